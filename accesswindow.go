@@ -0,0 +1,141 @@
+package mpdsub
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// An AccessWindow is a period of the day during which a user may use the
+// Server.
+type AccessWindow struct {
+	// Days optionally specifies the days of the week on which the window
+	// begins.  If Days is empty, the window begins on every day.
+	Days []time.Weekday
+
+	// Start and End specify the local times of day at which the window
+	// begins and ends, as offsets from midnight, such as 7 * time.Hour for
+	// 07:00.  If End is before Start, the window ends on the following
+	// day.  A window which spans the whole day begins at 0 and ends at
+	// 24 hours.
+	Start time.Duration
+	End   time.Duration
+}
+
+// validate verifies that an AccessWindow describes a valid period of the day.
+func (aw AccessWindow) validate() error {
+	if aw.Start < 0 || aw.Start >= 24*time.Hour {
+		return fmt.Errorf("access window start %v must be within a day", aw.Start)
+	}
+	if aw.End <= 0 || aw.End > 24*time.Hour {
+		return fmt.Errorf("access window end %v must be within a day", aw.End)
+	}
+	if aw.Start == aw.End {
+		return fmt.Errorf("access window start and end must differ: %v", aw.Start)
+	}
+
+	for _, d := range aw.Days {
+		if d < time.Sunday || d > time.Saturday {
+			return fmt.Errorf("invalid access window day: %d", d)
+		}
+	}
+
+	return nil
+}
+
+// validateAccessWindows verifies the AccessWindows of each user.
+func validateAccessWindows(windows map[string][]AccessWindow) error {
+	for user, ws := range windows {
+		if len(ws) == 0 {
+			return fmt.Errorf("no access windows for user %q", user)
+		}
+
+		for _, aw := range ws {
+			if err := aw.validate(); err != nil {
+				return fmt.Errorf("user %q: %v", user, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// contains reports whether t falls within the AccessWindow, using the wall
+// clock time of t.
+func (aw AccessWindow) contains(t time.Time) bool {
+	h, m, s := t.Clock()
+	tod := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+
+	day := t.Weekday()
+	if aw.Start < aw.End {
+		return aw.onDay(day) && tod >= aw.Start && tod < aw.End
+	}
+
+	// The window crosses midnight, so it may have begun on the previous day
+	yesterday := (day + 6) % 7
+	return (aw.onDay(day) && tod >= aw.Start) || (aw.onDay(yesterday) && tod < aw.End)
+}
+
+// onDay reports whether the AccessWindow begins on day d.
+func (aw AccessWindow) onDay(d time.Weekday) bool {
+	if len(aw.Days) == 0 {
+		return true
+	}
+
+	for _, wd := range aw.Days {
+		if wd == d {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String returns a description of the AccessWindow, such as
+// "Sat, Sun 09:00-22:00".
+func (aw AccessWindow) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+	times := clock(aw.Start) + "-" + clock(aw.End)
+
+	if len(aw.Days) == 0 {
+		return "daily " + times
+	}
+
+	days := make([]string, 0, len(aw.Days))
+	for _, d := range aw.Days {
+		days = append(days, d.String()[:3])
+	}
+
+	return strings.Join(days, ", ") + " " + times
+}
+
+// inAccessWindows reports whether t falls within any of the AccessWindows.
+func inAccessWindows(ws []AccessWindow, t time.Time) bool {
+	for _, aw := range ws {
+		if aw.contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// errAccessWindow indicates that user may not use the Server at this time,
+// and describes the times at which they may.
+func errAccessWindow(user string, ws []AccessWindow) func(c *container) {
+	descs := make([]string, 0, len(ws))
+	for _, aw := range ws {
+		descs = append(descs, aw.String())
+	}
+
+	return func(c *container) {
+		c.Status = statusFailed
+		c.Error = &subsonicError{
+			Code: codeNotAuthorized,
+			Message: fmt.Sprintf("User %q may only use the server during: %s.",
+				user, strings.Join(descs, "; ")),
+		}
+	}
+}
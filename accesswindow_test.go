@@ -0,0 +1,210 @@
+package mpdsub
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessWindow_contains(t *testing.T) {
+	// 2016-01-04 is a Monday
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2016, time.January, day, hour, min, 0, 0, time.UTC)
+	}
+
+	var (
+		weekdays = []time.Weekday{
+			time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday,
+		}
+
+		evenings = AccessWindow{
+			Days:  weekdays,
+			Start: 7 * time.Hour,
+			End:   21 * time.Hour,
+		}
+
+		overnight = AccessWindow{
+			Days:  []time.Weekday{time.Friday},
+			Start: 22 * time.Hour,
+			End:   2 * time.Hour,
+		}
+	)
+
+	tests := []struct {
+		name string
+		aw   AccessWindow
+		t    time.Time
+		ok   bool
+	}{
+		{
+			name: "within weekday window",
+			aw:   evenings,
+			t:    at(4, 20, 59),
+			ok:   true,
+		},
+		{
+			name: "at end of weekday window",
+			aw:   evenings,
+			t:    at(4, 21, 0),
+		},
+		{
+			name: "before weekday window",
+			aw:   evenings,
+			t:    at(4, 6, 30),
+		},
+		{
+			name: "weekend",
+			aw:   evenings,
+			t:    at(9, 12, 0),
+		},
+		{
+			name: "every day",
+			aw:   AccessWindow{Start: 0, End: 24 * time.Hour},
+			t:    at(10, 23, 59),
+			ok:   true,
+		},
+		{
+			name: "overnight before midnight",
+			aw:   overnight,
+			t:    at(8, 23, 0),
+			ok:   true,
+		},
+		{
+			name: "overnight after midnight",
+			aw:   overnight,
+			t:    at(9, 1, 0),
+			ok:   true,
+		},
+		{
+			name: "overnight after midnight on wrong day",
+			aw:   overnight,
+			t:    at(8, 1, 0),
+		},
+		{
+			name: "overnight after end",
+			aw:   overnight,
+			t:    at(9, 2, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if want, got := tt.ok, tt.aw.contains(tt.t); want != got {
+				t.Fatalf("unexpected contains result:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func Test_validateAccessWindows(t *testing.T) {
+	tests := []struct {
+		name string
+		ws   []AccessWindow
+		err  bool
+	}{
+		{
+			name: "no windows",
+			err:  true,
+		},
+		{
+			name: "negative start",
+			ws:   []AccessWindow{{Start: -time.Hour, End: time.Hour}},
+			err:  true,
+		},
+		{
+			name: "end beyond day",
+			ws:   []AccessWindow{{Start: 0, End: 25 * time.Hour}},
+			err:  true,
+		},
+		{
+			name: "empty window",
+			ws:   []AccessWindow{{Start: time.Hour, End: time.Hour}},
+			err:  true,
+		},
+		{
+			name: "invalid day",
+			ws:   []AccessWindow{{Days: []time.Weekday{7}, Start: 0, End: time.Hour}},
+			err:  true,
+		},
+		{
+			name: "OK",
+			ws: []AccessWindow{{
+				Days:  []time.Weekday{time.Saturday},
+				Start: 9 * time.Hour,
+				End:   24 * time.Hour,
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAccessWindows(map[string][]AccessWindow{"test": tt.ws})
+
+			if want, got := tt.err, err != nil; want != got {
+				t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestServer_accessWindows(t *testing.T) {
+	// A window on a day which is not today is never open
+	closed := AccessWindow{
+		Days:  []time.Weekday{(time.Now().Weekday() + 3) % 7},
+		Start: 0,
+		End:   24 * time.Hour,
+	}
+
+	tests := []struct {
+		name string
+		ws   []AccessWindow
+		ok   bool
+	}{
+		{
+			name: "unrestricted",
+			ok:   true,
+		},
+		{
+			name: "open",
+			ws:   []AccessWindow{{Start: 0, End: 24 * time.Hour}},
+			ok:   true,
+		},
+		{
+			name: "closed",
+			ws:   []AccessWindow{closed},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			if tt.ws != nil {
+				cfg.AccessWindows = map[string][]AccessWindow{
+					cfg.SubsonicUser: tt.ws,
+				}
+			}
+
+			withServer(t, nil, nil, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/ping.view", values))
+
+				if tt.ok {
+					if c.Error != nil {
+						t.Fatalf("unexpected error: %+v", c.Error)
+					}
+					return
+				}
+
+				if c.Error == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+				if want, got := codeNotAuthorized, c.Error.Code; want != got {
+					t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+				}
+				if want, got := closed.String(), c.Error.Message; !strings.Contains(got, want) {
+					t.Fatalf("error message does not describe window:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package mpdsub
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// coverArtArchiveURL is the URL of the Cover Art Archive, which serves
+// images of releases identified by their MusicBrainz IDs.
+const coverArtArchiveURL = "https://coverartarchive.org/release/"
+
+// getAlbumInfo is used in Subsonic to retrieve an album's notes and images,
+// organized by directory.
+func (s *Server) getAlbumInfo(w http.ResponseWriter, r *http.Request) {
+	info, ok := s.albumInfo(w, r)
+	if !ok {
+		return
+	}
+
+	writeXML(w, func(c *container) {
+		c.AlbumInfo = &albumInfo{albumDetails: info}
+	})
+}
+
+// getAlbumInfo2 is used in Subsonic to retrieve an album's notes and
+// images, organized by their tags.
+func (s *Server) getAlbumInfo2(w http.ResponseWriter, r *http.Request) {
+	info, ok := s.albumInfo(w, r)
+	if !ok {
+		return
+	}
+
+	writeXML(w, func(c *container) {
+		c.AlbumInfo2 = &albumInfo2{albumDetails: info}
+	})
+}
+
+// albumInfo retrieves the information about the album, or the album of the
+// song, identified by a request's id parameter.  Notes and images are
+// retrieved from Last.fm.  Albums which are tagged with a MusicBrainz
+// release ID, but which Last.fm has no images of, use images from the Cover
+// Art Archive.  If no provider has information about the album, empty
+// information is returned.  If the request is invalid, an error is written
+// to w and false is returned.
+func (s *Server) albumInfo(w http.ResponseWriter, r *http.Request) (albumDetails, bool) {
+	qID := r.URL.Query().Get("id")
+	if qID == "" {
+		writeXML(w, errMissingParameter)
+		return albumDetails{}, false
+	}
+
+	user := requestContextFrom(r).User
+
+	artist, album, a, ok, err := s.albumByID(user, qID)
+	switch {
+	case err != nil:
+		s.logf("error retrieving album from mpd for album info: %v", err)
+		writeXML(w, errMPD(err))
+		return albumDetails{}, false
+	case !ok:
+		writeXML(w, errNotFound)
+		return albumDetails{}, false
+	}
+
+	var info albumDetails
+	if s.lastFM != nil && artist != "" && album != "" {
+		al, err := s.lastFM.albumInfo(r.Context(), artist, album)
+		switch {
+		case err != nil:
+			s.logf("error retrieving album info for %q from last.fm: %v", album, err)
+		case al != nil:
+			info.Notes = strings.TrimSpace(al.Wiki.Summary)
+			info.MusicBrainzID = al.MBID
+			info.LastFMURL = al.URL
+			info.SmallImageURL = lastFMImageURL(al.Images, "small")
+			info.MediumImageURL = lastFMImageURL(al.Images, "medium")
+			info.LargeImageURL = lastFMImageURL(al.Images, "large")
+		}
+	}
+
+	// The release in the album's tags is more accurate than Last.fm's
+	// guess, and its images are available without an API key
+	if mbid := a["MUSICBRAINZ_ALBUMID"]; mbid != "" {
+		info.MusicBrainzID = mbid
+
+		if info.SmallImageURL == "" && info.MediumImageURL == "" && info.LargeImageURL == "" {
+			u := coverArtArchiveURL + mbid + "/front-"
+			info.SmallImageURL = u + "250"
+			info.MediumImageURL = u + "500"
+			info.LargeImageURL = u + "1200"
+		}
+	}
+
+	return info, true
+}
+
+// albumByID returns the artist and name of the album identified by qID,
+// which may be the ID of an album or of a song, and the attributes of a song
+// in the album.
+func (s *Server) albumByID(user string, qID string) (string, string, mpd.Attrs, bool, error) {
+	albums, err := s.albums(user, musicFolderAll)
+	if err != nil {
+		return "", "", nil, false, err
+	}
+
+	for _, a := range id3Artists(albums) {
+		for _, al := range a.Albums {
+			if !s.albumHasID(al, qID) {
+				continue
+			}
+
+			songs, err := s.db.ListAllInfo(al.Dir)
+			if err != nil {
+				return "", "", nil, false, err
+			}
+
+			for _, song := range songs {
+				if song["file"] != "" {
+					return a.Name, al.Name, song, true, nil
+				}
+			}
+
+			return a.Name, al.Name, nil, true, nil
+		}
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		return "", "", nil, false, err
+	}
+
+	f, ok := s.lookupID(indexFiles(fs), qID)
+	if !ok || f.Dir || !s.visible(user, f.Name) {
+		return "", "", nil, false, nil
+	}
+
+	a, err := s.songAttrs(f.Name)
+	if err != nil {
+		return "", "", nil, false, err
+	}
+
+	return firstTag(a, "AlbumArtist", "Artist"), a["Album"], a, true, nil
+}
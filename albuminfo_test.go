@@ -0,0 +1,110 @@
+package mpdsub
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_getAlbumInfo(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Beatles/Help/help.mp3",
+			"Stones/Sticky/brown.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "Beatles/Help/help.mp3", "Artist": "The Beatles", "Album": "Help!", "Title": "Help!"},
+			{"file": "Stones/Sticky/brown.mp3", "Artist": "The Rolling Stones", "Album": "Sticky Fingers", "Title": "Brown Sugar", "MUSICBRAINZ_ALBUMID": "8f7ca6b4"},
+		},
+	}
+
+	lastFM := func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("method") != "album.getInfo" || q.Get("artist") != "The Beatles" || q.Get("album") != "Help!" {
+			fmt.Fprint(w, `{"error":6,"message":"Album not found"}`)
+			return
+		}
+
+		fmt.Fprint(w, `{"album":{"name":"Help!","mbid":"25b4c2c4","url":"https://www.last.fm/music/The+Beatles/Help!",
+			"image":[{"#text":"https://img/m.png","size":"medium"}],
+			"wiki":{"summary":"Help! is the fifth studio album by the Beatles."}}}`)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		id   string
+		info albumDetails
+		err  int
+	}{
+		{
+			name: "album",
+			path: "getAlbumInfo2",
+			id:   "1",
+			info: albumDetails{
+				Notes:          "Help! is the fifth studio album by the Beatles.",
+				MusicBrainzID:  "25b4c2c4",
+				LastFMURL:      "https://www.last.fm/music/The+Beatles/Help!",
+				MediumImageURL: "https://img/m.png",
+			},
+		},
+		{
+			name: "song tagged with release",
+			path: "getAlbumInfo",
+			id:   "5",
+			info: albumDetails{
+				MusicBrainzID:  "8f7ca6b4",
+				SmallImageURL:  "https://coverartarchive.org/release/8f7ca6b4/front-250",
+				MediumImageURL: "https://coverartarchive.org/release/8f7ca6b4/front-500",
+				LargeImageURL:  "https://coverartarchive.org/release/8f7ca6b4/front-1200",
+			},
+		},
+		{
+			name: "artist",
+			path: "getAlbumInfo2",
+			id:   "0",
+			err:  70,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.LastFM = &LastFM{APIKey: "key"}
+			values.Set("id", tt.id)
+
+			withLastFM(t, db, cfg, lastFM, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/"+tt.path+".view", values))
+				if tt.err != 0 {
+					if c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+					if want, got := tt.err, c.Error.Code; want != got {
+						t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+					}
+					return
+				}
+				if c.Error != nil {
+					t.Fatalf("unexpected error: %v", c.Error.Message)
+				}
+
+				var got albumDetails
+				switch {
+				case c.AlbumInfo != nil:
+					got = c.AlbumInfo.albumDetails
+				case c.AlbumInfo2 != nil:
+					got = c.AlbumInfo2.albumDetails
+				default:
+					t.Fatal("no album info in response")
+				}
+
+				if want := tt.info; !reflect.DeepEqual(want, got) {
+					t.Fatalf("unexpected album info:\n- want: %+v\n-  got: %+v", want, got)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,523 @@
+package mpdsub
+
+import (
+	"math/rand"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+	"golang.org/x/text/collate"
+)
+
+const (
+	// defaultAlbumListSize is the number of albums returned by getAlbumList
+	// when a client does not specify a size.
+	defaultAlbumListSize = 10
+
+	// maxAlbumListSize is the maximum number of albums returned by a single
+	// getAlbumList request.
+	maxAlbumListSize = 500
+)
+
+// Album list types supported by getAlbumList.
+const (
+	albumListRandom               = "random"
+	albumListNewest               = "newest"
+	albumListHighest              = "highest"
+	albumListFrequent             = "frequent"
+	albumListRecent               = "recent"
+	albumListStarred              = "starred"
+	albumListAlphabeticalByName   = "alphabeticalByName"
+	albumListAlphabeticalByArtist = "alphabeticalByArtist"
+	albumListByYear               = "byYear"
+	albumListByGenre              = "byGenre"
+)
+
+// An album is a directory of songs in MPD's music directory, summarized
+// using the tags of its songs.  Its ID and ArtistID follow the shared ID
+// scheme described in ids.go.
+type album struct {
+	ID       int
+	ArtistID int
+	Dir      string
+	Name     string
+	Artist   string
+	Genre    string
+	Year     int
+	Songs    int
+	Duration int
+
+	// Created is the time the most recently modified song in the album was
+	// modified.
+	Created time.Time
+
+	// Plays are a user's play statistics for the songs in the album.
+	Plays playStats
+
+	// Starred is the time the album was starred by a user, if it was.
+	Starred time.Time
+
+	// Tagged reports whether the album's name comes from an album tag,
+	// rather than from its directory.
+	Tagged bool
+
+	// Parts are other directories of songs with the same album and artist
+	// tags, such as the discs of a multi-disc album, which were merged
+	// into the album by id3Artists.
+	Parts []album
+}
+
+// albums builds the albums in a music folder which are visible to user, with
+// user's play statistics and stars, ordered by their directory names.
+func (s *Server) albums(user string, folder int) ([]album, error) {
+	fs, err := s.db.List("file")
+	if err != nil {
+		return nil, err
+	}
+	ids := fileIDs(indexFiles(fs))
+
+	songs, err := s.db.ListAllInfo("")
+	if err != nil {
+		return nil, err
+	}
+
+	stats := s.playStats(user)
+	stars := s.stars(user)
+
+	byDir := make(map[string]*album)
+	var dirs []string
+	for _, a := range songs {
+		name := a["file"]
+		if name == "" || !s.visible(user, name) || !s.inMusicFolder(name, folder) {
+			continue
+		}
+		if s.filteredGenre(user, a["Genre"]) {
+			continue
+		}
+
+		// Songs at the root of the music directory are not in an album
+		dir := albumDir(name)
+		if dir == "" {
+			continue
+		}
+
+		al, ok := byDir[dir]
+		if !ok {
+			al = &album{
+				ID:       ids[dir],
+				ArtistID: ids[artistDir(dir)],
+				Dir:      dir,
+				Name:     path.Base(dir),
+				Starred:  stars[s.itemKey(dir)],
+			}
+			byDir[dir] = al
+			dirs = append(dirs, dir)
+		}
+
+		al.add(a)
+		al.Plays.add(stats[s.itemKey(name)])
+	}
+
+	sort.Strings(dirs)
+
+	out := make([]album, 0, len(dirs))
+	for _, d := range dirs {
+		out = append(out, *byDir[d])
+	}
+
+	return out, nil
+}
+
+// add adds the tags of a song to an album.  Empty album tags are filled in
+// using the first song which has them.
+func (al *album) add(a mpd.Attrs) {
+	if n := a["Album"]; n != "" && !al.Tagged {
+		al.Name = n
+		al.Tagged = true
+	}
+	if al.Artist == "" {
+		al.Artist = a["AlbumArtist"]
+	}
+	if al.Artist == "" {
+		al.Artist = a["Artist"]
+	}
+	if al.Genre == "" {
+		al.Genre = a["Genre"]
+	}
+	if al.Year == 0 {
+		al.Year = leadingInt(a["Date"])
+	}
+
+	if t := lastModified(a); t.After(al.Created) {
+		al.Created = t
+	}
+
+	al.Songs++
+	al.Duration += songDuration(a)
+}
+
+// merge merges another directory album with the same tags into an album.
+func (al *album) merge(o album) {
+	al.Parts = append(al.Parts, o)
+
+	if al.Genre == "" {
+		al.Genre = o.Genre
+	}
+	if al.Year == 0 {
+		al.Year = o.Year
+	}
+	if o.Created.After(al.Created) {
+		al.Created = o.Created
+	}
+
+	al.Songs += o.Songs
+	al.Duration += o.Duration
+	al.Plays.add(o.Plays)
+}
+
+// An albumListQuery specifies the type, filters, and page of an album list.
+type albumListQuery struct {
+	Type     string
+	Size     int
+	Offset   int
+	FromYear int
+	ToYear   int
+	Genre    string
+	Folder   int
+}
+
+// parseAlbumListQuery parses the parameters of a getAlbumList or
+// getAlbumList2 request.  If a parameter is missing, errMissingParameter is
+// returned.  If a parameter is invalid, errGeneric is returned.
+func parseAlbumListQuery(r *http.Request) (albumListQuery, func(c *container)) {
+	q := r.URL.Query()
+
+	aq := albumListQuery{
+		Type:   q.Get("type"),
+		Size:   defaultAlbumListSize,
+		Genre:  q.Get("genre"),
+		Folder: musicFolderAll,
+	}
+
+	switch aq.Type {
+	case "":
+		return aq, errMissingParameter
+	case albumListByYear:
+		if q.Get("fromYear") == "" || q.Get("toYear") == "" {
+			return aq, errMissingParameter
+		}
+	case albumListByGenre:
+		if aq.Genre == "" {
+			return aq, errMissingParameter
+		}
+	case albumListRandom, albumListNewest, albumListHighest, albumListFrequent,
+		albumListRecent, albumListStarred, albumListAlphabeticalByName,
+		albumListAlphabeticalByArtist:
+	default:
+		return aq, errGeneric
+	}
+
+	ints := []struct {
+		name string
+		v    *int
+	}{
+		{name: "size", v: &aq.Size},
+		{name: "offset", v: &aq.Offset},
+		{name: "fromYear", v: &aq.FromYear},
+		{name: "toYear", v: &aq.ToYear},
+		{name: "musicFolderId", v: &aq.Folder},
+	}
+
+	for _, i := range ints {
+		qv := q.Get(i.name)
+		if qv == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(qv)
+		if err != nil {
+			return aq, errGeneric
+		}
+		*i.v = n
+	}
+
+	if aq.Size < 0 || aq.Offset < 0 {
+		return aq, errGeneric
+	}
+	if aq.Size > maxAlbumListSize {
+		aq.Size = maxAlbumListSize
+	}
+
+	return aq, nil
+}
+
+// getAlbumList is used in Subsonic to retrieve a list of random, newest,
+// recently played, or alphabetically sorted albums, organized by directory.
+func (s *Server) getAlbumList(w http.ResponseWriter, r *http.Request) {
+	albums, _, ok := s.albumListPage(w, r)
+	if !ok {
+		return
+	}
+
+	out := make([]albumChild, 0, len(albums))
+	for _, al := range albums {
+		out = append(out, albumChild{child: s.albumChild(al)})
+	}
+
+	writeXML(w, func(c *container) {
+		c.AlbumList = &albumListContainer{
+			Albums: out,
+		}
+	})
+}
+
+// getAlbumList2 is used in Subsonic to retrieve the same lists of albums as
+// getAlbumList, organized by their tags.
+func (s *Server) getAlbumList2(w http.ResponseWriter, r *http.Request) {
+	albums, artistIDs, ok := s.albumListPage(w, r)
+	if !ok {
+		return
+	}
+
+	out := make([]albumID3, 0, len(albums))
+	for _, al := range albums {
+		out = append(out, s.albumID3(al, artistIDs[al.ID]))
+	}
+
+	writeXML(w, func(c *container) {
+		c.AlbumList2 = &albumList2Container{
+			Albums: out,
+		}
+	})
+}
+
+// albumListPage retrieves the page of albums requested by a getAlbumList or
+// getAlbumList2 request, and a map of album IDs to the IDs of their ID3
+// artists.  If the albums cannot be retrieved, an error is written to w and
+// false is returned.
+func (s *Server) albumListPage(w http.ResponseWriter, r *http.Request) ([]album, map[int]int, bool) {
+	aq, errFn := parseAlbumListQuery(r)
+	if errFn != nil {
+		writeXML(w, errFn)
+		return nil, nil, false
+	}
+
+	user := requestContextFrom(r).User
+
+	albums, err := s.albums(user, aq.Folder)
+	if err != nil {
+		s.logf("error retrieving albums from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return nil, nil, false
+	}
+
+	// Artist IDs depend on every album, so assign them before filtering
+	artistIDs := id3ArtistIDs(id3Artists(albums))
+
+	albums = s.listAlbums(user, albums, aq)
+
+	// Page through the list
+	if aq.Offset > len(albums) {
+		aq.Offset = len(albums)
+	}
+	albums = albums[aq.Offset:]
+	if len(albums) > aq.Size {
+		albums = albums[:aq.Size]
+	}
+
+	return albums, artistIDs, true
+}
+
+// listAlbums filters and orders albums according to the type of an album
+// list.
+func (s *Server) listAlbums(user string, albums []album, aq albumListQuery) []album {
+	switch aq.Type {
+	case albumListRandom:
+		for i := range albums {
+			j := i + rand.Intn(len(albums)-i)
+			albums[i], albums[j] = albums[j], albums[i]
+		}
+	case albumListNewest:
+		sort.Stable(byAlbumCreatedDesc(albums))
+	case albumListFrequent, albumListRecent:
+		albums = playedAlbums(albums)
+		if aq.Type == albumListFrequent {
+			sort.Stable(byAlbumPlaysDesc(albums))
+		} else {
+			sort.Stable(byAlbumPlayedDesc(albums))
+		}
+	case albumListAlphabeticalByName, albumListAlphabeticalByArtist:
+		sort.Stable(&byAlbumName{
+			albums: albums,
+			key:    s.albumSortKey(aq.Type),
+			c:      s.collator(user),
+		})
+	case albumListByYear:
+		albums = albumsByYear(albums, aq.FromYear, aq.ToYear)
+	case albumListByGenre:
+		var filtered []album
+		for _, al := range albums {
+			if s.hasGenre(al.Genre, aq.Genre) {
+				filtered = append(filtered, al)
+			}
+		}
+		albums = filtered
+	case albumListStarred:
+		albums = starredAlbums(albums)
+		sort.Stable(byAlbumStarredDesc(albums))
+	case albumListHighest:
+		// Album ratings are not yet supported
+		albums = nil
+	}
+
+	return albums
+}
+
+// albumSortKey returns a function which computes the key used to sort albums
+// for an alphabetical album list type.
+func (s *Server) albumSortKey(typ string) func(al album) string {
+	if typ == albumListAlphabeticalByArtist {
+		return func(al album) string {
+			return s.sortName(al.Artist) + "\x00" + s.sortName(al.Name)
+		}
+	}
+
+	return func(al album) string {
+		return s.sortName(al.Name)
+	}
+}
+
+// playedAlbums returns the albums which contain songs played by their
+// user.
+func playedAlbums(albums []album) []album {
+	var played []album
+	for _, al := range albums {
+		if al.Plays.Count > 0 {
+			played = append(played, al)
+		}
+	}
+
+	return played
+}
+
+// starredAlbums returns the albums which were starred by their user.
+func starredAlbums(albums []album) []album {
+	var starred []album
+	for _, al := range albums {
+		if !al.Starred.IsZero() {
+			starred = append(starred, al)
+		}
+	}
+
+	return starred
+}
+
+// albumsByYear returns the albums released between two years, inclusive.
+// As in Subsonic, albums are sorted in descending order if from is greater
+// than to.
+func albumsByYear(albums []album, from, to int) []album {
+	lo, hi := from, to
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+
+	var out []album
+	for _, al := range albums {
+		if al.Year >= lo && al.Year <= hi {
+			out = append(out, al)
+		}
+	}
+
+	if from > to {
+		sort.Stable(sort.Reverse(byAlbumYear(out)))
+	} else {
+		sort.Stable(byAlbumYear(out))
+	}
+
+	return out
+}
+
+// albumChild creates a Subsonic child from an album.
+func (s *Server) albumChild(al album) child {
+	c := child{
+		ID:       s.formatID(al.ID),
+		Parent:   s.formatID(al.ArtistID),
+		Album:    al.Name,
+		Artist:   al.Artist,
+		CoverArt: s.formatID(al.ID),
+		Genre:    s.genres.Primary(al.Genre),
+		IsDir:    true,
+		Title:    al.Name,
+		Duration: al.Duration,
+		Year:     al.Year,
+
+		PlayCount: al.Plays.Count,
+
+		Played:        al.Plays.played(),
+		SortName:      s.sortName(al.Name),
+		DisplayArtist: displayArtist(al.Artist),
+	}
+	c.Created = formatTime(al.Created)
+	c.Starred = formatTime(al.Starred)
+
+	return c
+}
+
+// byAlbumCreatedDesc sorts albums by their creation times, newest first.
+type byAlbumCreatedDesc []album
+
+func (b byAlbumCreatedDesc) Len() int           { return len(b) }
+func (b byAlbumCreatedDesc) Less(i, j int) bool { return b[i].Created.After(b[j].Created) }
+func (b byAlbumCreatedDesc) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// byAlbumPlaysDesc sorts albums by their play counts, most played first.
+type byAlbumPlaysDesc []album
+
+func (b byAlbumPlaysDesc) Len() int           { return len(b) }
+func (b byAlbumPlaysDesc) Less(i, j int) bool { return b[i].Plays.Count > b[j].Plays.Count }
+func (b byAlbumPlaysDesc) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// byAlbumPlayedDesc sorts albums by the time they were last played, newest
+// first.
+type byAlbumPlayedDesc []album
+
+func (b byAlbumPlayedDesc) Len() int           { return len(b) }
+func (b byAlbumPlayedDesc) Less(i, j int) bool { return b[i].Plays.Last.After(b[j].Plays.Last) }
+func (b byAlbumPlayedDesc) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// byAlbumStarredDesc sorts albums by the times they were starred, most
+// recently starred first.
+type byAlbumStarredDesc []album
+
+func (b byAlbumStarredDesc) Len() int           { return len(b) }
+func (b byAlbumStarredDesc) Less(i, j int) bool { return b[i].Starred.After(b[j].Starred) }
+func (b byAlbumStarredDesc) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// byAlbumYear sorts albums by their release years.
+type byAlbumYear []album
+
+func (b byAlbumYear) Len() int           { return len(b) }
+func (b byAlbumYear) Less(i, j int) bool { return b[i].Year < b[j].Year }
+func (b byAlbumYear) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// byAlbumName sorts albums by a key, using a collator if one is set.
+type byAlbumName struct {
+	albums []album
+	key    func(al album) string
+	c      *collate.Collator
+}
+
+func (b *byAlbumName) Len() int { return len(b.albums) }
+func (b *byAlbumName) Less(i, j int) bool {
+	ki, kj := b.key(b.albums[i]), b.key(b.albums[j])
+	if b.c != nil {
+		return b.c.CompareString(ki, kj) < 0
+	}
+
+	return strings.ToLower(ki) < strings.ToLower(kj)
+}
+func (b *byAlbumName) Swap(i, j int) { b.albums[i], b.albums[j] = b.albums[j], b.albums[i] }
@@ -0,0 +1,304 @@
+package mpdsub
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_getAlbumList(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Abba/Gold/1.mp3",
+			"Abba/Gold/2.mp3",
+			"The Beatles/Help/1.mp3",
+			"Zappa/Apostrophe/1.mp3",
+		},
+		songs: []mpd.Attrs{
+			{
+				"file":          "Abba/Gold/1.mp3",
+				"Album":         "Gold",
+				"Artist":        "Abba",
+				"Date":          "1992",
+				"Genre":         "Pop",
+				"Last-Modified": "2016-01-01T00:00:00Z",
+				"duration":      "100.5",
+			},
+			{
+				"file":          "Abba/Gold/2.mp3",
+				"Album":         "Gold",
+				"Artist":        "Abba",
+				"Date":          "1992",
+				"Genre":         "Pop",
+				"Last-Modified": "2016-01-02T00:00:00Z",
+				"duration":      "200",
+			},
+			{
+				"file":          "The Beatles/Help/1.mp3",
+				"Album":         "Help!",
+				"Artist":        "The Beatles",
+				"Date":          "1965",
+				"Genre":         "Rock",
+				"Last-Modified": "2017-01-01T00:00:00Z",
+			},
+			{
+				"file":          "Zappa/Apostrophe/1.mp3",
+				"Album":         "Apostrophe (')",
+				"Artist":        "Frank Zappa",
+				"Date":          "1974",
+				"Genre":         "Rock",
+				"Last-Modified": "2015-01-01T00:00:00Z",
+			},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		params map[string]string
+		err    bool
+		code   int
+		ids    []string
+	}{
+		{
+			name: "missing type",
+			err:  true,
+			code: codeMissingParameter,
+		},
+		{
+			name:   "unknown type",
+			params: map[string]string{"type": "foo"},
+			err:    true,
+			code:   codeGeneric,
+		},
+		{
+			name:   "newest",
+			params: map[string]string{"type": "newest"},
+			ids:    []string{"5", "1", "8"},
+		},
+		{
+			name:   "alphabetical by name",
+			params: map[string]string{"type": "alphabeticalByName"},
+			ids:    []string{"8", "1", "5"},
+		},
+		{
+			name:   "alphabetical by artist",
+			params: map[string]string{"type": "alphabeticalByArtist"},
+			ids:    []string{"1", "5", "8"},
+		},
+		{
+			name:   "size and offset",
+			params: map[string]string{"type": "alphabeticalByName", "size": "1", "offset": "1"},
+			ids:    []string{"1"},
+		},
+		{
+			name:   "offset past end",
+			params: map[string]string{"type": "alphabeticalByName", "offset": "10"},
+		},
+		{
+			name:   "by year missing range",
+			params: map[string]string{"type": "byYear", "fromYear": "1960"},
+			err:    true,
+			code:   codeMissingParameter,
+		},
+		{
+			name:   "by year",
+			params: map[string]string{"type": "byYear", "fromYear": "1960", "toYear": "1980"},
+			ids:    []string{"5", "8"},
+		},
+		{
+			name:   "by year descending",
+			params: map[string]string{"type": "byYear", "fromYear": "1980", "toYear": "1960"},
+			ids:    []string{"8", "5"},
+		},
+		{
+			name:   "by genre",
+			params: map[string]string{"type": "byGenre", "genre": "rock"},
+			ids:    []string{"5", "8"},
+		},
+		{
+			name:   "frequent without history",
+			params: map[string]string{"type": "frequent"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			for k, v := range tt.params {
+				values.Set(k, v)
+			}
+
+			withServer(t, db, nil, cfg, func(base string) {
+				res := testRequest(t, base, http.MethodGet, "/rest/getAlbumList.view", values)
+				c := mustDecodeXML(t, res)
+
+				if tt.err {
+					if c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+					if want, got := tt.code, c.Error.Code; want != got {
+						t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+					}
+					return
+				}
+
+				if c.AlbumList == nil {
+					t.Fatal("no album list in response")
+				}
+
+				var ids []string
+				for _, a := range c.AlbumList.Albums {
+					if !a.IsDir {
+						t.Fatalf("album %q is not a directory", a.ID)
+					}
+					ids = append(ids, a.ID)
+				}
+
+				if want, got := tt.ids, ids; !reflect.DeepEqual(want, got) {
+					t.Fatalf("unexpected album IDs:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		})
+	}
+}
+
+func TestServer_albums(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Abba/Gold/1.mp3",
+			"Abba/Gold/2.mp3",
+			"root.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "Abba/Gold/1.mp3", "Artist": "Abba", "duration": "100.5"},
+			{"file": "Abba/Gold/2.mp3", "Album": "Gold", "AlbumArtist": "ABBA", "Date": "1992-09-21", "duration": "200"},
+			{"file": "root.mp3", "Album": "Single"},
+		},
+	}
+
+	s, err := newServer(db, nil, &Config{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer s.Close()
+
+	albums, err := s.albums("", musicFolderAll)
+	if err != nil {
+		t.Fatalf("failed to retrieve albums: %v", err)
+	}
+
+	want := []album{{
+		ID:       1,
+		Dir:      "Abba/Gold",
+		Name:     "Gold",
+		Artist:   "Abba",
+		Year:     1992,
+		Songs:    2,
+		Duration: 300,
+		Tagged:   true,
+	}}
+
+	if got := albums; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected albums:\n- want: %+v\n-  got: %+v", want, got)
+	}
+}
+
+func TestServer_getAlbumList2(t *testing.T) {
+	cfg, values := configAuth()
+	values.Set("type", "alphabeticalByName")
+
+	withServer(t, id3Database(), nil, cfg, func(base string) {
+		res := testRequest(t, base, http.MethodGet, "/rest/getAlbumList2.view", values)
+		c := mustDecodeXML(t, res)
+
+		if c.AlbumList2 == nil {
+			t.Fatal("no album list in response")
+		}
+
+		type summary struct {
+			ID, Name, ArtistID string
+			SongCount          int
+		}
+
+		var albums []summary
+		for _, a := range c.AlbumList2.Albums {
+			albums = append(albums, summary{
+				ID:        a.ID,
+				Name:      a.Name,
+				ArtistID:  a.ArtistID,
+				SongCount: a.SongCount,
+			})
+		}
+
+		want := []summary{
+			{ID: "1", Name: "Gold", ArtistID: "0", SongCount: 2},
+			{ID: "5", Name: "Hits", ArtistID: "4", SongCount: 1},
+			{ID: "7", Name: "More", ArtistID: "7", SongCount: 1},
+		}
+
+		if got := albums; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected albums:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
+
+func TestServer_listAlbumsStarred(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"A/One/1.mp3",
+			"B/Two/1.mp3",
+			"C/Three/1.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "A/One/1.mp3"},
+			{"file": "B/Two/1.mp3"},
+			{"file": "C/Three/1.mp3"},
+		},
+	}
+
+	s, err := newServer(db, nil, &Config{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer s.Close()
+
+	now := time.Now()
+	_ = s.store.Update(func(d *storeData) error {
+		d.Stars["test"] = map[string]time.Time{
+			s.itemKey("A/One"):   now.Add(-time.Hour),
+			s.itemKey("C/Three"): now,
+		}
+		return nil
+	})
+
+	albums, err := s.albums("test", musicFolderAll)
+	if err != nil {
+		t.Fatalf("failed to retrieve albums: %v", err)
+	}
+
+	// Most recently starred albums are listed first
+	var names []string
+	for _, al := range s.listAlbums("test", albums, albumListQuery{Type: albumListStarred}) {
+		names = append(names, al.Name)
+
+		if want, got := now.Add(-time.Hour).UTC().Format(time.RFC3339), s.albumChild(al).Starred; al.Name == "One" && want != got {
+			t.Fatalf("unexpected starred time:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+
+	if want, got := []string{"Three", "One"}, names; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected starred albums:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Stars belong to a single user
+	albums, err = s.albums("someone", musicFolderAll)
+	if err != nil {
+		t.Fatalf("failed to retrieve albums: %v", err)
+	}
+	if want, got := 0, len(s.listAlbums("someone", albums, albumListQuery{Type: albumListStarred})); want != got {
+		t.Fatalf("unexpected number of starred albums:\n- want: %v\n-  got: %v", want, got)
+	}
+}
@@ -0,0 +1,201 @@
+package mpdsub
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultSimilarArtists is the default maximum number of similar artists
+// returned by getArtistInfo.
+const defaultSimilarArtists = 20
+
+// getArtistInfo is used in Subsonic to retrieve an artist's biography,
+// images, and similar artists, organized by directory.
+func (s *Server) getArtistInfo(w http.ResponseWriter, r *http.Request) {
+	info, ok := s.artistInfo(w, r)
+	if !ok {
+		return
+	}
+
+	writeXML(w, func(c *container) {
+		c.ArtistInfo = &artistInfo{artistDetails: info}
+	})
+}
+
+// getArtistInfo2 is used in Subsonic to retrieve an artist's biography,
+// images, and similar artists, organized by their tags.
+func (s *Server) getArtistInfo2(w http.ResponseWriter, r *http.Request) {
+	info, ok := s.artistInfo(w, r)
+	if !ok {
+		return
+	}
+
+	writeXML(w, func(c *container) {
+		c.ArtistInfo2 = &artistInfo2{artistDetails: info}
+	})
+}
+
+// artistInfo retrieves the information about the artist of the artist,
+// album, or song identified by a request's id parameter from Last.fm.
+// Similar artists are limited to artists in MPD's database, unless the
+// includeNotPresent parameter is true.  If Last.fm is not configured or is
+// unavailable, empty information is returned, so clients show an empty
+// page rather than an error.  If the request is invalid, an error is
+// written to w and false is returned.
+func (s *Server) artistInfo(w http.ResponseWriter, r *http.Request) (artistDetails, bool) {
+	q := r.URL.Query()
+
+	qID := q.Get("id")
+	if qID == "" {
+		writeXML(w, errMissingParameter)
+		return artistDetails{}, false
+	}
+
+	count := defaultSimilarArtists
+	if c := q.Get("count"); c != "" {
+		var err error
+		if count, err = strconv.Atoi(c); err != nil || count < 0 {
+			writeXML(w, errGeneric)
+			return artistDetails{}, false
+		}
+	}
+
+	var includeNotPresent bool
+	if v := q.Get("includeNotPresent"); v != "" {
+		var err error
+		if includeNotPresent, err = strconv.ParseBool(v); err != nil {
+			writeXML(w, errGeneric)
+			return artistDetails{}, false
+		}
+	}
+
+	user := requestContextFrom(r).User
+
+	artists, err := s.localArtists(user)
+	if err != nil {
+		s.logf("error retrieving artists from mpd for artist info: %v", err)
+		writeXML(w, errMPD(err))
+		return artistDetails{}, false
+	}
+
+	name, ok, err := s.artistByID(user, artists, qID)
+	switch {
+	case err != nil:
+		s.logf("error retrieving artist from mpd for artist info: %v", err)
+		writeXML(w, errMPD(err))
+		return artistDetails{}, false
+	case !ok:
+		writeXML(w, errNotFound)
+		return artistDetails{}, false
+	}
+
+	var info artistDetails
+	if s.lastFM == nil {
+		return info, true
+	}
+
+	a, err := s.lastFM.artistInfo(r.Context(), name)
+	if err != nil {
+		s.logf("error retrieving artist info for %q from last.fm: %v", name, err)
+		return info, true
+	}
+	if a != nil {
+		info.Biography = strings.TrimSpace(a.Bio.Summary)
+		info.MusicBrainzID = a.MBID
+		info.LastFMURL = a.URL
+		info.SmallImageURL = lastFMImageURL(a.Images, "small")
+		info.MediumImageURL = lastFMImageURL(a.Images, "medium")
+		info.LargeImageURL = lastFMImageURL(a.Images, "large")
+	}
+
+	if count == 0 {
+		return info, true
+	}
+
+	// Ask for more artists than needed, since many will not be present
+	limit := count
+	if !includeNotPresent {
+		limit *= 5
+	}
+
+	similar, err := s.lastFM.similarArtists(r.Context(), name, limit)
+	if err != nil {
+		s.logf("error retrieving similar artists for %q from last.fm: %v", name, err)
+		return info, true
+	}
+
+	for _, n := range similar {
+		if len(info.SimilarArtists) == count {
+			break
+		}
+
+		a, ok := artists[strings.ToLower(n)]
+		if !ok {
+			if includeNotPresent {
+				info.SimilarArtists = append(info.SimilarArtists, similarArtist{Name: n})
+			}
+			continue
+		}
+
+		id := s.formatID(a.ID)
+		info.SimilarArtists = append(info.SimilarArtists, similarArtist{
+			ID:         id,
+			Name:       a.Name,
+			AlbumCount: len(a.Albums),
+			CoverArt:   id,
+		})
+	}
+
+	return info, true
+}
+
+// localArtists returns the artists in MPD's database which are visible to
+// user, keyed by their names in lower case so that they can be matched with
+// names from external providers.
+func (s *Server) localArtists(user string) (map[string]id3Artist, error) {
+	albums, err := s.albums(user, musicFolderAll)
+	if err != nil {
+		return nil, err
+	}
+
+	artists := make(map[string]id3Artist)
+	for _, a := range id3Artists(albums) {
+		artists[strings.ToLower(a.Name)] = a
+	}
+
+	return artists, nil
+}
+
+// artistByID returns the name of the artist identified by qID, which may be
+// the ID of an artist or album in artists, or the ID of a song.
+func (s *Server) artistByID(user string, artists map[string]id3Artist, qID string) (string, bool, error) {
+	for _, a := range artists {
+		if s.formatID(a.ID) == qID {
+			return a.Name, true, nil
+		}
+		for _, al := range a.Albums {
+			if s.formatID(al.ID) == qID {
+				return a.Name, true, nil
+			}
+		}
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		return "", false, err
+	}
+
+	f, ok := s.lookupID(indexFiles(fs), qID)
+	if !ok || f.Dir || !s.visible(user, f.Name) {
+		return "", false, nil
+	}
+
+	a, err := s.songAttrs(f.Name)
+	if err != nil {
+		return "", false, err
+	}
+
+	name := firstTag(a, "Artist", "AlbumArtist")
+	return name, name != "", nil
+}
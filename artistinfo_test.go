@@ -0,0 +1,182 @@
+package mpdsub
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_getArtistInfo(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Beatles/Help/help.mp3",
+			"Stones/Sticky/brown.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "Beatles/Help/help.mp3", "Artist": "The Beatles", "Album": "Help!", "Title": "Help!"},
+			{"file": "Stones/Sticky/brown.mp3", "Artist": "The Rolling Stones", "Album": "Sticky Fingers", "Title": "Brown Sugar"},
+		},
+	}
+
+	lastFM := func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("artist") != "The Beatles" {
+			fmt.Fprint(w, `{"error":6,"message":"The artist you supplied could not be found"}`)
+			return
+		}
+
+		switch q.Get("method") {
+		case "artist.getInfo":
+			fmt.Fprint(w, `{"artist":{"name":"The Beatles","mbid":"b10bbbfc","url":"https://www.last.fm/music/The+Beatles",
+				"image":[{"#text":"https://img/s.png","size":"small"},{"#text":"https://img/l.png","size":"large"}],
+				"bio":{"summary":" The Beatles were an English rock band. "}}}`)
+		case "artist.getSimilar":
+			fmt.Fprint(w, `{"similarartists":{"artist":[{"name":"John Lennon"},{"name":"the rolling stones"}]}}`)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		id      string
+		params  url.Values
+		info    artistDetails
+		similar []string
+		err     int
+	}{
+		{
+			name: "artist",
+			path: "getArtistInfo2",
+			id:   "0",
+			info: artistDetails{
+				Biography:     "The Beatles were an English rock band.",
+				MusicBrainzID: "b10bbbfc",
+				LastFMURL:     "https://www.last.fm/music/The+Beatles",
+				SmallImageURL: "https://img/s.png",
+				LargeImageURL: "https://img/l.png",
+				SimilarArtists: []similarArtist{{
+					ID:         "3",
+					Name:       "The Rolling Stones",
+					AlbumCount: 1,
+					CoverArt:   "3",
+				}},
+			},
+		},
+		{
+			name:   "song, include not present",
+			path:   "getArtistInfo",
+			id:     "2",
+			params: url.Values{"includeNotPresent": {"true"}, "count": {"1"}},
+			info: artistDetails{
+				Biography:      "The Beatles were an English rock band.",
+				MusicBrainzID:  "b10bbbfc",
+				LastFMURL:      "https://www.last.fm/music/The+Beatles",
+				SmallImageURL:  "https://img/s.png",
+				LargeImageURL:  "https://img/l.png",
+				SimilarArtists: []similarArtist{{Name: "John Lennon"}},
+			},
+		},
+		{
+			name: "unknown to last.fm",
+			path: "getArtistInfo2",
+			id:   "3",
+		},
+		{
+			name: "not found",
+			path: "getArtistInfo2",
+			id:   "99",
+			err:  70,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.LastFM = &LastFM{APIKey: "key"}
+			values.Set("id", tt.id)
+			for k, v := range tt.params {
+				values[k] = v
+			}
+
+			withLastFM(t, db, cfg, lastFM, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/"+tt.path+".view", values))
+				if tt.err != 0 {
+					if c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+					if want, got := tt.err, c.Error.Code; want != got {
+						t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+					}
+					return
+				}
+				if c.Error != nil {
+					t.Fatalf("unexpected error: %v", c.Error.Message)
+				}
+
+				var got artistDetails
+				switch {
+				case c.ArtistInfo != nil:
+					got = c.ArtistInfo.artistDetails
+				case c.ArtistInfo2 != nil:
+					got = c.ArtistInfo2.artistDetails
+				default:
+					t.Fatal("no artist info in response")
+				}
+
+				if want := tt.info; !reflect.DeepEqual(want, got) {
+					t.Fatalf("unexpected artist info:\n- want: %+v\n-  got: %+v", want, got)
+				}
+			})
+		})
+	}
+}
+
+func TestServer_getArtistInfoNoLastFM(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{"Beatles/Help/help.mp3"},
+		songs: []mpd.Attrs{
+			{"file": "Beatles/Help/help.mp3", "Artist": "The Beatles", "Album": "Help!"},
+		},
+	}
+
+	cfg, values := configAuth()
+	values.Set("id", "0")
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getArtistInfo2.view", values))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %v", c.Error.Message)
+		}
+
+		if want, got := (artistDetails{}), c.ArtistInfo2.artistDetails; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected artist info:\n- want: %+v\n-  got: %+v", want, got)
+		}
+	})
+}
+
+// withLastFM is like withServer, but sends requests to the Last.fm API to
+// a server which uses fn to respond.
+func withLastFM(t *testing.T, db database, cfg *Config, fn http.HandlerFunc, run func(base string)) {
+	lastFM := httptest.NewServer(fn)
+	defer lastFM.Close()
+
+	cfg.Logger = log.New(ioutil.Discard, "", 0)
+
+	srv, err := newServer(db, &memoryFilesystem{}, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	srv.lastFM.endpoint = lastFM.URL
+
+	s := httptest.NewServer(srv)
+	defer s.Close()
+
+	run(s.URL)
+}
@@ -0,0 +1,351 @@
+package mpdsub
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errNoArtwork is returned by an artworkSource when no artwork is available
+// for a file.
+var errNoArtwork = errors.New("no artwork available")
+
+// An artworkSource is a type which can retrieve cover art for a file or
+// directory in the MPD database.
+type artworkSource interface {
+	Artwork(name string, dir bool) ([]byte, error)
+}
+
+var _ artworkSource = &mpdArtwork{}
+
+// An mpdArtwork is an artworkSource which retrieves cover art using MPD's
+// readpicture and albumart commands.  Because MPD reads the artwork itself,
+// mpdArtwork works even when the music directory is not available locally.
+//
+// readpicture requires MPD 0.22 and albumart requires MPD 0.21.  Errors
+// returned by older servers which do not support these commands are treated
+// as if no artwork is available.
+//
+// MPD returns artwork in chunks, each requiring a round trip, so artwork
+// retrieved from MPD is kept in cache.
+type mpdArtwork struct {
+	db    database
+	cache *artworkCache
+}
+
+// Artwork implements artworkSource.  For directories, MPD is queried using
+// the first file within the directory.
+func (a *mpdArtwork) Artwork(name string, dir bool) ([]byte, error) {
+	if dir {
+		f, err := firstSong(a.db, name)
+		if err != nil {
+			return nil, err
+		}
+		name = f
+	}
+
+	if b, ok := a.cache.get(name); ok {
+		return b, nil
+	}
+
+	// Prefer pictures embedded in the file, and fall back to images
+	// stored alongside it
+	if b, err := a.db.ReadPicture(name); err == nil && len(b) > 0 {
+		a.cache.add(name, b)
+		return b, nil
+	}
+	if b, err := a.db.AlbumArt(name); err == nil && len(b) > 0 {
+		a.cache.add(name, b)
+		return b, nil
+	}
+
+	return nil, errNoArtwork
+}
+
+// artworkMaxAge is the amount of time for which clients may cache artwork
+// without revalidating it.  Artwork is private, as it is only served to
+// authenticated users.
+const artworkMaxAge = 24 * time.Hour
+
+// firstSong returns the name of the first song within a directory, whose
+// artwork represents the directory.  If the directory contains no songs,
+// errNoArtwork is returned.
+func firstSong(db database, dir string) (string, error) {
+	songs, err := db.ListAllInfo(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for _, s := range songs {
+		if f := s["file"]; f != "" {
+			return f, nil
+		}
+	}
+
+	return "", errNoArtwork
+}
+
+// defaultArtworkNames are the image files recognized as cover art when
+// Config.ArtworkNames is empty.
+var defaultArtworkNames = []string{"cover.*", "folder.*", "front.*", "album.*"}
+
+// artworkExtensions are the image extensions matched by an artwork name
+// with the extension ".*".
+var artworkExtensions = []string{".jpg", ".jpeg", ".png", ".webp", ".gif"}
+
+var _ artworkSource = &fileArtwork{}
+
+// A fileArtwork is an artworkSource which reads cover art from image files
+// stored alongside songs in the music directory.
+type fileArtwork struct {
+	fs     filesystem
+	root   string
+	names  []string
+	parent bool
+}
+
+// newFileArtwork creates a fileArtwork which reads image files from the
+// music directory using fs, expanding the configured artwork names.
+func newFileArtwork(fs filesystem, cfg *Config) *fileArtwork {
+	names := cfg.ArtworkNames
+	if len(names) == 0 {
+		names = defaultArtworkNames
+	}
+
+	var expanded []string
+	for _, n := range names {
+		if !strings.HasSuffix(n, ".*") {
+			expanded = append(expanded, n)
+			continue
+		}
+
+		for _, ext := range artworkExtensions {
+			expanded = append(expanded, strings.TrimSuffix(n, ".*")+ext)
+		}
+	}
+
+	return &fileArtwork{
+		fs:     fs,
+		root:   cfg.MusicDirectory,
+		names:  expanded,
+		parent: cfg.ArtworkParentDirectory,
+	}
+}
+
+// Artwork implements artworkSource.  Songs use the artwork in their
+// directory.
+func (a *fileArtwork) Artwork(name string, dir bool) ([]byte, error) {
+	if !dir {
+		name = path.Dir(name)
+	}
+
+	// Songs at the root of the music directory are not in an album
+	if name == "." {
+		return nil, errNoArtwork
+	}
+
+	dirs := []string{name}
+	if parent := path.Dir(name); a.parent && parent != "." {
+		dirs = append(dirs, parent)
+	}
+
+	for _, d := range dirs {
+		for _, n := range a.names {
+			b, err := a.read(path.Join(d, n))
+			switch {
+			case err == nil:
+				return b, nil
+			case os.IsNotExist(err):
+				continue
+			default:
+				return nil, err
+			}
+		}
+	}
+
+	return nil, errNoArtwork
+}
+
+// read reads an image file from the music directory.
+func (a *fileArtwork) read(name string) ([]byte, error) {
+	f, err := a.fs.Open(filepath.Join(a.root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, os.ErrNotExist
+	}
+
+	return ioutil.ReadAll(f)
+}
+
+// artwork tries each configured artworkSource in order, returning the first
+// artwork found for the file or directory.  Files and directories without
+// artwork are remembered for a time, and are not looked up again.
+func (s *Server) artwork(f indexedFile) ([]byte, error) {
+	const kind = "artwork"
+
+	if s.misses.missed(kind, f.Name, time.Now()) {
+		return nil, errNoArtwork
+	}
+
+	for _, src := range s.artworkSources {
+		b, err := src.Artwork(f.Name, f.Dir)
+		switch err {
+		case nil:
+			return b, nil
+		case errNoArtwork:
+			continue
+		default:
+			return nil, err
+		}
+	}
+
+	s.misses.add(kind, f.Name, time.Now())
+	return nil, errNoArtwork
+}
+
+// coverArt retrieves the artwork for a file or directory, scaled to size if
+// size is not 0, using the disk cache if it is configured.  Scaled artwork
+// is produced from the cached original, so prewarmed artwork need not be
+// extracted again for each size.  Cached artwork is keyed by the time its
+// file or directory was last modified, so it remains valid across updates
+// of MPD's database which do not change the item.
+func (s *Server) coverArt(f indexedFile, size int) ([]byte, error) {
+	var key string
+	if s.artDisk != nil {
+		mtimes, err := s.modTimes()
+		if err != nil {
+			return nil, err
+		}
+
+		key = artworkCacheKey(f.Name, mtimes[f.Name], size)
+	}
+
+	if b, ok := s.artDisk.get(key); ok {
+		if size == 0 {
+			s.artInfo.add(f.Name, b)
+		}
+		return b, nil
+	}
+
+	var (
+		b   []byte
+		err error
+	)
+	if size > 0 {
+		b, err = s.coverArt(f, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err = resizeArtwork(b, size)
+	} else {
+		b, err = s.artwork(f)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Failing to cache artwork does not prevent serving it
+	if err := s.artDisk.add(key, b); err != nil {
+		s.logf("error caching cover art for %q: %v", f.Name, err)
+	}
+	if size == 0 {
+		s.artInfo.add(f.Name, b)
+	}
+
+	return b, nil
+}
+
+// getCoverArt is used in Subsonic to retrieve cover art for a file or
+// directory.  Artwork is served with a strong ETag derived from its
+// contents, so clients may revalidate it or request byte ranges of it.
+//
+// If the optional size parameter is set, artwork is scaled down so that it
+// is no wider or taller than size pixels.  Items without artwork receive a
+// generated placeholder image.
+func (s *Server) getCoverArt(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	qID := q.Get("id")
+	if qID == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	var size int
+	if qSize := q.Get("size"); qSize != "" {
+		n, err := strconv.Atoi(qSize)
+		if err != nil || n < 1 {
+			writeXML(w, errGeneric)
+			return
+		}
+		size = n
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		s.logf("error listing files from mpd for getting cover art: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	// Directories and ID3 albums share IDs, so both views show the same
+	// artwork
+	f, ok := s.lookupID(indexFiles(fs), qID)
+	if !ok {
+		writeXML(w, errNotFound)
+		return
+	}
+
+	if !s.visible(requestContextFrom(r).User, f.Name) {
+		writeXML(w, errNotAuthorized)
+		return
+	}
+
+	// Clients may cache artwork, but must revalidate placeholders so that
+	// artwork added later is displayed
+	cacheControl := fmt.Sprintf("private, max-age=%d", int(artworkMaxAge.Seconds()))
+
+	b, err := s.coverArt(f, size)
+	switch err {
+	case nil:
+	case errNoArtwork:
+		b, err = placeholderArtwork(f, size)
+		if err != nil {
+			s.logf("error generating placeholder cover art for %q: %v", f.Name, err)
+			writeXML(w, errGeneric)
+			return
+		}
+		cacheControl = "private, no-cache"
+	default:
+		s.logf("error retrieving cover art for %q: %v", f.Name, err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	ct := http.DetectContentType(b)
+	if !strings.HasPrefix(ct, "image/") {
+		ct = "application/octet-stream"
+	}
+
+	w.Header().Set(contentType, ct)
+	w.Header().Set("ETag", artworkETag(b))
+	w.Header().Set("Cache-Control", cacheControl)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+}
@@ -0,0 +1,270 @@
+package mpdsub
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_getCoverArt(t *testing.T) {
+	var (
+		png  = []byte("\x89PNG\r\n\x1a\nembedded")
+		jpeg = []byte("\xff\xd8\xff\xe0folder")
+	)
+
+	db := &memoryDatabase{
+		files: []string{
+			"foo/foo.mp3",
+			"foo/bar.mp3",
+			"bar/bar.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "foo/foo.mp3"},
+			{"file": "foo/bar.mp3"},
+			{"file": "bar/bar.mp3"},
+		},
+		pictures: map[string][]byte{
+			"foo/bar.mp3": png,
+		},
+		albumArt: map[string][]byte{
+			"foo/foo.mp3": jpeg,
+			"foo/bar.mp3": jpeg,
+		},
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		ct   string
+		b    []byte
+		code int
+
+		placeholder bool
+	}{
+		{
+			name: "directory",
+			id:   "0",
+			ct:   "image/jpeg",
+			b:    jpeg,
+		},
+		{
+			name: "album art",
+			id:   "1",
+			ct:   "image/jpeg",
+			b:    jpeg,
+		},
+		{
+			name: "embedded picture",
+			id:   "2",
+			ct:   "image/png",
+			b:    png,
+		},
+		{
+			name:        "no artwork",
+			id:          "4",
+			ct:          "image/png",
+			placeholder: true,
+		},
+		{
+			name: "out of bounds",
+			id:   "10",
+			code: codeNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			values.Set("id", tt.id)
+
+			withServer(t, db, nil, cfg, func(base string) {
+				res := testRequest(t, base, http.MethodGet, "/rest/getCoverArt.view", values)
+
+				if tt.code != 0 {
+					c := mustDecodeXML(t, res)
+					if c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+
+					if want, got := tt.code, c.Error.Code; want != got {
+						t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v",
+							want, got)
+					}
+
+					return
+				}
+
+				if want, got := tt.ct, res.Header.Get(contentType); want != got {
+					t.Fatalf("unexpected Content-Type:\n- want: %v\n-  got: %v",
+						want, got)
+				}
+
+				cc := "private, max-age=86400"
+				if tt.placeholder {
+					cc = "private, no-cache"
+				}
+				if want, got := cc, res.Header.Get("Cache-Control"); want != got {
+					t.Fatalf("unexpected Cache-Control:\n- want: %v\n-  got: %v",
+						want, got)
+				}
+
+				b, err := ioutil.ReadAll(res.Body)
+				if err != nil {
+					t.Fatalf("failed to read body: %v", err)
+				}
+
+				if tt.placeholder {
+					if _, _, err := image.Decode(bytes.NewReader(b)); err != nil {
+						t.Fatalf("failed to decode placeholder: %v", err)
+					}
+					return
+				}
+
+				if want, got := tt.b, b; !bytes.Equal(want, got) {
+					t.Fatalf("unexpected body:\n- want: %q\n-  got: %q",
+						want, got)
+				}
+			})
+		})
+	}
+}
+
+func TestServer_getCoverArtFolderImage(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\nfolder")
+
+	db := &memoryDatabase{
+		files: []string{"foo/foo.mp3"},
+		songs: []mpd.Attrs{{"file": "foo/foo.mp3"}},
+		albumArt: map[string][]byte{
+			"foo/foo.mp3": []byte("\xff\xd8\xff\xe0mpd"),
+		},
+	}
+
+	fs := &memoryFilesystem{
+		files: map[string]*memoryFile{
+			filepath.Join("/music", "foo", "folder.png"): {ReadSeeker: bytes.NewReader(png)},
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.MusicDirectory = "/music"
+	values.Set("id", "1")
+
+	withServer(t, db, fs, cfg, func(base string) {
+		res := testRequest(t, base, http.MethodGet, "/rest/getCoverArt.view", values)
+
+		if want, got := "image/png", res.Header.Get(contentType); want != got {
+			t.Fatalf("unexpected Content-Type:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+		if want, got := artworkETag(png), res.Header.Get("ETag"); want != got {
+			t.Fatalf("unexpected ETag:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+
+		b, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+
+		if want, got := png, b; !bytes.Equal(want, got) {
+			t.Fatalf("unexpected body:\n- want: %q\n-  got: %q",
+				want, got)
+		}
+	})
+}
+
+func Test_fileArtwork(t *testing.T) {
+	var (
+		cover = []byte("cover")
+		front = []byte("front")
+		box   = []byte("box")
+	)
+
+	// newFS creates a filesystem with unread image files
+	newFS := func() filesystem {
+		return &memoryFilesystem{
+			files: map[string]*memoryFile{
+				filepath.Join("/music", "foo", "cover.png"): {ReadSeeker: bytes.NewReader(cover)},
+				filepath.Join("/music", "foo", "front.jpg"): {ReadSeeker: bytes.NewReader(front)},
+				filepath.Join("/music", "box", "Art.jpg"):   {ReadSeeker: bytes.NewReader(box)},
+			},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		cfg   *Config
+		file  string
+		dir   bool
+		b     []byte
+		noArt bool
+	}{
+		{
+			name: "directory",
+			cfg:  &Config{},
+			file: "foo",
+			dir:  true,
+			b:    cover,
+		},
+		{
+			name: "song",
+			cfg:  &Config{},
+			file: "foo/foo.mp3",
+			b:    cover,
+		},
+		{
+			name: "preferred name",
+			cfg:  &Config{ArtworkNames: []string{"front.*", "cover.*"}},
+			file: "foo/foo.mp3",
+			b:    front,
+		},
+		{
+			name:  "root",
+			cfg:   &Config{},
+			file:  "foo.mp3",
+			noArt: true,
+		},
+		{
+			name:  "parent not searched",
+			cfg:   &Config{ArtworkNames: []string{"Art.jpg"}},
+			file:  "box/Disc 1/foo.mp3",
+			noArt: true,
+		},
+		{
+			name: "parent",
+			cfg: &Config{
+				ArtworkNames:           []string{"Art.jpg"},
+				ArtworkParentDirectory: true,
+			},
+			file: "box/Disc 1/foo.mp3",
+			b:    box,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.MusicDirectory = "/music"
+
+			b, err := newFileArtwork(newFS(), tt.cfg).Artwork(tt.file, tt.dir)
+			if tt.noArt {
+				if want, got := errNoArtwork, err; want != got {
+					t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to retrieve artwork: %v", err)
+			}
+
+			if want, got := tt.b, b; !bytes.Equal(want, got) {
+				t.Fatalf("unexpected artwork:\n- want: %q\n-  got: %q", want, got)
+			}
+		})
+	}
+}
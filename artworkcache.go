@@ -0,0 +1,100 @@
+package mpdsub
+
+import (
+	"container/list"
+	"sync"
+)
+
+// defaultArtworkCacheSize is the maximum number of bytes of artwork kept in
+// memory by an artworkCache.
+const defaultArtworkCacheSize = 32 << 20
+
+// An artworkCache keeps recently retrieved artwork in memory, evicting the
+// least recently used artwork once its size exceeds a limit.  It avoids
+// retrieving artwork from MPD in chunks each time a client displays it.
+//
+// A nil *artworkCache caches nothing.
+type artworkCache struct {
+	max int
+
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// An artworkEntry is an item of artwork stored by an artworkCache.
+type artworkEntry struct {
+	key string
+	b   []byte
+}
+
+// newArtworkCache creates an artworkCache which holds at most max bytes of
+// artwork.
+func newArtworkCache(max int) *artworkCache {
+	return &artworkCache{
+		max:     max,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get retrieves artwork stored under key.
+func (c *artworkCache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(e)
+	return e.Value.(*artworkEntry).b, true
+}
+
+// add stores artwork under key, evicting the least recently used artwork
+// as needed.  Artwork larger than the cache is not stored.
+func (c *artworkCache) add(key string, b []byte) {
+	if c == nil || len(b) > c.max {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.size -= len(e.Value.(*artworkEntry).b)
+		c.order.Remove(e)
+	}
+
+	c.entries[key] = c.order.PushFront(&artworkEntry{key: key, b: b})
+	c.size += len(b)
+
+	for c.size > c.max {
+		e := c.order.Back()
+		ae := e.Value.(*artworkEntry)
+
+		c.order.Remove(e)
+		delete(c.entries, ae.key)
+		c.size -= len(ae.b)
+	}
+}
+
+// clear removes all artwork from the cache.
+func (c *artworkCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.size = 0
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
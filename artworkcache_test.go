@@ -0,0 +1,69 @@
+package mpdsub
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_artworkCache(t *testing.T) {
+	c := newArtworkCache(8)
+
+	c.add("foo", []byte("foo"))
+	c.add("bar", []byte("bar"))
+
+	// Using foo makes bar the least recently used
+	if _, ok := c.get("foo"); !ok {
+		t.Fatal("expected foo to be cached")
+	}
+
+	c.add("baz", []byte("baz"))
+
+	if _, ok := c.get("bar"); ok {
+		t.Fatal("expected bar to be evicted")
+	}
+	for _, k := range []string{"foo", "baz"} {
+		b, ok := c.get(k)
+		if !ok {
+			t.Fatalf("expected %s to be cached", k)
+		}
+		if want, got := []byte(k), b; !bytes.Equal(want, got) {
+			t.Fatalf("unexpected artwork:\n- want: %q\n-  got: %q", want, got)
+		}
+	}
+
+	c.add("large", []byte("too large"))
+	if _, ok := c.get("large"); ok {
+		t.Fatal("expected artwork larger than the cache not to be cached")
+	}
+
+	c.clear()
+	if _, ok := c.get("foo"); ok {
+		t.Fatal("expected cache to be empty after clear")
+	}
+}
+
+func Test_mpdArtworkCache(t *testing.T) {
+	jpeg := []byte("\xff\xd8\xff\xe0folder")
+
+	db := &memoryDatabase{
+		albumArt: map[string][]byte{
+			"foo/foo.mp3": jpeg,
+		},
+	}
+
+	a := &mpdArtwork{db: db, cache: newArtworkCache(defaultArtworkCacheSize)}
+	if _, err := a.Artwork("foo/foo.mp3", false); err != nil {
+		t.Fatalf("failed to retrieve artwork: %v", err)
+	}
+
+	// Cached artwork is served without querying MPD
+	delete(db.albumArt, "foo/foo.mp3")
+
+	b, err := a.Artwork("foo/foo.mp3", false)
+	if err != nil {
+		t.Fatalf("failed to retrieve cached artwork: %v", err)
+	}
+	if want, got := jpeg, b; !bytes.Equal(want, got) {
+		t.Fatalf("unexpected artwork:\n- want: %q\n-  got: %q", want, got)
+	}
+}
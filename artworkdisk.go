@@ -0,0 +1,227 @@
+package mpdsub
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultArtworkDiskCacheSize is the default maximum number of bytes of
+// artwork kept in ArtworkCacheDirectory.
+const defaultArtworkDiskCacheSize = 256 << 20
+
+// artworkCacheSuffix is the suffix of files written to an artwork disk cache,
+// so that other files in the directory are left alone.
+const artworkCacheSuffix = ".art"
+
+// A diskArtworkCache keeps artwork which was expensive to produce, such as
+// pictures extracted from songs and resized images, in files within a
+// directory.  Once the cache exceeds its maximum size, the least recently
+// used artwork is removed.  Because the cache is stored on disk, it is
+// retained when the Server restarts and when MPD's database is updated, and
+// artwork is keyed by the modification time of its file or directory so
+// that changed artwork is not served.  Files are read and written without
+// holding the cache's lock, so slow disks do not serialize requests.
+//
+// A nil *diskArtworkCache caches nothing.
+type diskArtworkCache struct {
+	dir string
+	max int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// A diskArtworkEntry is a file in a diskArtworkCache.
+type diskArtworkEntry struct {
+	name string
+	size int64
+}
+
+// openDiskArtworkCache opens the artwork cache in dir, creating dir if it
+// does not exist, and indexes any artwork cached earlier.  If dir is empty,
+// nil is returned.  If max is 0, defaultArtworkDiskCacheSize is used.
+func openDiskArtworkCache(dir string, max int64) (*diskArtworkCache, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if max == 0 {
+		max = defaultArtworkDiskCacheSize
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Artwork used most recently was touched most recently
+	sort.Sort(byModTimeDesc(fis))
+
+	c := &diskArtworkCache{
+		dir:     dir,
+		max:     max,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+
+	c.mu.Lock()
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), artworkCacheSuffix) {
+			continue
+		}
+
+		c.entries[fi.Name()] = c.lru.PushBack(&diskArtworkEntry{
+			name: fi.Name(),
+			size: fi.Size(),
+		})
+		c.size += fi.Size()
+	}
+	evicted := c.evict()
+	c.mu.Unlock()
+
+	c.removeFiles(evicted)
+
+	return c, nil
+}
+
+// artworkCacheKey returns the key which identifies artwork for the file or
+// directory with the specified name and modification time, scaled to size.
+// Names are used rather than IDs, as IDs change when MPD's database changes.
+func artworkCacheKey(name string, modified time.Time, size int) string {
+	return name + "\x00" + strconv.FormatInt(modified.UnixNano(), 10) + "\x00" + strconv.Itoa(size)
+}
+
+// get retrieves artwork stored under key, marking it as recently used.
+func (c *diskArtworkCache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	name := c.fileName(key)
+
+	c.mu.Lock()
+	e, ok := c.entries[name]
+	if ok {
+		c.lru.MoveToFront(e)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	p := filepath.Join(c.dir, name)
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		// The file is already gone or unreadable, so only the index
+		// needs updating
+		c.mu.Lock()
+		if c.entries[name] == e {
+			c.remove(e)
+		}
+		c.mu.Unlock()
+
+		return nil, false
+	}
+
+	// Record the use on disk as well, so the order survives a restart
+	now := time.Now()
+	_ = os.Chtimes(p, now, now)
+
+	return b, true
+}
+
+// add stores artwork under key, removing the least recently used artwork
+// until the cache is within its maximum size.  Artwork larger than the cache
+// is not stored.
+func (c *diskArtworkCache) add(key string, b []byte) error {
+	if c == nil || int64(len(b)) > c.max {
+		return nil
+	}
+
+	name := c.fileName(key)
+
+	// Artwork is written atomically, so concurrent readers see either the
+	// old or the new file
+	if err := writeFileAtomic(filepath.Join(c.dir, name), b); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if e, ok := c.entries[name]; ok {
+		c.lru.Remove(e)
+		delete(c.entries, name)
+		c.size -= e.Value.(*diskArtworkEntry).size
+	}
+
+	c.entries[name] = c.lru.PushFront(&diskArtworkEntry{
+		name: name,
+		size: int64(len(b)),
+	})
+	c.size += int64(len(b))
+	evicted := c.evict()
+	c.mu.Unlock()
+
+	c.removeFiles(evicted)
+	return nil
+}
+
+// evict removes the least recently used artwork from the index until the
+// cache is within its maximum size, and returns the names of the files
+// which must be removed.  The caller must hold c.mu.
+func (c *diskArtworkCache) evict() []string {
+	var names []string
+	for c.size > c.max {
+		names = append(names, c.remove(c.lru.Back()))
+	}
+
+	return names
+}
+
+// remove removes an entry from the index, and returns the name of the file
+// which must be removed.  The caller must hold c.mu.
+func (c *diskArtworkCache) remove(e *list.Element) string {
+	de := e.Value.(*diskArtworkEntry)
+
+	c.lru.Remove(e)
+	delete(c.entries, de.name)
+	c.size -= de.size
+
+	return de.name
+}
+
+// removeFiles removes the files with the specified names from the cache's
+// directory.  The caller must not hold c.mu.
+func (c *diskArtworkCache) removeFiles(names []string) {
+	for _, n := range names {
+		_ = os.Remove(filepath.Join(c.dir, n))
+	}
+}
+
+// fileName returns the name of the file which stores artwork under key.
+func (c *diskArtworkCache) fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + artworkCacheSuffix
+}
+
+// byModTimeDesc sorts os.FileInfos by their modification times, newest
+// first.
+type byModTimeDesc []os.FileInfo
+
+func (b byModTimeDesc) Len() int           { return len(b) }
+func (b byModTimeDesc) Less(i, j int) bool { return b[i].ModTime().After(b[j].ModTime()) }
+func (b byModTimeDesc) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
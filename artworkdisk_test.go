@@ -0,0 +1,111 @@
+package mpdsub
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_diskArtworkCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-artwork")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Files which were not written by the cache are left alone
+	other := filepath.Join(dir, "other.txt")
+	if err := ioutil.WriteFile(other, []byte("other"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	c, err := openDiskArtworkCache(dir, 8)
+	if err != nil {
+		t.Fatalf("failed to open cache: %v", err)
+	}
+
+	mustAdd := func(c *diskArtworkCache, key string) {
+		if err := c.add(key, []byte(key)); err != nil {
+			t.Fatalf("failed to add %q: %v", key, err)
+		}
+	}
+
+	mustAdd(c, "foo")
+	mustAdd(c, "bar")
+
+	// Using foo makes bar the least recently used
+	if b, ok := c.get("foo"); !ok || string(b) != "foo" {
+		t.Fatalf("unexpected cached artwork for foo: %q, %v", b, ok)
+	}
+
+	mustAdd(c, "baz")
+	if _, ok := c.get("bar"); ok {
+		t.Fatal("expected bar to be evicted")
+	}
+
+	// Artwork is retained when the cache is reopened, in order of use
+	c, err = openDiskArtworkCache(dir, 8)
+	if err != nil {
+		t.Fatalf("failed to reopen cache: %v", err)
+	}
+	for _, k := range []string{"foo", "baz"} {
+		if b, ok := c.get(k); !ok || string(b) != k {
+			t.Fatalf("unexpected cached artwork for %s after reopening: %q, %v", k, b, ok)
+		}
+	}
+
+	// Artwork whose file disappears is forgotten
+	if err := os.Remove(filepath.Join(dir, c.fileName("foo"))); err != nil {
+		t.Fatalf("failed to remove file: %v", err)
+	}
+	if _, ok := c.get("foo"); ok {
+		t.Fatal("expected foo to be forgotten after its file was removed")
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	if want, got := 2, len(fis); want != got {
+		t.Fatalf("unexpected number of files:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_openDiskArtworkCacheEvicts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-artwork")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := openDiskArtworkCache(dir, 16)
+	if err != nil {
+		t.Fatalf("failed to open cache: %v", err)
+	}
+
+	for i, k := range []string{"old", "new"} {
+		if err := c.add(k, []byte(k)); err != nil {
+			t.Fatalf("failed to add %q: %v", k, err)
+		}
+
+		// Modification times record the order of use
+		mtime := time.Unix(int64(i+1), 0)
+		if err := os.Chtimes(filepath.Join(dir, c.fileName(k)), mtime, mtime); err != nil {
+			t.Fatalf("failed to set modification time: %v", err)
+		}
+	}
+
+	// A smaller cache only keeps the most recently used artwork
+	c, err = openDiskArtworkCache(dir, 4)
+	if err != nil {
+		t.Fatalf("failed to reopen cache: %v", err)
+	}
+	if _, ok := c.get("old"); ok {
+		t.Fatal("expected old to be evicted")
+	}
+	if _, ok := c.get("new"); !ok {
+		t.Fatal("expected new to be cached")
+	}
+}
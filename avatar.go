@@ -0,0 +1,152 @@
+package mpdsub
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// identiconCells is the number of cells in each row and column of a
+	// generated avatar.
+	identiconCells = 5
+
+	// identiconCellSize is the width and height in pixels of each cell of
+	// a generated avatar, which is surrounded by a margin of half a cell.
+	identiconCellSize = 24
+
+	// avatarMaxAge is the amount of time for which clients may cache an
+	// avatar without revalidating it.
+	avatarMaxAge = time.Hour
+)
+
+// avatarExtensions are the extensions of image files which may contain a
+// user's avatar in AvatarDirectory, in order of preference.
+var avatarExtensions = []string{".png", ".jpg", ".jpeg", ".gif"}
+
+// getAvatar is used in Subsonic to retrieve the avatar of a user.  Avatars
+// are read from an image file named after the user in AvatarDirectory, such
+// as "alice.png".  Users without an avatar receive a generated identicon,
+// which is the same each time it is requested.
+func (s *Server) getAvatar(w http.ResponseWriter, r *http.Request) {
+	user := r.URL.Query().Get("username")
+	if user == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	if _, ok := s.lookupUser(user); !ok {
+		writeXML(w, errNotFound)
+		return
+	}
+
+	b, err := s.avatarFile(user)
+	switch {
+	case err != nil:
+		s.logf("error reading avatar for %q: %v", user, err)
+		writeXML(w, errGeneric)
+		return
+	case b == nil:
+		b, err = identicon(user)
+		if err != nil {
+			s.logf("error generating avatar for %q: %v", user, err)
+			writeXML(w, errGeneric)
+			return
+		}
+	}
+
+	ct := http.DetectContentType(b)
+	if !strings.HasPrefix(ct, "image/") {
+		ct = "application/octet-stream"
+	}
+
+	w.Header().Set(contentType, ct)
+	w.Header().Set("ETag", artworkETag(b))
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(avatarMaxAge.Seconds())))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+}
+
+// avatarFile reads the image file which contains user's avatar in
+// AvatarDirectory.  If AvatarDirectory is not set or contains no avatar
+// for user, it returns nil.
+func (s *Server) avatarFile(user string) ([]byte, error) {
+	if s.cfg.AvatarDirectory == "" {
+		return nil, nil
+	}
+
+	// Names which are not a single path element cannot refer to a file in
+	// AvatarDirectory
+	if strings.HasPrefix(user, ".") || strings.ContainsAny(user, `/\`) {
+		return nil, nil
+	}
+
+	for _, ext := range avatarExtensions {
+		b, err := ioutil.ReadFile(filepath.Join(s.cfg.AvatarDirectory, user+ext))
+		switch {
+		case err == nil:
+			return b, nil
+		case os.IsNotExist(err):
+			continue
+		default:
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// identicon generates a PNG avatar for user: a symmetric pattern of cells
+// in a color, both derived from a hash of user's name.
+func identicon(user string) ([]byte, error) {
+	sum := sha256.Sum256([]byte(user))
+
+	// Use colors which are legible on both light and dark backgrounds
+	fg := color.RGBA{
+		R: 0x40 + sum[0]%0x80,
+		G: 0x40 + sum[1]%0x80,
+		B: 0x40 + sum[2]%0x80,
+		A: 0xff,
+	}
+	bg := color.RGBA{R: 0xf0, G: 0xf0, B: 0xf0, A: 0xff}
+
+	size := (identiconCells + 1) * identiconCellSize
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	// Each cell in the left half of the pattern, including the middle
+	// column, is set by one bit of the hash, and mirrored onto the right
+	half := (identiconCells + 1) / 2
+	margin := identiconCellSize / 2
+	for y := 0; y < identiconCells; y++ {
+		for x := 0; x < half; x++ {
+			bit := y*half + x
+			if sum[3+bit/8]&(1<<uint(bit%8)) == 0 {
+				continue
+			}
+
+			for _, cx := range []int{x, identiconCells - 1 - x} {
+				px := margin + cx*identiconCellSize
+				py := margin + y*identiconCellSize
+				draw.Draw(img, image.Rect(px, py, px+identiconCellSize, py+identiconCellSize),
+					image.NewUniform(fg), image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
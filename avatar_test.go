@@ -0,0 +1,139 @@
+package mpdsub
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServer_getAvatar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-avatar")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	jpeg := []byte("\xff\xd8\xff\xe0avatar")
+	if err := ioutil.WriteFile(filepath.Join(dir, "test.jpg"), jpeg, 0644); err != nil {
+		t.Fatalf("failed to write avatar: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		dir  string
+		user string
+		ct   string
+		b    []byte
+		code int
+	}{
+		{
+			name: "missing username",
+			code: codeMissingParameter,
+		},
+		{
+			name: "unknown user",
+			user: "nobody",
+			code: codeNotFound,
+		},
+		{
+			name: "avatar file",
+			dir:  dir,
+			user: "test",
+			ct:   "image/jpeg",
+			b:    jpeg,
+		},
+		{
+			name: "identicon",
+			user: "test",
+			ct:   "image/png",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.AvatarDirectory = tt.dir
+			if tt.user != "" {
+				values.Set("username", tt.user)
+			}
+
+			withServer(t, nil, nil, cfg, func(base string) {
+				res := testRequest(t, base, http.MethodGet, "/rest/getAvatar.view", values)
+
+				if tt.code != 0 {
+					c := mustDecodeXML(t, res)
+					if c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+
+					if want, got := tt.code, c.Error.Code; want != got {
+						t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+					}
+
+					return
+				}
+
+				if want, got := tt.ct, res.Header.Get(contentType); want != got {
+					t.Fatalf("unexpected Content-Type:\n- want: %v\n-  got: %v", want, got)
+				}
+
+				b, err := ioutil.ReadAll(res.Body)
+				if err != nil {
+					t.Fatalf("failed to read body: %v", err)
+				}
+
+				if tt.b == nil {
+					if _, _, err := image.Decode(bytes.NewReader(b)); err != nil {
+						t.Fatalf("failed to decode identicon: %v", err)
+					}
+					return
+				}
+
+				if want, got := tt.b, b; !bytes.Equal(want, got) {
+					t.Fatalf("unexpected body:\n- want: %q\n-  got: %q", want, got)
+				}
+			})
+		})
+	}
+}
+
+func Test_identicon(t *testing.T) {
+	a, err := identicon("alice")
+	if err != nil {
+		t.Fatalf("failed to generate identicon: %v", err)
+	}
+	b, err := identicon("alice")
+	if err != nil {
+		t.Fatalf("failed to generate identicon: %v", err)
+	}
+	c, err := identicon("bob")
+	if err != nil {
+		t.Fatalf("failed to generate identicon: %v", err)
+	}
+
+	if !bytes.Equal(a, b) {
+		t.Fatal("identicons for the same user should be identical")
+	}
+	if bytes.Equal(a, c) {
+		t.Fatal("identicons for different users should differ")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(a))
+	if err != nil {
+		t.Fatalf("failed to decode identicon: %v", err)
+	}
+
+	// The pattern is mirrored horizontally
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if img.At(x, y) != img.At(bounds.Max.X-1-x, y) {
+				t.Fatalf("identicon is not symmetric at (%d, %d)", x, y)
+			}
+		}
+	}
+}
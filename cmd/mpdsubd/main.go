@@ -16,39 +16,110 @@ func main() {
 		mpdNetwork  string
 		mpdAddr     string
 		mpdMusicDir string
+		mpdMusicURL string
+		mpdWatch    bool
+		mpdCheck    bool
+		mpdOffline  bool
 
-		user string
-		pass string
-		addr string
+		user        string
+		pass        string
+		addr        string
+		externalURL string
 
-		verbose bool
+		metricsAddr string
+
+		name      string
+		stateFile string
+		keyFile   string
+		indexFile string
+		readOnly  bool
+		verbose   bool
 	)
 
 	flag.StringVar(&mpdNetwork, "mpd.network", "tcp", "network to use to dial MPD (typically 'tcp' or 'unix')")
 	flag.StringVar(&mpdAddr, "mpd.addr", "localhost:6600", "address of MPD server")
 	flag.StringVar(&mpdMusicDir, "mpd.music.dir", "", "location of MPD's music directory")
+	flag.StringVar(&mpdMusicURL, "mpd.music.url", "", "optional URL of an HTTP server which serves MPD's music directory, used for streaming when the music directory is not available locally")
+	flag.BoolVar(&mpdCheck, "mpd.music.check", true, "check that files in MPD's database can be found in MPD's music directory at startup")
+	flag.BoolVar(&mpdWatch, "mpd.music.watch", false, "watch MPD's music directory and update MPD's database on changes")
+
+	flag.BoolVar(&mpdOffline, "mpd.offline", false, "cache MPD data so browsing and streaming continue while MPD is unavailable")
 
 	flag.StringVar(&user, "user", "", "username for authentication to this server")
 	flag.StringVar(&pass, "pass", "", "password for authentication to this server")
 	flag.StringVar(&addr, "addr", ":4040", "address this server will listen on")
+	flag.StringVar(&externalURL, "url", "", "optional URL at which clients reach this server, used in exported playlists")
+
+	flag.StringVar(&metricsAddr, "metrics.addr", "", "optional address to serve Prometheus metrics on, such as ':9393'")
 
+	flag.StringVar(&name, "name", "", "optional name for this server, displayed to Subsonic clients")
+	flag.StringVar(&stateFile, "state", "", "file used to persist state which cannot be stored in MPD")
+	flag.StringVar(&keyFile, "userkey", "", "file holding the key for user passwords; if empty, it is kept in the state file")
+	flag.StringVar(&indexFile, "search.index", "", "optional file used to persist a full-text index for fast searches")
+	flag.BoolVar(&readOnly, "readonly", false, "reject requests which would modify MPD or this server's state")
 	flag.BoolVar(&verbose, "v", false, "enable verbose logging")
 
 	flag.Parse()
 
-	c, err := mpd.Dial(mpdNetwork, mpdAddr)
+	dial := func() (*mpd.Client, error) {
+		return mpd.Dial(mpdNetwork, mpdAddr)
+	}
+
+	c, err := dial()
 	if err != nil {
 		log.Fatalf("failed to dial MPD: %v", err)
 	}
 	log.Printf("connected to MPD: %s://%s", mpdNetwork, mpdAddr)
 
-	s := mpdsub.NewServer(c, &mpdsub.Config{
-		SubsonicUser:     user,
-		SubsonicPassword: pass,
-		MusicDirectory:   mpdMusicDir,
-		Verbose:          verbose,
-		Keepalive:        1 * time.Second,
+	// Notify clients of the nowPlayingEvents endpoint when MPD's player
+	// state changes, and refresh caches and the search index when MPD's
+	// database changes.  If MPD cannot be watched, its database is polled
+	// for updates instead.
+	var events <-chan string
+	mw, err := mpd.NewWatcher(mpdNetwork, mpdAddr, "", "player", "database")
+	if err != nil {
+		log.Printf("failed to watch MPD, polling for database updates instead: %v", err)
+	} else {
+		events = mw.Event
+		go func() {
+			for err := range mw.Error {
+				log.Printf("error watching MPD: %v", err)
+			}
+		}()
+	}
+
+	s, err := mpdsub.NewServer(c, &mpdsub.Config{
+		SubsonicUser:        user,
+		SubsonicPassword:    pass,
+		ServerName:          name,
+		MusicDirectory:      mpdMusicDir,
+		MusicURL:            mpdMusicURL,
+		ExternalURL:         externalURL,
+		WatchMusicDirectory: mpdWatch,
+		CheckMusicDirectory: mpdCheck,
+		PlayerEvents:        events,
+		PollInterval:        time.Minute,
+		OfflineCache:        mpdOffline,
+		ReadOnly:            readOnly,
+		Verbose:             verbose,
+		Keepalive:           1 * time.Second,
+		StateFile:           stateFile,
+		UserKeyFile:         keyFile,
+		SearchIndexFile:     indexFile,
+		MPDDial:             dial,
 	})
+	if err != nil {
+		log.Fatalf("failed to create server: %v", err)
+	}
+
+	if metricsAddr != "" {
+		go func() {
+			log.Printf("starting metrics HTTP server: %s", metricsAddr)
+			if err := http.ListenAndServe(metricsAddr, s.MetricsHandler()); err != nil {
+				log.Fatalf("failed to start metrics HTTP server: %v", err)
+			}
+		}()
+	}
 
 	log.Printf("starting HTTP server: %s", addr)
 	if err := http.ListenAndServe(addr, s); err != nil {
@@ -0,0 +1,62 @@
+package mpdsub
+
+import (
+	"fmt"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// validateCollations verifies that each configured collation locale is a
+// valid BCP 47 language tag.
+func validateCollations(cfg *Config) error {
+	if cfg.Collation != "" {
+		if _, err := language.Parse(cfg.Collation); err != nil {
+			return fmt.Errorf("invalid collation %q: %v", cfg.Collation, err)
+		}
+	}
+
+	for user, c := range cfg.UserCollations {
+		if _, err := language.Parse(c); err != nil {
+			return fmt.Errorf("invalid collation %q for user %q: %v", c, user, err)
+		}
+	}
+
+	return nil
+}
+
+// collation returns the collation locale used to sort items for user, or
+// empty string if items are sorted by byte order.
+func (s *Server) collation(user string) string {
+	if c, ok := s.cfg.UserCollations[user]; ok {
+		return c
+	}
+
+	return s.cfg.Collation
+}
+
+// collator creates a collator for user, or returns nil if items are sorted
+// by byte order.  Collators are not safe for concurrent use, so a new
+// collator is created for each request.
+func (s *Server) collator(user string) *collate.Collator {
+	c := s.collation(user)
+	if c == "" {
+		return nil
+	}
+
+	// Validated by validateCollations
+	tag, _ := language.Parse(c)
+	return collate.New(tag)
+}
+
+// byArtistCollation sorts artists by their names using a collator.
+type byArtistCollation struct {
+	artists []artist
+	c       *collate.Collator
+}
+
+func (b *byArtistCollation) Len() int { return len(b.artists) }
+func (b *byArtistCollation) Less(i, j int) bool {
+	return b.c.CompareString(b.artists[i].Name, b.artists[j].Name) < 0
+}
+func (b *byArtistCollation) Swap(i, j int) { b.artists[i], b.artists[j] = b.artists[j], b.artists[i] }
@@ -0,0 +1,86 @@
+package mpdsub
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServer_getIndexesCollation(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Anna/a.mp3",
+			"Zorro/z.mp3",
+			"abba/a.mp3",
+			"Ärzte/a.mp3",
+		},
+	}
+
+	tests := []struct {
+		name      string
+		collation string
+		users     map[string]string
+		order     string
+	}{
+		{
+			name:  "byte order",
+			order: "A:Anna Z:Zorro a:abba Ä:Ärzte",
+		},
+		{
+			name:      "German",
+			collation: "de",
+			order:     "A:abba,Anna Ä:Ärzte Z:Zorro",
+		},
+		{
+			name:      "Swedish",
+			collation: "sv",
+			order:     "A:abba,Anna Z:Zorro Ä:Ärzte",
+		},
+		{
+			name:      "user override",
+			collation: "de",
+			users:     map[string]string{"test": "sv"},
+			order:     "A:abba,Anna Z:Zorro Ä:Ärzte",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.Collation = tt.collation
+			cfg.UserCollations = tt.users
+
+			withServer(t, db, nil, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getIndexes.view", values))
+				if c.Indexes == nil {
+					t.Fatal("indexes is nil")
+				}
+
+				var order []string
+				for _, idx := range c.Indexes.Indexes {
+					var names []string
+					for _, a := range idx.Artists {
+						names = append(names, a.Name)
+					}
+
+					order = append(order, idx.Name+":"+strings.Join(names, ","))
+				}
+
+				if want, got := tt.order, strings.Join(order, " "); want != got {
+					t.Fatalf("unexpected index order:\n- want: %v\n-  got: %v",
+						want, got)
+				}
+			})
+		})
+	}
+}
+
+func Test_validateCollations(t *testing.T) {
+	if err := validateCollations(&Config{Collation: "sv"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := validateCollations(&Config{UserCollations: map[string]string{"foo": "!!"}}); err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+}
@@ -0,0 +1,228 @@
+package mpdsub
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// Listeners of classical music organize their libraries by composer and
+// work, rather than by the performing artists and albums used by Subsonic.
+// When Config.ComposerBrowsing is set, the custom getComposers and
+// getComposer endpoints browse songs using MPD's composer and work tags.
+// Songs also carry their composer, work, and movement in every response, so
+// clients may display them regardless.
+
+// getComposers is a custom endpoint which retrieves the composers of the
+// songs in MPD's database, optionally in a single music folder, with the
+// number of songs and works by each composer.  Composers are matched
+// case-insensitively, and named after the first spelling encountered.
+func (s *Server) getComposers(w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.ComposerBrowsing {
+		writeXML(w, errNotAuthorized)
+		return
+	}
+
+	folder, ok := composerFolder(r)
+	if !ok {
+		writeXML(w, errGeneric)
+		return
+	}
+
+	songs, err := s.db.ListAllInfo("")
+	if err != nil {
+		s.logf("error retrieving songs from mpd for composers: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	user := requestContextFrom(r).User
+
+	byKey := make(map[string]*composer)
+	works := make(map[string]map[string]struct{})
+	for _, a := range s.composerSongs(user, songs, folder) {
+		name := strings.TrimSpace(a["Composer"])
+		key := strings.ToLower(name)
+
+		c, ok := byKey[key]
+		if !ok {
+			c = &composer{Name: name}
+			byKey[key] = c
+			works[key] = make(map[string]struct{})
+		}
+		c.SongCount++
+		works[key][strings.ToLower(a["Work"])] = struct{}{}
+	}
+
+	out := make([]composer, 0, len(byKey))
+	for key, c := range byKey {
+		c.WorkCount = len(works[key])
+		out = append(out, *c)
+	}
+	sort.Sort(byComposerName(out))
+
+	writeXML(w, func(c *container) {
+		c.Composers = &composersContainer{
+			Composers: out,
+		}
+	})
+}
+
+// getComposer is a custom endpoint which retrieves the songs by a composer,
+// optionally in a single music folder, grouped by work.  Works are ordered
+// by name, and songs within a work are ordered by album, then by movement
+// and track number, so that each recording of a work is kept together.
+func (s *Server) getComposer(w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.ComposerBrowsing {
+		writeXML(w, errNotAuthorized)
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	folder, ok := composerFolder(r)
+	if !ok {
+		writeXML(w, errGeneric)
+		return
+	}
+
+	// Search is case-insensitive but also matches partial names, so only
+	// keep exact matches
+	found, err := s.db.Search("composer", name)
+	if err != nil {
+		s.logf("error retrieving songs by composer from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	user := requestContextFrom(r).User
+
+	var songs []mpd.Attrs
+	for _, a := range s.composerSongs(user, found, folder) {
+		if strings.EqualFold(strings.TrimSpace(a["Composer"]), name) {
+			songs = append(songs, a)
+		}
+	}
+
+	children, err := s.songChildren(user, songs)
+	if err != nil {
+		s.logf("error retrieving songs by composer from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+	if len(children) == 0 {
+		writeXML(w, errNotFound)
+		return
+	}
+
+	sort.Stable(byMovement(children))
+
+	// Works are matched case-insensitively, like composers
+	res := &composer{
+		Name:      strings.TrimSpace(songs[0]["Composer"]),
+		SongCount: len(children),
+	}
+	byWork := make(map[string]int)
+	for _, c := range children {
+		key := strings.ToLower(c.Work)
+
+		i, ok := byWork[key]
+		if !ok {
+			i = len(res.Works)
+			byWork[key] = i
+			res.Works = append(res.Works, work{Name: c.Work})
+		}
+
+		res.Works[i].SongCount++
+		res.Works[i].Songs = append(res.Works[i].Songs, song{child: c})
+	}
+	res.WorkCount = len(res.Works)
+	sort.Stable(byWorkName(res.Works))
+
+	writeXML(w, func(c *container) {
+		c.Composer = res
+	})
+}
+
+// composerSongs returns the songs in a music folder which have a composer
+// tag and are visible to user.
+func (s *Server) composerSongs(user string, songs []mpd.Attrs, folder int) []mpd.Attrs {
+	out := make([]mpd.Attrs, 0, len(songs))
+	for _, a := range songs {
+		name := a["file"]
+		if name == "" || strings.TrimSpace(a["Composer"]) == "" {
+			continue
+		}
+		if !s.inMusicFolder(name, folder) || !s.visible(user, name) || s.filteredGenre(user, a["Genre"]) {
+			continue
+		}
+
+		out = append(out, a)
+	}
+
+	return out
+}
+
+// composerFolder parses the optional musicFolderId parameter of a
+// getComposers or getComposer request.  If the parameter is invalid, it
+// returns false.
+func composerFolder(r *http.Request) (int, bool) {
+	qFolder := r.URL.Query().Get("musicFolderId")
+	if qFolder == "" {
+		return musicFolderAll, true
+	}
+
+	folder, err := strconv.Atoi(qFolder)
+	if err != nil {
+		return 0, false
+	}
+
+	return folder, true
+}
+
+// byComposerName sorts composers by their names, case-insensitively.
+type byComposerName []composer
+
+func (b byComposerName) Len() int { return len(b) }
+func (b byComposerName) Less(i, j int) bool {
+	return strings.ToLower(b[i].Name) < strings.ToLower(b[j].Name)
+}
+func (b byComposerName) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+// byWorkName sorts works by their names, case-insensitively.  Songs without
+// a work tag are sorted last.
+type byWorkName []work
+
+func (b byWorkName) Len() int { return len(b) }
+func (b byWorkName) Less(i, j int) bool {
+	if (b[i].Name == "") != (b[j].Name == "") {
+		return b[j].Name == ""
+	}
+
+	return strings.ToLower(b[i].Name) < strings.ToLower(b[j].Name)
+}
+func (b byWorkName) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+// byMovement sorts children by their albums, and then by their movement and
+// track numbers.
+type byMovement []child
+
+func (b byMovement) Len() int { return len(b) }
+func (b byMovement) Less(i, j int) bool {
+	if di, dj := albumDir(b[i].Path), albumDir(b[j].Path); di != dj {
+		return di < dj
+	}
+	if b[i].MovementNumber != b[j].MovementNumber {
+		return b[i].MovementNumber < b[j].MovementNumber
+	}
+
+	return b[i].Track < b[j].Track
+}
+func (b byMovement) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,115 @@
+package mpdsub
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_composers(t *testing.T) {
+	var (
+		first  = mpd.Attrs{"file": "Bach/Suites/2.flac", "Title": "Allemande", "Composer": "J.S. Bach", "Work": "Cello Suite No. 1", "Movement": "Allemande", "MovementNumber": "2"}
+		second = mpd.Attrs{"file": "Bach/Suites/1.flac", "Title": "Prélude", "Composer": "J.S. Bach", "Work": "Cello Suite No. 1", "Movement": "Prélude", "MovementNumber": "1"}
+		other  = mpd.Attrs{"file": "Bach/Other/1.flac", "Title": "Toccata", "Composer": "j.s. bach"}
+		mass   = mpd.Attrs{"file": "Bach/Mass/1.flac", "Title": "Kyrie", "Composer": "J.S. Bach", "Work": "Mass in B minor"}
+		ravel  = mpd.Attrs{"file": "Ravel/Bolero/1.flac", "Title": "Boléro", "Composer": "Maurice Ravel"}
+	)
+
+	db := &memoryDatabase{
+		files: []string{
+			"Bach/Mass/1.flac",
+			"Bach/Other/1.flac",
+			"Bach/Suites/1.flac",
+			"Bach/Suites/2.flac",
+			"Ravel/Bolero/1.flac",
+			"root.flac",
+		},
+		songs: []mpd.Attrs{first, second, other, mass, ravel, {"file": "root.flac"}},
+		searches: map[string][]mpd.Attrs{
+			"composer J.S. Bach": {first, second, other, mass},
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.ComposerBrowsing = true
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getComposers.view", values))
+		if c.Composers == nil {
+			t.Fatal("no composers in response")
+		}
+
+		var composers []composer
+		for _, cm := range c.Composers.Composers {
+			cm.XMLName = xml.Name{}
+			composers = append(composers, cm)
+		}
+
+		wantComposers := []composer{
+			{Name: "J.S. Bach", SongCount: 4, WorkCount: 3},
+			{Name: "Maurice Ravel", SongCount: 1, WorkCount: 1},
+		}
+		if !reflect.DeepEqual(wantComposers, composers) {
+			t.Fatalf("unexpected composers:\n- want: %+v\n-  got: %+v", wantComposers, composers)
+		}
+
+		v := url.Values{}
+		for k, vs := range values {
+			v[k] = vs
+		}
+		v.Set("name", "J.S. Bach")
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getComposer.view", v))
+		if c.Composer == nil {
+			t.Fatal("no composer in response")
+		}
+
+		type summary struct {
+			Work   string
+			Titles []string
+		}
+
+		var works []summary
+		for _, w := range c.Composer.Works {
+			s := summary{Work: w.Name}
+			for _, sg := range w.Songs {
+				s.Titles = append(s.Titles, sg.Title)
+			}
+
+			works = append(works, s)
+		}
+
+		// Movements are ordered by number, and songs without a work are last
+		wantWorks := []summary{
+			{Work: "Cello Suite No. 1", Titles: []string{"Prélude", "Allemande"}},
+			{Work: "Mass in B minor", Titles: []string{"Kyrie"}},
+			{Work: "", Titles: []string{"Toccata"}},
+		}
+		if !reflect.DeepEqual(wantWorks, works) {
+			t.Fatalf("unexpected works:\n- want: %+v\n-  got: %+v", wantWorks, works)
+		}
+
+		if want, got := "J.S. Bach", c.Composer.Works[0].Songs[0].DisplayComposer; want != got {
+			t.Fatalf("unexpected display composer:\n- want: %q\n-  got: %q", want, got)
+		}
+
+		v.Set("name", "Nobody")
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getComposer.view", v))
+		if want, got := codeNotFound, c.Error.Code; want != got {
+			t.Fatalf("unexpected XML error code:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+
+	// Composer browsing must be enabled
+	cfg, values = configAuth()
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getComposers.view", values))
+		if want, got := codeNotAuthorized, c.Error.Code; want != got {
+			t.Fatalf("unexpected XML error code:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package mpdsub
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Validate checks a Config for errors which would otherwise only surface
+// when Subsonic clients make requests, such as an unreadable music
+// directory or a transcoder command which is not installed, so that a
+// misconfigured Server fails at startup with a specific message.  Validate
+// is called by NewServer, but may also be used to check a configuration
+// without starting a Server.
+func (cfg *Config) Validate() error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	if cfg.SubsonicUser == "" || cfg.SubsonicPassword == "" {
+		return errors.New("subsonic user and password must not be empty, or no client can authenticate")
+	}
+
+	if cfg.MusicDirectory != "" && cfg.MusicURL == "" {
+		if err := checkDirectory("music directory", cfg.MusicDirectory); err != nil {
+			return err
+		}
+	}
+	if cfg.PlaylistDirectory != "" {
+		if err := checkDirectory("playlist directory", cfg.PlaylistDirectory); err != nil {
+			return err
+		}
+	}
+	if cfg.WatchMusicDirectory && cfg.MusicDirectory == "" {
+		return errors.New("cannot watch music directory: music directory not set")
+	}
+
+	if cfg.StateFile != "" {
+		if err := checkStateFile(cfg.StateFile); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validate checks a Config for errors which do not depend on the
+// environment in which the Server runs, and which are checked whenever a
+// Server is created.
+func (cfg *Config) validate() error {
+	for i := range cfg.Transcoders {
+		if err := cfg.Transcoders[i].validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateCollations(cfg); err != nil {
+		return err
+	}
+	if err := validateClientFormats(cfg.ClientFormats, cfg.Transcoders); err != nil {
+		return err
+	}
+	if err := validateAccessWindows(cfg.AccessWindows); err != nil {
+		return err
+	}
+	if err := validateSmartPlaylists(cfg.SmartPlaylists); err != nil {
+		return err
+	}
+	if cfg.ReadOnly && cfg.WatchMusicDirectory {
+		return errors.New("read-only server cannot watch music directory")
+	}
+	if cfg.LastFM != nil {
+		if err := cfg.LastFM.validate(); err != nil {
+			return err
+		}
+	}
+	if cfg.MusicURL != "" {
+		if _, err := parseMusicURL(cfg.MusicURL); err != nil {
+			return err
+		}
+	}
+	if cfg.ExternalURL != "" {
+		if _, err := parseBaseURL("external", cfg.ExternalURL); err != nil {
+			return err
+		}
+	}
+	if cfg.PrewarmArtwork && cfg.ArtworkCacheDirectory == "" {
+		return errors.New("cannot prewarm artwork: artwork cache directory not set")
+	}
+	if cfg.MaxBackgroundJobs < 0 {
+		return errors.New("maximum number of background jobs must not be negative")
+	}
+
+	return nil
+}
+
+// checkDirectory verifies that dir is a directory whose contents can be
+// read.  kind describes the directory in errors.
+func checkDirectory(kind string, dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("%s %q: %v", kind, dir, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s %q: not a directory", kind, dir)
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("%s %q: %v", kind, dir, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Readdirnames(1); err != nil && err != io.EOF {
+		return fmt.Errorf("%s %q: cannot list contents: %v", kind, dir, err)
+	}
+
+	return nil
+}
+
+// checkStateFile verifies that the state file at path can be replaced, by
+// creating a temporary file in its directory, as the store does when it is
+// updated.
+func checkStateFile(path string) error {
+	dir := filepath.Dir(path)
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("state file %q: %v", path, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("state file %q: %q is not a directory", path, dir)
+	}
+
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("state file %q: directory is not writable: %v", path, err)
+	}
+	_ = f.Close()
+
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,141 @@
+package mpdsub
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-config")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatalf("failed to write temporary file: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  Config
+		ok   bool
+	}{
+		{
+			name: "no credentials",
+			cfg:  Config{MusicDirectory: dir},
+		},
+		{
+			name: "music directory missing",
+			cfg: Config{
+				SubsonicUser:     "test",
+				SubsonicPassword: "test",
+				MusicDirectory:   filepath.Join(dir, "missing"),
+			},
+		},
+		{
+			name: "music directory is a file",
+			cfg: Config{
+				SubsonicUser:     "test",
+				SubsonicPassword: "test",
+				MusicDirectory:   file,
+			},
+		},
+		{
+			name: "music directory not needed with music URL",
+			cfg: Config{
+				SubsonicUser:     "test",
+				SubsonicPassword: "test",
+				MusicDirectory:   filepath.Join(dir, "missing"),
+				MusicURL:         "http://localhost/music/",
+			},
+			ok: true,
+		},
+		{
+			name: "watch without music directory",
+			cfg: Config{
+				SubsonicUser:        "test",
+				SubsonicPassword:    "test",
+				WatchMusicDirectory: true,
+			},
+		},
+		{
+			name: "state file directory missing",
+			cfg: Config{
+				SubsonicUser:     "test",
+				SubsonicPassword: "test",
+				StateFile:        filepath.Join(dir, "missing", "state.json"),
+			},
+		},
+		{
+			name: "invalid smart playlist",
+			cfg: Config{
+				SubsonicUser:     "test",
+				SubsonicPassword: "test",
+				SmartPlaylists:   []SmartPlaylist{{Name: "empty"}},
+			},
+		},
+		{
+			name: "prewarm artwork without cache directory",
+			cfg: Config{
+				SubsonicUser:     "test",
+				SubsonicPassword: "test",
+				PrewarmArtwork:   true,
+			},
+		},
+		{
+			name: "last.fm session keys without secret",
+			cfg: Config{
+				SubsonicUser:     "test",
+				SubsonicPassword: "test",
+				LastFM: &LastFM{
+					APIKey:      "key",
+					SessionKeys: map[string]string{"test": "session"},
+				},
+			},
+		},
+		{
+			name: "last.fm metadata without secret",
+			cfg: Config{
+				SubsonicUser:     "test",
+				SubsonicPassword: "test",
+				LastFM:           &LastFM{APIKey: "key"},
+			},
+			ok: true,
+		},
+		{
+			name: "OK",
+			cfg: Config{
+				SubsonicUser:     "test",
+				SubsonicPassword: "test",
+				MusicDirectory:   dir,
+				StateFile:        filepath.Join(dir, "state.json"),
+			},
+			ok: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if err != nil && tt.ok {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && !tt.ok {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+
+	// Checking the state file must not leave files behind
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read temporary directory: %v", err)
+	}
+	if want, got := 1, len(files); want != got {
+		t.Fatalf("unexpected number of files:\n- want: %v\n-  got: %v", want, got)
+	}
+}
@@ -0,0 +1,175 @@
+package mpdsub
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultCheckSize is the default number of files sampled when checking
+	// MPD's music directory.
+	defaultCheckSize = 20
+
+	// maxCheckSize is the maximum number of files which may be sampled by
+	// a single check of MPD's music directory.
+	maxCheckSize = 500
+)
+
+// checkMusicDirectory is a custom endpoint used to verify that files in
+// MPD's database can be found in the Server's view of MPD's music directory.
+// This catches a MusicDirectory or MusicURL which does not match MPD's
+// music_directory setting, before users encounter broken streams.
+//
+// The optional size parameter specifies how many files are sampled.  Only
+// administrators may run the check, because it reveals the location of the
+// music directory.
+func (s *Server) checkMusicDirectory(w http.ResponseWriter, r *http.Request) {
+	size := defaultCheckSize
+	if qSize := r.URL.Query().Get("size"); qSize != "" {
+		n, err := strconv.Atoi(qSize)
+		if err != nil || n < 1 {
+			writeXML(w, errGeneric)
+			return
+		}
+		if n > maxCheckSize {
+			n = maxCheckSize
+		}
+		size = n
+	}
+
+	mc, err := s.checkFiles(requestContextFrom(r).User, size)
+	if err != nil {
+		s.logf("error checking music directory: %v", err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	writeXML(w, func(c *container) {
+		c.MusicDirectoryCheck = mc
+	})
+}
+
+// startupCheck checks MPD's music directory when the Server starts, and logs
+// any problems found.
+func (s *Server) startupCheck() {
+	defer s.wg.Done()
+
+	mc, err := s.checkFiles(s.cfg.SubsonicUser, defaultCheckSize)
+	if err != nil {
+		s.logf("failed to check music directory: %v", err)
+		return
+	}
+	if !mc.OK {
+		s.logf("music directory check failed: %s", mc.Message)
+	}
+}
+
+// checkFiles samples up to size files visible to user from MPD's database,
+// and reports which of them cannot be found in the local or remote music
+// directory.
+func (s *Server) checkFiles(user string, size int) (*musicDirectoryCheck, error) {
+	files, err := s.db.List("file")
+	if err != nil {
+		return nil, err
+	}
+
+	fs := make([]string, 0, len(files))
+	for _, f := range files {
+		if s.visible(user, f) {
+			fs = append(fs, f)
+		}
+	}
+
+	mc := &musicDirectoryCheck{
+		Directory: s.cfg.MusicDirectory,
+	}
+	if s.musicURL != nil {
+		mc.Directory = s.musicURL.String()
+	}
+
+	if s.musicURL == nil && s.cfg.MusicDirectory == "" {
+		mc.Message = "MusicDirectory is not set; set it to the music_directory from MPD's configuration to enable streaming"
+		return mc, nil
+	}
+
+	sample := sampleFiles(fs, size)
+	for _, name := range sample {
+		if !s.musicFileExists(name) {
+			mc.Missing = append(mc.Missing, missingFile{Path: name})
+		}
+	}
+
+	mc.Checked = len(sample)
+	mc.OK = len(mc.Missing) == 0
+	mc.Message = s.diagnose(mc)
+
+	return mc, nil
+}
+
+// diagnose returns an actionable message describing the results of a music
+// directory check.
+func (s *Server) diagnose(mc *musicDirectoryCheck) string {
+	switch {
+	case mc.Checked == 0:
+		return "MPD's database contains no files; update MPD's database and check again"
+	case len(mc.Missing) == 0:
+		return fmt.Sprintf("all %d sampled files were found in %q", mc.Checked, mc.Directory)
+	case len(mc.Missing) < mc.Checked:
+		return fmt.Sprintf("%d of %d sampled files were not found in %q, such as %q; MPD's database may be out of date, or some files may not be readable by this server",
+			len(mc.Missing), mc.Checked, mc.Directory, mc.Missing[0].Path)
+	}
+
+	msg := fmt.Sprintf("none of the %d sampled files were found in %q, such as %q", mc.Checked, mc.Directory, mc.Missing[0].Path)
+	if s.musicURL != nil {
+		return msg + "; MusicURL must serve the music_directory from MPD's configuration"
+	}
+
+	// A common mistake is to point MusicDirectory at a subdirectory of
+	// MPD's music directory, which duplicates the first path element
+	name := mc.Missing[0].Path
+	if i := strings.Index(name, "/"); i > 0 && s.musicFileExists(name[i+1:]) {
+		return msg + fmt.Sprintf("; MusicDirectory appears to be the %q subdirectory of MPD's music_directory, and should be set to its parent", name[:i])
+	}
+
+	return msg + "; MusicDirectory must match the music_directory from MPD's configuration"
+}
+
+// musicFileExists reports whether a file in MPD's database can be opened
+// in the local or remote music directory.
+func (s *Server) musicFileExists(name string) bool {
+	p := s.musicPath(name)
+
+	if s.musicURL == nil {
+		f, err := s.fs.Open(p)
+		if err != nil {
+			return false
+		}
+		_ = f.Close()
+		return true
+	}
+
+	res, err := s.musicClient.Head(p)
+	if err != nil {
+		return false
+	}
+	_ = res.Body.Close()
+
+	return res.StatusCode == http.StatusOK
+}
+
+// sampleFiles selects up to size files spread evenly across files, so that
+// a sample covers many directories of a large music directory.
+func sampleFiles(files []string, size int) []string {
+	if len(files) <= size {
+		return files
+	}
+
+	sample := make([]string, 0, size)
+	for i := 0; i < size; i++ {
+		sample = append(sample, files[i*len(files)/size])
+	}
+
+	return sample
+}
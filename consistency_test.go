@@ -0,0 +1,159 @@
+package mpdsub
+
+import (
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestServer_checkMusicDirectory(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Rock/a.mp3",
+			"Rock/b.mp3",
+			"Rock/c.mp3",
+		},
+	}
+
+	newFS := func(dir string, files ...string) *memoryFilesystem {
+		fs := &memoryFilesystem{
+			files: make(map[string]*memoryFile),
+		}
+		for _, f := range files {
+			fs.files[filepath.Join(dir, f)] = &memoryFile{ReadSeeker: strings.NewReader(f)}
+		}
+		return fs
+	}
+
+	tests := []struct {
+		name    string
+		dir     string
+		fs      *memoryFilesystem
+		size    string
+		folders map[string][]string
+		ok      bool
+		checked int
+		missing []missingFile
+		message string
+	}{
+		{
+			name:    "not set",
+			fs:      newFS(""),
+			message: "MusicDirectory is not set",
+		},
+		{
+			name:    "OK",
+			dir:     "/var/music",
+			fs:      newFS("/var/music", "Rock/a.mp3", "Rock/b.mp3", "Rock/c.mp3"),
+			ok:      true,
+			checked: 3,
+			message: "all 3 sampled files were found",
+		},
+		{
+			name:    "sample size",
+			dir:     "/var/music",
+			fs:      newFS("/var/music", "Rock/a.mp3", "Rock/b.mp3", "Rock/c.mp3"),
+			size:    "2",
+			ok:      true,
+			checked: 2,
+			message: "all 2 sampled files were found",
+		},
+		{
+			name:    "out of date",
+			dir:     "/var/music",
+			fs:      newFS("/var/music", "Rock/a.mp3", "Rock/c.mp3"),
+			checked: 3,
+			missing: []missingFile{{Path: "Rock/b.mp3"}},
+			message: "MPD's database may be out of date",
+		},
+		{
+			name:    "wrong directory",
+			dir:     "/srv/music",
+			fs:      newFS("/var/music", "Rock/a.mp3", "Rock/b.mp3", "Rock/c.mp3"),
+			checked: 3,
+			missing: []missingFile{{Path: "Rock/a.mp3"}, {Path: "Rock/b.mp3"}, {Path: "Rock/c.mp3"}},
+			message: "MusicDirectory must match",
+		},
+		{
+			name:    "subdirectory",
+			dir:     "/var/music/Rock",
+			fs:      newFS("/var/music", "Rock/a.mp3", "Rock/b.mp3", "Rock/c.mp3"),
+			checked: 3,
+			missing: []missingFile{{Path: "Rock/a.mp3"}, {Path: "Rock/b.mp3"}, {Path: "Rock/c.mp3"}},
+			message: `appears to be the "Rock" subdirectory`,
+		},
+		{
+			name:    "hidden",
+			dir:     "/srv/music",
+			fs:      newFS("/var/music", "Rock/a.mp3", "Rock/b.mp3", "Rock/c.mp3"),
+			folders: map[string][]string{"Rock": {"other"}},
+			ok:      true,
+			message: "MPD's database contains no files",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.MusicDirectory = tt.dir
+			cfg.FolderUsers = tt.folders
+			if tt.size != "" {
+				values.Set("size", tt.size)
+			}
+
+			withServer(t, db, tt.fs, cfg, func(base string) {
+				res := testRequest(t, base, http.MethodGet, "/rest/checkMusicDirectory.view", values)
+				c := mustDecodeXML(t, res)
+
+				mc := c.MusicDirectoryCheck
+				if mc == nil {
+					t.Fatal("no music directory check in response")
+				}
+
+				if want, got := tt.ok, mc.OK; want != got {
+					t.Fatalf("unexpected OK:\n- want: %v\n-  got: %v", want, got)
+				}
+				if want, got := tt.checked, mc.Checked; want != got {
+					t.Fatalf("unexpected number of checked files:\n- want: %v\n-  got: %v", want, got)
+				}
+				if want, got := tt.missing, mc.Missing; !reflect.DeepEqual(want, got) {
+					t.Fatalf("unexpected missing files:\n- want: %v\n-  got: %v", want, got)
+				}
+				if want, got := tt.message, mc.Message; !strings.Contains(got, want) {
+					t.Fatalf("unexpected message:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		})
+	}
+}
+
+func Test_sampleFiles(t *testing.T) {
+	files := []string{"a", "b", "c", "d", "e", "f"}
+
+	tests := []struct {
+		name string
+		size int
+		want []string
+	}{
+		{
+			name: "all",
+			size: 10,
+			want: files,
+		},
+		{
+			name: "spread",
+			size: 3,
+			want: []string{"a", "c", "e"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if want, got := tt.want, sampleFiles(files, tt.size); !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected sample:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
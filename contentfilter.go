@@ -0,0 +1,190 @@
+package mpdsub
+
+import (
+	"bufio"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// A ContentFilter hides content from a user, such as a child's account on a
+// Server shared by a family.  Hidden content does not appear when browsing,
+// searching, or listing random songs and playlists, and cannot be streamed
+// or downloaded.
+type ContentFilter struct {
+	// Genres optionally specifies genres which are hidden, such as
+	// "Comedy".  Genres are matched case-insensitively, after applying
+	// GenreAliases and GenreSeparators.
+	Genres []string
+
+	// Patterns optionally specifies patterns which hide entire subtrees of
+	// MPD's music directory, using the same syntax as ExcludePatterns.
+	Patterns []string
+
+	// BlocklistFile optionally specifies the path to a file which lists
+	// files and directories to hide, one per line, relative to MPD's music
+	// directory.  Blank lines and lines beginning with "#" are ignored.
+	// The file is read when the Server is created.
+	BlocklistFile string
+}
+
+// A contentFilter is a ContentFilter prepared for matching.
+type contentFilter struct {
+	genres  map[string]struct{}
+	exclude *excluder
+	blocked map[string]struct{}
+	fold    bool
+}
+
+// newContentFilters prepares the ContentFilters in cfg for matching, reading
+// any blocklist files.
+func newContentFilters(cfg *Config) (map[string]*contentFilter, error) {
+	filters := make(map[string]*contentFilter, len(cfg.ContentFilters))
+	for user, cf := range cfg.ContentFilters {
+		f := &contentFilter{
+			genres:  make(map[string]struct{}, len(cf.Genres)),
+			exclude: newExcluder(cf.Patterns, cfg.CaseInsensitivePaths),
+			blocked: make(map[string]struct{}),
+			fold:    cfg.CaseInsensitivePaths,
+		}
+
+		for _, g := range cf.Genres {
+			f.genres[strings.ToLower(g)] = struct{}{}
+		}
+
+		if cf.BlocklistFile != "" {
+			if err := f.readBlocklist(cf.BlocklistFile); err != nil {
+				return nil, err
+			}
+		}
+
+		filters[user] = f
+	}
+
+	return filters, nil
+}
+
+// readBlocklist adds the files and directories listed in the file at p to
+// the contentFilter.
+func (f *contentFilter) readBlocklist(p string) error {
+	file, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Accept Windows-style separators, as in ExcludePatterns
+		line = strings.TrimSuffix(strings.Replace(line, `\`, "/", -1), "/")
+		if f.fold {
+			line = strings.ToLower(line)
+		}
+
+		f.blocked[line] = struct{}{}
+	}
+
+	return s.Err()
+}
+
+// hidesPath reports whether the file or directory with the specified name,
+// or any of its parent directories, is hidden.
+func (f *contentFilter) hidesPath(name string) bool {
+	if f.exclude.Excluded(name) {
+		return true
+	}
+	if len(f.blocked) == 0 {
+		return false
+	}
+
+	if f.fold {
+		name = strings.ToLower(name)
+	}
+
+	for p := name; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		if _, ok := f.blocked[p]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hidesGenres reports whether any of the normalized genres is hidden.
+func (f *contentFilter) hidesGenres(genres []string) bool {
+	for _, g := range genres {
+		if _, ok := f.genres[strings.ToLower(g)]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filtered reports whether the file or directory with the specified name is
+// hidden from user by a ContentFilter.
+func (s *Server) filtered(user string, name string) bool {
+	f, ok := s.filters[user]
+	return ok && f.hidesPath(name)
+}
+
+// filteredGenre reports whether a song with the raw genre tag is hidden from
+// user by a ContentFilter.
+func (s *Server) filteredGenre(user string, raw string) bool {
+	f, ok := s.filters[user]
+	return ok && len(f.genres) > 0 && f.hidesGenres(s.genres.Normalize(raw))
+}
+
+// canAccess reports whether user may stream or download the file or directory
+// f.  If not, an error is written to w.
+func (s *Server) canAccess(w http.ResponseWriter, user string, f indexedFile) bool {
+	if !s.visible(user, f.Name) {
+		writeXML(w, errNotAuthorized)
+		return false
+	}
+	if f.Dir {
+		return true
+	}
+
+	hidden, err := s.filteredSong(user, f.Name)
+	if err != nil {
+		s.logf("error checking content filter for %q: %v", f.Name, err)
+		writeXML(w, errGeneric)
+		return false
+	}
+	if hidden {
+		writeXML(w, errNotAuthorized)
+		return false
+	}
+
+	return true
+}
+
+// filteredSong reports whether the file with the specified name is hidden
+// from user by a ContentFilter, looking up its genre in MPD's database if
+// user's filter hides any genres.
+func (s *Server) filteredSong(user string, name string) (bool, error) {
+	f, ok := s.filters[user]
+	if !ok {
+		return false, nil
+	}
+	if f.hidesPath(name) {
+		return true, nil
+	}
+	if len(f.genres) == 0 {
+		return false, nil
+	}
+
+	attrs, err := readTags(s.db, name)
+	if err != nil {
+		return false, err
+	}
+
+	return f.hidesGenres(s.genres.Normalize(attrs["GENRE"])), nil
+}
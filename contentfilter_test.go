@@ -0,0 +1,157 @@
+package mpdsub
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func Test_newContentFilters(t *testing.T) {
+	blocklist := writeTempFile(t, "# Not for kids\nSecret/\n\nRock\\Loud\\c.mp3\n")
+
+	filters, err := newContentFilters(&Config{
+		CaseInsensitivePaths: true,
+		ContentFilters: map[string]ContentFilter{
+			"kid": {
+				Genres:        []string{"Comedy"},
+				Patterns:      []string{"*/Explicit"},
+				BlocklistFile: blocklist,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create content filters: %v", err)
+	}
+
+	f := filters["kid"]
+
+	paths := []struct {
+		name   string
+		hidden bool
+	}{
+		{name: "Kids/Songs/a.mp3"},
+		{name: "Rock/Loud/b.mp3"},
+		{name: "Rock/Loud/c.mp3", hidden: true},
+		{name: "secret", hidden: true},
+		{name: "Secret/d.mp3", hidden: true},
+		{name: "Rock/explicit/e.mp3", hidden: true},
+	}
+
+	for _, p := range paths {
+		if want, got := p.hidden, f.hidesPath(p.name); want != got {
+			t.Fatalf("unexpected result for %q:\n- want: %v\n-  got: %v", p.name, want, got)
+		}
+	}
+
+	if !f.hidesGenres([]string{"Rock", "comedy"}) {
+		t.Fatal("expected comedy genre to be hidden")
+	}
+	if f.hidesGenres([]string{"Rock"}) {
+		t.Fatal("expected rock genre to be visible")
+	}
+
+	if _, err := newContentFilters(&Config{
+		ContentFilters: map[string]ContentFilter{
+			"kid": {BlocklistFile: "/nonexistent/mpdsub/blocklist"},
+		},
+	}); err == nil {
+		t.Fatal("expected an error for a missing blocklist file, but none occurred")
+	}
+}
+
+func TestServer_contentFilters(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Kids/Songs/a.mp3",
+			"Kids/Songs/b.mp3",
+			"Rock/Loud/c.mp3",
+			"Secret/d.mp3",
+		},
+		attrs: map[string]mpd.Attrs{
+			"Kids/Songs/a.mp3": {"TITLE": "a", "GENRE": "Children"},
+			"Kids/Songs/b.mp3": {"TITLE": "b", "GENRE": "Comedy"},
+			"Rock/Loud/c.mp3":  {"TITLE": "c", "GENRE": "Rock"},
+			"Secret/d.mp3":     {"TITLE": "d"},
+		},
+		songs: []mpd.Attrs{
+			{"file": "Kids/Songs/a.mp3", "Title": "a", "Genre": "Children"},
+			{"file": "Kids/Songs/b.mp3", "Title": "b", "Genre": "Comedy"},
+			{"file": "Rock/Loud/c.mp3", "Title": "c", "Genre": "Rock"},
+			{"file": "Secret/d.mp3", "Title": "d"},
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.ContentFilters = map[string]ContentFilter{
+		cfg.SubsonicUser: {
+			Genres:        []string{"comedy"},
+			Patterns:      []string{"Rock"},
+			BlocklistFile: writeTempFile(t, "Secret/d.mp3\n"),
+		},
+	}
+
+	// params copies values and sets additional query parameters
+	params := func(kv ...string) url.Values {
+		v := make(url.Values, len(values))
+		for k, vv := range values {
+			v[k] = vv
+		}
+		for i := 0; i < len(kv); i += 2 {
+			v.Set(kv[i], kv[i+1])
+		}
+		return v
+	}
+
+	withServer(t, db, nil, cfg, func(base string) {
+		t.Run("browse", func(t *testing.T) {
+			c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getMusicDirectory.view", params("id", "1")))
+
+			var titles []string
+			for _, ch := range c.MusicDirectory.Children {
+				titles = append(titles, ch.Title)
+			}
+
+			if want, got := []string{"a"}, titles; !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected children:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+
+		t.Run("random", func(t *testing.T) {
+			c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getRandomSongs.view", params()))
+
+			var titles []string
+			for _, s := range c.RandomSongs.Songs {
+				titles = append(titles, s.Title)
+			}
+
+			if want, got := []string{"a"}, titles; !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected random songs:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+
+		t.Run("albums", func(t *testing.T) {
+			c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getAlbumList.view", params("type", "alphabeticalByName")))
+
+			var ids []string
+			for _, a := range c.AlbumList.Albums {
+				ids = append(ids, a.ID)
+			}
+
+			if want, got := []string{"1"}, ids; !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected albums:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+
+		for _, id := range []string{"3", "6", "8"} {
+			t.Run("stream "+id, func(t *testing.T) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/stream.view", params("id", id)))
+				if c.Error == nil || c.Error.Code != codeNotAuthorized {
+					t.Fatalf("expected not authorized error, but got: %+v", c.Error)
+				}
+			})
+		}
+	})
+}
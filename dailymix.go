@@ -0,0 +1,323 @@
+package mpdsub
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+const (
+	// mixPlaylistPrefix is the prefix used for the IDs of daily mix playlists.
+	mixPlaylistPrefix = "mix:"
+
+	// Defaults for daily mix configuration.
+	defaultDailyMixSize    = 50
+	defaultDailyMixRefresh = 24 * time.Hour
+
+	// mixStarWeight is the number of plays a star is worth when choosing
+	// songs for daily mixes.
+	mixStarWeight = 5
+
+	// Favourites are rediscovered once they have not been played for
+	// rediscoverAge, if they are starred or were played at least
+	// rediscoverPlays times.
+	rediscoverAge   = 30 * 24 * time.Hour
+	rediscoverPlays = 3
+)
+
+// A dailyMix is an auto-generated playlist of songs, either from a single
+// genre or of favourites which have not been played in a while.
+type dailyMix struct {
+	Name    string
+	Comment string
+	Songs   []mpd.Attrs
+}
+
+// A mixCache caches generated daily mixes for each user until the end of the
+// refresh period in which they were generated.
+type mixCache struct {
+	mu     sync.Mutex
+	period time.Time
+	songs  []mpd.Attrs
+	mixes  map[string][]dailyMix
+}
+
+// dailyMixes returns user's daily mixes for the current refresh period,
+// generating them from MPD's database and user's play statistics and stars
+// if the period has elapsed.
+func (s *Server) dailyMixes(user string) ([]dailyMix, error) {
+	if s.cfg.DailyMixes <= 0 {
+		return nil, nil
+	}
+
+	refresh := s.cfg.DailyMixRefresh
+	if refresh <= 0 {
+		refresh = defaultDailyMixRefresh
+	}
+	size := s.cfg.DailyMixSize
+	if size <= 0 {
+		size = defaultDailyMixSize
+	}
+
+	period := time.Now().Truncate(refresh)
+
+	s.mixes.mu.Lock()
+	defer s.mixes.mu.Unlock()
+
+	if s.mixes.songs == nil || !s.mixes.period.Equal(period) {
+		songs, err := s.db.ListAllInfo("")
+		if err != nil {
+			return nil, err
+		}
+
+		s.mixes.period = period
+		s.mixes.songs = s.exclude.filterSongs(songs)
+		s.mixes.mixes = make(map[string][]dailyMix)
+	}
+
+	if mixes, ok := s.mixes.mixes[user]; ok {
+		return mixes, nil
+	}
+
+	l := listening{
+		key:   s.itemKey,
+		plays: s.playStats(user),
+		stars: s.stars(user),
+	}
+
+	mixes := generateMixes(s.mixes.songs, s.genres, l, s.cfg.DailyMixes, size, period)
+	s.mixes.mixes[user] = mixes
+
+	return mixes, nil
+}
+
+// mixPlaylistByID looks up a daily mix by its playlist ID and produces a
+// Subsonic playlist for it, for user.  If no mix exists with the ID, it
+// returns false.
+func (s *Server) mixPlaylistByID(user string, id string) (*playlist, bool, error) {
+	if !strings.HasPrefix(id, mixPlaylistPrefix) {
+		return nil, false, nil
+	}
+
+	n, err := strconv.Atoi(strings.TrimPrefix(id, mixPlaylistPrefix))
+	if err != nil {
+		return nil, false, nil
+	}
+
+	mixes, err := s.dailyMixes(user)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if n < 0 || n >= len(mixes) {
+		return nil, false, nil
+	}
+
+	pl, err := s.mixPlaylist(user, n, mixes[n])
+	if err != nil {
+		return nil, false, err
+	}
+
+	return pl, true, nil
+}
+
+// mixPlaylist produces a Subsonic playlist for the daily mix with index n,
+// for user.
+func (s *Server) mixPlaylist(user string, n int, m dailyMix) (*playlist, error) {
+	children, err := s.songChildren(user, m.Songs)
+	if err != nil {
+		return nil, err
+	}
+
+	pl := newPlaylist(mixPlaylistPrefix+strconv.Itoa(n), m.Name, s.cfg.SubsonicUser, true, children)
+	pl.Comment = m.Comment
+
+	return pl, nil
+}
+
+// listening is a user's play statistics and stars, keyed by key.
+type listening struct {
+	key   func(name string) string
+	plays map[string]playStats
+	stars map[string]time.Time
+}
+
+// score returns how much the user listens to the song with the specified
+// name, counting each star as mixStarWeight plays.
+func (l listening) score(name string) int {
+	k := l.key(name)
+
+	n := l.plays[k].Count
+	if _, ok := l.stars[k]; ok {
+		n += mixStarWeight
+	}
+
+	return n
+}
+
+// favourite reports whether the song with the specified name is one of the
+// user's favourites, and was last played before the specified time.
+func (l listening) favourite(name string, before time.Time) bool {
+	k := l.key(name)
+
+	ps := l.plays[k]
+	if _, ok := l.stars[k]; !ok && ps.Count < rediscoverPlays {
+		return false
+	}
+
+	return ps.Last.Before(before)
+}
+
+// generateMixes clusters songs by genre and produces up to n mixes of at most
+// size songs each, for the genres the user listens to most, followed by a
+// mix of favourites the user has not played in a while.  Genres are ranked
+// by the plays and stars of their songs, and then by their number of songs,
+// and songs the user listens to more are more likely to be chosen.  Songs
+// are chosen pseudo-randomly, seeded using period, so the same mixes are
+// produced until the next refresh period.
+func generateMixes(songs []mpd.Attrs, genres *genreMap, l listening, n int, size int, period time.Time) []dailyMix {
+	byGenre := make(map[string]*genreCluster)
+	var favourites []mpd.Attrs
+	before := period.Add(-rediscoverAge)
+
+	for _, s := range songs {
+		name := s["file"]
+		if name == "" {
+			continue
+		}
+
+		score := l.score(name)
+		for _, g := range genres.Normalize(s["Genre"]) {
+			c, ok := byGenre[g]
+			if !ok {
+				c = &genreCluster{Genre: g}
+				byGenre[g] = c
+			}
+
+			c.Songs = append(c.Songs, s)
+			c.Score += score
+		}
+
+		if l.favourite(name, before) {
+			favourites = append(favourites, s)
+		}
+	}
+
+	clusters := make([]genreCluster, 0, len(byGenre))
+	for _, c := range byGenre {
+		clusters = append(clusters, *c)
+	}
+	sort.Sort(byClusterScore(clusters))
+
+	if len(clusters) > n {
+		clusters = clusters[:n]
+	}
+
+	mixes := make([]dailyMix, 0, len(clusters)+1)
+	for i, c := range clusters {
+		mixes = append(mixes, dailyMix{
+			Name:    fmt.Sprintf("Daily Mix %d: %s", i+1, c.Genre),
+			Comment: fmt.Sprintf("Auto-generated mix of %s songs", c.Genre),
+			Songs:   pickSongs(c.Songs, l, size, mixRand(period, c.Genre)),
+		})
+	}
+
+	if len(favourites) > 0 {
+		mixes = append(mixes, dailyMix{
+			Name:    "Rediscover",
+			Comment: "Auto-generated mix of favourites you have not played in a while",
+			Songs:   pickSongs(favourites, l, size, mixRand(period, "")),
+		})
+	}
+
+	return mixes
+}
+
+// mixRand returns a random number generator for the mix with the specified
+// name in period.
+func mixRand(period time.Time, name string) *rand.Rand {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%d:%s", period.Unix(), name)
+
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+// pickSongs chooses at most size songs using rng, weighting each song by
+// one more than its score so that songs the user has never played may
+// still be chosen.
+func pickSongs(songs []mpd.Attrs, l listening, size int, rng *rand.Rand) []mpd.Attrs {
+	// Weighted sampling without replacement: each song is ordered by a
+	// random key u^(1/w), and the songs with the largest keys are chosen
+	weighted := make([]weightedSong, 0, len(songs))
+	for _, s := range songs {
+		w := float64(l.score(s["file"]) + 1)
+		weighted = append(weighted, weightedSong{
+			Song: s,
+			Key:  math.Pow(rng.Float64(), 1/w),
+		})
+	}
+	sort.Sort(byWeightKey(weighted))
+
+	if len(weighted) > size {
+		weighted = weighted[:size]
+	}
+
+	picked := make([]mpd.Attrs, 0, len(weighted))
+	for _, w := range weighted {
+		picked = append(picked, w.Song)
+	}
+
+	return picked
+}
+
+// A weightedSong is a song with a random key used for weighted sampling.
+type weightedSong struct {
+	Song mpd.Attrs
+	Key  float64
+}
+
+// byWeightKey sorts weightedSongs by descending key, and then by file name.
+type byWeightKey []weightedSong
+
+func (b byWeightKey) Len() int      { return len(b) }
+func (b byWeightKey) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byWeightKey) Less(i, j int) bool {
+	if b[i].Key != b[j].Key {
+		return b[i].Key > b[j].Key
+	}
+
+	return b[i].Song["file"] < b[j].Song["file"]
+}
+
+// A genreCluster is a group of songs which share a genre, and the sum of
+// the scores of its songs.
+type genreCluster struct {
+	Genre string
+	Songs []mpd.Attrs
+	Score int
+}
+
+// byClusterScore sorts genreClusters by descending score, then by descending
+// number of songs, and then by genre name.
+type byClusterScore []genreCluster
+
+func (b byClusterScore) Len() int      { return len(b) }
+func (b byClusterScore) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byClusterScore) Less(i, j int) bool {
+	if b[i].Score != b[j].Score {
+		return b[i].Score > b[j].Score
+	}
+	if len(b[i].Songs) != len(b[j].Songs) {
+		return len(b[i].Songs) > len(b[j].Songs)
+	}
+
+	return b[i].Genre < b[j].Genre
+}
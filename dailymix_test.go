@@ -0,0 +1,161 @@
+package mpdsub
+
+import (
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func Test_generateMixes(t *testing.T) {
+	songs := []mpd.Attrs{
+		{"file": "a.mp3", "Genre": "Rock"},
+		{"file": "b.mp3", "Genre": "Rock"},
+		{"file": "c.mp3", "Genre": "rock; Jazz"},
+		{"file": "d.mp3", "Genre": "Jazz"},
+		{"file": "e.mp3", "Genre": "Pop"},
+		{"file": "f.mp3"},
+	}
+
+	genres := newGenreMap(map[string]string{"rock": "Rock"}, ";")
+	period := time.Date(2016, time.November, 4, 0, 0, 0, 0, time.UTC)
+
+	l := listening{key: func(name string) string { return name }}
+	mixes := generateMixes(songs, genres, l, 2, 2, period)
+
+	if want, got := 2, len(mixes); want != got {
+		t.Fatalf("unexpected number of mixes:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	for i, g := range []string{"Rock", "Jazz"} {
+		if want, got := "Daily Mix "+strconv.Itoa(i+1)+": "+g, mixes[i].Name; want != got {
+			t.Fatalf("unexpected mix name:\n- want: %q\n-  got: %q", want, got)
+		}
+
+		if want, got := 2, len(mixes[i].Songs); want != got {
+			t.Fatalf("unexpected number of songs in mix:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+
+	// The same period must always produce the same mixes
+	again := generateMixes(songs, genres, l, 2, 2, period)
+	for i := range mixes {
+		for j := range mixes[i].Songs {
+			if want, got := mixes[i].Songs[j]["file"], again[i].Songs[j]["file"]; want != got {
+				t.Fatalf("unexpected song in regenerated mix:\n- want: %q\n-  got: %q", want, got)
+			}
+		}
+	}
+}
+
+func Test_generateMixesListening(t *testing.T) {
+	songs := []mpd.Attrs{
+		{"file": "a.mp3", "Genre": "Rock"},
+		{"file": "b.mp3", "Genre": "Rock"},
+		{"file": "c.mp3", "Genre": "Rock"},
+		{"file": "d.mp3", "Genre": "Jazz"},
+		{"file": "e.mp3", "Genre": "Jazz"},
+	}
+
+	genres := newGenreMap(nil, "")
+	period := time.Date(2016, time.November, 4, 0, 0, 0, 0, time.UTC)
+
+	l := listening{
+		key: func(name string) string { return name },
+		plays: map[string]playStats{
+			// Played often, but long ago
+			"d.mp3": {Count: 10, Last: period.Add(-60 * 24 * time.Hour)},
+			// Played often and recently
+			"e.mp3": {Count: 10, Last: period.Add(-time.Hour)},
+		},
+		stars: map[string]time.Time{
+			// Starred, but never played
+			"a.mp3": period.Add(-time.Hour),
+		},
+	}
+
+	mixes := generateMixes(songs, genres, l, 1, 10, period)
+
+	// Jazz is listened to more than Rock, despite having fewer songs, and
+	// the rediscovery mix follows the genre mixes
+	var names []string
+	for _, m := range mixes {
+		names = append(names, m.Name)
+	}
+
+	if want, got := []string{"Daily Mix 1: Jazz", "Rediscover"}, names; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected mixes:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	var files []string
+	for _, s := range mixes[1].Songs {
+		files = append(files, s["file"])
+	}
+	sort.Strings(files)
+
+	if want, got := []string{"a.mp3", "d.mp3"}, files; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected rediscovered songs:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_pickSongs(t *testing.T) {
+	songs := make([]mpd.Attrs, 0, 100)
+	for i := 0; i < 100; i++ {
+		songs = append(songs, mpd.Attrs{"file": strconv.Itoa(i)})
+	}
+
+	l := listening{
+		key:   func(name string) string { return name },
+		plays: map[string]playStats{"0": {Count: 1000}},
+	}
+
+	// A song played far more than any other is almost always chosen
+	var n int
+	for i := 0; i < 100; i++ {
+		for _, s := range pickSongs(songs, l, 10, rand.New(rand.NewSource(int64(i)))) {
+			if s["file"] == "0" {
+				n++
+			}
+		}
+	}
+
+	if n < 95 {
+		t.Fatalf("favourite song chosen too rarely: %d of 100 mixes", n)
+	}
+}
+
+func TestServer_getPlaylistDailyMix(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{"a.mp3", "b.mp3", "c.mp3"},
+		songs: []mpd.Attrs{
+			{"file": "a.mp3", "Genre": "Rock"},
+			{"file": "b.mp3", "Genre": "Rock"},
+			{"file": "c.mp3", "Genre": "Jazz"},
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.DailyMixes = 1
+	values.Set("id", "mix:0")
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getPlaylist.view", values))
+
+		if c.Playlist == nil {
+			t.Fatal("playlist is nil")
+		}
+
+		if want, got := "Daily Mix 1: Rock", c.Playlist.Name; want != got {
+			t.Fatalf("unexpected playlist name:\n- want: %q\n-  got: %q", want, got)
+		}
+
+		if want, got := 2, len(c.Playlist.Entries); want != got {
+			t.Fatalf("unexpected number of playlist entries:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
@@ -0,0 +1,265 @@
+package mpdsub
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// canDownload reports whether user may download original files.  Users who
+// cannot download may still stream files.
+func (s *Server) canDownload(user string) bool {
+	if s.cfg.DownloadUsers == nil {
+		return true
+	}
+
+	for _, u := range s.cfg.DownloadUsers {
+		if u == user {
+			return true
+		}
+	}
+
+	return false
+}
+
+// download is used in Subsonic to download the original, untranscoded file,
+// which requires the download role.
+// If the ID refers to a directory, the files within it are downloaded as a
+// zip archive.  If the ID refers to a playlist, the playlist is exported as
+// described by downloadPlaylist.
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	qID := r.URL.Query().Get("id")
+	if qID == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	// Exported playlists only contain stream URLs, so they are available
+	// to users who cannot download original files
+	if isPlaylistID(qID) {
+		s.downloadPlaylist(w, r, qID)
+		return
+	}
+
+	user := requestContextFrom(r).User
+	if u, ok := s.lookupUser(user); !ok || !s.effectiveRoles(u).Download {
+		writeXML(w, errNotAuthorized)
+		return
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		s.logf("error listing files from mpd for downloading: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+	files := indexFiles(fs)
+
+	id, ok := s.parseID(qID, len(files))
+	if !ok {
+		writeXML(w, errGeneric)
+		return
+	}
+	if id >= len(files) {
+		writeXML(w, errNotFound)
+		return
+	}
+
+	f := files[id]
+	if !s.canAccess(w, user, f) {
+		return
+	}
+
+	if !f.Dir {
+		w.Header().Set("Content-Disposition", attachment(path.Base(f.Name)))
+		s.serveFile(w, r, s.musicPath(f.Name))
+		return
+	}
+
+	// Collect the files within the directory, hiding excluded items unless
+	// downloading within an excluded directory
+	var dl []indexedFile
+	for _, ff := range files {
+		if ff.Dir || !strings.HasPrefix(ff.Name, f.Name+"/") {
+			continue
+		}
+
+		hidden, err := s.filteredSong(user, ff.Name)
+		if err != nil {
+			s.logf("error checking content filter for %q: %v", ff.Name, err)
+			writeXML(w, errGeneric)
+			return
+		}
+		if !hidden && s.visible(user, ff.Name) {
+			dl = append(dl, ff)
+		}
+	}
+	if !s.exclude.Excluded(f.Name) {
+		dl = s.exclude.filterIndexed(dl)
+	}
+
+	w.Header().Set(contentType, "application/zip")
+	w.Header().Set("Content-Disposition", attachment(path.Base(f.Name)+".zip"))
+
+	if err := s.writeZip(w, path.Dir(f.Name), dl); err != nil {
+		s.logf("error writing zip archive for %q: %v", f.Name, err)
+	}
+}
+
+// writeZip writes a zip archive containing files to w.  Files are named
+// relative to the directory base.
+func (s *Server) writeZip(w io.Writer, base string, files []indexedFile) error {
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		name := f.Name
+		if base != "." {
+			name = strings.TrimPrefix(name, base+"/")
+		}
+
+		// Audio files are already compressed, so store them as-is
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   name,
+			Method: zip.Store,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := s.copyFile(fw, f.Name); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// copyFile copies the contents of a file in MPD's music directory to w.
+func (s *Server) copyFile(w io.Writer, name string) error {
+	p := s.musicPath(name)
+
+	var rc io.ReadCloser
+	if s.musicURL != nil {
+		res, err := s.streamClient.Get(p)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusOK {
+			_ = res.Body.Close()
+			return fmt.Errorf("unexpected HTTP status for %q: %s", p, res.Status)
+		}
+
+		rc = res.Body
+	} else {
+		f, err := s.fs.Open(p)
+		if err != nil {
+			return err
+		}
+
+		rc = f
+	}
+	defer rc.Close()
+
+	_, err := io.Copy(w, rc)
+	return err
+}
+
+// downloadPlaylist exports a playlist as a M3U8 file containing a stream URL
+// for each song, so that the playlist can be played by players which do not
+// support Subsonic.  Each URL carries a single-use stream token rather than
+// the credentials of the request.  Tokens remain valid for the stream token
+// TTL plus the duration of the songs before them, so that the playlist can
+// be played through once.
+func (s *Server) downloadPlaylist(w http.ResponseWriter, r *http.Request, id string) {
+	if s.externalURL == nil {
+		s.logf("cannot export playlist %q: external URL not configured", id)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	rctx := requestContextFrom(r)
+	pl, ok, err := s.playlist(rctx.User, id)
+	if err != nil {
+		s.logf("error building playlist %q: %v", id, err)
+		writeXML(w, errGeneric)
+		return
+	}
+	if !ok {
+		writeXML(w, errNotFound)
+		return
+	}
+
+	stream := *s.externalURL
+	stream.Path += "rest/stream.view"
+
+	now := time.Now()
+	expires := now.Add(s.streamTokenTTL())
+
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&buf, "#PLAYLIST:%s\n", m3uText(pl.Name))
+
+	for _, e := range pl.Entries {
+		token, err := s.streamTokens.issue(streamToken{
+			ID:      e.ID,
+			User:    rctx.User,
+			Client:  rctx.Client,
+			Expires: expires,
+		}, now)
+		if err != nil {
+			s.logf("error creating stream token for playlist %q: %v", id, err)
+			writeXML(w, errGeneric)
+			return
+		}
+		expires = expires.Add(time.Duration(e.Duration) * time.Second)
+
+		v := url.Values{}
+		v.Set("id", e.ID)
+		v.Set("streamToken", token)
+		stream.RawQuery = v.Encode()
+
+		title := e.Title
+		if e.Artist != "" {
+			title = e.Artist + " - " + title
+		}
+
+		fmt.Fprintf(&buf, "#EXTINF:%d,%s\n", e.Duration, m3uText(title))
+		fmt.Fprintf(&buf, "%s\n", stream.String())
+	}
+
+	w.Header().Set(contentType, "audio/x-mpegurl; charset=utf-8")
+	w.Header().Set("Content-Disposition", attachment(pl.Name+".m3u8"))
+	_, _ = buf.WriteTo(w)
+}
+
+// isPlaylistID reports whether id is the ID of a playlist, rather than of a
+// file or directory.
+func isPlaylistID(id string) bool {
+	for _, p := range []string{storedPlaylistPrefix, m3uPlaylistPrefix, smartPlaylistPrefix, mixPlaylistPrefix} {
+		if strings.HasPrefix(id, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// m3uText removes line breaks from text written to a M3U file, which would
+// otherwise start a new entry.
+func m3uText(text string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(text)
+}
+
+// attachment creates a Content-Disposition header value for a file
+// download named filename.
+func attachment(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{
+		"filename": filename,
+	})
+}
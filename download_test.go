@@ -0,0 +1,278 @@
+package mpdsub
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_download(t *testing.T) {
+	const musicDirectory = "/var/music"
+
+	db := &memoryDatabase{
+		files: []string{
+			"foo/bar/a.mp3",
+			"foo/bar/b.mp3",
+			"foo/c.mp3",
+		},
+	}
+
+	newFS := func() *memoryFilesystem {
+		return &memoryFilesystem{
+			files: map[string]*memoryFile{
+				filepath.Join(musicDirectory, "foo/bar/a.mp3"): {ReadSeeker: strings.NewReader("a")},
+				filepath.Join(musicDirectory, "foo/bar/b.mp3"): {ReadSeeker: strings.NewReader("b")},
+				filepath.Join(musicDirectory, "foo/c.mp3"):     {ReadSeeker: strings.NewReader("c")},
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		id      string
+		users   []string
+		err     bool
+		code    int
+		dispo   string
+		body    string
+		archive []string
+	}{
+		{
+			name:  "file",
+			id:    "2",
+			dispo: `attachment; filename=a.mp3`,
+			body:  "a",
+		},
+		{
+			name:    "directory",
+			id:      "1",
+			dispo:   `attachment; filename=bar.zip`,
+			archive: []string{"bar/a.mp3", "bar/b.mp3"},
+		},
+		{
+			name: "out of bounds",
+			id:   "10",
+			err:  true,
+			code: codeNotFound,
+		},
+		{
+			name:  "not permitted",
+			id:    "2",
+			users: []string{"other"},
+			err:   true,
+			code:  codeNotAuthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.MusicDirectory = musicDirectory
+			cfg.DownloadUsers = tt.users
+			values.Set("id", tt.id)
+
+			withServer(t, db, newFS(), cfg, func(base string) {
+				res := testRequest(t, base, http.MethodGet, "/rest/download.view", values)
+
+				if tt.err {
+					c := mustDecodeXML(t, res)
+					if c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+
+					if want, got := tt.code, c.Error.Code; want != got {
+						t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v",
+							want, got)
+					}
+
+					return
+				}
+
+				if want, got := tt.dispo, res.Header.Get("Content-Disposition"); want != got {
+					t.Fatalf("unexpected Content-Disposition:\n- want: %v\n-  got: %v",
+						want, got)
+				}
+
+				b, err := ioutil.ReadAll(res.Body)
+				if err != nil {
+					t.Fatalf("failed to read body: %v", err)
+				}
+
+				if tt.archive == nil {
+					if want, got := tt.body, string(b); want != got {
+						t.Fatalf("unexpected body:\n- want: %q\n-  got: %q",
+							want, got)
+					}
+
+					return
+				}
+
+				zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+				if err != nil {
+					t.Fatalf("failed to read zip archive: %v", err)
+				}
+
+				var names []string
+				for _, f := range zr.File {
+					names = append(names, f.Name)
+				}
+				sort.Strings(names)
+
+				if want, got := strings.Join(tt.archive, ","), strings.Join(names, ","); want != got {
+					t.Fatalf("unexpected archive contents:\n- want: %v\n-  got: %v",
+						want, got)
+				}
+			})
+		})
+	}
+}
+
+func TestServer_streamWithoutDownload(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{"foo.mp3"},
+	}
+	fs := &memoryFilesystem{
+		files: map[string]*memoryFile{
+			"foo.mp3": {ReadSeeker: strings.NewReader("foo")},
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.DownloadUsers = []string{"other"}
+	values.Set("id", "0")
+
+	withServer(t, db, fs, cfg, func(base string) {
+		res := testRequest(t, base, http.MethodGet, "/rest/stream.view", values)
+
+		b, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+
+		if want, got := "foo", string(b); want != got {
+			t.Fatalf("unexpected body:\n- want: %q\n-  got: %q",
+				want, got)
+		}
+	})
+}
+
+func TestServer_downloadPlaylist(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{"a.mp3", "b.mp3"},
+		songs: []mpd.Attrs{
+			{"file": "a.mp3", "Artist": "Artist", "Title": "A", "duration": "61.5"},
+			{"file": "b.mp3", "Title": "B\nwith a break"},
+		},
+		playlists: map[string][]mpd.Attrs{
+			"mine": {
+				{"file": "b.mp3", "Title": "B\nwith a break"},
+				{"file": "a.mp3", "Artist": "Artist", "Title": "A", "duration": "61.5"},
+			},
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.ExternalURL = "https://music.example.com/subsonic"
+
+	// Users who cannot download original files may still export playlists
+	cfg.DownloadUsers = []string{}
+
+	withServer(t, db, nil, cfg, func(base string) {
+		values.Set("id", "pl:nope")
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/download.view", values))
+		if want, got := codeNotFound, c.Error.Code; want != got {
+			t.Fatalf("unexpected XML error code:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		values.Set("id", "pl:mine")
+		res := testRequest(t, base, http.MethodGet, "/rest/download.view", values)
+		defer res.Body.Close()
+
+		if want, got := "audio/x-mpegurl; charset=utf-8", res.Header.Get(contentType); want != got {
+			t.Fatalf("unexpected Content-Type:\n- want: %q\n-  got: %q", want, got)
+		}
+		if want, got := `attachment; filename=mine.m3u8`, res.Header.Get("Content-Disposition"); want != got {
+			t.Fatalf("unexpected Content-Disposition:\n- want: %q\n-  got: %q", want, got)
+		}
+
+		b, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+		if want, got := 6, len(lines); want != got {
+			t.Fatalf("unexpected number of lines:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		want := []string{
+			"#EXTM3U",
+			"#PLAYLIST:mine",
+			"#EXTINF:0,B with a break",
+			"#EXTINF:61,Artist - A",
+		}
+		if got := []string{lines[0], lines[1], lines[2], lines[4]}; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected playlist:\n- want: %q\n-  got: %q", want, got)
+		}
+
+		// Each entry carries its own stream token, and never the
+		// credentials of the request
+		tokens := make(map[string]struct{})
+		for i, id := range map[int]string{3: "1", 5: "0"} {
+			u, err := url.Parse(lines[i])
+			if err != nil {
+				t.Fatalf("failed to parse stream URL: %v", err)
+			}
+
+			if want, got := "https://music.example.com/subsonic/rest/stream.view", u.Scheme+"://"+u.Host+u.Path; want != got {
+				t.Fatalf("unexpected stream URL:\n- want: %v\n-  got: %v", want, got)
+			}
+
+			q := u.Query()
+			if want, got := id, q.Get("id"); want != got {
+				t.Fatalf("unexpected ID:\n- want: %v\n-  got: %v", want, got)
+			}
+			for k := range authParameters {
+				if v := q.Get(k); v != "" {
+					t.Fatalf("stream URL carries credential %q: %q", k, v)
+				}
+			}
+
+			token := q.Get("streamToken")
+			if token == "" {
+				t.Fatal("stream URL has no stream token")
+			}
+			tokens[token] = struct{}{}
+		}
+		if want, got := 2, len(tokens); want != got {
+			t.Fatalf("unexpected number of stream tokens:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
+
+func TestServer_downloadPlaylistNoExternalURL(t *testing.T) {
+	db := &memoryDatabase{
+		playlists: map[string][]mpd.Attrs{
+			"mine": {{"file": "a.mp3"}},
+		},
+	}
+
+	cfg, values := configAuth()
+	values.Set("id", "pl:mine")
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/download.view", values))
+		if want, got := codeGeneric, c.Error.Code; want != got {
+			t.Fatalf("unexpected XML error code:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
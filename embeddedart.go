@@ -0,0 +1,478 @@
+package mpdsub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path/filepath"
+)
+
+// maxEmbeddedArtwork is the maximum size in bytes of a picture embedded in a
+// song which will be read, so that a corrupt tag cannot exhaust memory.
+const maxEmbeddedArtwork = 16 << 20
+
+// pictureFrontCover is the picture type of a front cover, shared by ID3v2
+// APIC frames and FLAC PICTURE blocks.
+const pictureFrontCover = 3
+
+// errBadTag is returned when a song's tags cannot be parsed.
+var errBadTag = errors.New("malformed tag")
+
+var _ artworkSource = &embeddedArtwork{}
+
+// An embeddedArtwork is an artworkSource which extracts pictures embedded in
+// songs in the music directory: ID3v2 APIC frames, FLAC PICTURE blocks, and
+// MP4 covr atoms.  Unlike mpdArtwork, it does not depend on MPD 0.22's
+// readpicture command, but requires MusicDirectory to be available locally.
+type embeddedArtwork struct {
+	db   database
+	fs   filesystem
+	root string
+}
+
+// Artwork implements artworkSource.  For directories, the picture embedded
+// in the first song within the directory is used.
+func (a *embeddedArtwork) Artwork(name string, dir bool) ([]byte, error) {
+	if dir {
+		f, err := firstSong(a.db, name)
+		if err != nil {
+			return nil, err
+		}
+		name = f
+	}
+
+	f, err := a.fs.Open(filepath.Join(a.root, filepath.FromSlash(name)))
+	if err != nil {
+		// Songs which cannot be read have no artwork of their own
+		return nil, errNoArtwork
+	}
+	defer f.Close()
+
+	b, err := readEmbeddedPicture(f)
+	switch {
+	case err == errBadTag || err == io.EOF || err == io.ErrUnexpectedEOF:
+		return nil, errNoArtwork
+	case err != nil:
+		return nil, err
+	case len(b) == 0:
+		return nil, errNoArtwork
+	}
+
+	return b, nil
+}
+
+// readEmbeddedPicture reads the picture embedded in a song, detecting its
+// format by its magic number.  Front covers are preferred over other
+// pictures.  If the song has no picture, it returns nil.
+func readEmbeddedPicture(r io.ReadSeeker) ([]byte, error) {
+	magic := make([]byte, 8)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("ID3")):
+		return readID3Picture(r)
+	case bytes.HasPrefix(magic, []byte("fLaC")):
+		return readFLACPicture(r)
+	case bytes.Equal(magic[4:8], []byte("ftyp")):
+		return readMP4Picture(r)
+	default:
+		return nil, nil
+	}
+}
+
+// readID3Picture reads the picture in an ID3v2 tag's APIC frames, or in the
+// PIC frames of an ID3v2.2 tag.
+func readID3Picture(r io.Reader) ([]byte, error) {
+	var picture []byte
+	err := walkID3Frames(r, func(id string, frame []byte, version byte) (bool, error) {
+		if id != "APIC" && id != "PIC" {
+			return false, nil
+		}
+
+		typ, b, err := parseID3Picture(frame, version)
+		if err != nil {
+			return false, err
+		}
+		if typ == pictureFrontCover {
+			picture = b
+			return true, nil
+		}
+		if picture == nil {
+			picture = b
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return picture, nil
+}
+
+// walkID3Frames invokes fn with the ID and contents of each frame in an
+// ID3v2 tag, and the tag's major version, until fn returns true or an
+// error.  ID3v2.2 frames have three character IDs.
+func walkID3Frames(r io.Reader, fn func(id string, frame []byte, version byte) (bool, error)) error {
+	h := make([]byte, 10)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return err
+	}
+
+	version, flags := h[3], h[5]
+	size := syncsafe(h[6:10])
+	if version < 2 || version > 4 || size > maxEmbeddedArtwork {
+		return errBadTag
+	}
+
+	tag := make([]byte, size)
+	if _, err := io.ReadFull(r, tag); err != nil {
+		return err
+	}
+
+	// ID3v2.4 unsynchronizes each frame instead of the whole tag
+	if flags&0x80 != 0 && version < 4 {
+		tag = bytes.Replace(tag, []byte{0xff, 0x00}, []byte{0xff}, -1)
+	}
+
+	if flags&0x40 != 0 && version > 2 {
+		if len(tag) < 4 {
+			return errBadTag
+		}
+
+		n := int(binary.BigEndian.Uint32(tag[:4]))
+		if version == 4 {
+			n = syncsafe(tag[:4])
+		} else {
+			// ID3v2.3 does not include the size field itself
+			n += 4
+		}
+		if n > len(tag) {
+			return errBadTag
+		}
+		tag = tag[n:]
+	}
+
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	for len(tag) >= headerLen && tag[0] != 0 {
+		id := string(tag[:idLen])
+
+		var n int
+		switch version {
+		case 2:
+			n = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+		case 3:
+			n = int(binary.BigEndian.Uint32(tag[4:8]))
+		case 4:
+			n = syncsafe(tag[4:8])
+		}
+		if n < 0 || n > len(tag)-headerLen {
+			return errBadTag
+		}
+
+		var format byte
+		if version == 4 {
+			format = tag[9]
+		}
+
+		frame := tag[headerLen : headerLen+n]
+		tag = tag[headerLen+n:]
+
+		// ID3v2.4 frames may be prefixed with their decoded length, and
+		// unsynchronized individually
+		if format&0x01 != 0 {
+			if len(frame) < 4 {
+				return errBadTag
+			}
+			frame = frame[4:]
+		}
+		if format&0x02 != 0 {
+			frame = bytes.Replace(frame, []byte{0xff, 0x00}, []byte{0xff}, -1)
+		}
+
+		stop, err := fn(id, frame, version)
+		if err != nil || stop {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseID3Picture parses the contents of an APIC or PIC frame, returning the
+// picture's type and data.
+func parseID3Picture(frame []byte, version byte) (byte, []byte, error) {
+	if len(frame) < 1 {
+		return 0, nil, errBadTag
+	}
+	enc, frame := frame[0], frame[1:]
+
+	// ID3v2.2 uses a three character image format instead of a MIME type
+	if version == 2 {
+		if len(frame) < 3 {
+			return 0, nil, errBadTag
+		}
+		frame = frame[3:]
+	} else {
+		i := bytes.IndexByte(frame, 0)
+		if i < 0 {
+			return 0, nil, errBadTag
+		}
+		frame = frame[i+1:]
+	}
+
+	if len(frame) < 1 {
+		return 0, nil, errBadTag
+	}
+	typ, frame := frame[0], frame[1:]
+
+	frame, ok := skipID3String(frame, enc)
+	if !ok {
+		return 0, nil, errBadTag
+	}
+
+	return typ, frame, nil
+}
+
+// skipID3String skips a string terminated according to its ID3v2 text
+// encoding, such as the description of a picture, and returns the remainder
+// of b.  UTF-16 strings end with two zero bytes on a character boundary.
+func skipID3String(b []byte, enc byte) ([]byte, bool) {
+	switch enc {
+	case 1, 2:
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return b[i+2:], true
+			}
+		}
+		return nil, false
+	default:
+		i := bytes.IndexByte(b, 0)
+		if i < 0 {
+			return nil, false
+		}
+		return b[i+1:], true
+	}
+}
+
+// syncsafe decodes a 28-bit synchsafe integer used by ID3v2.
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// readFLACPicture reads the picture in a FLAC stream's PICTURE metadata
+// blocks.
+func readFLACPicture(r io.ReadSeeker) ([]byte, error) {
+	const blockPicture = 6
+
+	var picture []byte
+	err := walkFLACBlocks(r, blockPicture, func(block []byte) (bool, error) {
+		typ, b, err := parseFLACPicture(block)
+		if err != nil {
+			return false, err
+		}
+		if typ == pictureFrontCover {
+			picture = b
+			return true, nil
+		}
+		if picture == nil {
+			picture = b
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return picture, nil
+}
+
+// walkFLACBlocks invokes fn with the contents of each metadata block of a
+// FLAC stream with the specified type, until fn returns true or an error.
+// Blocks of other types are skipped without being read.
+func walkFLACBlocks(r io.ReadSeeker, typ byte, fn func(block []byte) (bool, error)) error {
+	if _, err := r.Seek(4, io.SeekStart); err != nil {
+		return err
+	}
+
+	h := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, h); err != nil {
+			return err
+		}
+
+		last := h[0]&0x80 != 0
+		n := int(h[1])<<16 | int(h[2])<<8 | int(h[3])
+
+		if h[0]&0x7f != typ {
+			if last {
+				return nil
+			}
+			if _, err := r.Seek(int64(n), io.SeekCurrent); err != nil {
+				return err
+			}
+			continue
+		}
+
+		block := make([]byte, n)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return err
+		}
+
+		stop, err := fn(block)
+		if err != nil || stop || last {
+			return err
+		}
+	}
+}
+
+// parseFLACPicture parses a FLAC PICTURE metadata block, returning the
+// picture's type and data.
+func parseFLACPicture(b []byte) (uint32, []byte, error) {
+	// next consumes a length-prefixed field
+	next := func() ([]byte, bool) {
+		if len(b) < 4 {
+			return nil, false
+		}
+		n := binary.BigEndian.Uint32(b[:4])
+		if uint64(n) > uint64(len(b)-4) {
+			return nil, false
+		}
+		v := b[4 : 4+n]
+		b = b[4+n:]
+		return v, true
+	}
+
+	if len(b) < 4 {
+		return 0, nil, errBadTag
+	}
+	typ := binary.BigEndian.Uint32(b[:4])
+	b = b[4:]
+
+	// MIME type and description
+	for i := 0; i < 2; i++ {
+		if _, ok := next(); !ok {
+			return 0, nil, errBadTag
+		}
+	}
+
+	// Width, height, color depth, and number of colors
+	if len(b) < 16 {
+		return 0, nil, errBadTag
+	}
+	b = b[16:]
+
+	data, ok := next()
+	if !ok {
+		return 0, nil, errBadTag
+	}
+
+	return typ, data, nil
+}
+
+// readMP4Picture reads the picture in the covr atom of an MP4 file's iTunes
+// metadata, at moov.udta.meta.ilst.covr.data.
+func readMP4Picture(r io.ReadSeeker) ([]byte, error) {
+	return readMP4Item(r, "covr")
+}
+
+// readMP4Item reads the data of an item in an MP4 file's iTunes metadata,
+// at moov.udta.meta.ilst.<item>.data.  If the item does not exist, it
+// returns nil.
+func readMP4Item(r io.ReadSeeker, item string) ([]byte, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	// Descend into each atom on the path, skipping its siblings
+	var pos int64
+	for _, want := range []string{"moov", "udta", "meta", "ilst", item, "data"} {
+		found := false
+		for pos < end {
+			if _, err := r.Seek(pos, io.SeekStart); err != nil {
+				return nil, err
+			}
+
+			typ, size, header, err := readMP4Atom(r)
+			if err != nil {
+				return nil, err
+			}
+			if size == 0 {
+				size = end - pos
+			}
+			if pos+size > end {
+				return nil, errBadTag
+			}
+
+			if typ != want {
+				pos += size
+				continue
+			}
+
+			end = pos + size
+			pos += header
+			found = true
+
+			// meta is a full atom with version and flags
+			if typ == "meta" {
+				pos += 4
+			}
+			break
+		}
+		if !found {
+			return nil, nil
+		}
+	}
+
+	// The data atom begins with its data type and locale
+	n := end - pos - 8
+	if n < 0 || n > maxEmbeddedArtwork {
+		return nil, errBadTag
+	}
+	if _, err := r.Seek(pos+8, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// readMP4Atom reads the header of an MP4 atom, returning its type, its size
+// including the header, and the size of the header.  A size of 0 indicates
+// that the atom extends to the end of the file.
+func readMP4Atom(r io.Reader) (string, int64, int64, error) {
+	h := make([]byte, 8)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return "", 0, 0, err
+	}
+
+	size, header := int64(binary.BigEndian.Uint32(h[:4])), int64(8)
+	if size == 1 {
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return "", 0, 0, err
+		}
+		size, header = int64(binary.BigEndian.Uint64(ext)), 16
+	}
+	if size != 0 && size < header {
+		return "", 0, 0, errBadTag
+	}
+
+	return string(h[4:8]), size, header, nil
+}
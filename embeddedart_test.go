@@ -0,0 +1,262 @@
+package mpdsub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func Test_readEmbeddedPicture(t *testing.T) {
+	var (
+		front = []byte("\xff\xd8\xff\xe0front")
+		back  = []byte("\x89PNG\r\n\x1a\nback")
+	)
+
+	tests := []struct {
+		name string
+		b    []byte
+		want []byte
+	}{
+		{
+			name: "ID3v2.3 front cover",
+			b: id3Tag(3,
+				id3Frame(3, "TIT2", []byte("\x00title")),
+				id3Frame(3, "APIC", apicFrame(0, 4, back)),
+				id3Frame(3, "APIC", apicFrame(0, pictureFrontCover, front)),
+			),
+			want: front,
+		},
+		{
+			name: "ID3v2.4 UTF-16 description",
+			b: id3Tag(4,
+				id3Frame(4, "APIC", apicFrame(1, pictureFrontCover, front)),
+			),
+			want: front,
+		},
+		{
+			name: "ID3v2.3 other picture",
+			b: id3Tag(3,
+				id3Frame(3, "APIC", apicFrame(0, 4, back)),
+			),
+			want: back,
+		},
+		{
+			name: "ID3v2.3 no picture",
+			b: id3Tag(3,
+				id3Frame(3, "TIT2", []byte("\x00title")),
+			),
+		},
+		{
+			name: "FLAC",
+			b: flacStream(
+				flacBlock(0, false, make([]byte, 34)),
+				flacBlock(6, false, flacPicture(4, back)),
+				flacBlock(6, true, flacPicture(pictureFrontCover, front)),
+			),
+			want: front,
+		},
+		{
+			name: "FLAC no picture",
+			b: flacStream(
+				flacBlock(0, true, make([]byte, 34)),
+			),
+		},
+		{
+			name: "MP4",
+			b: append(mp4Atom("ftyp", []byte("M4A \x00\x00\x00\x00")),
+				mp4Atom("moov",
+					mp4Atom("mvhd", make([]byte, 8)),
+					mp4Atom("udta",
+						mp4Atom("meta", make([]byte, 4),
+							mp4Atom("hdlr", make([]byte, 8)),
+							mp4Atom("ilst",
+								mp4Atom("\xa9nam", mp4Atom("data", make([]byte, 8), []byte("title"))),
+								mp4Atom("covr", mp4Atom("data", make([]byte, 8), front)),
+							),
+						),
+					),
+				)...,
+			),
+			want: front,
+		},
+		{
+			name: "MP4 no picture",
+			b: append(mp4Atom("ftyp", []byte("M4A \x00\x00\x00\x00")),
+				mp4Atom("moov", mp4Atom("mvhd", make([]byte, 8)))...,
+			),
+		},
+		{
+			name: "unknown format",
+			b:    []byte("OggS\x00\x02\x00\x00"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readEmbeddedPicture(bytes.NewReader(tt.b))
+			if err != nil {
+				t.Fatalf("failed to read picture: %v", err)
+			}
+
+			if want := tt.want; !bytes.Equal(want, got) {
+				t.Fatalf("unexpected picture:\n- want: %q\n-  got: %q", want, got)
+			}
+		})
+	}
+}
+
+func Test_readEmbeddedPictureMalformed(t *testing.T) {
+	// A frame which claims to extend beyond the end of the tag
+	frame := id3Frame(3, "APIC", apicFrame(0, pictureFrontCover, []byte("front")))
+	binary.BigEndian.PutUint32(frame[4:8], 1<<20)
+
+	if _, err := readEmbeddedPicture(bytes.NewReader(id3Tag(3, frame))); err != errBadTag {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errBadTag, err)
+	}
+}
+
+func Test_embeddedArtwork(t *testing.T) {
+	front := []byte("\xff\xd8\xff\xe0front")
+
+	db := &memoryDatabase{
+		songs: []mpd.Attrs{
+			{"file": "foo/foo.mp3"},
+			{"file": "bar/bar.mp3"},
+		},
+	}
+
+	// newFS creates a filesystem with unread songs
+	newFS := func() filesystem {
+		return &memoryFilesystem{
+			files: map[string]*memoryFile{
+				filepath.Join("/music", "foo", "foo.mp3"): {ReadSeeker: bytes.NewReader(id3Tag(3,
+					id3Frame(3, "APIC", apicFrame(0, pictureFrontCover, front)),
+				))},
+				filepath.Join("/music", "bar", "bar.mp3"): {ReadSeeker: bytes.NewReader(id3Tag(3))},
+			},
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		dir  bool
+		want []byte
+	}{
+		{name: "foo/foo.mp3", want: front},
+		{name: "foo", dir: true, want: front},
+		{name: "bar/bar.mp3"},
+		{name: "baz/baz.mp3"},
+	} {
+		a := &embeddedArtwork{db: db, fs: newFS(), root: "/music"}
+
+		got, err := a.Artwork(tt.name, tt.dir)
+		if tt.want == nil {
+			if err != errNoArtwork {
+				t.Fatalf("unexpected error for %q:\n- want: %v\n-  got: %v", tt.name, errNoArtwork, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("failed to retrieve artwork for %q: %v", tt.name, err)
+		}
+
+		if !bytes.Equal(tt.want, got) {
+			t.Fatalf("unexpected artwork for %q:\n- want: %q\n-  got: %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+// id3Tag builds an ID3v2 tag of the specified version containing frames.
+func id3Tag(version byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+
+	b := []byte{'I', 'D', '3', version, 0, 0}
+	b = append(b, syncsafeBytes(len(body))...)
+	return append(b, body...)
+}
+
+// id3Frame builds an ID3v2.3 or ID3v2.4 frame.
+func id3Frame(version byte, id string, body []byte) []byte {
+	size := make([]byte, 4)
+	if version == 4 {
+		size = syncsafeBytes(len(body))
+	} else {
+		binary.BigEndian.PutUint32(size, uint32(len(body)))
+	}
+
+	b := append([]byte(id), size...)
+	b = append(b, 0, 0)
+	return append(b, body...)
+}
+
+// apicFrame builds the body of an APIC frame with the specified text
+// encoding and picture type.
+func apicFrame(enc byte, typ byte, picture []byte) []byte {
+	b := []byte{enc}
+	b = append(b, "image/jpeg\x00"...)
+	b = append(b, typ)
+	if enc == 1 {
+		b = append(b, "\xff\xfec\x00\x00\x00"...)
+	} else {
+		b = append(b, "cover\x00"...)
+	}
+
+	return append(b, picture...)
+}
+
+// syncsafeBytes encodes n as a synchsafe integer.
+func syncsafeBytes(n int) []byte {
+	return []byte{
+		byte(n>>21) & 0x7f,
+		byte(n>>14) & 0x7f,
+		byte(n>>7) & 0x7f,
+		byte(n) & 0x7f,
+	}
+}
+
+// flacStream builds a FLAC stream containing metadata blocks.
+func flacStream(blocks ...[]byte) []byte {
+	return append([]byte("fLaC"), bytes.Join(blocks, nil)...)
+}
+
+// flacBlock builds a FLAC metadata block.
+func flacBlock(typ byte, last bool, body []byte) []byte {
+	if last {
+		typ |= 0x80
+	}
+
+	n := len(body)
+	return append([]byte{typ, byte(n >> 16), byte(n >> 8), byte(n)}, body...)
+}
+
+// flacPicture builds the body of a FLAC PICTURE block.
+func flacPicture(typ uint32, picture []byte) []byte {
+	u32 := func(n int) []byte {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(n))
+		return b
+	}
+
+	var b []byte
+	b = append(b, u32(int(typ))...)
+	b = append(b, u32(len("image/png"))...)
+	b = append(b, "image/png"...)
+	b = append(b, u32(len("cover"))...)
+	b = append(b, "cover"...)
+	b = append(b, make([]byte, 16)...)
+	b = append(b, u32(len(picture))...)
+	return append(b, picture...)
+}
+
+// mp4Atom builds an MP4 atom containing the concatenation of bodies.
+func mp4Atom(typ string, bodies ...[]byte) []byte {
+	body := bytes.Join(bodies, nil)
+
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(8+len(body)))
+	b = append(b, typ...)
+	return append(b, body...)
+}
@@ -0,0 +1,217 @@
+package mpdsub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// lyricsTags are the names of tags which contain lyrics, in order of
+// preference, as written to TXXX frames and Vorbis comments by taggers.
+var lyricsTags = []string{"LYRICS", "UNSYNCEDLYRICS"}
+
+// readEmbeddedLyrics reads the lyrics embedded in a song, detecting its
+// format by its magic number: ID3v2 USLT or TXXX frames, FLAC Vorbis
+// comments, or the ©lyr atom of MP4 files.  If the song has no lyrics, it
+// returns an empty string.
+func readEmbeddedLyrics(r io.ReadSeeker) (string, error) {
+	magic := make([]byte, 8)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("ID3")):
+		return readID3Lyrics(r)
+	case bytes.HasPrefix(magic, []byte("fLaC")):
+		return readFLACLyrics(r)
+	case bytes.Equal(magic[4:8], []byte("ftyp")):
+		b, err := readMP4Item(r, "\xa9lyr")
+		return string(b), err
+	default:
+		return "", nil
+	}
+}
+
+// readID3Lyrics reads the lyrics in an ID3v2 tag's USLT frames, or in the
+// ULT frames of an ID3v2.2 tag.  TXXX frames named after one of lyricsTags
+// are used if there is no USLT frame.
+func readID3Lyrics(r io.Reader) (string, error) {
+	var uslt, txxx string
+	err := walkID3Frames(r, func(id string, frame []byte, version byte) (bool, error) {
+		if len(frame) < 1 {
+			return false, errBadTag
+		}
+		enc, b := frame[0], frame[1:]
+
+		switch id {
+		case "USLT", "ULT":
+			// Skip the language, and then the content descriptor
+			if len(b) < 3 {
+				return false, errBadTag
+			}
+			text, ok := skipID3String(b[3:], enc)
+			if !ok {
+				return false, errBadTag
+			}
+
+			uslt = decodeID3Text(enc, text)
+			return true, nil
+		case "TXXX", "TXX":
+			if txxx != "" {
+				return false, nil
+			}
+
+			value, ok := skipID3String(b, enc)
+			if !ok {
+				return false, errBadTag
+			}
+
+			desc := decodeID3Text(enc, b[:len(b)-len(value)])
+			for _, t := range lyricsTags {
+				if strings.EqualFold(desc, t) {
+					txxx = decodeID3Text(enc, value)
+				}
+			}
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if uslt != "" {
+		return uslt, nil
+	}
+
+	return txxx, nil
+}
+
+// decodeID3Text decodes a string with the specified ID3v2 text encoding:
+// ISO-8859-1, UTF-16 with a byte order mark, UTF-16BE, or UTF-8.  Trailing
+// null characters are removed.
+func decodeID3Text(enc byte, b []byte) string {
+	var s string
+	switch enc {
+	case 0:
+		rs := make([]rune, 0, len(b))
+		for _, c := range b {
+			rs = append(rs, rune(c))
+		}
+		s = string(rs)
+	case 1, 2:
+		var order binary.ByteOrder = binary.BigEndian
+		if enc == 1 && len(b) >= 2 {
+			if b[0] == 0xff && b[1] == 0xfe {
+				order = binary.LittleEndian
+			}
+			if (b[0] == 0xff && b[1] == 0xfe) || (b[0] == 0xfe && b[1] == 0xff) {
+				b = b[2:]
+			}
+		}
+
+		u := make([]uint16, 0, len(b)/2)
+		for i := 0; i+1 < len(b); i += 2 {
+			u = append(u, order.Uint16(b[i:i+2]))
+		}
+		s = string(utf16.Decode(u))
+	default:
+		s = string(b)
+	}
+
+	return strings.TrimRight(s, "\x00")
+}
+
+// readFLACLyrics reads the lyrics in a FLAC stream's VORBIS_COMMENT
+// metadata block, from the first comment named after one of lyricsTags.
+func readFLACLyrics(r io.ReadSeeker) (string, error) {
+	const blockVorbisComment = 4
+
+	var lyrics string
+	err := walkFLACBlocks(r, blockVorbisComment, func(block []byte) (bool, error) {
+		comments, err := parseVorbisComments(block)
+		if err != nil {
+			return false, err
+		}
+
+		lyrics = firstComment(comments, lyricsTags...)
+		return true, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return lyrics, nil
+}
+
+// parseVorbisComments parses the body of a FLAC VORBIS_COMMENT block, whose
+// little endian lengths differ from the rest of the FLAC format.  Comment
+// names are returned in upper case, since they are case insensitive.
+func parseVorbisComments(b []byte) (map[string]string, error) {
+	next := func() ([]byte, bool) {
+		if len(b) < 4 {
+			return nil, false
+		}
+
+		n := binary.LittleEndian.Uint32(b[:4])
+		if uint64(n) > uint64(len(b)-4) {
+			return nil, false
+		}
+
+		v := b[4 : 4+n]
+		b = b[4+n:]
+		return v, true
+	}
+
+	// Skip the vendor string
+	if _, ok := next(); !ok {
+		return nil, errBadTag
+	}
+	if len(b) < 4 {
+		return nil, errBadTag
+	}
+
+	count := binary.LittleEndian.Uint32(b[:4])
+	b = b[4:]
+
+	comments := make(map[string]string)
+	for i := uint32(0); i < count; i++ {
+		c, ok := next()
+		if !ok {
+			return nil, errBadTag
+		}
+
+		j := bytes.IndexByte(c, '=')
+		if j < 0 {
+			continue
+		}
+
+		// Only the first value of repeated comments is kept
+		k := strings.ToUpper(string(c[:j]))
+		if _, ok := comments[k]; !ok {
+			comments[k] = string(c[j+1:])
+		}
+	}
+
+	return comments, nil
+}
+
+// firstComment returns the value of the first of the named comments which
+// is set, ignoring the case of their names.
+func firstComment(comments map[string]string, names ...string) string {
+	for _, n := range names {
+		for k, v := range comments {
+			if strings.EqualFold(k, n) && v != "" {
+				return v
+			}
+		}
+	}
+
+	return ""
+}
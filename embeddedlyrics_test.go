@@ -0,0 +1,125 @@
+package mpdsub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func Test_readEmbeddedLyrics(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want string
+	}{
+		{
+			name: "ID3v2.3 USLT",
+			b: id3Tag(3,
+				id3Frame(3, "TIT2", []byte("\x00title")),
+				id3Frame(3, "USLT", []byte("\x00eng\x00Caf\xe9 lyrics")),
+			),
+			want: "Café lyrics",
+		},
+		{
+			name: "ID3v2.4 UTF-16 USLT",
+			b: id3Tag(4,
+				id3Frame(4, "USLT", []byte("\x01eng\xff\xfe\x00\x00\xff\xfeh\x00i\x00\x00\x00")),
+			),
+			want: "hi",
+		},
+		{
+			name: "ID3v2.3 TXXX",
+			b: id3Tag(3,
+				id3Frame(3, "TXXX", []byte("\x03UNSYNCEDLYRICS\x00la la")),
+			),
+			want: "la la",
+		},
+		{
+			name: "ID3v2.3 USLT preferred",
+			b: id3Tag(3,
+				id3Frame(3, "TXXX", []byte("\x03LYRICS\x00txxx")),
+				id3Frame(3, "USLT", []byte("\x03eng\x00uslt")),
+			),
+			want: "uslt",
+		},
+		{
+			name: "ID3v2.3 no lyrics",
+			b: id3Tag(3,
+				id3Frame(3, "TXXX", []byte("\x03COMMENT\x00txxx")),
+			),
+		},
+		{
+			name: "FLAC",
+			b: flacStream(
+				flacBlock(0, false, make([]byte, 34)),
+				flacBlock(4, true, vorbisComments("TITLE=title", "Lyrics=la la")),
+			),
+			want: "la la",
+		},
+		{
+			name: "FLAC no lyrics",
+			b: flacStream(
+				flacBlock(0, false, make([]byte, 34)),
+				flacBlock(4, true, vorbisComments("TITLE=title")),
+			),
+		},
+		{
+			name: "MP4",
+			b: append(mp4Atom("ftyp", []byte("M4A \x00\x00\x00\x00")),
+				mp4Atom("moov",
+					mp4Atom("udta",
+						mp4Atom("meta", make([]byte, 4),
+							mp4Atom("ilst",
+								mp4Atom("\xa9lyr", mp4Atom("data", make([]byte, 8), []byte("la la"))),
+							),
+						),
+					),
+				)...,
+			),
+			want: "la la",
+		},
+		{
+			name: "unknown format",
+			b:    []byte("OggS\x00\x02\x00\x00"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readEmbeddedLyrics(bytes.NewReader(tt.b))
+			if err != nil {
+				t.Fatalf("failed to read lyrics: %v", err)
+			}
+
+			if want := tt.want; want != got {
+				t.Fatalf("unexpected lyrics:\n- want: %q\n-  got: %q", want, got)
+			}
+		})
+	}
+}
+
+func Test_readEmbeddedLyricsMalformed(t *testing.T) {
+	b := flacStream(flacBlock(4, true, []byte{0xff, 0xff, 0xff, 0xff}))
+
+	if _, err := readEmbeddedLyrics(bytes.NewReader(b)); err != errBadTag {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errBadTag, err)
+	}
+}
+
+// vorbisComments builds the body of a FLAC VORBIS_COMMENT block.
+func vorbisComments(comments ...string) []byte {
+	u32 := func(n int) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(n))
+		return b
+	}
+
+	b := append(u32(len("mpdsub")), "mpdsub"...)
+	b = append(b, u32(len(comments))...)
+	for _, c := range comments {
+		b = append(b, u32(len(c))...)
+		b = append(b, c...)
+	}
+
+	return b
+}
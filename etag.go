@@ -0,0 +1,142 @@
+package mpdsub
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// authParameters are query parameters used for authentication, which do not
+// affect the content of a response.
+var authParameters = map[string]struct{}{
+	"u": {},
+	"p": {},
+	"t": {},
+	"s": {},
+	"c": {},
+	"v": {},
+}
+
+// conditional wraps a handler for a metadata endpoint, so that a weak ETag is
+// computed for each response.  If a client's If-None-Match header matches the
+// ETag, HTTP 304 Not Modified is returned instead of invoking the handler.
+//
+// The ETag is derived from the time of MPD's last database update, the
+// version of the state store, the user, and the request's parameters.  If
+// epoch is not nil, its result is also included, for responses which change
+// over time without any database or store update.
+func (s *Server) conditional(fn http.HandlerFunc, epoch func() time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := s.db.Stats()
+		if err != nil {
+			// Without the database update time, no ETag can be computed
+			s.logf("error retrieving MPD statistics for ETag: %v", err)
+			fn(w, r)
+			return
+		}
+
+		var version uint64
+		s.store.View(func(d *storeData) {
+			version = d.Version
+		})
+
+		h := fnv.New64a()
+		_, _ = fmt.Fprintf(h, "%s\x00%d\x00%s\x00", stats["db_update"], version, requestContextFrom(r).User)
+
+		if epoch != nil {
+			_, _ = fmt.Fprintf(h, "%d\x00", epoch().Unix())
+		}
+
+		q := r.URL.Query()
+		keys := make([]string, 0, len(q))
+		for k := range q {
+			if _, ok := authParameters[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			_, _ = io.WriteString(h, k+"="+strings.Join(q[k], ",")+"\x00")
+		}
+
+		etag := fmt.Sprintf(`W/"%x"`, h.Sum64())
+		w.Header().Set("ETag", etag)
+
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		fn(w, r)
+	}
+}
+
+// fileETag computes a strong ETag for the file at p, derived from its size
+// and modification time.  The ETag changes whenever the file is modified,
+// so clients can safely resume an interrupted download using If-Range.
+func fileETag(p string, fi os.FileInfo) string {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%s\x00%d\x00%d", p, fi.Size(), fi.ModTime().UnixNano())
+
+	return fmt.Sprintf(`"%x"`, h.Sum64())
+}
+
+// etagMatch determines if an If-None-Match header value matches etag, using
+// the weak comparison function.
+func etagMatch(header string, etag string) bool {
+	if header == "" {
+		return false
+	}
+
+	want := strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == want {
+			return true
+		}
+	}
+
+	return false
+}
+
+// playlistsEpoch returns the start of the current period in which the
+// contents of time-dependent playlists, such as daily mixes and smart
+// playlists with a modification window or play counts, remain unchanged,
+// or the time M3U playlists were last modified, if later.
+func (s *Server) playlistsEpoch() time.Time {
+	period := time.Duration(0)
+
+	if s.cfg.DailyMixes > 0 {
+		period = s.cfg.DailyMixRefresh
+		if period <= 0 {
+			period = defaultDailyMixRefresh
+		}
+	}
+
+	// Play counts are recorded without a new store version, so playlists
+	// which compare them are also refreshed periodically
+	for _, p := range s.cfg.SmartPlaylists {
+		_, f, _ := p.searchArgs(time.Now())
+		if (p.ModifiedWithin > 0 || f.Plays != (playRange{})) && (period == 0 || period > time.Minute) {
+			period = time.Minute
+		}
+	}
+
+	var epoch time.Time
+	if period > 0 {
+		epoch = time.Now().Truncate(period)
+	}
+
+	// M3U playlists change whenever their files do
+	if t := s.m3uModified(); t.After(epoch) {
+		epoch = t
+	}
+
+	return epoch
+}
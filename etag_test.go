@@ -0,0 +1,222 @@
+package mpdsub
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_etagMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		ok     bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:   "mismatch",
+			header: `W/"bar"`,
+		},
+		{
+			name:   "weak match",
+			header: `W/"foo"`,
+			ok:     true,
+		},
+		{
+			name:   "strong match",
+			header: `"foo"`,
+			ok:     true,
+		},
+		{
+			name:   "list match",
+			header: `W/"bar", W/"foo"`,
+			ok:     true,
+		},
+		{
+			name:   "wildcard",
+			header: `*`,
+			ok:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if want, got := tt.ok, etagMatch(tt.header, `W/"foo"`); want != got {
+				t.Fatalf("unexpected match:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestServer_conditional(t *testing.T) {
+	db := &memoryDatabase{
+		files:    []string{"foo.mp3"},
+		dbUpdate: "1478282400",
+	}
+
+	cfg, values := configAuth()
+
+	withServer(t, db, nil, cfg, func(base string) {
+		res := testRequest(t, base, http.MethodGet, "/rest/getIndexes.view", values)
+		_ = res.Body.Close()
+
+		etag := res.Header.Get("ETag")
+		if etag == "" {
+			t.Fatal("no ETag in response")
+		}
+
+		res = conditionalRequest(t, base, "/rest/getIndexes.view", values, etag)
+		_ = res.Body.Close()
+
+		if want, got := http.StatusNotModified, res.StatusCode; want != got {
+			t.Fatalf("unexpected HTTP status code:\n- want: %03d\n-  got: %03d", want, got)
+		}
+
+		// Different parameters produce a different ETag
+		folder := url.Values{}
+		for k, v := range values {
+			folder[k] = v
+		}
+		folder.Set("musicFolderId", "0")
+
+		res = conditionalRequest(t, base, "/rest/getIndexes.view", folder, etag)
+		_ = res.Body.Close()
+
+		if want, got := http.StatusOK, res.StatusCode; want != got {
+			t.Fatalf("unexpected HTTP status code:\n- want: %03d\n-  got: %03d", want, got)
+		}
+
+		// A database update invalidates the ETag
+		db.mu.Lock()
+		db.dbUpdate = "1478368800"
+		db.mu.Unlock()
+
+		mustDecodeXML(t, conditionalRequest(t, base, "/rest/getIndexes.view", values, etag))
+	})
+}
+
+// conditionalRequest performs a HTTP GET request with an If-None-Match header
+// against the server specified by base.
+func conditionalRequest(t *testing.T, base string, target string, values url.Values, etag string) *http.Response {
+	u, err := url.Parse(base)
+	if err != nil {
+		t.Fatalf("failed to parse test server URL: %v", err)
+	}
+	u.Path = target
+	u.RawQuery = values.Encode()
+
+	r, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		t.Fatalf("failed to create HTTP request: %v", err)
+	}
+	r.Header.Set("If-None-Match", etag)
+
+	res, err := (&http.Client{}).Do(r)
+	if err != nil {
+		t.Fatalf("failed to perform HTTP request: %v", err)
+	}
+
+	return res
+}
+
+func TestServer_downloadResume(t *testing.T) {
+	const musicDirectory = "/var/music"
+
+	db := &memoryDatabase{
+		files: []string{"foo.mp3"},
+	}
+
+	fs := &memoryFilesystem{
+		files: map[string]*memoryFile{
+			filepath.Join(musicDirectory, "foo.mp3"): {
+				ReadSeeker: strings.NewReader("hello world"),
+				modTime:    time.Unix(1478368800, 0),
+			},
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.MusicDirectory = musicDirectory
+	values.Set("id", "0")
+
+	withServer(t, db, fs, cfg, func(base string) {
+		res := testRequest(t, base, http.MethodGet, "/rest/download.view", values)
+		_ = res.Body.Close()
+
+		etag := res.Header.Get("ETag")
+		if etag == "" || strings.HasPrefix(etag, "W/") {
+			t.Fatalf("expected a strong ETag, but got: %q", etag)
+		}
+
+		tests := []struct {
+			name    string
+			ifRange string
+			code    int
+			body    string
+		}{
+			{
+				name:    "resume",
+				ifRange: etag,
+				code:    http.StatusPartialContent,
+				body:    "world",
+			},
+			{
+				name:    "file changed",
+				ifRange: `"foo"`,
+				code:    http.StatusOK,
+				body:    "hello world",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				res := rangeRequest(t, base, "/rest/download.view", values, "bytes=6-", tt.ifRange)
+				defer res.Body.Close()
+
+				if want, got := tt.code, res.StatusCode; want != got {
+					t.Fatalf("unexpected HTTP status code:\n- want: %03d\n-  got: %03d", want, got)
+				}
+
+				b, err := ioutil.ReadAll(res.Body)
+				if err != nil {
+					t.Fatalf("failed to read body: %v", err)
+				}
+
+				if want, got := tt.body, string(b); want != got {
+					t.Fatalf("unexpected body:\n- want: %q\n-  got: %q", want, got)
+				}
+			})
+		}
+	})
+}
+
+// rangeRequest performs a HTTP GET request with Range and If-Range headers
+// against the server specified by base.
+func rangeRequest(t *testing.T, base string, target string, values url.Values, rng string, ifRange string) *http.Response {
+	u, err := url.Parse(base)
+	if err != nil {
+		t.Fatalf("failed to parse test server URL: %v", err)
+	}
+	u.Path = target
+	u.RawQuery = values.Encode()
+
+	r, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		t.Fatalf("failed to create HTTP request: %v", err)
+	}
+	r.Header.Set("Range", rng)
+	r.Header.Set("If-Range", ifRange)
+
+	res, err := (&http.Client{}).Do(r)
+	if err != nil {
+		t.Fatalf("failed to perform HTTP request: %v", err)
+	}
+
+	return res
+}
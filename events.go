@@ -0,0 +1,211 @@
+package mpdsub
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultEventTimeout is the default amount of time a long-polling
+	// client waits for an event.
+	defaultEventTimeout = 30 * time.Second
+
+	// maxEventTimeout is the maximum amount of time a long-polling client
+	// may wait for an event.
+	maxEventTimeout = 5 * time.Minute
+
+	// eventStream is the event published when a Subsonic client begins
+	// streaming a song.
+	eventStream = "stream"
+)
+
+// An eventHub distributes events to subscribed clients.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan string]struct{}
+}
+
+// subscribe registers a new subscriber.  The returned function must be called
+// to unsubscribe.
+func (h *eventHub) subscribe() (<-chan string, func()) {
+	// Buffer a single event so a subscriber which is busy writing to its
+	// client does not miss that a change occurred
+	c := make(chan string, 1)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subs == nil {
+		h.subs = make(map[chan string]struct{})
+	}
+	h.subs[c] = struct{}{}
+
+	return c, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		delete(h.subs, c)
+	}
+}
+
+// publish sends an event to all subscribers, without blocking on those which
+// already have an event pending.
+func (h *eventHub) publish(event string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.subs {
+		select {
+		case c <- event:
+		default:
+		}
+	}
+}
+
+// watchEvents publishes events received from MPD's idle watcher until ctx
+// is canceled or events is closed.
+func (s *Server) watchEvents(ctx context.Context, events <-chan string) {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+
+			// Files may have changed, so their transcoded output and
+			// artwork may be stale, missing artwork may have been added,
+			// songs which vanished may have reappeared, and the search
+			// index and prewarmed artwork must be rebuilt.  The disk
+			// artwork cache is keyed by modification times, so only
+			// changed items miss it
+			if ev == "database" {
+				s.transcodes.cache.clear()
+				s.artCache.clear()
+				s.artInfo.clear()
+				s.misses.clear()
+				s.index.invalidate()
+				s.jobs.trigger(jobSearchIndex)
+				s.jobs.trigger(jobArtworkPrewarm)
+
+				if err := s.relinkRatings(); err != nil {
+					s.logf("error restoring ratings: %v", err)
+				}
+			}
+
+			s.events.publish(ev)
+		}
+	}
+}
+
+// pollDatabase sends a "database" event on events each time MPD's database
+// update time changes, checking every interval until ctx is canceled.
+func (s *Server) pollDatabase(ctx context.Context, events chan<- string, interval time.Duration) {
+	defer s.wg.Done()
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	var last string
+	for {
+		// The first check only records the current update time, since
+		// caches are empty when the Server starts
+		stats, err := s.db.Stats()
+		switch {
+		case err != nil:
+			s.logf("error polling mpd for database updates: %v", err)
+		case last != "" && stats["db_update"] != last:
+			select {
+			case events <- "database":
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err == nil {
+			last = stats["db_update"]
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// nowPlayingEvents is a custom endpoint which notifies clients when MPD's
+// player state changes, or when a Subsonic client begins streaming a song.
+//
+// Clients which accept text/event-stream receive a stream of server-sent
+// events, named after the MPD subsystem which changed.  Other clients long
+// poll: the request blocks until an event occurs or the optional timeout
+// parameter (in seconds) elapses, and then responds as getNowPlaying does.
+func (s *Server) nowPlayingEvents(w http.ResponseWriter, r *http.Request) {
+	events, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		s.serveEventStream(w, r, events)
+		return
+	}
+
+	timeout := defaultEventTimeout
+	if qTimeout := r.URL.Query().Get("timeout"); qTimeout != "" {
+		n, err := strconv.Atoi(qTimeout)
+		if err != nil || n < 0 {
+			writeXML(w, errGeneric)
+			return
+		}
+		timeout = time.Duration(n) * time.Second
+	}
+	if timeout > maxEventTimeout {
+		timeout = maxEventTimeout
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case <-r.Context().Done():
+		return
+	case <-events:
+	case <-t.C:
+	}
+
+	s.getNowPlaying(w, r)
+}
+
+// serveEventStream writes events to a client as server-sent events until
+// the client disconnects.
+func (s *Server) serveEventStream(w http.ResponseWriter, r *http.Request, events <-chan string) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		writeXML(w, errGeneric)
+		return
+	}
+
+	w.Header().Set(contentType, "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-events:
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev, ev); err != nil {
+				return
+			}
+			f.Flush()
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package mpdsub
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_nowPlayingEventsLongPoll(t *testing.T) {
+	events := make(chan string)
+
+	cfg, values := configAuth()
+	cfg.PlayerEvents = events
+	values.Set("timeout", "10")
+
+	withServer(t, &memoryDatabase{}, nil, cfg, func(base string) {
+		// Publish events until the long-polling request completes
+		done := make(chan struct{})
+		defer close(done)
+		go publishUntil(events, done)
+
+		start := time.Now()
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/nowPlayingEvents.view", values))
+
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("long-polling request did not complete on event")
+		}
+		if c.NowPlaying == nil {
+			t.Fatal("no now playing in response")
+		}
+	})
+}
+
+func TestServer_nowPlayingEventsStream(t *testing.T) {
+	events := make(chan string)
+
+	cfg, values := configAuth()
+	cfg.PlayerEvents = events
+
+	withServer(t, &memoryDatabase{}, nil, cfg, func(base string) {
+		done := make(chan struct{})
+		defer close(done)
+
+		req, err := http.NewRequest(http.MethodGet, base+"/rest/nowPlayingEvents.view?"+values.Encode(), nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Accept", "text/event-stream")
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to perform request: %v", err)
+		}
+		defer res.Body.Close()
+
+		if want, got := "text/event-stream", res.Header.Get(contentType); want != got {
+			t.Fatalf("unexpected Content-Type:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+
+		go publishUntil(events, done)
+
+		line, err := bufio.NewReader(res.Body).ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event: %v", err)
+		}
+
+		if want, got := "event: player", strings.TrimSpace(line); want != got {
+			t.Fatalf("unexpected event:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+	})
+}
+
+// publishUntil repeatedly publishes player events until done is closed,
+// so subscribers which connect late still receive an event.
+func publishUntil(events chan<- string, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case events <- "player":
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestServerPollDatabase(t *testing.T) {
+	db := &memoryDatabase{dbUpdate: "1"}
+
+	cfg, _ := configAuth()
+	cfg.PollInterval = 10 * time.Millisecond
+
+	s, err := newServer(db, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer s.Close()
+
+	events, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+
+	// Let the first poll record the current update time
+	time.Sleep(50 * time.Millisecond)
+
+	db.mu.Lock()
+	db.dbUpdate = "2"
+	db.mu.Unlock()
+
+	select {
+	case ev := <-events:
+		if want, got := "database", ev; want != got {
+			t.Fatalf("unexpected event:\n- want: %v\n-  got: %v", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event after database update")
+	}
+}
+
+func TestServerDatabaseEventRelinksRatings(t *testing.T) {
+	db := &memoryDatabase{
+		files:    []string{"foo/foo.mp3"},
+		stickers: map[string]map[string]string{},
+	}
+
+	events := make(chan string)
+	cfg, _ := configAuth()
+	cfg.PlayerEvents = events
+
+	s, err := newServer(db, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer s.Close()
+
+	// A song whose rating was kept while it was missing from MPD's database
+	err = s.store.Update(func(d *storeData) error {
+		d.Ratings["foo/foo.mp3"] = savedRating{Rating: 4, Missing: true}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to update store: %v", err)
+	}
+
+	published, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+
+	// Ratings are only restored when the "database" subsystem is watched
+	events <- "database"
+
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("database event was not published")
+	}
+
+	if want, got := "4", db.stickers["foo/foo.mp3"]["rating"]; want != got {
+		t.Fatalf("unexpected restored rating:\n- want: %v\n-  got: %v", want, got)
+	}
+}
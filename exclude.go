@@ -0,0 +1,102 @@
+package mpdsub
+
+import (
+	"path"
+	"strings"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// An excluder matches MPD file and directory paths against a set of
+// exclusion patterns.
+type excluder struct {
+	patterns []string
+	fold     bool
+}
+
+// newExcluder creates an excluder from the input patterns.  Trailing slashes
+// are trimmed from patterns, so "Audiobooks/" and "Audiobooks" are equivalent.
+// If fold is true, patterns match paths case-insensitively.
+func newExcluder(patterns []string, fold bool) *excluder {
+	e := &excluder{
+		patterns: make([]string, 0, len(patterns)),
+		fold:     fold,
+	}
+
+	for _, p := range patterns {
+		// Accept Windows-style separators in patterns
+		p = strings.Replace(p, `\`, "/", -1)
+		if fold {
+			p = strings.ToLower(p)
+		}
+
+		if p = strings.TrimSuffix(p, "/"); p != "" {
+			e.patterns = append(e.patterns, p)
+		}
+	}
+
+	return e
+}
+
+// Root reports whether name itself matches an exclusion pattern.
+func (e *excluder) Root(name string) bool {
+	if e.fold {
+		name = strings.ToLower(name)
+	}
+
+	for _, p := range e.patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Excluded reports whether name, or any of its parent directories, matches
+// an exclusion pattern.
+func (e *excluder) Excluded(name string) bool {
+	if len(e.patterns) == 0 {
+		return false
+	}
+
+	for p := name; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		if e.Root(p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterSongs returns the songs which are not excluded from browsing.
+func (e *excluder) filterSongs(songs []mpd.Attrs) []mpd.Attrs {
+	if len(e.patterns) == 0 {
+		return songs
+	}
+
+	out := make([]mpd.Attrs, 0, len(songs))
+	for _, s := range songs {
+		if !e.Excluded(s["file"]) {
+			out = append(out, s)
+		}
+	}
+
+	return out
+}
+
+// filterIndexed returns the indexedFiles which are not excluded from browsing.
+func (e *excluder) filterIndexed(files []indexedFile) []indexedFile {
+	if len(e.patterns) == 0 {
+		return files
+	}
+
+	out := make([]indexedFile, 0, len(files))
+	for _, f := range files {
+		if !e.Excluded(f.Name) {
+			out = append(out, f)
+		}
+	}
+
+	return out
+}
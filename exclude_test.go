@@ -0,0 +1,196 @@
+package mpdsub
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func Test_excluder(t *testing.T) {
+	e := newExcluder([]string{"Audiobooks/", "*/demos", ""}, false)
+
+	tests := []struct {
+		name     string
+		root     bool
+		excluded bool
+	}{
+		{name: "Music"},
+		{name: "Music/Artist/song.mp3"},
+		{name: "Audiobooks", root: true, excluded: true},
+		{name: "Audiobooks/Book/01.mp3", excluded: true},
+		{name: "Artist/demos", root: true, excluded: true},
+		{name: "Artist/demos/demo.mp3", excluded: true},
+		{name: "Artist/Album/demos"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if want, got := tt.root, e.Root(tt.name); want != got {
+				t.Fatalf("unexpected root match:\n- want: %v\n-  got: %v", want, got)
+			}
+
+			if want, got := tt.excluded, e.Excluded(tt.name); want != got {
+				t.Fatalf("unexpected exclusion:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func Test_excluderFold(t *testing.T) {
+	e := newExcluder([]string{`Audiobooks\`, `*\Demos`}, true)
+
+	for _, name := range []string{
+		"audiobooks/Book/01.mp3",
+		"AUDIOBOOKS",
+		"Artist/demos/demo.mp3",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if !e.Excluded(name) {
+				t.Fatalf("expected %q to be excluded", name)
+			}
+		})
+	}
+}
+
+func TestServer_getIndexesExcluded(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Apple/A.mp3",
+			"Apple/demos/demo.mp3",
+			"Audiobooks/Book/01.mp3",
+			"Banana/B.mp3",
+		},
+	}
+
+	tests := []struct {
+		name    string
+		folder  string
+		indexes []index
+	}{
+		{
+			name: "library",
+			indexes: []index{
+				{
+					Name: "A",
+					Artists: []artist{{
+						Name: "Apple",
+						ID:   "0",
+					}},
+				},
+				{
+					Name: "B",
+					Artists: []artist{{
+						Name: "Banana",
+						ID:   "7",
+					}},
+				},
+			},
+		},
+		{
+			name:   "excluded",
+			folder: "1",
+			indexes: []index{{
+				Name: "A",
+				Artists: []artist{
+					{
+						Name: "Apple/demos",
+						ID:   "2",
+					},
+					{
+						Name: "Audiobooks",
+						ID:   "4",
+					},
+				},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.ExcludePatterns = []string{"Audiobooks/", "*/demos"}
+			cfg.ExcludedFolder = "Other"
+
+			if tt.folder != "" {
+				values.Set("musicFolderId", tt.folder)
+			}
+
+			withServer(t, db, nil, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getIndexes.view", values))
+
+				if c.Indexes == nil {
+					t.Fatal("indexes is nil")
+				}
+
+				mustIndexesEqual(t, tt.indexes, c.Indexes.Indexes)
+			})
+		})
+	}
+}
+
+func TestServer_getMusicDirectoryExcluded(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Apple/A.mp3",
+			"Apple/demos/demo.mp3",
+		},
+		attrs: map[string]mpd.Attrs{
+			"Apple/A.mp3":          {"TITLE": "A"},
+			"Apple/demos/demo.mp3": {"TITLE": "demo"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		id       string
+		folder   string
+		children int
+		notFound bool
+	}{
+		{
+			name:     "library directory hides excluded subtree",
+			id:       "0",
+			children: 1,
+		},
+		{
+			name:     "excluded directory",
+			id:       "2",
+			folder:   "Other",
+			children: 1,
+		},
+		{
+			name:     "excluded directory without excluded folder",
+			id:       "2",
+			notFound: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.ExcludePatterns = []string{"*/demos"}
+			cfg.ExcludedFolder = tt.folder
+			values.Set("id", tt.id)
+
+			withServer(t, db, nil, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getMusicDirectory.view", values))
+
+				if tt.notFound {
+					if c.Error == nil || c.Error.Code != codeNotFound {
+						t.Fatalf("expected not found error, but got: %+v", c.Error)
+					}
+					return
+				}
+
+				if c.MusicDirectory == nil {
+					t.Fatal("music directory is nil")
+				}
+
+				if want, got := tt.children, len(c.MusicDirectory.Children); want != got {
+					t.Fatalf("unexpected number of children:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package mpdsub
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// A fieldFilter is a http.ResponseWriter which causes writeXML to omit
+// optional fields from a response, trimming payloads for clients which do
+// not use them.
+type fieldFilter struct {
+	http.ResponseWriter
+	omit map[string]bool
+}
+
+// Flush implements http.Flusher, if the underlying http.ResponseWriter
+// supports it.
+func (ff *fieldFilter) Flush() {
+	if f, ok := ff.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// filterFields wraps w in a fieldFilter if the client requests that fields
+// be omitted, using the omit parameter or the client's configured defaults.
+func (s *Server) filterFields(w http.ResponseWriter, r *http.Request, client string) http.ResponseWriter {
+	omit := make(map[string]bool)
+	for _, f := range strings.Split(r.URL.Query().Get("omit"), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			omit[f] = true
+		}
+	}
+
+	for c, fields := range s.cfg.ClientOmitFields {
+		if !strings.EqualFold(c, client) {
+			continue
+		}
+
+		for _, f := range fields {
+			omit[f] = true
+		}
+	}
+
+	if len(omit) == 0 {
+		return w
+	}
+
+	return &fieldFilter{
+		ResponseWriter: w,
+		omit:           omit,
+	}
+}
+
+// omitFields zeroes each optional field within v whose XML name appears in
+// omit.  Only fields tagged omitempty are removed, so required fields such as
+// IDs are always present.
+func omitFields(v reflect.Value, omit map[string]bool) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			omitFields(v.Elem(), omit)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			omitFields(v.Index(i), omit)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			// Fields of embedded types, such as child, are settable even
+			// though the embedded type itself is not
+			f := v.Field(i)
+
+			tag := strings.Split(t.Field(i).Tag.Get("xml"), ",")
+			if f.CanSet() && omit[tag[0]] && hasOption(tag[1:], "omitempty") {
+				f.Set(reflect.Zero(f.Type()))
+				continue
+			}
+
+			omitFields(f, omit)
+		}
+	}
+}
+
+// hasOption reports whether a struct tag's options contain opt.
+func hasOption(options []string, opt string) bool {
+	for _, o := range options {
+		if o == opt {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,98 @@
+package mpdsub
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_omitFields(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{"foo/foo.mp3"},
+		attrs: map[string]mpd.Attrs{
+			"foo/foo.mp3": {
+				"ARTIST": "Foo",
+				"GENRE":  "Rock",
+				"TITLE":  "foo",
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		omit    string
+		clients map[string][]string
+		genre   string
+		artist  string
+		display string
+	}{
+		{
+			name:    "none",
+			genre:   "Rock",
+			artist:  "Foo",
+			display: "Foo",
+		},
+		{
+			name:   "parameter",
+			omit:   "genre,displayArtist",
+			artist: "Foo",
+		},
+		{
+			name: "client",
+			clients: map[string][]string{
+				"TEST": {"genre"},
+			},
+			artist:  "Foo",
+			display: "Foo",
+		},
+		{
+			name: "required fields",
+			omit: "id,artist",
+			// artist is not omitempty, so it is always present
+			genre:   "Rock",
+			artist:  "Foo",
+			display: "Foo",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.ClientOmitFields = tt.clients
+			values.Set("c", "test")
+			values.Set("id", "0")
+			if tt.omit != "" {
+				values.Set("omit", tt.omit)
+			}
+
+			withServer(t, db, nil, cfg, func(base string) {
+				res := testRequest(t, base, http.MethodGet, "/rest/getMusicDirectory.view", values)
+				c := mustDecodeXML(t, res)
+
+				if c.MusicDirectory == nil || len(c.MusicDirectory.Children) != 1 {
+					t.Fatalf("unexpected music directory: %+v", c.MusicDirectory)
+				}
+				ch := c.MusicDirectory.Children[0]
+
+				if want, got := "1", ch.ID; want != got {
+					t.Fatalf("unexpected ID:\n- want: %v\n-  got: %v",
+						want, got)
+				}
+				if want, got := tt.genre, ch.Genre; want != got {
+					t.Fatalf("unexpected genre:\n- want: %v\n-  got: %v",
+						want, got)
+				}
+				if want, got := tt.artist, ch.Artist; want != got {
+					t.Fatalf("unexpected artist:\n- want: %v\n-  got: %v",
+						want, got)
+				}
+
+				if want, got := tt.display, ch.DisplayArtist; want != got {
+					t.Fatalf("unexpected display artist:\n- want: %v\n-  got: %v",
+						want, got)
+				}
+			})
+		})
+	}
+}
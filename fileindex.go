@@ -1,9 +1,10 @@
 package mpdsub
 
 import (
-	"os"
-	"path/filepath"
+	"path"
 	"strings"
+
+	"github.com/fhs/gompd/mpd"
 )
 
 // An indexedFile is a file with an associated ID, name, and a boolean to
@@ -18,9 +19,18 @@ type indexedFile struct {
 type metadataFile struct {
 	indexedFile
 
-	Artist string
-	Album  string
-	Title  string
+	Artist      string
+	AlbumArtist string
+	Album       string
+	AlbumSort   string
+	Title       string
+	Genre       string
+
+	// Extended tags, which are only read using MPD's readcomments command.
+	Label         string
+	CatalogNumber string
+	Comment       string
+	Moods         []string
 }
 
 // indexFiles builds a slice of indexedFiles from a file list returned by
@@ -40,7 +50,7 @@ func indexFiles(files []string) []indexedFile {
 
 		// While a new directory is available for this filename, iterate and
 		// check it for uniqueness
-		for d := filepath.Dir(f); d != "."; d = filepath.Dir(d) {
+		for d := path.Dir(f); d != "."; d = path.Dir(d) {
 			// Has directory already been seen?
 			if _, ok := seen[d]; ok {
 				continue
@@ -74,6 +84,17 @@ func indexFiles(files []string) []indexedFile {
 	return out
 }
 
+// fileIDs builds a map of file names to their IDs from an input slice of
+// indexedFiles.
+func fileIDs(files []indexedFile) map[string]int {
+	ids := make(map[string]int, len(files))
+	for _, f := range files {
+		ids[f.Name] = f.ID
+	}
+
+	return ids
+}
+
 // filterFiles filters an input slice of indexedFiles and produces a
 // filtered output slice containing all of the items which belong in a given
 // directory, specified using its index in start.
@@ -105,12 +126,12 @@ func filterFiles(files []indexedFile, start int) []indexedFile {
 	// Track number of separators in initial item to determine how many we
 	// can allow to retrieve items in the current directory, but not items
 	// from child directories
-	sepCount := strings.Count(out[0].Name, string(os.PathSeparator))
+	sepCount := strings.Count(out[0].Name, "/")
 
 	var filter []indexedFile
 	for _, f := range out {
 		// Filter items from child directories
-		if strings.Count(f.Name, string(os.PathSeparator)) > sepCount+1 {
+		if strings.Count(f.Name, "/") > sepCount+1 {
 			continue
 		}
 
@@ -133,12 +154,12 @@ func tagFiles(db database, files []indexedFile) ([]metadataFile, error) {
 		if f.Dir {
 			out = append(out, metadataFile{
 				indexedFile: f,
-				Title:       filepath.Base(f.Name),
+				Title:       path.Base(f.Name),
 			})
 			continue
 		}
 
-		attrs, err := db.ReadComments(f.Name)
+		attrs, err := readTags(db, f.Name)
 		if err != nil {
 			return nil, err
 		}
@@ -148,21 +169,29 @@ func tagFiles(db database, files []indexedFile) ([]metadataFile, error) {
 		newf := metadataFile{
 			indexedFile: f,
 
-			Artist: attrs["ARTIST"],
-			Album:  attrs["ALBUM"],
-			Title:  attrs["TITLE"],
+			Artist:      attrs["ARTIST"],
+			AlbumArtist: attrs["ALBUMARTIST"],
+			Album:       attrs["ALBUM"],
+			AlbumSort:   attrs["ALBUMSORT"],
+			Title:       attrs["TITLE"],
+			Genre:       attrs["GENRE"],
+
+			Label:         firstTag(attrs, "LABEL", "ORGANIZATION", "PUBLISHER"),
+			CatalogNumber: firstTag(attrs, "CATALOGNUMBER"),
+			Comment:       firstTag(attrs, "COMMENT", "DESCRIPTION"),
+			Moods:         splitTag(attrs["MOOD"]),
 		}
 
 		out = append(out, newf)
 
 		// Add this metadata to the cache so the directory can be tagged later
-		dir := filepath.Dir(f.Name)
+		dir := path.Dir(f.Name)
 		cache[dir] = newf
 	}
 
 	for i, f := range out {
 		// Skip top-level and non-directories
-		if !strings.Contains(f.Name, string(os.PathSeparator)) {
+		if !strings.Contains(f.Name, "/") {
 			continue
 		}
 		if !f.Dir {
@@ -172,10 +201,70 @@ func tagFiles(db database, files []indexedFile) ([]metadataFile, error) {
 		// Tag directories with metadata if available
 		if ff, ok := cache[f.Name]; ok {
 			out[i].Artist = ff.Artist
+			out[i].AlbumArtist = ff.AlbumArtist
 			out[i].Album = ff.Album
+			out[i].AlbumSort = ff.AlbumSort
 			out[i].Title = ff.Album
+			out[i].Genre = ff.Genre
+			out[i].Label = ff.Label
+			out[i].CatalogNumber = ff.CatalogNumber
 		}
 	}
 
 	return out, nil
 }
+
+// readTags reads the tags of the song with the specified name using MPD's
+// readcomments command, keyed by their upper case names.  If readcomments
+// fails, such as with older versions of MPD or with files whose decoder does
+// not support it, the tags in MPD's database are used instead.
+func readTags(db database, name string) (mpd.Attrs, error) {
+	attrs, err := db.ReadComments(name)
+	if err == nil {
+		return attrs, nil
+	}
+
+	songs, lerr := db.ListAllInfo(name)
+	if lerr != nil {
+		// Report the original error
+		return nil, err
+	}
+
+	for _, a := range songs {
+		if a["file"] != name {
+			continue
+		}
+
+		tags := make(mpd.Attrs, len(a))
+		for k, v := range a {
+			tags[strings.ToUpper(k)] = v
+		}
+		return tags, nil
+	}
+
+	return nil, err
+}
+
+// firstTag returns the value of the first of the named tags which is set.
+func firstTag(attrs mpd.Attrs, names ...string) string {
+	for _, n := range names {
+		if v := strings.TrimSpace(attrs[n]); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// splitTag splits a tag which may contain several semicolon-separated values,
+// such as moods.  If the tag is empty, nil is returned.
+func splitTag(tag string) []string {
+	var out []string
+	for _, v := range strings.Split(tag, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
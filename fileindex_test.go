@@ -305,6 +305,7 @@ func Test_tagFiles(t *testing.T) {
 						"ARTIST": "Baz",
 						"ALBUM":  "Bar",
 						"TITLE":  "Foo",
+						"GENRE":  "Rock",
 					},
 				},
 			},
@@ -323,6 +324,62 @@ func Test_tagFiles(t *testing.T) {
 				Artist: "Baz",
 				Album:  "Bar",
 				Title:  "Foo",
+				Genre:  "Rock",
+			}},
+		},
+		{
+			name: "extended tags",
+			db: &memoryDatabase{
+				attrs: map[string]mpd.Attrs{
+					"foo.flac": mpd.Attrs{
+						"TITLE":         "Foo",
+						"ORGANIZATION":  "Label",
+						"CATALOGNUMBER": "LBL-001",
+						"DESCRIPTION":   "Remastered",
+						"MOOD":          "Happy; Energetic",
+					},
+				},
+			},
+			in: []indexedFile{{
+				ID:   0,
+				Name: "foo.flac",
+			}},
+			out: []metadataFile{{
+				indexedFile: indexedFile{
+					ID:   0,
+					Name: "foo.flac",
+				},
+
+				Title:         "Foo",
+				Label:         "Label",
+				CatalogNumber: "LBL-001",
+				Comment:       "Remastered",
+				Moods:         []string{"Happy", "Energetic"},
+			}},
+		},
+		{
+			name: "readcomments unavailable, database tags used",
+			db: &memoryDatabase{
+				songs: []mpd.Attrs{{
+					"file":   "foo.mp3",
+					"Artist": "Baz",
+					"Title":  "Foo",
+					"Label":  "Label",
+				}},
+			},
+			in: []indexedFile{{
+				ID:   0,
+				Name: "foo.mp3",
+			}},
+			out: []metadataFile{{
+				indexedFile: indexedFile{
+					ID:   0,
+					Name: "foo.mp3",
+				},
+
+				Artist: "Baz",
+				Title:  "Foo",
+				Label:  "Label",
 			}},
 		},
 		{
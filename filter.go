@@ -0,0 +1,190 @@
+package mpdsub
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// maxFilterDepth is the maximum nesting depth of parentheses in a MPD filter
+// expression passed to searchFilter.
+const maxFilterDepth = 16
+
+// searchFilter is a custom endpoint which finds songs matching a raw MPD
+// filter expression, such as "((artist == 'Foo') AND (date >= '1990'))", for
+// users familiar with MPD's query language.  Matching songs are returned as
+// the songs of a search3 result, in pages specified by count and offset,
+// optionally in a single music folder.
+func (s *Server) searchFilter(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	filter := strings.TrimSpace(q.Get("filter"))
+	if filter == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+	if err := validateFilter(filter); err != nil {
+		writeXML(w, errInvalidFilter(err))
+		return
+	}
+
+	var (
+		count  = defaultSearchCount
+		offset = 0
+		folder = musicFolderAll
+	)
+
+	ints := []struct {
+		name string
+		v    *int
+	}{
+		{name: "count", v: &count},
+		{name: "offset", v: &offset},
+		{name: "musicFolderId", v: &folder},
+	}
+
+	for _, i := range ints {
+		qv := q.Get(i.name)
+		if qv == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(qv)
+		if err != nil {
+			writeXML(w, errGeneric)
+			return
+		}
+		*i.v = n
+	}
+
+	if count < 0 || offset < 0 {
+		writeXML(w, errGeneric)
+		return
+	}
+	if count > maxSearchCount {
+		count = maxSearchCount
+	}
+
+	// The expression has been validated, but MPD may still reject it, such
+	// as when it names an unknown tag, or fail to run it, so MPD errors are
+	// detailed like those of any other command
+	songs, err := s.db.Find(filter)
+	if err != nil {
+		s.logf("error finding songs matching filter %q: %v", filter, err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	user := requestContextFrom(r).User
+
+	visible := make([]mpd.Attrs, 0, len(songs))
+	for _, a := range songs {
+		name := a["file"]
+		if name == "" || !s.inMusicFolder(name, folder) {
+			continue
+		}
+		if !s.visible(user, name) || s.filteredGenre(user, a["Genre"]) {
+			continue
+		}
+
+		visible = append(visible, a)
+	}
+
+	start, end := page(len(visible), offset, count)
+
+	children, err := s.songChildren(user, visible[start:end])
+	if err != nil {
+		s.logf("error retrieving songs matching filter from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	res := &searchResult3{
+		Artists: []artistID3{},
+		Albums:  []albumID3{},
+		Songs:   make([]song, 0, len(children)),
+	}
+	for _, c := range children {
+		res.Songs = append(res.Songs, song{child: c})
+	}
+
+	writeXML(w, func(c *container) {
+		c.SearchResult3 = res
+	})
+}
+
+// validateFilter verifies that a MPD filter expression is a single,
+// parenthesized expression with balanced parentheses and quotes, so that it
+// cannot be combined with other arguments to the find command.  Control
+// characters, which could terminate the command, are rejected.
+func validateFilter(filter string) error {
+	if !strings.HasPrefix(filter, "(") || !strings.HasSuffix(filter, ")") {
+		return errors.New("filter must be enclosed in parentheses")
+	}
+
+	var (
+		depth int
+		quote rune
+		esc   bool
+	)
+
+	for i, r := range filter {
+		if unicode.IsControl(r) {
+			return errors.New("filter must not contain control characters")
+		}
+
+		switch {
+		case esc:
+			esc = false
+		case quote != 0:
+			switch r {
+			case '\\':
+				esc = true
+			case quote:
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '(':
+			depth++
+			if depth > maxFilterDepth {
+				return fmt.Errorf("filter exceeds %d nested expressions", maxFilterDepth)
+			}
+		case r == ')':
+			depth--
+			if depth < 0 {
+				return errors.New("filter has unbalanced parentheses")
+			}
+
+			// The outermost expression must span the whole filter
+			if depth == 0 && i != len(filter)-1 {
+				return errors.New("filter must be a single expression")
+			}
+		}
+	}
+
+	if quote != 0 {
+		return errors.New("filter has an unterminated quoted string")
+	}
+	if depth != 0 {
+		return errors.New("filter has unbalanced parentheses")
+	}
+
+	return nil
+}
+
+// errInvalidFilter indicates that a MPD filter expression is invalid.
+func errInvalidFilter(err error) func(c *container) {
+	return func(c *container) {
+		c.Status = statusFailed
+		c.Error = &subsonicError{
+			Code:    codeGeneric,
+			Message: "Invalid filter expression: " + err.Error() + ".",
+		}
+	}
+}
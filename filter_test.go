@@ -0,0 +1,192 @@
+package mpdsub
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func Test_validateFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		err    bool
+	}{
+		{
+			name:   "not parenthesized",
+			filter: "artist == 'Foo'",
+			err:    true,
+		},
+		{
+			name:   "multiple expressions",
+			filter: "(artist == 'Foo') (album == 'Bar')",
+			err:    true,
+		},
+		{
+			name:   "unbalanced",
+			filter: "((artist == 'Foo')",
+			err:    true,
+		},
+		{
+			name:   "unterminated quote",
+			filter: "(artist == 'Foo)",
+			err:    true,
+		},
+		{
+			name:   "newline",
+			filter: "(artist == 'Foo')\nclear",
+			err:    true,
+		},
+		{
+			name:   "too deep",
+			filter: "((((((((((((((((((artist == 'Foo'))))))))))))))))))",
+			err:    true,
+		},
+		{
+			name:   "OK",
+			filter: "((artist == 'Foo') AND (date >= '1990'))",
+		},
+		{
+			name:   "OK parentheses in quotes",
+			filter: `(title == "Foo (Live) \"Bar\")")`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateFilter(tt.filter)
+
+			if want, got := tt.err, err != nil; want != got {
+				t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestServer_searchFilter(t *testing.T) {
+	const filter = "(artist == 'Foo')"
+
+	db := &memoryDatabase{
+		files: []string{
+			"bar.mp3",
+			"foo.mp3",
+			"foo2.mp3",
+		},
+		attrs: map[string]mpd.Attrs{
+			"foo.mp3":  {"file": "foo.mp3", "Artist": "Foo", "Title": "Foo"},
+			"foo2.mp3": {"file": "foo2.mp3", "Artist": "Foo", "Title": "Foo 2"},
+		},
+		finds: map[string][]mpd.Attrs{
+			filter: {
+				{"file": "foo.mp3", "Artist": "Foo", "Title": "Foo"},
+				{"file": "foo2.mp3", "Artist": "Foo", "Title": "Foo 2"},
+			},
+		},
+	}
+
+	cfg, values := configAuth()
+
+	// params copies values and adds additional query parameters
+	params := func(extra url.Values) url.Values {
+		v := make(url.Values, len(values))
+		for k, vv := range values {
+			v[k] = vv
+		}
+		for k, vv := range extra {
+			v[k] = vv
+		}
+		return v
+	}
+
+	withServer(t, db, nil, cfg, func(base string) {
+		errs := []struct {
+			name   string
+			params url.Values
+			code   int
+		}{
+			{
+				name: "missing filter",
+				code: codeMissingParameter,
+			},
+			{
+				name:   "invalid filter",
+				params: url.Values{"filter": {"artist == 'Foo'"}},
+				code:   codeGeneric,
+			},
+			{
+				name:   "invalid count",
+				params: url.Values{"filter": {filter}, "count": {"-1"}},
+				code:   codeGeneric,
+			},
+		}
+
+		for _, tt := range errs {
+			t.Run(tt.name, func(t *testing.T) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/searchFilter.view", params(tt.params)))
+				if c.Error == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+
+				if want, got := tt.code, c.Error.Code; want != got {
+					t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		}
+
+		t.Run("OK", func(t *testing.T) {
+			v := params(url.Values{
+				"filter": {filter},
+				"offset": {"1"},
+			})
+
+			c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/searchFilter.view", v))
+			if c.Error != nil {
+				t.Fatalf("unexpected error: %+v", c.Error)
+			}
+
+			songs := c.SearchResult3.Songs
+			if want, got := 1, len(songs); want != got {
+				t.Fatalf("unexpected number of songs:\n- want: %v\n-  got: %v", want, got)
+			}
+			if want, got := "2", songs[0].ID; want != got {
+				t.Fatalf("unexpected song ID:\n- want: %v\n-  got: %v", want, got)
+			}
+			if want, got := "Foo 2", songs[0].Title; want != got {
+				t.Fatalf("unexpected song title:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	})
+}
+
+// An unreachableFindDatabase is a database which loses its connection to
+// MPD when searching with a filter.
+type unreachableFindDatabase struct {
+	*memoryDatabase
+}
+
+func (db *unreachableFindDatabase) Find(args ...string) ([]mpd.Attrs, error) {
+	return nil, io.EOF
+}
+
+func TestServer_searchFilterMPDError(t *testing.T) {
+	db := &unreachableFindDatabase{
+		memoryDatabase: &memoryDatabase{files: []string{"foo.mp3"}},
+	}
+
+	cfg, values := configAuth()
+	values.Set("filter", "(artist == 'Foo')")
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/searchFilter.view", values))
+		if c.Error == nil {
+			t.Fatal("expected an error, but none occurred")
+		}
+
+		if want, got := detailMPDUnreachable, c.Error.Detail; want != got {
+			t.Fatalf("unexpected error detail:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
@@ -0,0 +1,282 @@
+package mpdsub
+
+import (
+	"hash/fnv"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Music folder IDs exposed to Subsonic clients.
+const (
+	// musicFolderAll indicates that no music folder was specified by a
+	// client, and items from all visible music folders should be returned.
+	musicFolderAll = -1
+
+	// musicFolderLibrary is the ID of the music folder containing all
+	// items which are not excluded.
+	musicFolderLibrary = 0
+
+	// musicFolderExcluded is the ID of the optional music folder containing
+	// only excluded items.
+	musicFolderExcluded = 1
+)
+
+// topLevelFolderID computes a stable music folder ID for a top-level
+// directory, so the ID does not change as the MPD database changes.
+func topLevelFolderID(name string) int {
+	h := fnv.New32a()
+	_, _ = io.WriteString(h, name)
+
+	// Never collide with the IDs reserved for the library and excluded folders
+	id := int(h.Sum32() & 0x7fffffff)
+	if id <= musicFolderExcluded {
+		id += musicFolderExcluded + 1
+	}
+
+	return id
+}
+
+// musicDirectoryName returns the last element of the path to MPD's music
+// directory.  Both slashes and backslashes are treated as separators, so the
+// name of a Windows music directory is correct on any platform.
+func musicDirectoryName(dir string) string {
+	dir = strings.TrimRight(dir, `/\`)
+	if i := strings.LastIndexAny(dir, `/\`); i != -1 {
+		dir = dir[i+1:]
+	}
+
+	// Handle a drive root, such as "C:"
+	if dir == "" || strings.HasSuffix(dir, ":") {
+		return "Music"
+	}
+
+	return dir
+}
+
+// topLevelDir returns the top-level directory in the path of name.
+func topLevelDir(name string) string {
+	if i := strings.IndexByte(name, '/'); i != -1 {
+		return name[:i]
+	}
+
+	return name
+}
+
+// visible reports whether the file or directory with the specified name may
+// be accessed by user, according to FolderUsers, the folders to which user
+// is restricted in the user store, and ContentFilters.  Songs may also be
+// hidden from user by genre, which is checked separately.
+func (s *Server) visible(user string, name string) bool {
+	if s.filtered(user, name) || !s.inUserFolders(user, name) {
+		return false
+	}
+
+	dir := topLevelDir(name)
+
+	users, ok := s.cfg.FolderUsers[dir]
+	if !ok && s.cfg.CaseInsensitivePaths {
+		for d, us := range s.cfg.FolderUsers {
+			if strings.EqualFold(d, dir) {
+				users, ok = us, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return true
+	}
+
+	for _, u := range users {
+		if u == user {
+			return true
+		}
+	}
+
+	return false
+}
+
+// musicFolders returns the music folders which are visible to user.
+func (s *Server) musicFolders(user string) ([]musicFolder, error) {
+	var folders []musicFolder
+
+	if !s.cfg.TopLevelFolders {
+		folders = append(folders, musicFolder{
+			ID:   musicFolderLibrary,
+			Name: musicDirectoryName(s.cfg.MusicDirectory),
+		})
+	} else {
+		fs, err := s.db.List("file")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range indexFiles(fs) {
+			if !f.Dir || strings.Contains(f.Name, "/") {
+				continue
+			}
+			if s.exclude.Excluded(f.Name) || !s.visible(user, f.Name) {
+				continue
+			}
+
+			folders = append(folders, musicFolder{
+				ID:   topLevelFolderID(f.Name),
+				Name: f.Name,
+			})
+		}
+	}
+
+	if s.cfg.ExcludedFolder != "" {
+		folders = append(folders, musicFolder{
+			ID:   musicFolderExcluded,
+			Name: s.cfg.ExcludedFolder,
+		})
+	}
+
+	return folders, nil
+}
+
+// indexArtists returns the items which appear at the top level of the
+// indexes for the specified music folder, when browsed by user, sorted
+// by their displayed names.
+func (s *Server) indexArtists(user string, files []indexedFile, folder int) []artist {
+	merged := make(map[string]struct{})
+
+	var artists []artist
+	for _, f := range files {
+		if !s.topLevel(user, f, folder) {
+			continue
+		}
+
+		// Items within top-level folders are displayed relative to their
+		// music folder
+		name := f.Name
+		if s.cfg.TopLevelFolders && folder != musicFolderExcluded {
+			name = strings.TrimPrefix(name, topLevelDir(name)+"/")
+		}
+
+		// Directories with the same name are merged into the first of them,
+		// whose directory contains the contents of each
+		if s.merges(f) && folder != musicFolderExcluded {
+			key := s.artistKey(name)
+			if _, ok := merged[key]; ok {
+				continue
+			}
+			merged[key] = struct{}{}
+		}
+
+		artists = append(artists, artist{
+			Name:     name,
+			ID:       s.formatID(f.ID),
+			SortName: s.sortName(name),
+		})
+	}
+
+	// Items from multiple top-level folders must be interleaved
+	s.sortArtists(user, artists)
+
+	return artists
+}
+
+// merges reports whether the indexedFile f may be merged with other top-level
+// directories with the same name.
+func (s *Server) merges(f indexedFile) bool {
+	return f.Dir && !s.cfg.SeparateArtists
+}
+
+// artistKey returns the key used to determine if two directories at the top
+// level of the indexes have the same name, ignoring case, whitespace, and
+// ignored articles.
+func (s *Server) artistKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s.sortName(name)), " "))
+}
+
+// mergedDirs returns the other directories at the top level of the indexes
+// which are merged with the directory f when browsed by user, such as the
+// same artist in several top-level folders.
+func (s *Server) mergedDirs(user string, files []indexedFile, f indexedFile) []indexedFile {
+	if !s.merges(f) || !s.topLevel(user, f, musicFolderAll) {
+		return nil
+	}
+
+	key := s.artistKey(path.Base(f.Name))
+
+	var dirs []indexedFile
+	for _, ff := range files {
+		if ff.ID == f.ID || !ff.Dir || !s.topLevel(user, ff, musicFolderAll) {
+			continue
+		}
+
+		if s.artistKey(path.Base(ff.Name)) == key {
+			dirs = append(dirs, ff)
+		}
+	}
+
+	return dirs
+}
+
+// sortArtists sorts artists by name, using the collation for user if one
+// is configured.
+func (s *Server) sortArtists(user string, artists []artist) {
+	if c := s.collator(user); c != nil {
+		sort.Stable(&byArtistCollation{artists: artists, c: c})
+	} else {
+		sort.Stable(byArtistName(artists))
+	}
+}
+
+// byArtistName sorts artists by their names.
+type byArtistName []artist
+
+func (b byArtistName) Len() int           { return len(b) }
+func (b byArtistName) Less(i, j int) bool { return b[i].Name < b[j].Name }
+func (b byArtistName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// topLevel reports whether an indexedFile appears at the top level of the
+// indexes for the specified music folder, when browsed by user.
+func (s *Server) topLevel(user string, f indexedFile, folder int) bool {
+	if !s.visible(user, f.Name) {
+		return false
+	}
+
+	if folder == musicFolderExcluded {
+		// Each excluded subtree appears at the top level of the excluded
+		// music folder, if it is enabled
+		return s.cfg.ExcludedFolder != "" &&
+			s.exclude.Root(f.Name) &&
+			!s.exclude.Excluded(path.Dir(f.Name))
+	}
+
+	if s.exclude.Excluded(f.Name) {
+		return false
+	}
+
+	depth := strings.Count(f.Name, "/")
+	if !s.cfg.TopLevelFolders {
+		return depth == 0
+	}
+
+	// Each top-level directory is a music folder, so its immediate children
+	// appear at the top level of the indexes
+	if depth != 1 {
+		return false
+	}
+
+	return folder == musicFolderAll || folder == topLevelFolderID(topLevelDir(f.Name))
+}
+
+// inMusicFolder reports whether the file or directory with the specified
+// name belongs to a music folder.
+func (s *Server) inMusicFolder(name string, folder int) bool {
+	switch folder {
+	case musicFolderExcluded:
+		return s.cfg.ExcludedFolder != "" && s.exclude.Excluded(name)
+	case musicFolderAll, musicFolderLibrary:
+		return !s.exclude.Excluded(name)
+	}
+
+	return !s.exclude.Excluded(name) &&
+		s.cfg.TopLevelFolders &&
+		folder == topLevelFolderID(topLevelDir(name))
+}
@@ -0,0 +1,244 @@
+package mpdsub
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestServer_getMusicFoldersTopLevel(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Audiobooks/Book/01.mp3",
+			"Music/Artist/song.mp3",
+			"Podcasts/Show/01.mp3",
+			"song.mp3",
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.TopLevelFolders = true
+	cfg.FolderUsers = map[string][]string{
+		"Music":    {"test"},
+		"Podcasts": {"someone"},
+	}
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getMusicFolders.view", values))
+
+		if c.MusicFolders == nil {
+			t.Fatal("music folders is nil")
+		}
+
+		want := []musicFolder{
+			{ID: topLevelFolderID("Audiobooks"), Name: "Audiobooks"},
+			{ID: topLevelFolderID("Music"), Name: "Music"},
+		}
+
+		if want, got := len(want), len(c.MusicFolders.MusicFolders); want != got {
+			t.Fatalf("unexpected number of music folders:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		for i := range want {
+			if want, got := want[i], c.MusicFolders.MusicFolders[i]; want != got {
+				t.Fatalf("unexpected music folder:\n- want: %v\n-  got: %v", want, got)
+			}
+		}
+	})
+}
+
+func TestServer_getIndexesTopLevel(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Audiobooks/Book/01.mp3",
+			"Music/Artist/song.mp3",
+			"Podcasts/Show/01.mp3",
+		},
+	}
+
+	tests := []struct {
+		name    string
+		folder  string
+		indexes []index
+	}{
+		{
+			name: "all folders",
+			indexes: []index{
+				{
+					Name: "A",
+					Artists: []artist{{
+						Name: "Artist",
+						ID:   "4",
+					}},
+				},
+				{
+					Name: "B",
+					Artists: []artist{{
+						Name: "Book",
+						ID:   "1",
+					}},
+				},
+			},
+		},
+		{
+			name:   "one folder",
+			folder: strconv.Itoa(topLevelFolderID("Music")),
+			indexes: []index{{
+				Name: "A",
+				Artists: []artist{{
+					Name: "Artist",
+					ID:   "4",
+				}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.TopLevelFolders = true
+			cfg.FolderUsers = map[string][]string{
+				"Podcasts": {"someone"},
+			}
+
+			if tt.folder != "" {
+				values.Set("musicFolderId", tt.folder)
+			}
+
+			withServer(t, db, nil, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getIndexes.view", values))
+
+				if c.Indexes == nil {
+					t.Fatal("indexes is nil")
+				}
+
+				mustIndexesEqual(t, tt.indexes, c.Indexes.Indexes)
+			})
+		})
+	}
+}
+
+func TestServer_streamNotVisible(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{"Podcasts/01.mp3"},
+	}
+
+	cfg, values := configAuth()
+	cfg.FolderUsers = map[string][]string{
+		"Podcasts": {"someone"},
+	}
+	values.Set("id", "1")
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/stream.view", values))
+
+		if want, got := codeNotAuthorized, c.Error.Code; want != got {
+			t.Fatalf("unexpected XML error code:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
+
+func Test_musicDirectoryName(t *testing.T) {
+	tests := []struct {
+		dir  string
+		name string
+	}{
+		{dir: "/var/music", name: "music"},
+		{dir: "/var/music/", name: "music"},
+		{dir: `C:\Users\foo\Music`, name: "Music"},
+		{dir: `\\nas\share\Library\`, name: "Library"},
+		{dir: `C:\`, name: "Music"},
+		{dir: "", name: "Music"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			if want, got := tt.name, musicDirectoryName(tt.dir); want != got {
+				t.Fatalf("unexpected name:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestServer_visibleCaseInsensitive(t *testing.T) {
+	s := &Server{
+		cfg: &Config{
+			FolderUsers: map[string][]string{
+				"Private": {"alice"},
+			},
+			CaseInsensitivePaths: true,
+		},
+	}
+
+	if s.visible("bob", "private/song.mp3") {
+		t.Fatal("bob can see private folder")
+	}
+	if !s.visible("alice", "PRIVATE/song.mp3") {
+		t.Fatal("alice cannot see private folder")
+	}
+}
+
+func TestServer_mergeArtists(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Music/Bob Dylan/Blonde on Blonde/01.mp3",
+			"Vinyl/bob  dylan/Desire/01.mp3",
+			"Vinyl/The Band/Stage Fright/01.mp3",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		separate bool
+		artists  []string
+		children []string
+	}{
+		{
+			name:     "merged",
+			artists:  []string{"1", "8"},
+			children: []string{"2", "6"},
+		},
+		{
+			name:     "separate",
+			separate: true,
+			artists:  []string{"1", "8", "5"},
+			children: []string{"2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.TopLevelFolders = true
+			cfg.SeparateArtists = tt.separate
+
+			withServer(t, db, nil, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getIndexes.view", values))
+
+				var artists []string
+				for _, idx := range c.Indexes.Indexes {
+					for _, a := range idx.Artists {
+						artists = append(artists, a.ID)
+					}
+				}
+
+				if want, got := tt.artists, artists; !reflect.DeepEqual(want, got) {
+					t.Fatalf("unexpected artists:\n- want: %v\n-  got: %v", want, got)
+				}
+
+				values.Set("id", "1")
+				c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getMusicDirectory.view", values))
+
+				var children []string
+				for _, ch := range c.MusicDirectory.Children {
+					children = append(children, ch.ID)
+				}
+
+				if want, got := tt.children, children; !reflect.DeepEqual(want, got) {
+					t.Fatalf("unexpected children:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		})
+	}
+}
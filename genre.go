@@ -0,0 +1,91 @@
+package mpdsub
+
+import (
+	"sort"
+	"strings"
+)
+
+// A genreMap normalizes raw genre tags into the canonical genre names which
+// are presented to Subsonic clients.
+type genreMap struct {
+	aliases    map[string]string
+	separators string
+}
+
+// newGenreMap creates a genreMap using the input aliases and separator
+// characters.  Alias keys are matched case-insensitively.
+func newGenreMap(aliases map[string]string, separators string) *genreMap {
+	m := &genreMap{
+		aliases:    make(map[string]string, len(aliases)),
+		separators: separators,
+	}
+
+	for k, v := range aliases {
+		m.aliases[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+	}
+
+	return m
+}
+
+// Normalize splits a raw genre tag on the configured separators, maps each
+// resulting genre to its alias, if one exists, and returns the deduplicated
+// list of genres in their original order.
+func (m *genreMap) Normalize(raw string) []string {
+	split := []string{raw}
+	if m.separators != "" {
+		split = strings.FieldsFunc(raw, func(r rune) bool {
+			return strings.ContainsRune(m.separators, r)
+		})
+	}
+
+	seen := make(map[string]struct{}, len(split))
+
+	var out []string
+	for _, g := range split {
+		g = strings.TrimSpace(g)
+		if g == "" {
+			continue
+		}
+
+		if alias, ok := m.aliases[strings.ToLower(g)]; ok {
+			g = alias
+		}
+
+		// Deduplicate genres which map to the same alias
+		key := strings.ToLower(g)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		out = append(out, g)
+	}
+
+	return out
+}
+
+// Sources returns the raw genres which are aliases of the canonical genre,
+// in lower case and sorted.
+func (m *genreMap) Sources(genre string) []string {
+	var out []string
+	for k, v := range m.aliases {
+		if strings.EqualFold(v, genre) {
+			out = append(out, k)
+		}
+	}
+
+	sort.Strings(out)
+	return out
+}
+
+// Primary returns the first normalized genre from a raw genre tag, for
+// Subsonic responses which only carry a single genre value.  If no genre
+// is present, empty string is returned.
+func (m *genreMap) Primary(raw string) string {
+	gs := m.Normalize(raw)
+	if len(gs) == 0 {
+		return ""
+	}
+
+	return gs[0]
+}
@@ -0,0 +1,64 @@
+package mpdsub
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_genreMapNormalize(t *testing.T) {
+	tests := []struct {
+		name       string
+		aliases    map[string]string
+		separators string
+		in         string
+		out        []string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "no aliases",
+			in:   "Rock",
+			out:  []string{"Rock"},
+		},
+		{
+			name: "no separators, multi-valued genre kept intact",
+			in:   "Rock; Pop",
+			out:  []string{"Rock; Pop"},
+		},
+		{
+			name: "alias, case-insensitive",
+			aliases: map[string]string{
+				"Alt Rock": "Alternative Rock",
+			},
+			in:  " alt rock ",
+			out: []string{"Alternative Rock"},
+		},
+		{
+			name:       "split on separators",
+			separators: ";/",
+			in:         "Rock; Pop/Jazz",
+			out:        []string{"Rock", "Pop", "Jazz"},
+		},
+		{
+			name: "split, alias, and deduplicate",
+			aliases: map[string]string{
+				"Alt Rock":    "Alternative Rock",
+				"Alternative": "Alternative Rock",
+			},
+			separators: ";/",
+			in:         "Alt Rock;Alternative/;alternative rock",
+			out:        []string{"Alternative Rock"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := newGenreMap(tt.aliases, tt.separators).Normalize(tt.in)
+
+			if want, got := tt.out, out; !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected genres:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package mpdsub
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+const (
+	// defaultSongsByGenre is the number of songs returned by getSongsByGenre
+	// when a client does not specify a count.
+	defaultSongsByGenre = 10
+
+	// maxSongsByGenre is the maximum number of songs returned by a single
+	// getSongsByGenre request.
+	maxSongsByGenre = 500
+)
+
+// A genreCount is a genre and the number of songs and albums which have it.
+type genreCount struct {
+	Name   string
+	Songs  int
+	Albums int
+}
+
+// A genreCache caches the genre counts visible to each user until MPD's
+// database is updated.
+type genreCache struct {
+	mu     sync.Mutex
+	update string
+	users  map[string][]genreCount
+}
+
+// getGenres is used in Subsonic to retrieve the genres in MPD's database,
+// with the number of songs and albums in each genre.
+func (s *Server) getGenres(w http.ResponseWriter, r *http.Request) {
+	counts, err := s.genreCounts(requestContextFrom(r).User)
+	if err != nil {
+		s.logf("error retrieving genres from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	genres := make([]genre, 0, len(counts))
+	for _, g := range counts {
+		genres = append(genres, genre{
+			Name:       g.Name,
+			SongCount:  g.Songs,
+			AlbumCount: g.Albums,
+		})
+	}
+
+	writeXML(w, func(c *container) {
+		c.Genres = &genresContainer{
+			Genres: genres,
+		}
+	})
+}
+
+// genreCounts returns the genres of the songs visible to user and their
+// counts, ordered by name.  Counts are cached until MPD's database is
+// updated.
+func (s *Server) genreCounts(user string) ([]genreCount, error) {
+	stats, err := s.db.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	s.genreCache.mu.Lock()
+	defer s.genreCache.mu.Unlock()
+
+	if s.genreCache.users == nil || s.genreCache.update != stats["db_update"] {
+		s.genreCache.update = stats["db_update"]
+		s.genreCache.users = make(map[string][]genreCount)
+	}
+
+	if counts, ok := s.genreCache.users[user]; ok {
+		return counts, nil
+	}
+
+	songs, err := s.db.ListAllInfo("")
+	if err != nil {
+		return nil, err
+	}
+
+	// Genres are matched case-insensitively, and named after the first
+	// spelling encountered
+	byKey := make(map[string]*genreCount)
+	albums := make(map[string]map[string]struct{})
+	for _, a := range songs {
+		name := a["file"]
+		if name == "" || !s.visible(user, name) || s.filteredGenre(user, a["Genre"]) {
+			continue
+		}
+
+		for _, g := range s.genres.Normalize(a["Genre"]) {
+			key := strings.ToLower(g)
+
+			gc, ok := byKey[key]
+			if !ok {
+				gc = &genreCount{Name: g}
+				byKey[key] = gc
+				albums[key] = make(map[string]struct{})
+			}
+			gc.Songs++
+
+			if dir := albumDir(name); dir != "" {
+				albums[key][dir] = struct{}{}
+			}
+		}
+	}
+
+	counts := make([]genreCount, 0, len(byKey))
+	for key, gc := range byKey {
+		gc.Albums = len(albums[key])
+		counts = append(counts, *gc)
+	}
+	sort.Sort(byGenreName(counts))
+
+	s.genreCache.users[user] = counts
+	return counts, nil
+}
+
+// getSongsByGenre is used in Subsonic to retrieve a page of the songs in a
+// genre, optionally in a single music folder.
+func (s *Server) getSongsByGenre(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	genre := q.Get("genre")
+	if genre == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	var (
+		count  = defaultSongsByGenre
+		offset = 0
+		folder = musicFolderAll
+	)
+
+	ints := []struct {
+		name string
+		v    *int
+	}{
+		{name: "count", v: &count},
+		{name: "offset", v: &offset},
+		{name: "musicFolderId", v: &folder},
+	}
+
+	for _, i := range ints {
+		qv := q.Get(i.name)
+		if qv == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(qv)
+		if err != nil {
+			writeXML(w, errGeneric)
+			return
+		}
+		*i.v = n
+	}
+
+	if count < 0 || offset < 0 {
+		writeXML(w, errGeneric)
+		return
+	}
+	if count > maxSongsByGenre {
+		count = maxSongsByGenre
+	}
+
+	user := requestContextFrom(r).User
+
+	songs, err := s.genreSongs(user, genre, folder)
+	if err != nil {
+		s.logf("error retrieving songs by genre from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	start, end := page(len(songs), offset, count)
+
+	children, err := s.songChildren(user, songs[start:end])
+	if err != nil {
+		s.logf("error retrieving songs by genre from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	out := make([]song, 0, len(children))
+	for _, c := range children {
+		out = append(out, song{child: c})
+	}
+
+	writeXML(w, func(c *container) {
+		c.SongsByGenre = &songsByGenreContainer{
+			Songs: out,
+		}
+	})
+}
+
+// genreSongs retrieves the songs in a genre and music folder which are
+// visible to user.
+func (s *Server) genreSongs(user string, genre string, folder int) ([]mpd.Attrs, error) {
+	// Raw genre tags can be matched exactly by MPD.  Normalized genres may
+	// be one of several in a tag, or be an alias of other genres, so search
+	// for tags containing the genre or its aliases and verify the normalized
+	// genres match instead
+	normalized := s.cfg.GenreSeparators != "" || len(s.cfg.GenreAliases) > 0
+
+	var (
+		songs []mpd.Attrs
+		err   error
+	)
+	if normalized {
+		songs, err = s.searchGenres(append([]string{genre}, s.genres.Sources(genre)...))
+	} else {
+		songs, err = s.db.Find("genre", genre)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]mpd.Attrs, 0, len(songs))
+	for _, a := range songs {
+		name := a["file"]
+		if name == "" || !s.inMusicFolder(name, folder) {
+			continue
+		}
+		if !s.visible(user, name) || s.filteredGenre(user, a["Genre"]) {
+			continue
+		}
+		if normalized && !s.hasGenre(a["Genre"], genre) {
+			continue
+		}
+
+		out = append(out, a)
+	}
+
+	return out, nil
+}
+
+// searchGenres searches for the songs whose genre tags contain any of genres,
+// returning each song once.
+func (s *Server) searchGenres(genres []string) ([]mpd.Attrs, error) {
+	seen := make(map[string]struct{})
+
+	var out []mpd.Attrs
+	for _, g := range genres {
+		songs, err := s.db.Search("genre", g)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, a := range songs {
+			if _, ok := seen[a["file"]]; ok {
+				continue
+			}
+			seen[a["file"]] = struct{}{}
+
+			out = append(out, a)
+		}
+	}
+
+	return out, nil
+}
+
+// byGenreName sorts genreCounts by their names, case-insensitively.
+type byGenreName []genreCount
+
+func (b byGenreName) Len() int { return len(b) }
+func (b byGenreName) Less(i, j int) bool {
+	return strings.ToLower(b[i].Name) < strings.ToLower(b[j].Name)
+}
+func (b byGenreName) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,188 @@
+package mpdsub
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_getGenres(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Abba/Gold/1.mp3",
+			"Abba/Gold/2.mp3",
+			"Queen/Innuendo/1.mp3",
+			"Secret/1.mp3",
+			"root.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "Abba/Gold/1.mp3", "Genre": "Pop"},
+			{"file": "Abba/Gold/2.mp3", "Genre": "pop; Disco"},
+			{"file": "Queen/Innuendo/1.mp3", "Genre": "Rock"},
+			{"file": "Secret/1.mp3", "Genre": "Jazz"},
+			{"file": "root.mp3", "Genre": "Rock"},
+		},
+		dbUpdate: "1",
+	}
+
+	cfg, values := configAuth()
+	cfg.GenreSeparators = ";"
+	cfg.FolderUsers = map[string][]string{
+		"Secret": {"someone"},
+	}
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getGenres.view", values))
+
+		if c.Genres == nil {
+			t.Fatal("no genres in response")
+		}
+
+		want := []genre{
+			{Name: "Disco", SongCount: 1, AlbumCount: 1},
+			{Name: "Pop", SongCount: 2, AlbumCount: 1},
+			{Name: "Rock", SongCount: 2, AlbumCount: 1},
+		}
+
+		if got := c.Genres.Genres; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected genres:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		// Counts are cached until the database is updated
+		db.mu.Lock()
+		db.songs = append(db.songs, mpd.Attrs{"file": "root.mp3", "Genre": "Blues"})
+		db.mu.Unlock()
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getGenres.view", values))
+		if want, got := 3, len(c.Genres.Genres); want != got {
+			t.Fatalf("unexpected number of cached genres:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		db.mu.Lock()
+		db.dbUpdate = "2"
+		db.mu.Unlock()
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getGenres.view", values))
+		if want, got := 4, len(c.Genres.Genres); want != got {
+			t.Fatalf("unexpected number of genres after update:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
+
+func TestServer_getSongsByGenre(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Music/a.mp3",
+			"Music/b.mp3",
+			"Music/d.mp3",
+			"Music/e.mp3",
+			"Podcasts/c.mp3",
+		},
+		finds: map[string][]mpd.Attrs{
+			"genre Rock": {
+				{"file": "Music/a.mp3", "Title": "a", "Genre": "Rock"},
+				{"file": "Music/b.mp3", "Title": "b", "Genre": "Rock"},
+				{"file": "Podcasts/c.mp3", "Title": "c", "Genre": "Rock"},
+			},
+		},
+		searches: map[string][]mpd.Attrs{
+			"genre Rock": {
+				{"file": "Music/a.mp3", "Title": "a", "Genre": "Rock; Pop"},
+				{"file": "Music/b.mp3", "Title": "b", "Genre": "Punk Rock"},
+			},
+			"genre alt rock": {
+				{"file": "Music/d.mp3", "Title": "d", "Genre": "Alt Rock"},
+			},
+			"genre Alternative Rock": {
+				{"file": "Music/e.mp3", "Title": "e", "Genre": "Alternative Rock"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		params     map[string]string
+		separators string
+		aliases    map[string]string
+		err        bool
+		code       int
+		titles     []string
+	}{
+		{
+			name: "missing genre",
+			err:  true,
+			code: codeMissingParameter,
+		},
+		{
+			name:   "invalid count",
+			params: map[string]string{"genre": "Rock", "count": "foo"},
+			err:    true,
+			code:   codeGeneric,
+		},
+		{
+			name:   "OK",
+			params: map[string]string{"genre": "Rock"},
+			titles: []string{"a", "b", "c"},
+		},
+		{
+			name:   "count and offset",
+			params: map[string]string{"genre": "Rock", "count": "1", "offset": "1"},
+			titles: []string{"b"},
+		},
+		{
+			name:   "music folder",
+			params: map[string]string{"genre": "Rock", "musicFolderId": strconv.Itoa(topLevelFolderID("Podcasts"))},
+			titles: []string{"c"},
+		},
+		{
+			name:       "normalized genres",
+			params:     map[string]string{"genre": "Rock"},
+			separators: ";",
+			titles:     []string{"a"},
+		},
+		{
+			name:    "aliased genres",
+			params:  map[string]string{"genre": "Alternative Rock"},
+			aliases: map[string]string{"Alt Rock": "Alternative Rock"},
+			titles:  []string{"e", "d"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.TopLevelFolders = true
+			cfg.GenreSeparators = tt.separators
+			cfg.GenreAliases = tt.aliases
+			for k, v := range tt.params {
+				values.Set(k, v)
+			}
+
+			withServer(t, db, nil, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getSongsByGenre.view", values))
+
+				if tt.err {
+					if c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+					if want, got := tt.code, c.Error.Code; want != got {
+						t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+					}
+					return
+				}
+
+				var titles []string
+				for _, s := range c.SongsByGenre.Songs {
+					titles = append(titles, s.Title)
+				}
+
+				if want, got := tt.titles, titles; !reflect.DeepEqual(want, got) {
+					t.Fatalf("unexpected songs:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		})
+	}
+}
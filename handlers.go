@@ -3,77 +3,78 @@ package mpdsub
 import (
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
-	"unicode/utf8"
+
+	"github.com/fhs/gompd/mpd"
 )
 
 // getLicense returns a license that is always valid.
 func (s *Server) getLicense(w http.ResponseWriter, r *http.Request) {
 	writeXML(w, func(c *container) {
+		c.ServerName = s.cfg.ServerName
+
 		// A license that indicates valid "true" allows Subsonic
 		// clients to connect to this server
-		c.License = &license{Valid: true}
+		c.License = &license{
+			Valid:          true,
+			Email:          s.cfg.LicenseEmail,
+			WelcomeMessage: s.cfg.WelcomeMessage,
+		}
+	})
+}
+
+// openSubsonicExtensions are the OpenSubsonic extensions supported by a
+// Server.
+var openSubsonicExtensions = []openSubsonicExtension{
+	{Name: "songLyrics", Versions: []int{1}},
+	// The detail attribute of errors, see errDetail
+	{Name: "errorDetail", Versions: []int{1}},
+}
+
+// getOpenSubsonicExtensions is used in OpenSubsonic to discover which
+// extensions to the Subsonic API are supported.
+func (s *Server) getOpenSubsonicExtensions(w http.ResponseWriter, r *http.Request) {
+	writeXML(w, func(c *container) {
+		c.OpenSubsonicExtensions = openSubsonicExtensions
 	})
 }
 
 // getIndexes returns a set of top-level indexes that indicate the top-level
 // items and directories.
 func (s *Server) getIndexes(w http.ResponseWriter, r *http.Request) {
+	user := requestContextFrom(r).User
+
+	folder := musicFolderAll
+	if qFolder := r.URL.Query().Get("musicFolderId"); qFolder != "" {
+		var err error
+		folder, err = strconv.Atoi(qFolder)
+		if err != nil {
+			writeXML(w, errGeneric)
+			return
+		}
+	}
+
 	fs, err := s.db.List("file")
 	if err != nil {
 		s.logf("error listing files from mpd for building indexes: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+	artists := s.indexArtists(user, indexFiles(fs), folder)
+
+	indexes, ok := s.lazyIndexes(r, groupIndexes(artists, s.collation(user) != ""))
+	if !ok {
 		writeXML(w, errGeneric)
 		return
 	}
-	files := indexFiles(fs)
 
 	writeXML(w, func(c *container) {
 		c.Indexes = &indexesContainer{
-			LastModified: time.Now().Unix(),
-		}
-
-		// Incremented whenever it's time to create a new index for a new
-		// initial letter
-		idx := -1
-
-		var indexes []index
-
-		// A set of initial characters, used to deduplicate the addition of
-		// nwe indexes
-		seenChars := make(map[rune]struct{}, 0)
-
-		for _, f := range files {
-			// Filter any non-top level items
-			if strings.Contains(f.Name, string(os.PathSeparator)) {
-				continue
-			}
-
-			// Initial rune is used to create an index name
-			c, _ := utf8.DecodeRuneInString(f.Name)
-			name := string(c)
-
-			// If initial rune is a digit, put index under a numeric section
-			if unicode.IsDigit(c) {
-				c = '#'
-				name = "#"
-			}
-
-			// If a new rune appears, create a new index for it
-			if _, ok := seenChars[c]; !ok {
-				seenChars[c] = struct{}{}
-				indexes = append(indexes, index{Name: name})
-				idx++
-			}
-
-			indexes[idx].Artists = append(indexes[idx].Artists, artist{
-				Name: f.Name,
-				ID:   strconv.Itoa(f.ID),
-			})
+			LastModified:    time.Now().Unix(),
+			IgnoredArticles: strings.Join(s.ignoredArticles(), " "),
 		}
 
 		c.Indexes.Indexes = indexes
@@ -88,24 +89,54 @@ func (s *Server) getMusicDirectory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := strconv.Atoi(qID)
+	fs, err := s.db.List("file")
 	if err != nil {
-		writeXML(w, errGeneric)
+		s.logf("error listing files from mpd for getting music directory: %v", err)
+		writeXML(w, errMPD(err))
 		return
 	}
 
-	fs, err := s.db.List("file")
-	if err != nil {
-		s.logf("error listing files from mpd for getting music directory: %v", err)
+	user := requestContextFrom(r).User
+
+	indexed := indexFiles(fs)
+
+	id, ok := s.parseID(qID, len(indexed))
+	if !ok {
 		writeXML(w, errGeneric)
 		return
 	}
+	if id < len(indexed) && !s.visible(user, indexed[id].Name) {
+		writeXML(w, errNotAuthorized)
+		return
+	}
+
+	// Excluded subtrees may only be browsed through the excluded music
+	// folder, if it is enabled
+	excluded := id < len(indexed) && s.exclude.Excluded(indexed[id].Name)
+	if excluded && s.cfg.ExcludedFolder == "" {
+		writeXML(w, errNotFound)
+		return
+	}
 
-	files, err := tagFiles(s.db, filterFiles(indexFiles(fs), id))
+	filtered := filterFiles(indexed, id)
+
+	// Include the contents of any merged directories
+	if id < len(indexed) {
+		for _, d := range s.mergedDirs(user, indexed, indexed[id]) {
+			filtered = append(filtered, filterFiles(indexed, d.ID)...)
+		}
+	}
+
+	// Hide excluded items, unless browsing within an excluded directory
+	if !excluded {
+		filtered = s.exclude.filterIndexed(filtered)
+	}
+
+	files, err := tagFiles(s.db, filtered)
 	if err != nil {
 		log.Println(err)
 		s.logf("error tagging files from mpd for getting music directory: %v", err)
-		writeXML(w, errGeneric)
+		writeXML(w, errMPD(err))
 		return
 	}
 
@@ -115,43 +146,97 @@ func (s *Server) getMusicDirectory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	modified, err := s.modTimes()
+	if err != nil {
+		s.logf("error retrieving songs from mpd for getting music directory: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	stats := s.playStats(user)
+	stars := s.stars(user)
+
 	var children []child
 	for _, f := range files {
-		ext := strings.TrimPrefix(filepath.Ext(f.Name), ".")
-		children = append(children, child{
-			ID:     strconv.Itoa(f.ID),
-			Album:  f.Album,
-			Artist: f.Artist,
-			IsDir:  f.Dir,
-			Suffix: ext,
-			Title:  f.Title,
-		})
+		if !s.visible(user, f.Name) || (!f.Dir && s.filteredGenre(user, f.Genre)) {
+			continue
+		}
+
+		ext := strings.TrimPrefix(path.Ext(f.Name), ".")
+		c := child{
+			ID:       s.formatID(f.ID),
+			Parent:   qID,
+			Album:    f.Album,
+			Artist:   f.Artist,
+			CoverArt: s.formatID(f.ID),
+			Genre:    s.genres.Primary(f.Genre),
+			IsDir:    f.Dir,
+			Suffix:   ext,
+			Title:    f.Title,
+
+			DisplayArtist:      displayArtist(f.Artist),
+			DisplayAlbumArtist: displayArtist(f.AlbumArtist),
+			Comment:            f.Comment,
+			Moods:              f.Moods,
+			CatalogNumber:      f.CatalogNumber,
+		}
+		if f.Label != "" {
+			c.RecordLabels = []recordLabel{{Name: f.Label}}
+		}
+
+		created := modified[f.Name]
+		if created.IsZero() && !f.Dir {
+			created = s.songCreated(mpd.Attrs{"file": f.Name})
+		}
+		c.Created = formatTime(created)
+		c.Starred = formatTime(stars[s.itemKey(f.Name)])
+
+		// Directories are displayed as albums by Subsonic clients
+		ps := stats[s.itemKey(f.Name)]
+		if f.Dir {
+			c.SortName = f.AlbumSort
+			if c.SortName == "" {
+				c.SortName = s.sortName(f.Title)
+			}
+
+			ps = dirPlayStats(stats, s.itemKey(f.Name))
+		}
+		c.PlayCount, c.Played = ps.Count, ps.played()
+
+		children = append(children, c)
 	}
 
 	writeXML(w, func(c *container) {
 		c.MusicDirectory = &musicDirectoryContainer{
-			ID:       strconv.Itoa(id),
+			ID:       s.formatID(id),
 			Name:     files[0].Name,
 			Children: children,
 		}
 	})
 }
 
-// getMusicFolders returns the location of MPD's music directory.
+// getMusicFolders returns the music folders visible to the user.
 func (s *Server) getMusicFolders(w http.ResponseWriter, r *http.Request) {
+	folders, err := s.musicFolders(requestContextFrom(r).User)
+	if err != nil {
+		s.logf("error listing files from mpd for getting music folders: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
 	writeXML(w, func(c *container) {
 		c.MusicFolders = &musicFoldersContainer{
-			MusicFolders: []musicFolder{{
-				ID:   0,
-				Name: filepath.Base(s.cfg.MusicDirectory),
-			}},
+			MusicFolders: folders,
 		}
 	})
 }
 
 // ping returns an empty response to indicate the server is working.
 func (s *Server) ping(w http.ResponseWriter, r *http.Request) {
-	writeXML(w, nil)
+	writeXML(w, func(c *container) {
+		c.ServerName = s.cfg.ServerName
+		c.Degraded = s.degraded()
+	})
 }
 
 // stream opens a file for streaming, and serves it to a client.
@@ -162,19 +247,19 @@ func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := strconv.Atoi(qID)
+	fs, err := s.db.List("file")
 	if err != nil {
-		writeXML(w, errGeneric)
+		s.logf("error listing files from mpd for streaming: %v", err)
+		writeXML(w, errMPD(err))
 		return
 	}
+	files := indexFiles(fs)
 
-	fs, err := s.db.List("file")
-	if err != nil {
-		s.logf("error listing files from mpd for streaming: %v", err)
+	id, ok := s.parseID(qID, len(files))
+	if !ok {
 		writeXML(w, errGeneric)
 		return
 	}
-	files := indexFiles(fs)
 
 	// Don't allow out of bounds slice access
 	if id >= len(files) {
@@ -182,12 +267,83 @@ func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	p := filepath.Join(s.cfg.MusicDirectory, files[id].Name)
+	if !s.canAccess(w, requestContextFrom(r).User, files[id]) {
+		return
+	}
+
+	if r.Method != http.MethodHead {
+		rctx := requestContextFrom(r)
+
+		// Repeated requests for a song while it plays, such as when a
+		// client seeks or reconnects, are part of the same listen
+		window := func() time.Duration {
+			a, err := s.songAttrs(files[id].Name)
+			if err != nil {
+				s.logf("error retrieving song from mpd for %q: %v", files[id].Name, err)
+				return defaultNowPlayingDuration
+			}
+
+			return scrobbleWindow(a)
+		}
+
+		if err := s.recordPlay(rctx.User, rctx.Client, files[id].Name, time.Now(), window); err != nil {
+			s.logf("error recording listening history for %q: %v", rctx.User, err)
+		}
+
+		s.events.publish(eventStream)
+	}
+
+	p := s.musicPath(files[id].Name)
+
+	// Transcode the file if the client requests a configured format, or
+	// if a default format is configured for the client
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" && q.Get("maxBitRate") == "" {
+		format = s.clientFormat(requestContextFrom(r).Client)
+	}
+
+	if t, ok := s.transcoder(format); ok {
+		bitRate, _ := strconv.Atoi(q.Get("maxBitRate"))
+		offset, _ := strconv.Atoi(q.Get("timeOffset"))
+
+		if ct, ok := s.transcodes.cached(t, p, bitRate); ok && (offset == 0 || ct.seekable(t)) {
+			s.serveCachedTranscode(w, r, t, ct, files[id].Name, offset)
+			return
+		}
+
+		// Output which is still being transcoded cannot be resumed at an
+		// arbitrary byte offset
+		w.Header().Set(contentType, t.ContentType)
+		w.Header().Set("Accept-Ranges", "none")
+		cw := &countWriter{w: w}
+		if err := s.transcodes.transcode(r.Context(), cw, t, p, offset, bitRate); err != nil {
+			s.logf("error transcoding %q to %s: %v", p, t.Format, err)
+
+			// An error can only be reported if no output was sent
+			if cw.n == 0 {
+				writeXML(w, errDetail(detailTranscoderFailed))
+			}
+		}
+
+		return
+	}
+
+	s.serveFile(w, r, p)
+}
+
+// serveFile serves the original file at p, either from the local filesystem
+// or by proxying it from the remote music directory.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, p string) {
+	if s.musicURL != nil {
+		s.proxyStream(w, r, p)
+		return
+	}
 
 	f, err := s.fs.Open(p)
 	if err != nil {
 		s.logf("error opening file for streaming: %q", p)
-		writeXML(w, errGeneric)
+		writeXML(w, errDetail(detailFileMissing))
 		return
 	}
 	defer f.Close()
@@ -195,10 +351,13 @@ func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
 	stat, err := f.Stat()
 	if err != nil {
 		s.logf("error stat'ing file for streaming: %q", p)
-		writeXML(w, errGeneric)
+		writeXML(w, errDetail(detailFileMissing))
 		return
 	}
 
+	// A strong ETag enables clients to resume interrupted downloads using
+	// If-Range, and to restart them if the file changed in the meantime
+	w.Header().Set("ETag", fileETag(p, stat))
 	http.ServeContent(w, r, p, stat.ModTime(), f)
 }
 
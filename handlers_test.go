@@ -2,6 +2,9 @@ package mpdsub
 
 import (
 	"encoding/xml"
+	"errors"
+	"io"
+	"net"
 	"net/http"
 	"path/filepath"
 	"reflect"
@@ -155,16 +158,29 @@ func TestServer_getIndexes(t *testing.T) {
 
 func TestServer_getLicense(t *testing.T) {
 	tests := []struct {
-		name string
+		name    string
+		server  string
+		email   string
+		welcome string
 	}{
 		{
 			name: "OK",
 		},
+		{
+			name:    "branding",
+			server:  "Home",
+			email:   "admin@example.com",
+			welcome: "Welcome home!",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cfg, values := configAuth()
+			cfg.ServerName = tt.server
+			cfg.LicenseEmail = tt.email
+			cfg.WelcomeMessage = tt.welcome
+
 			withServer(t, nil, nil, cfg, func(base string) {
 				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getLicense.view", values))
 
@@ -175,6 +191,18 @@ func TestServer_getLicense(t *testing.T) {
 				if want, got := true, c.License.Valid; want != got {
 					t.Fatalf("unexpected license valid value:\n- want: %v\n-  got: %v", want, got)
 				}
+
+				if want, got := tt.server, c.ServerName; want != got {
+					t.Fatalf("unexpected server name:\n- want: %q\n-  got: %q", want, got)
+				}
+
+				if want, got := tt.email, c.License.Email; want != got {
+					t.Fatalf("unexpected license email:\n- want: %q\n-  got: %q", want, got)
+				}
+
+				if want, got := tt.welcome, c.License.WelcomeMessage; want != got {
+					t.Fatalf("unexpected welcome message:\n- want: %q\n-  got: %q", want, got)
+				}
 			})
 		})
 	}
@@ -237,19 +265,26 @@ func TestServer_getMusicDirectory(t *testing.T) {
 
 				Children: []child{
 					{
-						ID:     "1",
-						Suffix: "mp3",
-						Title:  "foo",
+						ID:       "1",
+						Parent:   "0",
+						CoverArt: "1",
+						Suffix:   "mp3",
+						Title:    "foo",
 					},
 					{
-						ID:     "2",
-						Suffix: "mp3",
-						Title:  "bar",
+						ID:       "2",
+						Parent:   "0",
+						CoverArt: "2",
+						Suffix:   "mp3",
+						Title:    "bar",
 					},
 					{
-						ID:    "3",
-						Title: "bar",
-						IsDir: true,
+						ID:       "3",
+						Parent:   "0",
+						CoverArt: "3",
+						Title:    "bar",
+						IsDir:    true,
+						SortName: "bar",
 					},
 				},
 			},
@@ -556,3 +591,139 @@ func mustIndexesEqual(t *testing.T, a []index, b []index) {
 		})
 	}
 }
+
+func TestServer_errorDetail(t *testing.T) {
+	tests := []struct {
+		name   string
+		db     database
+		path   string
+		detail string
+	}{
+		{
+			name: "MPD error",
+			db: &flakyDatabase{
+				memoryDatabase: &memoryDatabase{files: []string{"foo.mp3"}},
+				down:           true,
+				err:            errors.New("ACK [50@0] {list} failed"),
+			},
+			path:   "/rest/getIndexes.view",
+			detail: detailMPDError,
+		},
+		{
+			name: "MPD unreachable",
+			db: &flakyDatabase{
+				memoryDatabase: &memoryDatabase{files: []string{"foo.mp3"}},
+				down:           true,
+			},
+			path:   "/rest/getIndexes.view",
+			detail: detailMPDUnreachable,
+		},
+		{
+			name:   "file missing",
+			db:     &memoryDatabase{files: []string{"foo.mp3"}},
+			path:   "/rest/stream.view",
+			detail: detailFileMissing,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.MusicDirectory = "/music"
+			values.Set("id", "0")
+
+			withServer(t, tt.db, &memoryFilesystem{}, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, tt.path, values))
+				if c.Error == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+
+				if want, got := tt.detail, c.Error.Detail; want != got {
+					t.Fatalf("unexpected error detail:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		})
+	}
+}
+
+func Test_errMPD(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		detail string
+	}{
+		{
+			name:   "connection refused",
+			err:    &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+			detail: detailMPDUnreachable,
+		},
+		{
+			name:   "timeout",
+			err:    errTimeout,
+			detail: detailMPDUnreachable,
+		},
+		{
+			name:   "connection closed",
+			err:    io.EOF,
+			detail: detailMPDUnreachable,
+		},
+		{
+			name:   "command failed",
+			err:    errors.New("No such song"),
+			detail: detailMPDError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c container
+			errMPD(tt.err)(&c)
+
+			if want, got := codeGeneric, c.Error.Code; want != got {
+				t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+			}
+			if want, got := tt.detail, c.Error.Detail; want != got {
+				t.Fatalf("unexpected error detail:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestServer_getMusicDirectoryTimestamps(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"foo/bar/a.mp3",
+			"foo/bar/b.mp3",
+			"foo/c.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "foo/bar/a.mp3", "Last-Modified": "2019-01-02T03:04:05Z"},
+			{"file": "foo/bar/b.mp3", "Last-Modified": "2020-01-02T03:04:05Z"},
+			{"file": "foo/c.mp3", "Last-Modified": "2018-01-02T03:04:05Z"},
+		},
+	}
+
+	cfg, values := configAuth()
+	values.Set("id", "0")
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getMusicDirectory.view", values))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %v", c.Error.Message)
+		}
+
+		created := make(map[bool]string)
+		for _, ch := range c.MusicDirectory.Children {
+			created[ch.IsDir] = ch.Created
+		}
+
+		// Directories were created when their newest song was
+		want := map[bool]string{
+			true:  "2020-01-02T03:04:05Z",
+			false: "2018-01-02T03:04:05Z",
+		}
+		if !reflect.DeepEqual(want, created) {
+			t.Fatalf("unexpected created times:\n- want: %v\n-  got: %v", want, created)
+		}
+	})
+}
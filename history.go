@@ -0,0 +1,303 @@
+package mpdsub
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+const (
+	// defaultHistoryRetention is the default amount of time for which
+	// listening history is retained.
+	defaultHistoryRetention = 30 * 24 * time.Hour
+
+	// defaultHistoryCount is the default number of entries returned by
+	// getHistory.
+	defaultHistoryCount = 50
+
+	// defaultNowPlayingDuration is how long a song with an unknown duration
+	// is considered to be playing after it is streamed.
+	defaultNowPlayingDuration = 10 * time.Minute
+
+	// mpdPlayerName is the name of the player reported by getNowPlaying
+	// for the song MPD is playing.
+	mpdPlayerName = "MPD"
+)
+
+// A historyEntry records a song streamed by a user.
+type historyEntry struct {
+	File   string    `json:"file"`
+	Client string    `json:"client,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// historyRetention returns the amount of time for which listening history
+// is retained.
+func (s *Server) historyRetention() time.Duration {
+	if s.cfg.HistoryRetention > 0 {
+		return s.cfg.HistoryRetention
+	}
+
+	return defaultHistoryRetention
+}
+
+// recordPlay adds a song streamed by user to their listening history and
+// play statistics, and removes entries which are older than the retention
+// window.  If the same client streamed the same song less than window ago,
+// such as when a client seeks or reconnects, the stream continues the same
+// listen and nothing is recorded.  window is only called for such repeated
+// streams, so that the song need not be looked up for every stream.
+func (s *Server) recordPlay(user, client, name string, now time.Time, window func() time.Duration) error {
+	var (
+		last historyEntry
+		ok   bool
+	)
+	s.store.View(func(d *storeData) {
+		if entries := d.History[user]; len(entries) > 0 {
+			last, ok = entries[len(entries)-1], true
+		}
+	})
+	if ok && last.File == name && last.Client == client && now.Sub(last.Time) < window() {
+		return nil
+	}
+
+	cutoff := now.Add(-s.historyRetention())
+
+	return s.store.Record(func(d *storeData) error {
+		var history []historyEntry
+		for _, e := range d.History[user] {
+			if e.Time.After(cutoff) {
+				history = append(history, e)
+			}
+		}
+
+		d.History[user] = append(history, historyEntry{
+			File:   name,
+			Client: client,
+			Time:   now,
+		})
+		d.addPlay(user, s.itemKey(name), now)
+		return nil
+	})
+}
+
+// A historySong is a song from a user's listening history, with its MPD
+// attributes and ID.
+type historySong struct {
+	historyEntry
+
+	User  string
+	ID    int
+	Attrs mpd.Attrs
+}
+
+// historySongs looks up the most recent max songs in a list of history
+// entries for each user, newest first, skipping songs which no longer exist
+// or are not visible to viewer.  If max is negative, every song is returned.
+func (s *Server) historySongs(viewer string, history map[string][]historyEntry, max int) ([]historySong, error) {
+	fs, err := s.db.List("file")
+	if err != nil {
+		return nil, err
+	}
+	ids := fileIDs(indexFiles(fs))
+
+	var out []historySong
+	for user, entries := range history {
+		for _, e := range entries {
+			id, ok := ids[e.File]
+			if !ok || !s.visible(viewer, e.File) {
+				continue
+			}
+
+			out = append(out, historySong{
+				historyEntry: e,
+				User:         user,
+				ID:           id,
+			})
+		}
+	}
+
+	// Most recently played songs first, and only look up the songs which
+	// are returned
+	sort.Sort(byPlayedDesc(out))
+	if max >= 0 && len(out) > max {
+		out = out[:max]
+	}
+
+	for i := range out {
+		songs, err := s.db.ListAllInfo(out[i].File)
+		if err != nil {
+			return nil, err
+		}
+
+		out[i].Attrs = mpd.Attrs{"file": out[i].File}
+		for _, a := range songs {
+			if a["file"] == out[i].File {
+				out[i].Attrs = a
+				break
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// byPlayedDesc sorts historySongs by the time they were played, newest first.
+type byPlayedDesc []historySong
+
+func (b byPlayedDesc) Len() int           { return len(b) }
+func (b byPlayedDesc) Less(i, j int) bool { return b[i].Time.After(b[j].Time) }
+func (b byPlayedDesc) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// getNowPlaying is used in Subsonic to retrieve the songs currently being
+// played by each user.  A song is considered to be playing if it was the
+// last song a user streamed or a client reported as playing, and the song's
+// duration has not yet elapsed.  The song MPD itself is playing, if any, is
+// reported first, as played by SubsonicUser on a player named "MPD".
+func (s *Server) getNowPlaying(w http.ResponseWriter, r *http.Request) {
+	user := requestContextFrom(r).User
+
+	var entries []nowPlayingEntry
+	if s.player != nil {
+		e, ok, err := s.mpdNowPlaying(user)
+		if err != nil {
+			// Songs streamed to clients can still be reported
+			s.logf("error retrieving current song from mpd: %v", err)
+		}
+		if ok {
+			entries = append(entries, e)
+		}
+	}
+
+	// Consider only each user's most recently streamed song
+	latest := make(map[string][]historyEntry)
+	s.store.View(func(d *storeData) {
+		for user, entries := range d.History {
+			if len(entries) > 0 {
+				latest[user] = entries[len(entries)-1:]
+			}
+		}
+	})
+	s.nowPlaying.latest(latest)
+
+	songs, err := s.historySongs(user, latest, -1)
+	if err != nil {
+		s.logf("error retrieving now playing songs: %v", err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	now := time.Now()
+
+	for _, sg := range songs {
+		d := time.Duration(songDuration(sg.Attrs)) * time.Second
+		if d == 0 {
+			d = defaultNowPlayingDuration
+		}
+
+		if now.After(sg.Time.Add(d)) {
+			continue
+		}
+
+		entries = append(entries, nowPlayingEntry{
+			child:      s.songChild(sg.ID, sg.Attrs),
+			Username:   sg.User,
+			MinutesAgo: int(now.Sub(sg.Time) / time.Minute),
+			PlayerName: sg.Client,
+		})
+	}
+
+	writeXML(w, func(c *container) {
+		c.NowPlaying = &nowPlayingContainer{
+			Entries: entries,
+		}
+	})
+}
+
+// mpdNowPlaying produces a nowPlayingEntry for the song MPD is playing, if
+// MPD is playing a song in its database which is visible to viewer.
+func (s *Server) mpdNowPlaying(viewer string) (nowPlayingEntry, bool, error) {
+	status, err := s.player.Status()
+	if err != nil {
+		return nowPlayingEntry{}, false, err
+	}
+	if status["state"] != "play" {
+		return nowPlayingEntry{}, false, nil
+	}
+
+	a, err := s.player.CurrentSong()
+	if err != nil {
+		return nowPlayingEntry{}, false, err
+	}
+
+	// Songs queued from URLs rather than MPD's database have no ID
+	name := a["file"]
+	if name == "" || !s.visible(viewer, name) || s.filteredGenre(viewer, a["Genre"]) {
+		return nowPlayingEntry{}, false, nil
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		return nowPlayingEntry{}, false, err
+	}
+	id, ok := fileIDs(indexFiles(fs))[name]
+	if !ok {
+		return nowPlayingEntry{}, false, nil
+	}
+
+	elapsed, _ := strconv.ParseFloat(status["elapsed"], 64)
+
+	return nowPlayingEntry{
+		child:      s.songChild(id, a),
+		Username:   s.cfg.SubsonicUser,
+		MinutesAgo: int(elapsed / 60),
+		PlayerName: mpdPlayerName,
+	}, true, nil
+}
+
+// getHistory is a custom endpoint used to retrieve the songs recently
+// streamed by the current user, newest first.  The optional count parameter
+// limits the number of songs returned.
+func (s *Server) getHistory(w http.ResponseWriter, r *http.Request) {
+	count := defaultHistoryCount
+	if qCount := r.URL.Query().Get("count"); qCount != "" {
+		n, err := strconv.Atoi(qCount)
+		if err != nil || n < 0 {
+			writeXML(w, errGeneric)
+			return
+		}
+		count = n
+	}
+
+	user := requestContextFrom(r).User
+
+	var history []historyEntry
+	s.store.View(func(d *storeData) {
+		history = append(history, d.History[user]...)
+	})
+
+	songs, err := s.historySongs(user, map[string][]historyEntry{user: history}, count)
+	if err != nil {
+		s.logf("error retrieving listening history: %v", err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	entries := make([]historyEntryXML, 0, len(songs))
+	for _, sg := range songs {
+		entries = append(entries, historyEntryXML{
+			child:  s.songChild(sg.ID, sg.Attrs),
+			Played: formatTime(sg.Time),
+			Client: sg.Client,
+		})
+	}
+
+	writeXML(w, func(c *container) {
+		c.History = &historyContainer{
+			Entries: entries,
+		}
+	})
+}
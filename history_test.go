@@ -0,0 +1,270 @@
+package mpdsub
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_streamHistory(t *testing.T) {
+	const musicDirectory = "/var/music"
+
+	db := &memoryDatabase{
+		files: []string{
+			"bar.mp3",
+			"foo.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "bar.mp3", "Title": "Bar", "duration": "600"},
+			{"file": "foo.mp3", "Title": "Foo", "duration": "300"},
+		},
+	}
+
+	fs := &memoryFilesystem{
+		files: map[string]*memoryFile{
+			filepath.Join(musicDirectory, "bar.mp3"): {ReadSeeker: strings.NewReader("bar")},
+			filepath.Join(musicDirectory, "foo.mp3"): {ReadSeeker: strings.NewReader("foo")},
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.MusicDirectory = musicDirectory
+	values.Set("c", "DSub")
+
+	withServer(t, db, fs, cfg, func(base string) {
+		for _, id := range []string{"0", "1"} {
+			values.Set("id", id)
+			res := testRequest(t, base, http.MethodGet, "/rest/stream.view", values)
+			_ = res.Body.Close()
+		}
+		values.Del("id")
+
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getHistory.view", values))
+		if c.History == nil {
+			t.Fatal("no history in response")
+		}
+
+		var titles []string
+		for _, e := range c.History.Entries {
+			if want, got := "DSub", e.Client; want != got {
+				t.Fatalf("unexpected client:\n- want: %v\n-  got: %v",
+					want, got)
+			}
+
+			titles = append(titles, e.Title)
+		}
+
+		if want, got := "Foo,Bar", strings.Join(titles, ","); want != got {
+			t.Fatalf("unexpected history:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getNowPlaying.view", values))
+		if c.NowPlaying == nil || len(c.NowPlaying.Entries) != 1 {
+			t.Fatalf("unexpected now playing: %+v", c.NowPlaying)
+		}
+
+		e := c.NowPlaying.Entries[0]
+		if want, got := "Foo", e.Title; want != got {
+			t.Fatalf("unexpected now playing title:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+		if want, got := cfg.SubsonicUser, e.Username; want != got {
+			t.Fatalf("unexpected now playing username:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+		if want, got := "DSub", e.PlayerName; want != got {
+			t.Fatalf("unexpected now playing player name:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+	})
+}
+
+func TestServer_getNowPlayingMPD(t *testing.T) {
+	p := newMemoryPlayer()
+	p.memoryDatabase = &memoryDatabase{
+		files: []string{
+			"bar.mp3",
+			"foo.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "bar.mp3", "Title": "Bar", "duration": "600"},
+			{"file": "foo.mp3", "Title": "Foo", "duration": "300"},
+		},
+	}
+	p.queue = []string{"foo.mp3"}
+
+	cfg, values := configAuth()
+
+	withServer(t, p, nil, cfg, func(base string) {
+		// Nothing is playing until MPD plays a song
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getNowPlaying.view", values))
+		if c.NowPlaying == nil || len(c.NowPlaying.Entries) != 0 {
+			t.Fatalf("unexpected now playing: %+v", c.NowPlaying)
+		}
+
+		p.playing = 0
+		p.elapsed = 150
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getNowPlaying.view", values))
+		if c.NowPlaying == nil || len(c.NowPlaying.Entries) != 1 {
+			t.Fatalf("unexpected now playing: %+v", c.NowPlaying)
+		}
+
+		e := c.NowPlaying.Entries[0]
+		if want, got := "1", e.ID; want != got {
+			t.Fatalf("unexpected now playing ID:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+		if want, got := "Foo", e.Title; want != got {
+			t.Fatalf("unexpected now playing title:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+		if want, got := mpdPlayerName, e.PlayerName; want != got {
+			t.Fatalf("unexpected now playing player name:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+		if want, got := 2, e.MinutesAgo; want != got {
+			t.Fatalf("unexpected now playing minutes ago:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+	})
+}
+
+func TestServer_recordPlay(t *testing.T) {
+	st, err := openStore("")
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	s := &Server{
+		cfg: &Config{
+			HistoryRetention: 24 * time.Hour,
+		},
+		store: st,
+	}
+
+	now := time.Date(2017, time.January, 2, 0, 0, 0, 0, time.UTC)
+
+	for _, e := range []struct {
+		name string
+		t    time.Time
+	}{
+		{name: "old.mp3", t: now.Add(-48 * time.Hour)},
+		{name: "recent.mp3", t: now.Add(-1 * time.Hour)},
+		{name: "new.mp3", t: now},
+	} {
+		if err := s.recordPlay("test", "web", e.name, e.t, func() time.Duration { return time.Minute }); err != nil {
+			t.Fatalf("failed to record play: %v", err)
+		}
+	}
+
+	var files []string
+	st.View(func(d *storeData) {
+		for _, e := range d.History["test"] {
+			files = append(files, e.File)
+		}
+	})
+
+	if want, got := "recent.mp3,new.mp3", strings.Join(files, ","); want != got {
+		t.Fatalf("unexpected history:\n- want: %v\n-  got: %v",
+			want, got)
+	}
+}
+
+func TestServer_recordPlayContinues(t *testing.T) {
+	st, err := openStore("")
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	s := &Server{
+		cfg:   &Config{},
+		store: st,
+	}
+
+	now := time.Now()
+	const window = 5 * time.Minute
+
+	for _, e := range []struct {
+		client string
+		t      time.Time
+	}{
+		// A seek and a reconnect continue the first listen
+		{client: "DSub", t: now.Add(-20 * time.Minute)},
+		{client: "DSub", t: now.Add(-19 * time.Minute)},
+		{client: "DSub", t: now.Add(-16 * time.Minute)},
+		// Another client is another listen
+		{client: "web", t: now.Add(-15 * time.Minute)},
+		// A later request after the song ended is another listen
+		{client: "web", t: now},
+	} {
+		if err := s.recordPlay("test", e.client, "foo.mp3", e.t, func() time.Duration { return window }); err != nil {
+			t.Fatalf("failed to record play: %v", err)
+		}
+	}
+
+	var (
+		times []time.Time
+		count int
+	)
+	st.View(func(d *storeData) {
+		for _, e := range d.History["test"] {
+			times = append(times, e.Time)
+		}
+		count = d.Plays["test"][s.itemKey("foo.mp3")].Count
+	})
+
+	if want, got := 3, len(times); want != got {
+		t.Fatalf("unexpected number of history entries:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := now.Add(-20*time.Minute), times[0]; !want.Equal(got) {
+		t.Fatalf("unexpected listen start time:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := 3, count; want != got {
+		t.Fatalf("unexpected play count:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestServer_recordPlayWindowLookup(t *testing.T) {
+	st, err := openStore("")
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	s := &Server{
+		cfg:   &Config{},
+		store: st,
+	}
+
+	var calls int
+	window := func() time.Duration {
+		calls++
+		return time.Hour
+	}
+
+	now := time.Now()
+	for i, name := range []string{"foo.mp3", "bar.mp3", "bar.mp3"} {
+		if err := s.recordPlay("test", "web", name, now.Add(time.Duration(i)*time.Second), window); err != nil {
+			t.Fatalf("failed to record play: %v", err)
+		}
+	}
+
+	// Only the repeated stream looks up the song, and it leaves the store
+	// unchanged
+	if want, got := 1, calls; want != got {
+		t.Fatalf("unexpected number of window lookups:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	var n int
+	st.View(func(d *storeData) {
+		n = len(d.History["test"])
+	})
+	if want, got := 2, n; want != got {
+		t.Fatalf("unexpected number of history entries:\n- want: %v\n-  got: %v", want, got)
+	}
+}
@@ -0,0 +1,291 @@
+package mpdsub
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// An id3Artist is an artist in the ID3 view of MPD's database, with the
+// albums tagged with its name.
+type id3Artist struct {
+	ID     int
+	Name   string
+	Albums []album
+}
+
+// id3Artists groups albums by their artist tags, and merges the albums of
+// each artist which share an album tag, such as the discs of a multi-disc
+// album stored in separate directories.  Albums without an album tag are
+// identified by their directories alone.  Each artist's ID follows the
+// shared ID scheme described in ids.go.  If several artists share the
+// directory which would identify them, such as a "Compilations" directory,
+// only the first is identified by it, and the others are identified by their
+// first album instead.
+func id3Artists(albums []album) []id3Artist {
+	byName := make(map[string]int)
+	byAlbum := make(map[[2]string]int)
+	taken := make(map[int]struct{})
+
+	var artists []id3Artist
+	for _, al := range albums {
+		// Fall back to the directory name, as getIndexes does
+		name := al.Artist
+		if name == "" {
+			name = path.Base(artistDir(al.Dir))
+		}
+
+		key := [2]string{name, al.Name}
+		if j, ok := byAlbum[key]; ok && al.Tagged {
+			i := byName[name]
+			artists[i].Albums[j].merge(al)
+			continue
+		}
+
+		i, ok := byName[name]
+		if !ok {
+			id := al.ArtistID
+			if _, ok := taken[id]; ok {
+				id = al.ID
+			}
+			taken[id] = struct{}{}
+
+			i = len(artists)
+			byName[name] = i
+			artists = append(artists, id3Artist{
+				ID:   id,
+				Name: name,
+			})
+		}
+
+		if al.Tagged {
+			byAlbum[key] = len(artists[i].Albums)
+		}
+		artists[i].Albums = append(artists[i].Albums, al)
+	}
+
+	return artists
+}
+
+// id3ArtistIDs maps the IDs of albums, and of the directories merged into
+// them, to the IDs of their ID3 artists.
+func id3ArtistIDs(artists []id3Artist) map[int]int {
+	out := make(map[int]int)
+	for _, a := range artists {
+		for _, al := range a.Albums {
+			out[al.ID] = a.ID
+			for _, p := range al.Parts {
+				out[p.ID] = a.ID
+			}
+		}
+	}
+
+	return out
+}
+
+// albumHasID reports whether an album, or one of the directories merged into it,
+// is identified by qID.
+func (s *Server) albumHasID(al album, qID string) bool {
+	if s.formatID(al.ID) == qID {
+		return true
+	}
+
+	for _, p := range al.Parts {
+		if s.formatID(p.ID) == qID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getArtists is used in Subsonic to retrieve a set of alphabetical indexes
+// of artists, organized by their tags rather than by directory.
+func (s *Server) getArtists(w http.ResponseWriter, r *http.Request) {
+	user := requestContextFrom(r).User
+
+	folder := musicFolderAll
+	if qFolder := r.URL.Query().Get("musicFolderId"); qFolder != "" {
+		var err error
+		folder, err = strconv.Atoi(qFolder)
+		if err != nil {
+			writeXML(w, errGeneric)
+			return
+		}
+	}
+
+	albums, err := s.albums(user, folder)
+	if err != nil {
+		s.logf("error retrieving albums from mpd for building artists: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	var artists []artist
+	for _, a := range id3Artists(albums) {
+		artists = append(artists, artist{
+			Name:       a.Name,
+			ID:         s.formatID(a.ID),
+			SortName:   s.sortName(a.Name),
+			AlbumCount: len(a.Albums),
+		})
+	}
+	s.sortArtists(user, artists)
+
+	indexes, ok := s.lazyIndexes(r, groupIndexes(artists, s.collation(user) != ""))
+	if !ok {
+		writeXML(w, errGeneric)
+		return
+	}
+
+	writeXML(w, func(c *container) {
+		c.Artists = &artistsContainer{
+			IgnoredArticles: strings.Join(s.ignoredArticles(), " "),
+			Indexes:         indexes,
+		}
+	})
+}
+
+// getArtist is used in Subsonic to retrieve an artist and its albums,
+// organized by their tags.
+func (s *Server) getArtist(w http.ResponseWriter, r *http.Request) {
+	qID := r.URL.Query().Get("id")
+	if qID == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	albums, err := s.albums(requestContextFrom(r).User, musicFolderAll)
+	if err != nil {
+		s.logf("error retrieving albums from mpd for getting artist: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	for _, a := range id3Artists(albums) {
+		if s.formatID(a.ID) != qID {
+			continue
+		}
+
+		out := make([]albumID3, 0, len(a.Albums))
+		for _, al := range a.Albums {
+			out = append(out, s.albumID3(al, a.ID))
+		}
+
+		writeXML(w, func(c *container) {
+			c.Artist = &artistID3{
+				ID:         qID,
+				Name:       a.Name,
+				AlbumCount: len(a.Albums),
+				Albums:     out,
+			}
+		})
+		return
+	}
+
+	writeXML(w, errNotFound)
+}
+
+// getAlbum is used in Subsonic to retrieve an album and its songs, organized
+// by their tags.
+func (s *Server) getAlbum(w http.ResponseWriter, r *http.Request) {
+	qID := r.URL.Query().Get("id")
+	if qID == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	user := requestContextFrom(r).User
+
+	albums, err := s.albums(user, musicFolderAll)
+	if err != nil {
+		s.logf("error retrieving albums from mpd for getting album: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	for _, a := range id3Artists(albums) {
+		for _, al := range a.Albums {
+			if !s.albumHasID(al, qID) {
+				continue
+			}
+
+			songs, err := s.albumSongs(user, al)
+			if err != nil {
+				s.logf("error retrieving songs from mpd for getting album: %v", err)
+				writeXML(w, errMPD(err))
+				return
+			}
+
+			out := s.albumID3(al, a.ID)
+			for _, c := range songs {
+				c.Parent = out.ID
+				c.AlbumID = out.ID
+				c.ArtistID = s.formatID(a.ID)
+
+				out.Songs = append(out.Songs, song{child: c})
+			}
+
+			writeXML(w, func(c *container) {
+				c.Album = &out
+			})
+			return
+		}
+	}
+
+	writeXML(w, errNotFound)
+}
+
+// albumSongs retrieves the songs in an album, including the directories
+// merged into it, which are visible to user.
+func (s *Server) albumSongs(user string, al album) ([]child, error) {
+	dirs := []string{al.Dir}
+	for _, p := range al.Parts {
+		dirs = append(dirs, p.Dir)
+	}
+
+	var songs []mpd.Attrs
+	for _, dir := range dirs {
+		attrs, err := s.db.ListAllInfo(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		// Songs in subdirectories belong to other albums
+		for _, a := range attrs {
+			if a["file"] != "" && albumDir(a["file"]) == dir {
+				songs = append(songs, a)
+			}
+		}
+	}
+
+	return s.songChildren(user, songs)
+}
+
+// albumID3 creates a Subsonic ID3 album from an album and the ID of its
+// artist.
+func (s *Server) albumID3(al album, artistID int) albumID3 {
+	a := albumID3{
+		ID:        s.formatID(al.ID),
+		Name:      al.Name,
+		Artist:    al.Artist,
+		ArtistID:  s.formatID(artistID),
+		CoverArt:  s.formatID(al.ID),
+		SongCount: al.Songs,
+		Duration:  al.Duration,
+		Year:      al.Year,
+		Genre:     s.genres.Primary(al.Genre),
+		PlayCount: al.Plays.Count,
+
+		Played:        al.Plays.played(),
+		SortName:      s.sortName(al.Name),
+		DisplayArtist: displayArtist(al.Artist),
+	}
+	a.Created = formatTime(al.Created)
+	a.Starred = formatTime(al.Starred)
+
+	return a
+}
@@ -0,0 +1,223 @@
+package mpdsub
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// id3Database returns a memoryDatabase with albums by several artists,
+// including two artists whose albums share a directory.
+func id3Database() *memoryDatabase {
+	return &memoryDatabase{
+		files: []string{
+			"Abba/Gold/1.mp3",
+			"Abba/Gold/2.mp3",
+			"Compilations/Hits/1.mp3",
+			"Compilations/More/1.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "Abba/Gold/1.mp3", "Title": "Dancing Queen", "Album": "Gold", "Artist": "Abba"},
+			{"file": "Abba/Gold/2.mp3", "Title": "Waterloo", "Album": "Gold", "Artist": "Abba"},
+			{"file": "Compilations/Hits/1.mp3", "Album": "Hits", "AlbumArtist": "Various"},
+			{"file": "Compilations/More/1.mp3", "Album": "More", "Artist": "Other"},
+		},
+	}
+}
+
+func TestServer_getArtists(t *testing.T) {
+	cfg, values := configAuth()
+
+	withServer(t, id3Database(), nil, cfg, func(base string) {
+		res := testRequest(t, base, http.MethodGet, "/rest/getArtists.view", values)
+		c := mustDecodeXML(t, res)
+
+		if c.Artists == nil {
+			t.Fatal("no artists in response")
+		}
+
+		want := []index{
+			{Name: "A", Artists: []artist{{Name: "Abba", ID: "0", SortName: "Abba", AlbumCount: 1}}},
+			{Name: "O", Artists: []artist{{Name: "Other", ID: "7", SortName: "Other", AlbumCount: 1}}},
+			{Name: "V", Artists: []artist{{Name: "Various", ID: "4", SortName: "Various", AlbumCount: 1}}},
+		}
+
+		mustIndexesEqual(t, want, c.Artists.Indexes)
+	})
+}
+
+func TestServer_getArtist(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		err    bool
+		code   int
+		artist string
+		albums []string
+	}{
+		{
+			name: "missing ID",
+			err:  true,
+			code: codeMissingParameter,
+		},
+		{
+			name: "not found",
+			id:   "1",
+			err:  true,
+			code: codeNotFound,
+		},
+		{
+			name:   "by directory",
+			id:     "4",
+			artist: "Various",
+			albums: []string{"5"},
+		},
+		{
+			name:   "by album",
+			id:     "7",
+			artist: "Other",
+			albums: []string{"7"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			if tt.id != "" {
+				values.Set("id", tt.id)
+			}
+
+			withServer(t, id3Database(), nil, cfg, func(base string) {
+				res := testRequest(t, base, http.MethodGet, "/rest/getArtist.view", values)
+				c := mustDecodeXML(t, res)
+
+				if tt.err {
+					if c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+					if want, got := tt.code, c.Error.Code; want != got {
+						t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+					}
+					return
+				}
+
+				if want, got := tt.artist, c.Artist.Name; want != got {
+					t.Fatalf("unexpected artist name:\n- want: %v\n-  got: %v", want, got)
+				}
+
+				var albums []string
+				for _, a := range c.Artist.Albums {
+					if want, got := tt.id, a.ArtistID; want != got {
+						t.Fatalf("unexpected album artist ID:\n- want: %v\n-  got: %v", want, got)
+					}
+					albums = append(albums, a.ID)
+				}
+
+				if want, got := tt.albums, albums; !reflect.DeepEqual(want, got) {
+					t.Fatalf("unexpected album IDs:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		})
+	}
+}
+
+func TestServer_getAlbum(t *testing.T) {
+	cfg, values := configAuth()
+	values.Set("id", "1")
+
+	withServer(t, id3Database(), nil, cfg, func(base string) {
+		res := testRequest(t, base, http.MethodGet, "/rest/getAlbum.view", values)
+		c := mustDecodeXML(t, res)
+
+		a := c.Album
+		if a == nil {
+			t.Fatal("no album in response")
+		}
+
+		if want, got := "Gold", a.Name; want != got {
+			t.Fatalf("unexpected album name:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := 2, a.SongCount; want != got {
+			t.Fatalf("unexpected song count:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		var titles []string
+		for _, s := range a.Songs {
+			if s.AlbumID != "1" || s.ArtistID != "0" {
+				t.Fatalf("unexpected song album and artist IDs: %q, %q", s.AlbumID, s.ArtistID)
+			}
+			titles = append(titles, s.Title)
+		}
+
+		if want, got := []string{"Dancing Queen", "Waterloo"}, titles; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected song titles:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		// Unknown albums are not found
+		values.Set("id", "99")
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getAlbum.view", values))
+		if c.Error == nil || c.Error.Code != codeNotFound {
+			t.Fatalf("expected not found error, but got: %+v", c.Error)
+		}
+	})
+}
+
+func TestServer_getAlbumMultipleDirectories(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Abba/Gold CD1/1.mp3",
+			"Abba/Gold CD2/1.mp3",
+			"Abba/Live/1.mp3",
+			"Abba/Live 2/1.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "Abba/Gold CD1/1.mp3", "Title": "Dancing Queen", "Album": "Gold", "Artist": "Abba"},
+			{"file": "Abba/Gold CD2/1.mp3", "Title": "Waterloo", "Album": "Gold", "Artist": "Abba"},
+			// Without album tags, albums are identified by their directories
+			{"file": "Abba/Live/1.mp3", "Title": "SOS", "Artist": "Abba"},
+			{"file": "Abba/Live 2/1.mp3", "Title": "Mamma Mia", "Artist": "Abba"},
+		},
+	}
+
+	cfg, values := configAuth()
+	values.Set("id", "0")
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getArtist.view", values))
+		if c.Artist == nil {
+			t.Fatalf("no artist in response: %+v", c.Error)
+		}
+
+		var albums []string
+		for _, a := range c.Artist.Albums {
+			albums = append(albums, a.Name)
+		}
+
+		if want, got := []string{"Gold", "Live", "Live 2"}, albums; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected albums:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		// Either disc's directory identifies the whole album
+		for _, id := range []string{c.Artist.Albums[0].ID, "3"} {
+			values.Set("id", id)
+			c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getAlbum.view", values))
+			if c.Album == nil {
+				t.Fatalf("no album in response: %+v", c.Error)
+			}
+
+			var titles []string
+			for _, s := range c.Album.Songs {
+				titles = append(titles, s.Title)
+			}
+
+			if want, got := []string{"Dancing Queen", "Waterloo"}, titles; !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected song titles:\n- want: %v\n-  got: %v", want, got)
+			}
+			if want, got := 2, c.Album.SongCount; want != got {
+				t.Fatalf("unexpected song count:\n- want: %v\n-  got: %v", want, got)
+			}
+		}
+	})
+}
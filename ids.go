@@ -0,0 +1,189 @@
+package mpdsub
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Subsonic clients browse MPD's music directory either by directory, using
+// getIndexes and getMusicDirectory, or by tags ("ID3"), using getArtists and
+// getAlbum.  Both views share a single ID scheme, so that an album or artist
+// has the same ID, and therefore the same artwork and per-item state, no
+// matter how a client reached it:
+//
+//  - a song's ID is the ID of its file in the file index
+//  - an album's ID is the ID of the directory containing its songs
+//  - an artist's ID is the ID of the directory containing its albums, or of
+//    its album if the album is at the top level of the music directory
+//
+// Because IDs are positions in the file index, they change when files are
+// added to or removed from MPD's database.  State which must outlive such
+// changes, such as stars and play counts, is keyed by itemKey instead.
+//
+// If Config.ObfuscateIDs is set, IDs are presented to clients as tokens
+// derived from each position using HMAC-SHA256, so that IDs in shared or
+// cast URLs cannot be enumerated to discover the rest of the library.
+
+// idTokenLength is the number of bytes of an HMAC used in an ID token.
+const idTokenLength = 12
+
+// idTokens maps IDs to and from their obfuscated tokens.  Because a token
+// depends only on its ID and the key, tokens are cached as they are needed
+// and never invalidated, even when MPD's database changes.
+type idTokens struct {
+	key []byte
+
+	mu     sync.Mutex
+	tokens []string
+	ids    map[string]int
+}
+
+// newIDTokens creates idTokens which derive tokens using key.
+func newIDTokens(key []byte) *idTokens {
+	return &idTokens{
+		key: key,
+		ids: make(map[string]int),
+	}
+}
+
+// format returns the token for id.
+func (t *idTokens) format(id int) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.grow(id + 1)
+	return t.tokens[id]
+}
+
+// parse returns the ID of the token qID, which must be one of the first n
+// IDs.
+func (t *idTokens) parse(qID string, n int) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.grow(n)
+	id, ok := t.ids[qID]
+	if !ok || id >= n {
+		return 0, false
+	}
+
+	return id, true
+}
+
+// grow derives tokens for the first n IDs.  t.mu must be held.
+func (t *idTokens) grow(n int) {
+	for id := len(t.tokens); id < n; id++ {
+		mac := hmac.New(sha256.New, t.key)
+		_, _ = mac.Write([]byte(strconv.Itoa(id)))
+
+		token := base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:idTokenLength])
+		t.tokens = append(t.tokens, token)
+		t.ids[token] = id
+	}
+}
+
+// idKey returns the key used to derive ID tokens from st, generating and
+// persisting a new key if none exists.
+func idKey(st *store) ([]byte, error) {
+	var key []byte
+	st.View(func(d *storeData) {
+		key = d.IDKey
+	})
+	if len(key) > 0 {
+		return key, nil
+	}
+
+	err := st.Update(func(d *storeData) error {
+		if len(d.IDKey) == 0 {
+			d.IDKey = make([]byte, 32)
+			if _, err := rand.Read(d.IDKey); err != nil {
+				return err
+			}
+		}
+
+		key = d.IDKey
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// formatID returns the Subsonic ID of the file or directory with index id.
+func (s *Server) formatID(id int) string {
+	if s.idTokens == nil {
+		return strconv.Itoa(id)
+	}
+
+	return s.idTokens.format(id)
+}
+
+// parseID parses the Subsonic ID qID of a file or directory, given that n
+// files are in the file index.  If qID is malformed, false is returned.  The
+// returned ID may be out of range for the file index, and must be checked
+// by the caller.
+func (s *Server) parseID(qID string, n int) (int, bool) {
+	if s.idTokens == nil {
+		id, err := strconv.Atoi(qID)
+		if err != nil || id < 0 {
+			return 0, false
+		}
+
+		return id, true
+	}
+
+	return s.idTokens.parse(qID, n)
+}
+
+// lookupID looks up the indexedFile with the Subsonic ID qID.  If qID is
+// not a valid ID, false is returned.
+func (s *Server) lookupID(files []indexedFile, qID string) (indexedFile, bool) {
+	id, ok := s.parseID(qID, len(files))
+	if !ok || id >= len(files) {
+		return indexedFile{}, false
+	}
+
+	return files[id], true
+}
+
+// albumDir returns the name of the directory which identifies the album
+// containing a song.  If the song is at the top level of the music
+// directory, empty string is returned.
+func albumDir(name string) string {
+	dir := path.Dir(name)
+	if dir == "." {
+		return ""
+	}
+
+	return dir
+}
+
+// artistDir returns the name of the directory which identifies the artist
+// of the album in directory dir.
+func artistDir(dir string) string {
+	if parent := path.Dir(dir); parent != "." {
+		return parent
+	}
+
+	return dir
+}
+
+// itemKey returns the key used to store state for the file or directory
+// with the specified name, such as a star or play count.  Keys are stable
+// across updates to MPD's database, and are shared by the directory and
+// ID3 views of an item.
+func (s *Server) itemKey(name string) string {
+	if s.cfg.CaseInsensitivePaths {
+		return strings.ToLower(name)
+	}
+
+	return name
+}
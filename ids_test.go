@@ -0,0 +1,178 @@
+package mpdsub
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func Test_lookupID(t *testing.T) {
+	s := &Server{}
+	files := indexFiles([]string{"foo/bar.mp3"})
+
+	tests := []struct {
+		id   string
+		name string
+		ok   bool
+	}{
+		{id: "0", name: "foo", ok: true},
+		{id: "1", name: "foo/bar.mp3", ok: true},
+		{id: "2"},
+		{id: "-1"},
+		{id: "foo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			f, ok := s.lookupID(files, tt.id)
+			if want, got := tt.ok, ok; want != got {
+				t.Fatalf("unexpected lookup result:\n- want: %v\n-  got: %v", want, got)
+			}
+			if want, got := tt.name, f.Name; want != got {
+				t.Fatalf("unexpected file name:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func Test_albumArtistDir(t *testing.T) {
+	tests := []struct {
+		name   string
+		album  string
+		artist string
+	}{
+		{name: "song.mp3"},
+		{name: "Album/song.mp3", album: "Album", artist: "Album"},
+		{name: "Artist/Album/song.mp3", album: "Artist/Album", artist: "Artist"},
+		{name: "Rock/Artist/Album/song.mp3", album: "Rock/Artist/Album", artist: "Rock/Artist"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := albumDir(tt.name)
+			if want, got := tt.album, dir; want != got {
+				t.Fatalf("unexpected album directory:\n- want: %v\n-  got: %v", want, got)
+			}
+			if dir == "" {
+				return
+			}
+
+			if want, got := tt.artist, artistDir(dir); want != got {
+				t.Fatalf("unexpected artist directory:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestServer_itemKey(t *testing.T) {
+	s := &Server{cfg: &Config{}}
+	if want, got := "Artist/Album", s.itemKey("Artist/Album"); want != got {
+		t.Fatalf("unexpected item key:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	s.cfg.CaseInsensitivePaths = true
+	if want, got := s.itemKey("artist/album"), s.itemKey("Artist/ALBUM"); want != got {
+		t.Fatalf("unexpected case-insensitive item key:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_idTokens(t *testing.T) {
+	ids := newIDTokens([]byte("key"))
+
+	token := ids.format(1)
+	if token == "1" || len(token) != 16 {
+		t.Fatalf("unexpected token: %q", token)
+	}
+
+	// Tokens depend only on the key and ID
+	if want, got := token, newIDTokens([]byte("key")).format(1); want != got {
+		t.Fatalf("unexpected token with same key:\n- want: %v\n-  got: %v", want, got)
+	}
+	if token == newIDTokens([]byte("other")).format(1) {
+		t.Fatal("expected different token with different key")
+	}
+
+	if id, ok := ids.parse(token, 2); !ok || id != 1 {
+		t.Fatalf("unexpected parse result: %d, %v", id, ok)
+	}
+	if _, ok := ids.parse(token, 1); ok {
+		t.Fatal("expected token out of range to be rejected")
+	}
+	if _, ok := ids.parse("1", 2); ok {
+		t.Fatal("expected numeric ID to be rejected")
+	}
+}
+
+func TestServer_obfuscateIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-ids")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := &memoryDatabase{
+		files: []string{"foo/bar.mp3"},
+		attrs: map[string]mpd.Attrs{
+			"foo":         {"directory": "foo"},
+			"foo/bar.mp3": {"file": "foo/bar.mp3", "Title": "Bar"},
+		},
+	}
+
+	// ids retrieves the IDs of the children of the root directory
+	ids := func() []string {
+		cfg, values := configAuth()
+		cfg.ObfuscateIDs = true
+		cfg.StateFile = filepath.Join(dir, "state.json")
+
+		var out []string
+		withServer(t, db, nil, cfg, func(base string) {
+			values.Set("id", "0")
+			c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getMusicDirectory.view", values))
+			if c.Error == nil || c.Error.Code != codeGeneric {
+				t.Fatalf("expected numeric ID to be rejected, but got: %+v", c.Error)
+			}
+
+			values.Set("id", newIDTokens(mustIDKey(t, cfg.StateFile)).format(0))
+			c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getMusicDirectory.view", values))
+			if c.Error != nil {
+				t.Fatalf("unexpected error: %+v", c.Error)
+			}
+
+			for _, ch := range c.MusicDirectory.Children {
+				out = append(out, ch.ID, ch.CoverArt)
+			}
+		})
+
+		return out
+	}
+
+	first := ids()
+	if len(first) != 2 || first[0] == "1" || first[0] != first[1] {
+		t.Fatalf("unexpected obfuscated IDs: %v", first)
+	}
+
+	// Keys persist in the state file, so IDs are stable across restarts
+	if want, got := first, ids(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected IDs after restart:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+// mustIDKey loads the ID key persisted in the state file at path.
+func mustIDKey(t *testing.T, path string) []byte {
+	st, err := openStore(path)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	key, err := idKey(st)
+	if err != nil {
+		t.Fatalf("failed to load ID key: %v", err)
+	}
+
+	return key
+}
@@ -0,0 +1,91 @@
+package mpdsub
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// groupIndexes groups artists, which must be sorted by name, into
+// alphabetical indexes by the initial character of each artist's name.
+// If fold is true, upper and lower case initial characters share an index,
+// as they are interleaved when sorting using a collation.
+func groupIndexes(artists []artist, fold bool) []index {
+	var indexes []index
+
+	// A map of initial characters to their indexes, used to deduplicate the
+	// addition of new indexes
+	seenChars := make(map[rune]int, 0)
+
+	for _, a := range artists {
+		// Initial rune is used to create an index name
+		c, _ := utf8.DecodeRuneInString(a.Name)
+		if fold {
+			c = unicode.ToUpper(c)
+		}
+		name := string(c)
+
+		// If initial rune is a digit, put index under a numeric section
+		if unicode.IsDigit(c) {
+			c = '#'
+			name = "#"
+		}
+
+		// If a new rune appears, create a new index for it
+		idx, ok := seenChars[c]
+		if !ok {
+			idx = len(indexes)
+			seenChars[c] = idx
+			indexes = append(indexes, index{Name: name})
+		}
+
+		indexes[idx].Artists = append(indexes[idx].Artists, a)
+	}
+
+	return indexes
+}
+
+// lazyIndexes applies the optional lazy loading parameters of a request to
+// a list of indexes.  If the letter parameter is set, only the index with that
+// name is returned.  Otherwise, if the skeleton parameter is true, or the
+// Server is configured to return skeletons by default, only the name and
+// number of artists of each index are returned.  If a parameter is invalid,
+// lazyIndexes returns false.
+func (s *Server) lazyIndexes(r *http.Request, indexes []index) ([]index, bool) {
+	q := r.URL.Query()
+
+	if letter := q.Get("letter"); letter != "" {
+		for _, idx := range indexes {
+			if strings.EqualFold(idx.Name, letter) {
+				return []index{idx}, true
+			}
+		}
+
+		return nil, true
+	}
+
+	skeleton := s.cfg.IndexSkeleton
+	if qSkeleton := q.Get("skeleton"); qSkeleton != "" {
+		var err error
+		skeleton, err = strconv.ParseBool(qSkeleton)
+		if err != nil {
+			return nil, false
+		}
+	}
+
+	if !skeleton {
+		return indexes, true
+	}
+
+	out := make([]index, 0, len(indexes))
+	for _, idx := range indexes {
+		out = append(out, index{
+			Name:        idx.Name,
+			ArtistCount: len(idx.Artists),
+		})
+	}
+
+	return out, true
+}
@@ -0,0 +1,121 @@
+package mpdsub
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestServer_getIndexesLazy(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Apple/A.mp3",
+			"Avocado/A.mp3",
+			"Banana/B.mp3",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		skeleton bool
+		values   map[string]string
+		indexes  []index
+		err      bool
+	}{
+		{
+			name:   "skeleton parameter",
+			values: map[string]string{"skeleton": "true"},
+			indexes: []index{
+				{Name: "A", ArtistCount: 2},
+				{Name: "B", ArtistCount: 1},
+			},
+		},
+		{
+			name:     "skeleton default",
+			skeleton: true,
+			indexes: []index{
+				{Name: "A", ArtistCount: 2},
+				{Name: "B", ArtistCount: 1},
+			},
+		},
+		{
+			name:     "skeleton default overridden",
+			skeleton: true,
+			values:   map[string]string{"skeleton": "false"},
+			indexes: []index{
+				{
+					Name: "A",
+					Artists: []artist{
+						{Name: "Apple", ID: "0"},
+						{Name: "Avocado", ID: "2"},
+					},
+				},
+				{
+					Name: "B",
+					Artists: []artist{
+						{Name: "Banana", ID: "4"},
+					},
+				},
+			},
+		},
+		{
+			name:     "letter",
+			skeleton: true,
+			values:   map[string]string{"letter": "b"},
+			indexes: []index{{
+				Name: "B",
+				Artists: []artist{
+					{Name: "Banana", ID: "4"},
+				},
+			}},
+		},
+		{
+			name:   "unknown letter",
+			values: map[string]string{"letter": "Z"},
+		},
+		{
+			name:   "bad skeleton",
+			values: map[string]string{"skeleton": "foo"},
+			err:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.IndexSkeleton = tt.skeleton
+			for k, v := range tt.values {
+				values.Set(k, v)
+			}
+
+			withServer(t, db, nil, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getIndexes.view", values))
+
+				if tt.err {
+					if c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+
+					return
+				}
+
+				if c.Indexes == nil {
+					t.Fatal("indexes is nil")
+				}
+
+				if want, got := len(tt.indexes), len(c.Indexes.Indexes); want != got {
+					t.Fatalf("unexpected number of indexes:\n- want: %v\n-  got: %v",
+						want, got)
+				}
+
+				for i := range tt.indexes {
+					if want, got := tt.indexes[i].ArtistCount, c.Indexes.Indexes[i].ArtistCount; want != got {
+						t.Fatalf("unexpected artist count:\n- want: %v\n-  got: %v",
+							want, got)
+					}
+				}
+
+				mustIndexesEqual(t, tt.indexes, c.Indexes.Indexes)
+			})
+		})
+	}
+}
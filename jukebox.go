@@ -0,0 +1,295 @@
+package mpdsub
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+)
+
+// Actions supported by the jukeboxControl endpoint.
+const (
+	jukeboxActionGet     = "get"
+	jukeboxActionStatus  = "status"
+	jukeboxActionSet     = "set"
+	jukeboxActionStart   = "start"
+	jukeboxActionStop    = "stop"
+	jukeboxActionSkip    = "skip"
+	jukeboxActionAdd     = "add"
+	jukeboxActionClear   = "clear"
+	jukeboxActionRemove  = "remove"
+	jukeboxActionShuffle = "shuffle"
+	jukeboxActionSetGain = "setGain"
+)
+
+// jukeboxControl is used in Subsonic to control playback on the Server's
+// own speakers.  MPD's queue is the jukebox playlist, and each action is
+// mapped onto MPD's queue and playback commands, with the gain mapped onto
+// MPD's volume.  Stopping the jukebox pauses MPD, so that starting it again
+// resumes playback where it stopped, as in Subsonic.
+//
+// The get action returns the songs in MPD's queue along with the status of
+// the jukebox, and all other actions return only the status.
+func (s *Server) jukeboxControl(w http.ResponseWriter, r *http.Request) {
+	if s.player == nil {
+		s.logf("jukebox control is not supported by the backing database")
+		writeXML(w, errGeneric)
+		return
+	}
+
+	q := r.URL.Query()
+
+	action := q.Get("action")
+	if action == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	// The jukebox may be inspected, but not controlled, on a read-only Server
+	if action != jukeboxActionGet && action != jukeboxActionStatus && s.cfg.ReadOnly {
+		writeXML(w, errReadOnly)
+		return
+	}
+
+	var err error
+	switch action {
+	case jukeboxActionGet, jukeboxActionStatus:
+	case jukeboxActionSet, jukeboxActionAdd:
+		files, ok := s.jukeboxFiles(w, r)
+		if !ok {
+			return
+		}
+
+		// Setting the playlist replaces MPD's queue, and may clear it
+		if action == jukeboxActionSet {
+			err = s.player.Clear()
+		}
+		for _, f := range files {
+			if err != nil {
+				break
+			}
+
+			_, err = s.player.AddID(f.Name, -1)
+		}
+	case jukeboxActionStart:
+		err = s.jukeboxStart()
+	case jukeboxActionStop:
+		err = s.player.Pause(true)
+	case jukeboxActionSkip, jukeboxActionRemove:
+		index, ok := jukeboxInt(w, q.Get("index"), true)
+		if !ok {
+			return
+		}
+
+		if action == jukeboxActionRemove {
+			err = s.player.Delete(index, index+1)
+			break
+		}
+
+		offset, ok := jukeboxInt(w, q.Get("offset"), false)
+		if !ok {
+			return
+		}
+
+		err = s.player.Seek(index, offset)
+	case jukeboxActionClear:
+		err = s.player.Clear()
+	case jukeboxActionShuffle:
+		err = s.player.Shuffle(-1, -1)
+	case jukeboxActionSetGain:
+		qGain := q.Get("gain")
+		if qGain == "" {
+			writeXML(w, errMissingParameter)
+			return
+		}
+
+		gain, perr := strconv.ParseFloat(qGain, 64)
+		if perr != nil || gain < 0 || gain > 1 {
+			writeXML(w, errGeneric)
+			return
+		}
+
+		err = s.player.SetVolume(int(gain*100 + 0.5))
+	default:
+		writeXML(w, errGeneric)
+		return
+	}
+	if err != nil {
+		s.logf("error performing jukebox action %q: %v", action, err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	st, err := s.jukeboxState()
+	if err != nil {
+		s.logf("error retrieving status from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	if action != jukeboxActionGet {
+		writeXML(w, func(c *container) {
+			c.JukeboxStatus = &jukeboxStatus{jukeboxState: st}
+		})
+		return
+	}
+
+	entries, err := s.jukeboxEntries()
+	if err != nil {
+		s.logf("error retrieving queue from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	writeXML(w, func(c *container) {
+		c.JukeboxPlaylist = &jukeboxPlaylist{
+			jukeboxState: st,
+			Entries:      entries,
+		}
+	})
+}
+
+// jukeboxFiles looks up the songs specified by the id parameters of a set or
+// add request.  If a song cannot be found or accessed, an error is written to
+// w and false is returned.
+func (s *Server) jukeboxFiles(w http.ResponseWriter, r *http.Request) ([]indexedFile, bool) {
+	qIDs := r.URL.Query()["id"]
+	if len(qIDs) == 0 {
+		return nil, true
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		s.logf("error listing files from mpd for jukebox: %v", err)
+		writeXML(w, errMPD(err))
+		return nil, false
+	}
+	indexed := indexFiles(fs)
+
+	user := requestContextFrom(r).User
+
+	files := make([]indexedFile, 0, len(qIDs))
+	for _, qID := range qIDs {
+		f, ok := s.lookupID(indexed, qID)
+		if !ok || f.Dir {
+			writeXML(w, errNotFound)
+			return nil, false
+		}
+		if !s.canAccess(w, user, f) {
+			return nil, false
+		}
+
+		files = append(files, f)
+	}
+
+	return files, true
+}
+
+// jukeboxInt parses a non-negative integer parameter of a jukebox request.  If
+// the parameter is missing and required, or invalid, an error is written to w
+// and false is returned.  A missing optional parameter is 0.
+func jukeboxInt(w http.ResponseWriter, qv string, required bool) (int, bool) {
+	if qv == "" {
+		if required {
+			writeXML(w, errMissingParameter)
+			return 0, false
+		}
+
+		return 0, true
+	}
+
+	n, err := strconv.Atoi(qv)
+	if err != nil || n < 0 {
+		writeXML(w, errGeneric)
+		return 0, false
+	}
+
+	return n, true
+}
+
+// jukeboxStart resumes playback if MPD is paused, or starts playback
+// otherwise.
+func (s *Server) jukeboxStart() error {
+	status, err := s.player.Status()
+	if err != nil {
+		return err
+	}
+
+	if status["state"] == "pause" {
+		return s.player.Pause(false)
+	}
+
+	return s.player.Play(-1)
+}
+
+// jukeboxState retrieves the status of the jukebox from MPD.
+func (s *Server) jukeboxState() (jukeboxState, error) {
+	status, err := s.player.Status()
+	if err != nil {
+		return jukeboxState{}, err
+	}
+
+	// MPD omits the current song when the queue is empty or stopped, and
+	// reports volume -1 when no mixer is available
+	index, err := strconv.Atoi(status["song"])
+	if err != nil {
+		index = -1
+	}
+
+	var gain float64
+	if volume, err := strconv.Atoi(status["volume"]); err == nil && volume >= 0 {
+		gain = float64(volume) / 100
+	}
+
+	elapsed, _ := strconv.ParseFloat(status["elapsed"], 64)
+
+	return jukeboxState{
+		CurrentIndex: index,
+		Playing:      status["state"] == "play",
+		Gain:         gain,
+		Position:     int(elapsed),
+	}, nil
+}
+
+// jukeboxEntries produces an entry for each song in MPD's queue, in order.
+// Songs which are not in MPD's database, such as internet radio streams, have
+// no ID, but are included so that indices match positions in the queue.
+func (s *Server) jukeboxEntries() ([]entry, error) {
+	queue, err := s.player.PlaylistInfo(-1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		return nil, err
+	}
+	ids := fileIDs(indexFiles(fs))
+
+	entries := make([]entry, 0, len(queue))
+	for _, a := range queue {
+		name := a["file"]
+
+		id, ok := ids[name]
+		if !ok {
+			title := a["Title"]
+			if title == "" {
+				title = path.Base(name)
+			}
+
+			entries = append(entries, entry{child: child{
+				Artist: a["Artist"],
+				Title:  title,
+			}})
+			continue
+		}
+
+		c := s.songChild(id, a)
+		if dir := albumDir(name); dir != "" {
+			c.Parent = s.formatID(ids[dir])
+		}
+
+		entries = append(entries, entry{child: c})
+	}
+
+	return entries, nil
+}
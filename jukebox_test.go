@@ -0,0 +1,236 @@
+package mpdsub
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_jukeboxControl(t *testing.T) {
+	p := newMemoryPlayer()
+	p.memoryDatabase = &memoryDatabase{
+		files: []string{
+			"Artist/Album/bar.mp3",
+			"Artist/Album/foo.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "Artist/Album/bar.mp3", "Title": "Bar"},
+			{"file": "Artist/Album/foo.mp3", "Title": "Foo"},
+		},
+	}
+
+	cfg, values := configAuth()
+
+	// params copies values and adds additional query parameters
+	params := func(extra url.Values) url.Values {
+		v := make(url.Values, len(values))
+		for k, vv := range values {
+			v[k] = vv
+		}
+		for k, vv := range extra {
+			v[k] = vv
+		}
+		return v
+	}
+
+	withServer(t, p, nil, cfg, func(base string) {
+		errs := []struct {
+			name   string
+			params url.Values
+			code   int
+		}{
+			{
+				name: "missing action",
+				code: codeMissingParameter,
+			},
+			{
+				name:   "unknown action",
+				params: url.Values{"action": {"foo"}},
+				code:   codeGeneric,
+			},
+			{
+				name:   "skip missing index",
+				params: url.Values{"action": {jukeboxActionSkip}},
+				code:   codeMissingParameter,
+			},
+			{
+				name:   "remove negative index",
+				params: url.Values{"action": {jukeboxActionRemove}, "index": {"-1"}},
+				code:   codeGeneric,
+			},
+			{
+				name:   "gain out of range",
+				params: url.Values{"action": {jukeboxActionSetGain}, "gain": {"1.5"}},
+				code:   codeGeneric,
+			},
+			{
+				name:   "add directory",
+				params: url.Values{"action": {jukeboxActionAdd}, "id": {"1"}},
+				code:   codeNotFound,
+			},
+		}
+
+		for _, tt := range errs {
+			t.Run(tt.name, func(t *testing.T) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/jukeboxControl.view", params(tt.params)))
+				if c.Error == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+
+				if want, got := tt.code, c.Error.Code; want != got {
+					t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		}
+
+		// do performs a jukebox action and returns the resulting status
+		do := func(t *testing.T, action string, extra url.Values) jukeboxState {
+			v := params(extra)
+			v.Set("action", action)
+
+			c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/jukeboxControl.view", v))
+			if c.Error != nil {
+				t.Fatalf("unexpected error for action %q: %+v", action, c.Error)
+			}
+
+			if action == jukeboxActionGet {
+				return c.JukeboxPlaylist.jukeboxState
+			}
+			return c.JukeboxStatus.jukeboxState
+		}
+
+		steps := []struct {
+			action string
+			params url.Values
+			want   jukeboxState
+			queue  []string
+		}{
+			{
+				action: jukeboxActionSet,
+				params: url.Values{"id": {"3", "2"}},
+				want:   jukeboxState{CurrentIndex: -1, Gain: 0.5},
+				queue:  []string{"Artist/Album/foo.mp3", "Artist/Album/bar.mp3"},
+			},
+			{
+				action: jukeboxActionStart,
+				want:   jukeboxState{CurrentIndex: 0, Playing: true, Gain: 0.5},
+			},
+			{
+				action: jukeboxActionSkip,
+				params: url.Values{"index": {"1"}, "offset": {"30"}},
+				want:   jukeboxState{CurrentIndex: 1, Playing: true, Gain: 0.5, Position: 30},
+			},
+			{
+				action: jukeboxActionStop,
+				want:   jukeboxState{CurrentIndex: 1, Gain: 0.5, Position: 30},
+			},
+			{
+				// Playback resumes where it stopped
+				action: jukeboxActionStart,
+				want:   jukeboxState{CurrentIndex: 1, Playing: true, Gain: 0.5, Position: 30},
+			},
+			{
+				action: jukeboxActionSetGain,
+				params: url.Values{"gain": {"0.25"}},
+				want:   jukeboxState{CurrentIndex: 1, Playing: true, Gain: 0.25, Position: 30},
+			},
+			{
+				action: jukeboxActionRemove,
+				params: url.Values{"index": {"0"}},
+				want:   jukeboxState{CurrentIndex: 0, Playing: true, Gain: 0.25, Position: 30},
+				queue:  []string{"Artist/Album/bar.mp3"},
+			},
+			{
+				action: jukeboxActionAdd,
+				params: url.Values{"id": {"3"}},
+				want:   jukeboxState{CurrentIndex: 0, Playing: true, Gain: 0.25, Position: 30},
+				queue:  []string{"Artist/Album/bar.mp3", "Artist/Album/foo.mp3"},
+			},
+			{
+				action: jukeboxActionShuffle,
+				want:   jukeboxState{CurrentIndex: 0, Playing: true, Gain: 0.25, Position: 30},
+				queue:  []string{"Artist/Album/foo.mp3", "Artist/Album/bar.mp3"},
+			},
+			{
+				action: jukeboxActionClear,
+				want:   jukeboxState{CurrentIndex: -1, Gain: 0.25},
+				queue:  []string{},
+			},
+		}
+
+		for _, s := range steps {
+			if want, got := s.want, do(t, s.action, s.params); want != got {
+				t.Fatalf("unexpected status after %q:\n- want: %+v\n-  got: %+v", s.action, want, got)
+			}
+
+			if s.queue == nil {
+				continue
+			}
+
+			if want, got := len(s.queue), len(p.queue); want != got {
+				t.Fatalf("unexpected queue length after %q:\n- want: %v\n-  got: %v", s.action, want, got)
+			}
+			for i := range s.queue {
+				if want, got := s.queue[i], p.queue[i]; want != got {
+					t.Fatalf("unexpected queue after %q:\n- want: %v\n-  got: %v", s.action, s.queue, p.queue)
+				}
+			}
+		}
+	})
+}
+
+func TestServer_jukeboxControlGet(t *testing.T) {
+	p := newMemoryPlayer()
+	p.memoryDatabase = &memoryDatabase{
+		files: []string{
+			"Artist/Album/foo.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "Artist/Album/foo.mp3", "Title": "Foo"},
+		},
+	}
+	p.queue = []string{
+		"Artist/Album/foo.mp3",
+		"http://radio.example.com/stream",
+	}
+	p.song, p.playing = 1, 1
+
+	cfg, values := configAuth()
+	values.Set("action", jukeboxActionGet)
+
+	withServer(t, p, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/jukeboxControl.view", values))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+
+		jp := c.JukeboxPlaylist
+		if want, got := 1, jp.CurrentIndex; want != got {
+			t.Fatalf("unexpected current index:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := 2, len(jp.Entries); want != got {
+			t.Fatalf("unexpected number of entries:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		// Songs outside of MPD's database have no ID
+		want := []struct {
+			id, parent, title string
+		}{
+			{id: "2", parent: "1", title: "Foo"},
+			{title: "stream"},
+		}
+		for i, e := range jp.Entries {
+			if want, got := want[i].id, e.ID; want != got {
+				t.Fatalf("unexpected entry %d ID:\n- want: %v\n-  got: %v", i, want, got)
+			}
+			if want, got := want[i].parent, e.Parent; want != got {
+				t.Fatalf("unexpected entry %d parent:\n- want: %v\n-  got: %v", i, want, got)
+			}
+			if want, got := want[i].title, e.Title; want != got {
+				t.Fatalf("unexpected entry %d title:\n- want: %v\n-  got: %v", i, want, got)
+			}
+		}
+	})
+}
@@ -0,0 +1,521 @@
+package mpdsub
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+const (
+	// lastFMEndpoint is the URL of the Last.fm API.
+	lastFMEndpoint = "https://ws.audioscrobbler.com/2.0/"
+
+	// defaultLastFMTimeout is the default maximum amount of time to wait
+	// for a request to the Last.fm API to complete.
+	defaultLastFMTimeout = 10 * time.Second
+
+	// maxLastFMScrobbles is the maximum number of plays which may be
+	// scrobbled to Last.fm in a single request.
+	maxLastFMScrobbles = 50
+
+	// maxLastFMResponse is the maximum size in bytes of a response from
+	// the Last.fm API which will be read.
+	maxLastFMResponse = 1 << 20
+
+	// minLastFMDuration is the duration of the shortest songs which
+	// Last.fm accepts scrobbles for.
+	minLastFMDuration = 30
+
+	// lastFMNotFound is the Last.fm error code returned when the artist,
+	// album, or track in a request is unknown.
+	lastFMNotFound = 6
+)
+
+// LastFM configures forwarding of plays to Last.fm, and retrieval of
+// metadata such as artist biographies from Last.fm.
+type LastFM struct {
+	// APIKey and Secret specify the credentials of a Last.fm API account.
+	// Metadata only requires APIKey, but Secret is required to forward
+	// plays.
+	APIKey string
+	Secret string
+
+	// SessionKeys maps users to the Last.fm session keys used to scrobble
+	// their plays, obtained by authorizing the API account to access
+	// their Last.fm profile.  Plays of users without a session key are not
+	// forwarded.
+	SessionKeys map[string]string
+
+	// Timeout optionally specifies the maximum amount of time to wait for
+	// a request to Last.fm to complete.  If Timeout is 0, a default of 10
+	// seconds is used.
+	Timeout time.Duration
+}
+
+// validate verifies that a LastFM configuration has API credentials.
+func (cfg *LastFM) validate() error {
+	if cfg.APIKey == "" {
+		return errors.New("last.fm API key must not be empty")
+	}
+	if len(cfg.SessionKeys) > 0 && cfg.Secret == "" {
+		return errors.New("last.fm secret must not be empty to forward plays")
+	}
+
+	return nil
+}
+
+// lastFM is a scrobbler which forwards plays to Last.fm, and a source of
+// metadata.
+type lastFM struct {
+	cfg      LastFM
+	endpoint string
+	client   *http.Client
+	provider *provider
+
+	// cache optionally stores metadata responses on disk.
+	cache *metadataCache
+}
+
+var _ scrobbler = &lastFM{}
+
+// newLastFM creates a lastFM scrobbler using the input configuration.
+func newLastFM(cfg LastFM) *lastFM {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultLastFMTimeout
+	}
+
+	return &lastFM{
+		cfg:      cfg,
+		endpoint: lastFMEndpoint,
+		client:   &http.Client{},
+		provider: newProvider("last.fm", timeout),
+	}
+}
+
+// NowPlaying implements scrobbler.
+func (l *lastFM) NowPlaying(user string, p scrobblePlay) error {
+	sk, ok := l.cfg.SessionKeys[user]
+	if !ok {
+		return nil
+	}
+
+	v := make(url.Values)
+	if !lastFMTrack(v, p.Attrs, "") {
+		return nil
+	}
+	v.Set("method", "track.updateNowPlaying")
+
+	return l.call(sk, v)
+}
+
+// Scrobble implements scrobbler.
+func (l *lastFM) Scrobble(user string, plays []scrobblePlay) error {
+	sk, ok := l.cfg.SessionKeys[user]
+	if !ok {
+		return nil
+	}
+
+	v := make(url.Values)
+	n := 0
+	for _, p := range plays {
+		suffix := "[" + strconv.Itoa(n) + "]"
+		if !lastFMTrack(v, p.Attrs, suffix) {
+			continue
+		}
+		v.Set("timestamp"+suffix, strconv.FormatInt(p.Time.Unix(), 10))
+		n++
+
+		if n == maxLastFMScrobbles {
+			if err := l.scrobble(sk, v); err != nil {
+				return err
+			}
+
+			v = make(url.Values)
+			n = 0
+		}
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	return l.scrobble(sk, v)
+}
+
+// scrobble submits a batch of scrobbles using session key sk.
+func (l *lastFM) scrobble(sk string, v url.Values) error {
+	v.Set("method", "track.scrobble")
+	return l.call(sk, v)
+}
+
+// lastFMTrack adds the parameters which describe a song to v, appending
+// suffix to each parameter name.  If the song lacks an artist or title, or is
+// too short to be scrobbled, it returns false.
+func lastFMTrack(v url.Values, a mpd.Attrs, suffix string) bool {
+	if a["Artist"] == "" || a["Title"] == "" {
+		return false
+	}
+
+	d := songDuration(a)
+	if d > 0 && d <= minLastFMDuration {
+		return false
+	}
+
+	v.Set("artist"+suffix, a["Artist"])
+	v.Set("track"+suffix, a["Title"])
+
+	optional := []struct {
+		name, value string
+	}{
+		{name: "album", value: a["Album"]},
+		{name: "albumArtist", value: a["AlbumArtist"]},
+		{name: "mbid", value: a["MUSICBRAINZ_TRACKID"]},
+	}
+	for _, o := range optional {
+		if o.value != "" {
+			v.Set(o.name+suffix, o.value)
+		}
+	}
+
+	if n := leadingInt(a["Track"]); n > 0 {
+		v.Set("trackNumber"+suffix, strconv.Itoa(n))
+	}
+	if d > 0 {
+		v.Set("duration"+suffix, strconv.Itoa(d))
+	}
+
+	return true
+}
+
+// call signs and performs a Last.fm API call using session key sk.
+func (l *lastFM) call(sk string, v url.Values) error {
+	v.Set("api_key", l.cfg.APIKey)
+	v.Set("sk", sk)
+	v.Set("api_sig", lastFMSignature(v, l.cfg.Secret))
+	v.Set("format", "json")
+
+	return l.provider.call(context.Background(), func(ctx context.Context) error {
+		return l.post(ctx, v)
+	})
+}
+
+// post performs a Last.fm API call with the signed parameters in v.
+func (l *lastFM) post(ctx context.Context, v url.Values) error {
+	req, err := http.NewRequest(http.MethodPost, l.endpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(contentType, "application/x-www-form-urlencoded")
+
+	res, err := l.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("last.fm returned HTTP %d", res.StatusCode)
+	}
+
+	var body struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil && res.StatusCode == http.StatusOK {
+		return &requestError{err: fmt.Errorf("failed to decode last.fm response: %v", err)}
+	}
+
+	// Errors such as an invalid session key only affect a single user, so
+	// must not stop plays being forwarded for other users
+	if body.Error != 0 {
+		return &requestError{err: fmt.Errorf("last.fm error %d: %s", body.Error, body.Message)}
+	}
+	if res.StatusCode != http.StatusOK {
+		return &requestError{err: fmt.Errorf("last.fm returned HTTP %d", res.StatusCode)}
+	}
+
+	return nil
+}
+
+// get performs an unauthenticated Last.fm API call with the parameters in v,
+// such as a call to artist.getInfo, and returns the response.  Responses
+// are cached for the TTL of l.cache, and expired responses are returned in
+// place of an error while Last.fm is unavailable.  Responses indicating that
+// an artist or album is unknown are cached like any other.
+func (l *lastFM) get(ctx context.Context, v url.Values) ([]byte, error) {
+	key := "last.fm " + v.Encode()
+
+	cached, fresh, ok := l.cache.get(key, time.Now())
+	if fresh {
+		return cached, nil
+	}
+
+	v.Set("api_key", l.cfg.APIKey)
+	v.Set("format", "json")
+
+	var b []byte
+	err := l.provider.call(ctx, func(ctx context.Context) error {
+		var err error
+		b, err = l.request(ctx, v)
+		return err
+	})
+	if err != nil {
+		if ok {
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	// The response remains usable even if it cannot be cached
+	_ = l.cache.add(key, b)
+	return b, nil
+}
+
+// request performs a Last.fm API call with the parameters in v using the
+// GET method, and returns the response body.
+func (l *lastFM) request(ctx context.Context, v url.Values) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, l.endpoint+"?"+v.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := l.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("last.fm returned HTTP %d", res.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxLastFMResponse))
+	if err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		if res.StatusCode != http.StatusOK {
+			return nil, &requestError{err: fmt.Errorf("last.fm returned HTTP %d", res.StatusCode)}
+		}
+		return nil, &requestError{err: fmt.Errorf("failed to decode last.fm response: %v", err)}
+	}
+
+	switch body.Error {
+	case 0:
+	case lastFMNotFound:
+		return b, nil
+	default:
+		return nil, &requestError{err: fmt.Errorf("last.fm error %d: %s", body.Error, body.Message)}
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, &requestError{err: fmt.Errorf("last.fm returned HTTP %d", res.StatusCode)}
+	}
+
+	return b, nil
+}
+
+// A lastFMImage is an image of an artist or album, in one of several sizes.
+type lastFMImage struct {
+	URL  string `json:"#text"`
+	Size string `json:"size"`
+}
+
+// lastFMImageURL returns the URL of the image with the specified size, such
+// as "small" or "large".
+func lastFMImageURL(images []lastFMImage, size string) string {
+	for _, img := range images {
+		if img.Size == size {
+			return img.URL
+		}
+	}
+
+	return ""
+}
+
+// A lastFMArtist is an artist's information as returned by artist.getInfo.
+type lastFMArtist struct {
+	Name   string        `json:"name"`
+	MBID   string        `json:"mbid"`
+	URL    string        `json:"url"`
+	Images []lastFMImage `json:"image"`
+	Bio    struct {
+		Summary string `json:"summary"`
+	} `json:"bio"`
+}
+
+// artistInfo retrieves the information of the named artist.  If Last.fm does
+// not know the artist, it returns nil.
+func (l *lastFM) artistInfo(ctx context.Context, artist string) (*lastFMArtist, error) {
+	b, err := l.get(ctx, url.Values{
+		"method":      {"artist.getInfo"},
+		"artist":      {artist},
+		"autocorrect": {"1"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		Artist *lastFMArtist `json:"artist"`
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, fmt.Errorf("failed to decode last.fm artist: %v", err)
+	}
+
+	return body.Artist, nil
+}
+
+// A lastFMAlbum is an album's information as returned by album.getInfo.
+type lastFMAlbum struct {
+	Name   string        `json:"name"`
+	MBID   string        `json:"mbid"`
+	URL    string        `json:"url"`
+	Images []lastFMImage `json:"image"`
+	Wiki   struct {
+		Summary string `json:"summary"`
+	} `json:"wiki"`
+}
+
+// albumInfo retrieves the information of the named album by artist.  If
+// Last.fm does not know the album, it returns nil.
+func (l *lastFM) albumInfo(ctx context.Context, artist, album string) (*lastFMAlbum, error) {
+	b, err := l.get(ctx, url.Values{
+		"method":      {"album.getInfo"},
+		"artist":      {artist},
+		"album":       {album},
+		"autocorrect": {"1"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		Album *lastFMAlbum `json:"album"`
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, fmt.Errorf("failed to decode last.fm album: %v", err)
+	}
+
+	return body.Album, nil
+}
+
+// similarArtists retrieves the names of up to limit artists similar to the
+// named artist, most similar first.
+func (l *lastFM) similarArtists(ctx context.Context, artist string, limit int) ([]string, error) {
+	b, err := l.get(ctx, url.Values{
+		"method":      {"artist.getSimilar"},
+		"artist":      {artist},
+		"autocorrect": {"1"},
+		"limit":       {strconv.Itoa(limit)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		SimilarArtists struct {
+			Artists []struct {
+				Name string `json:"name"`
+			} `json:"artist"`
+		} `json:"similarartists"`
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, fmt.Errorf("failed to decode last.fm similar artists: %v", err)
+	}
+
+	names := make([]string, 0, len(body.SimilarArtists.Artists))
+	for _, a := range body.SimilarArtists.Artists {
+		names = append(names, a.Name)
+	}
+
+	return names, nil
+}
+
+// A lastFMSong identifies a song known to Last.fm.
+type lastFMSong struct {
+	Artist string
+	Title  string
+}
+
+// similarTracks retrieves up to limit songs similar to the song with the
+// specified artist and title, most similar first.
+func (l *lastFM) similarTracks(ctx context.Context, artist, title string, limit int) ([]lastFMSong, error) {
+	b, err := l.get(ctx, url.Values{
+		"method":      {"track.getSimilar"},
+		"artist":      {artist},
+		"track":       {title},
+		"autocorrect": {"1"},
+		"limit":       {strconv.Itoa(limit)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		SimilarTracks struct {
+			Tracks []struct {
+				Name   string `json:"name"`
+				Artist struct {
+					Name string `json:"name"`
+				} `json:"artist"`
+			} `json:"track"`
+		} `json:"similartracks"`
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, fmt.Errorf("failed to decode last.fm similar tracks: %v", err)
+	}
+
+	tracks := make([]lastFMSong, 0, len(body.SimilarTracks.Tracks))
+	for _, t := range body.SimilarTracks.Tracks {
+		tracks = append(tracks, lastFMSong{
+			Artist: t.Artist.Name,
+			Title:  t.Name,
+		})
+	}
+
+	return tracks, nil
+}
+
+// lastFMSignature computes the signature of the parameters of a Last.fm API
+// call: the MD5 hash of each parameter name and value, ordered by name,
+// followed by secret.
+func lastFMSignature(v url.Values, secret string) string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		if k == "format" || k == "api_sig" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteString(v.Get(k))
+	}
+	b.WriteString(secret)
+
+	sum := md5.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,134 @@
+package mpdsub
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func Test_lastFM(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []url.Values
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("failed to parse form: %v", err)
+		}
+
+		mu.Lock()
+		calls = append(calls, r.PostForm)
+		mu.Unlock()
+
+		if r.PostForm.Get("sk") == "revoked" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error":9,"message":"Invalid session key"}`)
+			return
+		}
+
+		fmt.Fprint(w, `{}`)
+	}))
+	defer srv.Close()
+
+	l := newLastFM(LastFM{
+		APIKey: "key",
+		Secret: "secret",
+		SessionKeys: map[string]string{
+			"test":    "session",
+			"revoked": "revoked",
+		},
+	})
+	l.endpoint = srv.URL
+
+	now := time.Unix(1500000000, 0)
+	plays := []scrobblePlay{
+		{
+			historyEntry: historyEntry{File: "foo.mp3", Time: now},
+			Attrs: mpd.Attrs{
+				"file":     "foo.mp3",
+				"Artist":   "Foo",
+				"Title":    "Bar",
+				"Album":    "Baz",
+				"Track":    "3/12",
+				"duration": "200.5",
+			},
+		},
+		// Songs which are too short or not tagged are not scrobbled
+		{
+			historyEntry: historyEntry{File: "short.mp3", Time: now},
+			Attrs:        mpd.Attrs{"file": "short.mp3", "Artist": "Foo", "Title": "Short", "duration": "20"},
+		},
+		{
+			historyEntry: historyEntry{File: "untagged.mp3", Time: now},
+			Attrs:        mpd.Attrs{"file": "untagged.mp3"},
+		},
+	}
+
+	if err := l.Scrobble("test", plays); err != nil {
+		t.Fatalf("failed to scrobble: %v", err)
+	}
+	if err := l.NowPlaying("test", plays[0]); err != nil {
+		t.Fatalf("failed to update now playing: %v", err)
+	}
+
+	// Users without a session key are ignored
+	if err := l.Scrobble("nobody", plays); err != nil {
+		t.Fatalf("failed to ignore user without session key: %v", err)
+	}
+
+	if err := l.Scrobble("revoked", plays); err == nil {
+		t.Fatal("expected an error for revoked session key, but none occurred")
+	}
+
+	if want, got := 3, len(calls); want != got {
+		t.Fatalf("unexpected number of API calls:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	scrobble := calls[0]
+	want := url.Values{
+		"method":         {"track.scrobble"},
+		"api_key":        {"key"},
+		"sk":             {"session"},
+		"format":         {"json"},
+		"artist[0]":      {"Foo"},
+		"track[0]":       {"Bar"},
+		"album[0]":       {"Baz"},
+		"trackNumber[0]": {"3"},
+		"duration[0]":    {"200"},
+		"timestamp[0]":   {"1500000000"},
+	}
+	want.Set("api_sig", lastFMSignature(want, "secret"))
+
+	if want, got := want.Encode(), scrobble.Encode(); want != got {
+		t.Fatalf("unexpected scrobble parameters:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	nowPlaying := calls[1]
+	if want, got := "track.updateNowPlaying", nowPlaying.Get("method"); want != got {
+		t.Fatalf("unexpected method:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := "Bar", nowPlaying.Get("track"); want != got {
+		t.Fatalf("unexpected now playing track:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_lastFMSignature(t *testing.T) {
+	v := url.Values{
+		"method":  {"auth.getSession"},
+		"api_key": {"key"},
+		"token":   {"token"},
+		"format":  {"json"},
+	}
+
+	// md5("api_keykeymethodauth.getSessiontokentokensecret")
+	if want, got := "9ac306496295a8866c4a8673395540eb", lastFMSignature(v, "secret"); want != got {
+		t.Fatalf("unexpected signature:\n- want: %v\n-  got: %v", want, got)
+	}
+}
@@ -0,0 +1,106 @@
+package mpdsub
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Limits on the parameters of a request, which protect the Server and MPD
+// from pathological requests, such as a scrobble of thousands of songs which
+// would turn into thousands of MPD commands.  The limits are well above what
+// any Subsonic client sends.
+const (
+	// maxQueryLength is the maximum length in bytes of a request's query
+	// string.
+	maxQueryLength = 64 << 10
+
+	// maxParameters is the maximum number of distinct parameters in a
+	// request.
+	maxParameters = 64
+
+	// maxParameterValues is the maximum number of values of a single
+	// parameter, such as the IDs of songs to scrobble.
+	maxParameterValues = 500
+
+	// maxParameterLength is the maximum length in bytes of a single
+	// parameter value, such as a search query.
+	maxParameterLength = 1024
+
+	// maxSaltLength is the maximum length in bytes of the salt used for
+	// token authentication.
+	maxSaltLength = 64
+
+	// maxListValues is the maximum number of values of a parameter which
+	// lists the songs of a playlist or play queue.
+	maxListValues = 10000
+
+	// maxListQueryLength is the maximum length in bytes of the query string
+	// of a request with a parameter which lists songs, enough for
+	// maxListValues IDs.
+	maxListQueryLength = 1 << 20
+)
+
+// listParameters maps the paths of endpoints to their parameters which list
+// the songs of a playlist or play queue.  Saving a large playlist sends far
+// more IDs than maxParameterValues, and each ID does not turn into a MPD
+// command of its own, so these parameters are limited by maxListValues.
+var listParameters = map[string][]string{
+	"/rest/createPlaylist.view": {"songId"},
+	"/rest/updatePlaylist.view": {"songIdToAdd", "songIndexToRemove"},
+	"/rest/savePlayQueue.view":  {"id"},
+}
+
+// checkParameterLimits verifies that the raw query string of a request to
+// path and its parsed parameters are within the limits of the Server.  If
+// not, an error describing the limit which was exceeded is returned.
+func checkParameterLimits(path string, rawQuery string, q url.Values) error {
+	lists := listParameters[path]
+
+	maxQuery := maxQueryLength
+	if len(lists) > 0 {
+		maxQuery = maxListQueryLength
+	}
+
+	if len(rawQuery) > maxQuery {
+		return fmt.Errorf("query string exceeds %d bytes", maxQuery)
+	}
+	if len(q) > maxParameters {
+		return fmt.Errorf("request exceeds %d parameters", maxParameters)
+	}
+
+	for k, vs := range q {
+		maxValues := maxParameterValues
+		for _, l := range lists {
+			if k == l {
+				maxValues = maxListValues
+			}
+		}
+
+		if len(vs) > maxValues {
+			return fmt.Errorf("parameter %q exceeds %d values", k, maxValues)
+		}
+
+		for _, v := range vs {
+			if len(v) > maxParameterLength {
+				return fmt.Errorf("parameter %q exceeds %d bytes", k, maxParameterLength)
+			}
+		}
+	}
+
+	if s := q.Get("s"); len(s) > maxSaltLength {
+		return fmt.Errorf("salt exceeds %d bytes", maxSaltLength)
+	}
+
+	return nil
+}
+
+// errParameterLimit indicates that a request's parameters exceed a limit.
+func errParameterLimit(err error) func(c *container) {
+	return func(c *container) {
+		c.Status = statusFailed
+		c.Error = &subsonicError{
+			Code:    codeGeneric,
+			Message: "Request parameters exceed limit: " + err.Error() + ".",
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package mpdsub
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestServer_parameterLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string][]string
+		err    string
+	}{
+		{
+			name:   "OK",
+			params: map[string][]string{"id": {"0", "1"}, "query": {"foo"}},
+		},
+		{
+			name:   "too many parameters",
+			params: manyParameters(maxParameters),
+			err:    "request exceeds 64 parameters",
+		},
+		{
+			name:   "too many values",
+			params: map[string][]string{"id": make([]string, maxParameterValues+1)},
+			err:    `parameter "id" exceeds 500 values`,
+		},
+		{
+			name:   "value too long",
+			params: map[string][]string{"query": {strings.Repeat("a", maxParameterLength+1)}},
+			err:    `parameter "query" exceeds 1024 bytes`,
+		},
+		{
+			name:   "salt too long",
+			params: map[string][]string{"s": {strings.Repeat("a", maxSaltLength+1)}},
+			err:    "salt exceeds 64 bytes",
+		},
+		{
+			name: "query string too long",
+			params: map[string][]string{
+				"id": repeatValue(strings.Repeat("a", maxParameterLength), maxQueryLength/maxParameterLength+1),
+			},
+			err: "query string exceeds 65536 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			for k, vs := range tt.params {
+				values[k] = vs
+			}
+
+			withServer(t, &memoryDatabase{}, nil, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getLicense.view", values))
+
+				if tt.err == "" {
+					if c.Error != nil {
+						t.Fatalf("unexpected error: %+v", c.Error)
+					}
+					return
+				}
+
+				if c.Error == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+				if want, got := codeGeneric, c.Error.Code; want != got {
+					t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+				}
+				if !strings.Contains(c.Error.Message, tt.err) {
+					t.Fatalf("error message %q does not contain %q", c.Error.Message, tt.err)
+				}
+			})
+		})
+	}
+}
+
+func Test_checkParameterLimitsLists(t *testing.T) {
+	ids := make([]string, maxParameterValues+1)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		key  string
+		ok   bool
+	}{
+		{
+			name: "playlist songs",
+			path: "/rest/createPlaylist.view",
+			key:  "songId",
+			ok:   true,
+		},
+		{
+			name: "play queue songs",
+			path: "/rest/savePlayQueue.view",
+			key:  "id",
+			ok:   true,
+		},
+		{
+			name: "other parameter",
+			path: "/rest/createPlaylist.view",
+			key:  "name",
+		},
+		{
+			name: "other endpoint",
+			path: "/rest/scrobble.view",
+			key:  "id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := url.Values{tt.key: ids}
+
+			err := checkParameterLimits(tt.path, q.Encode(), q)
+			if want, got := tt.ok, err == nil; want != got {
+				t.Fatalf("unexpected result:\n- want: %v\n-  got: %v (%v)", want, got, err)
+			}
+		})
+	}
+}
+
+// manyParameters returns n distinct parameters, which together with the
+// authentication parameters exceed maxParameters.
+func manyParameters(n int) map[string][]string {
+	params := make(map[string][]string, n)
+	for i := 0; i < n; i++ {
+		params["p"+strconv.Itoa(i)] = []string{"x"}
+	}
+
+	return params
+}
+
+// repeatValue returns a parameter with n copies of v.
+func repeatValue(v string, n int) []string {
+	vs := make([]string, n)
+	for i := range vs {
+		vs[i] = v
+	}
+
+	return vs
+}
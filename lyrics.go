@@ -0,0 +1,378 @@
+package mpdsub
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxLyricsSize is the maximum size in bytes of a lyrics file which will be
+// read.
+const maxLyricsSize = 1 << 20
+
+// lyricsExtensions are the extensions of sidecar lyrics files stored next
+// to songs in the music directory, in order of preference.
+var lyricsExtensions = []string{".lrc", ".txt"}
+
+var (
+	// lrcTimestamp matches a LRC timestamp, such as "[01:23.45]", at the
+	// beginning of a line.
+	lrcTimestamp = regexp.MustCompile(`^\[(\d+):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+
+	// lrcMetadata matches a line containing a LRC metadata tag, such as
+	// "[ar:Artist]".
+	lrcMetadata = regexp.MustCompile(`^\[[a-zA-Z#]+:[^\]]*\]$`)
+
+	// lrcOffset matches a LRC offset tag, such as "[offset:+250]", which
+	// adjusts the timestamps of all lines by a number of milliseconds.
+	lrcOffset = regexp.MustCompile(`^\[offset:\s*([+-]?\d+)\s*\]$`)
+)
+
+// getLyrics is used in Subsonic to retrieve the lyrics of a song by its
+// artist and title.  Lyrics are read from the first matching song which has
+// a sidecar file or embedded lyrics.  If no lyrics are found, an empty
+// lyrics element is returned, as Subsonic does.
+func (s *Server) getLyrics(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	artist, title := q.Get("artist"), q.Get("title")
+
+	l := &lyrics{
+		Artist: artist,
+		Title:  title,
+	}
+
+	// Without a title, the song cannot be identified
+	if title == "" {
+		writeXML(w, func(c *container) {
+			c.Lyrics = l
+		})
+		return
+	}
+
+	args := []string{"title", title}
+	if artist != "" {
+		args = append([]string{"artist", artist}, args...)
+	}
+
+	songs, err := s.db.Find(args...)
+	if err != nil {
+		s.logf("error finding songs in mpd for lyrics: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	user := requestContextFrom(r).User
+	for _, a := range songs {
+		name := a["file"]
+		if name == "" || !s.visible(user, name) {
+			continue
+		}
+
+		text, lrc, ok := s.songLyrics(name)
+		if !ok {
+			continue
+		}
+
+		l.Artist = a["Artist"]
+		l.Title = a["Title"]
+		l.Text = plainLyrics(text, lrc)
+		break
+	}
+
+	writeXML(w, func(c *container) {
+		c.Lyrics = l
+	})
+}
+
+// getLyricsBySongID is used in OpenSubsonic to retrieve the structured
+// lyrics of a song by its ID.  Lyrics in LRC format are returned as synced
+// lines with their start times, so clients can highlight each line as it is
+// sung.  If the song has no lyrics, an empty list is returned.
+func (s *Server) getLyricsBySongID(w http.ResponseWriter, r *http.Request) {
+	qID := r.URL.Query().Get("id")
+	if qID == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		s.logf("error listing files from mpd for lyrics: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	f, ok := s.lookupID(indexFiles(fs), qID)
+	if !ok || f.Dir {
+		writeXML(w, errNotFound)
+		return
+	}
+
+	if !s.visible(requestContextFrom(r).User, f.Name) {
+		writeXML(w, errNotAuthorized)
+		return
+	}
+
+	l := &lyricsList{}
+
+	if text, lrc, ok := s.songLyrics(f.Name); ok {
+		a, err := s.songAttrs(f.Name)
+		if err != nil {
+			s.logf("error retrieving song %q from mpd for lyrics: %v", f.Name, err)
+			writeXML(w, errMPD(err))
+			return
+		}
+
+		sl := structuredLyricsFrom(text, lrc)
+		sl.DisplayArtist = a["Artist"]
+		sl.DisplayTitle = a["Title"]
+		l.StructuredLyrics = append(l.StructuredLyrics, sl)
+	}
+
+	writeXML(w, func(c *container) {
+		c.LyricsList = l
+	})
+}
+
+// structuredLyricsFrom splits lyrics into lines.  If lrc is true, the text
+// is in LRC format, and each line is timestamped with the time at which it
+// begins, adjusted by any offset tag.  Lines with several timestamps are
+// repeated at each time, and lines are ordered by time.
+func structuredLyricsFrom(text string, lrc bool) structuredLyrics {
+	// The language of lyrics files is not known
+	sl := structuredLyrics{Lang: "und"}
+
+	if !lrc {
+		for _, line := range strings.Split(plainLyrics(text, false), "\n") {
+			sl.Lines = append(sl.Lines, lyricsLine{Value: line})
+		}
+		return sl
+	}
+
+	sl.Synced = true
+
+	var offset int64
+	for _, line := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+		line = strings.TrimSpace(line)
+
+		if lrcMetadata.MatchString(line) {
+			// A positive offset makes lines appear sooner
+			if m := lrcOffset.FindStringSubmatch(line); m != nil {
+				offset, _ = strconv.ParseInt(m[1], 10, 64)
+			}
+			continue
+		}
+
+		var starts []int64
+		for {
+			m := lrcTimestamp.FindStringSubmatch(line)
+			if m == nil {
+				break
+			}
+
+			starts = append(starts, lrcMillis(m))
+			line = line[len(m[0]):]
+		}
+
+		// Lines without timestamps cannot be synced
+		line = strings.TrimSpace(line)
+		for _, start := range starts {
+			start := start
+			sl.Lines = append(sl.Lines, lyricsLine{
+				Start: &start,
+				Value: line,
+			})
+		}
+	}
+
+	for i := range sl.Lines {
+		start := *sl.Lines[i].Start - offset
+		if start < 0 {
+			start = 0
+		}
+		sl.Lines[i].Start = &start
+	}
+
+	sort.Stable(byStart(sl.Lines))
+	return sl
+}
+
+// lrcMillis returns the time in milliseconds of a LRC timestamp matched by
+// lrcTimestamp.  The fraction of a second may have up to three digits, so
+// that "[00:01.5]" is 1500 milliseconds.
+func lrcMillis(m []string) int64 {
+	min, _ := strconv.ParseInt(m[1], 10, 64)
+	sec, _ := strconv.ParseInt(m[2], 10, 64)
+
+	var ms int64
+	if m[3] != "" {
+		ms, _ = strconv.ParseInt((m[3] + "00")[:3], 10, 64)
+	}
+
+	return (min*60+sec)*1000 + ms
+}
+
+// byStart sorts synced lyrics lines by their start time.
+type byStart []lyricsLine
+
+func (b byStart) Len() int           { return len(b) }
+func (b byStart) Less(i, j int) bool { return *b[i].Start < *b[j].Start }
+func (b byStart) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// songLyrics returns the lyrics of the song with the specified name, and
+// whether they are in LRC format.  A sidecar file is preferred over lyrics
+// embedded in the song's tags.
+func (s *Server) songLyrics(name string) (string, bool, bool) {
+	if sidecar, ok := s.lyricsSidecar(name); ok {
+		b, err := s.readMusicFile(sidecar, maxLyricsSize)
+		if err == nil {
+			return decodeText(b), path.Ext(sidecar) == ".lrc", true
+		}
+
+		s.logf("error reading lyrics %q: %v", sidecar, err)
+	}
+
+	text, ok := s.embeddedLyrics(name)
+	if !ok {
+		return "", false, false
+	}
+
+	return text, isLRC(text), true
+}
+
+// embeddedLyrics returns the lyrics embedded in the tags of the song with
+// the specified name.  Tags are parsed from the song itself when
+// MusicDirectory is local, and are otherwise read using MPD's readcomments
+// command, which also supports formats such as Ogg Vorbis.  Songs without
+// lyrics are remembered for a time, and are not looked up again.
+func (s *Server) embeddedLyrics(name string) (string, bool) {
+	const kind = "embedded lyrics"
+
+	if s.misses.missed(kind, name, time.Now()) {
+		return "", false
+	}
+
+	var text string
+	if s.cfg.MusicDirectory != "" && s.musicURL == nil {
+		f, err := s.fs.Open(s.musicPath(name))
+		if err == nil {
+			text, err = readEmbeddedLyrics(f)
+			_ = f.Close()
+		}
+
+		switch err {
+		case nil, errBadTag, io.EOF, io.ErrUnexpectedEOF:
+		default:
+			if !os.IsNotExist(err) {
+				s.logf("error reading embedded lyrics %q: %v", name, err)
+			}
+		}
+	}
+
+	if strings.TrimSpace(text) == "" {
+		// Older versions of MPD and some decoders do not support
+		// readcomments, so errors are not reported
+		tags, err := s.db.ReadComments(name)
+		if err == nil {
+			text = firstComment(tags, lyricsTags...)
+		}
+	}
+
+	if strings.TrimSpace(text) == "" {
+		s.misses.add(kind, name, time.Now())
+		return "", false
+	}
+
+	return text, true
+}
+
+// isLRC reports whether text is in LRC format, because a line begins with
+// a timestamp.  Embedded lyrics have no file extension to indicate their
+// format.
+func isLRC(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		if lrcTimestamp.MatchString(strings.TrimSpace(line)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// plainLyrics returns the text of lyrics without trailing whitespace.  If
+// lrc is true, the text is in LRC format, and its timestamps and metadata
+// tags are removed.
+func plainLyrics(text string, lrc bool) string {
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if !lrc {
+			out = append(out, line)
+			continue
+		}
+
+		// Metadata is not part of the lyrics, and lines may carry several
+		// timestamps when they are repeated
+		if lrcMetadata.MatchString(line) {
+			continue
+		}
+		for lrcTimestamp.MatchString(line) {
+			line = lrcTimestamp.ReplaceAllString(line, "")
+		}
+
+		out = append(out, strings.TrimLeft(line, " "))
+	}
+
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+// decodeText decodes a text file, removing any UTF-8 byte order mark.  Files
+// which are not valid UTF-8 are decoded as Latin-1, which is common for
+// older sidecar files.
+func decodeText(b []byte) string {
+	b = []byte(strings.TrimPrefix(string(b), "\xef\xbb\xbf"))
+	if utf8.Valid(b) {
+		return string(b)
+	}
+
+	rs := make([]rune, 0, len(b))
+	for _, c := range b {
+		rs = append(rs, rune(c))
+	}
+
+	return string(rs)
+}
+
+// lyricsSidecar returns the name of the sidecar lyrics file for the song
+// with the specified name: a file with the same name as the song, but with
+// one of lyricsExtensions.  Songs without lyrics are remembered for a time,
+// and are not looked up again.
+func (s *Server) lyricsSidecar(name string) (string, bool) {
+	const kind = "lyrics"
+
+	if s.cfg.MusicDirectory == "" && s.musicURL == nil {
+		return "", false
+	}
+	if s.misses.missed(kind, name, time.Now()) {
+		return "", false
+	}
+
+	base := strings.TrimSuffix(name, path.Ext(name))
+	for _, ext := range lyricsExtensions {
+		if n := base + ext; s.musicFileExists(n) {
+			return n, true
+		}
+	}
+
+	s.misses.add(kind, name, time.Now())
+	return "", false
+}
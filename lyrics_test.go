@@ -0,0 +1,315 @@
+package mpdsub
+
+import (
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_getLyrics(t *testing.T) {
+	var (
+		yesterday = mpd.Attrs{"file": "Beatles/Help/yesterday.mp3", "Artist": "The Beatles", "Title": "Yesterday"}
+		help      = mpd.Attrs{"file": "Beatles/Help/help.mp3", "Artist": "The Beatles", "Title": "Help!"}
+		ticket    = mpd.Attrs{"file": "Beatles/Help/ticket.mp3", "Artist": "The Beatles", "Title": "Ticket to Ride"}
+		night     = mpd.Attrs{"file": "Beatles/Help/night.ogg", "Artist": "The Beatles", "Title": "The Night Before"}
+	)
+
+	db := &memoryDatabase{
+		files: []string{
+			"Beatles/Help/help.mp3",
+			"Beatles/Help/night.ogg",
+			"Beatles/Help/ticket.mp3",
+			"Beatles/Help/yesterday.mp3",
+		},
+		attrs: map[string]mpd.Attrs{
+			"Beatles/Help/night.ogg": {"lyrics": "We said our goodbyes"},
+		},
+		finds: map[string][]mpd.Attrs{
+			"artist The Beatles title Yesterday":        {yesterday},
+			"title Yesterday":                           {yesterday},
+			"artist The Beatles title Help!":            {help},
+			"artist The Beatles title Ticket to Ride":   {ticket},
+			"artist The Beatles title The Night Before": {night},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		artist string
+		title  string
+		text   string
+	}{
+		{
+			name:   "no title",
+			artist: "The Beatles",
+		},
+		{
+			name:   "LRC sidecar",
+			artist: "The Beatles",
+			title:  "Yesterday",
+			text:   "Yesterday\nAll my troubles seemed so far away",
+		},
+		{
+			name:  "title only",
+			title: "Yesterday",
+			text:  "Yesterday\nAll my troubles seemed so far away",
+		},
+		{
+			name:   "embedded",
+			artist: "The Beatles",
+			title:  "Ticket to Ride",
+			text:   "I think I'm gonna be sad",
+		},
+		{
+			name:   "readcomments",
+			artist: "The Beatles",
+			title:  "The Night Before",
+			text:   "We said our goodbyes",
+		},
+		{
+			name:   "no lyrics",
+			artist: "The Beatles",
+			title:  "Help!",
+		},
+		{
+			name:   "no song",
+			artist: "Nobody",
+			title:  "Nothing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ticketMP3 := id3Tag(3,
+				id3Frame(3, "USLT", []byte("\x00engverse\x00[00:05.00]I think I'm gonna be sad")),
+			)
+			lrc := "[ar:The Beatles]\r\n[ti:Yesterday]\r\n[00:01.00]Yesterday\r\n[00:04.50][01:10.00] All my troubles seemed so far away\r\n"
+			fs := &memoryFilesystem{
+				files: map[string]*memoryFile{
+					filepath.Join("/music", "Beatles", "Help", "yesterday.lrc"): {ReadSeeker: bytes.NewReader([]byte(lrc))},
+					filepath.Join("/music", "Beatles", "Help", "ticket.mp3"):    {ReadSeeker: bytes.NewReader(ticketMP3)},
+				},
+			}
+
+			cfg, values := configAuth()
+			cfg.MusicDirectory = "/music"
+			values.Set("artist", tt.artist)
+			values.Set("title", tt.title)
+
+			withServer(t, db, fs, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getLyrics.view", values))
+				if c.Error != nil {
+					t.Fatalf("unexpected error: %v", c.Error.Message)
+				}
+
+				if want, got := tt.text, c.Lyrics.Text; want != got {
+					t.Fatalf("unexpected lyrics:\n- want: %q\n-  got: %q", want, got)
+				}
+			})
+		})
+	}
+}
+
+func Test_plainLyrics(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		lrc  bool
+		out  string
+	}{
+		{
+			name: "text",
+			in:   "\n[Chorus]\r\nLa la la  \n\n",
+			out:  "[Chorus]\nLa la la",
+		},
+		{
+			name: "LRC",
+			in:   "[ar:Foo]\n[00:01.00]One\n[00:02.00]\n[00:03.00][00:05.00]Two\n",
+			lrc:  true,
+			out:  "One\n\nTwo",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if want, got := tt.out, plainLyrics(tt.in, tt.lrc); want != got {
+				t.Fatalf("unexpected lyrics:\n- want: %q\n-  got: %q", want, got)
+			}
+		})
+	}
+}
+
+func Test_decodeText(t *testing.T) {
+	if want, got := "Café", decodeText([]byte("\xef\xbb\xbfCaf\xc3\xa9")); want != got {
+		t.Fatalf("unexpected UTF-8 text:\n- want: %q\n-  got: %q", want, got)
+	}
+	if want, got := "Café", decodeText([]byte("Caf\xe9")); want != got {
+		t.Fatalf("unexpected Latin-1 text:\n- want: %q\n-  got: %q", want, got)
+	}
+}
+
+func TestServer_getLyricsBySongID(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Beatles/Help/help.mp3",
+			"Beatles/Help/yesterday.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "Beatles/Help/yesterday.mp3", "Artist": "The Beatles", "Title": "Yesterday"},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		id    string
+		lines []lyricsLine
+		err   int
+	}{
+		{
+			name: "directory",
+			id:   "1",
+			err:  70,
+		},
+		{
+			name: "no lyrics",
+			id:   "2",
+		},
+		{
+			name: "synced",
+			id:   "3",
+			lines: []lyricsLine{
+				{Start: int64p(1000), Value: "Yesterday"},
+				{Start: int64p(4500), Value: "All my troubles seemed so far away"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lrc := "[ti:Yesterday]\n[00:01.00]Yesterday\n[00:04.50]All my troubles seemed so far away\n"
+			fs := &memoryFilesystem{
+				files: map[string]*memoryFile{
+					filepath.Join("/music", "Beatles", "Help", "yesterday.lrc"): {ReadSeeker: bytes.NewReader([]byte(lrc))},
+				},
+			}
+
+			cfg, values := configAuth()
+			cfg.MusicDirectory = "/music"
+			values.Set("id", tt.id)
+
+			withServer(t, db, fs, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getLyricsBySongId.view", values))
+				if tt.err != 0 {
+					if c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+					if want, got := tt.err, c.Error.Code; want != got {
+						t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+					}
+					return
+				}
+				if c.Error != nil {
+					t.Fatalf("unexpected error: %v", c.Error.Message)
+				}
+
+				if tt.lines == nil {
+					if want, got := 0, len(c.LyricsList.StructuredLyrics); want != got {
+						t.Fatalf("unexpected number of lyrics:\n- want: %v\n-  got: %v", want, got)
+					}
+					return
+				}
+
+				sl := c.LyricsList.StructuredLyrics[0]
+				if !sl.Synced || sl.DisplayTitle != "Yesterday" {
+					t.Fatalf("unexpected lyrics: %+v", sl)
+				}
+				if want, got := tt.lines, sl.Lines; !reflect.DeepEqual(want, got) {
+					t.Fatalf("unexpected lines:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		})
+	}
+}
+
+func Test_structuredLyricsFrom(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		lrc    bool
+		synced bool
+		lines  []lyricsLine
+	}{
+		{
+			name: "text",
+			in:   "One\r\nTwo\n",
+			lines: []lyricsLine{
+				{Value: "One"},
+				{Value: "Two"},
+			},
+		},
+		{
+			name:   "repeated timestamps",
+			in:     "[ar:Foo]\n[00:01.5]One\n[00:02.00][00:04.00]Two\nuntimed\n[00:03.123]\n",
+			lrc:    true,
+			synced: true,
+			lines: []lyricsLine{
+				{Start: int64p(1500), Value: "One"},
+				{Start: int64p(2000), Value: "Two"},
+				{Start: int64p(3123), Value: ""},
+				{Start: int64p(4000), Value: "Two"},
+			},
+		},
+		{
+			name:   "offset",
+			in:     "[offset:+500]\n[00:00.20]One\n[01:00.00]Two\n",
+			lrc:    true,
+			synced: true,
+			lines: []lyricsLine{
+				{Start: int64p(0), Value: "One"},
+				{Start: int64p(59500), Value: "Two"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sl := structuredLyricsFrom(tt.in, tt.lrc)
+
+			if want, got := tt.synced, sl.Synced; want != got {
+				t.Fatalf("unexpected synced:\n- want: %v\n-  got: %v", want, got)
+			}
+			if want, got := tt.lines, sl.Lines; !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected lines:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestServer_getOpenSubsonicExtensions(t *testing.T) {
+	cfg, values := configAuth()
+
+	withServer(t, &memoryDatabase{}, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getOpenSubsonicExtensions.view", values))
+
+		if !c.OpenSubsonic {
+			t.Fatal("response does not indicate OpenSubsonic support")
+		}
+		var names []string
+		for _, e := range c.OpenSubsonicExtensions {
+			names = append(names, e.Name)
+		}
+
+		if want, got := []string{"songLyrics", "errorDetail"}, names; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected extensions:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
+
+// int64p returns a pointer to v.
+func int64p(v int64) *int64 {
+	return &v
+}
@@ -0,0 +1,263 @@
+package mpdsub
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// m3uPlaylistPrefix is the prefix of the IDs of M3U playlists, which are
+// followed by the path of the playlist file within PlaylistDirectory.
+const m3uPlaylistPrefix = "m3u:"
+
+// m3uPlaylists produces Subsonic playlists from the M3U playlist files in
+// PlaylistDirectory, containing the songs visible to user.
+func (s *Server) m3uPlaylists(user string) ([]*playlist, error) {
+	if s.cfg.PlaylistDirectory == "" {
+		return nil, nil
+	}
+
+	names, err := m3uFiles(s.cfg.PlaylistDirectory)
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+
+	songs, err := s.m3uSongs()
+	if err != nil {
+		return nil, err
+	}
+
+	pls := make([]*playlist, 0, len(names))
+	for _, name := range names {
+		pl, err := s.m3uPlaylist(user, name, songs)
+		if err != nil {
+			return nil, err
+		}
+
+		pls = append(pls, pl)
+	}
+
+	return pls, nil
+}
+
+// m3uPlaylistByID looks up a M3U playlist by its playlist ID.
+func (s *Server) m3uPlaylistByID(user string, id string) (*playlist, bool, error) {
+	if s.cfg.PlaylistDirectory == "" {
+		return nil, false, nil
+	}
+
+	names, err := m3uFiles(s.cfg.PlaylistDirectory)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Only files found in PlaylistDirectory may be read, so an ID cannot
+	// refer to a file elsewhere
+	name := strings.TrimPrefix(id, m3uPlaylistPrefix)
+	i := sort.SearchStrings(names, name)
+	if i == len(names) || names[i] != name {
+		return nil, false, nil
+	}
+
+	songs, err := s.m3uSongs()
+	if err != nil {
+		return nil, false, err
+	}
+
+	pl, err := s.m3uPlaylist(user, name, songs)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return pl, true, nil
+}
+
+// m3uPlaylist produces a Subsonic playlist from the M3U playlist file with
+// the specified name in PlaylistDirectory.  Entries which do not refer to a
+// song in MPD's database are skipped.  M3U playlists are owned by the
+// configured Subsonic user, and are named after their files.
+func (s *Server) m3uPlaylist(user string, name string, songs map[string]mpd.Attrs) (*playlist, error) {
+	file := filepath.Join(s.cfg.PlaylistDirectory, filepath.FromSlash(name))
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the .m3u8 extension guarantees UTF-8
+	latin1 := strings.EqualFold(path.Ext(name), ".m3u")
+
+	var attrs []mpd.Attrs
+	for _, e := range parseM3U(b, latin1) {
+		if f, ok := resolveM3UEntry(e, filepath.Dir(file), s.cfg.MusicDirectory, songs); ok {
+			attrs = append(attrs, songs[f])
+		}
+	}
+
+	children, err := s.songChildren(user, attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	display := strings.TrimSuffix(name, path.Ext(name))
+
+	pl := newPlaylist(m3uPlaylistPrefix+name, display, s.cfg.SubsonicUser, false, children)
+	pl.Created = formatTime(fi.ModTime())
+	pl.Changed = formatTime(fi.ModTime())
+	return pl, nil
+}
+
+// m3uSongs retrieves all songs in MPD's database, keyed by their names, so
+// that M3U playlist entries can be resolved.
+func (s *Server) m3uSongs() (map[string]mpd.Attrs, error) {
+	all, err := s.db.ListAllInfo("")
+	if err != nil {
+		return nil, err
+	}
+
+	songs := make(map[string]mpd.Attrs, len(all))
+	for _, a := range all {
+		if name := a["file"]; name != "" {
+			songs[name] = a
+		}
+	}
+
+	return songs, nil
+}
+
+// m3uModified returns the most recent time at which a M3U playlist, or a
+// directory containing one, was modified in PlaylistDirectory.  Directories
+// are included so that removing a playlist is also detected.
+func (s *Server) m3uModified() time.Time {
+	var latest time.Time
+	if s.cfg.PlaylistDirectory == "" {
+		return latest
+	}
+
+	_ = filepath.Walk(s.cfg.PlaylistDirectory, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if (fi.IsDir() || isM3U(p)) && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+
+		return nil
+	})
+
+	return latest
+}
+
+// m3uFiles returns the slash-separated paths of the M3U playlist files
+// within dir and its subdirectories, in sorted order.
+func m3uFiles(dir string) ([]string, error) {
+	var names []string
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || !isM3U(p) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// isM3U reports whether a file is a M3U playlist, by its extension.
+func isM3U(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".m3u" || ext == ".m3u8"
+}
+
+// parseM3U returns the entries of a M3U playlist, skipping comments and
+// extended M3U directives.  If latin1 is true and the playlist is not valid
+// UTF-8, it is decoded as Latin-1, the traditional encoding of .m3u files.
+func parseM3U(b []byte, latin1 bool) []string {
+	text := string(bytes.TrimPrefix(b, []byte("\xef\xbb\xbf")))
+	if latin1 {
+		text = decodeText(b)
+	}
+
+	var entries []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entries = append(entries, line)
+	}
+
+	return entries
+}
+
+// resolveM3UEntry resolves an entry of a M3U playlist in directory dir to
+// the name of a song in songs.  Entries may be file URLs, absolute paths
+// within musicDir, or paths relative to either the playlist or musicDir.
+// Other URLs, such as streams, and entries outside of musicDir cannot be
+// resolved.
+func resolveM3UEntry(entry string, dir string, musicDir string, songs map[string]mpd.Attrs) (string, bool) {
+	if strings.HasPrefix(strings.ToLower(entry), "file://") {
+		u, err := url.Parse(entry)
+		if err != nil {
+			return "", false
+		}
+		entry = u.Path
+	} else if strings.Contains(entry, "://") {
+		return "", false
+	}
+
+	// Playlists created on Windows use backslashes as separators
+	entry = filepath.FromSlash(strings.Replace(entry, `\`, "/", -1))
+
+	full := entry
+	if !filepath.IsAbs(full) {
+		full = filepath.Join(dir, entry)
+	}
+
+	var names []string
+	if musicDir != "" {
+		rel, err := filepath.Rel(musicDir, full)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			names = append(names, filepath.ToSlash(rel))
+		}
+	}
+
+	// MPD's own playlists use paths relative to its music directory
+	if !filepath.IsAbs(entry) {
+		names = append(names, path.Clean(filepath.ToSlash(entry)))
+	}
+
+	for _, n := range names {
+		if _, ok := songs[n]; ok {
+			return n, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,166 @@
+package mpdsub
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func Test_parseM3U(t *testing.T) {
+	b := []byte("\xef\xbb\xbf#EXTM3U\r\n#EXTINF:123,Artist - Title\r\nfoo.mp3\r\n\r\n  bar.mp3  \n")
+	if want, got := []string{"foo.mp3", "bar.mp3"}, parseM3U(b, false); !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected entries:\n- want: %q\n-  got: %q", want, got)
+	}
+
+	// Latin-1 is only decoded if the playlist is not valid UTF-8
+	latin1 := []byte("Bj\xf6rk.mp3\n")
+	if want, got := []string{"Björk.mp3"}, parseM3U(latin1, true); !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected Latin-1 entries:\n- want: %q\n-  got: %q", want, got)
+	}
+	utf8 := []byte("Björk.mp3\n")
+	if want, got := []string{"Björk.mp3"}, parseM3U(utf8, true); !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected UTF-8 entries:\n- want: %q\n-  got: %q", want, got)
+	}
+}
+
+func Test_resolveM3UEntry(t *testing.T) {
+	musicDir := filepath.FromSlash("/music")
+	dir := filepath.FromSlash("/music/Playlists")
+
+	songs := map[string]mpd.Attrs{
+		"A/foo.mp3":           {},
+		"Playlists/local.mp3": {},
+	}
+
+	tests := []struct {
+		entry string
+		name  string
+	}{
+		{entry: "A/foo.mp3", name: "A/foo.mp3"},
+		{entry: "../A/foo.mp3", name: "A/foo.mp3"},
+		{entry: `..\A\foo.mp3`, name: "A/foo.mp3"},
+		{entry: "local.mp3", name: "Playlists/local.mp3"},
+		{entry: "/music/A/foo.mp3", name: "A/foo.mp3"},
+		{entry: "file:///music/A/foo.mp3", name: "A/foo.mp3"},
+		{entry: "/elsewhere/A/foo.mp3"},
+		{entry: "http://radio.example.com/stream"},
+		{entry: "missing.mp3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			if filepath.Separator != '/' && filepath.IsAbs(tt.entry) {
+				t.Skip("skipping, absolute path is not absolute on this platform")
+			}
+
+			name, ok := resolveM3UEntry(tt.entry, dir, musicDir, songs)
+			if want, got := tt.name != "", ok; want != got {
+				t.Fatalf("unexpected resolution:\n- want: %v\n-  got: %v", want, got)
+			}
+			if want, got := tt.name, name; want != got {
+				t.Fatalf("unexpected name:\n- want: %q\n-  got: %q", want, got)
+			}
+		})
+	}
+}
+
+func TestServer_m3uPlaylists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-m3u")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	musicDir := filepath.Join(dir, "music")
+	playlistDir := filepath.Join(dir, "playlists")
+	if err := os.MkdirAll(filepath.Join(playlistDir, "Mixes"), 0755); err != nil {
+		t.Fatalf("failed to create playlist directory: %v", err)
+	}
+
+	files := map[string]string{
+		"road trip.m3u8":   "#EXTM3U\nb.mp3\n" + filepath.Join(musicDir, "a.mp3") + "\nhttp://radio.example.com/\n",
+		"Mixes/mine.m3u":   "a.mp3\n",
+		"notaplaylist.txt": "a.mp3\n",
+		// A stored playlist in MPD's playlist directory is not listed twice
+		"stored.m3u": "a.mp3\n",
+	}
+	for name, body := range files {
+		if err := ioutil.WriteFile(filepath.Join(playlistDir, filepath.FromSlash(name)), []byte(body), 0644); err != nil {
+			t.Fatalf("failed to write playlist: %v", err)
+		}
+	}
+
+	db := &memoryDatabase{
+		files: []string{"a.mp3", "b.mp3"},
+		songs: []mpd.Attrs{
+			{"file": "a.mp3", "Title": "A"},
+			{"file": "b.mp3", "Title": "B"},
+		},
+		playlists: map[string][]mpd.Attrs{
+			"stored": {{"file": "a.mp3"}},
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.MusicDirectory = musicDir
+	cfg.PlaylistDirectory = playlistDir
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getPlaylists.view", values))
+		if c.Playlists == nil {
+			t.Fatal("no playlists in response")
+		}
+
+		var ids []string
+		for _, pl := range c.Playlists.Playlists {
+			ids = append(ids, pl.ID)
+		}
+
+		if want, got := []string{"pl:stored", "m3u:Mixes/mine.m3u", "m3u:road trip.m3u8"}, ids; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected playlists:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		get := func(id string) container {
+			v := url.Values{}
+			for k, vs := range values {
+				v[k] = vs
+			}
+			v.Set("id", id)
+
+			return mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getPlaylist.view", v))
+		}
+
+		c = get("m3u:road trip.m3u8")
+		if c.Playlist == nil {
+			t.Fatal("no playlist in response")
+		}
+		if want, got := "road trip", c.Playlist.Name; want != got {
+			t.Fatalf("unexpected playlist name:\n- want: %q\n-  got: %q", want, got)
+		}
+
+		var titles []string
+		for _, e := range c.Playlist.Entries {
+			titles = append(titles, e.Title)
+		}
+		if want, got := []string{"B", "A"}, titles; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected songs:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		// Files outside of the playlist directory cannot be read
+		for _, id := range []string{"m3u:notaplaylist.txt", "m3u:../playlists/stored.m3u"} {
+			c = get(id)
+			if c.Error == nil {
+				t.Fatalf("expected an error for %q, but none occurred", id)
+			}
+			if want, got := codeNotFound, c.Error.Code; want != got {
+				t.Fatalf("unexpected XML error code:\n- want: %v\n-  got: %v", want, got)
+			}
+		}
+	})
+}
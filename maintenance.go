@@ -0,0 +1,72 @@
+package mpdsub
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// cacheEvictionInterval is how often expired entries are removed from
+	// in-memory caches which are otherwise only pruned as they grow.
+	cacheEvictionInterval = 10 * time.Minute
+
+	// artworkPrewarmInterval is how often artwork is prewarmed, in addition
+	// to after each update of MPD's database.
+	artworkPrewarmInterval = 24 * time.Hour
+)
+
+// evictCaches is a backgroundJob which removes expired misses, sessions,
+// and stream tokens, so that memory is released even if no new entries are
+// added, as well as long expired metadata.
+func (s *Server) evictCaches(ctx context.Context) error {
+	now := time.Now()
+
+	s.misses.evict(now)
+	s.sessions.evict(now)
+	s.streamTokens.evict(now)
+	s.metadata.evict(now)
+
+	return nil
+}
+
+// prewarmArtwork is a backgroundJob which stores the artwork for each
+// directory in the artwork disk cache, so that clients browsing the library
+// do not wait for artwork to be extracted from songs.  It pauses while the
+// scheduler is busy, and stops when ctx is canceled.
+func (s *Server) prewarmArtwork(ctx context.Context) error {
+	fs, err := s.db.List("file")
+	if err != nil {
+		return err
+	}
+
+	for _, f := range indexFiles(fs) {
+		if !f.Dir {
+			continue
+		}
+		if err := s.jobs.pause(ctx); err != nil {
+			return err
+		}
+
+		if _, err := s.coverArt(f, 0); err != nil && err != errNoArtwork {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mpdPlaying reports whether MPD is playing, so that background jobs may
+// wait until playback stops.  If MPD's status cannot be retrieved, it is
+// assumed not to be playing.
+func (s *Server) mpdPlaying() bool {
+	if s.player == nil {
+		return false
+	}
+
+	st, err := s.player.Status()
+	if err != nil {
+		return false
+	}
+
+	return st["state"] == "play"
+}
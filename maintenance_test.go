@@ -0,0 +1,76 @@
+package mpdsub
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_prewarmArtwork(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-prewarm")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := &memoryDatabase{
+		files: []string{"foo/foo.mp3", "bar/bar.mp3"},
+		songs: []mpd.Attrs{
+			{"file": "foo/foo.mp3"},
+			{"file": "bar/bar.mp3"},
+		},
+		pictures: map[string][]byte{
+			"foo/foo.mp3": []byte("\x89PNG\r\n\x1a\nfoo"),
+		},
+	}
+
+	cfg := &Config{
+		ArtworkCacheDirectory: dir,
+		PrewarmArtwork:        true,
+	}
+
+	withServer(t, db, nil, cfg, func(_ string) {
+		// Only the directory with artwork is cached
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			files, err := filepath.Glob(filepath.Join(dir, "*"+artworkCacheSuffix))
+			if err != nil {
+				t.Fatalf("failed to list cache directory: %v", err)
+			}
+			if len(files) == 1 {
+				return
+			}
+
+			if time.Now().After(deadline) {
+				t.Fatalf("unexpected number of cached files: %d", len(files))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestServer_evictCaches(t *testing.T) {
+	s := &Server{misses: newMissCache(time.Minute)}
+
+	past := time.Now().Add(-time.Hour)
+	s.misses.add("artwork", "foo", past)
+	if _, err := s.sessions.create(session{User: "test", Expires: past}, past); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if _, err := s.streamTokens.issue(streamToken{ID: "1", Expires: past}, past); err != nil {
+		t.Fatalf("failed to issue stream token: %v", err)
+	}
+
+	if err := s.evictCaches(context.Background()); err != nil {
+		t.Fatalf("failed to evict caches: %v", err)
+	}
+
+	if want, got := 0, len(s.misses.entries)+len(s.sessions.sessions)+len(s.streamTokens.tokens); want != got {
+		t.Fatalf("unexpected number of entries:\n- want: %v\n-  got: %v", want, got)
+	}
+}
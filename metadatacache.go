@@ -0,0 +1,114 @@
+package mpdsub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultMetadataCacheTTL is the default amount of time for which metadata
+// fetched from external providers is used before it is fetched again.
+const defaultMetadataCacheTTL = 7 * 24 * time.Hour
+
+// metadataCacheSuffix is the suffix of files written to a metadata cache, so
+// that other files in the directory are left alone.
+const metadataCacheSuffix = ".meta"
+
+// A metadataCache keeps responses from external metadata providers, such as
+// artist biographies from Last.fm, in files within a directory.  Responses
+// are used until they are older than the cache's TTL, so that providers are
+// not queried each time a client displays an artist, and are retained when
+// the Server restarts.  Expired responses remain available to stand in for
+// a provider which is unavailable.
+//
+// A nil *metadataCache caches nothing.
+type metadataCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// openMetadataCache opens the metadata cache in dir, creating dir if it does
+// not exist.  If dir is empty, nil is returned.  If ttl is 0,
+// defaultMetadataCacheTTL is used.
+func openMetadataCache(dir string, ttl time.Duration) (*metadataCache, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if ttl == 0 {
+		ttl = defaultMetadataCacheTTL
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &metadataCache{
+		dir: dir,
+		ttl: ttl,
+	}, nil
+}
+
+// get retrieves the response stored under key, and reports whether it was
+// stored within the cache's TTL of now.
+func (c *metadataCache) get(key string, now time.Time) (b []byte, fresh bool, ok bool) {
+	if c == nil {
+		return nil, false, false
+	}
+
+	p := c.path(key)
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, false, false
+	}
+
+	b, err = ioutil.ReadFile(p)
+	if err != nil {
+		return nil, false, false
+	}
+
+	return b, now.Sub(fi.ModTime()) < c.ttl, true
+}
+
+// add stores a response under key.
+func (c *metadataCache) add(key string, b []byte) error {
+	if c == nil {
+		return nil
+	}
+
+	return writeFileAtomic(c.path(key), b)
+}
+
+// evict removes responses which expired more than a TTL before now, and
+// are unlikely to be needed while a provider is unavailable.
+func (c *metadataCache) evict(now time.Time) {
+	if c == nil {
+		return
+	}
+
+	fis, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), metadataCacheSuffix) {
+			continue
+		}
+		if now.Sub(fi.ModTime()) < 2*c.ttl {
+			continue
+		}
+
+		_ = os.Remove(filepath.Join(c.dir, fi.Name()))
+	}
+}
+
+// path returns the path of the file which stores the response under key.
+func (c *metadataCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+metadataCacheSuffix)
+}
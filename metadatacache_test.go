@@ -0,0 +1,123 @@
+package mpdsub
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_metadataCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-metadata")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := openMetadataCache(filepath.Join(dir, "cache"), time.Hour)
+	if err != nil {
+		t.Fatalf("failed to open metadata cache: %v", err)
+	}
+
+	if _, _, ok := c.get("foo", time.Now()); ok {
+		t.Fatal("empty cache should not contain metadata")
+	}
+
+	if err := c.add("foo", []byte("bar")); err != nil {
+		t.Fatalf("failed to add metadata: %v", err)
+	}
+
+	now := time.Now()
+	b, fresh, ok := c.get("foo", now)
+	if !ok || !fresh || string(b) != "bar" {
+		t.Fatalf("unexpected fresh metadata: %q, %v, %v", b, fresh, ok)
+	}
+
+	// Expired metadata is still available
+	b, fresh, ok = c.get("foo", now.Add(2*time.Hour))
+	if !ok || fresh || string(b) != "bar" {
+		t.Fatalf("unexpected expired metadata: %q, %v, %v", b, fresh, ok)
+	}
+
+	c.evict(now.Add(90 * time.Minute))
+	if _, _, ok := c.get("foo", now); !ok {
+		t.Fatal("recently expired metadata should not be evicted")
+	}
+
+	c.evict(now.Add(3 * time.Hour))
+	if _, _, ok := c.get("foo", now); ok {
+		t.Fatal("long expired metadata should be evicted")
+	}
+}
+
+func Test_lastFMGetCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-metadata")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		calls int32
+		down  int32
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		if want, got := "key", r.URL.Query().Get("api_key"); want != got {
+			t.Errorf("unexpected API key:\n- want: %v\n-  got: %v", want, got)
+		}
+		fmt.Fprint(w, `{"artist":{"name":"Foo"}}`)
+	}))
+	defer srv.Close()
+
+	newClient := func(ttl time.Duration) *lastFM {
+		c, err := openMetadataCache(dir, ttl)
+		if err != nil {
+			t.Fatalf("failed to open metadata cache: %v", err)
+		}
+
+		l := newLastFM(LastFM{APIKey: "key"})
+		l.endpoint = srv.URL
+		l.cache = c
+		return l
+	}
+
+	v := func() url.Values {
+		return url.Values{"method": {"artist.getInfo"}, "artist": {"Foo"}}
+	}
+
+	// The cached response is reused by a new client, as after a restart
+	for i := 0; i < 2; i++ {
+		if _, err := newClient(time.Hour).get(context.Background(), v()); err != nil {
+			t.Fatalf("failed to get artist: %v", err)
+		}
+	}
+	if want, got := int32(1), atomic.LoadInt32(&calls); want != got {
+		t.Fatalf("unexpected number of calls:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Expired responses stand in for an unavailable provider
+	atomic.StoreInt32(&down, 1)
+	b, err := newClient(time.Nanosecond).get(context.Background(), v())
+	if err != nil {
+		t.Fatalf("failed to get expired artist: %v", err)
+	}
+	if want, got := `{"artist":{"name":"Foo"}}`, string(b); want != got {
+		t.Fatalf("unexpected response:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := int32(2), atomic.LoadInt32(&calls); want != got {
+		t.Fatalf("unexpected number of calls:\n- want: %v\n-  got: %v", want, got)
+	}
+}
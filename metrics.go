@@ -0,0 +1,343 @@
+package mpdsub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/trace"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the buckets of MPD
+// command latency histograms.
+var latencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// A histogram is a cumulative histogram of observed durations.
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// observe adds a duration to the histogram.
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	for i, b := range latencyBuckets {
+		if v <= b {
+			h.buckets[i]++
+		}
+	}
+
+	h.count++
+	h.sum += v
+}
+
+// metrics tracks latency histograms for each MPD command.
+type metrics struct {
+	mu       sync.Mutex
+	commands map[string]*histogram
+}
+
+// newMetrics creates an empty set of metrics.
+func newMetrics() *metrics {
+	return &metrics{
+		commands: make(map[string]*histogram),
+	}
+}
+
+// observe records the latency of a MPD command.
+func (m *metrics) observe(command string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.commands[command]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(latencyBuckets))}
+		m.commands[command] = h
+	}
+
+	h.observe(d)
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition format.
+func (m *metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	commands := make([]string, 0, len(m.commands))
+	for c := range m.commands {
+		commands = append(commands, c)
+	}
+	sort.Strings(commands)
+
+	const name = "mpdsub_mpd_command_duration_seconds"
+
+	cw := &countWriter{w: w}
+	fmt.Fprintf(cw, "# HELP %s Latency of commands sent to MPD.\n", name)
+	fmt.Fprintf(cw, "# TYPE %s histogram\n", name)
+
+	for _, c := range commands {
+		h := m.commands[c]
+		for i, b := range latencyBuckets {
+			fmt.Fprintf(cw, "%s_bucket{command=%q,le=\"%g\"} %d\n", name, c, b, h.buckets[i])
+		}
+		fmt.Fprintf(cw, "%s_bucket{command=%q,le=\"+Inf\"} %d\n", name, c, h.count)
+		fmt.Fprintf(cw, "%s_sum{command=%q} %g\n", name, c, h.sum)
+		fmt.Fprintf(cw, "%s_count{command=%q} %d\n", name, c, h.count)
+	}
+
+	return cw.n, cw.err
+}
+
+// A countWriter is an io.Writer which counts bytes written, and stops
+// writing after the first error.
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(b []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
+
+// MetricsHandler returns a http.Handler which serves metrics about the
+// Server, such as the latency of each MPD command, in the Prometheus text
+// exposition format.  The handler does not require Subsonic authentication,
+// and is typically served on a separate address from the Server.
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(contentType, "text/plain; version=0.0.4")
+		if _, err := s.metrics.WriteTo(w); err != nil {
+			s.logf("error writing metrics: %v", err)
+			return
+		}
+		if _, err := s.transcodes.WriteTo(w); err != nil {
+			s.logf("error writing metrics: %v", err)
+			return
+		}
+		if _, err := s.jobs.WriteTo(w); err != nil {
+			s.logf("error writing metrics: %v", err)
+		}
+	})
+}
+
+var _ database = &metricsDatabase{}
+
+// A metricsDatabase is a database which records the latency of each command
+// sent to another database, and annotates each command with a region for
+// use with runtime/trace.
+type metricsDatabase struct {
+	db database
+	m  *metrics
+}
+
+// observe begins timing a command.  The returned function must be called
+// when the command completes.
+func (d *metricsDatabase) observe(command string) func() {
+	return d.m.start(command)
+}
+
+// start begins timing a MPD command, and annotates it with a region for use
+// with runtime/trace.  The returned function must be called when the command
+// completes.
+func (m *metrics) start(command string) func() {
+	start := time.Now()
+	region := trace.StartRegion(context.Background(), "mpd."+command)
+
+	return func() {
+		region.End()
+		m.observe(command, time.Since(start))
+	}
+}
+
+func (d *metricsDatabase) AlbumArt(uri string) ([]byte, error) {
+	defer d.observe("albumart")()
+	return d.db.AlbumArt(uri)
+}
+
+func (d *metricsDatabase) Find(args ...string) ([]mpd.Attrs, error) {
+	defer d.observe("find")()
+	return d.db.Find(args...)
+}
+
+func (d *metricsDatabase) List(args ...string) ([]string, error) {
+	defer d.observe("list")()
+	return d.db.List(args...)
+}
+
+func (d *metricsDatabase) ListAllInfo(uri string) ([]mpd.Attrs, error) {
+	defer d.observe("listallinfo")()
+	return d.db.ListAllInfo(uri)
+}
+
+func (d *metricsDatabase) ListPlaylists() ([]mpd.Attrs, error) {
+	defer d.observe("listplaylists")()
+	return d.db.ListPlaylists()
+}
+
+func (d *metricsDatabase) PlaylistAdd(name string, uri string) error {
+	defer d.observe("playlist_add")()
+	return d.db.PlaylistAdd(name, uri)
+}
+
+func (d *metricsDatabase) PlaylistClear(name string) error {
+	defer d.observe("playlist_clear")()
+	return d.db.PlaylistClear(name)
+}
+
+func (d *metricsDatabase) PlaylistContents(name string) ([]mpd.Attrs, error) {
+	defer d.observe("listplaylistinfo")()
+	return d.db.PlaylistContents(name)
+}
+
+func (d *metricsDatabase) PlaylistDelete(name string, pos int) error {
+	defer d.observe("playlist_delete")()
+	return d.db.PlaylistDelete(name, pos)
+}
+
+func (d *metricsDatabase) PlaylistRemove(name string) error {
+	defer d.observe("playlist_remove")()
+	return d.db.PlaylistRemove(name)
+}
+
+func (d *metricsDatabase) PlaylistRename(name, newName string) error {
+	defer d.observe("playlist_rename")()
+	return d.db.PlaylistRename(name, newName)
+}
+
+func (d *metricsDatabase) ReadPicture(uri string) ([]byte, error) {
+	defer d.observe("readpicture")()
+	return d.db.ReadPicture(uri)
+}
+
+func (d *metricsDatabase) ReadComments(uri string) (mpd.Attrs, error) {
+	defer d.observe("readcomments")()
+	return d.db.ReadComments(uri)
+}
+
+func (d *metricsDatabase) Search(args ...string) ([]mpd.Attrs, error) {
+	defer d.observe("search")()
+	return d.db.Search(args...)
+}
+
+func (d *metricsDatabase) Stats() (mpd.Attrs, error) {
+	defer d.observe("stats")()
+	return d.db.Stats()
+}
+
+func (d *metricsDatabase) StickerDelete(uri string, name string) error {
+	defer d.observe("sticker_delete")()
+	return d.db.StickerDelete(uri, name)
+}
+
+func (d *metricsDatabase) StickerSet(uri string, name string, value string) error {
+	defer d.observe("sticker_set")()
+	return d.db.StickerSet(uri, name, value)
+}
+
+func (d *metricsDatabase) Update(uri string) (int, error) {
+	defer d.observe("update")()
+	return d.db.Update(uri)
+}
+
+func (d *metricsDatabase) Ping() error {
+	defer d.observe("ping")()
+	return d.db.Ping()
+}
+
+var _ player = &metricsPlayer{}
+
+// A metricsPlayer is a player which records the latency of each command
+// sent to another player, in the same way as a metricsDatabase.
+type metricsPlayer struct {
+	p player
+	m *metrics
+}
+
+func (p *metricsPlayer) AddID(uri string, pos int) (int, error) {
+	defer p.m.start("addid")()
+	return p.p.AddID(uri, pos)
+}
+
+func (p *metricsPlayer) Clear() error {
+	defer p.m.start("clear")()
+	return p.p.Clear()
+}
+
+func (p *metricsPlayer) CurrentSong() (mpd.Attrs, error) {
+	defer p.m.start("currentsong")()
+	return p.p.CurrentSong()
+}
+
+func (p *metricsPlayer) Delete(start, end int) error {
+	defer p.m.start("delete")()
+	return p.p.Delete(start, end)
+}
+
+func (p *metricsPlayer) DisableOutput(id int) error {
+	defer p.m.start("disableoutput")()
+	return p.p.DisableOutput(id)
+}
+
+func (p *metricsPlayer) EnableOutput(id int) error {
+	defer p.m.start("enableoutput")()
+	return p.p.EnableOutput(id)
+}
+
+func (p *metricsPlayer) ListOutputs() ([]mpd.Attrs, error) {
+	defer p.m.start("outputs")()
+	return p.p.ListOutputs()
+}
+
+func (p *metricsPlayer) Pause(pause bool) error {
+	defer p.m.start("pause")()
+	return p.p.Pause(pause)
+}
+
+func (p *metricsPlayer) Play(pos int) error {
+	defer p.m.start("play")()
+	return p.p.Play(pos)
+}
+
+func (p *metricsPlayer) PlayID(id int) error {
+	defer p.m.start("playid")()
+	return p.p.PlayID(id)
+}
+
+func (p *metricsPlayer) PlaylistInfo(start, end int) ([]mpd.Attrs, error) {
+	defer p.m.start("playlistinfo")()
+	return p.p.PlaylistInfo(start, end)
+}
+
+func (p *metricsPlayer) Seek(pos, time int) error {
+	defer p.m.start("seek")()
+	return p.p.Seek(pos, time)
+}
+
+func (p *metricsPlayer) SetVolume(volume int) error {
+	defer p.m.start("setvol")()
+	return p.p.SetVolume(volume)
+}
+
+func (p *metricsPlayer) Shuffle(start, end int) error {
+	defer p.m.start("shuffle")()
+	return p.p.Shuffle(start, end)
+}
+
+func (p *metricsPlayer) Status() (mpd.Attrs, error) {
+	defer p.m.start("status")()
+	return p.p.Status()
+}
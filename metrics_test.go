@@ -0,0 +1,81 @@
+package mpdsub
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_MetricsHandler(t *testing.T) {
+	cfg, _ := configAuth()
+	s, err := newServer(&memoryDatabase{files: []string{"foo.mp3"}}, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.db.List("file"); err != nil {
+			t.Fatalf("failed to list files: %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE mpdsub_mpd_command_duration_seconds histogram\n",
+		"mpdsub_mpd_command_duration_seconds_bucket{command=\"list\",le=\"+Inf\"} 2\n",
+		"mpdsub_mpd_command_duration_seconds_count{command=\"list\"} 2\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics do not contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestServer_MetricsHandlerPlayer(t *testing.T) {
+	cfg, _ := configAuth()
+	s, err := newServer(newMemoryPlayer(), nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer s.Close()
+
+	if _, err := s.player.ListOutputs(); err != nil {
+		t.Fatalf("failed to list outputs: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := "mpdsub_mpd_command_duration_seconds_count{command=\"outputs\"} 1\n"
+	if body := rec.Body.String(); !strings.Contains(body, want) {
+		t.Fatalf("metrics do not contain %q:\n%s", want, body)
+	}
+}
+
+func Test_metricsWriteTo(t *testing.T) {
+	m := newMetrics()
+	m.observe("stats", 3*time.Millisecond)
+	m.observe("stats", 2*time.Second)
+
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+
+	for _, want := range []string{
+		"mpdsub_mpd_command_duration_seconds_bucket{command=\"stats\",le=\"0.0025\"} 0\n",
+		"mpdsub_mpd_command_duration_seconds_bucket{command=\"stats\",le=\"0.005\"} 1\n",
+		"mpdsub_mpd_command_duration_seconds_bucket{command=\"stats\",le=\"2.5\"} 2\n",
+		"mpdsub_mpd_command_duration_seconds_count{command=\"stats\"} 2\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("metrics do not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package mpdsub
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultMissCacheTTL is the default amount of time for which a failed lookup,
+// such as for cover art which does not exist, is remembered.
+const defaultMissCacheTTL = 10 * time.Minute
+
+// A missCache remembers lookups which found nothing, such as cover art for
+// an album without any, so that clients which retry them, often on every
+// scroll through a list, do not repeatedly cause directory scans and tag
+// parsing.  Misses expire after a TTL, so that newly added items are found.
+//
+// A nil *missCache caches nothing.
+type missCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+// newMissCache creates a missCache which remembers misses for ttl.  If ttl
+// is 0, defaultMissCacheTTL is used.  If ttl is negative, nil is returned.
+func newMissCache(ttl time.Duration) *missCache {
+	if ttl < 0 {
+		return nil
+	}
+	if ttl == 0 {
+		ttl = defaultMissCacheTTL
+	}
+
+	return &missCache{
+		ttl:     ttl,
+		entries: make(map[string]time.Time),
+	}
+}
+
+// missed reports whether a lookup of kind, such as "artwork", for name missed
+// less than the TTL before now.
+func (c *missCache) missed(kind, name string, now time.Time) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := kind + "\x00" + name
+
+	expires, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	if !now.Before(expires) {
+		delete(c.entries, key)
+		return false
+	}
+
+	return true
+}
+
+// add records that a lookup of kind for name missed at now.  Expired misses
+// are removed as they are looked up, and periodically by evict.
+func (c *missCache) add(kind, name string, now time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[kind+"\x00"+name] = now.Add(c.ttl)
+}
+
+// evict removes misses which expired before now.
+func (c *missCache) evict(now time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, v := range c.entries {
+		if !now.Before(v) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// clear forgets all misses, such as after MPD's database is updated.
+func (c *missCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]time.Time)
+}
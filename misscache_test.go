@@ -0,0 +1,82 @@
+package mpdsub
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_missCache(t *testing.T) {
+	c := newMissCache(time.Minute)
+	now := time.Now()
+
+	if c.missed("artwork", "foo", now) {
+		t.Fatal("expected foo not to be missed before it was added")
+	}
+
+	c.add("artwork", "foo", now)
+	if !c.missed("artwork", "foo", now.Add(30*time.Second)) {
+		t.Fatal("expected foo to be missed within TTL")
+	}
+
+	// Kinds of lookups are cached separately
+	if c.missed("lyrics", "foo", now) {
+		t.Fatal("expected foo lyrics not to be missed")
+	}
+
+	if c.missed("artwork", "foo", now.Add(time.Minute)) {
+		t.Fatal("expected foo miss to expire after TTL")
+	}
+
+	// Expired misses remain until they are looked up or evicted
+	c.add("artwork", "bar", now)
+	c.add("artwork", "baz", now.Add(time.Minute))
+	if want, got := 2, len(c.entries); want != got {
+		t.Fatalf("unexpected number of misses:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	c.evict(now.Add(time.Minute))
+	if want, got := 1, len(c.entries); want != got {
+		t.Fatalf("unexpected number of misses after eviction:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	c.clear()
+	if c.missed("artwork", "bar", now) {
+		t.Fatal("expected bar miss to be cleared")
+	}
+
+	// A nil cache caches nothing
+	nc := newMissCache(-1)
+	nc.add("artwork", "foo", now)
+	if nc.missed("artwork", "foo", now) {
+		t.Fatal("expected nil cache to cache nothing")
+	}
+}
+
+// A countingArtwork is an artworkSource which never has artwork, and counts
+// lookups.
+type countingArtwork struct {
+	lookups int
+}
+
+func (a *countingArtwork) Artwork(name string, dir bool) ([]byte, error) {
+	a.lookups++
+	return nil, errNoArtwork
+}
+
+func TestServer_artworkMissCache(t *testing.T) {
+	src := &countingArtwork{}
+	s := &Server{
+		artworkSources: []artworkSource{src},
+		misses:         newMissCache(time.Minute),
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.artwork(indexedFile{Name: "foo"}); err != errNoArtwork {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errNoArtwork, err)
+		}
+	}
+
+	if want, got := 1, src.lookups; want != got {
+		t.Fatalf("unexpected number of lookups:\n- want: %v\n-  got: %v", want, got)
+	}
+}
@@ -0,0 +1,86 @@
+package mpdsub
+
+import (
+	"path"
+	"sync"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// A modTimeCache keeps the times at which the files and directories in MPD's
+// database were last modified, so that they need not be listed from MPD for
+// each request.  The times are listed again whenever MPD's database is
+// updated.
+type modTimeCache struct {
+	mu     sync.Mutex
+	update string
+	times  map[string]time.Time
+}
+
+// modTimes returns the times at which the files and directories in MPD's
+// database were last modified, keyed by name.  A directory was last modified
+// when it or the most recently modified song within it was.  The returned
+// map is shared, and must not be modified.
+func (s *Server) modTimes() (map[string]time.Time, error) {
+	stats, err := s.db.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &s.mtimes
+
+	// Concurrent requests wait for a single listing
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.times != nil && c.update == stats["db_update"] {
+		return c.times, nil
+	}
+
+	attrs, err := s.db.ListAllInfo("")
+	if err != nil {
+		return nil, err
+	}
+
+	c.times = newModTimes(attrs)
+	c.update = stats["db_update"]
+
+	return c.times, nil
+}
+
+// newModTimes builds the modification times of the files and directories
+// listed by MPD in attrs.
+func newModTimes(attrs []mpd.Attrs) map[string]time.Time {
+	out := make(map[string]time.Time)
+
+	// later records t for name if it is later than any known time
+	later := func(name string, t time.Time) {
+		if t.After(out[name]) {
+			out[name] = t
+		}
+	}
+
+	for _, a := range attrs {
+		t := lastModified(a)
+		if t.IsZero() {
+			continue
+		}
+
+		name := a["file"]
+		if name == "" {
+			// Directories may have changed without any songs changing,
+			// such as when cover art is added
+			if name = a["directory"]; name == "" {
+				continue
+			}
+		}
+
+		later(name, t)
+		for d := path.Dir(name); d != "."; d = path.Dir(d) {
+			later(d, t)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,69 @@
+package mpdsub
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func Test_newModTimes(t *testing.T) {
+	t1 := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	t3 := t2.Add(time.Hour)
+
+	got := newModTimes([]mpd.Attrs{
+		{"directory": "Artist", "Last-Modified": t1.Format(time.RFC3339)},
+		{"directory": "Artist/Album", "Last-Modified": t3.Format(time.RFC3339)},
+		{"file": "Artist/Album/a.mp3", "Last-Modified": t1.Format(time.RFC3339)},
+		{"file": "Artist/Album/b.mp3", "Last-Modified": t2.Format(time.RFC3339)},
+		{"file": "Artist/c.mp3"},
+	})
+
+	want := map[string]time.Time{
+		"Artist":             t3,
+		"Artist/Album":       t3,
+		"Artist/Album/a.mp3": t1,
+		"Artist/Album/b.mp3": t2,
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected modification times:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestServer_modTimes(t *testing.T) {
+	t1 := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+
+	db := &memoryDatabase{
+		dbUpdate: "1",
+		songs: []mpd.Attrs{
+			{"file": "a.mp3", "Last-Modified": t1.Format(time.RFC3339)},
+		},
+	}
+
+	s := &Server{db: db}
+
+	mustTime := func(want time.Time) {
+		t.Helper()
+
+		mtimes, err := s.modTimes()
+		if err != nil {
+			t.Fatalf("failed to retrieve modification times: %v", err)
+		}
+		if got := mtimes["a.mp3"]; !want.Equal(got) {
+			t.Fatalf("unexpected modification time:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+
+	mustTime(t1)
+
+	// Times are only listed again when MPD's database is updated
+	db.songs[0]["Last-Modified"] = t2.Format(time.RFC3339)
+	mustTime(t1)
+
+	db.dbUpdate = "2"
+	mustTime(t2)
+}
@@ -14,11 +14,49 @@ var _ database = &mpd.Client{}
 // A database is a type which can return data in the same format as MPD
 // database queries.  database is implemented by *mpd.Client.
 type database interface {
+	AlbumArt(uri string) ([]byte, error)
+	Find(args ...string) ([]mpd.Attrs, error)
 	List(args ...string) ([]string, error)
+	ListAllInfo(uri string) ([]mpd.Attrs, error)
+	ListPlaylists() ([]mpd.Attrs, error)
+	PlaylistAdd(name string, uri string) error
+	PlaylistClear(name string) error
+	PlaylistContents(name string) ([]mpd.Attrs, error)
+	PlaylistDelete(name string, pos int) error
+	PlaylistRemove(name string) error
+	PlaylistRename(name, newName string) error
+	ReadPicture(uri string) ([]byte, error)
 	ReadComments(uri string) (mpd.Attrs, error)
+	Search(args ...string) ([]mpd.Attrs, error)
+	Stats() (mpd.Attrs, error)
+	StickerDelete(uri string, name string) error
+	StickerSet(uri string, name string, value string) error
+	Update(uri string) (int, error)
 	Ping() error
 }
 
+var _ player = &mpd.Client{}
+
+// A player is a type which can control MPD's audio outputs, volume, queue,
+// and playback.  player is implemented by *mpd.Client.
+type player interface {
+	AddID(uri string, pos int) (int, error)
+	Clear() error
+	CurrentSong() (mpd.Attrs, error)
+	Delete(start, end int) error
+	DisableOutput(id int) error
+	EnableOutput(id int) error
+	ListOutputs() ([]mpd.Attrs, error)
+	Pause(pause bool) error
+	Play(pos int) error
+	PlayID(id int) error
+	PlaylistInfo(start, end int) ([]mpd.Attrs, error)
+	Seek(pos, time int) error
+	SetVolume(volume int) error
+	Shuffle(start, end int) error
+	Status() (mpd.Attrs, error)
+}
+
 // A filesystem is a type which can open a file.  filesystem is implemented
 // by *osFilesystem.
 type filesystem interface {
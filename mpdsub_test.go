@@ -2,6 +2,7 @@ package mpdsub
 
 import (
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,6 +11,8 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -38,7 +41,12 @@ func withServer(t *testing.T, db database, fs filesystem, cfg *Config, fn func(b
 	}
 	cfg.Logger = log.New(ioutil.Discard, "", 0)
 
-	s := httptest.NewServer(newServer(db, fs, cfg))
+	srv, err := newServer(db, fs, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	s := httptest.NewServer(srv)
 	defer s.Close()
 
 	fn(s.URL)
@@ -123,13 +131,41 @@ var _ database = &memoryDatabase{}
 
 // A memoryDatabase is an in-memory implementation of database.
 type memoryDatabase struct {
-	files []string
-	attrs map[string]mpd.Attrs
-	pingC chan<- struct{}
+	files     []string
+	attrs     map[string]mpd.Attrs
+	songs     []mpd.Attrs
+	searches  map[string][]mpd.Attrs
+	finds     map[string][]mpd.Attrs
+	playlists map[string][]mpd.Attrs
+	albumArt  map[string][]byte
+	pictures  map[string][]byte
+	stickers  map[string]map[string]string
+	dbUpdate  string
+	pingC     chan<- struct{}
+	updateC   chan<- string
 
 	mu sync.RWMutex
 }
 
+func (db *memoryDatabase) AlbumArt(uri string) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if b, ok := db.albumArt[uri]; ok {
+		return b, nil
+	}
+
+	return nil, fmt.Errorf("no album art for URI: %q", uri)
+}
+
+func (db *memoryDatabase) Find(args ...string) ([]mpd.Attrs, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// Finds are keyed by their space-separated arguments
+	return db.finds[strings.Join(args, " ")], nil
+}
+
 func (db *memoryDatabase) List(args ...string) ([]string, error) {
 	if len(args) != 1 || args[0] != "file" {
 		panic(fmt.Sprintf("memoryDatabase.List expects argument file, got: %v", args))
@@ -141,11 +177,186 @@ func (db *memoryDatabase) List(args ...string) ([]string, error) {
 	return db.files, nil
 }
 
+func (db *memoryDatabase) ListAllInfo(uri string) ([]mpd.Attrs, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var out []mpd.Attrs
+	for _, s := range db.songs {
+		if strings.HasPrefix(s["file"], uri) {
+			out = append(out, s)
+		}
+	}
+
+	return out, nil
+}
+
+func (db *memoryDatabase) ListPlaylists() ([]mpd.Attrs, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	names := make([]string, 0, len(db.playlists))
+	for name := range db.playlists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]mpd.Attrs, 0, len(names))
+	for _, name := range names {
+		out = append(out, mpd.Attrs{"playlist": name})
+	}
+
+	return out, nil
+}
+
+func (db *memoryDatabase) PlaylistAdd(name string, uri string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	a := mpd.Attrs{"file": uri}
+	for _, s := range db.songs {
+		if s["file"] == uri {
+			a = s
+			break
+		}
+	}
+
+	if db.playlists == nil {
+		db.playlists = make(map[string][]mpd.Attrs)
+	}
+	db.playlists[name] = append(db.playlists[name], a)
+	return nil
+}
+
+func (db *memoryDatabase) PlaylistClear(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Like MPD, clearing a playlist which does not exist creates it
+	if db.playlists == nil {
+		db.playlists = make(map[string][]mpd.Attrs)
+	}
+	db.playlists[name] = []mpd.Attrs{}
+	return nil
+}
+
+func (db *memoryDatabase) PlaylistContents(name string) ([]mpd.Attrs, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	songs, ok := db.playlists[name]
+	if !ok {
+		return nil, fmt.Errorf("no such playlist: %q", name)
+	}
+
+	return songs, nil
+}
+
+func (db *memoryDatabase) PlaylistDelete(name string, pos int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	songs, ok := db.playlists[name]
+	if !ok || pos < 0 || pos >= len(songs) {
+		return fmt.Errorf("bad playlist position %d in %q", pos, name)
+	}
+
+	out := make([]mpd.Attrs, 0, len(songs)-1)
+	out = append(out, songs[:pos]...)
+	db.playlists[name] = append(out, songs[pos+1:]...)
+	return nil
+}
+
+func (db *memoryDatabase) PlaylistRemove(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.playlists[name]; !ok {
+		return fmt.Errorf("no such playlist: %q", name)
+	}
+
+	delete(db.playlists, name)
+	return nil
+}
+
+func (db *memoryDatabase) PlaylistRename(name, newName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	songs, ok := db.playlists[name]
+	if !ok {
+		return fmt.Errorf("no such playlist: %q", name)
+	}
+	if _, ok := db.playlists[newName]; ok {
+		return fmt.Errorf("playlist already exists: %q", newName)
+	}
+
+	delete(db.playlists, name)
+	db.playlists[newName] = songs
+	return nil
+}
+
+func (db *memoryDatabase) Search(args ...string) ([]mpd.Attrs, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// Searches are keyed by their space-separated arguments
+	return db.searches[strings.Join(args, " ")], nil
+}
+
+func (db *memoryDatabase) Stats() (mpd.Attrs, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return mpd.Attrs{"db_update": db.dbUpdate}, nil
+}
+
+func (db *memoryDatabase) StickerDelete(uri string, name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Like MPD, deleting a sticker which does not exist is an error
+	if _, ok := db.stickers[uri][name]; !ok {
+		return errors.New("no such sticker")
+	}
+
+	delete(db.stickers[uri], name)
+	return nil
+}
+
+func (db *memoryDatabase) StickerSet(uri string, name string, value string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.stickers == nil {
+		db.stickers = make(map[string]map[string]string)
+	}
+	if db.stickers[uri] == nil {
+		db.stickers[uri] = make(map[string]string)
+	}
+
+	db.stickers[uri][name] = value
+	return nil
+}
+
+func (db *memoryDatabase) Update(uri string) (int, error) {
+	db.updateC <- uri
+	return 1, nil
+}
+
 func (db *memoryDatabase) Ping() error {
 	db.pingC <- struct{}{}
 	return nil
 }
 
+func (db *memoryDatabase) ReadPicture(uri string) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// Like MPD, return no data and no error for files without a picture
+	return db.pictures[uri], nil
+}
+
 func (db *memoryDatabase) ReadComments(uri string) (mpd.Attrs, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
@@ -180,19 +391,31 @@ func (fs *memoryFilesystem) Open(name string) (file, error) {
 // A memoryFile is an in-memory file used by memoryFilesystem.
 type memoryFile struct {
 	io.ReadSeeker
+
+	// modTime optionally specifies the file's modification time.  If zero,
+	// the current time is used.
+	modTime time.Time
 }
 
 func (f *memoryFile) Close() error               { return nil }
-func (f *memoryFile) Stat() (os.FileInfo, error) { return &memoryFileInfo{}, nil }
+func (f *memoryFile) Stat() (os.FileInfo, error) { return &memoryFileInfo{modTime: f.modTime}, nil }
 
 var _ os.FileInfo = &memoryFileInfo{}
 
 // A memoryFileInfo is an os.FileInfo used by memoryFiles.
-type memoryFileInfo struct{}
-
-func (fi *memoryFileInfo) Name() string       { return "" }
-func (fi *memoryFileInfo) Size() int64        { return 0 }
-func (fi *memoryFileInfo) Mode() os.FileMode  { return 0 }
-func (fi *memoryFileInfo) ModTime() time.Time { return time.Now() }
-func (fi *memoryFileInfo) IsDir() bool        { return false }
-func (fi *memoryFileInfo) Sys() interface{}   { return nil }
+type memoryFileInfo struct {
+	modTime time.Time
+}
+
+func (fi *memoryFileInfo) Name() string      { return "" }
+func (fi *memoryFileInfo) Size() int64       { return 0 }
+func (fi *memoryFileInfo) Mode() os.FileMode { return 0 }
+func (fi *memoryFileInfo) ModTime() time.Time {
+	if fi.modTime.IsZero() {
+		return time.Now()
+	}
+
+	return fi.modTime
+}
+func (fi *memoryFileInfo) IsDir() bool      { return false }
+func (fi *memoryFileInfo) Sys() interface{} { return nil }
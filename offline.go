@@ -0,0 +1,255 @@
+package mpdsub
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// degradedHeader is the HTTP header set on responses while the Server is
+// serving cached data because MPD is unavailable.
+const degradedHeader = "X-Mpdsub-Degraded"
+
+// maxOfflineCacheEntries is the maximum number of results kept by an
+// offlineDatabase, beyond which the least recently used are evicted.
+const maxOfflineCacheEntries = 4096
+
+var _ database = &offlineDatabase{}
+
+// An offlineDatabase is a database which caches the most recent result of
+// recently used read-only commands sent to another database.  If MPD cannot
+// be reached, the cached result is returned instead, and the database is
+// marked as degraded until MPD responds again.  Errors returned by MPD
+// itself, such as for a file without comments, are returned as usual.
+//
+// Commands which modify MPD's state, such as update, are never cached, so
+// the Server is effectively read-only while degraded.  Artwork is not cached
+// either, since it is large and kept by the artwork cache.
+type offlineDatabase struct {
+	db  database
+	max int
+
+	mu       sync.RWMutex
+	order    *list.List
+	entries  map[string]*list.Element
+	degraded bool
+}
+
+// An offlineEntry is a result stored by an offlineDatabase.
+type offlineEntry struct {
+	key string
+	v   interface{}
+}
+
+// newOfflineDatabase wraps db with an offlineDatabase.
+func newOfflineDatabase(db database) *offlineDatabase {
+	return &offlineDatabase{
+		db:      db,
+		max:     maxOfflineCacheEntries,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Degraded reports whether the database is currently serving cached results.
+func (d *offlineDatabase) Degraded() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.degraded
+}
+
+// do invokes fn, caching its result under key.  If MPD cannot be reached and
+// a result is cached, the cached result is returned.
+func (d *offlineDatabase) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	v, err := fn()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.degraded = err != nil && isUnreachable(err)
+
+	switch {
+	case err == nil:
+		d.add(key, v)
+		return v, nil
+	case d.degraded:
+		if e, ok := d.entries[key]; ok {
+			d.order.MoveToFront(e)
+			return e.Value.(*offlineEntry).v, nil
+		}
+	}
+
+	return nil, err
+}
+
+// add stores v under key, evicting the least recently used results as
+// needed.  d.mu must be held.
+func (d *offlineDatabase) add(key string, v interface{}) {
+	if e, ok := d.entries[key]; ok {
+		e.Value.(*offlineEntry).v = v
+		d.order.MoveToFront(e)
+		return
+	}
+
+	d.entries[key] = d.order.PushFront(&offlineEntry{key: key, v: v})
+
+	for d.order.Len() > d.max {
+		e := d.order.Back()
+		d.order.Remove(e)
+		delete(d.entries, e.Value.(*offlineEntry).key)
+	}
+}
+
+// cacheKey creates a cache key for a command and its arguments.
+func cacheKey(command string, args ...string) string {
+	return command + "\x00" + strings.Join(args, "\x00")
+}
+
+// AlbumArt is never cached, so artwork is unavailable while MPD is
+// unavailable, unless it is kept by the artwork cache.
+func (d *offlineDatabase) AlbumArt(uri string) ([]byte, error) {
+	b, err := d.db.AlbumArt(uri)
+	d.setDegraded(err)
+	return b, err
+}
+
+func (d *offlineDatabase) Find(args ...string) ([]mpd.Attrs, error) {
+	return d.attrsList(cacheKey("find", args...), func() ([]mpd.Attrs, error) { return d.db.Find(args...) })
+}
+
+func (d *offlineDatabase) List(args ...string) ([]string, error) {
+	v, err := d.do(cacheKey("list", args...), func() (interface{}, error) { return d.db.List(args...) })
+	ss, _ := v.([]string)
+	return ss, err
+}
+
+func (d *offlineDatabase) ListAllInfo(uri string) ([]mpd.Attrs, error) {
+	return d.attrsList(cacheKey("listallinfo", uri), func() ([]mpd.Attrs, error) { return d.db.ListAllInfo(uri) })
+}
+
+func (d *offlineDatabase) ListPlaylists() ([]mpd.Attrs, error) {
+	return d.attrsList(cacheKey("listplaylists"), d.db.ListPlaylists)
+}
+
+// PlaylistAdd is never cached, so playlists cannot be modified while MPD is
+// unavailable.
+func (d *offlineDatabase) PlaylistAdd(name string, uri string) error {
+	err := d.db.PlaylistAdd(name, uri)
+	d.setDegraded(err)
+	return err
+}
+
+// PlaylistClear is never cached, so playlists cannot be modified while MPD
+// is unavailable.
+func (d *offlineDatabase) PlaylistClear(name string) error {
+	err := d.db.PlaylistClear(name)
+	d.setDegraded(err)
+	return err
+}
+
+func (d *offlineDatabase) PlaylistContents(name string) ([]mpd.Attrs, error) {
+	return d.attrsList(cacheKey("listplaylistinfo", name), func() ([]mpd.Attrs, error) { return d.db.PlaylistContents(name) })
+}
+
+// PlaylistDelete is never cached, so playlists cannot be modified while MPD
+// is unavailable.
+func (d *offlineDatabase) PlaylistDelete(name string, pos int) error {
+	err := d.db.PlaylistDelete(name, pos)
+	d.setDegraded(err)
+	return err
+}
+
+// PlaylistRemove is never cached, so playlists cannot be removed while MPD
+// is unavailable.
+func (d *offlineDatabase) PlaylistRemove(name string) error {
+	err := d.db.PlaylistRemove(name)
+	d.setDegraded(err)
+	return err
+}
+
+// PlaylistRename is never cached, so playlists cannot be renamed while MPD
+// is unavailable.
+func (d *offlineDatabase) PlaylistRename(name, newName string) error {
+	err := d.db.PlaylistRename(name, newName)
+	d.setDegraded(err)
+	return err
+}
+
+// ReadPicture is never cached, so artwork is unavailable while MPD is
+// unavailable, unless it is kept by the artwork cache.
+func (d *offlineDatabase) ReadPicture(uri string) ([]byte, error) {
+	b, err := d.db.ReadPicture(uri)
+	d.setDegraded(err)
+	return b, err
+}
+
+func (d *offlineDatabase) ReadComments(uri string) (mpd.Attrs, error) {
+	v, err := d.do(cacheKey("readcomments", uri), func() (interface{}, error) { return d.db.ReadComments(uri) })
+	attrs, _ := v.(mpd.Attrs)
+	return attrs, err
+}
+
+func (d *offlineDatabase) Search(args ...string) ([]mpd.Attrs, error) {
+	return d.attrsList(cacheKey("search", args...), func() ([]mpd.Attrs, error) { return d.db.Search(args...) })
+}
+
+func (d *offlineDatabase) Stats() (mpd.Attrs, error) {
+	v, err := d.do(cacheKey("stats"), func() (interface{}, error) { return d.db.Stats() })
+	attrs, _ := v.(mpd.Attrs)
+	return attrs, err
+}
+
+// StickerDelete is never cached, so stickers cannot be modified while MPD is
+// unavailable.
+func (d *offlineDatabase) StickerDelete(uri string, name string) error {
+	err := d.db.StickerDelete(uri, name)
+	d.setDegraded(err)
+	return err
+}
+
+// StickerSet is never cached, so stickers cannot be modified while MPD is
+// unavailable.
+func (d *offlineDatabase) StickerSet(uri string, name string, value string) error {
+	err := d.db.StickerSet(uri, name, value)
+	d.setDegraded(err)
+	return err
+}
+
+// Update is never cached, so updates fail while MPD is unavailable.
+func (d *offlineDatabase) Update(uri string) (int, error) {
+	id, err := d.db.Update(uri)
+	d.setDegraded(err)
+	return id, err
+}
+
+// Ping is never cached, so keepalive messages detect when MPD is available.
+func (d *offlineDatabase) Ping() error {
+	err := d.db.Ping()
+	d.setDegraded(err)
+	return err
+}
+
+// setDegraded sets whether the database is degraded, according to whether
+// err indicates that MPD could not be reached.
+func (d *offlineDatabase) setDegraded(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.degraded = err != nil && isUnreachable(err)
+}
+
+// attrsList invokes a database command which returns a list of attributes.
+func (d *offlineDatabase) attrsList(key string, fn func() ([]mpd.Attrs, error)) ([]mpd.Attrs, error) {
+	v, err := d.do(key, func() (interface{}, error) { return fn() })
+	attrs, _ := v.([]mpd.Attrs)
+	return attrs, err
+}
+
+// degraded reports whether the Server is serving cached data because MPD
+// is unavailable.
+func (s *Server) degraded() bool {
+	return s.offline != nil && s.offline.Degraded()
+}
@@ -0,0 +1,190 @@
+package mpdsub
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_offlineCache(t *testing.T) {
+	db := &flakyDatabase{
+		memoryDatabase: &memoryDatabase{
+			files: []string{"foo/bar.mp3"},
+			attrs: map[string]mpd.Attrs{
+				"foo/bar.mp3": {"TITLE": "bar"},
+			},
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.OfflineCache = true
+	values.Set("id", "0")
+
+	withServer(t, db, nil, cfg, func(base string) {
+		getDir := func() (*http.Response, container) {
+			res := testRequest(t, base, http.MethodGet, "/rest/getMusicDirectory.view", values)
+			return res, mustDecodeXML(t, res)
+		}
+		ping := func() container {
+			return mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/ping.view", values))
+		}
+
+		// Populate the cache while MPD is available
+		if _, c := getDir(); c.Error != nil {
+			t.Fatalf("unexpected error: %v", c.Error.Message)
+		}
+
+		db.setDown(true)
+
+		res, c := getDir()
+		if c.Error != nil {
+			t.Fatalf("unexpected error while MPD is down: %v", c.Error.Message)
+		}
+		if want, got := 1, len(c.MusicDirectory.Children); want != got {
+			t.Fatalf("unexpected number of children:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		// The first request to fail marks the server as degraded, so the
+		// degraded indicators appear from the next request
+		if !ping().Degraded {
+			t.Fatal("ping does not report degraded mode")
+		}
+		if res, _ = getDir(); res.Header.Get(degradedHeader) != "true" {
+			t.Fatal("response does not carry degraded header")
+		}
+
+		db.setDown(false)
+		getDir()
+
+		if ping().Degraded {
+			t.Fatal("ping reports degraded mode after MPD is available")
+		}
+	})
+}
+
+func Test_offlineDatabaseErrors(t *testing.T) {
+	db := &flakyDatabase{
+		memoryDatabase: &memoryDatabase{files: []string{"foo.mp3"}},
+	}
+	d := newOfflineDatabase(db)
+
+	if _, err := d.List("file"); err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+
+	// Errors returned by MPD itself do not indicate that MPD is
+	// unavailable, so no cached result is used
+	db.setDown(true)
+	db.err = errors.New("ACK [50@0] {list} failed")
+	if _, err := d.List("file"); err != db.err {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", db.err, err)
+	}
+	if d.Degraded() {
+		t.Fatal("database degraded after MPD error")
+	}
+
+	db.err = nil
+	if _, err := d.List("file"); err != nil {
+		t.Fatalf("unexpected error while MPD is down: %v", err)
+	}
+	if !d.Degraded() {
+		t.Fatal("database not degraded while MPD is down")
+	}
+}
+
+func Test_offlineDatabaseEviction(t *testing.T) {
+	db := &flakyDatabase{
+		memoryDatabase: &memoryDatabase{files: []string{"foo.mp3"}},
+	}
+	d := newOfflineDatabase(db)
+	d.max = 2
+
+	for _, uri := range []string{"a", "b", "c"} {
+		if _, err := d.ListAllInfo(uri); err != nil {
+			t.Fatalf("failed to list %q: %v", uri, err)
+		}
+	}
+
+	// Only the most recently used results are kept
+	db.setDown(true)
+	if _, err := d.ListAllInfo("a"); err == nil {
+		t.Fatal("expected evicted result to be unavailable")
+	}
+	for _, uri := range []string{"b", "c"} {
+		if _, err := d.ListAllInfo(uri); err != nil {
+			t.Fatalf("unexpected error for cached %q: %v", uri, err)
+		}
+	}
+}
+
+// A flakyDatabase is a memoryDatabase which can simulate MPD being
+// unavailable, or failing commands with err if set.
+type flakyDatabase struct {
+	*memoryDatabase
+
+	mu   sync.Mutex
+	down bool
+	err  error
+}
+
+// errDown is returned by a flakyDatabase which is unavailable.
+var errDown = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+func (db *flakyDatabase) setDown(down bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.down = down
+}
+
+// downErr returns the error returned by commands while the database is down.
+func (db *flakyDatabase) downErr() error {
+	if db.err != nil {
+		return db.err
+	}
+
+	return errDown
+}
+
+func (db *flakyDatabase) isDown() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.down
+}
+
+func (db *flakyDatabase) List(args ...string) ([]string, error) {
+	if db.isDown() {
+		return nil, db.downErr()
+	}
+
+	return db.memoryDatabase.List(args...)
+}
+
+func (db *flakyDatabase) ReadComments(uri string) (mpd.Attrs, error) {
+	if db.isDown() {
+		return nil, db.downErr()
+	}
+
+	return db.memoryDatabase.ReadComments(uri)
+}
+
+func (db *flakyDatabase) ListAllInfo(uri string) ([]mpd.Attrs, error) {
+	if db.isDown() {
+		return nil, db.downErr()
+	}
+
+	return db.memoryDatabase.ListAllInfo(uri)
+}
+
+func (db *flakyDatabase) Stats() (mpd.Attrs, error) {
+	if db.isDown() {
+		return nil, db.downErr()
+	}
+
+	return db.memoryDatabase.Stats()
+}
@@ -0,0 +1,136 @@
+package mpdsub
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Actions supported by the outputControl endpoint.
+const (
+	outputActionStatus    = "status"
+	outputActionEnable    = "enable"
+	outputActionDisable   = "disable"
+	outputActionSetVolume = "setVolume"
+)
+
+// outputControl is a custom endpoint used to list MPD's audio outputs,
+// enable or disable them, and set MPD's volume.  This enables users who
+// control playback on MPD's speakers to choose which outputs play audio.
+//
+// The action parameter mirrors jukeboxControl: each request performs at most
+// one action, and the status of all outputs is returned afterward.
+func (s *Server) outputControl(w http.ResponseWriter, r *http.Request) {
+	if s.player == nil {
+		s.logf("output control is not supported by the backing database")
+		writeXML(w, errGeneric)
+		return
+	}
+
+	q := r.URL.Query()
+
+	action := q.Get("action")
+	if action == "" {
+		action = outputActionStatus
+	}
+
+	// Outputs may be listed, but not changed, by a read-only Server
+	if action != outputActionStatus && s.cfg.ReadOnly {
+		writeXML(w, errReadOnly)
+		return
+	}
+
+	var err error
+	switch action {
+	case outputActionStatus:
+	case outputActionEnable, outputActionDisable:
+		qID := q.Get("id")
+		if qID == "" {
+			writeXML(w, errMissingParameter)
+			return
+		}
+
+		id, perr := strconv.Atoi(qID)
+		if perr != nil {
+			writeXML(w, errGeneric)
+			return
+		}
+
+		if action == outputActionEnable {
+			err = s.player.EnableOutput(id)
+		} else {
+			err = s.player.DisableOutput(id)
+		}
+	case outputActionSetVolume:
+		qVolume := q.Get("volume")
+		if qVolume == "" {
+			writeXML(w, errMissingParameter)
+			return
+		}
+
+		volume, perr := strconv.Atoi(qVolume)
+		if perr != nil || volume < 0 || volume > 100 {
+			writeXML(w, errGeneric)
+			return
+		}
+
+		err = s.player.SetVolume(volume)
+	default:
+		writeXML(w, errGeneric)
+		return
+	}
+	if err != nil {
+		s.logf("error performing output control action %q: %v", action, err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	oc, err := s.outputs()
+	if err != nil {
+		s.logf("error retrieving outputs from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	writeXML(w, func(c *container) {
+		c.Outputs = oc
+	})
+}
+
+// outputs retrieves the status of MPD's audio outputs and volume.
+func (s *Server) outputs() (*outputsContainer, error) {
+	status, err := s.player.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := s.player.ListOutputs()
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([]output, 0, len(attrs))
+	for _, a := range attrs {
+		id, err := strconv.Atoi(a["outputid"])
+		if err != nil {
+			continue
+		}
+
+		outputs = append(outputs, output{
+			ID:      id,
+			Name:    a["outputname"],
+			Plugin:  a["plugin"],
+			Enabled: a["outputenabled"] == "1",
+		})
+	}
+
+	// MPD reports volume -1 when no mixer is available
+	volume, err := strconv.Atoi(status["volume"])
+	if err != nil {
+		volume = -1
+	}
+
+	return &outputsContainer{
+		Volume:  volume,
+		Outputs: outputs,
+	}, nil
+}
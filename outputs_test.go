@@ -0,0 +1,349 @@
+package mpdsub
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_outputControl(t *testing.T) {
+	tests := []struct {
+		name   string
+		db     database
+		values map[string]string
+		oc     *outputsContainer
+		err    bool
+		code   int
+	}{
+		{
+			name: "not supported",
+			db:   &memoryDatabase{},
+			err:  true,
+			code: codeGeneric,
+		},
+		{
+			name: "status",
+			db:   newMemoryPlayer(),
+			oc: &outputsContainer{
+				Volume: 50,
+				Outputs: []output{
+					{ID: 0, Name: "Living Room", Plugin: "alsa", Enabled: true},
+					{ID: 1, Name: "Kitchen", Plugin: "pulse"},
+				},
+			},
+		},
+		{
+			name: "enable",
+			db:   newMemoryPlayer(),
+			values: map[string]string{
+				"action": "enable",
+				"id":     "1",
+			},
+			oc: &outputsContainer{
+				Volume: 50,
+				Outputs: []output{
+					{ID: 0, Name: "Living Room", Plugin: "alsa", Enabled: true},
+					{ID: 1, Name: "Kitchen", Plugin: "pulse", Enabled: true},
+				},
+			},
+		},
+		{
+			name: "disable",
+			db:   newMemoryPlayer(),
+			values: map[string]string{
+				"action": "disable",
+				"id":     "0",
+			},
+			oc: &outputsContainer{
+				Volume: 50,
+				Outputs: []output{
+					{ID: 0, Name: "Living Room", Plugin: "alsa"},
+					{ID: 1, Name: "Kitchen", Plugin: "pulse"},
+				},
+			},
+		},
+		{
+			name: "disable missing ID",
+			db:   newMemoryPlayer(),
+			values: map[string]string{
+				"action": "disable",
+			},
+			err:  true,
+			code: codeMissingParameter,
+		},
+		{
+			name: "set volume",
+			db:   newMemoryPlayer(),
+			values: map[string]string{
+				"action": "setVolume",
+				"volume": "80",
+			},
+			oc: &outputsContainer{
+				Volume: 80,
+				Outputs: []output{
+					{ID: 0, Name: "Living Room", Plugin: "alsa", Enabled: true},
+					{ID: 1, Name: "Kitchen", Plugin: "pulse"},
+				},
+			},
+		},
+		{
+			name: "set volume out of range",
+			db:   newMemoryPlayer(),
+			values: map[string]string{
+				"action": "setVolume",
+				"volume": "101",
+			},
+			err:  true,
+			code: codeGeneric,
+		},
+		{
+			name: "unknown action",
+			db:   newMemoryPlayer(),
+			values: map[string]string{
+				"action": "explode",
+			},
+			err:  true,
+			code: codeGeneric,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			for k, v := range tt.values {
+				values.Set(k, v)
+			}
+
+			withServer(t, tt.db, nil, cfg, func(base string) {
+				res := testRequest(t, base, http.MethodGet, "/rest/outputControl.view", values)
+				c := mustDecodeXML(t, res)
+
+				if tt.err {
+					if c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+
+					if want, got := tt.code, c.Error.Code; want != got {
+						t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v",
+							want, got)
+					}
+
+					return
+				}
+
+				if c.Error != nil {
+					t.Fatalf("unexpected error: %v", c.Error.Message)
+				}
+
+				c.Outputs.XMLName = xml.Name{}
+				if want, got := tt.oc, c.Outputs; !reflect.DeepEqual(want, got) {
+					t.Fatalf("unexpected outputs:\n- want: %v\n-  got: %v",
+						want, got)
+				}
+			})
+		})
+	}
+}
+
+var _ player = &memoryPlayer{}
+
+// A memoryPlayer is an in-memory implementation of database and player.
+type memoryPlayer struct {
+	*memoryDatabase
+
+	outputs []mpd.Attrs
+	volume  int
+
+	queue   []string
+	song    int
+	playing int
+	paused  bool
+	elapsed int
+}
+
+// newMemoryPlayer creates a memoryPlayer with two outputs, one of which
+// is enabled.
+func newMemoryPlayer() *memoryPlayer {
+	return &memoryPlayer{
+		memoryDatabase: &memoryDatabase{},
+		outputs: []mpd.Attrs{
+			{"outputid": "0", "outputname": "Living Room", "plugin": "alsa", "outputenabled": "1"},
+			{"outputid": "1", "outputname": "Kitchen", "plugin": "pulse", "outputenabled": "0"},
+		},
+		volume:  50,
+		song:    -1,
+		playing: -1,
+	}
+}
+
+func (p *memoryPlayer) AddID(uri string, pos int) (int, error) {
+	if pos < 0 || pos > len(p.queue) {
+		pos = len(p.queue)
+	}
+
+	p.queue = append(p.queue, "")
+	copy(p.queue[pos+1:], p.queue[pos:])
+	p.queue[pos] = uri
+
+	// Song IDs are the positions at which songs were added, which is
+	// sufficient for tests
+	return pos, nil
+}
+
+func (p *memoryPlayer) Clear() error {
+	p.queue = nil
+	p.song, p.playing = -1, -1
+	return nil
+}
+
+func (p *memoryPlayer) CurrentSong() (mpd.Attrs, error) {
+	if p.playing < 0 || p.playing >= len(p.queue) {
+		return mpd.Attrs{}, nil
+	}
+
+	return p.songAttrs(p.queue[p.playing]), nil
+}
+
+func (p *memoryPlayer) Delete(start, end int) error {
+	if start < 0 || end > len(p.queue) || start >= end {
+		return fmt.Errorf("bad song index: %d:%d", start, end)
+	}
+
+	p.queue = append(p.queue[:start], p.queue[end:]...)
+
+	// Deleting the current song stops playback
+	switch {
+	case p.song >= end:
+		p.song -= end - start
+		p.playing = p.song
+	case p.song >= start:
+		p.song, p.playing = -1, -1
+	}
+
+	return nil
+}
+
+func (p *memoryPlayer) DisableOutput(id int) error { return p.setOutput(id, "0") }
+func (p *memoryPlayer) EnableOutput(id int) error  { return p.setOutput(id, "1") }
+
+func (p *memoryPlayer) ListOutputs() ([]mpd.Attrs, error) {
+	return p.outputs, nil
+}
+
+func (p *memoryPlayer) Pause(pause bool) error {
+	if p.playing >= 0 {
+		p.paused = pause
+	}
+
+	return nil
+}
+
+func (p *memoryPlayer) Play(pos int) error {
+	// Like MPD, resume from the current song if no position is specified
+	if pos < 0 {
+		pos = p.song
+		if pos < 0 {
+			pos = 0
+		}
+	}
+
+	return p.Seek(pos, 0)
+}
+
+func (p *memoryPlayer) PlaylistInfo(start, end int) ([]mpd.Attrs, error) {
+	if start >= 0 || end >= 0 {
+		panic("memoryPlayer.PlaylistInfo only supports listing the entire queue")
+	}
+
+	out := make([]mpd.Attrs, 0, len(p.queue))
+	for i, name := range p.queue {
+		a := make(mpd.Attrs)
+		for k, v := range p.songAttrs(name) {
+			a[k] = v
+		}
+		a["Pos"] = strconv.Itoa(i)
+
+		out = append(out, a)
+	}
+
+	return out, nil
+}
+
+func (p *memoryPlayer) Seek(pos, time int) error {
+	if pos < 0 || pos >= len(p.queue) {
+		return fmt.Errorf("bad song index: %d", pos)
+	}
+
+	p.song, p.playing = pos, pos
+	p.paused = false
+	p.elapsed = time
+	return nil
+}
+
+func (p *memoryPlayer) Shuffle(start, end int) error {
+	// Reversing the queue is sufficient for tests
+	for i, j := 0, len(p.queue)-1; i < j; i, j = i+1, j-1 {
+		p.queue[i], p.queue[j] = p.queue[j], p.queue[i]
+	}
+
+	return nil
+}
+
+func (p *memoryPlayer) PlayID(id int) error {
+	if id < 0 || id >= len(p.queue) {
+		return fmt.Errorf("no such song: %d", id)
+	}
+
+	p.playing = id
+	return nil
+}
+
+func (p *memoryPlayer) SetVolume(volume int) error {
+	p.volume = volume
+	return nil
+}
+
+func (p *memoryPlayer) Status() (mpd.Attrs, error) {
+	a := mpd.Attrs{
+		"volume": strconv.Itoa(p.volume),
+		"state":  "stop",
+	}
+	if p.song >= 0 {
+		a["song"] = strconv.Itoa(p.song)
+	}
+	if p.playing >= 0 {
+		a["state"] = "play"
+		if p.paused {
+			a["state"] = "pause"
+		}
+		a["elapsed"] = strconv.Itoa(p.elapsed)
+	}
+
+	return a, nil
+}
+
+// songAttrs returns the attributes of the song with the specified name, or
+// only its name if it is not in the database.
+func (p *memoryPlayer) songAttrs(name string) mpd.Attrs {
+	for _, a := range p.songs {
+		if a["file"] == name {
+			return a
+		}
+	}
+
+	return mpd.Attrs{"file": name}
+}
+
+func (p *memoryPlayer) setOutput(id int, enabled string) error {
+	if id < 0 || id >= len(p.outputs) {
+		return fmt.Errorf("no such output: %d", id)
+	}
+
+	p.outputs[id]["outputenabled"] = enabled
+	return nil
+}
@@ -0,0 +1,168 @@
+package mpdsub
+
+import (
+	"bytes"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"path"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// placeholderSize is the width and height of placeholder artwork when
+	// a client does not request a size.
+	placeholderSize = 300
+
+	// maxPlaceholderSize is the maximum width and height of placeholder
+	// artwork.
+	maxPlaceholderSize = 1200
+)
+
+// placeholderColors are the background colors of placeholder artwork, which
+// are dark enough for white initials to remain legible.
+var placeholderColors = []color.RGBA{
+	{R: 0x8e, G: 0x24, B: 0x3c, A: 0xff},
+	{R: 0xa8, G: 0x3c, B: 0x1c, A: 0xff},
+	{R: 0x9a, G: 0x62, B: 0x0e, A: 0xff},
+	{R: 0x4c, G: 0x6e, B: 0x1a, A: 0xff},
+	{R: 0x1b, G: 0x6e, B: 0x4a, A: 0xff},
+	{R: 0x13, G: 0x68, B: 0x72, A: 0xff},
+	{R: 0x1f, G: 0x4e, B: 0x8c, A: 0xff},
+	{R: 0x3a, G: 0x3f, B: 0x99, A: 0xff},
+	{R: 0x60, G: 0x33, B: 0x8f, A: 0xff},
+	{R: 0x86, G: 0x2a, B: 0x78, A: 0xff},
+	{R: 0x4a, G: 0x4f, B: 0x5a, A: 0xff},
+	{R: 0x6b, G: 0x4b, B: 0x32, A: 0xff},
+}
+
+// placeholderGlyphs are 5x7 pixel glyphs for the characters which may
+// appear in initials.  Each byte is a row, with the leftmost pixel in bit 4.
+var placeholderGlyphs = map[rune][7]byte{
+	'A': {0x0e, 0x11, 0x11, 0x1f, 0x11, 0x11, 0x11},
+	'B': {0x1e, 0x11, 0x11, 0x1e, 0x11, 0x11, 0x1e},
+	'C': {0x0e, 0x11, 0x10, 0x10, 0x10, 0x11, 0x0e},
+	'D': {0x1e, 0x11, 0x11, 0x11, 0x11, 0x11, 0x1e},
+	'E': {0x1f, 0x10, 0x10, 0x1e, 0x10, 0x10, 0x1f},
+	'F': {0x1f, 0x10, 0x10, 0x1e, 0x10, 0x10, 0x10},
+	'G': {0x0e, 0x11, 0x10, 0x17, 0x11, 0x11, 0x0f},
+	'H': {0x11, 0x11, 0x11, 0x1f, 0x11, 0x11, 0x11},
+	'I': {0x0e, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0e},
+	'J': {0x07, 0x02, 0x02, 0x02, 0x02, 0x12, 0x0c},
+	'K': {0x11, 0x12, 0x14, 0x18, 0x14, 0x12, 0x11},
+	'L': {0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x1f},
+	'M': {0x11, 0x1b, 0x15, 0x15, 0x11, 0x11, 0x11},
+	'N': {0x11, 0x11, 0x19, 0x15, 0x13, 0x11, 0x11},
+	'O': {0x0e, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0e},
+	'P': {0x1e, 0x11, 0x11, 0x1e, 0x10, 0x10, 0x10},
+	'Q': {0x0e, 0x11, 0x11, 0x11, 0x15, 0x12, 0x0d},
+	'R': {0x1e, 0x11, 0x11, 0x1e, 0x14, 0x12, 0x11},
+	'S': {0x0f, 0x10, 0x10, 0x0e, 0x01, 0x01, 0x1e},
+	'T': {0x1f, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+	'U': {0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0e},
+	'V': {0x11, 0x11, 0x11, 0x11, 0x11, 0x0a, 0x04},
+	'W': {0x11, 0x11, 0x11, 0x15, 0x15, 0x15, 0x0a},
+	'X': {0x11, 0x11, 0x0a, 0x04, 0x0a, 0x11, 0x11},
+	'Y': {0x11, 0x11, 0x11, 0x0a, 0x04, 0x04, 0x04},
+	'Z': {0x1f, 0x01, 0x02, 0x04, 0x08, 0x10, 0x1f},
+	'0': {0x0e, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0e},
+	'1': {0x04, 0x0c, 0x04, 0x04, 0x04, 0x04, 0x0e},
+	'2': {0x0e, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1f},
+	'3': {0x1f, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0e},
+	'4': {0x02, 0x06, 0x0a, 0x12, 0x1f, 0x02, 0x02},
+	'5': {0x1f, 0x10, 0x1e, 0x01, 0x01, 0x11, 0x0e},
+	'6': {0x06, 0x08, 0x10, 0x1e, 0x11, 0x11, 0x0e},
+	'7': {0x1f, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8': {0x0e, 0x11, 0x11, 0x0e, 0x11, 0x11, 0x0e},
+	'9': {0x0e, 0x11, 0x11, 0x0f, 0x01, 0x02, 0x0c},
+}
+
+// placeholderArtwork generates a square PNG image which stands in for the
+// artwork of a file or directory which has none, so that clients do not
+// display broken images.  The image shows the initials of the directory's
+// name, or of the file's name without its extension, on a background color
+// derived from its full path, so an item always has the same placeholder.
+//
+// If size is 0, placeholderSize is used.
+func placeholderArtwork(f indexedFile, size int) ([]byte, error) {
+	if size == 0 {
+		size = placeholderSize
+	}
+	if size > maxPlaceholderSize {
+		size = maxPlaceholderSize
+	}
+
+	name := path.Base(f.Name)
+	if !f.Dir {
+		name = strings.TrimSuffix(name, path.Ext(name))
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(f.Name))
+	bg := placeholderColors[h.Sum32()%uint32(len(placeholderColors))]
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	if text := initials(name); len(text) > 0 {
+		// Glyphs are separated by a column of pixels, and the initials
+		// span roughly half of the image
+		cols := len(text)*6 - 1
+		scale := max1(size / 2 / cols)
+		x0 := (size - cols*scale) / 2
+		y0 := (size - 7*scale) / 2
+
+		fg := image.NewUniform(color.White)
+		for i, r := range text {
+			g := placeholderGlyphs[r]
+			for y, row := range g {
+				for x := 0; x < 5; x++ {
+					if row&(0x10>>uint(x)) == 0 {
+						continue
+					}
+
+					px := x0 + (i*6+x)*scale
+					py := y0 + y*scale
+					draw.Draw(img, image.Rect(px, py, px+scale, py+scale), fg, image.Point{}, draw.Src)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// initials returns the upper case initials of the first two words of name
+// which can be drawn on placeholder artwork.  Accents are removed, so that
+// "Émilie" produces "E".
+func initials(name string) []rune {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var out []rune
+	for _, w := range words {
+		// The first rune of the decomposed word is its unaccented letter
+		r := unicode.ToUpper([]rune(norm.NFD.String(w))[0])
+		if _, ok := placeholderGlyphs[r]; !ok {
+			continue
+		}
+
+		out = append(out, r)
+		if len(out) == 2 {
+			break
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,107 @@
+package mpdsub
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_placeholderArtwork(t *testing.T) {
+	f := indexedFile{Name: "foo/Abbey Road", Dir: true}
+
+	a, err := placeholderArtwork(f, 0)
+	if err != nil {
+		t.Fatalf("failed to generate placeholder: %v", err)
+	}
+	b, err := placeholderArtwork(f, 0)
+	if err != nil {
+		t.Fatalf("failed to generate placeholder: %v", err)
+	}
+
+	if !bytes.Equal(a, b) {
+		t.Fatal("placeholders for the same item should be identical")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(a))
+	if err != nil {
+		t.Fatalf("failed to decode placeholder: %v", err)
+	}
+
+	if want, got := image.Rect(0, 0, placeholderSize, placeholderSize), img.Bounds(); want != got {
+		t.Fatalf("unexpected bounds:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// The corners show the background, and the center is crossed by the
+	// horizontal bar of the "A"
+	bg := color.RGBAModel.Convert(img.At(0, 0))
+	if _, ok := bg.(color.RGBA); !ok || bg == color.RGBAModel.Convert(color.White) {
+		t.Fatalf("unexpected background color: %v", bg)
+	}
+
+	var white bool
+	for x := 0; x < placeholderSize; x++ {
+		if color.RGBAModel.Convert(img.At(x, placeholderSize/2)) == color.RGBAModel.Convert(color.White) {
+			white = true
+			break
+		}
+	}
+	if !white {
+		t.Fatal("placeholder should contain initials")
+	}
+
+	c, err := placeholderArtwork(indexedFile{Name: "foo/Abbey Road", Dir: true}, 5000)
+	if err != nil {
+		t.Fatalf("failed to generate placeholder: %v", err)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(c))
+	if err != nil {
+		t.Fatalf("failed to decode placeholder: %v", err)
+	}
+	if want, got := maxPlaceholderSize, cfg.Width; want != got {
+		t.Fatalf("unexpected width:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_initials(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "two words",
+			in:   "abbey road",
+			out:  "AR",
+		},
+		{
+			name: "first two of many",
+			in:   "Sgt. Pepper's Lonely Hearts Club Band",
+			out:  "SP",
+		},
+		{
+			name: "accents",
+			in:   "Émilie Simon",
+			out:  "ES",
+		},
+		{
+			name: "digits",
+			in:   "1999",
+			out:  "1",
+		},
+		{
+			name: "no glyphs",
+			in:   "東京 !!",
+			out:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if want, got := tt.out, string(initials(tt.in)); want != got {
+				t.Fatalf("unexpected initials:\n- want: %q\n-  got: %q", want, got)
+			}
+		})
+	}
+}
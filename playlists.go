@@ -0,0 +1,735 @@
+package mpdsub
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// Prefixes used for the IDs of each kind of playlist.
+const (
+	storedPlaylistPrefix = "pl:"
+	smartPlaylistPrefix  = "smart:"
+)
+
+// getPlaylists returns all playlists available to the user.
+func (s *Server) getPlaylists(w http.ResponseWriter, r *http.Request) {
+	pls, err := s.playlists(requestContextFrom(r).User)
+	if err != nil {
+		s.logf("error listing playlists: %v", err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	playlists := make([]playlist, 0, len(pls))
+	for _, pl := range pls {
+		// Entries are only returned by getPlaylist
+		pl.Entries = nil
+		playlists = append(playlists, *pl)
+	}
+
+	writeXML(w, func(c *container) {
+		c.Playlists = &playlistsContainer{
+			Playlists: playlists,
+		}
+	})
+}
+
+// getPlaylist returns a single playlist and its songs.  The optional sort
+// and filter parameters are applied to the songs, as described by
+// playlistView.
+func (s *Server) getPlaylist(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	v, ok := parsePlaylistView(r)
+	if !ok {
+		writeXML(w, errGeneric)
+		return
+	}
+
+	user := requestContextFrom(r).User
+
+	pl, ok, err := s.playlist(user, id)
+	if err != nil {
+		s.logf("error building playlist %q: %v", id, err)
+		writeXML(w, errGeneric)
+		return
+	}
+	if !ok {
+		writeXML(w, errNotFound)
+		return
+	}
+
+	s.applyPlaylistView(user, pl, v)
+
+	writeXML(w, func(c *container) {
+		c.Playlist = pl
+	})
+}
+
+// createPlaylist creates a MPD stored playlist owned by the user, containing
+// the songs specified by the repeated songId parameter.  If playlistId is
+// specified instead of name, the songs of an existing stored playlist owned
+// by the user are replaced.
+func (s *Server) createPlaylist(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	user := requestContextFrom(r).User
+
+	var (
+		id   string
+		meta = playlistMeta{Owner: user}
+	)
+
+	switch {
+	case q.Get("playlistId") != "":
+		pl, ok := s.modifiablePlaylist(w, user, q.Get("playlistId"), true)
+		if !ok {
+			return
+		}
+
+		id = pl.ID
+		meta = playlistMeta{
+			Owner:   pl.Owner,
+			Comment: pl.Comment,
+			Public:  pl.Public,
+		}
+	case q.Get("name") != "":
+		if !validPlaylistName(q.Get("name")) {
+			writeXML(w, errGeneric)
+			return
+		}
+		id = storedPlaylistPrefix + s.namespacedPlaylist(user, q.Get("name"))
+
+		// MPD stored playlist names are unique, so an existing playlist
+		// cannot be created again
+		_, exists, err := s.storedPlaylistByID(user, id)
+		if err != nil {
+			s.logf("error listing playlists from mpd: %v", err)
+			writeXML(w, errMPD(err))
+			return
+		}
+		if exists {
+			writeXML(w, errGeneric)
+			return
+		}
+	default:
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	names, ok := s.playlistFiles(w, user, q["songId"])
+	if !ok {
+		return
+	}
+
+	name := strings.TrimPrefix(id, storedPlaylistPrefix)
+
+	// Clearing a stored playlist which does not exist creates it
+	if err := s.db.PlaylistClear(name); err != nil {
+		s.logf("error clearing playlist %q in mpd: %v", name, err)
+		writeXML(w, errMPD(err))
+		return
+	}
+	for _, f := range names {
+		if err := s.db.PlaylistAdd(name, f); err != nil {
+			s.logf("error adding %q to playlist %q in mpd: %v", f, name, err)
+			writeXML(w, errMPD(err))
+			return
+		}
+	}
+
+	// Storing the metadata also invalidates the ETag of getPlaylists,
+	// which cannot observe changes to MPD stored playlists
+	err := s.store.Update(func(d *storeData) error {
+		meta.Created = d.Playlists[id].Created
+		if meta.Created.IsZero() {
+			meta.Created = time.Now()
+		}
+
+		d.Playlists[id] = meta
+		return nil
+	})
+	if err != nil {
+		s.logf("error storing playlist metadata for %q: %v", id, err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	pl, ok, err := s.playlist(user, id)
+	if err != nil || !ok {
+		s.logf("error building playlist %q: %v", id, err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	writeXML(w, func(c *container) {
+		c.Playlist = pl
+	})
+}
+
+// updatePlaylist updates a playlist owned by the user.  The comment and
+// public flag of any playlist may be updated, while MPD stored playlists may
+// also be renamed, and have songs added using the repeated songIdToAdd
+// parameter and removed by their index in the playlist using the repeated
+// songIndexToRemove parameter.
+func (s *Server) updatePlaylist(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	id := q.Get("playlistId")
+	if id == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	var public *bool
+	if qPublic := q.Get("public"); qPublic != "" {
+		b, err := strconv.ParseBool(qPublic)
+		if err != nil {
+			writeXML(w, errGeneric)
+			return
+		}
+		public = &b
+	}
+
+	rename := q.Get("name")
+	if rename != "" && !validPlaylistName(rename) {
+		writeXML(w, errGeneric)
+		return
+	}
+
+	user := requestContextFrom(r).User
+
+	// Only the songs and names of MPD stored playlists can be modified
+	songs := rename != "" || len(q["songIdToAdd"]) > 0 || len(q["songIndexToRemove"]) > 0
+
+	pl, ok := s.modifiablePlaylist(w, user, id, songs)
+	if !ok {
+		return
+	}
+
+	meta := playlistMeta{
+		Owner:   pl.Owner,
+		Comment: pl.Comment,
+		Public:  pl.Public,
+	}
+	if _, ok := q["comment"]; ok {
+		meta.Comment = q.Get("comment")
+	}
+	if public != nil {
+		meta.Public = *public
+	}
+
+	newID := id
+	if songs {
+		name := strings.TrimPrefix(id, storedPlaylistPrefix)
+		if rename != "" {
+			newID = storedPlaylistPrefix + s.namespacedPlaylist(user, rename)
+		}
+
+		if !s.editStoredPlaylist(w, user, name, strings.TrimPrefix(newID, storedPlaylistPrefix), q) {
+			return
+		}
+	}
+
+	err := s.store.Update(func(d *storeData) error {
+		meta.Created = d.Playlists[id].Created
+
+		delete(d.Playlists, id)
+		d.Playlists[newID] = meta
+		return nil
+	})
+	if err != nil {
+		s.logf("error storing playlist metadata for %q: %v", newID, err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	writeXML(w, nil)
+}
+
+// editStoredPlaylist removes and adds the songs specified by the parameters
+// of an updatePlaylist request to the MPD stored playlist name, and renames
+// it to newName.  If an error occurs, it is written to w and false is
+// returned.
+func (s *Server) editStoredPlaylist(w http.ResponseWriter, user string, name string, newName string, q url.Values) bool {
+	positions, err := s.playlistPositions(user, name)
+	if err != nil {
+		s.logf("error retrieving playlist %q from mpd: %v", name, err)
+		writeXML(w, errMPD(err))
+		return false
+	}
+
+	// Validate all indices before modifying the playlist, and remove songs
+	// from the end first so that earlier positions remain valid
+	remove := make([]int, 0, len(q["songIndexToRemove"]))
+	seen := make(map[int]struct{})
+	for _, qIndex := range q["songIndexToRemove"] {
+		i, err := strconv.Atoi(qIndex)
+		if err != nil || i < 0 || i >= len(positions) {
+			writeXML(w, errGeneric)
+			return false
+		}
+
+		if _, ok := seen[i]; ok {
+			continue
+		}
+		seen[i] = struct{}{}
+		remove = append(remove, positions[i])
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(remove)))
+
+	add, ok := s.playlistFiles(w, user, q["songIdToAdd"])
+	if !ok {
+		return false
+	}
+
+	for _, pos := range remove {
+		if err := s.db.PlaylistDelete(name, pos); err != nil {
+			s.logf("error removing position %d from playlist %q in mpd: %v", pos, name, err)
+			writeXML(w, errMPD(err))
+			return false
+		}
+	}
+	for _, f := range add {
+		if err := s.db.PlaylistAdd(name, f); err != nil {
+			s.logf("error adding %q to playlist %q in mpd: %v", f, name, err)
+			writeXML(w, errMPD(err))
+			return false
+		}
+	}
+
+	if newName != name {
+		if err := s.db.PlaylistRename(name, newName); err != nil {
+			s.logf("error renaming playlist %q to %q in mpd: %v", name, newName, err)
+			writeXML(w, errMPD(err))
+			return false
+		}
+	}
+
+	return true
+}
+
+// deletePlaylist deletes a MPD stored playlist owned by the user.
+func (s *Server) deletePlaylist(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	user := requestContextFrom(r).User
+
+	if _, ok := s.modifiablePlaylist(w, user, id, true); !ok {
+		return
+	}
+
+	name := strings.TrimPrefix(id, storedPlaylistPrefix)
+	if err := s.db.PlaylistRemove(name); err != nil {
+		s.logf("error removing playlist %q from mpd: %v", name, err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	err := s.store.Update(func(d *storeData) error {
+		delete(d.Playlists, id)
+		return nil
+	})
+	if err != nil {
+		s.logf("error removing playlist metadata for %q: %v", id, err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	writeXML(w, nil)
+}
+
+// modifiablePlaylist looks up a playlist which user may modify.  If stored
+// is true, the playlist must also be a MPD stored playlist.  If the playlist
+// cannot be modified, an error is written to w and false is returned.
+func (s *Server) modifiablePlaylist(w http.ResponseWriter, user string, id string, stored bool) (*playlist, bool) {
+	pl, ok, err := s.playlist(user, id)
+	if err != nil {
+		s.logf("error building playlist %q: %v", id, err)
+		writeXML(w, errGeneric)
+		return nil, false
+	}
+	if !ok {
+		writeXML(w, errNotFound)
+		return nil, false
+	}
+
+	// Only the owner of a playlist may modify it, and the songs of smart
+	// playlists and daily mixes are determined by the Server
+	if pl.Owner != user || (stored && !strings.HasPrefix(id, storedPlaylistPrefix)) {
+		writeXML(w, errNotAuthorized)
+		return nil, false
+	}
+
+	return pl, true
+}
+
+// playlistFiles resolves the Subsonic IDs of songs to be added to a playlist
+// to their MPD file names.  If a song does not exist or is not accessible to
+// user, an error is written to w and false is returned.
+func (s *Server) playlistFiles(w http.ResponseWriter, user string, qIDs []string) ([]string, bool) {
+	if len(qIDs) == 0 {
+		return nil, true
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		s.logf("error listing files from mpd for playlist: %v", err)
+		writeXML(w, errMPD(err))
+		return nil, false
+	}
+	files := indexFiles(fs)
+
+	names := make([]string, 0, len(qIDs))
+	for _, qID := range qIDs {
+		f, ok := s.lookupID(files, qID)
+		if !ok || f.Dir {
+			writeXML(w, errNotFound)
+			return nil, false
+		}
+		if !s.canAccess(w, user, f) {
+			return nil, false
+		}
+
+		names = append(names, f.Name)
+	}
+
+	return names, true
+}
+
+// playlistPositions returns the positions in a MPD stored playlist of the
+// songs which are visible to user, so that the indices of the entries
+// returned by getPlaylist can be mapped back to the stored playlist.
+func (s *Server) playlistPositions(user string, name string) ([]int, error) {
+	songs, err := s.db.PlaylistContents(name)
+	if err != nil {
+		return nil, err
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		return nil, err
+	}
+	ids := fileIDs(indexFiles(fs))
+
+	// Skip the same songs as songChildren
+	positions := make([]int, 0, len(songs))
+	for i, a := range songs {
+		if _, ok := ids[a["file"]]; !ok || !s.visible(user, a["file"]) || s.filteredGenre(user, a["Genre"]) {
+			continue
+		}
+
+		positions = append(positions, i)
+	}
+
+	return positions, nil
+}
+
+// validPlaylistName reports whether name may be used as the name of a MPD
+// stored playlist.
+func validPlaylistName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "/\r\n")
+}
+
+// playlists returns all playlists which are visible to user.
+func (s *Server) playlists(user string) ([]*playlist, error) {
+	var pls []*playlist
+
+	stored, err := s.db.ListPlaylists()
+	if err != nil {
+		return nil, err
+	}
+
+	storedNames := make(map[string]struct{}, len(stored))
+	for _, a := range stored {
+		pl, err := s.storedPlaylist(user, a["playlist"], lastModified(a))
+		if err != nil {
+			return nil, err
+		}
+
+		pls = append(pls, pl)
+		storedNames[a["playlist"]] = struct{}{}
+	}
+
+	m3us, err := s.m3uPlaylists(user)
+	if err != nil {
+		return nil, err
+	}
+	for _, pl := range m3us {
+		// If PlaylistDirectory is MPD's own playlist directory, its
+		// playlists are already listed as stored playlists
+		file := strings.TrimPrefix(pl.ID, m3uPlaylistPrefix)
+		if _, ok := storedNames[strings.TrimSuffix(file, ".m3u")]; ok {
+			continue
+		}
+
+		pls = append(pls, pl)
+	}
+
+	for _, p := range s.cfg.SmartPlaylists {
+		pl, err := s.evaluateSmartPlaylist(user, p)
+		if err != nil {
+			return nil, err
+		}
+
+		pls = append(pls, pl)
+	}
+
+	mixes, err := s.dailyMixes(user)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, m := range mixes {
+		pl, err := s.mixPlaylist(user, i, m)
+		if err != nil {
+			return nil, err
+		}
+
+		pls = append(pls, pl)
+	}
+
+	visible := make([]*playlist, 0, len(pls))
+	for _, pl := range pls {
+		if s.applyPlaylistMeta(user, pl) {
+			visible = append(visible, pl)
+		}
+	}
+
+	return visible, nil
+}
+
+// playlist looks up a single playlist by its ID.  If the playlist does not
+// exist or is not visible to user, it returns false.
+func (s *Server) playlist(user string, id string) (*playlist, bool, error) {
+	var (
+		pl  *playlist
+		ok  bool
+		err error
+	)
+
+	switch {
+	case strings.HasPrefix(id, storedPlaylistPrefix):
+		pl, ok, err = s.storedPlaylistByID(user, id)
+	case strings.HasPrefix(id, m3uPlaylistPrefix):
+		pl, ok, err = s.m3uPlaylistByID(user, id)
+	case strings.HasPrefix(id, smartPlaylistPrefix):
+		p, found := s.smartPlaylist(id)
+		if found {
+			pl, err = s.evaluateSmartPlaylist(user, *p)
+			ok = err == nil
+		}
+	case strings.HasPrefix(id, mixPlaylistPrefix):
+		pl, ok, err = s.mixPlaylistByID(user, id)
+	}
+
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	if !s.applyPlaylistMeta(user, pl) {
+		return nil, false, nil
+	}
+
+	return pl, true, nil
+}
+
+// applyPlaylistMeta applies any stored metadata to a playlist, and reports
+// whether the playlist is visible to user.  Playlists without stored
+// metadata keep their default owner and public flag.
+func (s *Server) applyPlaylistMeta(user string, pl *playlist) bool {
+	s.store.View(func(d *storeData) {
+		if meta, ok := d.Playlists[pl.ID]; ok {
+			pl.Owner = meta.Owner
+			pl.Comment = meta.Comment
+			pl.Public = meta.Public
+			if !meta.Created.IsZero() {
+				pl.Created = formatTime(meta.Created)
+			}
+		}
+	})
+
+	return pl.Public || pl.Owner == user
+}
+
+// storedPlaylistByID looks up a MPD stored playlist by its playlist ID.
+func (s *Server) storedPlaylistByID(user string, id string) (*playlist, bool, error) {
+	name := strings.TrimPrefix(id, storedPlaylistPrefix)
+
+	stored, err := s.db.ListPlaylists()
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, a := range stored {
+		if a["playlist"] != name {
+			continue
+		}
+
+		pl, err := s.storedPlaylist(user, name, lastModified(a))
+		if err != nil {
+			return nil, false, err
+		}
+
+		return pl, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// storedPlaylist produces a Subsonic playlist from the MPD stored playlist
+// with the specified name.  Stored playlists are private unless metadata
+// states otherwise, and are owned by the configured Subsonic user unless
+// their name is namespaced by another user.  The playlist was created and
+// changed at modified, unless metadata records when it was created.
+func (s *Server) storedPlaylist(user string, name string, modified time.Time) (*playlist, error) {
+	songs, err := s.db.PlaylistContents(name)
+	if err != nil {
+		return nil, err
+	}
+
+	children, err := s.songChildren(user, songs)
+	if err != nil {
+		return nil, err
+	}
+
+	owner, display := s.playlistOwner(name)
+
+	pl := newPlaylist(storedPlaylistPrefix+name, display, owner, false, children)
+	pl.Created = formatTime(modified)
+	pl.Changed = formatTime(modified)
+	return pl, nil
+}
+
+// playlistNamespaceSep separates a user's name from a playlist's name in
+// the names of namespaced MPD stored playlists.  MPD does not permit
+// slashes in playlist names, so a colon is used instead.
+const playlistNamespaceSep = ":"
+
+// playlistOwner determines the owner and display name of a MPD stored
+// playlist.  When playlist namespaces are enabled, playlists named
+// "user:name" are owned by user and displayed as name.  All other playlists
+// are owned by the configured Subsonic user.
+func (s *Server) playlistOwner(name string) (owner string, display string) {
+	if s.cfg.PlaylistNamespaces {
+		if i := strings.Index(name, playlistNamespaceSep); i > 0 {
+			return name[:i], name[i+len(playlistNamespaceSep):]
+		}
+	}
+
+	return s.cfg.SubsonicUser, name
+}
+
+// namespacedPlaylist returns the MPD stored playlist name used for a
+// playlist named name which is owned by user.
+func (s *Server) namespacedPlaylist(user string, name string) string {
+	if !s.cfg.PlaylistNamespaces {
+		return name
+	}
+
+	return user + playlistNamespaceSep + name
+}
+
+// smartPlaylist looks up a configured SmartPlaylist by its ID.
+func (s *Server) smartPlaylist(id string) (*SmartPlaylist, bool) {
+	if !strings.HasPrefix(id, smartPlaylistPrefix) {
+		return nil, false
+	}
+
+	name := strings.TrimPrefix(id, smartPlaylistPrefix)
+	for i := range s.cfg.SmartPlaylists {
+		if s.cfg.SmartPlaylists[i].Name == name {
+			return &s.cfg.SmartPlaylists[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// evaluateSmartPlaylist queries MPD for the songs in a SmartPlaylist and
+// produces a Subsonic playlist containing the songs visible to user.
+func (s *Server) evaluateSmartPlaylist(user string, p SmartPlaylist) (*playlist, error) {
+	args, f, err := p.searchArgs(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// Play counts are only needed when the playlist compares them
+	var stats map[string]playStats
+	if f.Plays != (playRange{}) {
+		stats = s.playStats(user)
+	}
+
+	var found []mpd.Attrs
+	if len(args) > 0 {
+		found, err = s.db.Search(args...)
+	} else {
+		found, err = s.db.ListAllInfo("")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	songs := make([]mpd.Attrs, 0, len(found))
+	for _, a := range found {
+		if !f.Years.contains(a) {
+			continue
+		}
+		if stats != nil && !f.Plays.contains(stats[s.itemKey(a["file"])].Count) {
+			continue
+		}
+
+		songs = append(songs, a)
+	}
+
+	if p.Limit > 0 && len(songs) > p.Limit {
+		songs = songs[:p.Limit]
+	}
+
+	children, err := s.songChildren(user, songs)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPlaylist(smartPlaylistPrefix+p.Name, p.Name, s.cfg.SubsonicUser, true, children), nil
+}
+
+// newPlaylist creates a Subsonic playlist containing the input songs.
+func newPlaylist(id string, name string, owner string, public bool, children []child) *playlist {
+	pl := &playlist{
+		ID:        id,
+		Name:      name,
+		Owner:     owner,
+		Public:    public,
+		SongCount: len(children),
+	}
+
+	for _, c := range children {
+		pl.Duration += c.Duration
+		pl.Entries = append(pl.Entries, entry{child: c})
+	}
+
+	// Represent the playlist using the artwork of its first song's album,
+	// or of the song itself if it is not in an album
+	if len(children) > 0 {
+		pl.CoverArt = children[0].Parent
+		if pl.CoverArt == "" {
+			pl.CoverArt = children[0].CoverArt
+		}
+	}
+
+	return pl
+}
@@ -0,0 +1,509 @@
+package mpdsub
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_getPlaylists(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Metal/Iron Maiden/Aces High.mp3",
+			"Pop/Madonna/Vogue.mp3",
+		},
+		searches: map[string][]mpd.Attrs{
+			"genre metal": {{
+				"file":  "Metal/Iron Maiden/Aces High.mp3",
+				"Title": "Aces High",
+				"Time":  "271",
+			}},
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.SmartPlaylists = []SmartPlaylist{{
+		Name:  "Metal",
+		Query: "genre:metal",
+	}}
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getPlaylists.view", values))
+
+		if c.Playlists == nil {
+			t.Fatal("playlists is nil")
+		}
+
+		if want, got := 1, len(c.Playlists.Playlists); want != got {
+			t.Fatalf("unexpected number of playlists:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		p := c.Playlists.Playlists[0]
+		if want, got := "smart:Metal", p.ID; want != got {
+			t.Fatalf("unexpected playlist ID:\n- want: %q\n-  got: %q", want, got)
+		}
+		if want, got := 1, p.SongCount; want != got {
+			t.Fatalf("unexpected playlist song count:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := 271, p.Duration; want != got {
+			t.Fatalf("unexpected playlist duration:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := "1", p.CoverArt; want != got {
+			t.Fatalf("unexpected playlist cover art:\n- want: %q\n-  got: %q", want, got)
+		}
+		if want, got := 0, len(p.Entries); want != got {
+			t.Fatalf("unexpected number of playlist entries:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
+
+func TestServer_getPlaylist(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Metal/Iron Maiden/Aces High.mp3",
+			"Pop/Madonna/Vogue.mp3",
+		},
+		searches: map[string][]mpd.Attrs{
+			"genre metal": {{
+				"file":  "Metal/Iron Maiden/Aces High.mp3",
+				"Title": "Aces High",
+			}},
+		},
+	}
+
+	tests := []struct {
+		name string
+		id   string
+
+		xmlError *subsonicError
+		entries  []entry
+	}{
+		{
+			name: "no ID",
+
+			xmlError: &subsonicError{Code: codeMissingParameter},
+		},
+		{
+			name: "unknown ID",
+			id:   "smart:Jazz",
+
+			xmlError: &subsonicError{Code: codeNotFound},
+		},
+		{
+			name: "OK",
+			id:   "smart:Metal",
+
+			entries: []entry{{
+				child: child{
+					ID:       "2",
+					Parent:   "1",
+					CoverArt: "2",
+					Suffix:   "mp3",
+					Title:    "Aces High",
+					Path:     "Metal/Iron Maiden/Aces High.mp3",
+				},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.SmartPlaylists = []SmartPlaylist{{
+				Name:  "Metal",
+				Query: "genre:metal",
+			}}
+
+			if tt.id != "" {
+				values.Set("id", tt.id)
+			}
+
+			withServer(t, db, nil, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getPlaylist.view", values))
+
+				if tt.xmlError != nil {
+					if want, got := tt.xmlError.Code, c.Error.Code; want != got {
+						t.Fatalf("unexpected XML error code:\n- want: %v\n-  got: %v",
+							want, got)
+					}
+
+					return
+				}
+
+				if c.Playlist == nil {
+					t.Fatal("playlist is nil")
+				}
+
+				mustEntriesEqual(t, tt.entries, c.Playlist.Entries)
+			})
+		})
+	}
+}
+
+// mustEntriesEqual is a helper function for running subtests to compare two
+// slices of playlist entries.
+func mustEntriesEqual(t *testing.T, a []entry, b []entry) {
+	if want, got := len(a), len(b); want != got {
+		t.Fatalf("unexpected entries length:\n- want: %v\n-  got: %v",
+			want, got)
+	}
+
+	for i := range a {
+		want, got := a[i].child, b[i].child
+		want.XMLName, got.XMLName = xml.Name{}, xml.Name{}
+
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected entry:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+	}
+}
+
+func TestServer_storedPlaylistMetadata(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{"foo.mp3"},
+		playlists: map[string][]mpd.Attrs{
+			"mine":   {{"file": "foo.mp3"}},
+			"theirs": {{"file": "foo.mp3"}},
+		},
+	}
+
+	cfg, values := configAuth()
+
+	withServer(t, db, nil, cfg, func(base string) {
+		// Stored playlists are owned by the configured user by default
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getPlaylists.view", values))
+		if want, got := 2, len(c.Playlists.Playlists); want != got {
+			t.Fatalf("unexpected number of playlists:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		update := url.Values{}
+		for k, v := range values {
+			update[k] = v
+		}
+		update.Set("playlistId", "pl:mine")
+		update.Set("comment", "hello")
+		update.Set("public", "true")
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/updatePlaylist.view", update))
+		if want, got := statusOK, c.Status; want != got {
+			t.Fatalf("unexpected status:\n- want: %q\n-  got: %q", want, got)
+		}
+
+		get := url.Values{}
+		for k, v := range values {
+			get[k] = v
+		}
+		get.Set("id", "pl:mine")
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getPlaylist.view", get))
+		if c.Playlist == nil {
+			t.Fatal("playlist is nil")
+		}
+
+		want := playlist{
+			ID:        "pl:mine",
+			Name:      "mine",
+			Comment:   "hello",
+			Owner:     "test",
+			Public:    true,
+			SongCount: 1,
+			CoverArt:  "0",
+		}
+
+		got := *c.Playlist
+		got.XMLName = xml.Name{}
+		got.Entries = nil
+
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected playlist:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
+
+func TestServer_playlistVisibility(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{"foo.mp3"},
+		playlists: map[string][]mpd.Attrs{
+			"private": {{"file": "foo.mp3"}},
+		},
+	}
+
+	// Unknown playlists cannot be modified
+	cfg, values := configAuth()
+	withServer(t, db, nil, cfg, func(base string) {
+		update := url.Values{}
+		for k, v := range values {
+			update[k] = v
+		}
+		update.Set("playlistId", "pl:nope")
+
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/updatePlaylist.view", update))
+		if want, got := codeNotFound, c.Error.Code; want != got {
+			t.Fatalf("unexpected XML error code:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+
+	s, err := newServer(db, nil, &Config{SubsonicUser: "test"})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	// Another user owns the stored playlist, so it is hidden
+	_ = s.store.Update(func(d *storeData) error {
+		d.Playlists["pl:private"] = playlistMeta{Owner: "someone"}
+		return nil
+	})
+
+	pls, err := s.playlists("test")
+	if err != nil {
+		t.Fatalf("failed to list playlists: %v", err)
+	}
+	if want, got := 0, len(pls); want != got {
+		t.Fatalf("unexpected number of visible playlists:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	pls, err = s.playlists("someone")
+	if err != nil {
+		t.Fatalf("failed to list playlists: %v", err)
+	}
+	if want, got := 1, len(pls); want != got {
+		t.Fatalf("unexpected number of visible playlists:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestServer_playlistNamespaces(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{"foo.mp3"},
+		playlists: map[string][]mpd.Attrs{
+			"test:mine":      {{"file": "foo.mp3"}},
+			"someone:theirs": {{"file": "foo.mp3"}},
+			"shared":         {{"file": "foo.mp3"}},
+		},
+	}
+
+	s, err := newServer(db, nil, &Config{
+		SubsonicUser:       "admin",
+		PlaylistNamespaces: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	tests := []struct {
+		user  string
+		names []string
+	}{
+		{
+			user:  "test",
+			names: []string{"mine"},
+		},
+		{
+			user:  "someone",
+			names: []string{"theirs"},
+		},
+		{
+			user:  "admin",
+			names: []string{"shared"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			pls, err := s.playlists(tt.user)
+			if err != nil {
+				t.Fatalf("failed to list playlists: %v", err)
+			}
+
+			var names []string
+			for _, pl := range pls {
+				if want, got := tt.user, pl.Owner; want != got {
+					t.Fatalf("unexpected playlist owner:\n- want: %q\n-  got: %q", want, got)
+				}
+
+				names = append(names, pl.Name)
+			}
+
+			if want, got := tt.names, names; !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected playlists:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+
+	if want, got := "test:mine", s.namespacedPlaylist("test", "mine"); want != got {
+		t.Fatalf("unexpected namespaced playlist name:\n- want: %q\n-  got: %q", want, got)
+	}
+}
+
+func TestServer_editStoredPlaylists(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{"a.mp3", "b.mp3", "c.mp3"},
+		songs: []mpd.Attrs{
+			{"file": "a.mp3", "Title": "A"},
+			{"file": "b.mp3", "Title": "B"},
+			{"file": "c.mp3", "Title": "C"},
+		},
+	}
+
+	cfg, values := configAuth()
+
+	// request performs a request to endpoint with additional parameters
+	request := func(t *testing.T, base string, endpoint string, extra url.Values) container {
+		v := url.Values{}
+		for k, vs := range values {
+			v[k] = vs
+		}
+		for k, vs := range extra {
+			v[k] = vs
+		}
+
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/"+endpoint+".view", v))
+		if c.Error != nil {
+			t.Fatalf("unexpected error from %s: %+v", endpoint, c.Error)
+		}
+
+		return c
+	}
+
+	// titles returns the titles of the songs in a playlist
+	titles := func(t *testing.T, base string, id string) []string {
+		c := request(t, base, "getPlaylist", url.Values{"id": {id}})
+
+		var out []string
+		for _, e := range c.Playlist.Entries {
+			out = append(out, e.Title)
+		}
+
+		return out
+	}
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := request(t, base, "createPlaylist", url.Values{
+			"name":   {"mix"},
+			"songId": {"0", "1", "2"},
+		})
+		if want, got := "pl:mix", c.Playlist.ID; want != got {
+			t.Fatalf("unexpected playlist ID:\n- want: %q\n-  got: %q", want, got)
+		}
+		if want, got := "test", c.Playlist.Owner; want != got {
+			t.Fatalf("unexpected playlist owner:\n- want: %q\n-  got: %q", want, got)
+		}
+		if want, got := []string{"A", "B", "C"}, titles(t, base, "pl:mix"); !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected songs:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		request(t, base, "updatePlaylist", url.Values{
+			"playlistId":        {"pl:mix"},
+			"name":              {"renamed"},
+			"songIdToAdd":       {"0"},
+			"songIndexToRemove": {"0", "2"},
+		})
+		if want, got := []string{"B", "A"}, titles(t, base, "pl:renamed"); !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected songs after update:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		// Replace the songs of the existing playlist
+		request(t, base, "createPlaylist", url.Values{
+			"playlistId": {"pl:renamed"},
+			"songId":     {"2"},
+		})
+		if want, got := []string{"C"}, titles(t, base, "pl:renamed"); !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected songs after replace:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		request(t, base, "deletePlaylist", url.Values{"id": {"pl:renamed"}})
+		if want, got := 0, len(db.playlists); want != got {
+			t.Fatalf("unexpected number of MPD playlists:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
+
+func TestServer_editPlaylistErrors(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{"foo.mp3"},
+		playlists: map[string][]mpd.Attrs{
+			"mine": {{"file": "foo.mp3"}},
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.SmartPlaylists = []SmartPlaylist{{
+		Name:  "smart",
+		Query: "genre:metal",
+	}}
+
+	tests := []struct {
+		name     string
+		endpoint string
+		params   url.Values
+		code     int
+	}{
+		{
+			name:     "create missing name",
+			endpoint: "createPlaylist",
+			code:     codeMissingParameter,
+		},
+		{
+			name:     "create invalid name",
+			endpoint: "createPlaylist",
+			params:   url.Values{"name": {"a/b"}},
+			code:     codeGeneric,
+		},
+		{
+			name:     "create existing name",
+			endpoint: "createPlaylist",
+			params:   url.Values{"name": {"mine"}},
+			code:     codeGeneric,
+		},
+		{
+			name:     "create unknown song",
+			endpoint: "createPlaylist",
+			params:   url.Values{"name": {"new"}, "songId": {"10"}},
+			code:     codeNotFound,
+		},
+		{
+			name:     "update index out of range",
+			endpoint: "updatePlaylist",
+			params:   url.Values{"playlistId": {"pl:mine"}, "songIndexToRemove": {"1"}},
+			code:     codeGeneric,
+		},
+		{
+			name:     "update songs of smart playlist",
+			endpoint: "updatePlaylist",
+			params:   url.Values{"playlistId": {"smart:smart"}, "songIdToAdd": {"0"}},
+			code:     codeNotAuthorized,
+		},
+		{
+			name:     "delete missing",
+			endpoint: "deletePlaylist",
+			params:   url.Values{"id": {"pl:nope"}},
+			code:     codeNotFound,
+		},
+	}
+
+	withServer(t, db, nil, cfg, func(base string) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				v := url.Values{}
+				for k, vs := range values {
+					v[k] = vs
+				}
+				for k, vs := range tt.params {
+					v[k] = vs
+				}
+
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/"+tt.endpoint+".view", v))
+				if c.Error == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+				if want, got := tt.code, c.Error.Code; want != got {
+					t.Fatalf("unexpected XML error code:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		}
+	})
+}
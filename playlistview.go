@@ -0,0 +1,135 @@
+package mpdsub
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/collate"
+)
+
+// Sort orders supported by getPlaylist.
+const (
+	playlistSortAdded  = "added"
+	playlistSortArtist = "artist"
+	playlistSortAlbum  = "album"
+	playlistSortTitle  = "title"
+)
+
+// A playlistView specifies how the songs in a playlist are presented.  It
+// makes very large playlists, such as smart playlists, easier to browse.
+//
+// Songs are sorted by the sort parameter, one of "added", "artist", "album",
+// or "title", in ascending order unless the order parameter is "desc".  MPD
+// does not record when songs were added to a playlist, but appends new songs
+// to the end, so "added" sorts songs by their position in the playlist.
+//
+// If the filter parameter is set, only songs whose title, artist, or album
+// contain it, ignoring case, are returned.
+type playlistView struct {
+	Sort   string
+	Desc   bool
+	Filter string
+}
+
+// parsePlaylistView parses the parameters of a getPlaylist request into a
+// playlistView.  If a parameter is invalid, it returns false.
+func parsePlaylistView(r *http.Request) (playlistView, bool) {
+	q := r.URL.Query()
+
+	v := playlistView{
+		Sort:   q.Get("sort"),
+		Filter: strings.ToLower(strings.TrimSpace(q.Get("filter"))),
+	}
+
+	switch v.Sort {
+	case "", playlistSortAdded, playlistSortArtist, playlistSortAlbum, playlistSortTitle:
+	default:
+		return v, false
+	}
+
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		v.Desc = true
+	default:
+		return v, false
+	}
+
+	return v, true
+}
+
+// applyPlaylistView filters and sorts the songs in a playlist for user.
+// The playlist's song count and duration are updated to match.
+func (s *Server) applyPlaylistView(user string, pl *playlist, v playlistView) {
+	if v.Filter != "" {
+		var entries []entry
+		for _, e := range pl.Entries {
+			if matchesFilter(e.child, v.Filter) {
+				entries = append(entries, e)
+			}
+		}
+
+		pl.Entries = entries
+		pl.SongCount = len(entries)
+		pl.Duration = 0
+		for _, e := range entries {
+			pl.Duration += e.Duration
+		}
+	}
+
+	var key func(c child) string
+	switch v.Sort {
+	case playlistSortArtist:
+		key = func(c child) string { return s.sortName(c.Artist) + "\x00" + s.sortName(c.Album) }
+	case playlistSortAlbum:
+		key = func(c child) string { return s.sortName(c.Album) }
+	case playlistSortTitle:
+		key = func(c child) string { return c.Title }
+	}
+
+	// Songs are already in the order they were added
+	if key != nil {
+		sort.Stable(&byEntryKey{
+			entries: pl.Entries,
+			key:     key,
+			c:       s.collator(user),
+		})
+	}
+
+	if v.Desc {
+		for i, j := 0, len(pl.Entries)-1; i < j; i, j = i+1, j-1 {
+			pl.Entries[i], pl.Entries[j] = pl.Entries[j], pl.Entries[i]
+		}
+	}
+}
+
+// matchesFilter reports whether the title, artist, or album of c contain
+// filter, which must be lower case.
+func matchesFilter(c child, filter string) bool {
+	for _, s := range []string{c.Title, c.Artist, c.Album} {
+		if strings.Contains(strings.ToLower(s), filter) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// byEntryKey sorts playlist entries by a key, using a collator if one is set.
+type byEntryKey struct {
+	entries []entry
+	key     func(c child) string
+	c       *collate.Collator
+}
+
+func (b *byEntryKey) Len() int { return len(b.entries) }
+func (b *byEntryKey) Less(i, j int) bool {
+	ki, kj := b.key(b.entries[i].child), b.key(b.entries[j].child)
+	if b.c != nil {
+		return b.c.CompareString(ki, kj) < 0
+	}
+
+	return strings.ToLower(ki) < strings.ToLower(kj)
+}
+func (b *byEntryKey) Swap(i, j int) { b.entries[i], b.entries[j] = b.entries[j], b.entries[i] }
@@ -0,0 +1,116 @@
+package mpdsub
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_getPlaylistView(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"a.mp3",
+			"b.mp3",
+			"c.mp3",
+		},
+		playlists: map[string][]mpd.Attrs{
+			"mix": {
+				{"file": "b.mp3", "Title": "Vogue", "Artist": "Madonna", "Album": "I'm Breathless", "Time": "316"},
+				{"file": "a.mp3", "Title": "Aces High", "Artist": "Iron Maiden", "Album": "Powerslave", "Time": "271"},
+				{"file": "c.mp3", "Title": "Help!", "Artist": "The Beatles", "Album": "Help!", "Time": "138"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		params   map[string]string
+		err      bool
+		titles   []string
+		duration int
+	}{
+		{
+			name:     "default",
+			titles:   []string{"Vogue", "Aces High", "Help!"},
+			duration: 725,
+		},
+		{
+			name:     "added descending",
+			params:   map[string]string{"sort": "added", "order": "desc"},
+			titles:   []string{"Help!", "Aces High", "Vogue"},
+			duration: 725,
+		},
+		{
+			name:     "artist",
+			params:   map[string]string{"sort": "artist"},
+			titles:   []string{"Help!", "Aces High", "Vogue"},
+			duration: 725,
+		},
+		{
+			name:     "album",
+			params:   map[string]string{"sort": "album"},
+			titles:   []string{"Help!", "Vogue", "Aces High"},
+			duration: 725,
+		},
+		{
+			name:     "title descending",
+			params:   map[string]string{"sort": "title", "order": "desc"},
+			titles:   []string{"Vogue", "Help!", "Aces High"},
+			duration: 725,
+		},
+		{
+			name:     "filter",
+			params:   map[string]string{"filter": "HIGH"},
+			titles:   []string{"Aces High"},
+			duration: 271,
+		},
+		{
+			name:   "unknown sort",
+			params: map[string]string{"sort": "foo"},
+			err:    true,
+		},
+		{
+			name:   "unknown order",
+			params: map[string]string{"order": "foo"},
+			err:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			values.Set("id", "pl:mix")
+			for k, v := range tt.params {
+				values.Set(k, v)
+			}
+
+			withServer(t, db, nil, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getPlaylist.view", values))
+
+				if tt.err {
+					if c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+					return
+				}
+
+				var titles []string
+				for _, e := range c.Playlist.Entries {
+					titles = append(titles, e.Title)
+				}
+
+				if want, got := tt.titles, titles; !reflect.DeepEqual(want, got) {
+					t.Fatalf("unexpected titles:\n- want: %v\n-  got: %v", want, got)
+				}
+				if want, got := len(tt.titles), c.Playlist.SongCount; want != got {
+					t.Fatalf("unexpected song count:\n- want: %v\n-  got: %v", want, got)
+				}
+				if want, got := tt.duration, c.Playlist.Duration; want != got {
+					t.Fatalf("unexpected duration:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package mpdsub
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// A savedPlayQueue is a play queue saved by a Subsonic client, so that a
+// user can resume listening on another client.
+type savedPlayQueue struct {
+	// Files are the names of the songs in the queue, in order.
+	Files []string `json:"files"`
+
+	// Current is the name of the song which is playing, and Position is
+	// the position within it in milliseconds.
+	Current  string `json:"current,omitempty"`
+	Position int64  `json:"position,omitempty"`
+
+	Changed   time.Time `json:"changed"`
+	ChangedBy string    `json:"changedBy,omitempty"`
+}
+
+// savePlayQueue is used in Subsonic to save the play queue of a client, so
+// that the user can resume listening on another client.  If no songs are
+// specified, the saved queue is removed.  If MirrorPlayQueue is set and the
+// user has the jukebox role, any songs also replace MPD's own queue.
+func (s *Server) savePlayQueue(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var position int64
+	if qPosition := q.Get("position"); qPosition != "" {
+		p, err := strconv.ParseInt(qPosition, 10, 64)
+		if err != nil || p < 0 {
+			writeXML(w, errGeneric)
+			return
+		}
+		position = p
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		s.logf("error listing files from mpd for saving play queue: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+	files := indexFiles(fs)
+
+	rctx := requestContextFrom(r)
+
+	pq := savedPlayQueue{
+		Files:     make([]string, 0, len(q["id"])),
+		Position:  position,
+		Changed:   time.Now(),
+		ChangedBy: rctx.Client,
+	}
+
+	for _, qID := range q["id"] {
+		f, ok := s.lookupID(files, qID)
+		if !ok || f.Dir {
+			writeXML(w, errNotFound)
+			return
+		}
+		if !s.canAccess(w, rctx.User, f) {
+			return
+		}
+
+		pq.Files = append(pq.Files, f.Name)
+	}
+
+	if qCurrent := q.Get("current"); qCurrent != "" {
+		f, ok := s.lookupID(files, qCurrent)
+		if !ok || f.Dir {
+			writeXML(w, errNotFound)
+			return
+		}
+		if !s.canAccess(w, rctx.User, f) {
+			return
+		}
+
+		pq.Current = f.Name
+	}
+
+	err = s.store.Update(func(d *storeData) error {
+		if len(pq.Files) == 0 {
+			delete(d.PlayQueues, rctx.User)
+			return nil
+		}
+
+		d.PlayQueues[rctx.User] = pq
+		return nil
+	})
+	if err != nil {
+		s.logf("error saving play queue for %q: %v", rctx.User, err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	// Removing the saved queue leaves MPD's queue alone, as does saving the
+	// queue of a user who may not control MPD's playback
+	u, ok := s.lookupUser(rctx.User)
+	if s.cfg.MirrorPlayQueue && len(pq.Files) > 0 && ok && jukeboxRole(u.Roles) {
+		if err := s.mirrorPlayQueue(pq); err != nil {
+			s.logf("error mirroring play queue for %q to mpd: %v", rctx.User, err)
+			writeXML(w, errMPD(err))
+			return
+		}
+	}
+
+	writeXML(w, nil)
+}
+
+// mirrorPlayQueue replaces MPD's queue with the songs in a saved play queue,
+// without starting playback.  Clients save their queues every few seconds
+// to update the position, so MPD's queue is left alone if it already
+// contains the same songs, rather than interrupting playback.
+func (s *Server) mirrorPlayQueue(pq savedPlayQueue) error {
+	if s.player == nil {
+		s.logf("mirroring play queues is not supported by the backing database")
+		return nil
+	}
+
+	current, err := s.player.PlaylistInfo(-1, -1)
+	if err != nil {
+		return err
+	}
+	if sameQueue(current, pq.Files) {
+		return nil
+	}
+
+	if err := s.player.Clear(); err != nil {
+		return err
+	}
+
+	for _, f := range pq.Files {
+		if _, err := s.player.AddID(f, -1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sameQueue reports whether the songs in MPD's queue are files, in order.
+func sameQueue(queue []mpd.Attrs, files []string) bool {
+	if len(queue) != len(files) {
+		return false
+	}
+
+	for i := range queue {
+		if queue[i]["file"] != files[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getPlayQueue is used in Subsonic to retrieve the play queue saved by the
+// user.  Songs which are no longer in MPD's database, or are no longer
+// visible to the user, are omitted.  If no queue is saved, no play queue is
+// returned.
+func (s *Server) getPlayQueue(w http.ResponseWriter, r *http.Request) {
+	user := requestContextFrom(r).User
+
+	var (
+		pq savedPlayQueue
+		ok bool
+	)
+	s.store.View(func(d *storeData) {
+		pq, ok = d.PlayQueues[user]
+	})
+	if !ok {
+		writeXML(w, nil)
+		return
+	}
+
+	songs := make([]mpd.Attrs, 0, len(pq.Files))
+	for _, name := range pq.Files {
+		a, err := s.songAttrs(name)
+		if err != nil {
+			s.logf("error retrieving play queue songs from mpd: %v", err)
+			writeXML(w, errMPD(err))
+			return
+		}
+
+		songs = append(songs, a)
+	}
+
+	children, err := s.songChildren(user, songs)
+	if err != nil {
+		s.logf("error retrieving play queue songs from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	out := &playQueue{
+		Username:  user,
+		Changed:   formatTime(pq.Changed),
+		ChangedBy: pq.ChangedBy,
+		Entries:   make([]entry, 0, len(children)),
+	}
+	for _, c := range children {
+		out.Entries = append(out.Entries, entry{child: c})
+
+		if pq.Current != "" && c.Path == pq.Current {
+			out.Current = c.ID
+			out.Position = pq.Position
+		}
+	}
+
+	writeXML(w, func(c *container) {
+		c.PlayQueue = out
+	})
+}
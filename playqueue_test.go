@@ -0,0 +1,200 @@
+package mpdsub
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_playQueue(t *testing.T) {
+	p := newMemoryPlayer()
+	p.memoryDatabase = &memoryDatabase{
+		files: []string{
+			"Artist/Album/bar.mp3",
+			"Artist/Album/foo.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "Artist/Album/bar.mp3", "Title": "Bar"},
+			{"file": "Artist/Album/foo.mp3", "Title": "Foo"},
+		},
+	}
+	p.queue = []string{"Artist/Album/bar.mp3"}
+
+	cfg, values := configAuth()
+	cfg.MirrorPlayQueue = true
+	values.Set("c", "DSub")
+
+	// params copies values and adds additional query parameters
+	params := func(extra url.Values) url.Values {
+		v := make(url.Values, len(values))
+		for k, vv := range values {
+			v[k] = vv
+		}
+		for k, vv := range extra {
+			v[k] = vv
+		}
+		return v
+	}
+
+	withServer(t, p, nil, cfg, func(base string) {
+		errs := []struct {
+			name   string
+			params url.Values
+			code   int
+		}{
+			{
+				name:   "invalid position",
+				params: url.Values{"id": {"2"}, "position": {"-1"}},
+				code:   codeGeneric,
+			},
+			{
+				name:   "directory",
+				params: url.Values{"id": {"1"}},
+				code:   codeNotFound,
+			},
+			{
+				name:   "unknown current",
+				params: url.Values{"id": {"2"}, "current": {"9"}},
+				code:   codeNotFound,
+			},
+		}
+
+		for _, tt := range errs {
+			t.Run(tt.name, func(t *testing.T) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/savePlayQueue.view", params(tt.params)))
+				if c.Error == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+
+				if want, got := tt.code, c.Error.Code; want != got {
+					t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		}
+
+		// No queue is returned until one is saved
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getPlayQueue.view", values))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+		if c.PlayQueue != nil {
+			t.Fatalf("unexpected play queue: %+v", c.PlayQueue)
+		}
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/savePlayQueue.view", params(url.Values{
+			"id":       {"3", "2"},
+			"current":  {"2"},
+			"position": {"12345"},
+		})))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+
+		// The saved queue replaces MPD's queue
+		if want, got := 2, len(p.queue); want != got {
+			t.Fatalf("unexpected MPD queue length:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := "Artist/Album/foo.mp3", p.queue[0]; want != got {
+			t.Fatalf("unexpected first song in MPD queue:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		// Saving the same songs again only updates the position, and
+		// does not interrupt playback
+		p.playing = 1
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/savePlayQueue.view", params(url.Values{
+			"id":       {"3", "2"},
+			"current":  {"2"},
+			"position": {"12345"},
+		})))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+		if want, got := 1, p.playing; want != got {
+			t.Fatalf("unexpected playing song:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getPlayQueue.view", values))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+
+		pq := c.PlayQueue
+		if pq == nil {
+			t.Fatal("no play queue in response")
+		}
+		if want, got := "2", pq.Current; want != got {
+			t.Fatalf("unexpected current song:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := int64(12345), pq.Position; want != got {
+			t.Fatalf("unexpected position:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := "DSub", pq.ChangedBy; want != got {
+			t.Fatalf("unexpected changed by:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := 2, len(pq.Entries); want != got {
+			t.Fatalf("unexpected number of entries:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := "Foo", pq.Entries[0].Title; want != got {
+			t.Fatalf("unexpected first entry:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		// Saving an empty queue removes the saved queue
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/savePlayQueue.view", values))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getPlayQueue.view", values))
+		if c.PlayQueue != nil {
+			t.Fatalf("unexpected play queue after removal: %+v", c.PlayQueue)
+		}
+	})
+}
+
+func TestServer_savePlayQueueNoJukebox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-playqueue")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := newMemoryPlayer()
+	p.memoryDatabase = &memoryDatabase{
+		files: []string{"foo.mp3"},
+	}
+	p.queue = []string{"bar.mp3"}
+
+	cfg, values := configAuth()
+	cfg.MirrorPlayQueue = true
+	cfg.StateFile = filepath.Join(dir, "state.json")
+
+	s, err := newServer(p, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if err := s.addUser(user{Name: "none", Password: "none"}); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	values.Set("u", "none")
+	values.Set("p", "none")
+	values.Set("id", "0")
+
+	withServer(t, p, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/savePlayQueue.view", values))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+	})
+
+	// The queue is saved, but MPD's queue is left alone
+	if want, got := []string{"bar.mp3"}, p.queue; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected MPD queue:\n- want: %v\n-  got: %v", want, got)
+	}
+}
@@ -0,0 +1,98 @@
+package mpdsub
+
+import (
+	"strings"
+	"time"
+)
+
+// playStats are the number of times a user played a song, and the time of
+// the most recent play.  Unlike listening history, play statistics are kept
+// indefinitely.
+type playStats struct {
+	Count int       `json:"count"`
+	Last  time.Time `json:"last"`
+}
+
+// add adds the plays in ps to the statistics.
+func (p *playStats) add(ps playStats) {
+	p.Count += ps.Count
+	if ps.Last.After(p.Last) {
+		p.Last = ps.Last
+	}
+}
+
+// played formats the time of the most recent play for Subsonic, or returns
+// empty string if the item was never played.
+func (p playStats) played() string {
+	return formatTime(p.Last)
+}
+
+// addPlay records a play by user of the song with key at time t.
+func (d *storeData) addPlay(user, key string, t time.Time) {
+	plays, ok := d.Plays[user]
+	if !ok {
+		plays = make(map[string]playStats)
+		d.Plays[user] = plays
+	}
+
+	ps := plays[key]
+	ps.add(playStats{Count: 1, Last: t})
+	plays[key] = ps
+}
+
+// playStats returns a copy of the play statistics of user, keyed by itemKey.
+func (s *Server) playStats(user string) map[string]playStats {
+	var out map[string]playStats
+	s.store.View(func(d *storeData) {
+		out = make(map[string]playStats, len(d.Plays[user]))
+		for k, ps := range d.Plays[user] {
+			out[k] = ps
+		}
+	})
+
+	return out
+}
+
+// dirPlayStats sums the play statistics of the songs within the directory
+// with key dir, including any subdirectories.
+func dirPlayStats(stats map[string]playStats, dir string) playStats {
+	var out playStats
+	for k, ps := range stats {
+		if strings.HasPrefix(k, dir+"/") {
+			out.add(ps)
+		}
+	}
+
+	return out
+}
+
+// seedPlayStats builds play statistics from the listening history of users
+// who have history but no statistics, such as after upgrading from a
+// version which only recorded history.
+func (s *Server) seedPlayStats() error {
+	var seed bool
+	s.store.View(func(d *storeData) {
+		for user := range d.History {
+			if _, ok := d.Plays[user]; !ok {
+				seed = true
+			}
+		}
+	})
+	if !seed {
+		return nil
+	}
+
+	return s.store.Update(func(d *storeData) error {
+		for user, history := range d.History {
+			if _, ok := d.Plays[user]; ok {
+				continue
+			}
+
+			for _, e := range history {
+				d.addPlay(user, s.itemKey(e.File), e.Time)
+			}
+		}
+
+		return nil
+	})
+}
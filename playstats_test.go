@@ -0,0 +1,149 @@
+package mpdsub
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_playStats(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Artist/Album/bar.mp3",
+			"Artist/Album/foo.mp3",
+		},
+		attrs: map[string]mpd.Attrs{
+			"Artist/Album/bar.mp3": {},
+			"Artist/Album/foo.mp3": {},
+		},
+		songs: []mpd.Attrs{
+			{"file": "Artist/Album/bar.mp3", "Title": "Bar", "Album": "Album", "duration": "60"},
+			{"file": "Artist/Album/foo.mp3", "Title": "Foo", "Album": "Album", "duration": "60"},
+		},
+	}
+
+	cfg, values := configAuth()
+
+	// params copies values and adds additional query parameters
+	params := func(extra url.Values) url.Values {
+		v := make(url.Values, len(values))
+		for k, vv := range values {
+			v[k] = vv
+		}
+		for k, vv := range extra {
+			v[k] = vv
+		}
+		return v
+	}
+
+	// Foo is played twice, once outside of the history retention window
+	now := time.Now()
+	ms := func(t time.Time) string {
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	}
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/scrobble.view", params(url.Values{
+			"id":   {"3", "3"},
+			"time": {ms(now.Add(-1 * time.Hour)), ms(now.Add(-60 * 24 * time.Hour))},
+		})))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getMusicDirectory.view", params(url.Values{"id": {"1"}})))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+
+		counts := make(map[string]int)
+		for _, ch := range c.MusicDirectory.Children {
+			counts[ch.ID] = ch.PlayCount
+		}
+		if want, got := 0, counts["2"]; want != got {
+			t.Fatalf("unexpected Bar play count:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := 2, counts["3"]; want != got {
+			t.Fatalf("unexpected Foo play count:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getAlbumList2.view", params(url.Values{"type": {"frequent"}})))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+		if len(c.AlbumList2.Albums) != 1 {
+			t.Fatalf("unexpected frequent albums: %+v", c.AlbumList2.Albums)
+		}
+
+		al := c.AlbumList2.Albums[0]
+		if want, got := 2, al.PlayCount; want != got {
+			t.Fatalf("unexpected album play count:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		played, err := time.Parse(time.RFC3339, al.Played)
+		if err != nil {
+			t.Fatalf("failed to parse album played time: %v", err)
+		}
+		if d := now.Add(-1 * time.Hour).Sub(played); d < -time.Second || d > time.Second {
+			t.Fatalf("unexpected album played time: %v", played)
+		}
+	})
+}
+
+func TestServer_seedPlayStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-playstats")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+
+	// Write listening history as an older version would have
+	st, err := openStore(path)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	err = st.Update(func(d *storeData) error {
+		d.History["test"] = []historyEntry{
+			{File: "Album/foo.mp3", Time: time.Now().Add(-2 * time.Hour)},
+			{File: "Album/foo.mp3", Time: time.Now().Add(-1 * time.Hour)},
+		}
+		d.Plays = nil
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to update store: %v", err)
+	}
+
+	db := &memoryDatabase{
+		files: []string{"Album/foo.mp3"},
+		songs: []mpd.Attrs{{"file": "Album/foo.mp3", "Title": "Foo"}},
+	}
+
+	cfg, values := configAuth()
+	cfg.StateFile = path
+	values.Set("type", "recent")
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getAlbumList.view", values))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+		if len(c.AlbumList.Albums) != 1 {
+			t.Fatalf("unexpected recent albums: %+v", c.AlbumList.Albums)
+		}
+
+		if want, got := 2, c.AlbumList.Albums[0].PlayCount; want != got {
+			t.Fatalf("unexpected album play count:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
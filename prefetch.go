@@ -0,0 +1,166 @@
+package mpdsub
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sync"
+)
+
+// getPrefetchInfo is a custom endpoint which reports the artwork and lyrics
+// available for a batch of items, using repeated id parameters, so that a
+// client syncing a library for offline playback need not probe getCoverArt
+// for each item.  The ETag of each item's artwork matches the ETag returned
+// by getCoverArt, so clients can skip downloading artwork they already have.
+//
+// IDs which are unknown or not visible to the user are omitted from the
+// response, and items whose artwork cannot be retrieved carry an error
+// detail, so a single stale ID or broken file does not fail the whole
+// batch.  The ETag and size of artwork are remembered once it has been
+// retrieved, so repeated syncs need not load the artwork again.
+func (s *Server) getPrefetchInfo(w http.ResponseWriter, r *http.Request) {
+	qIDs := r.URL.Query()["id"]
+	if len(qIDs) == 0 {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		s.logf("error listing files from mpd for prefetch info: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+	files := indexFiles(fs)
+
+	user := requestContextFrom(r).User
+
+	items := make([]prefetchItem, 0, len(qIDs))
+	for _, qID := range qIDs {
+		f, ok := s.lookupID(files, qID)
+		if !ok || !s.visible(user, f.Name) {
+			continue
+		}
+
+		item := prefetchItem{ID: qID}
+
+		info, err := s.artworkInfo(f)
+		switch err {
+		case nil:
+			item.CoverArt = qID
+			item.CoverArtETag = info.ETag
+			item.CoverArtSize = info.Size
+		case errNoArtwork:
+		default:
+			s.logf("error retrieving cover art for %q: %v", f.Name, err)
+			item.Error = detailArtworkFailed
+		}
+
+		if !f.Dir {
+			_, item.Lyrics = s.lyricsSidecar(f.Name)
+		}
+
+		items = append(items, item)
+	}
+
+	writeXML(w, func(c *container) {
+		c.PrefetchInfo = &prefetchInfoContainer{
+			Items: items,
+		}
+	})
+}
+
+// artworkInfo returns the ETag and size of the original artwork for a file
+// or directory, retrieving the artwork only if they are not already known.
+func (s *Server) artworkInfo(f indexedFile) (artworkInfo, error) {
+	if info, ok := s.artInfo.get(f.Name); ok {
+		return info, nil
+	}
+
+	// coverArt records the information for later requests
+	b, err := s.coverArt(f, 0)
+	if err != nil {
+		return artworkInfo{}, err
+	}
+
+	return newArtworkInfo(b), nil
+}
+
+// artworkInfo is the ETag and size of an item's original artwork.
+type artworkInfo struct {
+	ETag string
+	Size int
+}
+
+// newArtworkInfo computes the artworkInfo of artwork b.
+func newArtworkInfo(b []byte) artworkInfo {
+	return artworkInfo{
+		ETag: artworkETag(b),
+		Size: len(b),
+	}
+}
+
+// An artworkInfoCache remembers the artworkInfo of items whose artwork was
+// retrieved, keyed by name, so that getPrefetchInfo need not load artwork
+// which was already served or prewarmed.  It is cleared when MPD's database
+// is updated, as artwork may have changed.
+//
+// A nil *artworkInfoCache caches nothing.
+type artworkInfoCache struct {
+	mu    sync.RWMutex
+	infos map[string]artworkInfo
+}
+
+// newArtworkInfoCache creates an empty artworkInfoCache.
+func newArtworkInfoCache() *artworkInfoCache {
+	return &artworkInfoCache{
+		infos: make(map[string]artworkInfo),
+	}
+}
+
+// get retrieves the artworkInfo stored for name.
+func (c *artworkInfoCache) get(name string) (artworkInfo, bool) {
+	if c == nil {
+		return artworkInfo{}, false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	info, ok := c.infos[name]
+	return info, ok
+}
+
+// add stores the artworkInfo of artwork b for name.
+func (c *artworkInfoCache) add(name string, b []byte) {
+	if c == nil {
+		return
+	}
+
+	info := newArtworkInfo(b)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.infos[name] = info
+}
+
+// clear forgets all artworkInfo.
+func (c *artworkInfoCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.infos = make(map[string]artworkInfo)
+}
+
+// artworkETag computes a strong ETag for artwork from its contents.
+func artworkETag(b []byte) string {
+	h := fnv.New64a()
+	_, _ = h.Write(b)
+
+	return fmt.Sprintf(`"%016x"`, h.Sum64())
+}
@@ -0,0 +1,237 @@
+package mpdsub
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_getPrefetchInfo(t *testing.T) {
+	jpeg := []byte("\xff\xd8\xff\xe0folder")
+
+	db := &memoryDatabase{
+		files: []string{
+			"foo/foo.mp3",
+			"foo/bar.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "foo/foo.mp3"},
+			{"file": "foo/bar.mp3"},
+		},
+		albumArt: map[string][]byte{
+			"foo/foo.mp3": jpeg,
+		},
+	}
+
+	fs := &memoryFilesystem{
+		files: map[string]*memoryFile{
+			filepath.Join("/music", "foo", "bar.lrc"): {ReadSeeker: bytes.NewReader(nil)},
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.MusicDirectory = "/music"
+	for _, id := range []string{"1", "2", "9"} {
+		values.Add("id", id)
+	}
+
+	withServer(t, db, fs, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getPrefetchInfo.view", values))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %v", c.Error.Message)
+		}
+
+		want := []prefetchItem{
+			{
+				ID:           "1",
+				CoverArt:     "1",
+				CoverArtETag: artworkETag(jpeg),
+				CoverArtSize: len(jpeg),
+			},
+			{
+				ID:     "2",
+				Lyrics: true,
+			},
+		}
+
+		got := c.PrefetchInfo.Items
+		if len(want) != len(got) {
+			t.Fatalf("unexpected number of items:\n- want: %v\n-  got: %v", len(want), len(got))
+		}
+		for i := range want {
+			if want[i] != got[i] {
+				t.Fatalf("unexpected item %d:\n- want: %+v\n-  got: %+v", i, want[i], got[i])
+			}
+		}
+	})
+}
+
+// A brokenArtwork is an artworkSource which fails to retrieve artwork for
+// files named in errs, and counts lookups.
+type brokenArtwork struct {
+	art  map[string][]byte
+	errs map[string]error
+
+	mu      sync.Mutex
+	lookups int
+}
+
+func (a *brokenArtwork) Artwork(name string, dir bool) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.lookups++
+	if err, ok := a.errs[name]; ok {
+		return nil, err
+	}
+	if b, ok := a.art[name]; ok {
+		return b, nil
+	}
+
+	return nil, errNoArtwork
+}
+
+func TestServer_getPrefetchInfoArtworkError(t *testing.T) {
+	jpeg := []byte("\xff\xd8\xff\xe0folder")
+
+	db := &memoryDatabase{
+		files: []string{
+			"foo/foo.mp3",
+			"foo/bar.mp3",
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.Logger = log.New(ioutil.Discard, "", 0)
+	for _, id := range []string{"1", "2"} {
+		values.Add("id", id)
+	}
+
+	s, err := newServer(db, &memoryFilesystem{files: map[string]*memoryFile{}}, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer s.Close()
+
+	src := &brokenArtwork{
+		art:  map[string][]byte{"foo/foo.mp3": jpeg},
+		errs: map[string]error{"foo/bar.mp3": errors.New("broken file")},
+	}
+	s.artworkSources = []artworkSource{src}
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	want := []prefetchItem{
+		{
+			ID:           "1",
+			CoverArt:     "1",
+			CoverArtETag: artworkETag(jpeg),
+			CoverArtSize: len(jpeg),
+		},
+		{
+			ID:    "2",
+			Error: detailArtworkFailed,
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		c := mustDecodeXML(t, testRequest(t, srv.URL, http.MethodGet, "/rest/getPrefetchInfo.view", values))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %v", c.Error.Message)
+		}
+
+		got := c.PrefetchInfo.Items
+		if len(want) != len(got) {
+			t.Fatalf("unexpected number of items:\n- want: %v\n-  got: %v", len(want), len(got))
+		}
+		for i := range want {
+			if want[i] != got[i] {
+				t.Fatalf("unexpected item %d:\n- want: %+v\n-  got: %+v", i, want[i], got[i])
+			}
+		}
+	}
+
+	// The ETag of foo's artwork is remembered, while bar's artwork is
+	// retried by each request
+	if want, got := 3, src.lookups; want != got {
+		t.Fatalf("unexpected number of artwork lookups:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestServer_getPrefetchInfoMissingID(t *testing.T) {
+	cfg, values := configAuth()
+
+	withServer(t, nil, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getPrefetchInfo.view", values))
+		if c.Error == nil {
+			t.Fatal("expected an error, but none occurred")
+		}
+
+		if want, got := codeMissingParameter, c.Error.Code; want != got {
+			t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
+
+func TestServer_getCoverArtRange(t *testing.T) {
+	jpeg := []byte("\xff\xd8\xff\xe0folder")
+
+	db := &memoryDatabase{
+		files: []string{"foo/foo.mp3"},
+		songs: []mpd.Attrs{{"file": "foo/foo.mp3"}},
+		albumArt: map[string][]byte{
+			"foo/foo.mp3": jpeg,
+		},
+	}
+
+	cfg, values := configAuth()
+	values.Set("id", "1")
+
+	withServer(t, db, nil, cfg, func(base string) {
+		req, err := http.NewRequest(http.MethodGet, base+"/rest/getCoverArt.view?"+values.Encode(), nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("If-None-Match", artworkETag(jpeg))
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to perform request: %v", err)
+		}
+		_ = res.Body.Close()
+
+		if want, got := http.StatusNotModified, res.StatusCode; want != got {
+			t.Fatalf("unexpected status code:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		req.Header.Del("If-None-Match")
+		req.Header.Set("Range", "bytes=4-")
+
+		res, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to perform request: %v", err)
+		}
+		defer res.Body.Close()
+
+		if want, got := http.StatusPartialContent, res.StatusCode; want != got {
+			t.Fatalf("unexpected status code:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(res.Body); err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+		if want, got := "folder", buf.String(); want != got {
+			t.Fatalf("unexpected body:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package mpdsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// breakerThreshold is the number of consecutive failed calls to an
+	// external provider after which further calls are rejected.
+	breakerThreshold = 5
+
+	// breakerCooldown is the amount of time for which calls to a failing
+	// external provider are rejected, before a single call is allowed to
+	// test whether the provider has recovered.
+	breakerCooldown = time.Minute
+)
+
+// errProviderUnavailable is returned when calls to an external provider are
+// rejected because the provider has been failing.
+var errProviderUnavailable = errors.New("provider temporarily unavailable")
+
+// A provider guards calls to an external service, such as Last.fm, so that
+// a slow or failing service cannot delay the Server.  Each call is bounded
+// by a timeout, and after breakerThreshold consecutive failures, calls are
+// rejected immediately until breakerCooldown has passed.  Only failures of
+// the service itself count, rather than requestErrors.
+type provider struct {
+	name    string
+	timeout time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// A requestError is an error which a service returned in response to a
+// particular request, such as one with an invalid session key.  The service
+// itself is working, so requestErrors do not trip a provider's breaker.
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+
+// newProvider creates a provider for the named service, which bounds each
+// call by timeout.
+func newProvider(name string, timeout time.Duration) *provider {
+	return &provider{
+		name:    name,
+		timeout: timeout,
+	}
+}
+
+// call invokes fn with a context bounded by the provider's timeout, unless
+// the provider has been failing.
+func (p *provider) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !p.allow(time.Now()) {
+		return fmt.Errorf("%s: %v", p.name, errProviderUnavailable)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	err := fn(ctx)
+
+	_, isRequest := err.(*requestError)
+	p.record(err == nil || isRequest, time.Now())
+	return err
+}
+
+// allow reports whether a call may be made at now.  Once the cooldown has
+// passed, a single call is allowed to probe the provider.
+func (p *provider) allow(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.failures < breakerThreshold {
+		return true
+	}
+	if now.Before(p.openUntil) || p.probing {
+		return false
+	}
+
+	p.probing = true
+	return true
+}
+
+// record records the outcome of a call made at now.
+func (p *provider) record(ok bool, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.probing = false
+	if ok {
+		p.failures = 0
+		return
+	}
+
+	p.failures++
+	if p.failures >= breakerThreshold {
+		p.openUntil = now.Add(breakerCooldown)
+	}
+}
@@ -0,0 +1,68 @@
+package mpdsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_providerBreaker(t *testing.T) {
+	p := newProvider("test", time.Second)
+	now := time.Unix(1, 0)
+
+	for i := 0; i < breakerThreshold; i++ {
+		if !p.allow(now) {
+			t.Fatalf("expected call %d to be allowed", i)
+		}
+		p.record(false, now)
+	}
+
+	if p.allow(now) {
+		t.Fatal("expected call to be rejected after repeated failures")
+	}
+
+	// A single call probes the provider once the cooldown has passed
+	now = now.Add(breakerCooldown)
+	if !p.allow(now) {
+		t.Fatal("expected probe to be allowed after cooldown")
+	}
+	if p.allow(now) {
+		t.Fatal("expected only a single probe to be allowed")
+	}
+
+	p.record(true, now)
+	if !p.allow(now) {
+		t.Fatal("expected calls to be allowed after successful probe")
+	}
+}
+
+func Test_providerTimeout(t *testing.T) {
+	p := newProvider("test", 10*time.Millisecond)
+
+	err := p.call(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if want, got := context.DeadlineExceeded, err; want != got {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_providerRequestError(t *testing.T) {
+	p := newProvider("test", time.Second)
+
+	// Errors in response to particular requests do not trip the breaker
+	for i := 0; i < breakerThreshold+1; i++ {
+		err := p.call(context.Background(), func(context.Context) error {
+			return &requestError{err: errors.New("invalid session key")}
+		})
+		if _, ok := err.(*requestError); !ok {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if !p.allow(time.Now()) {
+		t.Fatal("expected calls to be allowed after request errors")
+	}
+}
@@ -0,0 +1,117 @@
+package mpdsub
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// queueSong is a custom endpoint used to add a single song to MPD's own
+// queue, so that it plays on MPD's speakers rather than on the client.  This
+// enables a user to "play on the house speakers" from a phone client without
+// handing over control of playback as with jukeboxControl.
+//
+// By default, the song is added to the end of the queue.  If next is true,
+// it is added after the song which is currently playing, and if play is
+// true, playback skips to it immediately.
+func (s *Server) queueSong(w http.ResponseWriter, r *http.Request) {
+	if s.player == nil {
+		s.logf("queueing songs is not supported by the backing database")
+		writeXML(w, errGeneric)
+		return
+	}
+
+	q := r.URL.Query()
+
+	qID := q.Get("id")
+	if qID == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	var next, play bool
+
+	bools := []struct {
+		name string
+		v    *bool
+	}{
+		{name: "next", v: &next},
+		{name: "play", v: &play},
+	}
+
+	for _, b := range bools {
+		qv := q.Get(b.name)
+		if qv == "" {
+			continue
+		}
+
+		v, err := strconv.ParseBool(qv)
+		if err != nil {
+			writeXML(w, errGeneric)
+			return
+		}
+		*b.v = v
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		s.logf("error listing files from mpd for queueing: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	f, ok := s.lookupID(indexFiles(fs), qID)
+	if !ok || f.Dir {
+		writeXML(w, errNotFound)
+		return
+	}
+	if !s.canAccess(w, requestContextFrom(r).User, f) {
+		return
+	}
+
+	pos, err := s.queuePosition(next)
+	if err != nil {
+		s.logf("error retrieving status from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	id, err := s.player.AddID(f.Name, pos)
+	if err != nil {
+		s.logf("error adding %q to mpd queue: %v", f.Name, err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	if play {
+		if err := s.player.PlayID(id); err != nil {
+			s.logf("error playing %q from mpd queue: %v", f.Name, err)
+			writeXML(w, errMPD(err))
+			return
+		}
+	}
+
+	writeXML(w, nil)
+}
+
+// queuePosition returns the position in MPD's queue at which to add a song.
+// If next is true, the position follows the song which is currently playing,
+// if any.  Otherwise, -1 is returned, and the song is added to the end of
+// the queue.
+func (s *Server) queuePosition(next bool) (int, error) {
+	if !next {
+		return -1, nil
+	}
+
+	status, err := s.player.Status()
+	if err != nil {
+		return 0, err
+	}
+
+	// MPD omits the current song when the queue is empty or stopped
+	pos, err := strconv.Atoi(status["song"])
+	if err != nil {
+		return -1, nil
+	}
+
+	return pos + 1, nil
+}
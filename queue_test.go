@@ -0,0 +1,118 @@
+package mpdsub
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestServer_queueSong(t *testing.T) {
+	tests := []struct {
+		name    string
+		noQueue bool
+		values  map[string]string
+		queue   []string
+		playing int
+		err     bool
+		code    int
+	}{
+		{
+			name:    "not supported",
+			noQueue: true,
+			values:  map[string]string{"id": "1"},
+			err:     true,
+			code:    codeGeneric,
+		},
+		{
+			name: "missing ID",
+			err:  true,
+			code: codeMissingParameter,
+		},
+		{
+			name:   "unknown ID",
+			values: map[string]string{"id": "9"},
+			err:    true,
+			code:   codeNotFound,
+		},
+		{
+			name:   "directory",
+			values: map[string]string{"id": "0"},
+			err:    true,
+			code:   codeNotFound,
+		},
+		{
+			name: "invalid next",
+			values: map[string]string{
+				"id":   "1",
+				"next": "foo",
+			},
+			err:  true,
+			code: codeGeneric,
+		},
+		{
+			name:    "append",
+			values:  map[string]string{"id": "1"},
+			queue:   []string{"a.mp3", "b.mp3", "Album/foo.mp3"},
+			playing: -1,
+		},
+		{
+			name: "next and play",
+			values: map[string]string{
+				"id":   "1",
+				"next": "true",
+				"play": "true",
+			},
+			queue:   []string{"a.mp3", "Album/foo.mp3", "b.mp3"},
+			playing: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newMemoryPlayer()
+			p.files = []string{"Album/foo.mp3"}
+			p.queue = []string{"a.mp3", "b.mp3"}
+			p.song = 0
+
+			var db database = p
+			if tt.noQueue {
+				db = p.memoryDatabase
+			}
+
+			cfg, values := configAuth()
+			for k, v := range tt.values {
+				values.Set(k, v)
+			}
+
+			withServer(t, db, nil, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/queueSong.view", values))
+
+				if tt.err {
+					if c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+
+					if want, got := tt.code, c.Error.Code; want != got {
+						t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v",
+							want, got)
+					}
+
+					return
+				}
+
+				if c.Error != nil {
+					t.Fatalf("unexpected error: %v", c.Error.Message)
+				}
+
+				if want, got := tt.queue, p.queue; !reflect.DeepEqual(want, got) {
+					t.Fatalf("unexpected queue:\n- want: %v\n-  got: %v",
+						want, got)
+				}
+				if want, got := tt.playing, p.playing; want != got {
+					t.Fatalf("unexpected playing song:\n- want: %v\n-  got: %v",
+						want, got)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package mpdsub
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+const (
+	// defaultRandomSongs is the number of random songs returned when a client
+	// does not specify a size.
+	defaultRandomSongs = 10
+
+	// defaultMaxRandomSongs is the default maximum number of random songs
+	// returned for a single request.
+	defaultMaxRandomSongs = 500
+)
+
+// maxRandomSongs returns the maximum number of random songs returned for a
+// single request.
+func (s *Server) maxRandomSongs() int {
+	if s.cfg.MaxRandomSongs > 0 {
+		return s.cfg.MaxRandomSongs
+	}
+
+	return defaultMaxRandomSongs
+}
+
+// A randomSongsQuery specifies the filters applied to random songs.
+type randomSongsQuery struct {
+	User     string
+	Size     int
+	Genre    string
+	FromYear int
+	ToYear   int
+	Folder   int
+}
+
+// parseRandomSongsQuery parses the parameters of a getRandomSongs request.
+// If a parameter is invalid, false is returned.
+func (s *Server) parseRandomSongsQuery(r *http.Request) (randomSongsQuery, bool) {
+	q := r.URL.Query()
+
+	rq := randomSongsQuery{
+		User:   requestContextFrom(r).User,
+		Size:   defaultRandomSongs,
+		Genre:  q.Get("genre"),
+		Folder: musicFolderAll,
+	}
+
+	ints := []struct {
+		name string
+		v    *int
+	}{
+		{name: "size", v: &rq.Size},
+		{name: "fromYear", v: &rq.FromYear},
+		{name: "toYear", v: &rq.ToYear},
+		{name: "musicFolderId", v: &rq.Folder},
+	}
+
+	for _, i := range ints {
+		qv := q.Get(i.name)
+		if qv == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(qv)
+		if err != nil {
+			return rq, false
+		}
+		*i.v = n
+	}
+
+	if rq.Size < 0 {
+		return rq, false
+	}
+
+	// Cap large requests so they cannot stall the Server
+	if max := s.maxRandomSongs(); rq.Size > max {
+		rq.Size = max
+	}
+
+	return rq, true
+}
+
+// getRandomSongs is used in Subsonic to retrieve random songs, optionally
+// filtered by genre, release year, and music folder.
+func (s *Server) getRandomSongs(w http.ResponseWriter, r *http.Request) {
+	rq, ok := s.parseRandomSongsQuery(r)
+	if !ok {
+		writeXML(w, errGeneric)
+		return
+	}
+
+	songs, err := s.randomSongs(rq)
+	if err != nil {
+		s.logf("error retrieving random songs from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	children, err := s.songChildren(requestContextFrom(r).User, songs)
+	if err != nil {
+		s.logf("error retrieving random songs from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	out := make([]song, 0, len(children))
+	for _, c := range children {
+		out = append(out, song{child: c})
+	}
+
+	writeXML(w, func(c *container) {
+		c.RandomSongs = &randomSongsContainer{
+			Songs: out,
+		}
+	})
+}
+
+// randomSongs selects random songs matching a randomSongsQuery.
+func (s *Server) randomSongs(rq randomSongsQuery) ([]mpd.Attrs, error) {
+	// Narrow the MPD query as much as possible: search for the genre and
+	// its aliases if one is specified, or list only a single top-level
+	// directory if a top-level music folder is specified
+	var (
+		songs []mpd.Attrs
+		err   error
+	)
+	switch {
+	case rq.Genre != "":
+		songs, err = s.searchGenres(append([]string{rq.Genre}, s.genres.Sources(rq.Genre)...))
+	case s.cfg.TopLevelFolders && rq.Folder > musicFolderExcluded:
+		var dir string
+		dir, err = s.topLevelFolderDir(rq.Folder)
+		if err == nil && dir != "" {
+			songs, err = s.db.ListAllInfo(dir)
+		}
+	default:
+		songs, err = s.db.ListAllInfo("")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]mpd.Attrs, 0, len(songs))
+	for _, sg := range songs {
+		if sg["file"] == "" || !s.inMusicFolder(sg["file"], rq.Folder) {
+			continue
+		}
+
+		// Hide songs before sampling, so hidden songs do not reduce the
+		// number of songs returned
+		if !s.visible(rq.User, sg["file"]) || s.filteredGenre(rq.User, sg["Genre"]) {
+			continue
+		}
+
+		// MPD searches are substring matches of raw tags, so verify the
+		// normalized genres match exactly
+		if rq.Genre != "" && !s.hasGenre(sg["Genre"], rq.Genre) {
+			continue
+		}
+
+		year := leadingInt(sg["Date"])
+		if rq.FromYear > 0 && year < rq.FromYear {
+			continue
+		}
+		if rq.ToYear > 0 && (year == 0 || year > rq.ToYear) {
+			continue
+		}
+
+		matches = append(matches, sg)
+	}
+
+	// Partially shuffle only as many songs as are needed
+	n := rq.Size
+	if n > len(matches) {
+		n = len(matches)
+	}
+	for i := 0; i < n; i++ {
+		j := i + rand.Intn(len(matches)-i)
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+
+	return matches[:n], nil
+}
+
+// hasGenre reports whether a raw genre tag contains genre, after
+// normalization.
+func (s *Server) hasGenre(raw string, genre string) bool {
+	for _, g := range s.genres.Normalize(raw) {
+		if strings.EqualFold(g, genre) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// topLevelFolderDir returns the name of the top-level directory with the
+// specified music folder ID, or empty string if no directory has the ID.
+func (s *Server) topLevelFolderDir(folder int) (string, error) {
+	fs, err := s.db.List("file")
+	if err != nil {
+		return "", err
+	}
+
+	for _, f := range fs {
+		if d := topLevelDir(f); d != f && topLevelFolderID(d) == folder {
+			return d, nil
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,159 @@
+package mpdsub
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_getRandomSongs(t *testing.T) {
+	songs := []mpd.Attrs{
+		{"file": "Music/a.mp3", "Title": "a", "Genre": "Rock", "Date": "1985"},
+		{"file": "Music/b.mp3", "Title": "b", "Genre": "Rock; Metal", "Date": "1995-03-01"},
+		{"file": "Music/c.mp3", "Title": "c", "Genre": "Jazz", "Date": "2005"},
+		{"file": "Music/d.mp3", "Title": "d", "Genre": "Rock and Roll"},
+		{"file": "Podcasts/e.mp3", "Title": "e", "Genre": "Rock", "Date": "2015"},
+		{"file": "Music/f.mp3", "Title": "f", "Genre": "Heavy"},
+	}
+
+	files := make([]string, 0, len(songs))
+	for _, s := range songs {
+		files = append(files, s["file"])
+	}
+
+	db := &memoryDatabase{
+		files: files,
+		songs: songs,
+		searches: map[string][]mpd.Attrs{
+			// MPD searches match substrings
+			"genre Rock":  {songs[0], songs[1], songs[3], songs[4]},
+			"genre heavy": {songs[5]},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		cfg    func(cfg *Config)
+		values map[string]string
+		titles []string
+		count  int
+		err    bool
+	}{
+		{
+			name:   "all",
+			values: map[string]string{"size": "10"},
+			titles: []string{"a", "b", "c", "d", "e", "f"},
+		},
+		{
+			name: "genre",
+			cfg: func(cfg *Config) {
+				cfg.GenreSeparators = ";"
+			},
+			values: map[string]string{"genre": "Rock"},
+			titles: []string{"a", "b", "e"},
+		},
+		{
+			name: "genre alias",
+			cfg: func(cfg *Config) {
+				cfg.GenreAliases = map[string]string{"Heavy": "Rock"}
+			},
+			values: map[string]string{"genre": "Rock"},
+			titles: []string{"a", "e", "f"},
+		},
+		{
+			name:   "years",
+			values: map[string]string{"fromYear": "1990", "toYear": "2010"},
+			titles: []string{"b", "c"},
+		},
+		{
+			name: "top-level folder",
+			cfg: func(cfg *Config) {
+				cfg.TopLevelFolders = true
+			},
+			values: map[string]string{
+				"musicFolderId": strconv.Itoa(topLevelFolderID("Podcasts")),
+			},
+			titles: []string{"e"},
+		},
+		{
+			name: "excluded",
+			cfg: func(cfg *Config) {
+				cfg.ExcludePatterns = []string{"Podcasts"}
+				cfg.GenreSeparators = ";"
+			},
+			values: map[string]string{"genre": "Rock"},
+			titles: []string{"a", "b"},
+		},
+		{
+			name:   "size",
+			values: map[string]string{"size": "3"},
+			count:  3,
+		},
+		{
+			name: "capped size",
+			cfg: func(cfg *Config) {
+				cfg.MaxRandomSongs = 2
+			},
+			values: map[string]string{"size": "10000"},
+			count:  2,
+		},
+		{
+			name:   "bad size",
+			values: map[string]string{"size": "foo"},
+			err:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			if tt.cfg != nil {
+				tt.cfg(cfg)
+			}
+			for k, v := range tt.values {
+				values.Set(k, v)
+			}
+
+			withServer(t, db, nil, cfg, func(base string) {
+				res := testRequest(t, base, http.MethodGet, "/rest/getRandomSongs.view", values)
+				c := mustDecodeXML(t, res)
+
+				if tt.err {
+					if c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+
+					return
+				}
+
+				if c.RandomSongs == nil {
+					t.Fatal("no random songs in response")
+				}
+
+				if tt.titles == nil {
+					if want, got := tt.count, len(c.RandomSongs.Songs); want != got {
+						t.Fatalf("unexpected number of songs:\n- want: %v\n-  got: %v",
+							want, got)
+					}
+
+					return
+				}
+
+				var titles []string
+				for _, s := range c.RandomSongs.Songs {
+					titles = append(titles, s.Title)
+				}
+				sort.Strings(titles)
+
+				if want, got := strings.Join(tt.titles, ","), strings.Join(titles, ","); want != got {
+					t.Fatalf("unexpected songs:\n- want: %v\n-  got: %v",
+						want, got)
+				}
+			})
+		})
+	}
+}
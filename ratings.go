@@ -0,0 +1,166 @@
+package mpdsub
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ratingSticker is the name of the MPD sticker which stores a song's
+	// rating, so ratings are shared with other MPD clients.
+	ratingSticker = "rating"
+
+	// maxRating is the maximum rating of a song in Subsonic.
+	maxRating = 5
+)
+
+// setRating is used in Subsonic to rate a song from 1 to 5 stars, or to
+// remove its rating using rating 0.  Ratings are stored in MPD's sticker
+// database, so they survive restarts and are shared by all users and other
+// MPD clients.  MPD only supports stickers on songs, so albums and artists
+// cannot be rated.
+func (s *Server) setRating(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	qID, qRating := q.Get("id"), q.Get("rating")
+	if qID == "" || qRating == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	rating, err := strconv.Atoi(qRating)
+	if err != nil || rating < 0 || rating > maxRating {
+		writeXML(w, errGeneric)
+		return
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		s.logf("error listing files from mpd for rating: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	f, ok := s.lookupID(indexFiles(fs), qID)
+	if !ok {
+		writeXML(w, errNotFound)
+		return
+	}
+	if !s.canAccess(w, requestContextFrom(r).User, f) {
+		return
+	}
+	if f.Dir {
+		s.logf("cannot rate directory %q: mpd only supports stickers on songs", f.Name)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	if rating == 0 {
+		err = s.db.StickerDelete(f.Name, ratingSticker)
+
+		// Removing a rating which does not exist is not an error
+		if err != nil && strings.Contains(err.Error(), "no such sticker") {
+			err = nil
+		}
+	} else {
+		err = s.db.StickerSet(f.Name, ratingSticker, strconv.Itoa(rating))
+	}
+	if err != nil {
+		s.logf("error setting rating for %q: %v", f.Name, err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	key := s.itemKey(f.Name)
+	err = s.store.Update(func(d *storeData) error {
+		if rating == 0 {
+			delete(d.Ratings, key)
+		} else {
+			d.Ratings[key] = savedRating{Rating: rating}
+		}
+
+		return nil
+	})
+	if err != nil {
+		s.logf("error storing rating for %q: %v", f.Name, err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	writeXML(w, nil)
+}
+
+// A savedRating is a rating set by a Subsonic client.  Missing is set when
+// the song is no longer in MPD's database.
+type savedRating struct {
+	Rating  int  `json:"rating"`
+	Missing bool `json:"missing,omitempty"`
+}
+
+// relinkRatings restores the ratings of songs which reappear in MPD's
+// database.  MPD discards the stickers of songs which are removed from its
+// database, such as when a drive is temporarily unmounted, so ratings are
+// kept in the store as well, and songs which vanish are marked as missing
+// until they return.  Stars and play statistics are only kept in the store,
+// so they survive without intervention.
+func (s *Server) relinkRatings() error {
+	fs, err := s.db.List("file")
+	if err != nil {
+		return err
+	}
+
+	present := make(map[string]string, len(fs))
+	for _, f := range fs {
+		present[s.itemKey(f)] = f
+	}
+
+	// Only update the store if a song vanished or reappeared, so that
+	// clients' cached responses remain valid
+	var vanished, reappeared []string
+	s.store.View(func(d *storeData) {
+		for k, r := range d.Ratings {
+			_, ok := present[k]
+			switch {
+			case !ok && !r.Missing:
+				vanished = append(vanished, k)
+			case ok && r.Missing:
+				reappeared = append(reappeared, k)
+			}
+		}
+	})
+	if len(vanished) == 0 && len(reappeared) == 0 {
+		return nil
+	}
+
+	var restored []string
+	for _, k := range reappeared {
+		var r savedRating
+		s.store.View(func(d *storeData) {
+			r = d.Ratings[k]
+		})
+
+		if err := s.db.StickerSet(present[k], ratingSticker, strconv.Itoa(r.Rating)); err != nil {
+			s.logf("error restoring rating for %q: %v", present[k], err)
+			continue
+		}
+		restored = append(restored, k)
+	}
+
+	return s.store.Update(func(d *storeData) error {
+		for _, k := range vanished {
+			if r, ok := d.Ratings[k]; ok {
+				r.Missing = true
+				d.Ratings[k] = r
+			}
+		}
+		for _, k := range restored {
+			if r, ok := d.Ratings[k]; ok {
+				r.Missing = false
+				d.Ratings[k] = r
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,160 @@
+package mpdsub
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestServer_setRating(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]string
+		stickers map[string]map[string]string
+		rating   string
+		err      bool
+		code     int
+	}{
+		{
+			name:   "missing rating",
+			values: map[string]string{"id": "1"},
+			err:    true,
+			code:   codeMissingParameter,
+		},
+		{
+			name:   "rating out of range",
+			values: map[string]string{"id": "1", "rating": "6"},
+			err:    true,
+			code:   codeGeneric,
+		},
+		{
+			name:   "unknown ID",
+			values: map[string]string{"id": "9", "rating": "3"},
+			err:    true,
+			code:   codeNotFound,
+		},
+		{
+			name:   "directory",
+			values: map[string]string{"id": "0", "rating": "3"},
+			err:    true,
+			code:   codeGeneric,
+		},
+		{
+			name:   "set",
+			values: map[string]string{"id": "1", "rating": "4"},
+			rating: "4",
+		},
+		{
+			name:   "replace",
+			values: map[string]string{"id": "1", "rating": "2"},
+			stickers: map[string]map[string]string{
+				"foo/foo.mp3": {"rating": "5"},
+			},
+			rating: "2",
+		},
+		{
+			name:   "remove",
+			values: map[string]string{"id": "1", "rating": "0"},
+			stickers: map[string]map[string]string{
+				"foo/foo.mp3": {"rating": "5"},
+			},
+		},
+		{
+			name:   "remove unrated",
+			values: map[string]string{"id": "1", "rating": "0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &memoryDatabase{
+				files:    []string{"foo/foo.mp3"},
+				stickers: tt.stickers,
+			}
+
+			cfg, values := configAuth()
+			for k, v := range tt.values {
+				values.Set(k, v)
+			}
+
+			withServer(t, db, nil, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/setRating.view", values))
+
+				if tt.err {
+					if c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+
+					if want, got := tt.code, c.Error.Code; want != got {
+						t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v",
+							want, got)
+					}
+
+					return
+				}
+
+				if c.Error != nil {
+					t.Fatalf("unexpected error: %v", c.Error.Message)
+				}
+
+				if want, got := tt.rating, db.stickers["foo/foo.mp3"]["rating"]; want != got {
+					t.Fatalf("unexpected rating sticker:\n- want: %q\n-  got: %q",
+						want, got)
+				}
+			})
+		})
+	}
+}
+
+func TestServer_relinkRatings(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{"foo/foo.mp3", "bar/bar.mp3"},
+		stickers: map[string]map[string]string{
+			"foo/foo.mp3": {"rating": "4"},
+		},
+	}
+
+	s, err := newServer(db, nil, &Config{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = s.store.Update(func(d *storeData) error {
+		d.Ratings["foo/foo.mp3"] = savedRating{Rating: 4}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to update store: %v", err)
+	}
+
+	missing := func() bool {
+		var r savedRating
+		s.store.View(func(d *storeData) {
+			r = d.Ratings["foo/foo.mp3"]
+		})
+		return r.Missing
+	}
+
+	// MPD discards the stickers of songs removed from its database
+	db.files = []string{"bar/bar.mp3"}
+	delete(db.stickers, "foo/foo.mp3")
+
+	if err := s.relinkRatings(); err != nil {
+		t.Fatalf("failed to relink ratings: %v", err)
+	}
+	if !missing() {
+		t.Fatal("expected vanished song to be marked missing")
+	}
+
+	db.files = []string{"foo/foo.mp3", "bar/bar.mp3"}
+
+	if err := s.relinkRatings(); err != nil {
+		t.Fatalf("failed to relink ratings: %v", err)
+	}
+	if missing() {
+		t.Fatal("expected reappeared song not to be marked missing")
+	}
+
+	if want, got := "4", db.stickers["foo/foo.mp3"]["rating"]; want != got {
+		t.Fatalf("unexpected restored rating:\n- want: %v\n-  got: %v", want, got)
+	}
+}
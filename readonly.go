@@ -0,0 +1,26 @@
+package mpdsub
+
+import "net/http"
+
+// mutating wraps a handler for an endpoint which modifies MPD or the
+// Server's state, so that it is rejected if the Server is read-only.
+func (s *Server) mutating(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.ReadOnly {
+			writeXML(w, errReadOnly)
+			return
+		}
+
+		fn(w, r)
+	}
+}
+
+// errReadOnly indicates that the requested operation would modify MPD or the
+// Server's state, but the Server is read-only.
+func errReadOnly(c *container) {
+	c.Status = statusFailed
+	c.Error = &subsonicError{
+		Code:    codeNotAuthorized,
+		Message: "The server is read-only.",
+	}
+}
@@ -0,0 +1,62 @@
+package mpdsub
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestServer_readOnly(t *testing.T) {
+	endpoints := []string{
+		"createPlaylist",
+		"deletePlaylist",
+		"jukeboxControl",
+		"outputControl",
+		"queueSong",
+		"savePlayQueue",
+		"setRating",
+		"star",
+		"unstar",
+		"updatePlaylist",
+	}
+
+	cfg, values := configAuth()
+	cfg.ReadOnly = true
+
+	// Parameters are not validated before rejecting the request
+	values.Set("id", "0")
+	values.Set("action", outputActionEnable)
+
+	withServer(t, newMemoryPlayer(), nil, cfg, func(base string) {
+		for _, e := range endpoints {
+			t.Run(e, func(t *testing.T) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/"+e+".view", values))
+				if c.Error == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+
+				if want, got := codeNotAuthorized, c.Error.Code; want != got {
+					t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		}
+
+		t.Run("output status", func(t *testing.T) {
+			values.Set("action", outputActionStatus)
+
+			c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/outputControl.view", values))
+			if c.Error != nil {
+				t.Fatalf("unexpected error: %+v", c.Error)
+			}
+		})
+	})
+}
+
+func TestServer_readOnlyWatch(t *testing.T) {
+	_, err := newServer(&memoryDatabase{}, nil, &Config{
+		ReadOnly:            true,
+		WatchMusicDirectory: true,
+	})
+	if err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+}
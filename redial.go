@@ -0,0 +1,202 @@
+package mpdsub
+
+import (
+	"sync"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// A resetter is a database whose connection to MPD can be replaced, such as
+// when a command times out and its response may still arrive on the
+// connection.
+type resetter interface {
+	Reset() error
+}
+
+var (
+	_ database = &redialClient{}
+	_ player   = &redialClient{}
+	_ resetter = &redialClient{}
+)
+
+// A redialClient is a database and player which sends commands using a
+// *mpd.Client, and which can replace the client with a new connection to
+// MPD.
+type redialClient struct {
+	dial func() (*mpd.Client, error)
+
+	mu sync.Mutex
+	c  *mpd.Client
+}
+
+// newRedialClient creates a redialClient which sends commands using c, and
+// uses dial to replace it.
+func newRedialClient(c *mpd.Client, dial func() (*mpd.Client, error)) *redialClient {
+	return &redialClient{
+		dial: dial,
+		c:    c,
+	}
+}
+
+// client returns the current client.  The lock is not held while a command
+// runs, so that a command which never completes cannot block Reset.
+func (rc *redialClient) client() *mpd.Client {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.c
+}
+
+// Reset dials a new connection to MPD and closes the current connection,
+// which also fails any command still waiting for a response on it.  If the
+// new connection cannot be dialed, the current connection is kept.
+func (rc *redialClient) Reset() error {
+	c, err := rc.dial()
+	if err != nil {
+		return err
+	}
+
+	rc.mu.Lock()
+	old := rc.c
+	rc.c = c
+	rc.mu.Unlock()
+
+	_ = old.Close()
+	return nil
+}
+
+func (rc *redialClient) AlbumArt(uri string) ([]byte, error) {
+	return rc.client().AlbumArt(uri)
+}
+
+func (rc *redialClient) Find(args ...string) ([]mpd.Attrs, error) {
+	return rc.client().Find(args...)
+}
+
+func (rc *redialClient) List(args ...string) ([]string, error) {
+	return rc.client().List(args...)
+}
+
+func (rc *redialClient) ListAllInfo(uri string) ([]mpd.Attrs, error) {
+	return rc.client().ListAllInfo(uri)
+}
+
+func (rc *redialClient) ListPlaylists() ([]mpd.Attrs, error) {
+	return rc.client().ListPlaylists()
+}
+
+func (rc *redialClient) PlaylistAdd(name string, uri string) error {
+	return rc.client().PlaylistAdd(name, uri)
+}
+
+func (rc *redialClient) PlaylistClear(name string) error {
+	return rc.client().PlaylistClear(name)
+}
+
+func (rc *redialClient) PlaylistContents(name string) ([]mpd.Attrs, error) {
+	return rc.client().PlaylistContents(name)
+}
+
+func (rc *redialClient) PlaylistDelete(name string, pos int) error {
+	return rc.client().PlaylistDelete(name, pos)
+}
+
+func (rc *redialClient) PlaylistRemove(name string) error {
+	return rc.client().PlaylistRemove(name)
+}
+
+func (rc *redialClient) PlaylistRename(name, newName string) error {
+	return rc.client().PlaylistRename(name, newName)
+}
+
+func (rc *redialClient) ReadPicture(uri string) ([]byte, error) {
+	return rc.client().ReadPicture(uri)
+}
+
+func (rc *redialClient) ReadComments(uri string) (mpd.Attrs, error) {
+	return rc.client().ReadComments(uri)
+}
+
+func (rc *redialClient) Search(args ...string) ([]mpd.Attrs, error) {
+	return rc.client().Search(args...)
+}
+
+func (rc *redialClient) Stats() (mpd.Attrs, error) {
+	return rc.client().Stats()
+}
+
+func (rc *redialClient) StickerDelete(uri string, name string) error {
+	return rc.client().StickerDelete(uri, name)
+}
+
+func (rc *redialClient) StickerSet(uri string, name string, value string) error {
+	return rc.client().StickerSet(uri, name, value)
+}
+
+func (rc *redialClient) Update(uri string) (int, error) {
+	return rc.client().Update(uri)
+}
+
+func (rc *redialClient) Ping() error {
+	return rc.client().Ping()
+}
+
+func (rc *redialClient) AddID(uri string, pos int) (int, error) {
+	return rc.client().AddID(uri, pos)
+}
+
+func (rc *redialClient) Clear() error {
+	return rc.client().Clear()
+}
+
+func (rc *redialClient) CurrentSong() (mpd.Attrs, error) {
+	return rc.client().CurrentSong()
+}
+
+func (rc *redialClient) Delete(start, end int) error {
+	return rc.client().Delete(start, end)
+}
+
+func (rc *redialClient) DisableOutput(id int) error {
+	return rc.client().DisableOutput(id)
+}
+
+func (rc *redialClient) EnableOutput(id int) error {
+	return rc.client().EnableOutput(id)
+}
+
+func (rc *redialClient) ListOutputs() ([]mpd.Attrs, error) {
+	return rc.client().ListOutputs()
+}
+
+func (rc *redialClient) Pause(pause bool) error {
+	return rc.client().Pause(pause)
+}
+
+func (rc *redialClient) Play(pos int) error {
+	return rc.client().Play(pos)
+}
+
+func (rc *redialClient) PlayID(id int) error {
+	return rc.client().PlayID(id)
+}
+
+func (rc *redialClient) PlaylistInfo(start, end int) ([]mpd.Attrs, error) {
+	return rc.client().PlaylistInfo(start, end)
+}
+
+func (rc *redialClient) Seek(pos, time int) error {
+	return rc.client().Seek(pos, time)
+}
+
+func (rc *redialClient) SetVolume(volume int) error {
+	return rc.client().SetVolume(volume)
+}
+
+func (rc *redialClient) Shuffle(start, end int) error {
+	return rc.client().Shuffle(start, end)
+}
+
+func (rc *redialClient) Status() (mpd.Attrs, error) {
+	return rc.client().Status()
+}
@@ -0,0 +1,25 @@
+package mpdsub
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func Test_redialClientResetFails(t *testing.T) {
+	c := &mpd.Client{}
+	errDial := errors.New("connection refused")
+
+	rc := newRedialClient(c, func() (*mpd.Client, error) {
+		return nil, errDial
+	})
+
+	// The current connection is kept if a new one cannot be dialed
+	if want, got := errDial, rc.Reset(); want != got {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+	}
+	if rc.client() != c {
+		t.Fatal("current connection was replaced")
+	}
+}
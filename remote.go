@@ -0,0 +1,164 @@
+package mpdsub
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// remoteReadTimeout is the maximum amount of time to wait while reading a
+// small file, such as lyrics, from a remote music directory, so that an
+// unresponsive server cannot stall the requests which need the file.
+const remoteReadTimeout = 10 * time.Second
+
+// remoteStreamTimeout is the maximum amount of time to wait while connecting
+// to a remote music directory, and for its response headers, when streaming
+// a file.  Streams may take any amount of time to read once they begin.
+const remoteStreamTimeout = 30 * time.Second
+
+// newStreamClient creates an HTTP client for streaming files from a remote
+// music directory, which gives up on unresponsive servers without limiting
+// the duration of a stream.
+func newStreamClient() *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{Timeout: remoteStreamTimeout}).DialContext
+	t.TLSHandshakeTimeout = remoteStreamTimeout
+	t.ResponseHeaderTimeout = remoteStreamTimeout
+
+	return &http.Client{Transport: t}
+}
+
+// proxyHeaders are the HTTP headers copied from a remote music server's
+// response to a streaming client.
+var proxyHeaders = []string{
+	"Accept-Ranges",
+	"Content-Length",
+	"Content-Range",
+	contentType,
+	"ETag",
+	"Last-Modified",
+}
+
+// parseMusicURL parses and validates a remote music directory URL.
+func parseMusicURL(s string) (*url.URL, error) {
+	return parseBaseURL("music", s)
+}
+
+// parseBaseURL parses and validates a URL to which paths are appended.
+// kind describes the URL in errors.
+func parseBaseURL(kind string, s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%s URL %q must use scheme http or https", kind, s)
+	}
+
+	// Ensure file paths are appended to the URL's path, rather than
+	// replacing its last element
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	return u, nil
+}
+
+// musicPath returns the location of a file in MPD's music directory, either
+// as a URL in the remote music directory or as a local path.
+func (s *Server) musicPath(name string) string {
+	if s.musicURL == nil {
+		return filepath.Join(s.cfg.MusicDirectory, filepath.FromSlash(name))
+	}
+
+	u := *s.musicURL
+	u.Path += name
+	return u.String()
+}
+
+// readMusicFile reads up to max bytes of a file in the local or remote
+// music directory.
+func (s *Server) readMusicFile(name string, max int64) ([]byte, error) {
+	p := s.musicPath(name)
+
+	if s.musicURL == nil {
+		f, err := s.fs.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return ioutil.ReadAll(io.LimitReader(f, max))
+	}
+
+	res, err := s.musicClient.Get(p)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status for %q: %s", p, res.Status)
+	}
+
+	return ioutil.ReadAll(io.LimitReader(res.Body, max))
+}
+
+// proxyStream streams a file from a remote music directory to a client,
+// forwarding any Range headers so clients can seek.
+func (s *Server) proxyStream(w http.ResponseWriter, r *http.Request, u string) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		s.logf("error creating request for remote stream %q: %v", u, err)
+		writeXML(w, errGeneric)
+		return
+	}
+	req = req.WithContext(r.Context())
+
+	for _, h := range []string{"Range", "If-Range"} {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+
+	res, err := s.streamClient.Do(req)
+	if err != nil {
+		s.logf("error requesting remote stream %q: %v", u, err)
+		writeXML(w, errGeneric)
+		return
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+	case http.StatusNotFound:
+		writeXML(w, errNotFound)
+		return
+	default:
+		s.logf("unexpected HTTP status for remote stream %q: %s", u, res.Status)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	for _, h := range proxyHeaders {
+		if v := res.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(res.StatusCode)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if _, err := io.Copy(w, res.Body); err != nil {
+		s.logf("error proxying remote stream %q: %v", u, err)
+	}
+}
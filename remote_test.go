@@ -0,0 +1,196 @@
+package mpdsub
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_streamRemote(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/music/foo bar/baz.mp3" {
+			http.NotFound(w, r)
+			return
+		}
+
+		http.ServeContent(w, r, "baz.mp3", time.Time{}, strings.NewReader("hello world"))
+	}))
+	defer remote.Close()
+
+	db := &memoryDatabase{
+		files: []string{
+			"foo bar/baz.mp3",
+			"foo bar/qux.mp3",
+		},
+	}
+
+	tests := []struct {
+		name  string
+		id    string
+		rng   string
+		code  int
+		body  string
+		error bool
+	}{
+		{
+			name: "OK",
+			id:   "1",
+			code: http.StatusOK,
+			body: "hello world",
+		},
+		{
+			name: "range",
+			id:   "1",
+			rng:  "bytes=6-",
+			code: http.StatusPartialContent,
+			body: "world",
+		},
+		{
+			name:  "not found",
+			id:    "2",
+			code:  http.StatusOK,
+			error: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.MusicURL = remote.URL + "/music"
+			values.Set("id", tt.id)
+
+			withServer(t, db, nil, cfg, func(base string) {
+				req, err := http.NewRequest(http.MethodGet, base+"/rest/stream.view?"+values.Encode(), nil)
+				if err != nil {
+					t.Fatalf("failed to create request: %v", err)
+				}
+				if tt.rng != "" {
+					req.Header.Set("Range", tt.rng)
+				}
+
+				res, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("failed to perform request: %v", err)
+				}
+				defer res.Body.Close()
+
+				if want, got := tt.code, res.StatusCode; want != got {
+					t.Fatalf("unexpected HTTP status code:\n- want: %03d\n-  got: %03d",
+						want, got)
+				}
+
+				if tt.error {
+					if c := mustDecodeXML(t, res); c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+					return
+				}
+
+				b, err := ioutil.ReadAll(res.Body)
+				if err != nil {
+					t.Fatalf("failed to read body: %v", err)
+				}
+
+				if want, got := tt.body, string(b); want != got {
+					t.Fatalf("unexpected body:\n- want: %q\n-  got: %q",
+						want, got)
+				}
+			})
+		})
+	}
+}
+
+func TestServer_readMusicFileTimeout(t *testing.T) {
+	done := make(chan struct{})
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Never respond until the test is complete
+		<-done
+	}))
+	defer remote.Close()
+	defer close(done)
+
+	cfg := &Config{MusicURL: remote.URL + "/music"}
+	s, err := newServer(&memoryDatabase{}, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer s.Close()
+
+	s.musicClient.Timeout = 50 * time.Millisecond
+
+	if _, err := s.readMusicFile("foo.lrc", 1024); err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+}
+
+func TestServer_copyFileRemoteTimeout(t *testing.T) {
+	done := make(chan struct{})
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Never respond until the test is complete
+		<-done
+	}))
+	defer remote.Close()
+	defer close(done)
+
+	cfg := &Config{MusicURL: remote.URL + "/music"}
+	s, err := newServer(&memoryDatabase{}, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer s.Close()
+
+	s.streamClient.Transport.(*http.Transport).ResponseHeaderTimeout = 50 * time.Millisecond
+
+	if err := s.copyFile(ioutil.Discard, "foo.mp3"); err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+}
+
+func Test_parseMusicURL(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		u    string
+		ok   bool
+	}{
+		{
+			name: "bad scheme",
+			s:    "ftp://example.com/music",
+		},
+		{
+			name: "OK",
+			s:    "http://example.com/music",
+			u:    "http://example.com/music/",
+			ok:   true,
+		},
+		{
+			name: "OK trailing slash",
+			s:    "https://example.com/music/",
+			u:    "https://example.com/music/",
+			ok:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := parseMusicURL(tt.s)
+			if err != nil && tt.ok {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && !tt.ok {
+				t.Fatal("expected an error, but none occurred")
+			}
+			if !tt.ok {
+				return
+			}
+
+			if want, got := tt.u, u.String(); want != got {
+				t.Fatalf("unexpected URL:\n- want: %v\n-  got: %v",
+					want, got)
+			}
+		})
+	}
+}
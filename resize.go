@@ -0,0 +1,120 @@
+package mpdsub
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+
+	// Decode GIF artwork
+	_ "image/gif"
+)
+
+const (
+	// resizedJPEGQuality is the quality of JPEG images produced by resizing
+	// artwork.
+	resizedJPEGQuality = 85
+
+	// maxArtworkPixels is the number of pixels in the largest artwork which
+	// will be decoded for resizing, so a small file claiming enormous
+	// dimensions cannot exhaust memory.
+	maxArtworkPixels = 64 << 20
+)
+
+// resizeArtwork scales artwork down so that neither its width nor its height
+// exceed size, preserving its aspect ratio.  PNG images remain PNG images, so
+// transparency is preserved, and other images are encoded as JPEG.  Artwork
+// which is already small enough, which is larger than maxArtworkPixels, or
+// which is in a format which cannot be decoded, is returned unchanged.
+func resizeArtwork(b []byte, size int) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return b, nil
+	}
+
+	w, h := cfg.Width, cfg.Height
+	if w <= size && h <= size {
+		return b, nil
+	}
+	if int64(w)*int64(h) > maxArtworkPixels {
+		return b, nil
+	}
+
+	src, format, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return b, nil
+	}
+
+	if w >= h {
+		w, h = size, max1(h*size/w)
+	} else {
+		w, h = max1(w*size/h), size
+	}
+
+	dst := scaleImage(src, w, h)
+
+	var buf bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&buf, dst)
+	} else {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: resizedJPEGQuality})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// scaleImage scales src down to w by h pixels, averaging the pixels of src
+// which fall within each pixel of the output.
+func scaleImage(src image.Image, w, h int) *image.NRGBA {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := sb.Min.Y+y*sh/h, sb.Min.Y+(y+1)*sh/h
+		if y1 == y0 {
+			y1++
+		}
+
+		for x := 0; x < w; x++ {
+			x0, x1 := sb.Min.X+x*sw/w, sb.Min.X+(x+1)*sw/w
+			if x1 == x0 {
+				x1++
+			}
+
+			// Average premultiplied colors, so that transparent pixels
+			// do not darken their neighbors
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+
+			c := color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			}
+			dst.Set(x, y, c)
+		}
+	}
+
+	return dst
+}
+
+// max1 returns n, or 1 if n is less than 1.
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+
+	return n
+}
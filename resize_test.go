@@ -0,0 +1,129 @@
+package mpdsub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func Test_resizeArtwork(t *testing.T) {
+	tests := []struct {
+		name   string
+		b      []byte
+		size   int
+		format string
+		w, h   int
+	}{
+		{
+			name:   "PNG landscape",
+			b:      testImage(t, "png", 100, 50),
+			size:   20,
+			format: "png",
+			w:      20,
+			h:      10,
+		},
+		{
+			name:   "JPEG portrait",
+			b:      testImage(t, "jpeg", 30, 90),
+			size:   45,
+			format: "jpeg",
+			w:      15,
+			h:      45,
+		},
+		{
+			name:   "already small",
+			b:      testImage(t, "png", 10, 10),
+			size:   64,
+			format: "png",
+			w:      10,
+			h:      10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := resizeArtwork(tt.b, tt.size)
+			if err != nil {
+				t.Fatalf("failed to resize artwork: %v", err)
+			}
+
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+			if err != nil {
+				t.Fatalf("failed to decode resized artwork: %v", err)
+			}
+
+			if want, got := tt.format, format; want != got {
+				t.Fatalf("unexpected format:\n- want: %v\n-  got: %v", want, got)
+			}
+			if want, got := [2]int{tt.w, tt.h}, [2]int{cfg.Width, cfg.Height}; want != got {
+				t.Fatalf("unexpected dimensions:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func Test_resizeArtworkUnknownFormat(t *testing.T) {
+	b := []byte("RIFF\x00\x00\x00\x00WEBP")
+
+	got, err := resizeArtwork(b, 10)
+	if err != nil {
+		t.Fatalf("failed to resize artwork: %v", err)
+	}
+
+	if !bytes.Equal(b, got) {
+		t.Fatalf("unexpected artwork:\n- want: %q\n-  got: %q", b, got)
+	}
+}
+
+func Test_resizeArtworkTooLarge(t *testing.T) {
+	// Claim enormous dimensions in the PNG header without the pixel data to
+	// back them up, which must not be decoded
+	b := testImage(t, "png", 2, 2)
+	ihdr := b[8+8 : 8+8+13]
+	binary.BigEndian.PutUint32(ihdr[0:4], 1<<16)
+	binary.BigEndian.PutUint32(ihdr[4:8], 1<<16)
+	binary.BigEndian.PutUint32(b[8+8+13:], crc32.ChecksumIEEE(b[8+4:8+8+13]))
+
+	if _, err := png.DecodeConfig(bytes.NewReader(b)); err != nil {
+		t.Fatalf("failed to decode image configuration: %v", err)
+	}
+
+	got, err := resizeArtwork(b, 10)
+	if err != nil {
+		t.Fatalf("failed to resize artwork: %v", err)
+	}
+
+	if !bytes.Equal(b, got) {
+		t.Fatalf("unexpected artwork:\n- want: %q\n-  got: %q", b, got)
+	}
+}
+
+// testImage encodes a solid image of the specified dimensions.
+func testImage(t *testing.T, format string, w, h int) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: 0xff, A: 0xff})
+		}
+	}
+
+	var buf bytes.Buffer
+	var err error
+	if format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,229 @@
+package mpdsub
+
+import (
+	"net"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// defaultRetryBackoff is the default initial delay between retries of
+// MPD commands.
+const defaultRetryBackoff = 100 * time.Millisecond
+
+// errTimeout is returned when a MPD command does not complete within the
+// configured timeout.
+var errTimeout = &timeoutError{}
+
+// A timeoutError is a net.Error which indicates a MPD command timed out.
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "timed out waiting for MPD" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
+
+var _ database = &retryDatabase{}
+
+// A retryDatabase is a database which applies a timeout to each command
+// sent to another database, and retries commands which time out.
+//
+// Only timeouts are retried: other errors, such as a closed connection or an
+// error returned by MPD, are returned immediately so that requests fail fast
+// when MPD is down or a command is invalid.  Commands which modify MPD's
+// state are never retried, since a command which timed out may still be
+// applied by MPD: retrying playlistadd could add a song twice, and retrying
+// playlistdelete could remove the wrong song.
+type retryDatabase struct {
+	db      database
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+
+	// reset optionally replaces the connection to MPD after a command times
+	// out, so that the command's late response cannot be read by the next
+	// command, and retries are not queued behind it.
+	reset func() error
+}
+
+// newRetryDatabase wraps db using the timeout and retry policy specified
+// in cfg.
+func newRetryDatabase(db database, cfg *Config) *retryDatabase {
+	backoff := cfg.MPDRetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	return &retryDatabase{
+		db:      db,
+		timeout: cfg.MPDTimeout,
+		retries: cfg.MPDRetries,
+		backoff: backoff,
+	}
+}
+
+// A result is the result of a single database command.
+type result struct {
+	v   interface{}
+	err error
+}
+
+// do invokes fn, applying the timeout and retry policy.
+func (d *retryDatabase) do(fn func() (interface{}, error)) (interface{}, error) {
+	backoff := d.backoff
+
+	var res result
+	for i := 0; i <= d.retries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		res = d.try(fn)
+		if !isTimeout(res.err) {
+			return res.v, res.err
+		}
+	}
+
+	return res.v, res.err
+}
+
+// mutate invokes fn, which modifies MPD's state, applying the timeout but
+// never retrying it.
+func (d *retryDatabase) mutate(fn func() error) error {
+	return d.try(func() (interface{}, error) { return nil, fn() }).err
+}
+
+// try invokes fn once, applying the timeout.  If fn times out, the
+// connection to MPD is reset if possible, and otherwise fn continues to run
+// in the background and its result is discarded.
+func (d *retryDatabase) try(fn func() (interface{}, error)) result {
+	if d.timeout <= 0 {
+		v, err := fn()
+		return result{v: v, err: err}
+	}
+
+	resC := make(chan result, 1)
+	go func() {
+		v, err := fn()
+		resC <- result{v: v, err: err}
+	}()
+
+	timer := time.NewTimer(d.timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-resC:
+		return res
+	case <-timer.C:
+		if d.reset != nil {
+			// The current connection remains in use if it cannot be
+			// replaced, so the timeout is reported either way
+			_ = d.reset()
+		}
+
+		return result{err: errTimeout}
+	}
+}
+
+// isTimeout determines if err indicates a timeout.
+func isTimeout(err error) bool {
+	nerr, ok := err.(net.Error)
+	return ok && nerr.Timeout()
+}
+
+func (d *retryDatabase) AlbumArt(uri string) ([]byte, error) {
+	v, err := d.do(func() (interface{}, error) { return d.db.AlbumArt(uri) })
+	b, _ := v.([]byte)
+	return b, err
+}
+
+func (d *retryDatabase) Find(args ...string) ([]mpd.Attrs, error) {
+	return d.attrsList(func() ([]mpd.Attrs, error) { return d.db.Find(args...) })
+}
+
+func (d *retryDatabase) List(args ...string) ([]string, error) {
+	v, err := d.do(func() (interface{}, error) { return d.db.List(args...) })
+	ss, _ := v.([]string)
+	return ss, err
+}
+
+func (d *retryDatabase) ListAllInfo(uri string) ([]mpd.Attrs, error) {
+	return d.attrsList(func() ([]mpd.Attrs, error) { return d.db.ListAllInfo(uri) })
+}
+
+func (d *retryDatabase) ListPlaylists() ([]mpd.Attrs, error) {
+	return d.attrsList(d.db.ListPlaylists)
+}
+
+func (d *retryDatabase) PlaylistAdd(name string, uri string) error {
+	return d.mutate(func() error { return d.db.PlaylistAdd(name, uri) })
+}
+
+func (d *retryDatabase) PlaylistClear(name string) error {
+	return d.mutate(func() error { return d.db.PlaylistClear(name) })
+}
+
+func (d *retryDatabase) PlaylistContents(name string) ([]mpd.Attrs, error) {
+	return d.attrsList(func() ([]mpd.Attrs, error) { return d.db.PlaylistContents(name) })
+}
+
+func (d *retryDatabase) PlaylistDelete(name string, pos int) error {
+	return d.mutate(func() error { return d.db.PlaylistDelete(name, pos) })
+}
+
+func (d *retryDatabase) PlaylistRemove(name string) error {
+	return d.mutate(func() error { return d.db.PlaylistRemove(name) })
+}
+
+func (d *retryDatabase) PlaylistRename(name, newName string) error {
+	return d.mutate(func() error { return d.db.PlaylistRename(name, newName) })
+}
+
+func (d *retryDatabase) ReadPicture(uri string) ([]byte, error) {
+	v, err := d.do(func() (interface{}, error) { return d.db.ReadPicture(uri) })
+	b, _ := v.([]byte)
+	return b, err
+}
+
+func (d *retryDatabase) ReadComments(uri string) (mpd.Attrs, error) {
+	v, err := d.do(func() (interface{}, error) { return d.db.ReadComments(uri) })
+	attrs, _ := v.(mpd.Attrs)
+	return attrs, err
+}
+
+func (d *retryDatabase) Search(args ...string) ([]mpd.Attrs, error) {
+	return d.attrsList(func() ([]mpd.Attrs, error) { return d.db.Search(args...) })
+}
+
+func (d *retryDatabase) Stats() (mpd.Attrs, error) {
+	v, err := d.do(func() (interface{}, error) { return d.db.Stats() })
+	attrs, _ := v.(mpd.Attrs)
+	return attrs, err
+}
+
+func (d *retryDatabase) StickerDelete(uri string, name string) error {
+	return d.mutate(func() error { return d.db.StickerDelete(uri, name) })
+}
+
+func (d *retryDatabase) StickerSet(uri string, name string, value string) error {
+	return d.mutate(func() error { return d.db.StickerSet(uri, name, value) })
+}
+
+func (d *retryDatabase) Update(uri string) (int, error) {
+	// Update is never retried, so that MPD does not queue duplicate updates
+	res := d.try(func() (interface{}, error) { return d.db.Update(uri) })
+	id, _ := res.v.(int)
+	return id, res.err
+}
+
+func (d *retryDatabase) Ping() error {
+	_, err := d.do(func() (interface{}, error) { return nil, d.db.Ping() })
+	return err
+}
+
+// attrsList invokes a database command which returns a list of attributes.
+func (d *retryDatabase) attrsList(fn func() ([]mpd.Attrs, error)) ([]mpd.Attrs, error) {
+	v, err := d.do(func() (interface{}, error) { return fn() })
+	attrs, _ := v.([]mpd.Attrs)
+	return attrs, err
+}
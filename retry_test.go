@@ -0,0 +1,185 @@
+package mpdsub
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_retryDatabase(t *testing.T) {
+	errMPD := errors.New("no such playlist")
+
+	tests := []struct {
+		name    string
+		retries int
+		delays  []time.Duration
+		errs    []error
+
+		files []string
+		err   error
+		calls int
+	}{
+		{
+			name:   "OK",
+			delays: []time.Duration{0},
+			errs:   []error{nil},
+			files:  []string{"foo.mp3"},
+			calls:  1,
+		},
+		{
+			name:    "MPD error is not retried",
+			retries: 3,
+			delays:  []time.Duration{0},
+			errs:    []error{errMPD},
+			err:     errMPD,
+			calls:   1,
+		},
+		{
+			name:    "timeout, then OK",
+			retries: 1,
+			delays:  []time.Duration{time.Second, 0},
+			errs:    []error{nil, nil},
+			files:   []string{"foo.mp3"},
+			calls:   2,
+		},
+		{
+			name:    "timeout, retries exhausted",
+			retries: 1,
+			delays:  []time.Duration{time.Second, time.Second},
+			errs:    []error{nil, nil},
+			err:     errTimeout,
+			calls:   2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &slowDatabase{
+				delays: tt.delays,
+				errs:   tt.errs,
+				files:  []string{"foo.mp3"},
+			}
+
+			rdb := newRetryDatabase(db, &Config{
+				MPDTimeout:      20 * time.Millisecond,
+				MPDRetries:      tt.retries,
+				MPDRetryBackoff: time.Millisecond,
+			})
+
+			files, err := rdb.List("file")
+			if want, got := tt.err, err; want != got {
+				t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+			}
+
+			if want, got := tt.files, files; !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected files:\n- want: %v\n-  got: %v", want, got)
+			}
+
+			if want, got := tt.calls, db.Calls(); want != got {
+				t.Fatalf("unexpected number of calls:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func Test_retryDatabaseMutation(t *testing.T) {
+	db := &slowDatabase{
+		delays: []time.Duration{time.Second, 0},
+		errs:   []error{nil, nil},
+	}
+
+	rdb := newRetryDatabase(db, &Config{
+		MPDTimeout:      20 * time.Millisecond,
+		MPDRetries:      3,
+		MPDRetryBackoff: time.Millisecond,
+	})
+
+	// The command may still be applied by MPD after it times out, so it
+	// must not be attempted again
+	if want, got := errTimeout, rdb.PlaylistAdd("foo", "foo.mp3"); want != got {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	if want, got := 1, db.Calls(); want != got {
+		t.Fatalf("unexpected number of calls:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_retryDatabaseReset(t *testing.T) {
+	db := &slowDatabase{
+		delays: []time.Duration{time.Second, 0},
+		errs:   []error{nil, nil},
+		files:  []string{"foo.mp3"},
+	}
+
+	rdb := newRetryDatabase(db, &Config{
+		MPDTimeout:      20 * time.Millisecond,
+		MPDRetries:      1,
+		MPDRetryBackoff: time.Millisecond,
+	})
+
+	// The connection is replaced before the command is retried
+	var resets int
+	rdb.reset = func() error {
+		if want, got := 1, db.Calls(); want != got {
+			t.Errorf("unexpected number of calls before reset:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		resets++
+		return nil
+	}
+
+	if _, err := rdb.List("file"); err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+
+	if want, got := 1, resets; want != got {
+		t.Fatalf("unexpected number of resets:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+// A slowDatabase is a memoryDatabase whose List and PlaylistAdd methods
+// return each of a series of errors after a series of delays.
+type slowDatabase struct {
+	memoryDatabase
+
+	delays []time.Duration
+	errs   []error
+	files  []string
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (db *slowDatabase) List(args ...string) ([]string, error) {
+	if err := db.next(); err != nil {
+		return nil, err
+	}
+
+	return db.files, nil
+}
+
+func (db *slowDatabase) PlaylistAdd(name string, uri string) error {
+	return db.next()
+}
+
+// next waits for the delay of the next call, and returns its error.
+func (db *slowDatabase) next() error {
+	db.mu.Lock()
+	i := db.calls
+	db.calls++
+	db.mu.Unlock()
+
+	time.Sleep(db.delays[i])
+	return db.errs[i]
+}
+
+// Calls returns the number of calls to List and PlaylistAdd.
+func (db *slowDatabase) Calls() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.calls
+}
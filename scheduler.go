@@ -0,0 +1,276 @@
+package mpdsub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMaxBackgroundJobs is the default number of background jobs
+	// which may run concurrently.
+	defaultMaxBackgroundJobs = 1
+
+	// defaultIdlePoll is how often a scheduler checks whether MPD has
+	// stopped playing while jobs are paused.
+	defaultIdlePoll = 30 * time.Second
+)
+
+// Names of the background jobs run by a Server.
+const (
+	jobArtworkPrewarm = "artwork_prewarm"
+	jobCacheEviction  = "cache_eviction"
+	jobSearchIndex    = "search_index"
+)
+
+// A backgroundJob is maintenance work which is run periodically by a
+// scheduler, such as building the search index.
+type backgroundJob struct {
+	// name identifies the job in logs, metrics, and calls to trigger.
+	name string
+
+	// interval is the time between runs of the job.  Jobs also run once
+	// when the scheduler starts, and whenever they are triggered.
+	interval time.Duration
+
+	// run performs the job.  Long-running jobs should stop when ctx is
+	// canceled, and may call scheduler.pause between steps.
+	run func(ctx context.Context) error
+}
+
+// A scheduler runs background jobs, so that maintenance work cannot
+// degrade streaming on small devices.  The number of jobs which run
+// concurrently is limited, each run is delayed by a random jitter so that
+// jobs do not run in lockstep, and jobs may optionally wait while MPD is
+// playing.
+type scheduler struct {
+	slots  chan struct{}
+	jitter time.Duration
+	busy   func() bool
+	poll   time.Duration
+	logf   func(format string, v ...interface{})
+
+	mu   sync.Mutex
+	jobs map[string]*scheduledJob
+}
+
+// A scheduledJob is a backgroundJob and the statistics of its runs.
+type scheduledJob struct {
+	backgroundJob
+	trigger chan struct{}
+
+	runs     uint64
+	failures uint64
+	running  bool
+}
+
+// newScheduler creates a scheduler which runs at most max jobs concurrently,
+// and delays each run by up to jitter.  If busy is not nil, jobs wait to
+// start while it reports true.  If max is 0, defaultMaxBackgroundJobs is
+// used.
+func newScheduler(max int, jitter time.Duration, busy func() bool, logf func(format string, v ...interface{})) *scheduler {
+	if max == 0 {
+		max = defaultMaxBackgroundJobs
+	}
+
+	return &scheduler{
+		slots:  make(chan struct{}, max),
+		jitter: jitter,
+		busy:   busy,
+		poll:   defaultIdlePoll,
+		logf:   logf,
+		jobs:   make(map[string]*scheduledJob),
+	}
+}
+
+// add registers a job.  Jobs must be added before the scheduler is started.
+func (s *scheduler) add(j backgroundJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[j.name] = &scheduledJob{
+		backgroundJob: j,
+		trigger:       make(chan struct{}, 1),
+	}
+}
+
+// start runs each job in its own goroutine until ctx is canceled.  wg is
+// incremented for each goroutine.
+func (s *scheduler) start(ctx context.Context, wg *sync.WaitGroup) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, j := range s.jobs {
+		wg.Add(1)
+		go func(j *scheduledJob) {
+			defer wg.Done()
+			s.loop(ctx, j)
+		}(j)
+	}
+}
+
+// trigger requests that the named job runs as soon as possible, such as
+// after MPD's database changes.  Unknown jobs are ignored.
+func (s *scheduler) trigger(name string) {
+	s.mu.Lock()
+	j, ok := s.jobs[name]
+	s.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	select {
+	case j.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// loop runs a job once, and then each time its interval elapses or it is
+// triggered, until ctx is canceled.
+func (s *scheduler) loop(ctx context.Context, j *scheduledJob) {
+	for {
+		if !sleep(ctx, s.delay()) {
+			return
+		}
+		if err := s.waitIdle(ctx); err != nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case s.slots <- struct{}{}:
+		}
+
+		s.record(j, true, nil)
+		err := j.run(ctx)
+		<-s.slots
+		s.record(j, false, err)
+
+		if err != nil && ctx.Err() == nil {
+			s.logf("error running background job %s: %v", j.name, err)
+		}
+
+		t := time.NewTimer(j.interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-j.trigger:
+			t.Stop()
+		case <-t.C:
+		}
+	}
+}
+
+// record updates a job's statistics when it starts or finishes running.
+func (s *scheduler) record(j *scheduledJob, running bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j.running = running
+	if running {
+		return
+	}
+
+	j.runs++
+	if err != nil {
+		j.failures++
+	}
+}
+
+// delay returns a random delay of up to the scheduler's jitter.
+func (s *scheduler) delay() time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(s.jitter)))
+}
+
+// pause is called by a running job between steps, so that it pauses while
+// the scheduler's busy function reports true, such as when playback begins.
+// The job's slot is released while it is paused, so that it cannot block
+// other jobs, and is acquired again before pause returns, even if ctx was
+// canceled, as the scheduler releases it when the job returns.
+func (s *scheduler) pause(ctx context.Context) error {
+	if s.busy == nil || !s.busy() {
+		return ctx.Err()
+	}
+
+	<-s.slots
+	err := s.waitIdle(ctx)
+	s.slots <- struct{}{}
+
+	return err
+}
+
+// waitIdle blocks until the scheduler's busy function reports false, such
+// as when MPD stops playing, or until ctx is canceled.
+func (s *scheduler) waitIdle(ctx context.Context) error {
+	for s.busy != nil && s.busy() {
+		if !sleep(ctx, s.poll) {
+			return ctx.Err()
+		}
+	}
+
+	return ctx.Err()
+}
+
+// sleep waits for d to elapse, and reports false if ctx was canceled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// WriteTo writes the statistics of each job to w in the Prometheus text
+// exposition format.
+func (s *scheduler) WriteTo(w io.Writer) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.jobs))
+	for n := range s.jobs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	cw := &countWriter{w: w}
+	fmt.Fprintln(cw, "# HELP mpdsub_background_job_runs_total Number of completed runs of each background job.")
+	fmt.Fprintln(cw, "# TYPE mpdsub_background_job_runs_total counter")
+	for _, n := range names {
+		fmt.Fprintf(cw, "mpdsub_background_job_runs_total{job=%q} %d\n", n, s.jobs[n].runs)
+	}
+	fmt.Fprintln(cw, "# HELP mpdsub_background_job_failures_total Number of runs of each background job which failed.")
+	fmt.Fprintln(cw, "# TYPE mpdsub_background_job_failures_total counter")
+	for _, n := range names {
+		fmt.Fprintf(cw, "mpdsub_background_job_failures_total{job=%q} %d\n", n, s.jobs[n].failures)
+	}
+	fmt.Fprintln(cw, "# HELP mpdsub_background_job_running Whether each background job is running.")
+	fmt.Fprintln(cw, "# TYPE mpdsub_background_job_running gauge")
+	for _, n := range names {
+		var v int
+		if s.jobs[n].running {
+			v = 1
+		}
+		fmt.Fprintf(cw, "mpdsub_background_job_running{job=%q} %d\n", n, v)
+	}
+
+	return cw.n, cw.err
+}
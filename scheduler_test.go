@@ -0,0 +1,201 @@
+package mpdsub
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_schedulerConcurrency(t *testing.T) {
+	logf := log.New(ioutil.Discard, "", 0).Printf
+	s := newScheduler(1, 0, nil, logf)
+
+	var (
+		running int32
+		overlap int32
+		wg      sync.WaitGroup
+	)
+	wg.Add(2)
+
+	for _, name := range []string{"foo", "bar"} {
+		var once sync.Once
+		s.add(backgroundJob{
+			name:     name,
+			interval: time.Hour,
+			run: func(ctx context.Context) error {
+				if atomic.AddInt32(&running, 1) > 1 {
+					atomic.StoreInt32(&overlap, 1)
+				}
+				time.Sleep(20 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+
+				once.Do(wg.Done)
+				return nil
+			},
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	s.start(ctx, &jobs)
+
+	wg.Wait()
+	cancel()
+	jobs.Wait()
+
+	if atomic.LoadInt32(&overlap) != 0 {
+		t.Fatal("jobs should not run concurrently")
+	}
+}
+
+func Test_schedulerTrigger(t *testing.T) {
+	logf := log.New(ioutil.Discard, "", 0).Printf
+	s := newScheduler(0, 0, nil, logf)
+
+	runs := make(chan struct{}, 10)
+	s.add(backgroundJob{
+		name:     "foo",
+		interval: time.Hour,
+		run: func(ctx context.Context) error {
+			runs <- struct{}{}
+			return nil
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	s.start(ctx, &jobs)
+	defer func() {
+		cancel()
+		jobs.Wait()
+	}()
+
+	// Jobs run when the scheduler starts, and again when triggered
+	for i := 0; i < 2; i++ {
+		select {
+		case <-runs:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("job did not run %d time(s)", i+1)
+		}
+
+		s.trigger("foo")
+	}
+
+	// Unknown jobs are ignored
+	s.trigger("bar")
+}
+
+func Test_schedulerPause(t *testing.T) {
+	var busy, first int32
+
+	logf := log.New(ioutil.Discard, "", 0).Printf
+	s := newScheduler(1, 0, func() bool {
+		return atomic.LoadInt32(&busy) == 1
+	}, logf)
+	s.poll = time.Millisecond
+
+	runs := make(chan string, 2)
+	for _, name := range []string{"foo", "bar"} {
+		s.add(backgroundJob{
+			name:     name,
+			interval: time.Hour,
+			run: func(ctx context.Context) error {
+				if !atomic.CompareAndSwapInt32(&first, 0, 1) {
+					runs <- "other"
+					return nil
+				}
+
+				// Let the other job wait for the slot, then begin playback
+				time.Sleep(20 * time.Millisecond)
+				atomic.StoreInt32(&busy, 1)
+
+				if err := s.pause(ctx); err != nil {
+					return err
+				}
+
+				runs <- "paused"
+				return nil
+			},
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	s.start(ctx, &jobs)
+	defer func() {
+		cancel()
+		jobs.Wait()
+	}()
+
+	// The paused job releases its slot, so the other job may run
+	for _, want := range []string{"other", "paused"} {
+		select {
+		case got := <-runs:
+			if want != got {
+				t.Fatalf("unexpected job run:\n- want: %v\n-  got: %v", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s job did not run", want)
+		}
+
+		atomic.StoreInt32(&busy, 0)
+	}
+}
+
+func Test_schedulerBusy(t *testing.T) {
+	var busy int32 = 1
+
+	logf := log.New(ioutil.Discard, "", 0).Printf
+	s := newScheduler(0, 0, func() bool {
+		return atomic.LoadInt32(&busy) == 1
+	}, logf)
+	s.poll = time.Millisecond
+
+	runs := make(chan struct{}, 1)
+	s.add(backgroundJob{
+		name:     "foo",
+		interval: time.Hour,
+		run: func(ctx context.Context) error {
+			runs <- struct{}{}
+			return nil
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	s.start(ctx, &jobs)
+	defer func() {
+		cancel()
+		jobs.Wait()
+	}()
+
+	select {
+	case <-runs:
+		t.Fatal("job should not run while busy")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	atomic.StoreInt32(&busy, 0)
+
+	select {
+	case <-runs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not run once idle")
+	}
+
+	var buf bytes.Buffer
+	if _, err := s.WriteTo(&buf); err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+
+	// The run may not have been recorded yet
+	if !strings.Contains(buf.String(), `mpdsub_background_job_running{job="foo"}`) {
+		t.Fatalf("unexpected metrics:\n%s", buf.String())
+	}
+}
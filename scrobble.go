@@ -0,0 +1,292 @@
+package mpdsub
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// A scrobblePlay is a play of a song submitted by a Subsonic client.  Plays
+// of the same song less than Window apart are considered the same listen,
+// such as when a client submits a song which it also streamed.
+type scrobblePlay struct {
+	historyEntry
+	Window time.Duration
+	Attrs  mpd.Attrs
+}
+
+// nowPlayingNotes holds the songs which clients reported as playing for
+// each user, using scrobble with submission=false.  Notes are kept in
+// memory only.
+type nowPlayingNotes struct {
+	mu    sync.Mutex
+	users map[string]historyEntry
+}
+
+// set records that user is playing a song.
+func (n *nowPlayingNotes) set(user string, e historyEntry) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.users == nil {
+		n.users = make(map[string]historyEntry)
+	}
+	n.users[user] = e
+}
+
+// latest replaces the songs in latest, which maps users to their most
+// recently streamed song, with any songs reported as playing more recently.
+func (n *nowPlayingNotes) latest(latest map[string][]historyEntry) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for user, e := range n.users {
+		if cur, ok := latest[user]; !ok || e.Time.After(cur[0].Time) {
+			latest[user] = []historyEntry{e}
+		}
+	}
+}
+
+// scrobble is used in Subsonic to report songs played by a client, which
+// may not have been streamed from the Server, such as songs cached by the
+// client for offline playback.  Several songs may be submitted at once using
+// repeated id and time parameters.  If submission is false, the songs are
+// only reported as now playing, and are not added to the user's listening
+// history.
+func (s *Server) scrobble(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	qIDs := q["id"]
+	if len(qIDs) == 0 {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	// Times are optional, but must be paired with each ID if present
+	qTimes := q["time"]
+	if len(qTimes) > 0 && len(qTimes) != len(qIDs) {
+		writeXML(w, errGeneric)
+		return
+	}
+
+	submission := true
+	if qSubmission := q.Get("submission"); qSubmission != "" {
+		b, err := strconv.ParseBool(qSubmission)
+		if err != nil {
+			writeXML(w, errGeneric)
+			return
+		}
+		submission = b
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		s.logf("error listing files from mpd for scrobbling: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+	files := indexFiles(fs)
+
+	rctx := requestContextFrom(r)
+	now := time.Now()
+
+	plays := make([]scrobblePlay, 0, len(qIDs))
+	for i, qID := range qIDs {
+		f, ok := s.lookupID(files, qID)
+		if !ok || f.Dir {
+			writeXML(w, errNotFound)
+			return
+		}
+		if !s.canAccess(w, rctx.User, f) {
+			return
+		}
+
+		t := now
+		if len(qTimes) > 0 {
+			ms, err := strconv.ParseInt(qTimes[i], 10, 64)
+			if err != nil {
+				writeXML(w, errGeneric)
+				return
+			}
+			t = time.Unix(0, ms*int64(time.Millisecond))
+		}
+
+		a, err := s.songAttrs(f.Name)
+		if err != nil {
+			s.logf("error retrieving song from mpd for %q: %v", f.Name, err)
+			writeXML(w, errMPD(err))
+			return
+		}
+
+		plays = append(plays, scrobblePlay{
+			historyEntry: historyEntry{
+				File:   f.Name,
+				Client: rctx.Client,
+				Time:   t,
+			},
+			Window: scrobbleWindow(a),
+			Attrs:  a,
+		})
+	}
+
+	if !submission {
+		// Only the last song can be playing
+		last := plays[len(plays)-1]
+		s.nowPlaying.set(rctx.User, last.historyEntry)
+		s.events.publish(eventStream)
+		s.forwardScrobbles(rctx.User, []scrobblePlay{last}, false)
+
+		writeXML(w, nil)
+		return
+	}
+
+	recorded, err := s.recordScrobbles(rctx.User, plays, now)
+	if err != nil {
+		s.logf("error recording scrobbles for %q: %v", rctx.User, err)
+		writeXML(w, errGeneric)
+		return
+	}
+	s.forwardScrobbles(rctx.User, recorded, true)
+
+	writeXML(w, nil)
+}
+
+// songAttrs retrieves the MPD attributes of the song with the specified name.
+// If MPD does not return the song, only its name is known.
+func (s *Server) songAttrs(name string) (mpd.Attrs, error) {
+	songs, err := s.db.ListAllInfo(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range songs {
+		if a["file"] == name {
+			return a, nil
+		}
+	}
+
+	return mpd.Attrs{"file": name}, nil
+}
+
+// scrobbleWindow returns the window in which plays of a song are considered
+// the same listen: the song's duration, or defaultNowPlayingDuration if its
+// duration is unknown.
+func scrobbleWindow(a mpd.Attrs) time.Duration {
+	if d := songDuration(a); d > 0 {
+		return time.Duration(d) * time.Second
+	}
+
+	return defaultNowPlayingDuration
+}
+
+// recordScrobbles adds a batch of plays submitted by user to their listening
+// history and play statistics in a single update, skipping plays which
+// repeat a play already in the history or earlier in the batch.  Plays which
+// are older than the retention window are only added to the statistics, and
+// history entries which are older than the retention window are removed.
+// The plays which were not skipped are returned.
+func (s *Server) recordScrobbles(user string, plays []scrobblePlay, now time.Time) ([]scrobblePlay, error) {
+	cutoff := now.Add(-s.historyRetention())
+
+	var recorded []scrobblePlay
+	err := s.store.Record(func(d *storeData) error {
+		var history []historyEntry
+		for _, e := range d.History[user] {
+			if e.Time.After(cutoff) {
+				history = append(history, e)
+			}
+		}
+
+		var batch []historyEntry
+		for _, p := range plays {
+			if repeatsPlay(history, p) || repeatsPlay(batch, p) {
+				continue
+			}
+			batch = append(batch, p.historyEntry)
+			recorded = append(recorded, p)
+
+			d.addPlay(user, s.itemKey(p.File), p.Time)
+			if p.Time.After(cutoff) {
+				history = append(history, p.historyEntry)
+			}
+		}
+
+		// Submitted plays may be older than those already recorded
+		sort.Stable(byPlayed(history))
+
+		d.History[user] = history
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return recorded, nil
+}
+
+// repeatsPlay reports whether a play repeats a play of the same song in
+// history.
+func repeatsPlay(history []historyEntry, p scrobblePlay) bool {
+	for _, e := range history {
+		if e.File != p.File {
+			continue
+		}
+
+		delta := e.Time.Sub(p.Time)
+		if delta < 0 {
+			delta = -delta
+		}
+		if delta < p.Window {
+			return true
+		}
+	}
+
+	return false
+}
+
+// byPlayed sorts historyEntries by the time they were played, oldest first.
+type byPlayed []historyEntry
+
+func (b byPlayed) Len() int           { return len(b) }
+func (b byPlayed) Less(i, j int) bool { return b[i].Time.Before(b[j].Time) }
+func (b byPlayed) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// A scrobbler forwards plays submitted by Subsonic clients to an external
+// service.
+type scrobbler interface {
+	// NowPlaying reports that user is playing a song.
+	NowPlaying(user string, p scrobblePlay) error
+
+	// Scrobble submits plays by user.
+	Scrobble(user string, plays []scrobblePlay) error
+}
+
+// forwardScrobbles forwards plays by user to each configured scrobbler in the
+// background, so that clients are not delayed by slow services.  If
+// submission is false, the plays are reported as now playing.
+func (s *Server) forwardScrobbles(user string, plays []scrobblePlay, submission bool) {
+	if len(s.scrobblers) == 0 || len(plays) == 0 {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for _, sc := range s.scrobblers {
+			var err error
+			if submission {
+				err = sc.Scrobble(user, plays)
+			} else {
+				err = sc.NowPlaying(user, plays[len(plays)-1])
+			}
+			if err != nil {
+				s.logf("error forwarding scrobbles for %q: %v", user, err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,130 @@
+package mpdsub
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_scrobble(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"bar.mp3",
+			"foo.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "bar.mp3", "Title": "Bar", "duration": "600"},
+			{"file": "foo.mp3", "Title": "Foo", "duration": "300"},
+		},
+	}
+
+	start := time.Now().Add(-1 * time.Hour)
+
+	// ms returns the scrobble time of a play d after start
+	ms := func(d time.Duration) string {
+		return strconv.FormatInt(start.Add(d).UnixNano()/int64(time.Millisecond), 10)
+	}
+
+	tests := []struct {
+		name   string
+		params url.Values
+		code   int
+	}{
+		{
+			name: "missing ID",
+			code: codeMissingParameter,
+		},
+		{
+			name:   "unpaired time",
+			params: url.Values{"id": {"0", "1"}, "time": {ms(0)}},
+			code:   codeGeneric,
+		},
+		{
+			name:   "invalid submission",
+			params: url.Values{"id": {"0"}, "submission": {"foo"}},
+			code:   codeGeneric,
+		},
+		{
+			name:   "unknown ID",
+			params: url.Values{"id": {"9"}},
+			code:   codeNotFound,
+		},
+	}
+
+	cfg, values := configAuth()
+	values.Set("c", "DSub")
+
+	// params copies values and adds additional query parameters
+	params := func(extra url.Values) url.Values {
+		v := make(url.Values, len(values))
+		for k, vv := range values {
+			v[k] = vv
+		}
+		for k, vv := range extra {
+			v[k] = vv
+		}
+		return v
+	}
+
+	withServer(t, db, nil, cfg, func(base string) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/scrobble.view", params(tt.params)))
+				if c.Error == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+				if want, got := tt.code, c.Error.Code; want != got {
+					t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		}
+
+		// Submit a batch out of order, including a repeat of Foo which
+		// is within its duration of the first play
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/scrobble.view", params(url.Values{
+			"id":   {"0", "1", "1"},
+			"time": {ms(10 * time.Minute), ms(0), ms(1 * time.Minute)},
+		})))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+
+		// Report Foo as now playing, without adding it to the history
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/scrobble.view", params(url.Values{
+			"id":         {"1"},
+			"submission": {"false"},
+		})))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getHistory.view", values))
+
+		var titles []string
+		for _, e := range c.History.Entries {
+			titles = append(titles, e.Title)
+		}
+
+		if want, got := "Bar,Foo", strings.Join(titles, ","); want != got {
+			t.Fatalf("unexpected history:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getNowPlaying.view", values))
+		if c.NowPlaying == nil || len(c.NowPlaying.Entries) != 1 {
+			t.Fatalf("unexpected now playing: %+v", c.NowPlaying)
+		}
+
+		e := c.NowPlaying.Entries[0]
+		if want, got := "Foo", e.Title; want != got {
+			t.Fatalf("unexpected now playing title:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := "DSub", e.PlayerName; want != got {
+			t.Fatalf("unexpected now playing player name:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
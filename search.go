@@ -0,0 +1,384 @@
+package mpdsub
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+const (
+	// defaultSearchCount is the number of artists, albums, or songs returned
+	// by a search when a client does not specify a count.
+	defaultSearchCount = 20
+
+	// maxSearchCount is the maximum number of artists, albums, or songs
+	// returned by a single search.
+	maxSearchCount = 500
+)
+
+// A searchQuery specifies the query, music folder, and pages of artists,
+// albums, and songs requested by a search.
+type searchQuery struct {
+	Query  string
+	Folder int
+
+	ArtistCount  int
+	ArtistOffset int
+	AlbumCount   int
+	AlbumOffset  int
+	SongCount    int
+	SongOffset   int
+}
+
+// parseSearchQuery parses the parameters of a search2 or search3 request.  If the query
+// is missing, errMissingParameter is returned.  If a parameter is invalid,
+// errGeneric is returned.
+func parseSearchQuery(r *http.Request) (searchQuery, func(c *container)) {
+	q := r.URL.Query()
+
+	sq := searchQuery{
+		Query:       q.Get("query"),
+		Folder:      musicFolderAll,
+		ArtistCount: defaultSearchCount,
+		AlbumCount:  defaultSearchCount,
+		SongCount:   defaultSearchCount,
+	}
+
+	if sq.Query == "" {
+		return sq, errMissingParameter
+	}
+
+	ints := []struct {
+		name string
+		v    *int
+	}{
+		{name: "artistCount", v: &sq.ArtistCount},
+		{name: "artistOffset", v: &sq.ArtistOffset},
+		{name: "albumCount", v: &sq.AlbumCount},
+		{name: "albumOffset", v: &sq.AlbumOffset},
+		{name: "songCount", v: &sq.SongCount},
+		{name: "songOffset", v: &sq.SongOffset},
+		{name: "musicFolderId", v: &sq.Folder},
+	}
+
+	for _, i := range ints {
+		qv := q.Get(i.name)
+		if qv == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(qv)
+		if err != nil {
+			return sq, errGeneric
+		}
+		*i.v = n
+	}
+
+	for _, n := range []*int{&sq.ArtistCount, &sq.AlbumCount, &sq.SongCount} {
+		if *n < 0 {
+			return sq, errGeneric
+		}
+		if *n > maxSearchCount {
+			*n = maxSearchCount
+		}
+	}
+	if sq.ArtistOffset < 0 || sq.AlbumOffset < 0 || sq.SongOffset < 0 {
+		return sq, errGeneric
+	}
+
+	return sq, nil
+}
+
+// search2 is used in Subsonic to search for artists, albums, and songs,
+// organized by directory.  Artists, albums, and songs are matched by their
+// artist, album, and title tags respectively, using MPD's case-insensitive
+// substring search.
+func (s *Server) search2(w http.ResponseWriter, r *http.Request) {
+	sq, m, ok := s.searchPage(w, r)
+	if !ok {
+		return
+	}
+
+	res := &searchResult2{
+		Artists: []artist{},
+		Albums:  []albumChild{},
+		Songs:   []song{},
+	}
+
+	// Artists are the directories containing the matching songs' albums
+	seen := make(map[string]struct{})
+	for _, a := range m.Artists {
+		dir := artistDir(albumDir(a["file"]))
+		if _, ok := seen[dir]; ok {
+			continue
+		}
+		seen[dir] = struct{}{}
+
+		res.Artists = append(res.Artists, artist{
+			Name:     path.Base(dir),
+			ID:       s.formatID(m.IDs[dir]),
+			SortName: s.sortName(path.Base(dir)),
+			CoverArt: s.formatID(m.IDs[dir]),
+		})
+	}
+	start, end := page(len(res.Artists), sq.ArtistOffset, sq.ArtistCount)
+	res.Artists = res.Artists[start:end]
+
+	// Albums are the directories containing the matching songs
+	byDir := make(map[string]*album)
+	var dirs []string
+	for _, a := range m.Albums {
+		dir := albumDir(a["file"])
+
+		al, ok := byDir[dir]
+		if !ok {
+			al = &album{
+				ID:       m.IDs[dir],
+				ArtistID: m.IDs[artistDir(dir)],
+				Dir:      dir,
+				Name:     path.Base(dir),
+			}
+			byDir[dir] = al
+			dirs = append(dirs, dir)
+		}
+
+		al.add(a)
+	}
+	start, end = page(len(dirs), sq.AlbumOffset, sq.AlbumCount)
+	for _, d := range dirs[start:end] {
+		res.Albums = append(res.Albums, albumChild{child: s.albumChild(*byDir[d])})
+	}
+
+	for _, a := range m.Songs {
+		res.Songs = append(res.Songs, song{child: s.songChild(m.IDs[a["file"]], a)})
+	}
+
+	writeXML(w, func(c *container) {
+		c.SearchResult2 = res
+	})
+}
+
+// search3 is used in Subsonic to search for the same artists, albums, and
+// songs as search2, organized by their tags.
+func (s *Server) search3(w http.ResponseWriter, r *http.Request) {
+	sq, m, ok := s.searchPage(w, r)
+	if !ok {
+		return
+	}
+
+	albums, err := s.albums(requestContextFrom(r).User, sq.Folder)
+	if err != nil {
+		s.logf("error retrieving albums from mpd for searching: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	// Look up the albums containing matching songs, and their ID3 artists
+	byDir := make(map[string]album, len(albums))
+	for _, al := range albums {
+		byDir[al.Dir] = al
+	}
+	id3 := id3Artists(albums)
+	artists := make(map[int]id3Artist, len(id3))
+	for _, a := range id3 {
+		artists[a.ID] = a
+	}
+	artistIDs := id3ArtistIDs(id3)
+
+	res := &searchResult3{
+		Artists: []artistID3{},
+		Albums:  []albumID3{},
+		Songs:   []song{},
+	}
+
+	seen := make(map[int]struct{})
+	for _, a := range m.Artists {
+		al, ok := byDir[albumDir(a["file"])]
+		if !ok {
+			continue
+		}
+
+		id := artistIDs[al.ID]
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+
+		res.Artists = append(res.Artists, artistID3{
+			ID:         s.formatID(id),
+			Name:       artists[id].Name,
+			AlbumCount: len(artists[id].Albums),
+		})
+	}
+	start, end := page(len(res.Artists), sq.ArtistOffset, sq.ArtistCount)
+	res.Artists = res.Artists[start:end]
+
+	seenAlbums := make(map[int]struct{})
+	for _, a := range m.Albums {
+		al, ok := byDir[albumDir(a["file"])]
+		if !ok {
+			continue
+		}
+		if _, ok := seenAlbums[al.ID]; ok {
+			continue
+		}
+		seenAlbums[al.ID] = struct{}{}
+
+		res.Albums = append(res.Albums, s.albumID3(al, artistIDs[al.ID]))
+	}
+	start, end = page(len(res.Albums), sq.AlbumOffset, sq.AlbumCount)
+	res.Albums = res.Albums[start:end]
+
+	for _, a := range m.Songs {
+		c := s.songChild(m.IDs[a["file"]], a)
+		if al, ok := byDir[albumDir(a["file"])]; ok {
+			c.Parent = s.formatID(al.ID)
+			c.AlbumID = s.formatID(al.ID)
+			c.ArtistID = s.formatID(artistIDs[al.ID])
+		}
+
+		res.Songs = append(res.Songs, song{child: c})
+	}
+
+	writeXML(w, func(c *container) {
+		c.SearchResult3 = res
+	})
+}
+
+// searchMatches are the songs matching each kind of result requested by a
+// searchQuery, and the IDs of the files in the file index.  Artists and
+// Albums contain only songs in albums, and Songs is already paged.
+type searchMatches struct {
+	IDs     map[string]int
+	Artists []mpd.Attrs
+	Albums  []mpd.Attrs
+	Songs   []mpd.Attrs
+}
+
+// searchPage searches MPD for the songs matching a search2 or search3
+// request.  If the search cannot be performed, an error is written to w and
+// false is returned.
+func (s *Server) searchPage(w http.ResponseWriter, r *http.Request) (searchQuery, searchMatches, bool) {
+	sq, errFn := parseSearchQuery(r)
+	if errFn != nil {
+		writeXML(w, errFn)
+		return sq, searchMatches{}, false
+	}
+
+	m, err := s.searchMatches(requestContextFrom(r).User, sq)
+	if err != nil {
+		s.logf("error searching mpd for %q: %v", sq.Query, err)
+		writeXML(w, errMPD(err))
+		return sq, searchMatches{}, false
+	}
+
+	return sq, m, true
+}
+
+// searchMatches searches for the songs matching a searchQuery which are
+// visible to user, using the search index if it is ready, or MPD otherwise.
+func (s *Server) searchMatches(user string, sq searchQuery) (searchMatches, error) {
+	snap := s.index.snapshot()
+
+	var m searchMatches
+	if snap != nil {
+		m.IDs = snap.ids
+	} else {
+		fs, err := s.db.List("file")
+		if err != nil {
+			return searchMatches{}, err
+		}
+
+		m.IDs = fileIDs(indexFiles(fs))
+	}
+
+	tags := []struct {
+		tag     string
+		count   int
+		inAlbum bool
+		v       *[]mpd.Attrs
+	}{
+		{tag: "artist", count: sq.ArtistCount, inAlbum: true, v: &m.Artists},
+		{tag: "album", count: sq.AlbumCount, inAlbum: true, v: &m.Albums},
+		{tag: "title", count: sq.SongCount, v: &m.Songs},
+	}
+
+	for _, t := range tags {
+		songs, err := s.searchTag(snap, user, sq, t.tag, t.count)
+		if err != nil {
+			return searchMatches{}, err
+		}
+
+		for _, a := range songs {
+			if _, ok := m.IDs[a["file"]]; !ok {
+				continue
+			}
+
+			// Songs at the root of the music directory are not in an
+			// album, and therefore have no artist
+			if t.inAlbum && albumDir(a["file"]) == "" {
+				continue
+			}
+
+			*t.v = append(*t.v, a)
+		}
+	}
+
+	start, end := page(len(m.Songs), sq.SongOffset, sq.SongCount)
+	m.Songs = m.Songs[start:end]
+
+	return m, nil
+}
+
+// searchTag searches for songs whose tag contains the query of a
+// searchQuery, and returns those which are visible to user in the query's
+// music folder.  If snap is not nil, the search index is searched, and
+// songs are ordered by relevance; otherwise, MPD is searched.  If count is
+// zero, nothing is searched.
+func (s *Server) searchTag(snap *indexSnapshot, user string, sq searchQuery, tag string, count int) ([]mpd.Attrs, error) {
+	if count == 0 {
+		return nil, nil
+	}
+
+	var songs []mpd.Attrs
+	if snap != nil {
+		songs = snap.search(tag, sq.Query)
+	} else {
+		var err error
+		songs, err = s.db.Search(tag, sq.Query)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]mpd.Attrs, 0, len(songs))
+	for _, a := range songs {
+		name := a["file"]
+		if name == "" || !s.inMusicFolder(name, sq.Folder) {
+			continue
+		}
+		if !s.visible(user, name) || s.filteredGenre(user, a["Genre"]) {
+			continue
+		}
+
+		out = append(out, a)
+	}
+
+	return out, nil
+}
+
+// page returns the bounds of the page of a list of length n which begins at
+// offset and contains at most count items.
+func page(n int, offset int, count int) (int, int) {
+	if offset > n {
+		offset = n
+	}
+	end := offset + count
+	if end > n {
+		end = n
+	}
+
+	return offset, end
+}
@@ -0,0 +1,191 @@
+package mpdsub
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_search2(t *testing.T) {
+	var (
+		help      = mpd.Attrs{"file": "The Beatles/Help/1.mp3", "Title": "Help!", "Artist": "The Beatles", "Album": "Help!"}
+		yesterday = mpd.Attrs{"file": "The Beatles/Help/2.mp3", "Title": "Yesterday", "Artist": "The Beatles", "Album": "Help!"}
+		helpless  = mpd.Attrs{"file": "Young/Harvest/1.mp3", "Title": "Helpless", "Artist": "Neil Young", "Album": "Harvest"}
+		secret    = mpd.Attrs{"file": "Secret/Help/1.mp3", "Title": "Help Me", "Artist": "Secret", "Album": "Help"}
+	)
+
+	db := &memoryDatabase{
+		files: []string{
+			"Secret/Help/1.mp3",
+			"The Beatles/Help/1.mp3",
+			"The Beatles/Help/2.mp3",
+			"Young/Harvest/1.mp3",
+		},
+		searches: map[string][]mpd.Attrs{
+			"artist beatles": {help, yesterday},
+			"album help":     {secret, help, yesterday},
+			"title help":     {secret, help, helpless},
+		},
+	}
+
+	type result struct {
+		Artists, Albums, Songs []string
+	}
+
+	tests := []struct {
+		name   string
+		params map[string]string
+		err    bool
+		code   int
+		res    result
+	}{
+		{
+			name: "missing query",
+			err:  true,
+			code: codeMissingParameter,
+		},
+		{
+			name:   "invalid count",
+			params: map[string]string{"query": "help", "songCount": "foo"},
+			err:    true,
+			code:   codeGeneric,
+		},
+		{
+			name:   "negative offset",
+			params: map[string]string{"query": "help", "albumOffset": "-1"},
+			err:    true,
+			code:   codeGeneric,
+		},
+		{
+			name:   "artists",
+			params: map[string]string{"query": "beatles"},
+			res:    result{Artists: []string{"The Beatles"}},
+		},
+		{
+			name:   "albums and songs",
+			params: map[string]string{"query": "help"},
+			res: result{
+				Albums: []string{"Help!"},
+				Songs:  []string{"Help!", "Helpless"},
+			},
+		},
+		{
+			name:   "counts and offsets",
+			params: map[string]string{"query": "help", "albumCount": "0", "songCount": "1", "songOffset": "1"},
+			res:    result{Songs: []string{"Helpless"}},
+		},
+		{
+			name:   "offset past end",
+			params: map[string]string{"query": "help", "songOffset": "10"},
+			res:    result{Albums: []string{"Help!"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.FolderUsers = map[string][]string{
+				"Secret": {"someone"},
+			}
+			for k, v := range tt.params {
+				values.Set(k, v)
+			}
+
+			withServer(t, db, nil, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/search2.view", values))
+
+				if tt.err {
+					if c.Error == nil {
+						t.Fatal("expected an error, but none occurred")
+					}
+					if want, got := tt.code, c.Error.Code; want != got {
+						t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+					}
+					return
+				}
+
+				if c.SearchResult2 == nil {
+					t.Fatal("no search result in response")
+				}
+
+				var res result
+				for _, a := range c.SearchResult2.Artists {
+					if want, got := a.ID, a.CoverArt; want != got {
+						t.Fatalf("unexpected artist cover art:\n- want: %v\n-  got: %v", want, got)
+					}
+					res.Artists = append(res.Artists, a.Name)
+				}
+				for _, a := range c.SearchResult2.Albums {
+					res.Albums = append(res.Albums, a.Title)
+				}
+				for _, s := range c.SearchResult2.Songs {
+					res.Songs = append(res.Songs, s.Title)
+				}
+
+				if want, got := tt.res, res; !reflect.DeepEqual(want, got) {
+					t.Fatalf("unexpected search result:\n- want: %+v\n-  got: %+v", want, got)
+				}
+			})
+		})
+	}
+}
+
+func TestServer_search3(t *testing.T) {
+	db := id3Database()
+	db.searches = map[string][]mpd.Attrs{
+		"artist o": {db.songs[3]},
+		"album o":  {db.songs[0], db.songs[1], db.songs[3]},
+		"title o":  {db.songs[1]},
+	}
+
+	cfg, values := configAuth()
+	values.Set("query", "o")
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/search3.view", values))
+
+		res := c.SearchResult3
+		if res == nil {
+			t.Fatal("no search result in response")
+		}
+
+		type summary struct {
+			ID, Name, ArtistID string
+			Count              int
+		}
+
+		var artists []summary
+		for _, a := range res.Artists {
+			artists = append(artists, summary{ID: a.ID, Name: a.Name, Count: a.AlbumCount})
+		}
+
+		if want, got := []summary{{ID: "7", Name: "Other", Count: 1}}, artists; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected artists:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		var albums []summary
+		for _, a := range res.Albums {
+			albums = append(albums, summary{ID: a.ID, Name: a.Name, ArtistID: a.ArtistID, Count: a.SongCount})
+		}
+
+		want := []summary{
+			{ID: "1", Name: "Gold", ArtistID: "0", Count: 2},
+			{ID: "7", Name: "More", ArtistID: "7", Count: 1},
+		}
+
+		if got := albums; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected albums:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		if want, got := 1, len(res.Songs); want != got {
+			t.Fatalf("unexpected number of songs:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		sg := res.Songs[0]
+		if sg.Title != "Waterloo" || sg.AlbumID != "1" || sg.ArtistID != "0" {
+			t.Fatalf("unexpected song: %+v", sg.child)
+		}
+	})
+}
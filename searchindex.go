@@ -0,0 +1,363 @@
+package mpdsub
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+const (
+	// searchIndexVersion is the version of the format of a persisted
+	// search index.  Files with any other version are rebuilt.
+	searchIndexVersion = 1
+
+	// searchIndexInterval is how often the search index is compared with
+	// MPD's database, in case a change was not reported by an event or
+	// building the index failed.
+	searchIndexInterval = time.Minute
+)
+
+// Fields of a song in which a term appears, stored as bits in a posting.
+const (
+	fieldArtist uint8 = 1 << iota
+	fieldAlbum
+	fieldTitle
+	fieldPath
+)
+
+// searchFields maps the tags searched by searchTag to the fields of the
+// search index which match them.  Songs are also matched by their file
+// names, so that songs without tags can be found.
+var searchFields = map[string]uint8{
+	"artist": fieldArtist,
+	"album":  fieldAlbum,
+	"title":  fieldTitle | fieldPath,
+}
+
+// searchTags maps the tags searched by searchTag to the MPD tags whose entire
+// value may equal a query.
+var searchTags = map[string][]string{
+	"artist": {"Artist", "AlbumArtist"},
+	"album":  {"Album"},
+	"title":  {"Title"},
+}
+
+// A searchIndex is a full-text index of the tags and file names of the
+// songs in MPD's database, which is searched in memory instead of asking
+// MPD to search every song.  The index is persisted to a file so it is available
+// immediately after a restart, and is rebuilt when MPD's database changes.
+//
+// A nil *searchIndex is never ready, so searches are performed by MPD.
+type searchIndex struct {
+	path string
+
+	mu    sync.RWMutex
+	snap  *indexSnapshot
+	gen   uint64
+	built uint64
+}
+
+// An indexSnapshot is an immutable index of MPD's database at a single
+// point in time.
+type indexSnapshot struct {
+	data     searchIndexData
+	ids      map[string]int
+	terms    []string
+	postings map[string][]posting
+}
+
+// searchIndexData is the state persisted by a searchIndex.
+type searchIndexData struct {
+	Version int `json:"version"`
+
+	// DBUpdate is MPD's db_update statistic when the index was built.
+	DBUpdate string `json:"dbUpdate"`
+
+	// Files are the files in MPD's database, in the order returned by
+	// MPD, from which IDs are assigned.
+	Files []string `json:"files"`
+
+	// Songs are the attributes of each song.
+	Songs []mpd.Attrs `json:"songs"`
+}
+
+// A posting records the fields of a song in which a term appears.
+type posting struct {
+	song   int
+	fields uint8
+}
+
+// openSearchIndex opens the search index persisted at path, if it exists.
+// The index is not ready until rebuild has confirmed that it matches MPD's
+// database.  If path is empty, nil is returned.
+func openSearchIndex(path string) (*searchIndex, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	// The index is not yet known to be built from the current database
+	x := &searchIndex{
+		path: path,
+		gen:  1,
+	}
+
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return x, nil
+	default:
+		return nil, err
+	}
+
+	// The index can always be rebuilt from MPD, so a file which cannot be
+	// decoded is ignored
+	var d searchIndexData
+	if err := json.Unmarshal(b, &d); err != nil || d.Version != searchIndexVersion {
+		return x, nil
+	}
+
+	x.snap = newIndexSnapshot(d)
+	return x, nil
+}
+
+// snapshot returns the current index, or nil if the index is not ready
+// because MPD's database may have changed since it was built.
+func (x *searchIndex) snapshot() *indexSnapshot {
+	if x == nil {
+		return nil
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.built != x.gen {
+		return nil
+	}
+
+	return x.snap
+}
+
+// invalidate marks the index as stale, such as when MPD's database changes,
+// until it is rebuilt.
+func (x *searchIndex) invalidate() {
+	if x == nil {
+		return
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.gen++
+}
+
+// rebuild builds the index from db, unless the index was already built from
+// the same version of MPD's database, and persists it.
+func (x *searchIndex) rebuild(db database) error {
+	x.mu.RLock()
+	gen, snap := x.gen, x.snap
+	x.mu.RUnlock()
+
+	stats, err := db.Stats()
+	if err != nil {
+		return err
+	}
+
+	if snap == nil || snap.data.DBUpdate != stats["db_update"] {
+		files, err := db.List("file")
+		if err != nil {
+			return err
+		}
+		all, err := db.ListAllInfo("")
+		if err != nil {
+			return err
+		}
+
+		songs := make([]mpd.Attrs, 0, len(all))
+		for _, a := range all {
+			if a["file"] != "" {
+				songs = append(songs, a)
+			}
+		}
+
+		snap = newIndexSnapshot(searchIndexData{
+			Version:  searchIndexVersion,
+			DBUpdate: stats["db_update"],
+			Files:    files,
+			Songs:    songs,
+		})
+
+		if err := x.save(snap.data); err != nil {
+			return err
+		}
+	}
+
+	// If the database changed again while building, the index remains
+	// stale until it is rebuilt once more
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.snap = snap
+	x.built = gen
+
+	return nil
+}
+
+// save persists the index's data.
+func (x *searchIndex) save(d searchIndexData) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(x.path, b)
+}
+
+// newIndexSnapshot indexes the terms in the tags and paths of the songs in d.
+func newIndexSnapshot(d searchIndexData) *indexSnapshot {
+	snap := &indexSnapshot{
+		data:     d,
+		ids:      fileIDs(indexFiles(d.Files)),
+		postings: make(map[string][]posting),
+	}
+
+	for i, a := range d.Songs {
+		fields := make(map[string]uint8)
+		add := func(v string, f uint8) {
+			for _, t := range searchTerms(v) {
+				fields[t] |= f
+			}
+		}
+
+		add(a["Artist"], fieldArtist)
+		add(a["AlbumArtist"], fieldArtist)
+		add(a["Album"], fieldAlbum)
+		add(a["Title"], fieldTitle)
+		add(strings.TrimSuffix(path.Base(a["file"]), path.Ext(a["file"])), fieldPath)
+
+		for t, f := range fields {
+			snap.postings[t] = append(snap.postings[t], posting{song: i, fields: f})
+		}
+	}
+
+	snap.terms = make([]string, 0, len(snap.postings))
+	for t := range snap.postings {
+		snap.terms = append(snap.terms, t)
+	}
+	sort.Strings(snap.terms)
+
+	return snap
+}
+
+// search returns the songs matching query in the fields which match tag,
+// most relevant first.  Each word of the query must begin a word in one of
+// the fields.  Songs score higher when words match entirely rather than as
+// a prefix, when they match a tag rather than only the song's path, and
+// when the query matches an entire tag.
+func (snap *indexSnapshot) search(tag string, query string) []mpd.Attrs {
+	fields := searchFields[tag]
+	words := searchTerms(query)
+	if fields == 0 || len(words) == 0 {
+		return nil
+	}
+
+	var scores map[int]int
+	for _, w := range words {
+		next := make(map[int]int)
+		for i := sort.SearchStrings(snap.terms, w); i < len(snap.terms) && strings.HasPrefix(snap.terms[i], w); i++ {
+			t := snap.terms[i]
+			for _, p := range snap.postings[t] {
+				f := p.fields & fields
+				if f == 0 {
+					continue
+				}
+				if _, ok := scores[p.song]; scores != nil && !ok {
+					continue
+				}
+
+				score := 1
+				if t == w {
+					score *= 2
+				}
+				if f&^fieldPath != 0 {
+					score *= 3
+				}
+				if score > next[p.song] {
+					next[p.song] = score
+				}
+			}
+		}
+
+		for song := range next {
+			next[song] += scores[song]
+		}
+		scores = next
+
+		if len(scores) == 0 {
+			return nil
+		}
+	}
+
+	phrase := strings.Join(words, " ")
+	results := make([]searchResult, 0, len(scores))
+	for song, score := range scores {
+		a := snap.data.Songs[song]
+		for _, t := range searchTags[tag] {
+			if strings.Join(searchTerms(a[t]), " ") == phrase {
+				score += 10
+				break
+			}
+		}
+
+		results = append(results, searchResult{attrs: a, score: score})
+	}
+	sort.Sort(byRelevance(results))
+
+	out := make([]mpd.Attrs, 0, len(results))
+	for _, r := range results {
+		out = append(out, r.attrs)
+	}
+
+	return out
+}
+
+// searchTerms splits a string into lower case words, ignoring punctuation.
+func searchTerms(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// A searchResult is a song matched by a search, and its relevance.
+type searchResult struct {
+	attrs mpd.Attrs
+	score int
+}
+
+// byRelevance sorts searchResults by their relevance, most relevant first, and
+// then by their names.
+type byRelevance []searchResult
+
+func (b byRelevance) Len() int      { return len(b) }
+func (b byRelevance) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byRelevance) Less(i, j int) bool {
+	if b[i].score != b[j].score {
+		return b[i].score > b[j].score
+	}
+
+	return b[i].attrs["file"] < b[j].attrs["file"]
+}
+
+// rebuildSearchIndex is a backgroundJob which builds the search index.
+func (s *Server) rebuildSearchIndex(ctx context.Context) error {
+	return s.index.rebuild(s.db)
+}
@@ -0,0 +1,197 @@
+package mpdsub
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func Test_indexSnapshotSearch(t *testing.T) {
+	snap := newIndexSnapshot(searchIndexData{
+		Songs: []mpd.Attrs{
+			{"file": "Beatles/Help/1.mp3", "Title": "Help!", "Artist": "The Beatles", "Album": "Help!"},
+			{"file": "Beatles/Help/2.mp3", "Title": "Yesterday", "Artist": "The Beatles", "Album": "Help!"},
+			{"file": "Young/Harvest/1.mp3", "Title": "Helpless", "Artist": "Neil Young", "Album": "Harvest"},
+			{"file": "Various/Hits/1.mp3", "Title": "Help Me Rhonda", "Artist": "The Beach Boys", "AlbumArtist": "Various Artists", "Album": "Hits"},
+			{"file": "Untagged/help me.mp3"},
+		},
+	})
+
+	tests := []struct {
+		name  string
+		tag   string
+		query string
+		files []string
+	}{
+		{
+			name:  "exact title first",
+			tag:   "title",
+			query: "help",
+			files: []string{
+				"Beatles/Help/1.mp3",
+				"Various/Hits/1.mp3",
+				"Young/Harvest/1.mp3",
+				"Untagged/help me.mp3",
+			},
+		},
+		{
+			name:  "all words",
+			tag:   "title",
+			query: "help me",
+			files: []string{
+				"Various/Hits/1.mp3",
+				"Untagged/help me.mp3",
+			},
+		},
+		{
+			name:  "album artist",
+			tag:   "artist",
+			query: "various",
+			files: []string{"Various/Hits/1.mp3"},
+		},
+		{
+			name:  "case and punctuation",
+			tag:   "album",
+			query: "HELP",
+			files: []string{"Beatles/Help/1.mp3", "Beatles/Help/2.mp3"},
+		},
+		{
+			name:  "no match",
+			tag:   "artist",
+			query: "eatles",
+		},
+		{
+			name:  "no words",
+			tag:   "title",
+			query: "!!",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var files []string
+			for _, a := range snap.search(tt.tag, tt.query) {
+				files = append(files, a["file"])
+			}
+
+			if want, got := tt.files, files; !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected files:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func Test_searchIndexRebuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-searchindex")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "index.json")
+
+	db := &memoryDatabase{
+		files: []string{"foo/bar.mp3"},
+		songs: []mpd.Attrs{
+			{"directory": "foo"},
+			{"file": "foo/bar.mp3", "Title": "Bar"},
+		},
+		dbUpdate: "1",
+	}
+
+	x, err := openSearchIndex(file)
+	if err != nil {
+		t.Fatalf("failed to open search index: %v", err)
+	}
+	if x.snapshot() != nil {
+		t.Fatal("search index should not be ready before it is built")
+	}
+
+	if err := x.rebuild(db); err != nil {
+		t.Fatalf("failed to build search index: %v", err)
+	}
+
+	snap := x.snapshot()
+	if snap == nil {
+		t.Fatal("search index should be ready after it is built")
+	}
+	if want, got := 1, snap.ids["foo/bar.mp3"]; want != got {
+		t.Fatalf("unexpected ID:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := 1, len(snap.search("title", "bar")); want != got {
+		t.Fatalf("unexpected number of results:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// A changed database makes the index stale until it is rebuilt
+	x.invalidate()
+	if x.snapshot() != nil {
+		t.Fatal("search index should not be ready after it is invalidated")
+	}
+
+	// The persisted index is reused while MPD's database is unchanged
+	x, err = openSearchIndex(file)
+	if err != nil {
+		t.Fatalf("failed to reopen search index: %v", err)
+	}
+
+	db.songs = nil
+	if err := x.rebuild(db); err != nil {
+		t.Fatalf("failed to rebuild search index: %v", err)
+	}
+	if want, got := 1, len(x.snapshot().search("title", "bar")); want != got {
+		t.Fatalf("unexpected number of results:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	db.dbUpdate = "2"
+	if err := x.rebuild(db); err != nil {
+		t.Fatalf("failed to rebuild search index: %v", err)
+	}
+	if want, got := 0, len(x.snapshot().search("title", "bar")); want != got {
+		t.Fatalf("unexpected number of results:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestServer_search3SearchIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-searchindex")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// MPD itself finds nothing, so any results come from the index
+	db := &memoryDatabase{
+		files: []string{"Beatles/Help/1.mp3"},
+		songs: []mpd.Attrs{
+			{"file": "Beatles/Help/1.mp3", "Title": "Help!", "Artist": "The Beatles", "Album": "Help!"},
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.SearchIndexFile = filepath.Join(dir, "index.json")
+	values.Set("query", "help")
+
+	withServer(t, db, nil, cfg, func(base string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/search3.view", values))
+
+			if c.SearchResult3 != nil && len(c.SearchResult3.Songs) == 1 {
+				if want, got := "Help!", c.SearchResult3.Songs[0].Title; want != got {
+					t.Fatalf("unexpected title:\n- want: %v\n-  got: %v", want, got)
+				}
+				return
+			}
+
+			if time.Now().After(deadline) {
+				t.Fatal("search index was not used")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"sync"
@@ -19,10 +21,45 @@ import (
 // of an MPD server.  It enables Subsonic clients to read information from
 // MPD's database and stream files from the local filesystem.
 type Server struct {
-	db  database
-	fs  filesystem
-	cfg *Config
-	ll  *log.Logger
+	db      database
+	player  player
+	fs      filesystem
+	cfg     *Config
+	ll      *log.Logger
+	genres  *genreMap
+	store   *store
+	exclude *excluder
+	filters map[string]*contentFilter
+	mixes   mixCache
+	metrics *metrics
+
+	genreCache genreCache
+
+	transcodes *transcodeManager
+	offline    *offlineDatabase
+
+	idTokens     *idTokens
+	streamTokens streamTokens
+	sessions     sessions
+	events       eventHub
+	nowPlaying   nowPlayingNotes
+	scrobblers   []scrobbler
+
+	artworkSources []artworkSource
+	artCache       *artworkCache
+	artInfo        *artworkInfoCache
+	artDisk        *diskArtworkCache
+	mtimes         modTimeCache
+	index          *searchIndex
+	jobs           *scheduler
+	lastFM         *lastFM
+	metadata       *metadataCache
+	misses         *missCache
+	musicURL       *url.URL
+	externalURL    *url.URL
+	userKey        []byte
+	musicClient    *http.Client
+	streamClient   *http.Client
 
 	mux *http.ServeMux
 
@@ -32,20 +69,103 @@ type Server struct {
 
 // Config specifies configuration for a Server.
 type Config struct {
-	// Credentials which Subsonic clients must provide to authenticate
-	// to the Server.
+	// Credentials of a user which Subsonic clients may provide to
+	// authenticate to the Server.  Additional users, each with their own
+	// password and settings, are kept in the user store within StateFile.
+	// The user defined here has every role, so that it may manage other
+	// users, and cannot be modified or removed by clients.
 	SubsonicUser     string
 	SubsonicPassword string
 
 	// MusicDirectory specifies the root music directory for the MPD server.
 	// This must match the value specified in MPD's configuration to enable
-	// streaming media through the Server.
+	// streaming media through the Server.  MPD always uses forward slashes
+	// in file URIs, which are converted to the local path separator when
+	// joined with MusicDirectory, so Windows paths such as `C:\Music` or a
+	// mounted SMB share may be used.
 	//
 	// TODO(mdlayher): perhaps enable parsing this via:
 	//  - MPD 'config' command, if over UNIX socket
 	//  - MPD configuration file
 	MusicDirectory string
 
+	// CaseInsensitivePaths specifies if paths in MPD's music directory
+	// should be compared case-insensitively when matching ExcludePatterns
+	// and FolderUsers.  This is typically needed when MPD runs on Windows or
+	// serves a library from a case-insensitive filesystem, such as a SMB
+	// share.
+	CaseInsensitivePaths bool
+
+	// CheckMusicDirectory specifies if the Server should check that a
+	// sample of the files in MPD's database can be found in MusicDirectory,
+	// or MusicURL if set, when it starts.  Any problems found are logged.
+	// The check may also be run on demand using the checkMusicDirectory
+	// endpoint.
+	CheckMusicDirectory bool
+
+	// MusicURL optionally specifies the URL of an HTTP server which serves
+	// the contents of MPD's music directory, such as a web server running
+	// on the same host as MPD.  If set, files are streamed by proxying
+	// requests to this URL, so the Server does not require access to
+	// MusicDirectory.  Transcoders receive the file's URL in place of its
+	// path, and must support reading from HTTP.
+	MusicURL string
+
+	// ArtworkNames optionally specifies the names of image files in a
+	// song's directory which contain cover art, such as "cover.jpg", in
+	// order of preference.  A name with the extension ".*" matches any
+	// common image extension.  Names are case-sensitive if the filesystem
+	// is.  If ArtworkNames is empty, "cover.*", "folder.*", "front.*", and
+	// "album.*" are used.  Image files are only read when MusicDirectory
+	// is available locally, and are preferred over pictures embedded in
+	// songs; otherwise, MPD locates cover art itself.
+	ArtworkNames []string
+
+	// ArtworkParentDirectory specifies if the parent of a song's directory
+	// should also be searched for cover art, for box sets where each disc
+	// has its own directory and art is stored one level up.
+	ArtworkParentDirectory bool
+
+	// ArtworkCacheDirectory optionally specifies a directory in which
+	// artwork served by getCoverArt, including artwork extracted from songs
+	// and resized artwork, is cached on disk.  Cached artwork is retained
+	// across restarts, and discarded when MPD's database is updated.  If
+	// ArtworkCacheDirectory is empty, artwork is not cached on disk.
+	ArtworkCacheDirectory string
+
+	// ArtworkCacheSize optionally specifies the maximum number of bytes of
+	// artwork kept in ArtworkCacheDirectory, after which the least recently
+	// used artwork is removed.  If ArtworkCacheSize is 0, a default of 256
+	// MiB is used.
+	ArtworkCacheSize int64
+
+	// SearchIndexFile optionally specifies the path to a file in which a
+	// full-text index of the tags and file names of the songs in MPD's
+	// database is persisted.  If set, search2 and search3 search the index
+	// in memory rather than asking MPD to search every song, which is much
+	// faster for large libraries, and rank results by relevance.  Each
+	// word of a query matches the beginning of a word in a tag, rather
+	// than any substring.  The index is built in the background, and
+	// rebuilt when PlayerEvents reports that MPD's "database" subsystem
+	// changed; until it is ready, MPD is searched.
+	SearchIndexFile string
+
+	// AvatarDirectory optionally specifies a directory containing avatar
+	// images served by getAvatar, named after each user with the extension
+	// .png, .jpg, .jpeg, or .gif, such as "alice.png".  Users without an
+	// image receive a generated identicon.
+	AvatarDirectory string
+
+	// ServerName optionally specifies a name for the Server, such as "Home"
+	// or "Office", which is returned by the ping and getLicense endpoints
+	// so users can distinguish between multiple Servers.
+	ServerName string
+
+	// LicenseEmail and WelcomeMessage optionally specify an email address
+	// and message which are returned by the getLicense endpoint.
+	LicenseEmail   string
+	WelcomeMessage string
+
 	// Verbose specifies if the server should enable verbose logging.
 	Verbose bool
 
@@ -57,10 +177,350 @@ type Config struct {
 	// Logger specifies an optional logger for the Server.  If Logger is
 	// nil, Server logs will be sent to stdout.
 	Logger *log.Logger
+
+	// GenreAliases optionally maps genre names found in file tags to the
+	// canonical genre names presented to Subsonic clients, such as
+	// "Alt Rock" to "Alternative Rock".  Keys are matched case-insensitively.
+	GenreAliases map[string]string
+
+	// GenreSeparators optionally specifies a set of characters which are
+	// used to split multi-valued genre tags, such as ";/" to split
+	// "Rock; Pop/Jazz" into three genres.  If empty, genre tags are not split.
+	GenreSeparators string
+
+	// SmartPlaylists optionally specifies playlists defined by saved
+	// queries, which are evaluated against MPD's database each time a
+	// Subsonic client requests them.  Each SmartPlaylist must have a unique
+	// name, and is validated when the Server is created.
+	SmartPlaylists []SmartPlaylist
+
+	// DailyMixes optionally specifies the number of auto-generated "daily
+	// mix" playlists to expose to each user, each containing songs from one
+	// of the genres the user plays and stars most.  Songs the user plays
+	// and stars more are chosen more often.  A "Rediscover" mix of starred
+	// or often played songs which the user has not played for 30 days is
+	// also exposed.  If DailyMixes is 0, no mixes are generated.
+	DailyMixes int
+
+	// DailyMixSize specifies the maximum number of songs in each daily mix.
+	// If DailyMixSize is 0, a default of 50 songs is used.
+	DailyMixSize int
+
+	// DailyMixRefresh specifies how often the songs in daily mixes are
+	// rotated.  If DailyMixRefresh is 0, mixes are rotated once per day.
+	DailyMixRefresh time.Duration
+
+	// ExcludePatterns optionally specifies patterns which exclude entire
+	// subtrees of MPD's music directory from browsing, such as
+	// "Audiobooks/" or "*/demos".  Patterns are matched against paths
+	// relative to the music directory using path.Match.
+	ExcludePatterns []string
+
+	// ExcludedFolder optionally specifies the name of a separate music
+	// folder which exposes the subtrees excluded by ExcludePatterns.  If
+	// empty, excluded subtrees cannot be browsed.
+	ExcludedFolder string
+
+	// TopLevelFolders specifies if each immediate subdirectory of MPD's
+	// music directory, such as "Music" or "Audiobooks", should be exposed
+	// as its own music folder, rather than exposing the entire music
+	// directory as a single music folder.
+	TopLevelFolders bool
+
+	// SeparateArtists specifies if directories at the top level of the
+	// indexes which have the same name, ignoring case and IgnoredArticles,
+	// should be listed separately.  By default, such directories, such as
+	// the same artist in several top-level folders, are merged into a
+	// single directory containing the contents of each.
+	SeparateArtists bool
+
+	// IndexSkeleton specifies if getIndexes should return only the name and
+	// number of artists of each index by default, rather than every artist.
+	// Clients fetch the artists for a single index using the letter
+	// parameter.  Clients may override the default using the skeleton
+	// parameter.
+	IndexSkeleton bool
+
+	// ComposerBrowsing enables the custom getComposers and getComposer
+	// endpoints, which browse songs by their composer and work tags rather
+	// than by performing artist, as is common for classical music.
+	ComposerBrowsing bool
+
+	// Collation optionally specifies a BCP 47 language tag, such as "sv" or
+	// "de", whose collation rules are used to sort indexes.  UserCollations
+	// optionally overrides Collation for individual users.  If no collation
+	// is configured, indexes are sorted in byte order.
+	Collation      string
+	UserCollations map[string]string
+
+	// IgnoredArticles optionally specifies the leading articles, such as
+	// "The", which are ignored when computing the sort names of artists
+	// and albums.  If IgnoredArticles is nil, Subsonic's defaults are used.
+	IgnoredArticles []string
+
+	// HistoryRetention optionally specifies how long each user's listening
+	// history is retained.  If HistoryRetention is 0, a default of 30 days
+	// is used.
+	HistoryRetention time.Duration
+
+	// ContentFilters optionally maps users to a ContentFilter which hides
+	// content from them, such as for children's accounts.
+	ContentFilters map[string]ContentFilter
+
+	// FolderUsers optionally restricts access to immediate subdirectories of
+	// MPD's music directory to a list of users.  Subdirectories which do not
+	// appear in FolderUsers are accessible to all users.  Users in the user
+	// store may also be restricted to any directories by an administrator,
+	// using the createUser and updateUser endpoints.
+	FolderUsers map[string][]string
+
+	// DownloadUsers optionally restricts downloading original files using
+	// the download endpoint to a list of users.  Users who may not download
+	// files may still stream them.  If DownloadUsers is nil, all users may
+	// download files.
+	DownloadUsers []string
+
+	// AccessWindows optionally restricts the times at which users may use
+	// the Server, such as a child's account on a Server shared by a
+	// family.  A user with AccessWindows may only use the Server during
+	// one of their windows, and receives an error describing the windows
+	// otherwise.  Users without AccessWindows are not restricted.
+	AccessWindows map[string][]AccessWindow
+
+	// ReadOnly specifies if the Server should reject every request which
+	// would modify MPD or the Server's state, such as updating playlists,
+	// starring or rating songs, or controlling playback, so that browsing
+	// and streaming can be exposed publicly.  ReadOnly cannot be combined
+	// with WatchMusicDirectory, which asks MPD to update its database.
+	ReadOnly bool
+
+	// PlayerEvents optionally specifies a channel of MPD subsystems which
+	// have changed, such as the Event channel of an mpd.Watcher watching the
+	// "player" and "database" subsystems.  Each event is forwarded to
+	// clients connected to the nowPlayingEvents endpoint.  "database" events
+	// also refresh caches and the search index, and restore the ratings of
+	// songs which reappear in MPD's database, so the watcher must watch the
+	// "database" subsystem for these to happen.
+	PlayerEvents <-chan string
+
+	// PollInterval optionally specifies how often the Server checks whether
+	// MPD's database was updated when PlayerEvents is nil, such as when MPD
+	// cannot be watched.  Caches and the search index are then refreshed as
+	// if a "database" event was received.  If PlayerEvents is set or
+	// PollInterval is 0, MPD's database is not polled.
+	PollInterval time.Duration
+
+	// WatchMusicDirectory specifies if the Server should watch
+	// MusicDirectory for changes, and ask MPD to update its database
+	// for the directories which change.
+	WatchMusicDirectory bool
+
+	// WatchDelay specifies how long the Server waits for filesystem activity
+	// to settle before asking MPD to update its database.  If WatchDelay is
+	// 0, a default of 5 seconds is used.
+	WatchDelay time.Duration
+
+	// StateFile optionally specifies the path to a file where the Server
+	// persists state which cannot be stored in MPD, such as playlist
+	// metadata.  If StateFile is empty, state is only kept in memory and
+	// is lost when the Server stops.  Listening history is written every
+	// 30 seconds and when the Server is closed, rather than on each play.
+	StateFile string
+
+	// UserKeyFile optionally specifies the path to a file holding the key
+	// which encrypts the passwords of the users kept in StateFile.  The
+	// file is created with a random key if it does not exist, and a key
+	// previously kept in StateFile is moved to it.  Subsonic's token
+	// authentication requires the passwords themselves, so they cannot be
+	// hashed.  If UserKeyFile is empty, the key is kept in StateFile along
+	// with the passwords, so anyone who can read StateFile can recover
+	// every password, as if they were stored in plaintext.
+	UserKeyFile string
+
+	// PlaylistDirectory optionally specifies a directory containing M3U
+	// playlists, with the extension .m3u or .m3u8, which are listed by
+	// getPlaylists alongside MPD's stored playlists.  Entries may be paths
+	// relative to the playlist file or to MusicDirectory, or absolute paths
+	// within MusicDirectory.  Entries which do not match a song in MPD's
+	// database, such as streams, are skipped.  M3U playlists are owned by
+	// SubsonicUser, and their songs cannot be modified by clients.
+	PlaylistDirectory string
+
+	// PlaylistNamespaces specifies if MPD stored playlists should be
+	// namespaced per user.  When enabled, playlists are stored in MPD with
+	// the name "user:name", and are only visible to their owner unless
+	// marked as public.  Playlists without a namespace are owned by
+	// SubsonicUser.
+	PlaylistNamespaces bool
+
+	// Transcoders optionally specifies external commands which transcode
+	// audio files to other formats when requested by Subsonic clients.
+	// Each Transcoder is validated when the Server is created.
+	Transcoders []Transcoder
+
+	// MaxRandomSongs optionally specifies the maximum number of songs
+	// returned by a single getRandomSongs request.  Larger requests are
+	// capped to this size.  If MaxRandomSongs is 0, a default of 500 is
+	// used.
+	MaxRandomSongs int
+
+	// StreamTokenTTL optionally specifies how long a single-use stream token
+	// created by the createStreamToken endpoint remains valid.  If
+	// StreamTokenTTL is 0, a default of 5 minutes is used.
+	StreamTokenTTL time.Duration
+
+	// ExternalURL optionally specifies the URL at which clients reach the
+	// Server, such as "https://music.example.com/", when it differs from
+	// the address it listens on, such as behind a reverse proxy.  It is
+	// used to build absolute URLs in playlists exported by the download
+	// endpoint, and playlists cannot be exported if it is not set.
+	ExternalURL string
+
+	// WebSessions specifies if browser-based clients, such as a web UI, may
+	// exchange Subsonic credentials for a session cookie using the custom
+	// createSession endpoint.  Requests which carry a valid session cookie
+	// are authenticated as the session's user, without Subsonic
+	// credentials in their URLs.
+	WebSessions bool
+
+	// SessionTTL optionally specifies how long a session remains valid
+	// after it was last used.  If SessionTTL is 0, a default of 24 hours
+	// is used.
+	SessionTTL time.Duration
+
+	// ObfuscateIDs specifies if IDs should be presented to clients as
+	// opaque tokens, rather than as sequential numbers which can be
+	// enumerated to discover the rest of the library from an ID in a
+	// shared or cast URL.  Tokens are derived using a key persisted in
+	// StateFile, so they remain stable across restarts if StateFile is
+	// set.
+	ObfuscateIDs bool
+
+	// ClientOmitFields optionally maps Subsonic client names to the
+	// optional XML attributes, such as "genre" or "path", which are omitted
+	// from responses sent to that client.  Clients may also request that
+	// fields be omitted using the comma-separated omit parameter.  Client
+	// names are matched case-insensitively.
+	ClientOmitFields map[string][]string
+
+	// MaxTranscodes optionally limits the number of transcoder processes
+	// which may run concurrently.  Additional requests are queued until a
+	// running transcoder exits.  Requests for the same rendition of a file
+	// share a single transcoder process.  If MaxTranscodes is 0, the number
+	// of transcoder processes is not limited.
+	MaxTranscodes int
+
+	// TranscodeCacheSize optionally specifies the maximum number of bytes of
+	// complete transcoder output kept in memory.  Cached renditions are
+	// served without running the transcoder again, and support Range
+	// requests and timeOffset, so clients can seek within transcoded songs.
+	// Cached renditions are discarded when MPD's database is updated.  If
+	// TranscodeCacheSize is 0, transcoder output is not cached.
+	TranscodeCacheSize int64
+
+	// ClientFormats optionally maps Subsonic client names, such as "DSub",
+	// to the stream format used when a client does not request a format or
+	// maximum bitrate.  Each format must match the Format of a Transcoder,
+	// or be "raw" to disable transcoding.  Client names are matched
+	// case-insensitively.
+	ClientFormats map[string]string
+
+	// MPDTimeout optionally specifies the maximum amount of time to wait for
+	// each MPD command to complete.  If MPDTimeout is 0, no timeout is
+	// applied.
+	MPDTimeout time.Duration
+
+	// OfflineCache specifies if the Server should cache the results of
+	// recent MPD database queries, so that browsing and streaming known files
+	// continue to work while MPD is unreachable.  Artwork is not cached, and
+	// errors returned by MPD itself are not hidden.  While cached results
+	// are served, responses carry an X-Mpdsub-Degraded header, and ping
+	// reports degraded="true".
+	OfflineCache bool
+
+	// MPDRetries optionally specifies how many times a MPD command which
+	// times out should be retried.  Commands which fail for any other
+	// reason, such as a lost connection to MPD, are never retried, nor are
+	// commands which modify playlists, stickers, or the database.
+	MPDRetries int
+
+	// MPDRetryBackoff specifies the delay before the first retry of a MPD
+	// command, which doubles with each following retry.  If MPDRetryBackoff
+	// is 0, a default of 100 milliseconds is used.
+	MPDRetryBackoff time.Duration
+
+	// MPDDial optionally specifies a function which dials a new connection
+	// to MPD.  When a command exceeds MPDTimeout, the connection is replaced
+	// using MPDDial before the command is retried, since MPD may still send
+	// the response to the command which timed out.  If MPDDial is nil, the
+	// connection is kept, and retries wait for the earlier command.
+	MPDDial func() (*mpd.Client, error)
+
+	// MirrorPlayQueue specifies if play queues saved by Subsonic clients
+	// using savePlayQueue should also replace MPD's own queue, so that
+	// listening can continue on MPD's speakers.  Playback is not started.
+	MirrorPlayQueue bool
+
+	// MissCacheTTL optionally specifies how long the Server remembers that
+	// an item, such as an album, has no cover art, so that clients which
+	// repeatedly request missing cover art do not cause repeated lookups.
+	// Misses are also forgotten when MPD's database is updated.  If
+	// MissCacheTTL is 0, a default of 10 minutes is used.  If MissCacheTTL
+	// is negative, misses are not remembered.
+	MissCacheTTL time.Duration
+
+	// MaxBackgroundJobs optionally limits the number of background
+	// maintenance jobs, such as building the search index or prewarming
+	// artwork, which run concurrently.  If MaxBackgroundJobs is 0, a
+	// default of 1 is used.
+	MaxBackgroundJobs int
+
+	// BackgroundJobJitter optionally delays each run of a background job
+	// by a random duration of up to BackgroundJobJitter, so that jobs do
+	// not run in lockstep.  If BackgroundJobJitter is 0, jobs are not
+	// delayed.
+	BackgroundJobJitter time.Duration
+
+	// PauseJobsDuringPlayback specifies if background jobs should wait
+	// until MPD is not playing before starting, and pause between steps
+	// while it is playing, so that they do not compete with playback on
+	// small devices.
+	PauseJobsDuringPlayback bool
+
+	// PrewarmArtwork specifies if the artwork for every directory should be
+	// stored in ArtworkCacheDirectory in the background, once a day and
+	// after each update of MPD's database, rather than when clients first
+	// request it.  PrewarmArtwork requires ArtworkCacheDirectory.
+	PrewarmArtwork bool
+
+	// LastFM optionally configures forwarding of plays and now playing
+	// songs submitted by Subsonic clients using scrobble to Last.fm.
+	// Plays of songs streamed without a scrobble are not forwarded.
+	//
+	// LastFM also enables getArtistInfo and getArtistInfo2, which return
+	// artist biographies, images, and similar artists from Last.fm, and
+	// getAlbumInfo and getAlbumInfo2, which return album notes and images.
+	// getSimilarSongs and getSimilarSongs2 use Last.fm's similar songs and
+	// artists, rather than similarity derived from the library.
+	LastFM *LastFM
+
+	// MetadataCacheDirectory optionally specifies a directory in which
+	// metadata fetched from external providers such as Last.fm is cached
+	// on disk.  If MetadataCacheDirectory is empty, metadata is only
+	// cached in memory, and is fetched again after a restart.
+	MetadataCacheDirectory string
+
+	// MetadataCacheTTL optionally specifies the amount of time for which
+	// metadata cached in MetadataCacheDirectory is used before it is
+	// fetched again.  Expired metadata is still used while a provider is
+	// unavailable.  If MetadataCacheTTL is 0, a default of 7 days is used.
+	MetadataCacheTTL time.Duration
 }
 
-// NewServer creates a new Server using the input MPD client and Config.
-func NewServer(c *mpd.Client, cfg *Config) *Server {
+// NewServer creates a new Server using the input MPD client and Config.  The
+// Config is checked using Validate, and MPD must be reachable unless
+// OfflineCache is set.
+func NewServer(c *mpd.Client, cfg *Config) (*Server, error) {
 	if cfg == nil {
 		cfg = &Config{}
 	}
@@ -68,27 +528,261 @@ func NewServer(c *mpd.Client, cfg *Config) *Server {
 		cfg.Logger = log.New(os.Stdout, "", log.Ldate|log.Ltime)
 	}
 
-	return newServer(c, &osFilesystem{}, cfg)
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+
+	// Unless cached data can be served while MPD is unavailable, a Server
+	// which cannot reach MPD would fail every request
+	if !cfg.OfflineCache {
+		if err := c.Ping(); err != nil {
+			return nil, fmt.Errorf("cannot reach MPD: %v", err)
+		}
+	}
+
+	var db database = c
+	if cfg.MPDDial != nil {
+		db = newRedialClient(c, cfg.MPDDial)
+	}
+
+	return newServer(db, &osFilesystem{}, cfg)
 }
 
 // newServer is the internal constructor for Server.  It enables swapping in
 // arbitrary database implementations for testing.  It also sets up all Subsonic
 // API routes.
-func newServer(db database, fs filesystem, cfg *Config) *Server {
+func newServer(db database, fs filesystem, cfg *Config) (*Server, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	var musicURL *url.URL
+	if cfg.MusicURL != "" {
+		u, err := parseMusicURL(cfg.MusicURL)
+		if err != nil {
+			return nil, err
+		}
+		musicURL = u
+	}
+
+	var externalURL *url.URL
+	if cfg.ExternalURL != "" {
+		u, err := parseBaseURL("external", cfg.ExternalURL)
+		if err != nil {
+			return nil, err
+		}
+		externalURL = u
+	}
+
+	filters, err := newContentFilters(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := openStore(cfg.StateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	userKey, err := openUserKey(cfg.UserKeyFile, st)
+	if err != nil {
+		return nil, err
+	}
+
+	artDisk, err := openDiskArtworkCache(cfg.ArtworkCacheDirectory, cfg.ArtworkCacheSize)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := openSearchIndex(cfg.SearchIndexFile)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := openMetadataCache(cfg.MetadataCacheDirectory, cfg.MetadataCacheTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids *idTokens
+	if cfg.ObfuscateIDs {
+		key, err := idKey(st)
+		if err != nil {
+			return nil, err
+		}
+		ids = newIDTokens(key)
+	}
+
+	// Output control and queueing are only available if the database can
+	// also control MPD's playback
+	p, _ := db.(player)
+
+	// The connection to MPD can be replaced after a command times out if
+	// the database supports it
+	r, _ := db.(resetter)
+
+	// Measure the latency of each individual MPD command, including each
+	// retry of a command
+	m := newMetrics()
+	db = &metricsDatabase{db: db, m: m}
+	if p != nil {
+		p = &metricsPlayer{p: p, m: m}
+	}
+
+	if cfg.MPDTimeout > 0 || cfg.MPDRetries > 0 {
+		rdb := newRetryDatabase(db, cfg)
+		if r != nil {
+			rdb.reset = r.Reset
+		}
+		db = rdb
+	}
+
+	// Serve cached data only after any retries have failed
+	var offline *offlineDatabase
+	if cfg.OfflineCache {
+		offline = newOfflineDatabase(db)
+		db = offline
+	}
+
 	s := &Server{
-		db:  db,
-		fs:  fs,
-		cfg: cfg,
+		db:      db,
+		player:  p,
+		metrics: m,
+		fs:      fs,
+		cfg:     cfg,
+		store:   st,
+		genres:  newGenreMap(cfg.GenreAliases, cfg.GenreSeparators),
+		exclude: newExcluder(cfg.ExcludePatterns, cfg.CaseInsensitivePaths),
+		filters: filters,
+
+		transcodes:  newTranscodeManager(cfg.MaxTranscodes, cfg.TranscodeCacheSize),
+		artCache:    newArtworkCache(defaultArtworkCacheSize),
+		artInfo:     newArtworkInfoCache(),
+		artDisk:     artDisk,
+		index:       index,
+		metadata:    metadata,
+		misses:      newMissCache(cfg.MissCacheTTL),
+		offline:     offline,
+		idTokens:    ids,
+		musicURL:    musicURL,
+		externalURL: externalURL,
+		userKey:     userKey,
+
+		musicClient:  &http.Client{Timeout: remoteReadTimeout},
+		streamClient: newStreamClient(),
+	}
+
+	// Image files alongside songs are preferred when they can be read
+	// locally, as other Subsonic servers do, followed by pictures embedded
+	// in songs
+	if cfg.MusicDirectory != "" && musicURL == nil {
+		s.artworkSources = append(s.artworkSources,
+			newFileArtwork(fs, cfg),
+			&embeddedArtwork{db: db, fs: fs, root: cfg.MusicDirectory},
+		)
+	}
+	s.artworkSources = append(s.artworkSources, &mpdArtwork{db: db, cache: s.artCache})
+
+	if cfg.LastFM != nil {
+		s.lastFM = newLastFM(*cfg.LastFM)
+		s.lastFM.cache = metadata
+		s.scrobblers = append(s.scrobblers, s.lastFM)
+	}
+
+	var busy func() bool
+	if cfg.PauseJobsDuringPlayback {
+		busy = s.mpdPlaying
+	}
+
+	s.jobs = newScheduler(cfg.MaxBackgroundJobs, cfg.BackgroundJobJitter, busy, s.logf)
+	s.jobs.add(backgroundJob{
+		name:     jobCacheEviction,
+		interval: cacheEvictionInterval,
+		run:      s.evictCaches,
+	})
+	if index != nil {
+		s.jobs.add(backgroundJob{
+			name:     jobSearchIndex,
+			interval: searchIndexInterval,
+			run:      s.rebuildSearchIndex,
+		})
+	}
+	if cfg.PrewarmArtwork {
+		s.jobs.add(backgroundJob{
+			name:     jobArtworkPrewarm,
+			interval: artworkPrewarmInterval,
+			run:      s.prewarmArtwork,
+		})
+	}
+
+	if err := s.seedPlayStats(); err != nil {
+		return nil, err
 	}
 
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/rest/getLicense.view", s.getLicense)
-	mux.HandleFunc("/rest/getIndexes.view", s.getIndexes)
+	mux.HandleFunc("/rest/changePassword.view", s.mutating(s.changePassword))
+	mux.HandleFunc("/rest/checkMusicDirectory.view", s.requireRole(adminRole, s.checkMusicDirectory))
+	mux.HandleFunc("/rest/createPlaylist.view", s.mutating(s.requireRole(playlistRole, s.createPlaylist)))
+	mux.HandleFunc("/rest/createSession.view", s.createSession)
+	mux.HandleFunc("/rest/createStreamToken.view", s.requireRole(streamRole, s.createStreamToken))
+	mux.HandleFunc("/rest/createUser.view", s.mutating(s.requireRole(adminRole, s.createUser)))
+	mux.HandleFunc("/rest/deletePlaylist.view", s.mutating(s.requireRole(playlistRole, s.deletePlaylist)))
+	mux.HandleFunc("/rest/deleteSession.view", s.deleteSession)
+	mux.HandleFunc("/rest/deleteUser.view", s.mutating(s.requireRole(adminRole, s.deleteUser)))
+	mux.HandleFunc("/rest/download.view", s.download)
+	mux.HandleFunc("/rest/getAlbum.view", s.getAlbum)
+	mux.HandleFunc("/rest/getAlbumInfo.view", s.getAlbumInfo)
+	mux.HandleFunc("/rest/getAlbumInfo2.view", s.getAlbumInfo2)
+	mux.HandleFunc("/rest/getAlbumList.view", s.getAlbumList)
+	mux.HandleFunc("/rest/getAlbumList2.view", s.getAlbumList2)
+	mux.HandleFunc("/rest/getArtist.view", s.getArtist)
+	mux.HandleFunc("/rest/getArtistInfo.view", s.getArtistInfo)
+	mux.HandleFunc("/rest/getArtistInfo2.view", s.getArtistInfo2)
+	mux.HandleFunc("/rest/getArtists.view", s.conditional(s.getArtists, nil))
+	mux.HandleFunc("/rest/getAvatar.view", s.getAvatar)
+	mux.HandleFunc("/rest/getComposer.view", s.getComposer)
+	mux.HandleFunc("/rest/getComposers.view", s.conditional(s.getComposers, nil))
+	mux.HandleFunc("/rest/getCoverArt.view", s.getCoverArt)
+	mux.HandleFunc("/rest/getGenres.view", s.conditional(s.getGenres, nil))
+	mux.HandleFunc("/rest/getHistory.view", s.getHistory)
+	mux.HandleFunc("/rest/getIndexes.view", s.conditional(s.getIndexes, nil))
+	mux.HandleFunc("/rest/getLyrics.view", s.getLyrics)
+	mux.HandleFunc("/rest/getLyricsBySongId.view", s.getLyricsBySongID)
 	mux.HandleFunc("/rest/getMusicDirectory.view", s.getMusicDirectory)
 	mux.HandleFunc("/rest/getMusicFolders.view", s.getMusicFolders)
+	mux.HandleFunc("/rest/getNowPlaying.view", s.getNowPlaying)
+	mux.HandleFunc("/rest/getOpenSubsonicExtensions.view", s.getOpenSubsonicExtensions)
+	mux.HandleFunc("/rest/getPlayQueue.view", s.getPlayQueue)
+	mux.HandleFunc("/rest/getPlaylist.view", s.getPlaylist)
+	mux.HandleFunc("/rest/getPlaylists.view", s.conditional(s.getPlaylists, s.playlistsEpoch))
+	mux.HandleFunc("/rest/getPrefetchInfo.view", s.getPrefetchInfo)
+	mux.HandleFunc("/rest/getRandomSongs.view", s.getRandomSongs)
+	mux.HandleFunc("/rest/getSimilarSongs.view", s.getSimilarSongs)
+	mux.HandleFunc("/rest/getSimilarSongs2.view", s.getSimilarSongs2)
+	mux.HandleFunc("/rest/getSongsByGenre.view", s.getSongsByGenre)
+	mux.HandleFunc("/rest/getStarred.view", s.getStarred)
+	mux.HandleFunc("/rest/getStarred2.view", s.getStarred2)
+	mux.HandleFunc("/rest/getTopSongs.view", s.getTopSongs)
+	mux.HandleFunc("/rest/getUser.view", s.getUser)
+	mux.HandleFunc("/rest/getUsers.view", s.requireRole(adminRole, s.getUsers))
+	mux.HandleFunc("/rest/jukeboxControl.view", s.requireRole(jukeboxRole, s.jukeboxControl))
+	mux.HandleFunc("/rest/nowPlayingEvents.view", s.nowPlayingEvents)
+	mux.HandleFunc("/rest/outputControl.view", s.requireRole(jukeboxRole, s.outputControl))
 	mux.HandleFunc("/rest/ping.view", s.ping)
-	mux.HandleFunc("/rest/stream.view", s.stream)
+	mux.HandleFunc("/rest/queueSong.view", s.mutating(s.requireRole(jukeboxRole, s.queueSong)))
+	mux.HandleFunc("/rest/savePlayQueue.view", s.mutating(s.savePlayQueue))
+	mux.HandleFunc("/rest/scrobble.view", s.scrobble)
+	mux.HandleFunc("/rest/search2.view", s.search2)
+	mux.HandleFunc("/rest/search3.view", s.search3)
+	mux.HandleFunc("/rest/searchFilter.view", s.searchFilter)
+	mux.HandleFunc("/rest/setRating.view", s.mutating(s.setRating))
+	mux.HandleFunc("/rest/star.view", s.mutating(s.star))
+	mux.HandleFunc("/rest/stream.view", s.requireRole(streamRole, s.stream))
+	mux.HandleFunc("/rest/unstar.view", s.mutating(s.unstar))
+	mux.HandleFunc("/rest/updatePlaylist.view", s.mutating(s.requireRole(playlistRole, s.updatePlaylist)))
+	mux.HandleFunc("/rest/updateUser.view", s.mutating(s.requireRole(adminRole, s.updateUser)))
 
 	s.mux = mux
 
@@ -101,7 +795,36 @@ func newServer(db database, fs filesystem, cfg *Config) *Server {
 		go s.keepalive(ctx)
 	}
 
-	return s
+	if cfg.PlayerEvents != nil {
+		s.wg.Add(1)
+		go s.watchEvents(ctx, cfg.PlayerEvents)
+	} else if cfg.PollInterval > 0 {
+		events := make(chan string)
+
+		s.wg.Add(2)
+		go s.pollDatabase(ctx, events, cfg.PollInterval)
+		go s.watchEvents(ctx, events)
+	}
+
+	if cfg.StateFile != "" {
+		s.wg.Add(1)
+		go s.flushStore(ctx)
+	}
+
+	s.jobs.start(ctx, s.wg)
+
+	if cfg.CheckMusicDirectory {
+		s.wg.Add(1)
+		go s.startupCheck()
+	}
+
+	if cfg.WatchMusicDirectory {
+		if err := s.startWatcher(ctx); err != nil {
+			s.logf("failed to watch music directory: %v", err)
+		}
+	}
+
+	return s, nil
 }
 
 // keepalive sends keepalive messages to the database at regular intervals,
@@ -142,21 +865,52 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Connection", "close")
+	if s.degraded() {
+		w.Header().Set(degradedHeader, "true")
+	}
 
-	rctx, ok := parseRequestContext(r)
-	if !ok {
-		// Subsonic API returns HTTP 200 on missing parameters
-		writeXML(w, errMissingParameter)
+	// Reject pathological requests before doing any work on their behalf
+	if err := checkParameterLimits(r.URL.Path, r.URL.RawQuery, r.URL.Query()); err != nil {
+		if s.cfg.Verbose {
+			s.logf("%s -> rejected request: %v", r.RemoteAddr, err)
+		}
+
+		writeXML(w, errParameterLimit(err))
 		return
 	}
 
-	if !s.authenticate(rctx) {
-		// Subsonic API returns HTTP 200 on invalid authentication
-		writeXML(w, errUnauthorized)
+	// Renderers handed a stream URL authenticate using a single-use token
+	// in place of Subsonic credentials
+	rctx, ok := s.redeemStreamToken(r)
+	if !ok {
+		// Browser-based clients authenticate using a session cookie
+		rctx, ok = s.sessionContext(r)
+	}
+	if !ok {
+		rctx, ok = parseRequestContext(r)
+		if !ok {
+			// Subsonic API returns HTTP 200 on missing parameters
+			writeXML(w, errMissingParameter)
+			return
+		}
+
+		if !s.authenticate(rctx) {
+			// Subsonic API returns HTTP 200 on invalid authentication
+			writeXML(w, errUnauthorized)
+			return
+		}
+	}
+
+	// Access windows apply regardless of how the user authenticated
+	if ws, ok := s.cfg.AccessWindows[rctx.User]; ok && !inAccessWindows(ws, time.Now()) {
+		writeXML(w, errAccessWindow(rctx.User, ws))
 		return
 	}
 
-	s.mux.ServeHTTP(w, r)
+	w = s.filterFields(w, r, rctx.Client)
+
+	// Make the requestContext available to handlers
+	s.mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestContextKey, rctx)))
 }
 
 // logf is a convenience function to create a formatted log entry using the
@@ -181,19 +935,20 @@ const (
 // authenticate attempts to authenticate a user using the input requestContext.
 // It returns true if authentication is successful, or false if not.
 func (s *Server) authenticate(rctx *requestContext) bool {
-	if rctx.User != s.cfg.SubsonicUser {
+	u, ok := s.lookupUser(rctx.User)
+	if !ok {
 		return false
 	}
 
 	switch rctx.authMethod {
 	case authMethodPassword:
-		return rctx.Password == s.cfg.SubsonicPassword
+		return rctx.Password == u.Password
 	case authMethodTokenSalt:
 		// From Subsonic documentation:
 		// http://www.subsonic.org/pages/api.jsp
 		//   token = md5(password + salt)
 		h := md5.New()
-		_, _ = io.WriteString(h, s.cfg.SubsonicPassword+rctx.Salt)
+		_, _ = io.WriteString(h, u.Password+rctx.Salt)
 		return rctx.Token == hex.EncodeToString(h.Sum(nil))
 	default:
 		return false
@@ -212,6 +967,24 @@ type requestContext struct {
 	authMethod authMethod
 }
 
+// A contextKey is a key for a value stored in a HTTP request's context.
+type contextKey int
+
+// requestContextKey is the key for the requestContext stored in a HTTP
+// request's context by ServeHTTP.
+const requestContextKey contextKey = iota
+
+// requestContextFrom retrieves the requestContext for an authenticated HTTP
+// request.  If none is present, an empty requestContext is returned.
+func requestContextFrom(r *http.Request) *requestContext {
+	rctx, ok := r.Context().Value(requestContextKey).(*requestContext)
+	if !ok {
+		return &requestContext{}
+	}
+
+	return rctx
+}
+
 // parseRequestContext parses parameters from a HTTP request into a requestContext.
 // If any mandatory parameters are missing, it returns false.
 func parseRequestContext(r *http.Request) (*requestContext, bool) {
@@ -219,7 +992,8 @@ func parseRequestContext(r *http.Request) (*requestContext, bool) {
 
 	user := q.Get("u")
 	if user == "" {
-		return nil, false
+		// Fall back to HTTP Basic authentication, if available
+		return parseBasicAuth(r)
 	}
 
 	client := q.Get("c")
@@ -269,6 +1043,43 @@ func parseRequestContext(r *http.Request) (*requestContext, bool) {
 	}, true
 }
 
+// basicAuthClient is the client name used for requests authenticated using
+// HTTP Basic authentication which do not specify a client name.
+const basicAuthClient = "http-basic"
+
+// parseBasicAuth parses HTTP Basic authentication credentials from a HTTP
+// request into a requestContext, for simple scripts and proxies which cannot
+// supply Subsonic authentication parameters.  The client and version
+// parameters are optional when using HTTP Basic authentication.  If no
+// credentials are present, it returns false.
+func parseBasicAuth(r *http.Request) (*requestContext, bool) {
+	user, pass, ok := r.BasicAuth()
+	if !ok || user == "" || pass == "" {
+		return nil, false
+	}
+
+	q := r.URL.Query()
+
+	client := q.Get("c")
+	if client == "" {
+		client = basicAuthClient
+	}
+
+	version := q.Get("v")
+	if version == "" {
+		version = apiVersion
+	}
+
+	return &requestContext{
+		User:     user,
+		Password: pass,
+		Client:   client,
+		Version:  version,
+
+		authMethod: authMethodPassword,
+	}, true
+}
+
 // decodePassword decodes a password, if necessary, from its encoded hex
 // format.  If the password is not encoded, the input string is returned.
 func decodePassword(p string) string {
@@ -13,9 +13,13 @@ func TestServerClose(t *testing.T) {
 		pingC: pingC,
 	}
 
-	s := newServer(db, nil, &Config{
+	s, err := newServer(db, nil, &Config{
 		Keepalive: 10 * time.Millisecond,
 	})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
 	for i := 0; i < 3; i++ {
 		<-pingC
 	}
@@ -29,9 +33,11 @@ func TestServerServeHTTP(t *testing.T) {
 		db   database
 		cfg  *Config
 
-		method string
-		target string
-		values url.Values
+		method   string
+		target   string
+		values   url.Values
+		user     string
+		password string
 
 		httpCode int
 		status   string
@@ -186,6 +192,35 @@ func TestServerServeHTTP(t *testing.T) {
 
 			status: statusOK,
 		},
+		{
+			name: "incorrect basic auth password",
+			cfg: &Config{
+				SubsonicUser:     "test",
+				SubsonicPassword: "test",
+			},
+
+			method:   http.MethodGet,
+			target:   "/rest/ping.view",
+			user:     "test",
+			password: "foo",
+
+			code:   codeUnauthorized,
+			status: statusFailed,
+		},
+		{
+			name: "OK basic auth",
+			cfg: &Config{
+				SubsonicUser:     "test",
+				SubsonicPassword: "test",
+			},
+
+			method:   http.MethodGet,
+			target:   "/rest/ping.view",
+			user:     "test",
+			password: "test",
+
+			status: statusOK,
+		},
 		{
 			name: "OK token and salt",
 			cfg: &Config{
@@ -211,7 +246,12 @@ func TestServerServeHTTP(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			withServer(t, tt.db, nil, tt.cfg, func(base string) {
-				res := testRequest(t, base, tt.method, tt.target, tt.values)
+				var res *http.Response
+				if tt.user != "" {
+					res = basicAuthRequest(t, base, tt.target, tt.user, tt.password)
+				} else {
+					res = testRequest(t, base, tt.method, tt.target, tt.values)
+				}
 
 				if tt.httpCode != 0 {
 					if want, got := tt.httpCode, res.StatusCode; want != got {
@@ -236,3 +276,20 @@ func TestServerServeHTTP(t *testing.T) {
 		})
 	}
 }
+
+// basicAuthRequest performs a HTTP GET request using HTTP Basic authentication
+// against the server specified by base.
+func basicAuthRequest(t *testing.T, base string, target string, user string, password string) *http.Response {
+	r, err := http.NewRequest(http.MethodGet, base+target, nil)
+	if err != nil {
+		t.Fatalf("failed to create HTTP request: %v", err)
+	}
+	r.SetBasicAuth(user, password)
+
+	res, err := (&http.Client{}).Do(r)
+	if err != nil {
+		t.Fatalf("failed to perform HTTP request: %v", err)
+	}
+
+	return res
+}
@@ -0,0 +1,205 @@
+package mpdsub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSessionTTL is the default amount of time for which a session
+	// remains valid after it was last used.
+	defaultSessionTTL = 24 * time.Hour
+
+	// sessionCookie is the name of the cookie which carries a session ID.
+	sessionCookie = "mpdsub_session"
+)
+
+// A session authenticates requests from a browser-based client using a
+// cookie, in place of Subsonic credentials.
+type session struct {
+	User    string
+	Client  string
+	Expires time.Time
+}
+
+// sessions stores active sessions.
+type sessions struct {
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+// create creates a new session, and removes any expired sessions.
+func (ss *sessions) create(sn session, now time.Time) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.sessions == nil {
+		ss.sessions = make(map[string]session)
+	}
+
+	ss.prune(now)
+	ss.sessions[id] = sn
+	return id, nil
+}
+
+// evict removes sessions which expired before now.
+func (ss *sessions) evict(now time.Time) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.prune(now)
+}
+
+// prune removes sessions which expired before now.  The caller must hold
+// ss.mu.
+func (ss *sessions) prune(now time.Time) {
+	for k, v := range ss.sessions {
+		if now.After(v.Expires) {
+			delete(ss.sessions, k)
+		}
+	}
+}
+
+// touch looks up a session, extending its expiry time to now plus ttl if
+// the session exists and has not expired.
+func (ss *sessions) touch(id string, now time.Time, ttl time.Duration) (session, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sn, ok := ss.sessions[id]
+	if !ok {
+		return session{}, false
+	}
+	if now.After(sn.Expires) {
+		delete(ss.sessions, id)
+		return session{}, false
+	}
+
+	sn.Expires = now.Add(ttl)
+	ss.sessions[id] = sn
+	return sn, true
+}
+
+// remove ends a session.
+func (ss *sessions) remove(id string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	delete(ss.sessions, id)
+}
+
+// revoke ends every session of user.
+func (ss *sessions) revoke(user string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for k, v := range ss.sessions {
+		if v.User == user {
+			delete(ss.sessions, k)
+		}
+	}
+}
+
+// sessionTTL returns the amount of time for which a session remains valid
+// after it was last used.
+func (s *Server) sessionTTL() time.Duration {
+	if s.cfg.SessionTTL > 0 {
+		return s.cfg.SessionTTL
+	}
+
+	return defaultSessionTTL
+}
+
+// sessionContext produces a requestContext for a request which carries a
+// valid session cookie.  If web sessions are disabled or the request has no
+// valid session, it returns false.
+func (s *Server) sessionContext(r *http.Request) (*requestContext, bool) {
+	if !s.cfg.WebSessions {
+		return nil, false
+	}
+
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, false
+	}
+
+	sn, ok := s.sessions.touch(c.Value, time.Now(), s.sessionTTL())
+	if !ok {
+		return nil, false
+	}
+
+	client := r.URL.Query().Get("c")
+	if client == "" {
+		client = sn.Client
+	}
+
+	return &requestContext{
+		User:    sn.User,
+		Client:  client,
+		Version: apiVersion,
+	}, true
+}
+
+// createSession is a custom endpoint which exchanges Subsonic credentials for
+// a session cookie, so that a browser-based client, such as a web UI, need
+// not embed the Subsonic password in every URL it requests.  The cookie is
+// only sent with same-site requests, and is not readable by scripts.
+func (s *Server) createSession(w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.WebSessions {
+		writeXML(w, errNotAuthorized)
+		return
+	}
+
+	rctx := requestContextFrom(r)
+	now := time.Now()
+
+	id, err := s.sessions.create(session{
+		User:    rctx.User,
+		Client:  rctx.Client,
+		Expires: now.Add(s.sessionTTL()),
+	}, now)
+	if err != nil {
+		s.logf("error creating session: %v", err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/rest/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	writeXML(w, nil)
+}
+
+// deleteSession is a custom endpoint which ends the session carried by a
+// request's session cookie, if any, and clears the cookie.
+func (s *Server) deleteSession(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		s.sessions.remove(c.Value)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/rest/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	writeXML(w, nil)
+}
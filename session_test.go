@@ -0,0 +1,104 @@
+package mpdsub
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestServer_webSessions(t *testing.T) {
+	cfg, values := configAuth()
+	cfg.WebSessions = true
+
+	withServer(t, &memoryDatabase{}, nil, cfg, func(base string) {
+		res := testRequest(t, base, http.MethodGet, "/rest/createSession.view", values)
+		if c := mustDecodeXML(t, res); c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+
+		var cookie *http.Cookie
+		for _, c := range res.Cookies() {
+			if c.Name == sessionCookie {
+				cookie = c
+			}
+		}
+		if cookie == nil {
+			t.Fatal("no session cookie in response")
+		}
+		if !cookie.HttpOnly {
+			t.Fatal("session cookie is readable by scripts")
+		}
+
+		// The session authenticates requests without credentials
+		c := mustDecodeXML(t, cookieRequest(t, base, "/rest/getLicense.view", cookie))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+
+		c = mustDecodeXML(t, cookieRequest(t, base, "/rest/deleteSession.view", cookie))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+
+		c = mustDecodeXML(t, cookieRequest(t, base, "/rest/getLicense.view", cookie))
+		if c.Error == nil || c.Error.Code != codeMissingParameter {
+			t.Fatalf("expected missing parameter error, but got: %+v", c.Error)
+		}
+	})
+}
+
+func TestServer_webSessionsDisabled(t *testing.T) {
+	cfg, values := configAuth()
+
+	withServer(t, &memoryDatabase{}, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/createSession.view", values))
+		if c.Error == nil || c.Error.Code != codeNotAuthorized {
+			t.Fatalf("expected not authorized error, but got: %+v", c.Error)
+		}
+	})
+}
+
+func Test_sessionsExpire(t *testing.T) {
+	var ss sessions
+	now := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	id, err := ss.create(session{User: "test", Expires: now.Add(time.Hour)}, now)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	// Using a session extends its expiry time
+	if _, ok := ss.touch(id, now.Add(50*time.Minute), time.Hour); !ok {
+		t.Fatal("expected session to be valid")
+	}
+	if _, ok := ss.touch(id, now.Add(100*time.Minute), time.Hour); !ok {
+		t.Fatal("expected session to be extended")
+	}
+	if _, ok := ss.touch(id, now.Add(200*time.Minute), time.Hour); ok {
+		t.Fatal("expected session to expire")
+	}
+}
+
+// cookieRequest performs a HTTP GET request carrying a cookie, and no
+// Subsonic credentials.
+func cookieRequest(t *testing.T, base string, target string, cookie *http.Cookie) *http.Response {
+	u, err := url.Parse(base)
+	if err != nil {
+		t.Fatalf("failed to parse test server URL: %v", err)
+	}
+	u.Path = target
+
+	r, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		t.Fatalf("failed to create HTTP request: %v", err)
+	}
+	r.AddCookie(cookie)
+
+	res, err := (&http.Client{}).Do(r)
+	if err != nil {
+		t.Fatalf("failed to perform HTTP request: %v", err)
+	}
+
+	return res
+}
@@ -0,0 +1,427 @@
+package mpdsub
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// Weights applied to each kind of feature shared between two artists when
+// computing their similarity.  Sharing a folder or playlist is a much stronger
+// signal than sharing a genre.
+const (
+	weightGenre    = 1.0
+	weightFolder   = 3.0
+	weightPlaylist = 2.0
+)
+
+// defaultSimilarSongs is the default number of songs returned by getSimilarSongs.
+const defaultSimilarSongs = 50
+
+// maxLastFMSimilar is the maximum number of similar songs or artists
+// requested from Last.fm, most of which may not be in MPD's database.
+const maxLastFMSimilar = 100
+
+// A similarityModel is a purely local model of artist similarity, derived from
+// the co-occurrence of artists in genres, folders, and MPD stored playlists.
+type similarityModel struct {
+	// features maps each artist to the weighted features they appear with.
+	features map[string]map[string]float64
+	// songs maps each artist to their songs.
+	songs map[string][]mpd.Attrs
+}
+
+// newSimilarityModel builds a similarityModel from all songs in MPD's database
+// and a map of stored playlist names to their songs.
+func newSimilarityModel(songs []mpd.Attrs, playlists map[string][]mpd.Attrs, genres *genreMap) *similarityModel {
+	m := &similarityModel{
+		features: make(map[string]map[string]float64),
+		songs:    make(map[string][]mpd.Attrs),
+	}
+
+	add := func(artist string, feature string, weight float64) {
+		fs, ok := m.features[artist]
+		if !ok {
+			fs = make(map[string]float64)
+			m.features[artist] = fs
+		}
+
+		fs[feature] += weight
+	}
+
+	for _, s := range songs {
+		artist := songArtist(s)
+		if artist == "" {
+			continue
+		}
+
+		m.songs[artist] = append(m.songs[artist], s)
+
+		for _, g := range genres.Normalize(s["Genre"]) {
+			add(artist, "genre:"+strings.ToLower(g), weightGenre)
+		}
+
+		add(artist, "folder:"+path.Dir(s["file"]), weightFolder)
+	}
+
+	for name, ss := range playlists {
+		for _, s := range ss {
+			if artist := songArtist(s); artist != "" {
+				add(artist, "playlist:"+name, weightPlaylist)
+			}
+		}
+	}
+
+	return m
+}
+
+// SimilarArtists returns up to n artists which are similar to the input
+// artist, ordered from most to least similar.
+func (m *similarityModel) SimilarArtists(artist string, n int) []string {
+	a, ok := m.features[artist]
+	if !ok {
+		return nil
+	}
+
+	var scores []artistScore
+	for other, b := range m.features {
+		if other == artist {
+			continue
+		}
+
+		if score := cosine(a, b); score > 0 {
+			scores = append(scores, artistScore{Artist: other, Score: score})
+		}
+	}
+	sort.Sort(byScore(scores))
+
+	if len(scores) > n {
+		scores = scores[:n]
+	}
+
+	out := make([]string, 0, len(scores))
+	for _, s := range scores {
+		out = append(out, s.Artist)
+	}
+
+	return out
+}
+
+// SimilarSongs returns a random selection of up to n songs by the input
+// artist and artists similar to them.
+func (m *similarityModel) SimilarSongs(artist string, n int, rng *rand.Rand) []mpd.Attrs {
+	var pool []mpd.Attrs
+	pool = append(pool, m.songs[artist]...)
+	for _, a := range m.SimilarArtists(artist, 10) {
+		pool = append(pool, m.songs[a]...)
+	}
+
+	out := make([]mpd.Attrs, 0, n)
+	for _, i := range rng.Perm(len(pool)) {
+		if len(out) == n {
+			break
+		}
+
+		out = append(out, pool[i])
+	}
+
+	return out
+}
+
+// cosine computes the cosine similarity of two weighted feature sets.
+func cosine(a, b map[string]float64) float64 {
+	var dot, na, nb float64
+	for k, v := range a {
+		dot += v * b[k]
+		na += v * v
+	}
+	for _, v := range b {
+		nb += v * v
+	}
+
+	if na == 0 || nb == 0 {
+		return 0
+	}
+
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// songArtist returns the artist of a song, preferring the album artist tag.
+func songArtist(a mpd.Attrs) string {
+	if aa := a["AlbumArtist"]; aa != "" {
+		return aa
+	}
+
+	return a["Artist"]
+}
+
+// An artistScore is an artist with a similarity score.
+type artistScore struct {
+	Artist string
+	Score  float64
+}
+
+// byScore sorts artistScores by descending score, and then by artist name.
+type byScore []artistScore
+
+func (b byScore) Len() int      { return len(b) }
+func (b byScore) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byScore) Less(i, j int) bool {
+	if b[i].Score != b[j].Score {
+		return b[i].Score > b[j].Score
+	}
+
+	return b[i].Artist < b[j].Artist
+}
+
+// similarityModel builds a similarityModel using the current contents of
+// MPD's database and stored playlists.
+func (s *Server) similarityModel() (*similarityModel, error) {
+	songs, err := s.db.ListAllInfo("")
+	if err != nil {
+		return nil, err
+	}
+
+	pls, err := s.db.ListPlaylists()
+	if err != nil {
+		return nil, err
+	}
+
+	playlists := make(map[string][]mpd.Attrs, len(pls))
+	for _, p := range pls {
+		name := p["playlist"]
+
+		ss, err := s.db.PlaylistContents(name)
+		if err != nil {
+			return nil, err
+		}
+
+		playlists[name] = ss
+	}
+
+	return newSimilarityModel(s.exclude.filterSongs(songs), playlists, s.genres), nil
+}
+
+// getSimilarSongs returns a random selection of songs by the artist of the
+// input song, album, or artist ID, and by similar artists, organized by
+// directory.
+func (s *Server) getSimilarSongs(w http.ResponseWriter, r *http.Request) {
+	songs, ok := s.similarSongs(w, r)
+	if !ok {
+		return
+	}
+
+	writeXML(w, func(c *container) {
+		c.SimilarSongs = &similarSongsContainer{
+			Songs: songs,
+		}
+	})
+}
+
+// getSimilarSongs2 is like getSimilarSongs, but for artist IDs obtained
+// when browsing by tags.
+func (s *Server) getSimilarSongs2(w http.ResponseWriter, r *http.Request) {
+	songs, ok := s.similarSongs(w, r)
+	if !ok {
+		return
+	}
+
+	writeXML(w, func(c *container) {
+		c.SimilarSongs2 = &similarSongs2Container{
+			Songs: songs,
+		}
+	})
+}
+
+// similarSongs selects the songs similar to the song, album, or artist
+// identified by a request's id parameter.  If Last.fm is configured, songs
+// are selected using its similarity data, limited to songs in MPD's
+// database.  Otherwise, or if Last.fm knows of no similar songs in MPD's
+// database, similar artists are determined using the local
+// similarityModel, so no external services are required.  If the request
+// is invalid, an error is written to w and false is returned.
+func (s *Server) similarSongs(w http.ResponseWriter, r *http.Request) ([]song, bool) {
+	q := r.URL.Query()
+
+	qID := q.Get("id")
+	if qID == "" {
+		writeXML(w, errMissingParameter)
+		return nil, false
+	}
+
+	count := defaultSimilarSongs
+	if c := q.Get("count"); c != "" {
+		var err error
+		if count, err = strconv.Atoi(c); err != nil || count < 0 {
+			writeXML(w, errGeneric)
+			return nil, false
+		}
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		s.logf("error listing files from mpd for similar songs: %v", err)
+		writeXML(w, errMPD(err))
+		return nil, false
+	}
+	files := indexFiles(fs)
+
+	id, ok := s.parseID(qID, len(files))
+	if !ok {
+		writeXML(w, errGeneric)
+		return nil, false
+	}
+
+	if id >= len(files) {
+		writeXML(w, errNotFound)
+		return nil, false
+	}
+
+	m, err := s.similarityModel()
+	if err != nil {
+		s.logf("error building similarity model: %v", err)
+		writeXML(w, errGeneric)
+		return nil, false
+	}
+
+	artist := m.artistOf(files[id])
+	if artist == "" {
+		writeXML(w, errNotFound)
+		return nil, false
+	}
+
+	rng := rand.New(rand.NewSource(rand.Int63()))
+
+	var similar []mpd.Attrs
+	if s.lastFM != nil {
+		similar = s.lastFMSimilarSongs(r.Context(), m, files[id], artist, count, rng)
+	}
+	if len(similar) == 0 {
+		similar = m.SimilarSongs(artist, count, rng)
+	}
+
+	children, err := s.songChildren(requestContextFrom(r).User, similar)
+	if err != nil {
+		s.logf("error building similar songs: %v", err)
+		writeXML(w, errGeneric)
+		return nil, false
+	}
+
+	songs := make([]song, 0, len(children))
+	for _, c := range children {
+		songs = append(songs, song{child: c})
+	}
+
+	return songs, true
+}
+
+// lastFMSimilarSongs returns up to n songs in m which Last.fm considers
+// similar to seed, by artist: first, the songs similar to seed if it is a
+// song, most similar first, and then a random selection of songs by artist
+// and the artists similar to them.  Errors from Last.fm are logged, and
+// result in fewer or no songs.
+func (s *Server) lastFMSimilarSongs(ctx context.Context, m *similarityModel, seed indexedFile, artist string, n int, rng *rand.Rand) []mpd.Attrs {
+	var (
+		out  = make([]mpd.Attrs, 0, n)
+		seen = map[string]struct{}{seed.Name: {}}
+	)
+
+	add := func(a mpd.Attrs) {
+		if _, ok := seen[a["file"]]; ok || len(out) == n {
+			return
+		}
+
+		seen[a["file"]] = struct{}{}
+		out = append(out, a)
+	}
+
+	// Songs are matched by both their artist and album artist, since
+	// Last.fm knows of songs on compilations by their performers
+	if a := m.song(seed.Name); a != nil && a["Title"] != "" {
+		byTrack := make(map[string][]mpd.Attrs)
+		for _, ss := range m.songs {
+			for _, a := range ss {
+				for _, k := range []string{a["Artist"], songArtist(a)} {
+					key := strings.ToLower(k) + "\x00" + strings.ToLower(a["Title"])
+					byTrack[key] = append(byTrack[key], a)
+				}
+			}
+		}
+
+		tracks, err := s.lastFM.similarTracks(ctx, firstTag(a, "Artist", "AlbumArtist"), a["Title"], maxLastFMSimilar)
+		if err != nil {
+			s.logf("error retrieving similar songs for %q from last.fm: %v", seed.Name, err)
+		}
+		for _, t := range tracks {
+			for _, a := range byTrack[strings.ToLower(t.Artist)+"\x00"+strings.ToLower(t.Title)] {
+				add(a)
+			}
+		}
+	}
+
+	artists, err := s.lastFM.similarArtists(ctx, artist, maxLastFMSimilar)
+	if err != nil {
+		s.logf("error retrieving similar artists for %q from last.fm: %v", artist, err)
+		return out
+	}
+
+	local := make(map[string]string, len(m.songs))
+	for a := range m.songs {
+		local[strings.ToLower(a)] = a
+	}
+
+	var pool []mpd.Attrs
+	for _, name := range artists {
+		if a, ok := local[strings.ToLower(name)]; ok {
+			pool = append(pool, m.songs[a]...)
+		}
+	}
+
+	// Without any similar artists in MPD's database, songs by the artist
+	// alone do not make for a radio station
+	if len(pool) == 0 {
+		return out
+	}
+	pool = append(pool, m.songs[artist]...)
+
+	for _, i := range rng.Perm(len(pool)) {
+		add(pool[i])
+	}
+
+	return out
+}
+
+// song returns the attributes of the song with the specified name, or nil
+// if m has no such song.
+func (m *similarityModel) song(name string) mpd.Attrs {
+	for _, ss := range m.songs {
+		for _, s := range ss {
+			if s["file"] == name {
+				return s
+			}
+		}
+	}
+
+	return nil
+}
+
+// artistOf determines the artist for an indexed file or directory, using
+// the first song found at or beneath its path.
+func (m *similarityModel) artistOf(f indexedFile) string {
+	for artist, ss := range m.songs {
+		for _, s := range ss {
+			if s["file"] == f.Name || (f.Dir && strings.HasPrefix(s["file"], f.Name+"/")) {
+				return artist
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,232 @@
+package mpdsub
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func Test_similarityModelSimilarArtists(t *testing.T) {
+	songs := []mpd.Attrs{
+		{"file": "Metal/Iron Maiden/Aces High.mp3", "Artist": "Iron Maiden", "Genre": "Metal"},
+		{"file": "Metal/Judas Priest/Painkiller.mp3", "Artist": "Judas Priest", "Genre": "Metal"},
+		{"file": "Compilations/Metal Hits/01.mp3", "Artist": "Iron Maiden", "Genre": "Metal"},
+		{"file": "Compilations/Metal Hits/02.mp3", "Artist": "Saxon", "Genre": "Metal"},
+		{"file": "Pop/Madonna/Vogue.mp3", "Artist": "Madonna", "Genre": "Pop"},
+		{"file": "Pop/Kylie Minogue/Spinning Around.mp3", "Artist": "Kylie Minogue", "Genre": "Pop"},
+	}
+
+	playlists := map[string][]mpd.Attrs{
+		"party": {
+			{"file": "Pop/Madonna/Vogue.mp3", "Artist": "Madonna"},
+			{"file": "Metal/Judas Priest/Painkiller.mp3", "Artist": "Judas Priest"},
+		},
+	}
+
+	m := newSimilarityModel(songs, playlists, newGenreMap(nil, ""))
+
+	tests := []struct {
+		artist  string
+		similar []string
+	}{
+		{
+			artist: "Unknown",
+		},
+		{
+			// Sharing a compilation folder ranks Saxon above Judas Priest
+			artist:  "Iron Maiden",
+			similar: []string{"Saxon", "Judas Priest"},
+		},
+		{
+			// Sharing a playlist outweighs sharing a genre
+			artist:  "Madonna",
+			similar: []string{"Judas Priest", "Kylie Minogue"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.artist, func(t *testing.T) {
+			similar := m.SimilarArtists(tt.artist, 2)
+
+			if want, got := tt.similar, similar; !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected similar artists:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+
+	ss := m.SimilarSongs("Saxon", 10, rand.New(rand.NewSource(1)))
+	for _, s := range ss {
+		if s["Genre"] != "Metal" {
+			t.Fatalf("unexpected non-metal song for Saxon: %v", s)
+		}
+	}
+}
+
+func TestServer_getSimilarSongs(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Metal/Iron Maiden/Aces High.mp3",
+			"Metal/Judas Priest/Painkiller.mp3",
+			"Pop/Madonna/Vogue.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "Metal/Iron Maiden/Aces High.mp3", "Artist": "Iron Maiden", "Genre": "Metal"},
+			{"file": "Metal/Judas Priest/Painkiller.mp3", "Artist": "Judas Priest", "Genre": "Metal"},
+			{"file": "Pop/Madonna/Vogue.mp3", "Artist": "Madonna", "Genre": "Pop"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		id   string
+
+		xmlError *subsonicError
+		songs    int
+	}{
+		{
+			name: "no ID",
+
+			xmlError: &subsonicError{Code: codeMissingParameter},
+		},
+		{
+			name: "out of range ID",
+			id:   "100",
+
+			xmlError: &subsonicError{Code: codeNotFound},
+		},
+		{
+			name: "artist directory",
+			id:   "1",
+
+			songs: 2,
+		},
+		{
+			name: "song",
+			id:   "7",
+
+			songs: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			if tt.id != "" {
+				values.Set("id", tt.id)
+			}
+
+			withServer(t, db, nil, cfg, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getSimilarSongs.view", values))
+
+				if tt.xmlError != nil {
+					if want, got := tt.xmlError.Code, c.Error.Code; want != got {
+						t.Fatalf("unexpected XML error code:\n- want: %v\n-  got: %v",
+							want, got)
+					}
+
+					return
+				}
+
+				if c.SimilarSongs == nil {
+					t.Fatal("similar songs is nil")
+				}
+
+				if want, got := tt.songs, len(c.SimilarSongs.Songs); want != got {
+					t.Fatalf("unexpected number of similar songs:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		})
+	}
+}
+
+func TestServer_getSimilarSongsLastFM(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Metal/Iron Maiden/Aces High.mp3",
+			"Metal/Judas Priest/Painkiller.mp3",
+			"Metal/Saxon/Crusader.mp3",
+			"Pop/Madonna/Vogue.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "Metal/Iron Maiden/Aces High.mp3", "Artist": "Iron Maiden", "Title": "Aces High", "Genre": "Metal"},
+			{"file": "Metal/Judas Priest/Painkiller.mp3", "Artist": "Judas Priest", "Title": "Painkiller", "Genre": "Metal"},
+			{"file": "Metal/Saxon/Crusader.mp3", "Artist": "Saxon", "Title": "Crusader", "Genre": "Metal"},
+			{"file": "Pop/Madonna/Vogue.mp3", "Artist": "Madonna", "Title": "Vogue", "Genre": "Pop"},
+		},
+	}
+
+	lastFM := func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("artist") != "Iron Maiden" {
+			fmt.Fprint(w, `{"error":6,"message":"The artist you supplied could not be found"}`)
+			return
+		}
+
+		switch q.Get("method") {
+		case "track.getSimilar":
+			fmt.Fprint(w, `{"similartracks":{"track":[
+				{"name":"Not Here","artist":{"name":"Nobody"}},
+				{"name":"painkiller","artist":{"name":"Judas Priest"}}]}}`)
+		case "artist.getSimilar":
+			fmt.Fprint(w, `{"similarartists":{"artist":[{"name":"Manowar"},{"name":"Saxon"}]}}`)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		id     string
+		titles []string
+	}{
+		{
+			name:   "song",
+			path:   "getSimilarSongs",
+			id:     "2",
+			titles: []string{"Painkiller", "Crusader"},
+		},
+		{
+			name:   "unknown to last.fm",
+			path:   "getSimilarSongs2",
+			id:     "8",
+			titles: []string{"Vogue"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, values := configAuth()
+			cfg.LastFM = &LastFM{APIKey: "key"}
+			values.Set("id", tt.id)
+
+			withLastFM(t, db, cfg, lastFM, func(base string) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/"+tt.path+".view", values))
+				if c.Error != nil {
+					t.Fatalf("unexpected error: %v", c.Error.Message)
+				}
+
+				var songs []song
+				switch {
+				case c.SimilarSongs != nil:
+					songs = c.SimilarSongs.Songs
+				case c.SimilarSongs2 != nil:
+					songs = c.SimilarSongs2.Songs
+				default:
+					t.Fatal("no similar songs in response")
+				}
+
+				var titles []string
+				for _, s := range songs {
+					titles = append(titles, s.Title)
+				}
+
+				if want, got := tt.titles, titles; !reflect.DeepEqual(want, got) {
+					t.Fatalf("unexpected similar songs:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package mpdsub
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// A SmartPlaylist is a playlist defined by a saved query, which is evaluated
+// against MPD's database each time the playlist is requested.
+type SmartPlaylist struct {
+	// Name is the name of the playlist displayed to Subsonic clients.
+	Name string
+
+	// Query is the query used to select songs for the playlist.  It may
+	// either be a MPD filter expression, such as:
+	//   ((genre == "Metal") AND (artist != "Iron Maiden"))
+	// or a list of field-scoped terms, where each term is matched using
+	// MPD's case-insensitive search, such as:
+	//   genre:metal artist:"Iron Maiden" live
+	// Terms without a field prefix are matched against any tag, and either
+	// ":" or "=" may separate a field from its value.  Terms may also
+	// compare the year a song was released, such as:
+	//   genre=jazz AND year>1990
+	// or compare the number of times the requesting user played a song,
+	// such as "plays=0" for songs which were never played.  MPD cannot do
+	// either itself, so years and plays are compared by the Server.
+	// Every term must match, so terms may optionally be joined by AND, but
+	// OR and NOT are not supported outside of filter expressions.
+	Query string
+
+	// ModifiedWithin optionally restricts the playlist to songs which were
+	// added or modified in the MPD database within the specified duration,
+	// enabling playlists such as "recently added".
+	ModifiedWithin time.Duration
+
+	// Limit optionally specifies the maximum number of songs in the
+	// playlist.  If Limit is 0, all matching songs are returned.
+	Limit int
+}
+
+// errEmptyQuery is returned when a SmartPlaylist has no criteria.
+var errEmptyQuery = errors.New("smart playlist must specify a query or modification window")
+
+// validateSmartPlaylists verifies that each SmartPlaylist has a unique name
+// and a valid query, so that errors are reported when the Server is created
+// rather than each time the playlists are requested.
+func validateSmartPlaylists(ps []SmartPlaylist) error {
+	names := make(map[string]struct{}, len(ps))
+	for _, p := range ps {
+		if p.Name == "" {
+			return fmt.Errorf("smart playlist %q: name must not be empty", p.Query)
+		}
+		if _, ok := names[p.Name]; ok {
+			return fmt.Errorf("smart playlist %q: duplicate name", p.Name)
+		}
+		names[p.Name] = struct{}{}
+
+		if p.Limit < 0 {
+			return fmt.Errorf("smart playlist %q: limit must not be negative", p.Name)
+		}
+		if _, _, err := p.searchArgs(time.Now()); err != nil {
+			return fmt.Errorf("smart playlist %q: %v", p.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// A songFilter holds the criteria of a SmartPlaylist which MPD cannot
+// evaluate, and which are compared by the Server instead.  The zero value
+// matches every song.
+type songFilter struct {
+	Years yearRange
+	Plays playRange
+}
+
+// A playRange restricts the songs in a SmartPlaylist to those which a user
+// played between From and To times, inclusive.  If HasTo is false, the
+// upper end of the range is open.
+type playRange struct {
+	From  int
+	To    int
+	HasTo bool
+}
+
+// contains reports whether a song played n times is within the range.
+func (pr playRange) contains(n int) bool {
+	return n >= pr.From && (!pr.HasTo || n <= pr.To)
+}
+
+// compare narrows the range using a comparison from a field-scoped term,
+// such as "plays<3".
+func (pr *playRange) compare(op, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid play count in query: %q", value)
+	}
+
+	switch op {
+	case ":", "=":
+		pr.From, pr.To, pr.HasTo = n, n, true
+	case ">":
+		pr.From = n + 1
+	case ">=":
+		pr.From = n
+	case "<":
+		pr.To, pr.HasTo = n-1, true
+	case "<=":
+		pr.To, pr.HasTo = n, true
+	}
+
+	if pr.HasTo && pr.From > pr.To {
+		return fmt.Errorf("empty range of play counts in query: %q", op+value)
+	}
+
+	return nil
+}
+
+// A yearRange restricts the songs in a SmartPlaylist to those released
+// between two years, inclusive.  A zero year leaves that end of the range
+// open.
+type yearRange struct {
+	From, To int
+}
+
+// contains reports whether the song with attributes a was released within
+// the range.  Songs without a year are only within an open range.
+func (yr yearRange) contains(a mpd.Attrs) bool {
+	if yr.From == 0 && yr.To == 0 {
+		return true
+	}
+
+	year := leadingInt(a["Date"])
+	if year == 0 {
+		return false
+	}
+
+	return (yr.From == 0 || year >= yr.From) && (yr.To == 0 || year <= yr.To)
+}
+
+// searchArgs produces the arguments for a MPD search command which selects
+// the songs for the playlist, relative to the time specified by now, and the
+// filter which the songs must also match.  If only a filter is specified, no
+// arguments are returned, and every song must be compared.
+func (p *SmartPlaylist) searchArgs(now time.Time) ([]string, songFilter, error) {
+	query := strings.TrimSpace(p.Query)
+
+	var since string
+	if p.ModifiedWithin > 0 {
+		since = now.Add(-p.ModifiedWithin).UTC().Format(time.RFC3339)
+	}
+
+	// MPD filter expressions are always enclosed in parentheses
+	if strings.HasPrefix(query, "(") {
+		if since == "" {
+			return []string{query}, songFilter{}, nil
+		}
+
+		return []string{fmt.Sprintf("(%s AND (modified-since '%s'))", query, since)}, songFilter{}, nil
+	}
+
+	terms, err := splitTerms(query)
+	if err != nil {
+		return nil, songFilter{}, err
+	}
+
+	var (
+		args []string
+		f    songFilter
+	)
+	for _, qt := range terms {
+		// Every term must match, so AND is implied
+		if qt.Operator {
+			if qt.Text == "AND" {
+				continue
+			}
+
+			return nil, songFilter{}, fmt.Errorf("%s is only supported in filter expressions", qt.Text)
+		}
+
+		t := qt.Text
+
+		// Terms without a field are matched against any tag
+		i := strings.IndexAny(t, ":=<>")
+		if i <= 0 {
+			args = append(args, "any", t)
+			continue
+		}
+
+		tag, op, value := t[:i], t[i:i+1], t[i+1:]
+		if strings.HasPrefix(value, "=") && (op == "<" || op == ">") {
+			op, value = op+"=", value[1:]
+		}
+
+		if tag == "plays" {
+			if err := f.Plays.compare(op, value); err != nil {
+				return nil, songFilter{}, err
+			}
+			continue
+		}
+
+		if op == ":" || op == "=" {
+			args = append(args, tag, value)
+			continue
+		}
+
+		if err := f.Years.compare(tag, op, value); err != nil {
+			return nil, songFilter{}, err
+		}
+	}
+
+	if since != "" {
+		args = append(args, "modified-since", since)
+	}
+
+	if len(args) == 0 && f == (songFilter{}) {
+		return nil, songFilter{}, errEmptyQuery
+	}
+
+	return args, f, nil
+}
+
+// compare narrows the range using a comparison from a field-scoped term,
+// such as "year>1990".
+func (yr *yearRange) compare(tag, op, value string) error {
+	if tag != "year" && tag != "date" {
+		return fmt.Errorf("cannot compare tag %q, only year or plays", tag)
+	}
+
+	year, err := strconv.Atoi(value)
+	if err != nil || year <= 0 {
+		return fmt.Errorf("invalid year in query: %q", value)
+	}
+
+	switch op {
+	case ">":
+		yr.From = year + 1
+	case ">=":
+		yr.From = year
+	case "<":
+		yr.To = year - 1
+	case "<=":
+		yr.To = year
+	}
+
+	if yr.From != 0 && yr.To != 0 && yr.From > yr.To {
+		return fmt.Errorf("empty range of years in query: %d-%d", yr.From, yr.To)
+	}
+
+	return nil
+}
+
+// A queryTerm is a single term of a field-scoped query.
+type queryTerm struct {
+	Text string
+
+	// Operator reports whether the term is an unquoted operator, such as
+	// AND.  Quoted terms such as "AND" are matched as text instead.
+	Operator bool
+}
+
+// splitTerms splits a field-scoped query into its individual terms,
+// treating double-quoted sections as part of a single term.
+func splitTerms(query string) ([]queryTerm, error) {
+	var (
+		terms     []queryTerm
+		term      []rune
+		quoted    bool
+		hadQuotes bool
+	)
+
+	flush := func() {
+		if len(term) == 0 {
+			return
+		}
+
+		t := string(term)
+		terms = append(terms, queryTerm{
+			Text:     t,
+			Operator: !hadQuotes && (t == "AND" || t == "OR" || t == "NOT"),
+		})
+		term = term[:0]
+		hadQuotes = false
+	}
+
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			hadQuotes = true
+		case unicode.IsSpace(r) && !quoted:
+			flush()
+		default:
+			term = append(term, r)
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in query: %q", query)
+	}
+
+	flush()
+	return terms, nil
+}
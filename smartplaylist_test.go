@@ -0,0 +1,338 @@
+package mpdsub
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestSmartPlaylist_searchArgs(t *testing.T) {
+	now := time.Date(2016, time.November, 4, 18, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		p    SmartPlaylist
+		args []string
+		yr   yearRange
+		pr   playRange
+		ok   bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "unterminated quote",
+			p: SmartPlaylist{
+				Query: `artist:"Iron Maiden`,
+			},
+		},
+		{
+			name: "filter expression",
+			p: SmartPlaylist{
+				Query: `(genre == "Metal")`,
+			},
+			args: []string{`(genre == "Metal")`},
+			ok:   true,
+		},
+		{
+			name: "filter expression, modified within",
+			p: SmartPlaylist{
+				Query:          `(genre == "Metal")`,
+				ModifiedWithin: 24 * time.Hour,
+			},
+			args: []string{`((genre == "Metal") AND (modified-since '2016-11-03T18:00:00Z'))`},
+			ok:   true,
+		},
+		{
+			name: "field-scoped terms",
+			p: SmartPlaylist{
+				Query: `genre:metal artist:"Iron Maiden" live`,
+			},
+			args: []string{"genre", "metal", "artist", "Iron Maiden", "any", "live"},
+			ok:   true,
+		},
+		{
+			name: "equals separator",
+			p: SmartPlaylist{
+				Query: `genre=jazz`,
+			},
+			args: []string{"genre", "jazz"},
+			ok:   true,
+		},
+		{
+			name: "year comparisons",
+			p: SmartPlaylist{
+				Query: `genre=jazz year>1990 year<=2000`,
+			},
+			args: []string{"genre", "jazz"},
+			yr:   yearRange{From: 1991, To: 2000},
+			ok:   true,
+		},
+		{
+			name: "AND operator",
+			p: SmartPlaylist{
+				Query: `genre=jazz AND year>1990`,
+			},
+			args: []string{"genre", "jazz"},
+			yr:   yearRange{From: 1991},
+			ok:   true,
+		},
+		{
+			name: "quoted AND",
+			p: SmartPlaylist{
+				Query: `genre=jazz "AND"`,
+			},
+			args: []string{"genre", "jazz", "any", "AND"},
+			ok:   true,
+		},
+		{
+			name: "OR operator",
+			p: SmartPlaylist{
+				Query: `genre=jazz OR genre=blues`,
+			},
+		},
+		{
+			name: "year only",
+			p: SmartPlaylist{
+				Query: `year>=1990`,
+			},
+			yr: yearRange{From: 1990},
+			ok: true,
+		},
+		{
+			name: "comparison of other tag",
+			p: SmartPlaylist{
+				Query: `track>3`,
+			},
+		},
+		{
+			name: "invalid year",
+			p: SmartPlaylist{
+				Query: `year>nineties`,
+			},
+		},
+		{
+			name: "empty year range",
+			p: SmartPlaylist{
+				Query: `year>2000 year<1990`,
+			},
+		},
+		{
+			name: "never played",
+			p: SmartPlaylist{
+				Query: `plays=0`,
+			},
+			pr: playRange{HasTo: true},
+			ok: true,
+		},
+		{
+			name: "play count",
+			p: SmartPlaylist{
+				Query: `genre:rock plays>=3 plays<10`,
+			},
+			args: []string{"genre", "rock"},
+			pr:   playRange{From: 3, To: 9, HasTo: true},
+			ok:   true,
+		},
+		{
+			name: "invalid play count",
+			p: SmartPlaylist{
+				Query: `plays>-1`,
+			},
+		},
+		{
+			name: "empty play count range",
+			p: SmartPlaylist{
+				Query: `plays<0`,
+			},
+		},
+		{
+			name: "recently added",
+			p: SmartPlaylist{
+				ModifiedWithin: 7 * 24 * time.Hour,
+			},
+			args: []string{"modified-since", "2016-10-28T18:00:00Z"},
+			ok:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, f, err := tt.p.searchArgs(now)
+			if err != nil && tt.ok {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && !tt.ok {
+				t.Fatal("expected an error, but none occurred")
+			}
+
+			if want, got := tt.args, args; !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected search arguments:\n- want: %q\n-  got: %q", want, got)
+			}
+			if want, got := tt.yr, f.Years; want != got {
+				t.Fatalf("unexpected year range:\n- want: %+v\n-  got: %+v", want, got)
+			}
+			if want, got := tt.pr, f.Plays; want != got {
+				t.Fatalf("unexpected play count range:\n- want: %+v\n-  got: %+v", want, got)
+			}
+		})
+	}
+}
+
+func Test_yearRange(t *testing.T) {
+	yr := yearRange{From: 1990, To: 1999}
+
+	tests := []struct {
+		date string
+		ok   bool
+	}{
+		{date: "1989-12-31"},
+		{date: "1990", ok: true},
+		{date: "1999-05-01", ok: true},
+		{date: "2000"},
+		{date: ""},
+	}
+
+	for _, tt := range tests {
+		if want, got := tt.ok, yr.contains(mpd.Attrs{"Date": tt.date}); want != got {
+			t.Fatalf("unexpected result for %q:\n- want: %v\n-  got: %v", tt.date, want, got)
+		}
+	}
+
+	// An open range contains songs without a year
+	if !(yearRange{}).contains(mpd.Attrs{}) {
+		t.Fatal("expected open range to contain song without year")
+	}
+}
+
+func Test_validateSmartPlaylists(t *testing.T) {
+	tests := []struct {
+		name string
+		ps   []SmartPlaylist
+		ok   bool
+	}{
+		{
+			name: "OK",
+			ps: []SmartPlaylist{
+				{Name: "Jazz", Query: "genre=jazz year>1990"},
+				{Name: "Recent", ModifiedWithin: time.Hour},
+			},
+			ok: true,
+		},
+		{
+			name: "no name",
+			ps:   []SmartPlaylist{{Query: "genre=jazz"}},
+		},
+		{
+			name: "duplicate name",
+			ps: []SmartPlaylist{
+				{Name: "Jazz", Query: "genre=jazz"},
+				{Name: "Jazz", Query: "genre=bebop"},
+			},
+		},
+		{
+			name: "negative limit",
+			ps:   []SmartPlaylist{{Name: "Jazz", Query: "genre=jazz", Limit: -1}},
+		},
+		{
+			name: "empty query",
+			ps:   []SmartPlaylist{{Name: "Jazz"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSmartPlaylists(tt.ps)
+			if err != nil && tt.ok {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && !tt.ok {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func TestServer_evaluateSmartPlaylistYears(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{"a.mp3", "b.mp3", "c.mp3"},
+		songs: []mpd.Attrs{
+			{"file": "a.mp3", "Date": "1985"},
+			{"file": "b.mp3", "Date": "1995-03-01"},
+			{"file": "c.mp3"},
+		},
+	}
+
+	p := SmartPlaylist{Name: "Nineties", Query: "year>=1990 year<2000"}
+
+	s, err := newServer(db, nil, &Config{SmartPlaylists: []SmartPlaylist{p}})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer s.Close()
+
+	// Without other criteria, every song in MPD's database is compared
+	pl, err := s.evaluateSmartPlaylist("", p)
+	if err != nil {
+		t.Fatalf("failed to evaluate smart playlist: %v", err)
+	}
+
+	var paths []string
+	for _, e := range pl.Entries {
+		paths = append(paths, e.Path)
+	}
+
+	if want, got := []string{"b.mp3"}, paths; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected songs:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestServer_evaluateSmartPlaylistPlays(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{"a.mp3", "b.mp3"},
+		songs: []mpd.Attrs{
+			{"file": "a.mp3"},
+			{"file": "b.mp3"},
+		},
+	}
+
+	p := SmartPlaylist{Name: "Never played", Query: "plays=0"}
+
+	s, err := newServer(db, nil, &Config{SmartPlaylists: []SmartPlaylist{p}})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer s.Close()
+
+	_ = s.store.Update(func(d *storeData) error {
+		d.addPlay("alice", "a.mp3", time.Now())
+		return nil
+	})
+
+	// Play counts are compared for the requesting user
+	tests := []struct {
+		user  string
+		paths []string
+	}{
+		{user: "alice", paths: []string{"b.mp3"}},
+		{user: "bob", paths: []string{"a.mp3", "b.mp3"}},
+	}
+
+	for _, tt := range tests {
+		pl, err := s.evaluateSmartPlaylist(tt.user, p)
+		if err != nil {
+			t.Fatalf("failed to evaluate smart playlist: %v", err)
+		}
+
+		var paths []string
+		for _, e := range pl.Entries {
+			paths = append(paths, e.Path)
+		}
+
+		if want, got := tt.paths, paths; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected songs for %q:\n- want: %v\n-  got: %v", tt.user, want, got)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package mpdsub
+
+import (
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// songChildren converts songs returned by MPD into Subsonic children, looking
+// up the IDs of each song and its album in the file index, and user's play
+// statistics and stars for each song.  Songs which are not present in the
+// file index or are not visible to user are skipped.
+func (s *Server) songChildren(user string, songs []mpd.Attrs) ([]child, error) {
+	fs, err := s.db.List("file")
+	if err != nil {
+		return nil, err
+	}
+	ids := fileIDs(indexFiles(fs))
+	stats := s.playStats(user)
+	stars := s.stars(user)
+
+	children := make([]child, 0, len(songs))
+	for _, a := range songs {
+		id, ok := ids[a["file"]]
+		if !ok || !s.visible(user, a["file"]) || s.filteredGenre(user, a["Genre"]) {
+			continue
+		}
+
+		c := s.songChild(id, a)
+		if dir := albumDir(a["file"]); dir != "" {
+			c.Parent = s.formatID(ids[dir])
+		}
+
+		key := s.itemKey(a["file"])
+		ps := stats[key]
+		c.PlayCount, c.Played = ps.Count, ps.played()
+		c.Starred = formatTime(stars[key])
+
+		children = append(children, c)
+	}
+
+	return children, nil
+}
+
+// songChild creates a Subsonic child from a song's MPD attributes.
+func (s *Server) songChild(id int, a mpd.Attrs) child {
+	name := a["file"]
+
+	title := a["Title"]
+	if title == "" {
+		title = path.Base(name)
+	}
+
+	return child{
+		ID:       s.formatID(id),
+		Album:    a["Album"],
+		Artist:   a["Artist"],
+		CoverArt: s.formatID(id),
+		Genre:    s.genres.Primary(a["Genre"]),
+		Created:  formatTime(s.songCreated(a)),
+		Suffix:   strings.TrimPrefix(path.Ext(name), "."),
+		Title:    title,
+		Path:     name,
+		Duration: songDuration(a),
+		Track:    leadingInt(a["Track"]),
+		Year:     leadingInt(a["Date"]),
+
+		DisplayArtist:      displayArtist(a["Artist"]),
+		DisplayAlbumArtist: displayArtist(a["AlbumArtist"]),
+		DisplayComposer:    displayArtist(a["Composer"]),
+
+		Work:           a["Work"],
+		Movement:       a["Movement"],
+		MovementNumber: leadingInt(a["MovementNumber"]),
+	}
+}
+
+// formatTime formats t for Subsonic as an ISO 8601 timestamp in UTC, or
+// returns empty string if t is zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// lastModified parses the time at which MPD last saw a song or playlist
+// modified.  If MPD did not provide the time, it returns the zero time.
+func lastModified(a mpd.Attrs) time.Time {
+	t, err := time.Parse(time.RFC3339, a["Last-Modified"])
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+// songCreated returns the time at which a song was added to the library:
+// the time MPD last saw it modified or, if MPD did not provide that time,
+// the modification time of the file in a local music directory.
+func (s *Server) songCreated(a mpd.Attrs) time.Time {
+	if t := lastModified(a); !t.IsZero() {
+		return t
+	}
+	if s.cfg.MusicDirectory == "" || s.musicURL != nil {
+		return time.Time{}
+	}
+
+	f, err := s.fs.Open(s.musicPath(a["file"]))
+	if err != nil {
+		return time.Time{}
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return time.Time{}
+	}
+
+	return fi.ModTime()
+}
+
+// songDuration returns the duration of a song in seconds, using whichever
+// duration attribute MPD provides.
+func songDuration(a mpd.Attrs) int {
+	if d, err := strconv.ParseFloat(a["duration"], 64); err == nil {
+		return int(d)
+	}
+
+	return leadingInt(a["Time"])
+}
+
+// leadingInt parses the leading digits of a tag value as an integer, such as
+// "3" from track "3/12", or "1999" from date "1999-05-01".  If no digits are
+// present, 0 is returned.
+func leadingInt(s string) int {
+	s = strings.TrimSpace(s)
+
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+
+	n, _ := strconv.Atoi(s[:end])
+	return n
+}
@@ -0,0 +1,55 @@
+package mpdsub
+
+import (
+	"strings"
+)
+
+// defaultIgnoredArticles are the articles ignored when sorting names, if
+// none are configured.  These match Subsonic's defaults.
+var defaultIgnoredArticles = []string{"The", "El", "La", "Los", "Las", "Le", "Les"}
+
+// ignoredArticles returns the articles ignored when sorting names.
+func (s *Server) ignoredArticles() []string {
+	if s.cfg.IgnoredArticles != nil {
+		return s.cfg.IgnoredArticles
+	}
+
+	return defaultIgnoredArticles
+}
+
+// sortName computes the name used to sort an artist or album, by removing
+// a leading ignored article from name, such as "Beatles" from "The Beatles".
+func (s *Server) sortName(name string) string {
+	for _, a := range s.ignoredArticles() {
+		if len(name) <= len(a)+1 || name[len(a)] != ' ' {
+			continue
+		}
+
+		if strings.EqualFold(name[:len(a)], a) {
+			return strings.TrimSpace(name[len(a)+1:])
+		}
+	}
+
+	return name
+}
+
+// displayArtist formats a tag which may contain multiple artists separated
+// by semicolons for display, such as "A, B & C" from "A; B; C".
+func displayArtist(tag string) string {
+	var artists []string
+	for _, a := range strings.Split(tag, ";") {
+		if a = strings.TrimSpace(a); a != "" {
+			artists = append(artists, a)
+		}
+	}
+
+	switch len(artists) {
+	case 0:
+		return ""
+	case 1:
+		return artists[0]
+	}
+
+	last := len(artists) - 1
+	return strings.Join(artists[:last], ", ") + " & " + artists[last]
+}
@@ -0,0 +1,88 @@
+package mpdsub
+
+import (
+	"testing"
+)
+
+func TestServer_sortName(t *testing.T) {
+	tests := []struct {
+		name     string
+		articles []string
+		in       string
+		out      string
+	}{
+		{
+			name: "no article",
+			in:   "Iron Maiden",
+			out:  "Iron Maiden",
+		},
+		{
+			name: "default article",
+			in:   "The Beatles",
+			out:  "Beatles",
+		},
+		{
+			name: "case insensitive",
+			in:   "los Lobos",
+			out:  "Lobos",
+		},
+		{
+			name: "article prefix of word",
+			in:   "Theory of a Deadman",
+			out:  "Theory of a Deadman",
+		},
+		{
+			name: "only article",
+			in:   "The",
+			out:  "The",
+		},
+		{
+			name:     "configured articles",
+			articles: []string{"A", "An"},
+			in:       "A Perfect Circle",
+			out:      "Perfect Circle",
+		},
+		{
+			name:     "configured articles exclude defaults",
+			articles: []string{"A", "An"},
+			in:       "The Beatles",
+			out:      "The Beatles",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				cfg: &Config{
+					IgnoredArticles: tt.articles,
+				},
+			}
+
+			if want, got := tt.out, s.sortName(tt.in); want != got {
+				t.Fatalf("unexpected sort name:\n- want: %q\n-  got: %q",
+					want, got)
+			}
+		})
+	}
+}
+
+func Test_displayArtist(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{in: "", out: ""},
+		{in: "AC/DC", out: "AC/DC"},
+		{in: "Simon; Garfunkel", out: "Simon & Garfunkel"},
+		{in: "A;B; ;C", out: "A, B & C"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if want, got := tt.out, displayArtist(tt.in); want != got {
+				t.Fatalf("unexpected display artist:\n- want: %q\n-  got: %q",
+					want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package mpdsub
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+// starParameters are the parameters of star and unstar which identify the
+// items to star or unstar.  Songs and directories are identified by id, and
+// albums and artists by albumId and artistId when browsing by tags.  All
+// share the ID scheme described in ids.go, so an item's star is the same no
+// matter how a client identified it.
+var starParameters = []string{"id", "albumId", "artistId"}
+
+// star is used in Subsonic to star songs, albums, and artists.
+func (s *Server) star(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+
+	s.updateStars(w, r, func(stars map[string]time.Time, key string) {
+		// Keep the original time if an item is starred again
+		if _, ok := stars[key]; !ok {
+			stars[key] = now
+		}
+	})
+}
+
+// unstar is used in Subsonic to unstar songs, albums, and artists.
+func (s *Server) unstar(w http.ResponseWriter, r *http.Request) {
+	s.updateStars(w, r, func(stars map[string]time.Time, key string) {
+		delete(stars, key)
+	})
+}
+
+// updateStars looks up the items identified by a star or unstar request, and
+// applies fn to the requesting user's stars for each item's key.
+func (s *Server) updateStars(w http.ResponseWriter, r *http.Request, fn func(stars map[string]time.Time, key string)) {
+	q := r.URL.Query()
+
+	var qIDs []string
+	for _, p := range starParameters {
+		qIDs = append(qIDs, q[p]...)
+	}
+	if len(qIDs) == 0 {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		s.logf("error listing files from mpd for starring: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+	files := indexFiles(fs)
+
+	user := requestContextFrom(r).User
+
+	// Verify every item before updating any stars
+	keys := make([]string, 0, len(qIDs))
+	for _, qID := range qIDs {
+		f, ok := s.lookupID(files, qID)
+		if !ok {
+			writeXML(w, errNotFound)
+			return
+		}
+		if !s.visible(user, f.Name) {
+			writeXML(w, errNotAuthorized)
+			return
+		}
+
+		keys = append(keys, s.itemKey(f.Name))
+	}
+
+	err = s.store.Update(func(d *storeData) error {
+		stars, ok := d.Stars[user]
+		if !ok {
+			stars = make(map[string]time.Time)
+			d.Stars[user] = stars
+		}
+
+		for _, k := range keys {
+			fn(stars, k)
+		}
+
+		if len(stars) == 0 {
+			delete(d.Stars, user)
+		}
+		return nil
+	})
+	if err != nil {
+		s.logf("error storing stars for %q: %v", user, err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	writeXML(w, nil)
+}
+
+// stars returns a copy of the items starred by user and the times they were
+// starred, keyed by itemKey.
+func (s *Server) stars(user string) map[string]time.Time {
+	var out map[string]time.Time
+	s.store.View(func(d *storeData) {
+		out = make(map[string]time.Time, len(d.Stars[user]))
+		for k, t := range d.Stars[user] {
+			out[k] = t
+		}
+	})
+
+	return out
+}
+
+// starredItems are the songs, albums, and artists starred by a user, and the
+// times they were starred, keyed by itemKey.
+type starredItems struct {
+	Times   map[string]time.Time
+	IDs     map[string]int
+	Songs   []mpd.Attrs
+	Albums  []album
+	Artists []string
+
+	// ID3Artists are all of the ID3 artists visible to the user, and
+	// ArtistIDs maps album IDs to the IDs of their ID3 artists.
+	ID3Artists []id3Artist
+	ArtistIDs  map[int]int
+}
+
+// starredTime formats the time an item was starred for Subsonic.
+func (si starredItems) starredTime(key string) string {
+	return formatTime(si.Times[key])
+}
+
+// getStarred is used in Subsonic to retrieve the songs, albums, and artists
+// starred by a user, organized by directory.
+func (s *Server) getStarred(w http.ResponseWriter, r *http.Request) {
+	si, ok := s.starredPage(w, r)
+	if !ok {
+		return
+	}
+
+	res := &starredContainer{
+		Artists: make([]artist, 0, len(si.Artists)),
+		Albums:  make([]albumChild, 0, len(si.Albums)),
+		Songs:   make([]song, 0, len(si.Songs)),
+	}
+
+	for _, dir := range si.Artists {
+		res.Artists = append(res.Artists, artist{
+			Name:     path.Base(dir),
+			ID:       s.formatID(si.IDs[dir]),
+			SortName: s.sortName(path.Base(dir)),
+			Starred:  si.starredTime(s.itemKey(dir)),
+		})
+	}
+
+	for _, al := range si.Albums {
+		c := s.albumChild(al)
+		c.Starred = si.starredTime(s.itemKey(al.Dir))
+
+		res.Albums = append(res.Albums, albumChild{child: c})
+	}
+
+	for _, a := range si.Songs {
+		c := s.songChild(si.IDs[a["file"]], a)
+		c.Starred = si.starredTime(s.itemKey(a["file"]))
+		if dir := albumDir(a["file"]); dir != "" {
+			c.Parent = s.formatID(si.IDs[dir])
+		}
+
+		res.Songs = append(res.Songs, song{child: c})
+	}
+
+	writeXML(w, func(c *container) {
+		c.Starred = res
+	})
+}
+
+// getStarred2 is used in Subsonic to retrieve the same songs, albums, and
+// artists as getStarred, organized by their tags.
+func (s *Server) getStarred2(w http.ResponseWriter, r *http.Request) {
+	si, ok := s.starredPage(w, r)
+	if !ok {
+		return
+	}
+
+	res := &starred2Container{
+		Artists: []artistID3{},
+		Albums:  make([]albumID3, 0, len(si.Albums)),
+		Songs:   make([]song, 0, len(si.Songs)),
+	}
+
+	// ID3 artists share the IDs of the directories which identify them
+	for _, a := range si.ID3Artists {
+		for _, dir := range si.Artists {
+			if si.IDs[dir] != a.ID {
+				continue
+			}
+
+			res.Artists = append(res.Artists, artistID3{
+				ID:         s.formatID(a.ID),
+				Name:       a.Name,
+				AlbumCount: len(a.Albums),
+				Starred:    si.starredTime(s.itemKey(dir)),
+			})
+			break
+		}
+	}
+
+	for _, al := range si.Albums {
+		a := s.albumID3(al, si.ArtistIDs[al.ID])
+		a.Starred = si.starredTime(s.itemKey(al.Dir))
+
+		res.Albums = append(res.Albums, a)
+	}
+
+	byDir := make(map[string]album, len(si.Albums))
+	for _, a := range si.ID3Artists {
+		for _, al := range a.Albums {
+			byDir[al.Dir] = al
+			for _, p := range al.Parts {
+				byDir[p.Dir] = al
+			}
+		}
+	}
+
+	for _, a := range si.Songs {
+		c := s.songChild(si.IDs[a["file"]], a)
+		c.Starred = si.starredTime(s.itemKey(a["file"]))
+		if al, ok := byDir[albumDir(a["file"])]; ok {
+			c.Parent = s.formatID(al.ID)
+			c.AlbumID = s.formatID(al.ID)
+			c.ArtistID = s.formatID(si.ArtistIDs[al.ID])
+		}
+
+		res.Songs = append(res.Songs, song{child: c})
+	}
+
+	writeXML(w, func(c *container) {
+		c.Starred2 = res
+	})
+}
+
+// starredPage retrieves the items starred by the user making a getStarred or
+// getStarred2 request.  If the items cannot be retrieved, an error is written
+// to w and false is returned.
+func (s *Server) starredPage(w http.ResponseWriter, r *http.Request) (starredItems, bool) {
+	folder := musicFolderAll
+	if qFolder := r.URL.Query().Get("musicFolderId"); qFolder != "" {
+		var err error
+		folder, err = strconv.Atoi(qFolder)
+		if err != nil {
+			writeXML(w, errGeneric)
+			return starredItems{}, false
+		}
+	}
+
+	si, err := s.starredItems(requestContextFrom(r).User, folder)
+	if err != nil {
+		s.logf("error retrieving starred items from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return starredItems{}, false
+	}
+
+	return si, true
+}
+
+// starredItems retrieves the songs, albums, and artists in a music folder
+// which were starred by user and are visible to them.  Starred directories
+// which contain songs are albums, and any other starred directories are
+// artists.
+func (s *Server) starredItems(user string, folder int) (starredItems, error) {
+	si := starredItems{
+		Times:     s.stars(user),
+		ArtistIDs: make(map[int]int),
+	}
+	if len(si.Times) == 0 {
+		return si, nil
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		return starredItems{}, err
+	}
+	files := indexFiles(fs)
+	si.IDs = fileIDs(files)
+
+	albums, err := s.albums(user, folder)
+	if err != nil {
+		return starredItems{}, err
+	}
+
+	si.ID3Artists = id3Artists(albums)
+	si.ArtistIDs = id3ArtistIDs(si.ID3Artists)
+
+	albumDirs := make(map[string]struct{}, len(albums))
+	for _, al := range albums {
+		albumDirs[al.Dir] = struct{}{}
+
+		if _, ok := si.Times[s.itemKey(al.Dir)]; ok {
+			si.Albums = append(si.Albums, al)
+		}
+	}
+
+	var starredSongs bool
+	for _, f := range files {
+		if _, ok := si.Times[s.itemKey(f.Name)]; !ok {
+			continue
+		}
+		if !f.Dir {
+			starredSongs = true
+			continue
+		}
+		if _, ok := albumDirs[f.Name]; ok {
+			continue
+		}
+		if !s.visible(user, f.Name) || !s.inMusicFolder(f.Name, folder) {
+			continue
+		}
+
+		si.Artists = append(si.Artists, f.Name)
+	}
+
+	if !starredSongs {
+		return si, nil
+	}
+
+	songs, err := s.db.ListAllInfo("")
+	if err != nil {
+		return starredItems{}, err
+	}
+
+	for _, a := range songs {
+		name := a["file"]
+		if name == "" || !s.inMusicFolder(name, folder) {
+			continue
+		}
+		if _, ok := si.Times[s.itemKey(name)]; !ok {
+			continue
+		}
+		if !s.visible(user, name) || s.filteredGenre(user, a["Genre"]) {
+			continue
+		}
+
+		si.Songs = append(si.Songs, a)
+	}
+
+	return si, nil
+}
@@ -0,0 +1,201 @@
+package mpdsub
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_starUnstar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-stars")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+
+	db := &memoryDatabase{
+		files: []string{"Artist/Album/foo.mp3"},
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		params url.Values
+		code   int
+	}{
+		{
+			name:   "missing ID",
+			target: "/rest/star.view",
+			code:   codeMissingParameter,
+		},
+		{
+			name:   "unknown ID",
+			target: "/rest/star.view",
+			params: url.Values{"id": {"9"}},
+			code:   codeNotFound,
+		},
+		{
+			name:   "unknown album ID",
+			target: "/rest/unstar.view",
+			params: url.Values{"albumId": {"foo"}},
+			code:   codeNotFound,
+		},
+	}
+
+	cfg, values := configAuth()
+	cfg.StateFile = path
+
+	// params copies values and adds additional query parameters
+	params := func(extra url.Values) url.Values {
+		v := make(url.Values, len(values))
+		for k, vv := range values {
+			v[k] = vv
+		}
+		for k, vv := range extra {
+			v[k] = vv
+		}
+		return v
+	}
+
+	withServer(t, db, nil, cfg, func(base string) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, tt.target, params(tt.params)))
+				if c.Error == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+				if want, got := tt.code, c.Error.Code; want != got {
+					t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		}
+
+		// Star the song, album, and artist at once, then unstar the album
+		requests := []struct {
+			target string
+			params url.Values
+		}{
+			{
+				target: "/rest/star.view",
+				params: url.Values{"id": {"2"}, "albumId": {"1"}, "artistId": {"0"}},
+			},
+			{
+				target: "/rest/unstar.view",
+				params: url.Values{"albumId": {"1"}},
+			},
+		}
+
+		for _, rr := range requests {
+			c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, rr.target, params(rr.params)))
+			if c.Error != nil {
+				t.Fatalf("unexpected error: %+v", c.Error)
+			}
+		}
+	})
+
+	st, err := openStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+
+	var got []string
+	st.View(func(d *storeData) {
+		for k := range d.Stars["test"] {
+			got = append(got, k)
+		}
+	})
+	sort.Strings(got)
+
+	if want := []string{"Artist", "Artist/Album/foo.mp3"}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected stars:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestServer_getStarred(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{
+			"Artist/Album/bar.mp3",
+			"Artist/Album/foo.mp3",
+			"baz.mp3",
+		},
+		songs: []mpd.Attrs{
+			{"file": "Artist/Album/bar.mp3", "Title": "Bar", "Artist": "The Artist", "Album": "The Album"},
+			{"file": "Artist/Album/foo.mp3", "Title": "Foo", "Artist": "The Artist", "Album": "The Album"},
+			{"file": "baz.mp3", "Title": "Baz"},
+		},
+	}
+
+	cfg, values := configAuth()
+
+	withServer(t, db, nil, cfg, func(base string) {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getStarred.view", values))
+		if c.Starred == nil || len(c.Starred.Artists)+len(c.Starred.Albums)+len(c.Starred.Songs) != 0 {
+			t.Fatalf("expected no starred items, but got: %+v", c.Starred)
+		}
+
+		// Star the artist, album, Foo, and Baz
+		star := url.Values{"id": {"3", "4"}, "albumId": {"1"}, "artistId": {"0"}}
+		for k, v := range values {
+			star[k] = v
+		}
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/star.view", star))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getStarred.view", values))
+		st := c.Starred
+
+		if len(st.Artists) != 1 || len(st.Albums) != 1 || len(st.Songs) != 2 {
+			t.Fatalf("unexpected starred items: %+v", st)
+		}
+		if want, got := "Artist", st.Artists[0].Name; want != got {
+			t.Fatalf("unexpected artist name:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := "1", st.Albums[0].ID; want != got {
+			t.Fatalf("unexpected album ID:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := "Foo", st.Songs[0].Title; want != got {
+			t.Fatalf("unexpected song title:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := "1", st.Songs[0].Parent; want != got {
+			t.Fatalf("unexpected song parent:\n- want: %v\n-  got: %v", want, got)
+		}
+		if st.Songs[0].Starred == "" {
+			t.Fatal("expected song to have a starred time")
+		}
+
+		c = mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getStarred2.view", values))
+		st2 := c.Starred2
+
+		if len(st2.Artists) != 1 || len(st2.Albums) != 1 || len(st2.Songs) != 2 {
+			t.Fatalf("unexpected starred ID3 items: %+v", st2)
+		}
+		if want, got := "The Artist", st2.Artists[0].Name; want != got {
+			t.Fatalf("unexpected artist name:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := "The Album", st2.Albums[0].Name; want != got {
+			t.Fatalf("unexpected album name:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := "0", st2.Songs[0].ArtistID; want != got {
+			t.Fatalf("unexpected song artist ID:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := "Baz", st2.Songs[1].Title; want != got {
+			t.Fatalf("unexpected song title:\n- want: %v\n-  got: %v", want, got)
+		}
+		if _, err := time.Parse(time.RFC3339, st2.Albums[0].Starred); err != nil {
+			t.Fatalf("failed to parse album starred time: %v", err)
+		}
+	})
+}
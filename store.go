@@ -0,0 +1,242 @@
+package mpdsub
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// storeFlushInterval is how often changes recorded in the store, such as
+// listening history, are persisted.
+const storeFlushInterval = 30 * time.Second
+
+// A store persists state which MPD cannot store on behalf of the Server,
+// such as playlist metadata.  State is kept in memory and, if a path is
+// configured, written to a JSON file after each update.  Frequent changes,
+// such as listening history, are recorded without a new version and written
+// by the next Update or Flush.
+type store struct {
+	mu    sync.RWMutex
+	path  string
+	data  storeData
+	dirty bool
+}
+
+// storeData is the state persisted by a store.
+type storeData struct {
+	// Version is incremented each time the store is updated, but not when
+	// a change is recorded.
+	Version uint64 `json:"version"`
+
+	// Playlists maps playlist IDs to their metadata.
+	Playlists map[string]playlistMeta `json:"playlists,omitempty"`
+
+	// History maps users to the songs they have streamed, oldest first.
+	History map[string][]historyEntry `json:"history,omitempty"`
+
+	// Stars maps users to the items they have starred, keyed by itemKey,
+	// and the times they were starred.
+	Stars map[string]map[string]time.Time `json:"stars,omitempty"`
+
+	// Plays maps users to their play statistics for each song, keyed by
+	// itemKey.
+	Plays map[string]map[string]playStats `json:"plays,omitempty"`
+
+	// PlayQueues maps users to the play queues saved by their clients.
+	PlayQueues map[string]savedPlayQueue `json:"playQueues,omitempty"`
+
+	// Ratings maps songs, keyed by itemKey, to the ratings set by Subsonic
+	// clients, so they can be restored if MPD discards its stickers.
+	Ratings map[string]savedRating `json:"ratings,omitempty"`
+
+	// IDKey is the key used to derive obfuscated IDs.
+	IDKey []byte `json:"idKey,omitempty"`
+
+	// Users maps the names of users, other than SubsonicUser, to their
+	// accounts.
+	Users map[string]userAccount `json:"users,omitempty"`
+
+	// UserKey is the key used to encrypt the passwords of Users, unless
+	// Config.UserKeyFile is set.  Because it is stored alongside the
+	// passwords, it does not protect them from anyone who can read the
+	// store.
+	UserKey []byte `json:"userKey,omitempty"`
+}
+
+// playlistMeta is metadata for a playlist beyond what MPD stores.
+type playlistMeta struct {
+	Owner   string    `json:"owner"`
+	Comment string    `json:"comment,omitempty"`
+	Public  bool      `json:"public"`
+	Created time.Time `json:"created,omitempty"`
+}
+
+// openStore opens a store backed by the file at path, creating the store if
+// the file does not exist.  If path is empty, state is only kept in memory.
+func openStore(path string) (*store, error) {
+	s := &store{path: path}
+
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(b, &s.data); err != nil {
+				return nil, err
+			}
+		case !os.IsNotExist(err):
+			return nil, err
+		}
+	}
+
+	s.data.init()
+	return s, nil
+}
+
+// init initializes any nil maps in storeData.
+func (d *storeData) init() {
+	if d.Playlists == nil {
+		d.Playlists = make(map[string]playlistMeta)
+	}
+	if d.History == nil {
+		d.History = make(map[string][]historyEntry)
+	}
+	if d.Stars == nil {
+		d.Stars = make(map[string]map[string]time.Time)
+	}
+	if d.Plays == nil {
+		d.Plays = make(map[string]map[string]playStats)
+	}
+	if d.PlayQueues == nil {
+		d.PlayQueues = make(map[string]savedPlayQueue)
+	}
+	if d.Ratings == nil {
+		d.Ratings = make(map[string]savedRating)
+	}
+	if d.Users == nil {
+		d.Users = make(map[string]userAccount)
+	}
+}
+
+// View invokes fn with read-only access to the store's data.
+func (s *store) View(fn func(d *storeData)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	fn(&s.data)
+}
+
+// Update invokes fn with read-write access to the store's data, and persists
+// the data if fn returns no error.
+func (s *store) Update(fn func(d *storeData) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := fn(&s.data); err != nil {
+		return err
+	}
+	s.data.Version++
+
+	return s.save()
+}
+
+// Record invokes fn with read-write access to the store's data, like Update,
+// but neither increments the store's Version nor persists the data.  It is
+// used for frequent changes which do not affect responses with ETags, such
+// as listening history, so that streaming a song does not invalidate every
+// client's cache or rewrite the store's file.  Recorded changes are persisted
+// by the next Update or Flush.
+func (s *store) Record(fn func(d *storeData) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := fn(&s.data); err != nil {
+		return err
+	}
+	s.dirty = true
+
+	return nil
+}
+
+// Flush persists any changes recorded since the store was last persisted.
+func (s *store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+
+	return s.save()
+}
+
+// flushStore periodically persists changes recorded in the store, and
+// persists any remaining changes when ctx is canceled.
+func (s *Server) flushStore(ctx context.Context) {
+	defer s.wg.Done()
+
+	tick := time.NewTicker(storeFlushInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.store.Flush(); err != nil {
+				s.logf("failed to persist state: %v", err)
+			}
+			return
+		case <-tick.C:
+			if err := s.store.Flush(); err != nil {
+				s.logf("failed to persist state: %v", err)
+			}
+		}
+	}
+}
+
+// save persists the store's data.  The caller must hold s.mu.
+func (s *store) save() error {
+	if s.path == "" {
+		s.dirty = false
+		return nil
+	}
+
+	b, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, b); err != nil {
+		return err
+	}
+
+	s.dirty = false
+	return nil
+}
+
+// writeFileAtomic writes b to a temporary file and renames it to path, so a
+// crash cannot leave a partially written file behind.  The temporary file is
+// removed if any step fails.
+func writeFileAtomic(path string, b []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
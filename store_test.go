@@ -0,0 +1,158 @@
+package mpdsub
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_storePersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-store")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+
+	s, err := openStore(path)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	want := playlistMeta{
+		Owner:   "test",
+		Comment: "hello",
+		Public:  true,
+	}
+
+	err = s.Update(func(d *storeData) error {
+		d.Playlists["foo"] = want
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to update store: %v", err)
+	}
+
+	s, err = openStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+
+	var got playlistMeta
+	s.View(func(d *storeData) {
+		got = d.Playlists["foo"]
+	})
+
+	if want != got {
+		t.Fatalf("unexpected playlist metadata:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_storeBadFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "mpdsub-store")
+	if err != nil {
+		t.Fatalf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString("not JSON"); err != nil {
+		t.Fatalf("failed to write temporary file: %v", err)
+	}
+	_ = f.Close()
+
+	if _, err := openStore(f.Name()); err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+}
+
+func Test_writeFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-store")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	if err := writeFileAtomic(path, []byte("foo")); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if want, got := "foo", string(b); want != got {
+		t.Fatalf("unexpected file contents:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Renaming over a non-empty directory fails, and must not leave the
+	// temporary file behind
+	busy := filepath.Join(dir, "busy")
+	if err := os.MkdirAll(filepath.Join(busy, "child"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := writeFileAtomic(busy, []byte("bar")); err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	if want, got := 2, len(fis); want != got {
+		t.Fatalf("unexpected number of files:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_storeRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-store")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+
+	s, err := openStore(path)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	err = s.Record(func(d *storeData) error {
+		d.addPlay("test", "foo.mp3", time.Now())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to record play: %v", err)
+	}
+
+	// Recorded changes neither change the version nor write the file
+	s.View(func(d *storeData) {
+		if want, got := uint64(0), d.Version; want != got {
+			t.Fatalf("unexpected version:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected store file not to exist, but got: %v", err)
+	}
+
+	if err := s.Flush(); err != nil {
+		t.Fatalf("failed to flush store: %v", err)
+	}
+
+	s, err = openStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+
+	var count int
+	s.View(func(d *storeData) {
+		count = d.Plays["test"]["foo.mp3"].Count
+	})
+	if want, got := 1, count; want != got {
+		t.Fatalf("unexpected play count:\n- want: %v\n-  got: %v", want, got)
+	}
+}
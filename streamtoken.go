@@ -0,0 +1,195 @@
+package mpdsub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// defaultStreamTokenTTL is the default amount of time for which a stream
+// token is valid.
+const defaultStreamTokenTTL = 5 * time.Minute
+
+// A streamToken grants a single request to stream a file, without requiring
+// Subsonic authentication.
+type streamToken struct {
+	ID      string
+	User    string
+	Client  string
+	Expires time.Time
+}
+
+// streamTokens stores unredeemed stream tokens.
+type streamTokens struct {
+	mu     sync.Mutex
+	tokens map[string]streamToken
+}
+
+// issue creates a new token for t, and removes any expired tokens.
+func (st *streamTokens) issue(t streamToken, now time.Time) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.tokens == nil {
+		st.tokens = make(map[string]streamToken)
+	}
+
+	st.prune(now)
+	st.tokens[token] = t
+	return token, nil
+}
+
+// evict removes tokens which expired before now.
+func (st *streamTokens) evict(now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.prune(now)
+}
+
+// prune removes tokens which expired before now.  The caller must hold
+// st.mu.
+func (st *streamTokens) prune(now time.Time) {
+	for k, v := range st.tokens {
+		if now.After(v.Expires) {
+			delete(st.tokens, k)
+		}
+	}
+}
+
+// redeem consumes a token, returning its streamToken if the token exists,
+// has not expired, and grants access to the file with the specified ID.
+func (st *streamTokens) redeem(token string, id string, now time.Time) (streamToken, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	t, ok := st.tokens[token]
+	if !ok || t.ID != id {
+		return streamToken{}, false
+	}
+
+	// Tokens are single-use, even if expired
+	delete(st.tokens, token)
+
+	return t, !now.After(t.Expires)
+}
+
+// revoke removes every token issued to user.
+func (st *streamTokens) revoke(user string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for k, v := range st.tokens {
+		if v.User == user {
+			delete(st.tokens, k)
+		}
+	}
+}
+
+// streamTokenTTL returns the amount of time for which a stream token is valid.
+func (s *Server) streamTokenTTL() time.Duration {
+	if s.cfg.StreamTokenTTL > 0 {
+		return s.cfg.StreamTokenTTL
+	}
+
+	return defaultStreamTokenTTL
+}
+
+// redeemStreamToken produces a requestContext for a stream request which
+// carries a valid stream token.  If the request is not a stream request or
+// the token is invalid, it returns false.
+func (s *Server) redeemStreamToken(r *http.Request) (*requestContext, bool) {
+	if r.URL.Path != "/rest/stream.view" {
+		return nil, false
+	}
+
+	q := r.URL.Query()
+
+	token := q.Get("streamToken")
+	if token == "" {
+		return nil, false
+	}
+
+	t, ok := s.streamTokens.redeem(token, q.Get("id"), time.Now())
+	if !ok {
+		return nil, false
+	}
+
+	return &requestContext{
+		User:    t.User,
+		Client:  t.Client,
+		Version: apiVersion,
+	}, true
+}
+
+// createStreamToken is a custom endpoint which issues a short-lived,
+// single-use token for streaming a file.  The returned URL may be handed to
+// a renderer, such as a Chromecast or UPnP device, which cannot perform
+// Subsonic authentication.
+func (s *Server) createStreamToken(w http.ResponseWriter, r *http.Request) {
+	qID := r.URL.Query().Get("id")
+	if qID == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	fs, err := s.db.List("file")
+	if err != nil {
+		s.logf("error listing files from mpd for creating stream token: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+	files := indexFiles(fs)
+
+	id, ok := s.parseID(qID, len(files))
+	if !ok {
+		writeXML(w, errGeneric)
+		return
+	}
+	if id >= len(files) || files[id].Dir {
+		writeXML(w, errNotFound)
+		return
+	}
+
+	rctx := requestContextFrom(r)
+	if !s.visible(rctx.User, files[id].Name) {
+		writeXML(w, errNotAuthorized)
+		return
+	}
+
+	now := time.Now()
+	expires := now.Add(s.streamTokenTTL())
+
+	token, err := s.streamTokens.issue(streamToken{
+		ID:      qID,
+		User:    rctx.User,
+		Client:  rctx.Client,
+		Expires: expires,
+	}, now)
+	if err != nil {
+		s.logf("error creating stream token: %v", err)
+		writeXML(w, errGeneric)
+		return
+	}
+
+	v := url.Values{}
+	v.Set("id", qID)
+	v.Set("streamToken", token)
+
+	writeXML(w, func(c *container) {
+		c.StreamToken = &streamTokenXML{
+			Token:   token,
+			Expires: expires.UTC().Format(time.RFC3339),
+			URL:     "/rest/stream.view?" + v.Encode(),
+		}
+	})
+}
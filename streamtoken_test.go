@@ -0,0 +1,81 @@
+package mpdsub
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_createStreamToken(t *testing.T) {
+	db := &memoryDatabase{
+		files: []string{"foo.mp3", "bar.mp3"},
+	}
+	fs := &memoryFilesystem{
+		files: map[string]*memoryFile{
+			"foo.mp3": {ReadSeeker: strings.NewReader("foo")},
+			"bar.mp3": {ReadSeeker: strings.NewReader("bar")},
+		},
+	}
+
+	cfg, values := configAuth()
+	values.Set("id", "0")
+
+	withServer(t, db, fs, cfg, func(base string) {
+		res := testRequest(t, base, http.MethodGet, "/rest/createStreamToken.view", values)
+		c := mustDecodeXML(t, res)
+		if c.StreamToken == nil {
+			t.Fatal("no stream token in response")
+		}
+
+		stream := func(u string) *http.Response {
+			res, err := http.Get(base + u)
+			if err != nil {
+				t.Fatalf("failed to perform request: %v", err)
+			}
+
+			return res
+		}
+
+		// Tokens cannot be used to stream other files
+		other := strings.Replace(c.StreamToken.URL, "id=0", "id=1", 1)
+		if c := mustDecodeXML(t, stream(other)); c.Error == nil {
+			t.Fatal("expected an error for other file, but none occurred")
+		}
+
+		res = stream(c.StreamToken.URL)
+		b, err := ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+
+		if want, got := "foo", string(b); want != got {
+			t.Fatalf("unexpected body:\n- want: %q\n-  got: %q",
+				want, got)
+		}
+
+		// Tokens are single-use
+		if c := mustDecodeXML(t, stream(c.StreamToken.URL)); c.Error == nil {
+			t.Fatal("expected an error for reused token, but none occurred")
+		}
+	})
+}
+
+func Test_streamTokensExpired(t *testing.T) {
+	var st streamTokens
+
+	now := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	token, err := st.issue(streamToken{
+		ID:      "0",
+		Expires: now.Add(time.Minute),
+	}, now)
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+
+	if _, ok := st.redeem(token, "0", now.Add(2*time.Minute)); ok {
+		t.Fatal("expired token was redeemed")
+	}
+}
@@ -0,0 +1,89 @@
+package mpdsub
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultTopSongs is the number of songs returned by getTopSongs when a
+	// client does not specify a count.
+	defaultTopSongs = 50
+
+	// maxTopSongs is the maximum number of songs returned by a single
+	// getTopSongs request.
+	maxTopSongs = 500
+)
+
+// getTopSongs is used in Subsonic to retrieve the most played songs by an
+// artist.  Songs are ordered by the number of times the user played them,
+// and songs with the same number of plays, such as songs which were never
+// played, are ordered by title.
+func (s *Server) getTopSongs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	artist := q.Get("artist")
+	if artist == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	count := defaultTopSongs
+	if qCount := q.Get("count"); qCount != "" {
+		n, err := strconv.Atoi(qCount)
+		if err != nil || n < 0 {
+			writeXML(w, errGeneric)
+			return
+		}
+		count = n
+	}
+	if count > maxTopSongs {
+		count = maxTopSongs
+	}
+
+	songs, err := s.db.Find("artist", artist)
+	if err != nil {
+		s.logf("error retrieving songs by artist from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	children, err := s.songChildren(requestContextFrom(r).User, songs)
+	if err != nil {
+		s.logf("error retrieving songs by artist from mpd: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	sort.Stable(byTopSong(children))
+	if len(children) > count {
+		children = children[:count]
+	}
+
+	out := make([]song, 0, len(children))
+	for _, c := range children {
+		out = append(out, song{child: c})
+	}
+
+	writeXML(w, func(c *container) {
+		c.TopSongs = &topSongsContainer{
+			Songs: out,
+		}
+	})
+}
+
+// byTopSong sorts children by their play counts, most played first, and
+// then by their titles, case-insensitively.
+type byTopSong []child
+
+func (b byTopSong) Len() int { return len(b) }
+func (b byTopSong) Less(i, j int) bool {
+	if b[i].PlayCount != b[j].PlayCount {
+		return b[i].PlayCount > b[j].PlayCount
+	}
+
+	return strings.ToLower(b[i].Title) < strings.ToLower(b[j].Title)
+}
+func (b byTopSong) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,93 @@
+package mpdsub
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func TestServer_getTopSongs(t *testing.T) {
+	songs := []mpd.Attrs{
+		{"file": "a.mp3", "Artist": "Foo", "Title": "alpha", "duration": "60"},
+		{"file": "b.mp3", "Artist": "Foo", "Title": "Beta", "duration": "60"},
+		{"file": "c.mp3", "Artist": "Foo", "Title": "Charlie", "duration": "60"},
+	}
+
+	db := &memoryDatabase{
+		files: []string{"a.mp3", "b.mp3", "c.mp3"},
+		songs: songs,
+		finds: map[string][]mpd.Attrs{
+			"artist Foo": songs,
+		},
+	}
+
+	cfg, values := configAuth()
+
+	// params copies values and adds additional query parameters
+	params := func(extra url.Values) url.Values {
+		v := make(url.Values, len(values))
+		for k, vv := range values {
+			v[k] = vv
+		}
+		for k, vv := range extra {
+			v[k] = vv
+		}
+		return v
+	}
+
+	// titles requests the top songs and returns their titles
+	titles := func(t *testing.T, base string, extra url.Values) []string {
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getTopSongs.view", params(extra)))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+
+		var out []string
+		for _, s := range c.TopSongs.Songs {
+			out = append(out, s.Title)
+		}
+		return out
+	}
+
+	ms := func(t time.Time) string {
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	}
+
+	withServer(t, db, nil, cfg, func(base string) {
+		t.Run("missing artist", func(t *testing.T) {
+			c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getTopSongs.view", values))
+			if c.Error == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+
+			if want, got := codeMissingParameter, c.Error.Code; want != got {
+				t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+
+		// Without play statistics, songs are ordered by title
+		want := []string{"alpha", "Beta", "Charlie"}
+		if got := titles(t, base, url.Values{"artist": {"Foo"}}); !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected unplayed top songs:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		now := time.Now()
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/scrobble.view", params(url.Values{
+			"id":   {"2", "1", "2"},
+			"time": {ms(now.Add(-3 * time.Hour)), ms(now.Add(-2 * time.Hour)), ms(now.Add(-1 * time.Hour))},
+		})))
+		if c.Error != nil {
+			t.Fatalf("unexpected error: %+v", c.Error)
+		}
+
+		want = []string{"Charlie", "Beta"}
+		if got := titles(t, base, url.Values{"artist": {"Foo"}, "count": {"2"}}); !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected played top songs:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
@@ -0,0 +1,187 @@
+package mpdsub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// A Transcoder is an external command which converts audio files to another
+// format for streaming, such as ffmpeg, sox, or a hardware-accelerated
+// encoder.
+type Transcoder struct {
+	// Format is the name of the output format, such as "mp3" or "opus".
+	// Subsonic clients select a Transcoder using the stream format parameter.
+	Format string
+
+	// ContentType is the MIME type of the command's output, such as
+	// "audio/mpeg".
+	ContentType string
+
+	// Command is a template for the command to execute.  The command must
+	// write the transcoded audio to its standard output.  The template is
+	// split into arguments on whitespace; double quotes may be used to
+	// group arguments containing whitespace.  No shell is involved.
+	//
+	// The following placeholders are replaced in each argument:
+	//   {path}:    absolute path to the input file (required)
+	//   {offset}:  offset in seconds at which to begin transcoding
+	//   {bitrate}: target bitrate in kbps
+	//   {format}:  the value of Format
+	//
+	// For example:
+	//   ffmpeg -v 0 -ss {offset} -i {path} -map 0:a:0 -b:a {bitrate}k -f {format} -
+	Command string
+
+	// BitRate is the default bitrate in kbps passed to the command when a
+	// client does not specify a maximum bitrate.
+	BitRate int
+}
+
+// Placeholders which may appear in a Transcoder's Command.
+const (
+	placeholderPath    = "{path}"
+	placeholderOffset  = "{offset}"
+	placeholderBitRate = "{bitrate}"
+	placeholderFormat  = "{format}"
+)
+
+// lookPath is swapped out in tests to avoid depending on the commands
+// installed on a system.
+var lookPath = exec.LookPath
+
+// validate verifies that a Transcoder is well-formed and that its command
+// exists.
+func (t *Transcoder) validate() error {
+	if t.Format == "" {
+		return fmt.Errorf("transcoder %q: format must not be empty", t.Command)
+	}
+	if t.ContentType == "" {
+		return fmt.Errorf("transcoder %q: content type must not be empty", t.Format)
+	}
+
+	terms, err := splitTerms(t.Command)
+	if err != nil {
+		return fmt.Errorf("transcoder %q: %v", t.Format, err)
+	}
+	if len(terms) == 0 {
+		return fmt.Errorf("transcoder %q: command must not be empty", t.Format)
+	}
+
+	var hasPath bool
+	for _, tt := range terms {
+		a := tt.Text
+		if strings.Contains(a, placeholderPath) {
+			hasPath = true
+		}
+
+		// Reject any unknown placeholders, which are likely typos
+		rest := a
+		for _, p := range []string{placeholderPath, placeholderOffset, placeholderBitRate, placeholderFormat} {
+			rest = strings.Replace(rest, p, "", -1)
+		}
+		if i := strings.Index(rest, "{"); i != -1 && strings.Contains(rest[i:], "}") {
+			return fmt.Errorf("transcoder %q: unknown placeholder in argument %q", t.Format, a)
+		}
+	}
+
+	if !hasPath {
+		return fmt.Errorf("transcoder %q: command must contain %s placeholder", t.Format, placeholderPath)
+	}
+
+	if _, err := lookPath(terms[0].Text); err != nil {
+		return fmt.Errorf("transcoder %q: %v", t.Format, err)
+	}
+
+	return nil
+}
+
+// args produces the command arguments for transcoding the file at path,
+// beginning at offset seconds, at the specified bitrate.
+func (t *Transcoder) args(path string, offset int, bitRate int) []string {
+	if bitRate <= 0 {
+		bitRate = t.BitRate
+	}
+
+	r := strings.NewReplacer(
+		placeholderPath, path,
+		placeholderOffset, strconv.Itoa(offset),
+		placeholderBitRate, strconv.Itoa(bitRate),
+		placeholderFormat, t.Format,
+	)
+
+	// Command was validated at startup
+	split, _ := splitTerms(t.Command)
+
+	args := make([]string, 0, len(split))
+	for _, a := range split {
+		args = append(args, r.Replace(a.Text))
+	}
+
+	return args
+}
+
+// formatRaw is the stream format which indicates a file should not be
+// transcoded.
+const formatRaw = "raw"
+
+// clientFormat returns the default stream format configured for a client,
+// or empty string if none is configured.
+func (s *Server) clientFormat(client string) string {
+	for c, format := range s.cfg.ClientFormats {
+		if strings.EqualFold(c, client) {
+			return format
+		}
+	}
+
+	return ""
+}
+
+// validateClientFormats verifies that each format in a map of client names
+// to default stream formats refers to a configured Transcoder, or is "raw".
+func validateClientFormats(formats map[string]string, ts []Transcoder) error {
+	for client, format := range formats {
+		if strings.EqualFold(format, formatRaw) {
+			continue
+		}
+
+		var found bool
+		for _, t := range ts {
+			if strings.EqualFold(t.Format, format) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("client %q: no transcoder configured for format %q", client, format)
+		}
+	}
+
+	return nil
+}
+
+// transcoder returns the configured Transcoder for format, if one exists.
+func (s *Server) transcoder(format string) (*Transcoder, bool) {
+	for i := range s.cfg.Transcoders {
+		if strings.EqualFold(s.cfg.Transcoders[i].Format, format) {
+			return &s.cfg.Transcoders[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// transcode runs a Transcoder for the file at path and copies its output
+// to w until the command exits or ctx is canceled.
+func transcode(ctx context.Context, w io.Writer, t *Transcoder, path string, offset int, bitRate int) error {
+	args := t.args(path, offset, bitRate)
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = w
+
+	return cmd.Run()
+}
@@ -0,0 +1,255 @@
+package mpdsub
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTranscoder_validate(t *testing.T) {
+	lp := lookPath
+	defer func() { lookPath = lp }()
+
+	lookPath = func(file string) (string, error) {
+		if file == "ffmpeg" {
+			return "/usr/bin/ffmpeg", nil
+		}
+
+		return "", errors.New("not found")
+	}
+
+	tests := []struct {
+		name string
+		t    Transcoder
+		ok   bool
+	}{
+		{
+			name: "no format",
+			t: Transcoder{
+				ContentType: "audio/mpeg",
+				Command:     "ffmpeg -i {path} -",
+			},
+		},
+		{
+			name: "no content type",
+			t: Transcoder{
+				Format:  "mp3",
+				Command: "ffmpeg -i {path} -",
+			},
+		},
+		{
+			name: "no command",
+			t: Transcoder{
+				Format:      "mp3",
+				ContentType: "audio/mpeg",
+			},
+		},
+		{
+			name: "no path placeholder",
+			t: Transcoder{
+				Format:      "mp3",
+				ContentType: "audio/mpeg",
+				Command:     "ffmpeg -i - -",
+			},
+		},
+		{
+			name: "unknown placeholder",
+			t: Transcoder{
+				Format:      "mp3",
+				ContentType: "audio/mpeg",
+				Command:     "ffmpeg -i {path} -b:a {bitrat}k -",
+			},
+		},
+		{
+			name: "command not found",
+			t: Transcoder{
+				Format:      "opus",
+				ContentType: "audio/ogg",
+				Command:     "opusenc {path} -",
+			},
+		},
+		{
+			name: "OK",
+			t: Transcoder{
+				Format:      "mp3",
+				ContentType: "audio/mpeg",
+				Command:     "ffmpeg -ss {offset} -i {path} -b:a {bitrate}k -f {format} -",
+			},
+			ok: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.t.validate()
+			if err != nil && tt.ok {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && !tt.ok {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func TestTranscoder_args(t *testing.T) {
+	tr := &Transcoder{
+		Format:  "mp3",
+		Command: `ffmpeg -ss {offset} -i {path} -metadata "comment=via {format}" -metadata "OR" -b:a {bitrate}k -`,
+		BitRate: 192,
+	}
+
+	tests := []struct {
+		name    string
+		bitRate int
+		args    []string
+	}{
+		{
+			name: "default bitrate",
+			args: []string{"ffmpeg", "-ss", "10", "-i", "/var/music/foo bar.flac", "-metadata", "comment=via mp3", "-metadata", "OR", "-b:a", "192k", "-"},
+		},
+		{
+			name:    "client bitrate",
+			bitRate: 64,
+			args:    []string{"ffmpeg", "-ss", "10", "-i", "/var/music/foo bar.flac", "-metadata", "comment=via mp3", "-metadata", "OR", "-b:a", "64k", "-"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tr.args("/var/music/foo bar.flac", 10, tt.bitRate)
+
+			if want, got := tt.args, args; !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected arguments:\n- want: %q\n-  got: %q", want, got)
+			}
+		})
+	}
+}
+
+func TestServer_streamTranscode(t *testing.T) {
+	if _, err := lookPath("cat"); err != nil {
+		t.Skipf("skipping, cat not available: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "mpdsub-transcode")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "foo.flac"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to write temporary file: %v", err)
+	}
+
+	db := &memoryDatabase{
+		files: []string{"foo.flac"},
+	}
+
+	cfg, values := configAuth()
+	cfg.MusicDirectory = dir
+	cfg.Transcoders = []Transcoder{{
+		Format:      "mp3",
+		ContentType: "audio/mpeg",
+		Command:     "cat {path}",
+	}}
+
+	values.Set("id", "0")
+
+	tests := []struct {
+		name    string
+		client  string
+		format  string
+		formats map[string]string
+	}{
+		{
+			name:   "format parameter",
+			client: "test",
+			format: "mp3",
+		},
+		{
+			name:   "client default format",
+			client: "Sonos",
+			formats: map[string]string{
+				"sonos": "mp3",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values.Set("c", tt.client)
+			values.Set("format", tt.format)
+			cfg.ClientFormats = tt.formats
+
+			testStreamTranscode(t, db, cfg, values)
+		})
+	}
+}
+
+// testStreamTranscode verifies that a request to stream.view transcodes
+// a file containing "hello" using cat.
+func testStreamTranscode(t *testing.T, db database, cfg *Config, values url.Values) {
+	withServer(t, db, nil, cfg, func(base string) {
+		res := testRequest(t, base, http.MethodGet, "/rest/stream.view", values)
+		defer res.Body.Close()
+
+		if want, got := "audio/mpeg", res.Header.Get(contentType); want != got {
+			t.Fatalf("unexpected Content-Type header:\n- want: %q\n-  got: %q", want, got)
+		}
+
+		b, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+
+		if want, got := "hello", string(b); want != got {
+			t.Fatalf("unexpected body:\n- want: %q\n-  got: %q", want, got)
+		}
+	})
+}
+
+func Test_validateClientFormats(t *testing.T) {
+	ts := []Transcoder{{Format: "mp3"}}
+
+	tests := []struct {
+		name    string
+		formats map[string]string
+		ok      bool
+	}{
+		{
+			name: "empty",
+			ok:   true,
+		},
+		{
+			name: "OK",
+			formats: map[string]string{
+				"Sonos": "MP3",
+				"web":   "raw",
+			},
+			ok: true,
+		},
+		{
+			name: "unknown format",
+			formats: map[string]string{
+				"DSub": "opus",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateClientFormats(tt.formats, ts)
+			if err != nil && tt.ok {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && !tt.ok {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
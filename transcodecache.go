@@ -0,0 +1,206 @@
+package mpdsub
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A transcodeCache keeps the complete output of recent transcoding jobs in
+// memory, so that clients can seek within a transcoded song using Range
+// requests or timeOffset without running the transcoder again.  Once the
+// cache exceeds its maximum size, the least recently used renditions are
+// evicted.
+//
+// A nil *transcodeCache caches nothing.
+type transcodeCache struct {
+	max int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+	hits    uint64
+}
+
+// newTranscodeCache creates a transcodeCache which holds at most max bytes.
+// If max is 0, nil is returned.
+func newTranscodeCache(max int64) *transcodeCache {
+	if max <= 0 {
+		return nil
+	}
+
+	return &transcodeCache{
+		max:     max,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// A cachedTranscode is the complete output of a transcoding job.
+type cachedTranscode struct {
+	key     string
+	data    []byte
+	created time.Time
+}
+
+// renditionKey returns the key which identifies a complete rendition of the
+// file at path.
+func renditionKey(t *Transcoder, path string, bitRate int) string {
+	return strings.Join([]string{
+		t.Format,
+		path,
+		strconv.Itoa(bitRate),
+	}, "\x00")
+}
+
+// get retrieves a cached rendition, marking it as recently used.
+func (c *transcodeCache) get(key string) (*cachedTranscode, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.hits++
+	c.lru.MoveToFront(e)
+	return e.Value.(*cachedTranscode), true
+}
+
+// add caches a rendition, evicting the least recently used renditions until
+// the cache is within its maximum size.  Renditions larger than the cache
+// are not cached.
+func (c *transcodeCache) add(key string, data []byte) {
+	if c == nil || int64(len(data)) > c.max {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+
+	c.entries[key] = c.lru.PushFront(&cachedTranscode{
+		key:     key,
+		data:    data,
+		created: time.Now(),
+	})
+	c.size += int64(len(data))
+
+	for c.size > c.max {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes an entry from the cache.  The caller must hold c.mu.
+func (c *transcodeCache) remove(e *list.Element) {
+	ct := e.Value.(*cachedTranscode)
+
+	c.lru.Remove(e)
+	delete(c.entries, ct.key)
+	c.size -= int64(len(ct.data))
+}
+
+// clear removes all renditions from the cache, such as when files in MPD's
+// database may have changed.
+func (c *transcodeCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.size = 0
+}
+
+// stats returns the number of cache hits and the number of bytes cached.
+func (c *transcodeCache) stats() (uint64, int64) {
+	if c == nil {
+		return 0, 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hits, c.size
+}
+
+// seekable reports whether playback can begin partway through a cached
+// rendition in format t.  MP3 is a sequence of self-contained frames, and
+// transcoders produce it at a constant bitrate, so playback can begin at a
+// proportional byte offset.  Container formats such as Ogg carry headers and
+// page granule positions which make a slice of the rendition unplayable, so
+// the transcoder is run again at the requested time offset instead.
+func (ct *cachedTranscode) seekable(t *Transcoder) bool {
+	return t.Format == "mp3"
+}
+
+// byteOffset returns the byte offset at which playback begins offset seconds
+// into a rendition of a song lasting duration seconds.  Each second of audio
+// in a constant bitrate rendition is indexed at an equal share of it, so
+// byteOffset may only be used for seekable renditions.
+func (ct *cachedTranscode) byteOffset(offset int, duration int) int {
+	if offset <= 0 || duration <= 0 {
+		return 0
+	}
+	if offset >= duration {
+		return len(ct.data)
+	}
+
+	return int(int64(len(ct.data)) * int64(offset) / int64(duration))
+}
+
+// etag computes a strong ETag for a cached rendition beginning at offset
+// seconds.
+func (ct *cachedTranscode) etag(offset int) string {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%s\x00%d\x00%d", ct.key, ct.created.UnixNano(), offset)
+
+	return fmt.Sprintf(`"%x"`, h.Sum64())
+}
+
+// serveCachedTranscode serves a cached rendition of the song with the
+// specified name, beginning at offset seconds.  Range requests are
+// supported, so clients can seek or resume within the rendition.
+func (s *Server) serveCachedTranscode(w http.ResponseWriter, r *http.Request, t *Transcoder, ct *cachedTranscode, name string, offset int) {
+	data := ct.data
+	if offset > 0 {
+		songs, err := s.db.ListAllInfo(name)
+		if err != nil {
+			s.logf("error retrieving song duration from mpd for %q: %v", name, err)
+			writeXML(w, errMPD(err))
+			return
+		}
+
+		var duration int
+		for _, a := range songs {
+			if a["file"] == name {
+				duration = songDuration(a)
+				break
+			}
+		}
+
+		data = data[ct.byteOffset(offset, duration):]
+	}
+
+	w.Header().Set(contentType, t.ContentType)
+	w.Header().Set("ETag", ct.etag(offset))
+	http.ServeContent(w, r, "", ct.created, bytes.NewReader(data))
+}
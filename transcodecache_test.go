@@ -0,0 +1,170 @@
+package mpdsub
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fhs/gompd/mpd"
+)
+
+func Test_transcodeCache(t *testing.T) {
+	c := newTranscodeCache(10)
+
+	c.add("a", []byte("aaaa"))
+	c.add("b", []byte("bbbb"))
+
+	// Use a so that b is evicted first
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	c.add("c", []byte("cccc"))
+	if _, ok := c.get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+
+	// Renditions larger than the cache are not cached
+	c.add("d", []byte("ddddddddddd"))
+	if _, ok := c.get("d"); ok {
+		t.Fatal("expected d not to be cached")
+	}
+
+	hits, size := c.stats()
+	if want, got := uint64(1), hits; want != got {
+		t.Fatalf("unexpected cache hits:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := int64(8), size; want != got {
+		t.Fatalf("unexpected cache size:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	c.clear()
+	if _, ok := c.get("a"); ok {
+		t.Fatal("expected a to be cleared")
+	}
+
+	// A nil cache caches nothing
+	var nc *transcodeCache
+	nc.add("a", []byte("aaaa"))
+	if _, ok := nc.get("a"); ok {
+		t.Fatal("expected nil cache to cache nothing")
+	}
+}
+
+func TestServer_streamTranscodeCache(t *testing.T) {
+	if _, err := lookPath("cat"); err != nil {
+		t.Skipf("skipping, cat not available: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "mpdsub-transcode")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "foo.flac"), []byte("0123456789"), 0644); err != nil {
+		t.Fatalf("failed to write temporary file: %v", err)
+	}
+
+	db := &memoryDatabase{
+		files: []string{"foo.flac"},
+		songs: []mpd.Attrs{{"file": "foo.flac", "duration": "10"}},
+	}
+
+	cfg, values := configAuth()
+	cfg.MusicDirectory = dir
+	cfg.TranscodeCacheSize = 1024
+	cfg.Transcoders = []Transcoder{{
+		Format:      "mp3",
+		ContentType: "audio/mpeg",
+		Command:     "cat {path}",
+	}}
+
+	values.Set("id", "0")
+	values.Set("format", "mp3")
+
+	// body reads the body of a response and verifies its status
+	body := func(t *testing.T, res *http.Response, status int) string {
+		defer res.Body.Close()
+
+		if want, got := status, res.StatusCode; want != got {
+			t.Fatalf("unexpected HTTP status:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		b, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+
+		return string(b)
+	}
+
+	withServer(t, db, nil, cfg, func(base string) {
+		// The first request runs the transcoder, and cannot be seeked
+		res := testRequest(t, base, http.MethodGet, "/rest/stream.view", values)
+		if want, got := "none", res.Header.Get("Accept-Ranges"); want != got {
+			t.Fatalf("unexpected Accept-Ranges header:\n- want: %q\n-  got: %q", want, got)
+		}
+		if want, got := "0123456789", body(t, res, http.StatusOK); want != got {
+			t.Fatalf("unexpected body:\n- want: %q\n-  got: %q", want, got)
+		}
+
+		res = rangeRequest(t, base, "/rest/stream.view", values, "bytes=2-4", "")
+		if want, got := "audio/mpeg", res.Header.Get(contentType); want != got {
+			t.Fatalf("unexpected Content-Type header:\n- want: %q\n-  got: %q", want, got)
+		}
+		if want, got := "234", body(t, res, http.StatusPartialContent); want != got {
+			t.Fatalf("unexpected partial body:\n- want: %q\n-  got: %q", want, got)
+		}
+
+		values.Set("timeOffset", "5")
+		res = testRequest(t, base, http.MethodGet, "/rest/stream.view", values)
+		if want, got := "56789", body(t, res, http.StatusOK); want != got {
+			t.Fatalf("unexpected body at time offset:\n- want: %q\n-  got: %q", want, got)
+		}
+	})
+}
+
+func TestServer_streamTranscodeCacheContainer(t *testing.T) {
+	if _, err := lookPath("echo"); err != nil {
+		t.Skipf("skipping, echo not available: %v", err)
+	}
+
+	db := &memoryDatabase{
+		files: []string{"foo.flac"},
+		songs: []mpd.Attrs{{"file": "foo.flac", "duration": "10"}},
+	}
+
+	cfg, values := configAuth()
+	cfg.MusicDirectory = "/music"
+	cfg.TranscodeCacheSize = 1024
+	cfg.Transcoders = []Transcoder{{
+		Format:      "opus",
+		ContentType: "audio/ogg",
+		Command:     "echo {offset} {path}",
+	}}
+
+	values.Set("id", "0")
+	values.Set("format", "opus")
+
+	withServer(t, db, nil, cfg, func(base string) {
+		for _, offset := range []string{"0", "0", "5"} {
+			values.Set("timeOffset", offset)
+			res := testRequest(t, base, http.MethodGet, "/rest/stream.view", values)
+
+			b, err := ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+			if err != nil {
+				t.Fatalf("failed to read body: %v", err)
+			}
+
+			// Ogg renditions cannot be sliced, so the transcoder must run
+			// again at the requested offset rather than using the cache
+			if want, got := offset+" /music/foo.flac\n", string(b); want != got {
+				t.Fatalf("unexpected body at time offset %s:\n- want: %q\n-  got: %q", offset, want, got)
+			}
+		}
+	})
+}
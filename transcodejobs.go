@@ -0,0 +1,337 @@
+package mpdsub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A transcodeManager runs transcoding jobs, limiting the number of
+// concurrent transcoder processes and sharing the output of a job between
+// clients which request the same rendition of a file.  The output of
+// complete renditions is kept in a transcodeCache.
+type transcodeManager struct {
+	// slots limits concurrent jobs, or is nil if jobs are unlimited.
+	slots chan struct{}
+
+	// cache holds complete renditions, or is nil if caching is disabled.
+	cache *transcodeCache
+
+	mu      sync.Mutex
+	jobs    map[string]*transcodeJob
+	queued  int
+	running int
+	reused  uint64
+}
+
+// newTranscodeManager creates a transcodeManager which runs at most max
+// concurrent jobs, and caches at most cacheSize bytes of complete
+// renditions.  If max is 0, jobs are unlimited.  If cacheSize is 0,
+// renditions are not cached.
+func newTranscodeManager(max int, cacheSize int64) *transcodeManager {
+	m := &transcodeManager{
+		cache: newTranscodeCache(cacheSize),
+		jobs:  make(map[string]*transcodeJob),
+	}
+
+	if max > 0 {
+		m.slots = make(chan struct{}, max)
+	}
+
+	return m
+}
+
+// A transcodeJob is a single transcoder process, whose output is written to
+// a temporary file so it can be read by one or more clients, however far
+// behind the transcoder they are, without holding the output in memory.
+type transcodeJob struct {
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	f       *os.File
+	size    int64
+	done    bool
+	err     error
+	readers int
+}
+
+// Write implements io.Writer, appending transcoder output to the file and
+// waking any waiting readers.
+func (j *transcodeJob) Write(b []byte) (int, error) {
+	// Only the transcoder writes to the file, and readers only read the
+	// part which was written, so the file is written without the lock
+	n, err := j.f.Write(b)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.size += int64(n)
+	j.cond.Broadcast()
+	return n, err
+}
+
+// finish marks the job as complete and wakes any waiting readers.  The
+// output file is removed once no readers remain.
+func (j *transcodeJob) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.done = true
+	j.err = err
+	j.cond.Broadcast()
+
+	if j.readers == 0 {
+		j.remove()
+	}
+}
+
+// remove closes and removes the job's output file.  The caller must hold
+// j.mu.
+func (j *transcodeJob) remove() {
+	if j.f == nil {
+		return
+	}
+
+	_ = j.f.Close()
+	_ = os.Remove(j.f.Name())
+	j.f = nil
+}
+
+// transcode writes the transcoded output of the file at path to w, starting
+// a new job or joining an in-progress job for the same rendition.  If the
+// maximum number of jobs are running, the job is queued until another
+// job completes.
+func (m *transcodeManager) transcode(ctx context.Context, w io.Writer, t *Transcoder, path string, offset int, bitRate int) error {
+	key := strings.Join([]string{
+		t.Format,
+		path,
+		strconv.Itoa(offset),
+		strconv.Itoa(bitRate),
+	}, "\x00")
+
+	m.mu.Lock()
+	j, ok := m.jobs[key]
+	if ok {
+		m.reused++
+	} else {
+		jctx, cancel := context.WithCancel(context.Background())
+		j = &transcodeJob{cancel: cancel}
+		j.cond = sync.NewCond(&j.mu)
+
+		m.jobs[key] = j
+		go m.run(jctx, key, j, t, path, offset, bitRate)
+	}
+	j.mu.Lock()
+	j.readers++
+	j.mu.Unlock()
+	m.mu.Unlock()
+
+	defer m.leave(key, j)
+
+	return j.copy(ctx, w)
+}
+
+// run runs a job once a slot is available.
+func (m *transcodeManager) run(ctx context.Context, key string, j *transcodeJob, t *Transcoder, path string, offset int, bitRate int) {
+	err := m.runJob(ctx, j, t, path, offset, bitRate)
+
+	// The job is removed before it finishes, so no reader can join it
+	// after its output file is removed
+	m.mu.Lock()
+	if m.jobs[key] == j {
+		// A new job may have replaced this one if it was canceled
+		delete(m.jobs, key)
+	}
+	m.mu.Unlock()
+
+	j.finish(err)
+}
+
+// runJob waits for a slot and runs the transcoder for a job, writing its
+// output to a temporary file.
+func (m *transcodeManager) runJob(ctx context.Context, j *transcodeJob, t *Transcoder, path string, offset int, bitRate int) error {
+	if m.slots != nil {
+		m.setQueued(1)
+
+		select {
+		case m.slots <- struct{}{}:
+			m.setQueued(-1)
+			defer func() { <-m.slots }()
+		case <-ctx.Done():
+			m.setQueued(-1)
+			return ctx.Err()
+		}
+	}
+
+	f, err := ioutil.TempFile("", "mpdsub-transcode")
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.f = f
+	j.mu.Unlock()
+
+	m.mu.Lock()
+	m.running++
+	m.mu.Unlock()
+
+	err = transcode(ctx, j, t, path, offset, bitRate)
+
+	m.mu.Lock()
+	m.running--
+	m.mu.Unlock()
+
+	// Only complete renditions can be seeked into; the file is no longer
+	// appended to once the transcoder exits
+	if err == nil && offset == 0 {
+		m.cacheOutput(renditionKey(t, path, bitRate), j)
+	}
+
+	return err
+}
+
+// cacheOutput adds the output of a complete job to the cache, if it fits.
+func (m *transcodeManager) cacheOutput(key string, j *transcodeJob) {
+	if m.cache == nil || j.size > m.cache.max {
+		return
+	}
+
+	b := make([]byte, j.size)
+	if _, err := j.f.ReadAt(b, 0); err != nil {
+		return
+	}
+
+	m.cache.add(key, b)
+}
+
+// cached retrieves a complete rendition of the file at path, if one is
+// cached.
+func (m *transcodeManager) cached(t *Transcoder, path string, bitRate int) (*cachedTranscode, bool) {
+	return m.cache.get(renditionKey(t, path, bitRate))
+}
+
+// setQueued adjusts the number of queued jobs by n.
+func (m *transcodeManager) setQueued(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queued += n
+}
+
+// leave removes a reader from a job, canceling the job if no readers remain.
+func (m *transcodeManager) leave(key string, j *transcodeJob) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.readers--
+	if j.readers > 0 {
+		return
+	}
+	if j.done {
+		j.remove()
+		return
+	}
+
+	// Nobody is listening, so stop the transcoder and make sure no new
+	// readers join the canceled job
+	j.cancel()
+	if m.jobs[key] == j {
+		delete(m.jobs, key)
+	}
+}
+
+// copy copies the job's output to w as it becomes available, until the job
+// completes or ctx is canceled.
+func (j *transcodeJob) copy(ctx context.Context, w io.Writer) error {
+	// Wake this reader if its context is canceled while waiting for output
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			j.mu.Lock()
+			j.cond.Broadcast()
+			j.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	var (
+		n   int64
+		buf = make([]byte, 32<<10)
+	)
+	for {
+		j.mu.Lock()
+		for n == j.size && !j.done && ctx.Err() == nil {
+			j.cond.Wait()
+		}
+
+		if err := ctx.Err(); err != nil {
+			j.mu.Unlock()
+			return err
+		}
+
+		// The file is only appended to, and is not removed while this
+		// reader remains, so the written part can be read after unlocking
+		f, size := j.f, j.size
+		done, err := j.done, j.err
+		j.mu.Unlock()
+
+		if n < size {
+			b := buf
+			if int64(len(b)) > size-n {
+				b = b[:size-n]
+			}
+
+			rn, err := f.ReadAt(b, n)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(b[:rn]); err != nil {
+				return err
+			}
+			n += int64(rn)
+			continue
+		}
+
+		if done {
+			return err
+		}
+	}
+}
+
+// WriteTo writes metrics about transcoding jobs to w in the Prometheus text
+// exposition format.
+func (m *transcodeManager) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cw := &countWriter{w: w}
+	fmt.Fprintln(cw, "# HELP mpdsub_transcode_jobs Number of transcoding jobs by state.")
+	fmt.Fprintln(cw, "# TYPE mpdsub_transcode_jobs gauge")
+	fmt.Fprintf(cw, "mpdsub_transcode_jobs{state=\"queued\"} %d\n", m.queued)
+	fmt.Fprintf(cw, "mpdsub_transcode_jobs{state=\"running\"} %d\n", m.running)
+	fmt.Fprintln(cw, "# HELP mpdsub_transcode_jobs_reused_total Number of requests which joined an in-progress transcoding job.")
+	fmt.Fprintln(cw, "# TYPE mpdsub_transcode_jobs_reused_total counter")
+	fmt.Fprintf(cw, "mpdsub_transcode_jobs_reused_total %d\n", m.reused)
+
+	hits, size := m.cache.stats()
+	fmt.Fprintln(cw, "# HELP mpdsub_transcode_cache_hits_total Number of requests served from cached transcoder output.")
+	fmt.Fprintln(cw, "# TYPE mpdsub_transcode_cache_hits_total counter")
+	fmt.Fprintf(cw, "mpdsub_transcode_cache_hits_total %d\n", hits)
+	fmt.Fprintln(cw, "# HELP mpdsub_transcode_cache_bytes Number of bytes of cached transcoder output.")
+	fmt.Fprintln(cw, "# TYPE mpdsub_transcode_cache_bytes gauge")
+	fmt.Fprintf(cw, "mpdsub_transcode_cache_bytes %d\n", size)
+
+	return cw.n, cw.err
+}
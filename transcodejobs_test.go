@@ -0,0 +1,152 @@
+package mpdsub
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_transcodeManagerQueue(t *testing.T) {
+	path := writeTempFile(t, "hello")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	m := newTranscodeManager(1, 0)
+	tc := &Transcoder{Format: "mp3", Command: "cat {path}"}
+
+	// Occupy the only slot so the next job is queued
+	m.slots <- struct{}{}
+
+	var buf bytes.Buffer
+	errC := make(chan error, 1)
+	go func() {
+		errC <- m.transcode(context.Background(), &buf, tc, path, 0, 0)
+	}()
+
+	waitFor(t, func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.queued == 1
+	})
+
+	<-m.slots
+
+	if err := <-errC; err != nil {
+		t.Fatalf("failed to transcode: %v", err)
+	}
+
+	if want, got := "hello", buf.String(); want != got {
+		t.Fatalf("unexpected output:\n- want: %q\n-  got: %q", want, got)
+	}
+}
+
+func Test_transcodeManagerTempFile(t *testing.T) {
+	// Output larger than a single read from the job's file
+	want := strings.Repeat("hello", 20000)
+	path := writeTempFile(t, want)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	tmp, err := ioutil.TempDir("", "mpdsub-test")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	old := os.Getenv("TMPDIR")
+	defer os.Setenv("TMPDIR", old)
+	os.Setenv("TMPDIR", tmp)
+
+	m := newTranscodeManager(0, 0)
+	tc := &Transcoder{Format: "mp3", Command: "cat {path}"}
+
+	var buf bytes.Buffer
+	if err := m.transcode(context.Background(), &buf, tc, path, 0, 0); err != nil {
+		t.Fatalf("failed to transcode: %v", err)
+	}
+
+	if got := buf.String(); want != got {
+		t.Fatalf("unexpected output length:\n- want: %v\n-  got: %v", len(want), len(got))
+	}
+
+	// The job's output file is removed once its only reader leaves
+	waitFor(t, func() bool {
+		files, err := ioutil.ReadDir(tmp)
+		return err == nil && len(files) == 0
+	})
+}
+
+func Test_transcodeManagerReuse(t *testing.T) {
+	path := writeTempFile(t, "hello")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	m := newTranscodeManager(0, 0)
+
+	// Delay output so both requests join the same job
+	tc := &Transcoder{Format: "mp3", Command: `sh -c "sleep 0.5; cat $0" {path}`}
+
+	const n = 2
+	bufs := make([]bytes.Buffer, n)
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			if err := m.transcode(context.Background(), &bufs[i], tc, path, 0, 0); err != nil {
+				t.Errorf("failed to transcode: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, b := range bufs {
+		if want, got := "hello", b.String(); want != got {
+			t.Fatalf("unexpected output:\n- want: %q\n-  got: %q", want, got)
+		}
+	}
+
+	if want, got := uint64(1), m.reused; want != got {
+		t.Fatalf("unexpected number of reused jobs:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	var metrics bytes.Buffer
+	if _, err := m.WriteTo(&metrics); err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+
+	if want := "mpdsub_transcode_jobs_reused_total 1\n"; !strings.Contains(metrics.String(), want) {
+		t.Fatalf("metrics do not contain %q:\n%s", want, metrics.String())
+	}
+}
+
+// writeTempFile writes s to a file in a new temporary directory.
+func writeTempFile(t *testing.T, s string) string {
+	dir, err := ioutil.TempDir("", "mpdsub-test")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	return path
+}
+
+// waitFor polls fn until it returns true, or fails the test after a timeout.
+func waitFor(t *testing.T, fn func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
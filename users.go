@@ -0,0 +1,865 @@
+package mpdsub
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userKeySize is the size of the AES-256 key which encrypts the passwords of
+// users.
+const userKeySize = 32
+
+var (
+	// errUserExists is returned when adding a user whose name is taken.
+	errUserExists = errors.New("user already exists")
+
+	// errNoUser is returned when modifying a user which does not exist.
+	errNoUser = errors.New("user does not exist")
+
+	// errConfiguredUser is returned when modifying the user defined by
+	// SubsonicUser and SubsonicPassword, which only the configuration
+	// may change.
+	errConfiguredUser = errors.New("user is defined in configuration and cannot be modified")
+
+	// errInvalidUserName is returned when adding a user whose name cannot
+	// be used.
+	errInvalidUserName = errors.New("user name must not be empty, begin with a dot, or contain separators")
+
+	// errEmptyPassword is returned when adding a user without a password.
+	errEmptyPassword = errors.New("password must not be empty")
+
+	// errInvalidFolder is returned when restricting a user to a folder
+	// which cannot be identified.
+	errInvalidFolder = errors.New("folders must be music folder IDs or paths within the music directory")
+)
+
+// A user is an account which may authenticate to the Server.
+type user struct {
+	Name     string
+	Password string
+	Email    string
+	Roles    userRoles
+
+	// Folders optionally restricts the user to directories within MPD's
+	// music directory.  If empty, the user is not restricted.
+	Folders []string
+
+	// Configured is true for the user defined by SubsonicUser and
+	// SubsonicPassword, which is not kept in the user store.
+	Configured bool
+}
+
+// userRoles are the Subsonic roles of a user, which determine the operations
+// the user may perform.
+type userRoles struct {
+	Admin    bool `json:"admin,omitempty"`
+	Settings bool `json:"settings,omitempty"`
+	Stream   bool `json:"stream,omitempty"`
+	Download bool `json:"download,omitempty"`
+	Jukebox  bool `json:"jukebox,omitempty"`
+	Playlist bool `json:"playlist,omitempty"`
+	CoverArt bool `json:"coverArt,omitempty"`
+}
+
+// allRoles are the roles of the user defined by SubsonicUser.
+var allRoles = userRoles{
+	Admin:    true,
+	Settings: true,
+	Stream:   true,
+	Download: true,
+	Jukebox:  true,
+	Playlist: true,
+	CoverArt: true,
+}
+
+// A userAccount is a user kept in the user store.
+type userAccount struct {
+	// Password is the user's password, encrypted with the key from
+	// Config.UserKeyFile or the store's UserKey.  Subsonic's token
+	// authentication hashes the password with a salt chosen by the client,
+	// so the password itself must be kept rather than a hash of it.
+	Password []byte    `json:"password"`
+	Email    string    `json:"email,omitempty"`
+	Roles    userRoles `json:"roles"`
+	Folders  []string  `json:"folders,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// requireRole wraps a handler for an endpoint which is restricted to users
+// with the role reported by role, such as adminRole.
+//
+// Only the roles of the user are checked, since endpoints which are limited
+// by the Server's configuration check those limits themselves.
+func (s *Server) requireRole(role func(r userRoles) bool, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := s.lookupUser(requestContextFrom(r).User)
+		if !ok || !role(u.Roles) {
+			writeXML(w, errNotAuthorized)
+			return
+		}
+
+		fn(w, r)
+	}
+}
+
+// Roles which may be required by requireRole.
+func adminRole(r userRoles) bool    { return r.Admin }
+func streamRole(r userRoles) bool   { return r.Stream }
+func jukeboxRole(r userRoles) bool  { return r.Jukebox }
+func playlistRole(r userRoles) bool { return r.Playlist }
+
+// getUser is used in Subsonic to retrieve the roles of a user, so that
+// clients only offer the features which the user may use.  If no username
+// parameter is specified, the requesting user is retrieved.  Only users
+// with the admin role may retrieve other users.
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	caller := requestContextFrom(r).User
+
+	name := r.URL.Query().Get("username")
+	if name == "" {
+		name = caller
+	}
+
+	if name != caller {
+		c, ok := s.lookupUser(caller)
+		if !ok || !c.Roles.Admin {
+			writeXML(w, errNotAuthorized)
+			return
+		}
+	}
+
+	u, ok := s.lookupUser(name)
+	if !ok {
+		writeXML(w, errNotFound)
+		return
+	}
+
+	uc, err := s.userContainer(u)
+	if err != nil {
+		s.logf("error listing music folders from mpd for getting user: %v", err)
+		writeXML(w, errMPD(err))
+		return
+	}
+
+	writeXML(w, func(c *container) {
+		c.User = &uc
+	})
+}
+
+// getUsers is used in Subsonic to retrieve all users and their roles.
+func (s *Server) getUsers(w http.ResponseWriter, r *http.Request) {
+	users := s.listUsers()
+
+	out := make([]userContainer, 0, len(users))
+	for _, u := range users {
+		uc, err := s.userContainer(u)
+		if err != nil {
+			s.logf("error listing music folders from mpd for getting users: %v", err)
+			writeXML(w, errMPD(err))
+			return
+		}
+
+		out = append(out, uc)
+	}
+
+	writeXML(w, func(c *container) {
+		c.Users = &usersContainer{Users: out}
+	})
+}
+
+// createUser is used in Subsonic to add a user.  Roles which are not
+// specified default to those of Subsonic: only the settings and stream
+// roles.
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	name := q.Get("username")
+	password := decodePassword(q.Get("password"))
+	if name == "" || password == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	u := user{
+		Name:     name,
+		Password: password,
+		Email:    q.Get("email"),
+		Roles: userRoles{
+			Settings: true,
+			Stream:   true,
+		},
+	}
+	if !parseRoles(q, &u.Roles) {
+		writeXML(w, errGeneric)
+		return
+	}
+
+	folders, _, err := s.parseFolders(q)
+	if err != nil {
+		s.writeUserResult(w, "creating", err)
+		return
+	}
+	u.Folders = folders
+
+	s.writeUserResult(w, "creating", s.addUser(u))
+}
+
+// updateUser is used in Subsonic to modify a user's password, email
+// address, roles, or folders.  Only the specified parameters are changed.
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	name := q.Get("username")
+	if name == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	// Check the roles before applying them to the user's current roles
+	if !parseRoles(q, &userRoles{}) {
+		writeXML(w, errGeneric)
+		return
+	}
+
+	folders, setFolders, err := s.parseFolders(q)
+	if err != nil {
+		s.writeUserResult(w, "updating", err)
+		return
+	}
+
+	err = s.modifyUser(name, func(u *user) {
+		if p := decodePassword(q.Get("password")); p != "" {
+			u.Password = p
+		}
+		if _, ok := q["email"]; ok {
+			u.Email = q.Get("email")
+		}
+		parseRoles(q, &u.Roles)
+		if setFolders {
+			u.Folders = folders
+		}
+	})
+
+	s.writeUserResult(w, "updating", err)
+}
+
+// deleteUser is used in Subsonic to remove a user.
+func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("username")
+	if name == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	s.writeUserResult(w, "deleting", s.removeUser(name))
+}
+
+// changePassword is used in Subsonic to change a user's password.  Users
+// with the settings role may change their own password, and users with the
+// admin role may change any user's password.  Like other passwords in the
+// user store, the new password is kept in a recoverable form rather than
+// hashed, since token authentication requires it.
+func (s *Server) changePassword(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	name := q.Get("username")
+	password := decodePassword(q.Get("password"))
+	if name == "" || password == "" {
+		writeXML(w, errMissingParameter)
+		return
+	}
+
+	caller, ok := s.lookupUser(requestContextFrom(r).User)
+	if !ok {
+		writeXML(w, errNotAuthorized)
+		return
+	}
+
+	roles := s.effectiveRoles(caller)
+	if !roles.Admin && (name != caller.Name || !roles.Settings) {
+		writeXML(w, errNotAuthorized)
+		return
+	}
+
+	err := s.modifyUser(name, func(u *user) {
+		u.Password = password
+	})
+
+	s.writeUserResult(w, "changing password of", err)
+}
+
+// writeUserResult writes the response to a request which modifies the user
+// store, using the error returned by the modification.
+func (s *Server) writeUserResult(w http.ResponseWriter, action string, err error) {
+	switch err {
+	case nil:
+		writeXML(w, nil)
+	case errNoUser:
+		writeXML(w, errNotFound)
+	case errConfiguredUser:
+		writeXML(w, errNotAuthorized)
+	case errUserExists, errInvalidUserName, errEmptyPassword, errInvalidFolder:
+		writeXML(w, errInvalidUser(err))
+	default:
+		s.logf("error %s user: %v", action, err)
+		writeXML(w, errGeneric)
+	}
+}
+
+// errInvalidUser indicates that a user could not be added or modified.
+func errInvalidUser(err error) func(c *container) {
+	return func(c *container) {
+		c.Status = statusFailed
+		c.Error = &subsonicError{
+			Code:    codeGeneric,
+			Message: "Invalid user: " + err.Error() + ".",
+		}
+	}
+}
+
+// parseRoles applies the role parameters specified in q to roles.  If a
+// parameter is not a boolean, it returns false.
+func parseRoles(q url.Values, roles *userRoles) bool {
+	params := []struct {
+		name string
+		role *bool
+	}{
+		{name: "adminRole", role: &roles.Admin},
+		{name: "settingsRole", role: &roles.Settings},
+		{name: "streamRole", role: &roles.Stream},
+		{name: "downloadRole", role: &roles.Download},
+		{name: "jukeboxRole", role: &roles.Jukebox},
+		{name: "playlistRole", role: &roles.Playlist},
+		{name: "coverArtRole", role: &roles.CoverArt},
+	}
+
+	for _, p := range params {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false
+		}
+		*p.role = b
+	}
+
+	return true
+}
+
+// parseFolders parses the folders to which a user is restricted from the
+// musicFolderId parameters in q, which identify top-level folders, and the
+// folder parameters, which are paths of directories within MPD's music
+// directory.  It reports whether any folders were specified.  Specifying the
+// library's music folder or the music directory itself removes all
+// restrictions.
+func (s *Server) parseFolders(q url.Values) ([]string, bool, error) {
+	ids, paths := q["musicFolderId"], q["folder"]
+	if len(ids) == 0 && len(paths) == 0 {
+		return nil, false, nil
+	}
+
+	var folders []string
+	if len(ids) > 0 {
+		fs, err := s.db.List("file")
+		if err != nil {
+			return nil, false, err
+		}
+
+		names := make(map[int]string)
+		for _, f := range indexFiles(fs) {
+			if f.Dir && !strings.Contains(f.Name, "/") {
+				names[topLevelFolderID(f.Name)] = f.Name
+			}
+		}
+
+		for _, v := range ids {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, false, errInvalidFolder
+			}
+			if id == musicFolderLibrary {
+				return nil, true, nil
+			}
+
+			name, ok := names[id]
+			if !ok {
+				return nil, false, errInvalidFolder
+			}
+			folders = append(folders, name)
+		}
+	}
+
+	for _, p := range paths {
+		p = path.Clean(strings.Trim(p, "/"))
+		if p == "." {
+			return nil, true, nil
+		}
+		if p == ".." || strings.HasPrefix(p, "../") {
+			return nil, false, errInvalidFolder
+		}
+
+		folders = append(folders, p)
+	}
+
+	return folders, true, nil
+}
+
+// inUserFolders reports whether the file or directory with the specified
+// name is within the folders to which user is restricted.  Directories which
+// contain one of the folders are also permitted, so that clients can browse
+// to the folder.
+func (s *Server) inUserFolders(user string, name string) bool {
+	// Servers created without a store have no stored users
+	if s.store == nil {
+		return true
+	}
+
+	var folders []string
+	s.store.View(func(d *storeData) {
+		folders = d.Users[user].Folders
+	})
+
+	if len(folders) == 0 {
+		return true
+	}
+
+	for _, f := range folders {
+		if s.withinDir(name, f) || s.withinDir(f, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withinDir reports whether the file or directory with the specified name is
+// dir or is within dir, according to CaseInsensitivePaths.
+func (s *Server) withinDir(name string, dir string) bool {
+	if len(name) < len(dir) {
+		return false
+	}
+
+	prefix := name[:len(dir)]
+	if prefix != dir && !(s.cfg.CaseInsensitivePaths && strings.EqualFold(prefix, dir)) {
+		return false
+	}
+
+	return len(name) == len(dir) || name[len(dir)] == '/'
+}
+
+// userContainer produces the Subsonic representation of u, including the
+// music folders which u may access.
+func (s *Server) userContainer(u user) (userContainer, error) {
+	folders, err := s.musicFolders(u.Name)
+	if err != nil {
+		return userContainer{}, err
+	}
+
+	ids := make([]int, 0, len(folders))
+	for _, f := range folders {
+		ids = append(ids, f.ID)
+	}
+
+	roles := s.effectiveRoles(u)
+
+	return userContainer{
+		Username:          u.Name,
+		Email:             u.Email,
+		ScrobblingEnabled: true,
+		AdminRole:         roles.Admin,
+		SettingsRole:      roles.Settings,
+		StreamRole:        roles.Stream,
+		DownloadRole:      roles.Download,
+		JukeboxRole:       roles.Jukebox,
+		PlaylistRole:      roles.Playlist,
+		CoverArtRole:      roles.CoverArt,
+		Folders:           ids,
+	}, nil
+}
+
+// effectiveRoles returns the roles of u which the Server's configuration
+// permits: only users in DownloadUsers may download files, the jukebox
+// requires a database which can control playback, and a read-only Server
+// does not permit changing settings, playlists, or the jukebox.
+func (s *Server) effectiveRoles(u user) userRoles {
+	roles := u.Roles
+
+	if !s.canDownload(u.Name) {
+		roles.Download = false
+	}
+	if s.player == nil {
+		roles.Jukebox = false
+	}
+	if s.cfg.ReadOnly {
+		roles.Settings = false
+		roles.Playlist = false
+		roles.Jukebox = false
+	}
+
+	return roles
+}
+
+// validUserName reports whether name may be used as the name of a user.
+// Names are used in playlist namespaces and file names, so they may not
+// contain separators.
+func validUserName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `:/\`)
+}
+
+// lookupUser returns the user with the specified name: either the user
+// defined by SubsonicUser, or a user in the user store.
+func (s *Server) lookupUser(name string) (user, bool) {
+	if name == "" {
+		return user{}, false
+	}
+	if name == s.cfg.SubsonicUser {
+		return s.configuredUser(), true
+	}
+
+	var (
+		u   user
+		ok  bool
+		err error
+	)
+	s.store.View(func(d *storeData) {
+		var a userAccount
+		if a, ok = d.Users[name]; ok {
+			u, err = a.user(name, s.passwordKey(d))
+		}
+	})
+	if err != nil {
+		s.logf("error decrypting password of user %q: %v", name, err)
+		return user{}, false
+	}
+
+	return u, ok
+}
+
+// listUsers returns all users, ordered by name.
+func (s *Server) listUsers() []user {
+	var users []user
+	if s.cfg.SubsonicUser != "" {
+		users = append(users, s.configuredUser())
+	}
+
+	s.store.View(func(d *storeData) {
+		for name, a := range d.Users {
+			u, err := a.user(name, s.passwordKey(d))
+			if err != nil {
+				s.logf("error decrypting password of user %q: %v", name, err)
+				continue
+			}
+
+			users = append(users, u)
+		}
+	})
+
+	sort.Sort(byUserName(users))
+	return users
+}
+
+// configuredUser returns the user defined by SubsonicUser and
+// SubsonicPassword.
+func (s *Server) configuredUser() user {
+	return user{
+		Name:       s.cfg.SubsonicUser,
+		Password:   s.cfg.SubsonicPassword,
+		Roles:      allRoles,
+		Configured: true,
+	}
+}
+
+// addUser adds a user to the user store.
+func (s *Server) addUser(u user) error {
+	if !validUserName(u.Name) {
+		return errInvalidUserName
+	}
+	if u.Password == "" {
+		return errEmptyPassword
+	}
+	if u.Name == s.cfg.SubsonicUser {
+		return errUserExists
+	}
+
+	return s.store.Update(func(d *storeData) error {
+		if _, ok := d.Users[u.Name]; ok {
+			return errUserExists
+		}
+
+		// The key is only generated once it is needed, so that servers
+		// with a single user do not write it to the store
+		if s.userKey == nil && len(d.UserKey) == 0 {
+			d.UserKey = make([]byte, userKeySize)
+			if _, err := rand.Read(d.UserKey); err != nil {
+				return err
+			}
+		}
+
+		pw, err := sealPassword(s.passwordKey(d), u.Password)
+		if err != nil {
+			return err
+		}
+
+		d.Users[u.Name] = userAccount{
+			Password: pw,
+			Email:    u.Email,
+			Roles:    u.Roles,
+			Folders:  u.Folders,
+			Created:  time.Now(),
+		}
+		return nil
+	})
+}
+
+// modifyUser updates the user with the specified name in the user store,
+// by applying fn to the user.  The user's name may not be changed.  If the
+// user's password is changed, the user's sessions and stream tokens are
+// revoked.
+func (s *Server) modifyUser(name string, fn func(u *user)) error {
+	if name == s.cfg.SubsonicUser {
+		return errConfiguredUser
+	}
+
+	var changed bool
+	err := s.store.Update(func(d *storeData) error {
+		a, ok := d.Users[name]
+		if !ok {
+			return errNoUser
+		}
+
+		u, err := a.user(name, s.passwordKey(d))
+		if err != nil {
+			return err
+		}
+
+		old := u.Password
+		fn(&u)
+		if u.Password == "" {
+			return errEmptyPassword
+		}
+		changed = u.Password != old
+
+		pw, err := sealPassword(s.passwordKey(d), u.Password)
+		if err != nil {
+			return err
+		}
+
+		a.Password = pw
+		a.Email = u.Email
+		a.Roles = u.Roles
+		a.Folders = u.Folders
+
+		d.Users[name] = a
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		s.revokeUser(name)
+	}
+
+	return nil
+}
+
+// removeUser removes a user from the user store, along with the user's
+// history, stars, play statistics, saved play queue, sessions, and stream
+// tokens.
+func (s *Server) removeUser(name string) error {
+	if name == s.cfg.SubsonicUser {
+		return errConfiguredUser
+	}
+
+	err := s.store.Update(func(d *storeData) error {
+		if _, ok := d.Users[name]; !ok {
+			return errNoUser
+		}
+
+		delete(d.Users, name)
+		delete(d.History, name)
+		delete(d.Stars, name)
+		delete(d.Plays, name)
+		delete(d.PlayQueues, name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.revokeUser(name)
+	return nil
+}
+
+// revokeUser ends the sessions of the user with the specified name, and
+// invalidates the user's stream tokens, so that they cannot be used once the
+// user's password has changed or the user has been removed.
+func (s *Server) revokeUser(name string) {
+	s.sessions.revoke(name)
+	s.streamTokens.revoke(name)
+}
+
+// user returns the user with the specified name stored in a, decrypting its
+// password using key.
+func (a userAccount) user(name string, key []byte) (user, error) {
+	pw, err := openPassword(key, a.Password)
+	if err != nil {
+		return user{}, err
+	}
+
+	return user{
+		Name:     name,
+		Password: pw,
+		Email:    a.Email,
+		Roles:    a.Roles,
+		Folders:  a.Folders,
+	}, nil
+}
+
+// sealPassword encrypts a password with key using AES-GCM, prefixing the
+// result with its random nonce.
+//
+// Passwords are kept in a recoverable form rather than hashed, unlike in
+// most systems, since Subsonic's token authentication sends
+// md5(password + salt) with a salt chosen by the client for each request,
+// which can only be verified using the password itself.  The encryption
+// only protects the passwords if the key is kept apart from them, using
+// Config.UserKeyFile.  Otherwise the key is stored in the same state file,
+// and the passwords are effectively stored in plaintext.
+func sealPassword(key []byte, password string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, []byte(password), nil), nil
+}
+
+// passwordKey returns the key which encrypts the passwords of users in d.
+func (s *Server) passwordKey(d *storeData) []byte {
+	if s.userKey != nil {
+		return s.userKey
+	}
+
+	return d.UserKey
+}
+
+// openUserKey reads the key which encrypts the passwords of users from the
+// file at path, creating the file with a new key if it does not exist.  A
+// key kept in the store by an earlier version of the Server is moved to the
+// file, so that existing passwords can still be decrypted.  If path is
+// empty, nil is returned, and the key is kept in the store.
+func openUserKey(path string, st *store) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	var key []byte
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		key, err = hex.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil || len(key) != userKeySize {
+			return nil, fmt.Errorf("user key file %q must contain a %d byte hex-encoded key", path, userKeySize)
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	var stored []byte
+	st.View(func(d *storeData) {
+		stored = d.UserKey
+	})
+
+	switch {
+	case len(stored) > 0 && key == nil:
+		key = stored
+	case len(stored) > 0 && !bytes.Equal(stored, key):
+		return nil, fmt.Errorf("user key file %q does not match the key in the state file", path)
+	case key == nil:
+		key = make([]byte, userKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(b) == 0 {
+		if err := writeFileAtomic(path, []byte(hex.EncodeToString(key)+"\n")); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(stored) == 0 {
+		return key, nil
+	}
+
+	// The key is only removed from the store once it has been written to
+	// the file, so that it cannot be lost
+	err = st.Update(func(d *storeData) error {
+		d.UserKey = nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// openPassword decrypts a password encrypted by sealPassword.
+func openPassword(key []byte, b []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(b) < gcm.NonceSize() {
+		return "", errors.New("encrypted password is too short")
+	}
+
+	pw, err := gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pw), nil
+}
+
+// newGCM creates an AES-GCM cipher using key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// byUserName sorts users by their names.
+type byUserName []user
+
+func (b byUserName) Len() int           { return len(b) }
+func (b byUserName) Less(i, j int) bool { return b[i].Name < b[j].Name }
+func (b byUserName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,859 @@
+package mpdsub
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerUsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-users")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, _ := configAuth()
+	cfg.StateFile = filepath.Join(dir, "state.json")
+
+	s, err := newServer(nil, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	alice := user{Name: "alice", Password: "secret", Email: "alice@example.com"}
+	if err := s.addUser(alice); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		u    user
+		err  error
+	}{
+		{name: "exists", u: user{Name: "alice", Password: "x"}, err: errUserExists},
+		{name: "configured", u: user{Name: "test", Password: "x"}, err: errUserExists},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if want, got := tt.err, s.addUser(tt.u); want != got {
+				t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+
+	for _, name := range []string{"", ".alice", "a:b", "a/b", `a\b`} {
+		if err := s.addUser(user{Name: name, Password: "x"}); err == nil {
+			t.Fatalf("expected error adding user %q, but none occurred", name)
+		}
+	}
+
+	if want, got := errConfiguredUser, s.removeUser("test"); want != got {
+		t.Fatalf("unexpected error removing configured user:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := errNoUser, s.modifyUser("bob", func(*user) {}); want != got {
+		t.Fatalf("unexpected error updating missing user:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Passwords must not be stored in plain text
+	b, err := ioutil.ReadFile(cfg.StateFile)
+	if err != nil {
+		t.Fatalf("failed to read state file: %v", err)
+	}
+	if bytes.Contains(b, []byte(alice.Password)) {
+		t.Fatal("state file contains password in plain text")
+	}
+
+	// Users persist in the state file across restarts
+	s, err = newServer(nil, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	want := []user{alice, s.configuredUser()}
+	if got := s.listUsers(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected users:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Passwords are unchanged when updating other settings
+	err = s.modifyUser("alice", func(u *user) {
+		u.Email = "a@example.com"
+	})
+	if err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+	u, ok := s.lookupUser("alice")
+	if !ok {
+		t.Fatal("user not found after update")
+	}
+	if want, got := (user{Name: "alice", Password: "secret", Email: "a@example.com"}), u; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected user:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	if err := s.removeUser("alice"); err != nil {
+		t.Fatalf("failed to remove user: %v", err)
+	}
+	if _, ok := s.lookupUser("alice"); ok {
+		t.Fatal("user found after removal")
+	}
+}
+
+func TestServerStoredUserAuthentication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-users")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, values := configAuth()
+	cfg.StateFile = filepath.Join(dir, "state.json")
+
+	s, err := newServer(nil, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if err := s.addUser(user{Name: "alice", Password: "secret"}); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	sum := md5.Sum([]byte("secret" + "salt"))
+	token := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		token    string
+		ok       bool
+	}{
+		{name: "password", user: "alice", password: "secret", ok: true},
+		{name: "token", user: "alice", token: token, ok: true},
+		{name: "bad password", user: "alice", password: "test"},
+		{name: "configured password", user: "test", password: "secret"},
+		{name: "unknown user", user: "bob", password: "secret"},
+	}
+
+	withServer(t, nil, nil, cfg, func(base string) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				v := make(url.Values, len(values))
+				for k, vs := range values {
+					v[k] = vs
+				}
+				delete(v, "p")
+
+				v["u"] = []string{tt.user}
+				if tt.password != "" {
+					v["p"] = []string{tt.password}
+				} else {
+					v["t"] = []string{tt.token}
+					v["s"] = []string{"salt"}
+				}
+
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/ping.view", v))
+				if want, got := tt.ok, c.Error == nil; want != got {
+					t.Fatalf("unexpected authentication result:\n- want: %v\n-  got: %v (%+v)", want, got, c.Error)
+				}
+			})
+		}
+	})
+}
+
+func TestServerUserKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-users")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, _ := configAuth()
+	cfg.StateFile = filepath.Join(dir, "state.json")
+
+	// Users added before a key file is configured keep the key in the store
+	s, err := newServer(nil, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if err := s.addUser(user{Name: "alice", Password: "secret"}); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	s.Close()
+
+	mustPassword := func(s *Server) {
+		t.Helper()
+
+		u, ok := s.lookupUser("alice")
+		if !ok {
+			t.Fatal("user alice not found")
+		}
+		if want, got := "secret", u.Password; want != got {
+			t.Fatalf("unexpected password:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+
+	// The key is moved out of the store into the key file, which is
+	// created with the existing key
+	cfg.UserKeyFile = filepath.Join(dir, "user.key")
+	for i := 0; i < 2; i++ {
+		s, err := newServer(nil, nil, cfg)
+		if err != nil {
+			t.Fatalf("failed to create server: %v", err)
+		}
+		mustPassword(s)
+		s.Close()
+
+		b, err := ioutil.ReadFile(cfg.StateFile)
+		if err != nil {
+			t.Fatalf("failed to read state file: %v", err)
+		}
+		if bytes.Contains(b, []byte("userKey")) {
+			t.Fatalf("state file still contains the user key:\n%s", b)
+		}
+	}
+
+	// A key file which does not match a key in the store is rejected,
+	// rather than leaving the stored passwords unreadable
+	keyFile := cfg.UserKeyFile
+	cfg.StateFile = filepath.Join(dir, "other.json")
+	cfg.UserKeyFile = ""
+
+	s, err = newServer(nil, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if err := s.addUser(user{Name: "bob", Password: "secret"}); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	s.Close()
+
+	cfg.UserKeyFile = keyFile
+	if _, err := newServer(nil, nil, cfg); err == nil {
+		t.Fatal("expected an error for a mismatched key file")
+	}
+
+	cfg.UserKeyFile = filepath.Join(dir, "bad.key")
+	if err := ioutil.WriteFile(cfg.UserKeyFile, []byte("test\n"), 0600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	if _, err := newServer(nil, nil, cfg); err == nil {
+		t.Fatal("expected an error for a malformed key file")
+	}
+}
+
+func TestServerUserAdministration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-users")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, values := configAuth()
+	cfg.StateFile = filepath.Join(dir, "state.json")
+
+	// request performs a request as the specified user, returning the
+	// error code or -1 if none occurred
+	request := func(base, target, name, password string, params url.Values) (container, int) {
+		v := make(url.Values)
+		for k, vs := range values {
+			v[k] = vs
+		}
+		for k, vs := range params {
+			v[k] = vs
+		}
+		v.Set("u", name)
+		v.Set("p", password)
+
+		c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, target, v))
+		if c.Error != nil {
+			return c, c.Error.Code
+		}
+
+		return c, -1
+	}
+
+	withServer(t, nil, nil, cfg, func(base string) {
+		params := url.Values{
+			"username":     []string{"bob"},
+			"password":     []string{"enc:73656372657431"},
+			"email":        []string{"bob@example.com"},
+			"downloadRole": []string{"true"},
+		}
+		if _, code := request(base, "/rest/createUser.view", "test", "test", params); code != -1 {
+			t.Fatalf("failed to create user: %d", code)
+		}
+		if want, got := codeGeneric, second(request(base, "/rest/createUser.view", "test", "test", params)); want != got {
+			t.Fatalf("unexpected error code creating duplicate user:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		// Only administrators may manage users
+		if want, got := codeNotAuthorized, second(request(base, "/rest/getUsers.view", "bob", "secret1", nil)); want != got {
+			t.Fatalf("unexpected error code for non-administrator:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		c, code := request(base, "/rest/getUsers.view", "test", "test", nil)
+		if code != -1 {
+			t.Fatalf("failed to get users: %d", code)
+		}
+
+		want := []userContainer{
+			{
+				XMLName:           xml.Name{Space: xmlNS, Local: "user"},
+				Username:          "bob",
+				Email:             "bob@example.com",
+				ScrobblingEnabled: true,
+				SettingsRole:      true,
+				StreamRole:        true,
+				DownloadRole:      true,
+				Folders:           []int{musicFolderLibrary},
+			},
+			{
+				XMLName:           xml.Name{Space: xmlNS, Local: "user"},
+				Username:          "test",
+				ScrobblingEnabled: true,
+				AdminRole:         true,
+				SettingsRole:      true,
+				StreamRole:        true,
+				DownloadRole:      true,
+				PlaylistRole:      true,
+				CoverArtRole:      true,
+				Folders:           []int{musicFolderLibrary},
+			},
+		}
+		if got := c.Users.Users; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected users:\n- want: %+v\n-  got: %+v", want, got)
+		}
+
+		params = url.Values{
+			"username":  []string{"bob"},
+			"password":  []string{"secret2"},
+			"adminRole": []string{"true"},
+		}
+		if _, code := request(base, "/rest/updateUser.view", "test", "test", params); code != -1 {
+			t.Fatalf("failed to update user: %d", code)
+		}
+		if want, got := codeUnauthorized, second(request(base, "/rest/ping.view", "bob", "secret1", nil)); want != got {
+			t.Fatalf("unexpected error code for old password:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		// The updated user is now an administrator
+		if _, code := request(base, "/rest/getUsers.view", "bob", "secret2", nil); code != -1 {
+			t.Fatalf("failed to get users as updated user: %d", code)
+		}
+
+		params = url.Values{"username": []string{"test"}}
+		if want, got := codeNotAuthorized, second(request(base, "/rest/deleteUser.view", "bob", "secret2", params)); want != got {
+			t.Fatalf("unexpected error code deleting configured user:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		params = url.Values{"username": []string{"bob"}}
+		if _, code := request(base, "/rest/deleteUser.view", "test", "test", params); code != -1 {
+			t.Fatalf("failed to delete user: %d", code)
+		}
+		if want, got := codeNotFound, second(request(base, "/rest/deleteUser.view", "test", "test", params)); want != got {
+			t.Fatalf("unexpected error code deleting missing user:\n- want: %v\n-  got: %v", want, got)
+		}
+	})
+}
+
+func TestServer_getUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-users")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, values := configAuth()
+	cfg.StateFile = filepath.Join(dir, "state.json")
+
+	// bob's download role is revoked by DownloadUsers
+	cfg.DownloadUsers = []string{"test"}
+
+	s, err := newServer(nil, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	bob := user{
+		Name:     "bob",
+		Password: "secret",
+		Roles:    userRoles{Stream: true, Download: true},
+	}
+	if err := s.addUser(bob); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		username string
+		want     *userContainer
+		code     int
+	}{
+		{
+			name:     "own roles",
+			user:     "bob",
+			password: "secret",
+			username: "bob",
+			want: &userContainer{
+				XMLName:           xml.Name{Space: xmlNS, Local: "user"},
+				Username:          "bob",
+				ScrobblingEnabled: true,
+				StreamRole:        true,
+				Folders:           []int{musicFolderLibrary},
+			},
+		},
+		{
+			name:     "requesting user",
+			user:     "bob",
+			password: "secret",
+			want: &userContainer{
+				XMLName:           xml.Name{Space: xmlNS, Local: "user"},
+				Username:          "bob",
+				ScrobblingEnabled: true,
+				StreamRole:        true,
+				Folders:           []int{musicFolderLibrary},
+			},
+		},
+		{
+			name:     "other user",
+			user:     "bob",
+			password: "secret",
+			username: "test",
+			code:     codeNotAuthorized,
+		},
+		{
+			name:     "administrator",
+			user:     "test",
+			password: "test",
+			username: "bob",
+			want: &userContainer{
+				XMLName:           xml.Name{Space: xmlNS, Local: "user"},
+				Username:          "bob",
+				ScrobblingEnabled: true,
+				StreamRole:        true,
+				Folders:           []int{musicFolderLibrary},
+			},
+		},
+		{
+			name:     "not found",
+			user:     "test",
+			password: "test",
+			username: "alice",
+			code:     codeNotFound,
+		},
+	}
+
+	withServer(t, nil, nil, cfg, func(base string) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				v := make(url.Values)
+				for k, vs := range values {
+					v[k] = vs
+				}
+				v.Set("u", tt.user)
+				v.Set("p", tt.password)
+				if tt.username != "" {
+					v.Set("username", tt.username)
+				}
+
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/getUser.view", v))
+				if tt.want == nil {
+					if c.Error == nil || c.Error.Code != tt.code {
+						t.Fatalf("unexpected error:\n- want: %v\n-  got: %+v", tt.code, c.Error)
+					}
+					return
+				}
+
+				if want, got := tt.want, c.User; !reflect.DeepEqual(want, got) {
+					t.Fatalf("unexpected user:\n- want: %+v\n-  got: %+v", want, got)
+				}
+			})
+		}
+	})
+}
+
+func TestServer_changePassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-users")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, values := configAuth()
+	cfg.StateFile = filepath.Join(dir, "state.json")
+
+	s, err := newServer(nil, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	for _, u := range []user{
+		{Name: "bob", Password: "bob", Roles: userRoles{Settings: true}},
+		{Name: "carol", Password: "carol"},
+	} {
+		if err := s.addUser(u); err != nil {
+			t.Fatalf("failed to add user: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		username string
+		code     int
+	}{
+		{name: "own password", user: "bob", password: "bob", username: "bob", code: -1},
+		{name: "other user", user: "bob", password: "changed", username: "carol", code: codeNotAuthorized},
+		{name: "no settings role", user: "carol", password: "carol", username: "carol", code: codeNotAuthorized},
+		{name: "administrator", user: "test", password: "test", username: "carol", code: -1},
+		{name: "configured user", user: "test", password: "test", username: "test", code: codeNotAuthorized},
+		{name: "not found", user: "test", password: "test", username: "dave", code: codeNotFound},
+	}
+
+	withServer(t, nil, nil, cfg, func(base string) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				v := make(url.Values)
+				for k, vs := range values {
+					v[k] = vs
+				}
+				v.Set("u", tt.user)
+				v.Set("p", tt.password)
+				v.Set("username", tt.username)
+				v.Set("password", "enc:6368616e676564")
+
+				c := mustDecodeXML(t, testRequest(t, base, http.MethodGet, "/rest/changePassword.view", v))
+
+				code := -1
+				if c.Error != nil {
+					code = c.Error.Code
+				}
+				if want, got := tt.code, code; want != got {
+					t.Fatalf("unexpected error code:\n- want: %v\n-  got: %v", want, got)
+				}
+			})
+		}
+	})
+
+	// The new passwords persist in the state file
+	s, err = newServer(nil, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	for _, name := range []string{"bob", "carol"} {
+		u, ok := s.lookupUser(name)
+		if !ok {
+			t.Fatalf("user %q not found", name)
+		}
+		if want, got := "changed", u.Password; want != got {
+			t.Fatalf("unexpected password for %q:\n- want: %v\n-  got: %v", name, want, got)
+		}
+	}
+}
+
+func TestServerRoles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-users")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, values := configAuth()
+	cfg.StateFile = filepath.Join(dir, "state.json")
+
+	s, err := newServer(nil, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	for _, u := range []user{
+		{Name: "none", Password: "none"},
+		{Name: "all", Password: "all", Roles: allRoles},
+	} {
+		if err := s.addUser(u); err != nil {
+			t.Fatalf("failed to add user: %v", err)
+		}
+	}
+
+	targets := []string{
+		"/rest/checkMusicDirectory.view",
+		"/rest/createPlaylist.view",
+		"/rest/createStreamToken.view",
+		"/rest/download.view",
+		"/rest/getUsers.view",
+		"/rest/jukeboxControl.view",
+		"/rest/outputControl.view",
+		"/rest/queueSong.view",
+		"/rest/stream.view",
+	}
+
+	withServer(t, nil, nil, cfg, func(base string) {
+		for _, target := range targets {
+			for _, name := range []string{"none", "all"} {
+				v := make(url.Values)
+				for k, vs := range values {
+					v[k] = vs
+				}
+				v.Set("u", name)
+				v.Set("p", name)
+				v.Set("id", "1")
+
+				// Users with the role may fail for other reasons, such as
+				// the song not being found, but must not be rejected for
+				// lack of a role
+				want := name == "none"
+
+				res := testRequest(t, base, http.MethodGet, target, v)
+				if res.StatusCode != http.StatusOK && !want {
+					_ = res.Body.Close()
+					continue
+				}
+
+				c := mustDecodeXML(t, res)
+				if got := c.Error != nil && c.Error.Code == codeNotAuthorized; want != got {
+					t.Fatalf("unexpected authorization for %q at %s:\n- want rejected: %v\n-  got rejected: %v",
+						name, target, want, got)
+				}
+			}
+		}
+	})
+}
+
+func TestServerUserFolders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-users")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := &memoryDatabase{
+		files: []string{
+			"Jazz/a.mp3",
+			"Rock/Beatles/b.mp3",
+			"Rock/Stones/c.mp3",
+		},
+	}
+	fs := &memoryFilesystem{
+		files: map[string]*memoryFile{
+			"Rock/Beatles/b.mp3": {ReadSeeker: strings.NewReader("b")},
+			"Rock/Stones/c.mp3":  {ReadSeeker: strings.NewReader("c")},
+		},
+	}
+
+	list, err := db.List("file")
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	ids := fileIDs(indexFiles(list))
+
+	cfg, values := configAuth()
+	cfg.StateFile = filepath.Join(dir, "state.json")
+	cfg.TopLevelFolders = true
+
+	// request performs a request as the specified user
+	request := func(base, target, name string, params url.Values) *http.Response {
+		v := make(url.Values)
+		for k, vs := range values {
+			v[k] = vs
+		}
+		for k, vs := range params {
+			v[k] = vs
+		}
+		v.Set("u", name)
+		v.Set("p", name)
+
+		return testRequest(t, base, http.MethodGet, target, v)
+	}
+
+	withServer(t, db, fs, cfg, func(base string) {
+		params := url.Values{
+			"username": []string{"alice"},
+			"password": []string{"alice"},
+			"folder":   []string{"/Rock/Beatles/"},
+		}
+		if c := mustDecodeXML(t, request(base, "/rest/createUser.view", "test", params)); c.Error != nil {
+			t.Fatalf("failed to create user: %+v", c.Error)
+		}
+
+		// Only the directories leading to alice's folder are browsable
+		c := mustDecodeXML(t, request(base, "/rest/getMusicFolders.view", "alice", nil))
+		if want, got := []musicFolder{{ID: topLevelFolderID("Rock"), Name: "Rock"}}, c.MusicFolders.MusicFolders; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected music folders:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		c = mustDecodeXML(t, request(base, "/rest/getIndexes.view", "alice", nil))
+		var names []string
+		for _, idx := range c.Indexes.Indexes {
+			for _, a := range idx.Artists {
+				names = append(names, a.Name)
+			}
+		}
+		if want, got := []string{"Beatles"}, names; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected indexes:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		// Songs outside of alice's folder cannot be streamed
+		params = url.Values{"id": []string{strconv.Itoa(ids["Rock/Stones/c.mp3"])}}
+		c = mustDecodeXML(t, request(base, "/rest/stream.view", "alice", params))
+		if c.Error == nil || c.Error.Code != codeNotAuthorized {
+			t.Fatalf("expected not authorized error, but got: %+v", c.Error)
+		}
+
+		params = url.Values{"id": []string{strconv.Itoa(ids["Rock/Beatles/b.mp3"])}}
+		res := request(base, "/rest/stream.view", "alice", params)
+		b, err := ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+		if want, got := "b", string(b); want != got {
+			t.Fatalf("unexpected body:\n- want: %q\n-  got: %q", want, got)
+		}
+
+		params = url.Values{
+			"username":      []string{"alice"},
+			"musicFolderId": []string{strconv.Itoa(topLevelFolderID("Jazz"))},
+		}
+		if c := mustDecodeXML(t, request(base, "/rest/updateUser.view", "test", params)); c.Error != nil {
+			t.Fatalf("failed to update user: %+v", c.Error)
+		}
+
+		c = mustDecodeXML(t, request(base, "/rest/getUser.view", "alice", nil))
+		if want, got := []int{topLevelFolderID("Jazz")}, c.User.Folders; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected user folders:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		for _, p := range []url.Values{
+			{"folder": []string{"../Rock"}},
+			{"musicFolderId": []string{"12345"}},
+		} {
+			p.Set("username", "alice")
+			c := mustDecodeXML(t, request(base, "/rest/updateUser.view", "test", p))
+			if c.Error == nil || c.Error.Code != codeGeneric {
+				t.Fatalf("expected generic error for %v, but got: %+v", p, c.Error)
+			}
+		}
+	})
+}
+
+func TestServer_inUserFolders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-users")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, _ := configAuth()
+	cfg.StateFile = filepath.Join(dir, "state.json")
+	cfg.CaseInsensitivePaths = true
+
+	s, err := newServer(nil, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if err := s.addUser(user{Name: "alice", Password: "alice", Folders: []string{"Rock/Beatles"}}); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	tests := []struct {
+		user string
+		name string
+		ok   bool
+	}{
+		{user: "alice", name: "Rock", ok: true},
+		{user: "alice", name: "Rock/Beatles", ok: true},
+		{user: "alice", name: "rock/beatles/song.mp3", ok: true},
+		{user: "alice", name: "Rock/Beatles2"},
+		{user: "alice", name: "Rock/Stones/song.mp3"},
+		{user: "alice", name: "Jazz"},
+		{user: "test", name: "Jazz", ok: true},
+	}
+
+	for _, tt := range tests {
+		if want, got := tt.ok, s.inUserFolders(tt.user, tt.name); want != got {
+			t.Fatalf("unexpected result for %q in %q:\n- want: %v\n-  got: %v", tt.user, tt.name, want, got)
+		}
+	}
+}
+
+func TestServerUserRevocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpdsub-users")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, _ := configAuth()
+	cfg.StateFile = filepath.Join(dir, "state.json")
+
+	s, err := newServer(nil, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if err := s.addUser(user{Name: "alice", Password: "alice"}); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	now := time.Now()
+
+	// login creates a session and a stream token for alice, and returns
+	// a function which reports whether each remains valid
+	login := func() func() (bool, bool) {
+		id, err := s.sessions.create(session{User: "alice", Expires: now.Add(time.Hour)}, now)
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+		token, err := s.streamTokens.issue(streamToken{ID: "1", User: "alice", Expires: now.Add(time.Hour)}, now)
+		if err != nil {
+			t.Fatalf("failed to issue stream token: %v", err)
+		}
+
+		return func() (bool, bool) {
+			_, sok := s.sessions.touch(id, now, time.Hour)
+			_, tok := s.streamTokens.redeem(token, "1", now)
+			return sok, tok
+		}
+	}
+
+	// Changing other settings does not end alice's sessions
+	valid := login()
+	if err := s.modifyUser("alice", func(u *user) { u.Email = "alice@example.com" }); err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+	if sok, tok := valid(); !sok || !tok {
+		t.Fatalf("session or token revoked without password change: %v, %v", sok, tok)
+	}
+
+	valid = login()
+	if err := s.modifyUser("alice", func(u *user) { u.Password = "changed" }); err != nil {
+		t.Fatalf("failed to change password: %v", err)
+	}
+	if sok, tok := valid(); sok || tok {
+		t.Fatalf("session or token valid after password change: %v, %v", sok, tok)
+	}
+
+	valid = login()
+	if err := s.removeUser("alice"); err != nil {
+		t.Fatalf("failed to remove user: %v", err)
+	}
+	if sok, tok := valid(); sok || tok {
+		t.Fatalf("session or token valid after removal: %v, %v", sok, tok)
+	}
+}
+
+// second returns the second of two values.
+func second(_ container, code int) int {
+	return code
+}
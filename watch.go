@@ -0,0 +1,172 @@
+package mpdsub
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultWatchDelay is the default amount of time to wait for filesystem
+// activity to settle before updating MPD's database.
+const defaultWatchDelay = 5 * time.Second
+
+// startWatcher begins watching the music directory for changes, and issues
+// MPD database updates for the directories which change.
+func (s *Server) startWatcher(ctx context.Context) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// fsnotify does not watch directories recursively, so every directory
+	// must be added individually
+	err = filepath.Walk(s.cfg.MusicDirectory, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+
+		return w.Add(path)
+	})
+	if err != nil {
+		_ = w.Close()
+		return err
+	}
+
+	changes := make(chan string)
+
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		defer w.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-w.Errors:
+				s.logf("error watching music directory: %v", err)
+			case e := <-w.Events:
+				// Watch newly created directories so their contents are
+				// tracked as well
+				if e.Op&fsnotify.Create != 0 {
+					if fi, err := os.Stat(e.Name); err == nil && fi.IsDir() {
+						if err := w.Add(e.Name); err != nil {
+							s.logf("error watching directory %q: %v", e.Name, err)
+						}
+					}
+				}
+
+				select {
+				case changes <- e.Name:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	go func() {
+		defer s.wg.Done()
+		s.watchUpdates(ctx, changes)
+	}()
+
+	return nil
+}
+
+// watchUpdates receives the paths of changed files and directories, and
+// issues MPD database updates for their parent directories once no further
+// changes have occurred for the configured watch delay.
+func (s *Server) watchUpdates(ctx context.Context, changes <-chan string) {
+	delay := s.cfg.WatchDelay
+	if delay <= 0 {
+		delay = defaultWatchDelay
+	}
+
+	pending := make(map[string]struct{})
+
+	timer := time.NewTimer(delay)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case name := <-changes:
+			dir, ok := updatePath(s.cfg.MusicDirectory, name)
+			if !ok {
+				continue
+			}
+
+			pending[dir] = struct{}{}
+			timer.Reset(delay)
+		case <-timer.C:
+			paths := make([]string, 0, len(pending))
+			for p := range pending {
+				paths = append(paths, p)
+			}
+			pending = make(map[string]struct{})
+
+			for _, p := range coalescePaths(paths) {
+				if _, err := s.db.Update(p); err != nil {
+					s.logf("failed to update MPD database for %q: %v", p, err)
+					continue
+				}
+
+				if s.cfg.Verbose {
+					s.logf("updating MPD database: %q", p)
+				}
+			}
+		}
+	}
+}
+
+// updatePath determines the path, relative to the music directory, which
+// should be passed to MPD's update command when the file or directory with
+// the specified absolute name changes.  If name is not within the music
+// directory, it returns false.
+func updatePath(musicDirectory string, name string) (string, bool) {
+	rel, err := filepath.Rel(musicDirectory, filepath.Dir(name))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", false
+	}
+
+	// An empty path causes MPD to update its entire database
+	if rel == "." {
+		return "", true
+	}
+
+	return filepath.ToSlash(rel), true
+}
+
+// coalescePaths sorts and deduplicates a list of paths for MPD's update
+// command, removing any paths which are already covered by an update of one
+// of their parent directories.
+func coalescePaths(paths []string) []string {
+	sort.Strings(paths)
+
+	var out []string
+	for _, p := range paths {
+		covered := false
+		for _, o := range out {
+			if o == "" || p == o || strings.HasPrefix(p, o+"/") {
+				covered = true
+				break
+			}
+		}
+
+		if !covered {
+			out = append(out, p)
+		}
+	}
+
+	return out
+}
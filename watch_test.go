@@ -0,0 +1,124 @@
+package mpdsub
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_updatePath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		ok   bool
+	}{
+		{
+			name: "/srv/other/foo.mp3",
+		},
+		{
+			name: "/var/music/foo.mp3",
+			ok:   true,
+		},
+		{
+			name: "/var/music/Artist/Album/01.flac",
+			path: "Artist/Album",
+			ok:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, ok := updatePath("/var/music", tt.name)
+
+			if want, got := tt.ok, ok; want != got {
+				t.Fatalf("unexpected ok:\n- want: %v\n-  got: %v", want, got)
+			}
+
+			if want, got := tt.path, path; want != got {
+				t.Fatalf("unexpected path:\n- want: %q\n-  got: %q", want, got)
+			}
+		})
+	}
+}
+
+func Test_coalescePaths(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		out   []string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:  "root covers all",
+			paths: []string{"Artist/Album", "", "Other"},
+			out:   []string{""},
+		},
+		{
+			name:  "parents cover children",
+			paths: []string{"Artist/Album", "Artist", "Artist 2/Album", "Other/Album"},
+			out:   []string{"Artist", "Artist 2/Album", "Other/Album"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if want, got := tt.out, coalescePaths(tt.paths); !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected paths:\n- want: %q\n-  got: %q", want, got)
+			}
+		})
+	}
+}
+
+func TestServer_watchUpdates(t *testing.T) {
+	updateC := make(chan string, 10)
+	db := &memoryDatabase{
+		updateC: updateC,
+	}
+
+	s, err := newServer(db, nil, &Config{
+		MusicDirectory: "/var/music",
+		WatchDelay:     10 * time.Millisecond,
+		Logger:         log.New(ioutil.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan string)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.watchUpdates(ctx, changes)
+	}()
+
+	for _, c := range []string{
+		"/var/music/Artist/Album/01.flac",
+		"/var/music/Artist/Album/02.flac",
+		"/var/music/Other/Album/cover.jpg",
+	} {
+		changes <- c
+	}
+
+	var got []string
+	for i := 0; i < 2; i++ {
+		got = append(got, <-updateC)
+	}
+	sort.Strings(got)
+
+	if want := []string{"Artist/Album", "Other/Album"}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected updates:\n- want: %q\n-  got: %q", want, got)
+	}
+
+	cancel()
+	<-done
+}
@@ -3,7 +3,9 @@ package mpdsub
 import (
 	"encoding/xml"
 	"io"
+	"net"
 	"net/http"
+	"reflect"
 )
 
 const (
@@ -22,6 +24,8 @@ const (
 	codeGeneric          = 0
 	codeMissingParameter = 10
 	codeUnauthorized     = 40
+	codeNotAuthorized    = 50
+	codeNotFound         = 70
 )
 
 // errUnauthorized indicates an incorrect username or password.
@@ -42,6 +46,25 @@ func errMissingParameter(c *container) {
 	}
 }
 
+// errNotAuthorized indicates that the user is not authorized to perform
+// the requested operation.
+func errNotAuthorized(c *container) {
+	c.Status = statusFailed
+	c.Error = &subsonicError{
+		Code:    50,
+		Message: "User is not authorized for the given operation.",
+	}
+}
+
+// errNotFound indicates that the requested data was not found.
+func errNotFound(c *container) {
+	c.Status = statusFailed
+	c.Error = &subsonicError{
+		Code:    70,
+		Message: "The requested data was not found.",
+	}
+}
+
 // errGeneric indicates a generic error.
 func errGeneric(c *container) {
 	c.Status = statusFailed
@@ -51,6 +74,44 @@ func errGeneric(c *container) {
 	}
 }
 
+// Machine-readable details of errors, which distinguish the causes of
+// generic errors for clients and monitoring.
+const (
+	detailMPDError         = "mpd_error"
+	detailMPDUnreachable   = "mpd_unreachable"
+	detailFileMissing      = "file_missing"
+	detailTranscoderFailed = "transcoder_failed"
+	detailArtworkFailed    = "artwork_failed"
+)
+
+// errDetail produces a generic error with a machine-readable detail.
+func errDetail(detail string) func(c *container) {
+	return func(c *container) {
+		errGeneric(c)
+		c.Error.Detail = detail
+	}
+}
+
+// errMPD produces a generic error for a failed MPD command, detailing
+// whether MPD could not be reached or rejected the command.
+func errMPD(err error) func(c *container) {
+	if isUnreachable(err) {
+		return errDetail(detailMPDUnreachable)
+	}
+
+	return errDetail(detailMPDError)
+}
+
+// isUnreachable determines if err indicates that MPD could not be reached,
+// such as a refused connection, a timeout, or a closed connection.
+func isUnreachable(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
 const (
 	// Content-Type header name and XML content type.
 	contentType    = "Content-Type"
@@ -60,15 +121,21 @@ const (
 // writeXML writes an XML body to w after modifying it using the input function.
 func writeXML(w io.Writer, fn func(c *container)) {
 	c := &container{
-		XMLNS:   xmlNS,
-		Status:  statusOK,
-		Version: apiVersion,
+		XMLNS:        xmlNS,
+		Status:       statusOK,
+		Version:      apiVersion,
+		OpenSubsonic: true,
 	}
 
 	if fn != nil {
 		fn(c)
 	}
 
+	// Omit optional fields if requested by the client
+	if ff, ok := w.(*fieldFilter); ok {
+		omitFields(reflect.ValueOf(c), ff.omit)
+	}
+
 	// Set HTTP content type if available
 	if rw, ok := w.(http.ResponseWriter); ok {
 		rw.Header().Set(contentType, contentTypeXML)
@@ -87,13 +154,60 @@ type container struct {
 	Status  string `xml:"status,attr"`
 	Version string `xml:"version,attr"`
 
+	// Indicates support for OpenSubsonic, whose extensions are listed by
+	// getOpenSubsonicExtensions.
+	OpenSubsonic bool `xml:"openSubsonic,attr,omitempty"`
+
+	// Optional server branding, returned by informational endpoints.
+	ServerName string `xml:"serverName,attr,omitempty"`
+
+	// Set by ping when MPD is unavailable and cached data is being served.
+	Degraded bool `xml:"degraded,attr,omitempty"`
+
 	// Error, returned on failures.
 	Error *subsonicError
 
-	Indexes        *indexesContainer
-	License        *license
-	MusicDirectory *musicDirectoryContainer
-	MusicFolders   *musicFoldersContainer
+	Album                  *albumID3
+	AlbumInfo              *albumInfo
+	AlbumInfo2             *albumInfo2
+	AlbumList              *albumListContainer
+	AlbumList2             *albumList2Container
+	Artist                 *artistID3
+	ArtistInfo             *artistInfo
+	ArtistInfo2            *artistInfo2
+	Artists                *artistsContainer
+	Composer               *composer
+	Composers              *composersContainer
+	Genres                 *genresContainer
+	Indexes                *indexesContainer
+	JukeboxPlaylist        *jukeboxPlaylist
+	JukeboxStatus          *jukeboxStatus
+	License                *license
+	Lyrics                 *lyrics
+	LyricsList             *lyricsList
+	MusicDirectory         *musicDirectoryContainer
+	MusicDirectoryCheck    *musicDirectoryCheck
+	MusicFolders           *musicFoldersContainer
+	NowPlaying             *nowPlayingContainer
+	OpenSubsonicExtensions []openSubsonicExtension `xml:"openSubsonicExtensions"`
+	History                *historyContainer
+	Outputs                *outputsContainer
+	PlayQueue              *playQueue
+	PrefetchInfo           *prefetchInfoContainer
+	Playlists              *playlistsContainer
+	Playlist               *playlist
+	RandomSongs            *randomSongsContainer
+	SearchResult2          *searchResult2
+	SearchResult3          *searchResult3
+	SimilarSongs           *similarSongsContainer
+	SimilarSongs2          *similarSongs2Container
+	SongsByGenre           *songsByGenreContainer
+	Starred                *starredContainer
+	Starred2               *starred2Container
+	StreamToken            *streamTokenXML
+	TopSongs               *topSongsContainer
+	User                   *userContainer
+	Users                  *usersContainer
 }
 
 // A subsonicError contains a Subsonic error, with status code and message.
@@ -102,13 +216,18 @@ type subsonicError struct {
 
 	Code    int    `xml:"code,attr"`
 	Message string `xml:"message,attr"`
+
+	// Not part of Subsonic or OpenSubsonic, but harmless to other clients.
+	Detail string `xml:"detail,attr,omitempty"`
 }
 
 // A license is a Subsonic license structure.
 type license struct {
 	XMLName xml.Name `xml:"license,omitempty"`
 
-	Valid bool `xml:"valid,attr"`
+	Valid          bool   `xml:"valid,attr"`
+	Email          string `xml:"email,attr,omitempty"`
+	WelcomeMessage string `xml:"welcomeMessage,attr,omitempty"`
 }
 
 // A musicFoldersContainer contains a list of emulated Subsonic music folders.
@@ -118,6 +237,37 @@ type musicFoldersContainer struct {
 	MusicFolders []musicFolder `xml:"musicFolder"`
 }
 
+// A usersContainer contains a list of Subsonic users.
+type usersContainer struct {
+	XMLName xml.Name `xml:"users,omitempty"`
+
+	Users []userContainer `xml:"user"`
+}
+
+// A userContainer represents a Subsonic user and their roles.  Roles for
+// features which the Server does not support are always false.
+type userContainer struct {
+	XMLName xml.Name `xml:"user,omitempty"`
+
+	Username            string `xml:"username,attr"`
+	Email               string `xml:"email,attr,omitempty"`
+	ScrobblingEnabled   bool   `xml:"scrobblingEnabled,attr"`
+	AdminRole           bool   `xml:"adminRole,attr"`
+	SettingsRole        bool   `xml:"settingsRole,attr"`
+	DownloadRole        bool   `xml:"downloadRole,attr"`
+	UploadRole          bool   `xml:"uploadRole,attr"`
+	PlaylistRole        bool   `xml:"playlistRole,attr"`
+	CoverArtRole        bool   `xml:"coverArtRole,attr"`
+	CommentRole         bool   `xml:"commentRole,attr"`
+	PodcastRole         bool   `xml:"podcastRole,attr"`
+	StreamRole          bool   `xml:"streamRole,attr"`
+	JukeboxRole         bool   `xml:"jukeboxRole,attr"`
+	ShareRole           bool   `xml:"shareRole,attr"`
+	VideoConversionRole bool   `xml:"videoConversionRole,attr"`
+
+	Folders []int `xml:"folder"`
+}
+
 // A musicFolder represents an emulated Subsonic music folder.
 type musicFolder struct {
 	ID   int    `xml:"id,attr"`
@@ -128,8 +278,9 @@ type musicFolder struct {
 type indexesContainer struct {
 	XMLName xml.Name `xml:"indexes,omitempty"`
 
-	LastModified int64   `xml:"lastModified,attr"`
-	Indexes      []index `xml:"index"`
+	LastModified    int64   `xml:"lastModified,attr"`
+	IgnoredArticles string  `xml:"ignoredArticles,attr"`
+	Indexes         []index `xml:"index"`
 }
 
 // An index represents an alphabetical Subsonic index.
@@ -138,6 +289,9 @@ type index struct {
 
 	Name string `xml:"name,attr"`
 
+	// ArtistCount is only set when a skeleton of the indexes is requested.
+	ArtistCount int `xml:"artistCount,attr,omitempty"`
+
 	Artists []artist `xml:"artist"`
 }
 
@@ -145,8 +299,127 @@ type index struct {
 type artist struct {
 	XMLName xml.Name `xml:"artist,omitempty"`
 
-	Name string `xml:"name,attr"`
-	ID   string `xml:"id,attr"`
+	Name     string `xml:"name,attr"`
+	ID       string `xml:"id,attr"`
+	SortName string `xml:"sortName,attr,omitempty"`
+
+	// AlbumCount is only set when browsing by tags.
+	AlbumCount int `xml:"albumCount,attr,omitempty"`
+
+	// CoverArt is only set in search results.
+	CoverArt string `xml:"coverArt,attr,omitempty"`
+
+	// Starred is only set in a user's starred items.
+	Starred string `xml:"starred,attr,omitempty"`
+}
+
+// An artistsContainer contains the alphabetical indexes of artists returned
+// when browsing by tags.
+type artistsContainer struct {
+	XMLName xml.Name `xml:"artists,omitempty"`
+
+	IgnoredArticles string  `xml:"ignoredArticles,attr"`
+	Indexes         []index `xml:"index"`
+}
+
+// An artistID3 represents an artist and its albums, when browsing by tags.
+type artistID3 struct {
+	XMLName xml.Name `xml:"artist,omitempty"`
+
+	ID         string `xml:"id,attr"`
+	Name       string `xml:"name,attr"`
+	AlbumCount int    `xml:"albumCount,attr"`
+	Starred    string `xml:"starred,attr,omitempty"`
+
+	Albums []albumID3 `xml:"album"`
+}
+
+// An artistInfo contains information about an artist from an external
+// provider, when browsing by directory.
+type artistInfo struct {
+	XMLName xml.Name `xml:"artistInfo,omitempty"`
+
+	artistDetails
+}
+
+// An artistInfo2 contains information about an artist from an external
+// provider, when browsing by tags.
+type artistInfo2 struct {
+	XMLName xml.Name `xml:"artistInfo2,omitempty"`
+
+	artistDetails
+}
+
+// artistDetails are the contents of artistInfo and artistInfo2.
+type artistDetails struct {
+	Biography      string          `xml:"biography,omitempty"`
+	MusicBrainzID  string          `xml:"musicBrainzId,omitempty"`
+	LastFMURL      string          `xml:"lastFmUrl,omitempty"`
+	SmallImageURL  string          `xml:"smallImageUrl,omitempty"`
+	MediumImageURL string          `xml:"mediumImageUrl,omitempty"`
+	LargeImageURL  string          `xml:"largeImageUrl,omitempty"`
+	SimilarArtists []similarArtist `xml:"similarArtist"`
+}
+
+// A similarArtist is an artist similar to another.  Artists which are not
+// in MPD's database have no ID.
+type similarArtist struct {
+	ID         string `xml:"id,attr"`
+	Name       string `xml:"name,attr"`
+	AlbumCount int    `xml:"albumCount,attr,omitempty"`
+	CoverArt   string `xml:"coverArt,attr,omitempty"`
+}
+
+// An albumInfo contains information about an album from external providers,
+// when browsing by directory.
+type albumInfo struct {
+	XMLName xml.Name `xml:"albumInfo,omitempty"`
+
+	albumDetails
+}
+
+// An albumInfo2 contains information about an album from external
+// providers, when browsing by tags.
+type albumInfo2 struct {
+	XMLName xml.Name `xml:"albumInfo2,omitempty"`
+
+	albumDetails
+}
+
+// albumDetails are the contents of albumInfo and albumInfo2.
+type albumDetails struct {
+	Notes          string `xml:"notes,omitempty"`
+	MusicBrainzID  string `xml:"musicBrainzId,omitempty"`
+	LastFMURL      string `xml:"lastFmUrl,omitempty"`
+	SmallImageURL  string `xml:"smallImageUrl,omitempty"`
+	MediumImageURL string `xml:"mediumImageUrl,omitempty"`
+	LargeImageURL  string `xml:"largeImageUrl,omitempty"`
+}
+
+// An albumID3 represents an album, when browsing by tags.  Songs are only
+// populated when a single album is requested.
+type albumID3 struct {
+	XMLName xml.Name `xml:"album"`
+
+	ID        string `xml:"id,attr"`
+	Name      string `xml:"name,attr"`
+	Artist    string `xml:"artist,attr,omitempty"`
+	ArtistID  string `xml:"artistId,attr,omitempty"`
+	CoverArt  string `xml:"coverArt,attr"`
+	SongCount int    `xml:"songCount,attr"`
+	Duration  int    `xml:"duration,attr"`
+	Created   string `xml:"created,attr,omitempty"`
+	Year      int    `xml:"year,attr,omitempty"`
+	Genre     string `xml:"genre,attr,omitempty"`
+	Starred   string `xml:"starred,attr,omitempty"`
+	PlayCount int    `xml:"playCount,attr,omitempty"`
+
+	// OpenSubsonic extensions.
+	Played        string `xml:"played,attr,omitempty"`
+	SortName      string `xml:"sortName,attr,omitempty"`
+	DisplayArtist string `xml:"displayArtist,attr,omitempty"`
+
+	Songs []song `xml:"song"`
 }
 
 // A musicDirectoryContainer contains a list of emulated Subsonic music folders.
@@ -163,12 +436,409 @@ type musicDirectoryContainer struct {
 type child struct {
 	XMLName xml.Name `xml:"child,omitempty"`
 
-	ID       string `xml:"id,attr"`
-	Album    string `xml:"album,attr"`
-	Artist   string `xml:"artist,attr"`
-	CoverArt int    `xml:"coverArt,attr"`
-	Created  string `xml:"created,attr"`
-	IsDir    bool   `xml:"isDir,attr"`
-	Suffix   string `xml:"suffix,attr"`
-	Title    string `xml:"title,attr"`
+	ID        string `xml:"id,attr"`
+	Parent    string `xml:"parent,attr,omitempty"`
+	Album     string `xml:"album,attr"`
+	Artist    string `xml:"artist,attr"`
+	CoverArt  string `xml:"coverArt,attr"`
+	Created   string `xml:"created,attr"`
+	Genre     string `xml:"genre,attr,omitempty"`
+	IsDir     bool   `xml:"isDir,attr"`
+	Suffix    string `xml:"suffix,attr"`
+	Title     string `xml:"title,attr"`
+	Path      string `xml:"path,attr,omitempty"`
+	Duration  int    `xml:"duration,attr,omitempty"`
+	Track     int    `xml:"track,attr,omitempty"`
+	Year      int    `xml:"year,attr,omitempty"`
+	AlbumID   string `xml:"albumId,attr,omitempty"`
+	ArtistID  string `xml:"artistId,attr,omitempty"`
+	Starred   string `xml:"starred,attr,omitempty"`
+	PlayCount int    `xml:"playCount,attr,omitempty"`
+
+	// OpenSubsonic extensions.
+	Played             string        `xml:"played,attr,omitempty"`
+	SortName           string        `xml:"sortName,attr,omitempty"`
+	DisplayArtist      string        `xml:"displayArtist,attr,omitempty"`
+	DisplayAlbumArtist string        `xml:"displayAlbumArtist,attr,omitempty"`
+	DisplayComposer    string        `xml:"displayComposer,attr,omitempty"`
+	Comment            string        `xml:"comment,attr,omitempty"`
+	Moods              []string      `xml:"moods,omitempty"`
+	RecordLabels       []recordLabel `xml:"recordLabels,omitempty"`
+
+	// Not part of Subsonic or OpenSubsonic, but harmless to other clients.
+	CatalogNumber  string `xml:"catalogNumber,attr,omitempty"`
+	Work           string `xml:"work,attr,omitempty"`
+	Movement       string `xml:"movement,attr,omitempty"`
+	MovementNumber int    `xml:"movementNumber,attr,omitempty"`
+}
+
+// A composersContainer contains the composers of the songs in MPD's
+// database.
+type composersContainer struct {
+	XMLName xml.Name `xml:"composers,omitempty"`
+
+	Composers []composer `xml:"composer"`
+}
+
+// A composer is the composer of songs, and the works to which the songs
+// belong.  Works are only populated when a single composer is requested.
+type composer struct {
+	XMLName xml.Name `xml:"composer,omitempty"`
+
+	Name      string `xml:"name,attr"`
+	SongCount int    `xml:"songCount,attr"`
+	WorkCount int    `xml:"workCount,attr"`
+
+	Works []work `xml:"work"`
+}
+
+// A work is a composition and the songs which contain its movements.  Songs
+// without a work tag belong to a work without a name.
+type work struct {
+	XMLName xml.Name `xml:"work"`
+
+	Name      string `xml:"name,attr,omitempty"`
+	SongCount int    `xml:"songCount,attr"`
+
+	Songs []song `xml:"song"`
+}
+
+// A prefetchInfoContainer contains the artwork and lyrics available for a
+// batch of items.
+type prefetchInfoContainer struct {
+	XMLName xml.Name `xml:"prefetchInfo,omitempty"`
+
+	Items []prefetchItem `xml:"item"`
+}
+
+// A prefetchItem is the artwork and lyrics available for a file or
+// directory.  CoverArt is empty if the item has no artwork, or if its
+// artwork could not be retrieved, in which case Error details why.
+type prefetchItem struct {
+	ID           string `xml:"id,attr"`
+	CoverArt     string `xml:"coverArt,attr,omitempty"`
+	CoverArtETag string `xml:"coverArtETag,attr,omitempty"`
+	CoverArtSize int    `xml:"coverArtSize,attr,omitempty"`
+	Lyrics       bool   `xml:"lyrics,attr"`
+	Error        string `xml:"error,attr,omitempty"`
+}
+
+// A lyrics element contains the lyrics of a song.  Text is empty if no
+// lyrics were found.
+type lyrics struct {
+	XMLName xml.Name `xml:"lyrics,omitempty"`
+
+	Artist string `xml:"artist,attr,omitempty"`
+	Title  string `xml:"title,attr,omitempty"`
+	Text   string `xml:",chardata"`
+}
+
+// A lyricsList is an OpenSubsonic list of structured lyrics for a song.
+type lyricsList struct {
+	XMLName xml.Name `xml:"lyricsList,omitempty"`
+
+	StructuredLyrics []structuredLyrics `xml:"structuredLyrics"`
+}
+
+// A structuredLyrics is an OpenSubsonic set of lyrics split into lines,
+// which are timestamped if synced is true.
+type structuredLyrics struct {
+	DisplayArtist string       `xml:"displayArtist,attr,omitempty"`
+	DisplayTitle  string       `xml:"displayTitle,attr,omitempty"`
+	Lang          string       `xml:"lang,attr"`
+	Synced        bool         `xml:"synced,attr"`
+	Lines         []lyricsLine `xml:"line"`
+}
+
+// A lyricsLine is a line of structured lyrics.  Start is the time in
+// milliseconds at which the line is sung, and is only set for synced lyrics.
+type lyricsLine struct {
+	Start *int64 `xml:"start,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+// An openSubsonicExtension is an OpenSubsonic extension supported by the
+// server, and the versions of it which are supported.
+type openSubsonicExtension struct {
+	XMLName xml.Name `xml:"openSubsonicExtensions,omitempty"`
+
+	Name     string `xml:"name,attr"`
+	Versions []int  `xml:"versions"`
+}
+
+// A recordLabel is the record label which released an album or song.
+type recordLabel struct {
+	Name string `xml:"name,attr"`
+}
+
+// A playlistsContainer contains a list of Subsonic playlists.
+type playlistsContainer struct {
+	XMLName xml.Name `xml:"playlists,omitempty"`
+
+	Playlists []playlist `xml:"playlist"`
+}
+
+// A playlist represents a Subsonic playlist.  Entries are only populated
+// when a single playlist is requested.
+type playlist struct {
+	XMLName xml.Name `xml:"playlist,omitempty"`
+
+	ID        string `xml:"id,attr"`
+	Name      string `xml:"name,attr"`
+	Comment   string `xml:"comment,attr,omitempty"`
+	Owner     string `xml:"owner,attr,omitempty"`
+	Public    bool   `xml:"public,attr"`
+	SongCount int    `xml:"songCount,attr"`
+	Duration  int    `xml:"duration,attr"`
+	CoverArt  string `xml:"coverArt,attr,omitempty"`
+	Created   string `xml:"created,attr,omitempty"`
+	Changed   string `xml:"changed,attr,omitempty"`
+
+	Entries []entry `xml:"entry"`
+}
+
+// A playQueue is a play queue saved by a Subsonic client.
+type playQueue struct {
+	XMLName xml.Name `xml:"playQueue,omitempty"`
+
+	Current   string `xml:"current,attr,omitempty"`
+	Position  int64  `xml:"position,attr,omitempty"`
+	Username  string `xml:"username,attr"`
+	Changed   string `xml:"changed,attr"`
+	ChangedBy string `xml:"changedBy,attr"`
+
+	Entries []entry `xml:"entry"`
+}
+
+// An entry is a child which appears as a song in a playlist.
+type entry struct {
+	XMLName xml.Name `xml:"entry"`
+
+	child
+}
+
+// A song is a child which appears as a song in a list of songs.
+type song struct {
+	XMLName xml.Name `xml:"song"`
+
+	child
+}
+
+// An albumListContainer contains a list of albums, organized by directory.
+type albumListContainer struct {
+	XMLName xml.Name `xml:"albumList,omitempty"`
+
+	Albums []albumChild `xml:"album"`
+}
+
+// An albumList2Container contains a list of albums, organized by their tags.
+type albumList2Container struct {
+	XMLName xml.Name `xml:"albumList2,omitempty"`
+
+	Albums []albumID3 `xml:"album"`
+}
+
+// An albumChild is a child which appears as an album in a list of albums.
+type albumChild struct {
+	XMLName xml.Name `xml:"album"`
+
+	child
+}
+
+// A genresContainer contains the genres in MPD's database.
+type genresContainer struct {
+	XMLName xml.Name `xml:"genres,omitempty"`
+
+	Genres []genre `xml:"genre"`
+}
+
+// A genre is a genre and the number of songs and albums which have it.
+type genre struct {
+	Name       string `xml:",chardata"`
+	SongCount  int    `xml:"songCount,attr"`
+	AlbumCount int    `xml:"albumCount,attr"`
+}
+
+// A songsByGenreContainer contains a page of the songs in a genre.
+type songsByGenreContainer struct {
+	XMLName xml.Name `xml:"songsByGenre,omitempty"`
+
+	Songs []song `xml:"song"`
+}
+
+// A similarSongsContainer contains a list of songs similar to another song,
+// album, or artist.
+type similarSongsContainer struct {
+	XMLName xml.Name `xml:"similarSongs,omitempty"`
+
+	Songs []song `xml:"song"`
+}
+
+// A similarSongs2Container contains a list of songs similar to an artist,
+// when browsing by tags.
+type similarSongs2Container struct {
+	XMLName xml.Name `xml:"similarSongs2,omitempty"`
+
+	Songs []song `xml:"song"`
+}
+
+// A topSongsContainer contains the most played songs by an artist.
+type topSongsContainer struct {
+	XMLName xml.Name `xml:"topSongs,omitempty"`
+
+	Songs []song `xml:"song"`
+}
+
+// A randomSongsContainer contains a list of random songs.
+type randomSongsContainer struct {
+	XMLName xml.Name `xml:"randomSongs,omitempty"`
+
+	Songs []song `xml:"song"`
+}
+
+// A searchResult2 contains the artists, albums, and songs matching a
+// search, organized by directory.
+type searchResult2 struct {
+	XMLName xml.Name `xml:"searchResult2,omitempty"`
+
+	Artists []artist     `xml:"artist"`
+	Albums  []albumChild `xml:"album"`
+	Songs   []song       `xml:"song"`
+}
+
+// A searchResult3 contains the artists, albums, and songs matching a
+// search, organized by their tags.
+type searchResult3 struct {
+	XMLName xml.Name `xml:"searchResult3,omitempty"`
+
+	Artists []artistID3 `xml:"artist"`
+	Albums  []albumID3  `xml:"album"`
+	Songs   []song      `xml:"song"`
+}
+
+// A starredContainer contains the artists, albums, and songs starred by a
+// user, organized by directory.
+type starredContainer struct {
+	XMLName xml.Name `xml:"starred,omitempty"`
+
+	Artists []artist     `xml:"artist"`
+	Albums  []albumChild `xml:"album"`
+	Songs   []song       `xml:"song"`
+}
+
+// A starred2Container contains the artists, albums, and songs starred by a
+// user, organized by their tags.
+type starred2Container struct {
+	XMLName xml.Name `xml:"starred2,omitempty"`
+
+	Artists []artistID3 `xml:"artist"`
+	Albums  []albumID3  `xml:"album"`
+	Songs   []song      `xml:"song"`
+}
+
+// A jukeboxState is the status of the jukebox.
+type jukeboxState struct {
+	CurrentIndex int     `xml:"currentIndex,attr"`
+	Playing      bool    `xml:"playing,attr"`
+	Gain         float64 `xml:"gain,attr"`
+	Position     int     `xml:"position,attr"`
+}
+
+// A jukeboxStatus contains the status of the jukebox.
+type jukeboxStatus struct {
+	XMLName xml.Name `xml:"jukeboxStatus,omitempty"`
+
+	jukeboxState
+}
+
+// A jukeboxPlaylist contains the status of the jukebox and the songs in its
+// playlist.
+type jukeboxPlaylist struct {
+	XMLName xml.Name `xml:"jukeboxPlaylist,omitempty"`
+
+	jukeboxState
+
+	Entries []entry `xml:"entry"`
+}
+
+// An outputsContainer contains MPD's volume and a list of its audio outputs.
+// It is returned by the custom outputControl endpoint.
+type outputsContainer struct {
+	XMLName xml.Name `xml:"outputs,omitempty"`
+
+	Volume  int      `xml:"volume,attr"`
+	Outputs []output `xml:"output"`
+}
+
+// A musicDirectoryCheck reports files in MPD's database which could not be
+// found in the Server's music directory.  It is returned by the custom
+// checkMusicDirectory endpoint.
+type musicDirectoryCheck struct {
+	XMLName xml.Name `xml:"musicDirectoryCheck,omitempty"`
+
+	Directory string        `xml:"directory,attr"`
+	Checked   int           `xml:"checked,attr"`
+	OK        bool          `xml:"ok,attr"`
+	Message   string        `xml:"message,attr"`
+	Missing   []missingFile `xml:"missing"`
+}
+
+// A missingFile is a file in MPD's database which could not be found.
+type missingFile struct {
+	Path string `xml:"path,attr"`
+}
+
+// An output represents an MPD audio output.
+type output struct {
+	ID      int    `xml:"id,attr"`
+	Name    string `xml:"name,attr"`
+	Plugin  string `xml:"plugin,attr,omitempty"`
+	Enabled bool   `xml:"enabled,attr"`
+}
+
+// A nowPlayingContainer contains the songs currently being played by users.
+type nowPlayingContainer struct {
+	XMLName xml.Name `xml:"nowPlaying,omitempty"`
+
+	Entries []nowPlayingEntry `xml:"entry"`
+}
+
+// A nowPlayingEntry is a child which is currently being played by a user.
+type nowPlayingEntry struct {
+	XMLName xml.Name `xml:"entry"`
+
+	child
+
+	Username   string `xml:"username,attr"`
+	MinutesAgo int    `xml:"minutesAgo,attr"`
+	PlayerID   int    `xml:"playerId,attr"`
+	PlayerName string `xml:"playerName,attr,omitempty"`
+}
+
+// A historyContainer contains the songs recently streamed by a user.  It is
+// returned by the custom getHistory endpoint.
+type historyContainer struct {
+	XMLName xml.Name `xml:"history,omitempty"`
+
+	Entries []historyEntryXML `xml:"entry"`
+}
+
+// A historyEntryXML is a child which was streamed by a user.
+type historyEntryXML struct {
+	XMLName xml.Name `xml:"entry"`
+
+	child
+
+	Played string `xml:"played,attr"`
+	Client string `xml:"client,attr,omitempty"`
+}
+
+// A streamTokenXML is a single-use token for streaming a file.  It is
+// returned by the custom createStreamToken endpoint.
+type streamTokenXML struct {
+	XMLName xml.Name `xml:"streamToken,omitempty"`
+
+	Token   string `xml:"token,attr"`
+	Expires string `xml:"expires,attr"`
+	URL     string `xml:"url,attr"`
 }